@@ -0,0 +1,492 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bridgecmd
+
+import (
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"slices"
+	"sort"
+	"time"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+
+	"github.com/webmeshproj/webmesh/pkg/config"
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/system/firewall"
+	"github.com/webmeshproj/webmesh/pkg/meshnode"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+)
+
+// exportedPrefix is a prefix of one mesh advertised into another.
+type exportedPrefix struct {
+	// Prefix is the prefix in the source mesh.
+	Prefix netip.Prefix
+	// Advertised is the prefix advertised to the destination mesh. It differs
+	// from Prefix when the prefix is translated.
+	Advertised netip.Prefix
+}
+
+// Translated returns true if the prefix is advertised under a translated prefix.
+func (e exportedPrefix) Translated() bool {
+	return e.Prefix != e.Advertised
+}
+
+// meshView is a snapshot of the prefixes known to a bridged mesh.
+type meshView struct {
+	// networks are the networks of the mesh.
+	networks []netip.Prefix
+	// addrs are the addresses of the nodes in the mesh by node ID.
+	addrs map[string][]netip.Prefix
+	// routes are the routes in the mesh not owned by the bridge by node ID.
+	routes map[string][]netip.Prefix
+}
+
+// overlaps returns true if the prefix overlaps a network or route of the mesh.
+func (v meshView) overlaps(prefix netip.Prefix) bool {
+	for _, network := range v.networks {
+		if network.Overlaps(prefix) {
+			return true
+		}
+	}
+	for _, routes := range v.routes {
+		for _, route := range routes {
+			if route.Overlaps(prefix) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// planExport returns the prefixes the export advertises from one mesh into
+// another. Prefixes that overlap the destination mesh are translated into
+// prefixes allocated from the NAT pool, preferring the previous translations.
+// Overlapping prefixes that cannot be translated are returned as skipped.
+func planExport(export config.BridgeExportOptions, from, to meshView, previous map[netip.Prefix]netip.Prefix) (exported []exportedPrefix, skipped []netip.Prefix) {
+	var cidrs []netip.Prefix
+	for _, cidr := range export.CIDRs {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err == nil {
+			cidrs = append(cidrs, prefix.Masked())
+		}
+	}
+	if len(cidrs) == 0 {
+		cidrs = from.networks
+	}
+	within := func(prefix netip.Prefix) bool {
+		for _, cidr := range cidrs {
+			if cidr.Bits() <= prefix.Bits() && cidr.Contains(prefix.Addr()) {
+				return true
+			}
+		}
+		return false
+	}
+	var candidates []netip.Prefix
+	if len(export.Nodes) > 0 {
+		for _, node := range export.Nodes {
+			for _, addr := range from.addrs[node] {
+				if within(addr) {
+					candidates = append(candidates, addr)
+				}
+			}
+			if export.Routes {
+				candidates = append(candidates, from.routes[node]...)
+			}
+		}
+	} else {
+		candidates = append(candidates, cidrs...)
+		if export.Routes {
+			for _, routes := range from.routes {
+				for _, route := range routes {
+					if len(export.CIDRs) == 0 || within(route) {
+						candidates = append(candidates, route)
+					}
+				}
+			}
+		}
+	}
+	candidates = compactPrefixes(candidates)
+
+	pool, _ := netip.ParsePrefix(export.NATPool)
+	pool = pool.Masked()
+	var allocated []netip.Prefix
+	available := func(prefix netip.Prefix) bool {
+		if !pool.IsValid() || prefix.Bits() < pool.Bits() || !pool.Contains(prefix.Addr()) || to.overlaps(prefix) {
+			return false
+		}
+		for _, other := range allocated {
+			if other.Overlaps(prefix) {
+				return false
+			}
+		}
+		return true
+	}
+	var overlapping []netip.Prefix
+	for _, prefix := range candidates {
+		if !to.overlaps(prefix) {
+			exported = append(exported, exportedPrefix{Prefix: prefix, Advertised: prefix})
+			continue
+		}
+		if prev, ok := previous[prefix]; ok && available(prev) {
+			allocated = append(allocated, prev)
+			exported = append(exported, exportedPrefix{Prefix: prefix, Advertised: prev})
+			continue
+		}
+		overlapping = append(overlapping, prefix)
+	}
+	for _, prefix := range overlapping {
+		translated, ok := allocatePrefix(pool, prefix, available)
+		if !ok {
+			skipped = append(skipped, prefix)
+			continue
+		}
+		allocated = append(allocated, translated)
+		exported = append(exported, exportedPrefix{Prefix: prefix, Advertised: translated})
+	}
+	return exported, skipped
+}
+
+// allocatePrefix returns the first prefix in the pool of the same family and
+// size as the given prefix that is available.
+func allocatePrefix(pool, prefix netip.Prefix, available func(netip.Prefix) bool) (netip.Prefix, bool) {
+	if !pool.IsValid() || pool.Addr().Is4() != prefix.Addr().Is4() || prefix.Bits() < pool.Bits() {
+		return netip.Prefix{}, false
+	}
+	block := netip.PrefixFrom(pool.Addr(), prefix.Bits())
+	for pool.Contains(block.Addr()) {
+		if available(block) {
+			return block, true
+		}
+		next, ok := nextPrefix(block)
+		if !ok {
+			break
+		}
+		block = next
+	}
+	return netip.Prefix{}, false
+}
+
+// nextPrefix returns the prefix of the same size following the given prefix.
+func nextPrefix(prefix netip.Prefix) (netip.Prefix, bool) {
+	addr := prefix.Addr().AsSlice()
+	bit := prefix.Bits() - 1
+	if bit < 0 {
+		return netip.Prefix{}, false
+	}
+	i, carry := bit/8, byte(1)<<(7-bit%8)
+	for ; i >= 0; i-- {
+		sum := addr[i] + carry
+		overflow := sum < addr[i]
+		addr[i] = sum
+		if !overflow {
+			break
+		}
+		carry = 1
+	}
+	if i < 0 {
+		return netip.Prefix{}, false
+	}
+	next, _ := netip.AddrFromSlice(addr)
+	return netip.PrefixFrom(next, prefix.Bits()), true
+}
+
+// compactPrefixes sorts the prefixes and removes those contained in others.
+func compactPrefixes(prefixes []netip.Prefix) []netip.Prefix {
+	for i := range prefixes {
+		prefixes[i] = prefixes[i].Masked()
+	}
+	sort.Slice(prefixes, func(i, j int) bool {
+		if prefixes[i].Bits() != prefixes[j].Bits() {
+			return prefixes[i].Bits() < prefixes[j].Bits()
+		}
+		return prefixes[i].Addr().Less(prefixes[j].Addr())
+	})
+	var out []netip.Prefix
+Prefixes:
+	for _, prefix := range prefixes {
+		for _, other := range out {
+			if other.Contains(prefix.Addr()) {
+				continue Prefixes
+			}
+		}
+		out = append(out, prefix)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Addr().Less(out[j].Addr())
+	})
+	return out
+}
+
+// exporter advertises the prefixes of the bridged meshes into each other.
+type exporter struct {
+	conf    config.BridgeOptions
+	meshes  map[string]meshnode.Node
+	dnsPort int
+	// nat holds the translations made between each pair of meshes.
+	nat map[[2]string]map[netip.Prefix]netip.Prefix
+	// netmaps are the translations installed in the firewalls by the ID of
+	// the mesh they were installed for.
+	netmaps map[firewall.NetmapOptions]string
+	// advertised are the routes last advertised to each mesh.
+	advertised map[string][]string
+	log        *slog.Logger
+}
+
+func newExporter(ctx context.Context, conf config.BridgeOptions, meshes map[string]meshnode.Node, dnsPort int) *exporter {
+	return &exporter{
+		conf:       conf,
+		meshes:     meshes,
+		dnsPort:    dnsPort,
+		nat:        make(map[[2]string]map[netip.Prefix]netip.Prefix),
+		netmaps:    make(map[firewall.NetmapOptions]string),
+		advertised: make(map[string][]string),
+		log:        context.LoggerFrom(ctx).With("component", "bridge-exporter"),
+	}
+}
+
+// run refreshes the advertised routes whenever nodes or routes change in any
+// of the meshes until the context is canceled.
+func (e *exporter) run(ctx context.Context) error {
+	changes := make(chan struct{}, 1)
+	notify := func(_, _ []byte) {
+		select {
+		case changes <- struct{}{}:
+		default:
+		}
+	}
+	for meshID, meshConn := range e.meshes {
+		for _, prefix := range [][]byte{storage.RoutesPrefix, storage.NodesPrefix} {
+			cancel, err := meshConn.Storage().MeshStorage().Subscribe(ctx, prefix, notify)
+			if err != nil {
+				return fmt.Errorf("subscribe to changes in mesh %q: %w", meshID, err)
+			}
+			defer cancel()
+		}
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-changes:
+		}
+		// Let bursts of changes settle.
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(time.Second):
+		}
+		if err := e.refresh(ctx); err != nil {
+			e.log.Error("Failed to refresh bridged routes", slog.String("error", err.Error()))
+		}
+	}
+}
+
+// refresh advertises the current exports to every mesh.
+func (e *exporter) refresh(ctx context.Context) error {
+	views := make(map[string]meshView, len(e.meshes))
+	for meshID, meshConn := range e.meshes {
+		view, err := e.view(ctx, meshConn)
+		if err != nil {
+			return fmt.Errorf("load prefixes of mesh %q: %w", meshID, err)
+		}
+		views[meshID] = view
+	}
+	planned := make(map[firewall.NetmapOptions]string)
+	for meshID, meshConn := range e.meshes {
+		var routes []string
+		for otherID, other := range e.meshes {
+			if otherID == meshID {
+				continue
+			}
+			exports := e.exportsBetween(otherID, meshID)
+			if len(exports) == 0 {
+				routes = append(routes, other.Network().NetworkV6().String())
+				continue
+			}
+			pair := [2]string{otherID, meshID}
+			if e.nat[pair] == nil {
+				e.nat[pair] = make(map[netip.Prefix]netip.Prefix)
+			}
+			for _, export := range exports {
+				exported, skipped := planExport(export, views[otherID], views[meshID], e.nat[pair])
+				for _, prefix := range skipped {
+					e.log.Warn("Not exporting prefix that overlaps the destination mesh",
+						slog.String("from", otherID), slog.String("to", meshID), slog.String("prefix", prefix.String()))
+				}
+				for _, prefix := range exported {
+					routes = append(routes, prefix.Advertised.String())
+					if !prefix.Translated() {
+						continue
+					}
+					e.nat[pair][prefix.Prefix] = prefix.Advertised
+					netmap := firewall.NetmapOptions{
+						InInterface:  meshConn.Network().WireGuard().Name(),
+						OutInterface: other.Network().WireGuard().Name(),
+						Prefix:       prefix.Advertised,
+						To:           prefix.Prefix,
+					}
+					planned[netmap] = meshID
+					if err := e.ensureNetmap(ctx, meshID, netmap); err != nil {
+						return fmt.Errorf("translate %s from mesh %q: %w", prefix.Prefix, otherID, err)
+					}
+				}
+			}
+		}
+		sort.Strings(routes)
+		routes = slices.Compact(routes)
+		if slices.Equal(routes, e.advertised[meshID]) {
+			continue
+		}
+		e.log.Info("Broadcasting routes and features to mesh", slog.String("mesh-id", meshID), slog.Any("routes", routes))
+		if err := e.update(ctx, meshID, routes); err != nil {
+			return err
+		}
+		e.advertised[meshID] = routes
+	}
+	return e.pruneNetmaps(ctx, planned)
+}
+
+// exportsBetween returns the exports from one mesh to another.
+func (e *exporter) exportsBetween(from, to string) []config.BridgeExportOptions {
+	var out []config.BridgeExportOptions
+	for _, export := range e.conf.Exports {
+		if export.From == from && export.To == to {
+			out = append(out, export)
+		}
+	}
+	return out
+}
+
+// view loads the prefixes known to a mesh.
+func (e *exporter) view(ctx context.Context, meshConn meshnode.Node) (meshView, error) {
+	view := meshView{
+		addrs:  make(map[string][]netip.Prefix),
+		routes: make(map[string][]netip.Prefix),
+	}
+	for _, network := range []netip.Prefix{meshConn.Network().NetworkV4(), meshConn.Network().NetworkV6()} {
+		if network.IsValid() {
+			view.networks = append(view.networks, network)
+		}
+	}
+	peers, err := meshConn.Storage().MeshDB().Peers().List(ctx)
+	if err != nil {
+		return view, fmt.Errorf("list peers: %w", err)
+	}
+	for _, peer := range peers {
+		for _, addr := range []netip.Prefix{peer.PrivateAddrV4(), peer.PrivateAddrV6()} {
+			if addr.IsValid() {
+				view.addrs[peer.GetId()] = append(view.addrs[peer.GetId()], netip.PrefixFrom(addr.Addr(), addr.Addr().BitLen()))
+			}
+		}
+	}
+	routes, err := meshConn.Storage().MeshDB().Networking().ListRoutes(ctx)
+	if err != nil {
+		return view, fmt.Errorf("list routes: %w", err)
+	}
+	for _, route := range routes {
+		if route.GetNode() == meshConn.ID().String() {
+			// Don't export our own routes back out.
+			continue
+		}
+		view.routes[route.GetNode()] = append(view.routes[route.GetNode()], route.DestinationPrefixes()...)
+	}
+	return view, nil
+}
+
+// ensureNetmap installs the translation in the firewall of the mesh if it
+// is not already installed.
+func (e *exporter) ensureNetmap(ctx context.Context, meshID string, opts firewall.NetmapOptions) error {
+	if _, ok := e.netmaps[opts]; ok {
+		return nil
+	}
+	e.log.Info("Translating exported prefix",
+		slog.String("prefix", opts.To.String()), slog.String("advertised", opts.Prefix.String()))
+	if err := e.meshes[meshID].Network().Firewall().AddNetmap(ctx, opts); err != nil {
+		return err
+	}
+	e.netmaps[opts] = meshID
+	return nil
+}
+
+// pruneNetmaps removes the installed translations that are no longer planned.
+func (e *exporter) pruneNetmaps(ctx context.Context, planned map[firewall.NetmapOptions]string) error {
+	for opts, meshID := range e.netmaps {
+		if _, ok := planned[opts]; ok {
+			continue
+		}
+		e.log.Info("Removing translation of exported prefix",
+			slog.String("prefix", opts.To.String()), slog.String("advertised", opts.Prefix.String()))
+		if err := e.meshes[meshID].Network().Firewall().RemoveNetmap(ctx, opts); err != nil {
+			return fmt.Errorf("remove translation of %s in mesh %q: %w", opts.To, meshID, err)
+		}
+		delete(e.netmaps, opts)
+	}
+	return nil
+}
+
+// update sends the routes and features of the bridge to the leader of the mesh.
+func (e *exporter) update(ctx context.Context, meshID string, routes []string) error {
+	meshConn := e.meshes[meshID]
+	meshConfig := e.conf.Meshes[meshID]
+	req := &v1.UpdateRequest{
+		Id:     meshConn.ID().String(),
+		Routes: routes,
+		Features: meshConfig.Services.NewFeatureSet(
+			meshConn.Storage(),
+			meshConfig.Services.API.ListenPort(),
+		),
+	}
+	// If we are bridging DNS, add it to our feature set
+	if e.conf.MeshDNS.Enabled {
+		req.Features = append(req.Features, &v1.FeaturePort{
+			Feature: v1.Feature_MESH_DNS,
+			Port:    int32(e.dnsPort),
+		}, &v1.FeaturePort{
+			Feature: v1.Feature_FORWARD_MESH_DNS,
+			Port:    int32(e.dnsPort),
+		})
+	}
+	// Make retries configurable
+	var tries int
+	var maxTries int = 5
+	var err error
+	for tries <= maxTries {
+		if ctx.Err() != nil {
+			return fmt.Errorf("timed out updating mesh %q: %w", meshID, ctx.Err())
+		}
+		err = e.sendUpdate(ctx, meshConn, req)
+		if err == nil {
+			return nil
+		}
+		tries++
+		e.log.Error("Failed to send update RPC to mesh leader", slog.String("error", err.Error()))
+		time.Sleep(time.Second)
+	}
+	return fmt.Errorf("failed to send update RPC to mesh leader: %w", err)
+}
+
+func (e *exporter) sendUpdate(ctx context.Context, meshConn meshnode.Node, req *v1.UpdateRequest) error {
+	c, err := meshConn.DialLeader(ctx)
+	if err != nil {
+		return fmt.Errorf("dial mesh leader: %w", err)
+	}
+	defer c.Close()
+	_, err = v1.NewMembershipClient(c).Update(ctx, req)
+	return err
+}
@@ -0,0 +1,172 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bridgecmd
+
+import (
+	"net/netip"
+	"reflect"
+	"testing"
+
+	"github.com/webmeshproj/webmesh/pkg/config"
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/meshnet"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/system/firewall"
+	"github.com/webmeshproj/webmesh/pkg/meshnode"
+)
+
+func TestPlanExport(t *testing.T) {
+	p := netip.MustParsePrefix
+	from := meshView{
+		networks: []netip.Prefix{p("172.16.0.0/12")},
+		addrs: map[string][]netip.Prefix{
+			"node-a": {p("172.16.0.1/32")},
+			"node-b": {p("172.16.0.2/32")},
+		},
+		routes: map[string][]netip.Prefix{
+			"node-a": {p("10.10.0.0/24")},
+			"node-b": {p("192.168.1.0/24")},
+		},
+	}
+	to := meshView{
+		networks: []netip.Prefix{p("172.16.0.0/12")},
+		routes: map[string][]netip.Prefix{
+			"node-c": {p("10.20.0.0/16")},
+		},
+	}
+
+	tc := []struct {
+		name     string
+		export   config.BridgeExportOptions
+		previous map[netip.Prefix]netip.Prefix
+		exported []exportedPrefix
+		skipped  []netip.Prefix
+	}{
+		{
+			name:    "OverlappingWithoutPool",
+			export:  config.BridgeExportOptions{},
+			skipped: []netip.Prefix{p("172.16.0.0/12")},
+		},
+		{
+			name:   "OverlappingWithPool",
+			export: config.BridgeExportOptions{NATPool: "100.64.0.0/10"},
+			exported: []exportedPrefix{
+				{Prefix: p("172.16.0.0/12"), Advertised: p("100.64.0.0/12")},
+			},
+		},
+		{
+			name:   "NodesAndRoutes",
+			export: config.BridgeExportOptions{Nodes: []string{"node-a"}, Routes: true, NATPool: "100.64.0.0/10"},
+			exported: []exportedPrefix{
+				{Prefix: p("10.10.0.0/24"), Advertised: p("10.10.0.0/24")},
+				{Prefix: p("172.16.0.1/32"), Advertised: p("100.64.0.0/32")},
+			},
+		},
+		{
+			name:   "CIDRsFilterRoutes",
+			export: config.BridgeExportOptions{CIDRs: []string{"10.10.0.0/16"}, Routes: true},
+			exported: []exportedPrefix{
+				{Prefix: p("10.10.0.0/16"), Advertised: p("10.10.0.0/16")},
+			},
+		},
+		{
+			name:   "StableAllocation",
+			export: config.BridgeExportOptions{Nodes: []string{"node-a", "node-b"}, NATPool: "100.64.0.0/10"},
+			previous: map[netip.Prefix]netip.Prefix{
+				p("172.16.0.2/32"): p("100.64.0.0/32"),
+			},
+			exported: []exportedPrefix{
+				{Prefix: p("172.16.0.2/32"), Advertised: p("100.64.0.0/32")},
+				{Prefix: p("172.16.0.1/32"), Advertised: p("100.64.0.1/32")},
+			},
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			exported, skipped := planExport(tt.export, from, to, tt.previous)
+			if !reflect.DeepEqual(exported, tt.exported) {
+				t.Errorf("expected exported %v, got %v", tt.exported, exported)
+			}
+			if !reflect.DeepEqual(skipped, tt.skipped) {
+				t.Errorf("expected skipped %v, got %v", tt.skipped, skipped)
+			}
+		})
+	}
+}
+
+func TestPruneNetmaps(t *testing.T) {
+	ctx := context.Background()
+	p := netip.MustParsePrefix
+	fw := &recordingFirewall{installed: make(map[firewall.NetmapOptions]struct{})}
+	e := newExporter(ctx, config.BridgeOptions{}, map[string]meshnode.Node{
+		"mesh-a": &fakeMeshNode{network: &fakeNetwork{fw: fw}},
+	}, 0)
+	kept := firewall.NetmapOptions{InInterface: "wg-a", OutInterface: "wg-b", Prefix: p("100.64.0.0/32"), To: p("172.16.0.1/32")}
+	stale := firewall.NetmapOptions{InInterface: "wg-a", OutInterface: "wg-b", Prefix: p("100.64.0.1/32"), To: p("172.16.0.2/32")}
+	for _, opts := range []firewall.NetmapOptions{kept, stale} {
+		if err := e.ensureNetmap(ctx, "mesh-a", opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := e.pruneNetmaps(ctx, map[firewall.NetmapOptions]string{kept: "mesh-a"}); err != nil {
+		t.Fatal(err)
+	}
+	want := map[firewall.NetmapOptions]struct{}{kept: {}}
+	if !reflect.DeepEqual(fw.installed, want) {
+		t.Errorf("expected installed netmaps %v, got %v", want, fw.installed)
+	}
+	if !reflect.DeepEqual(e.netmaps, map[firewall.NetmapOptions]string{kept: "mesh-a"}) {
+		t.Errorf("expected only the planned netmap to be tracked, got %v", e.netmaps)
+	}
+
+	// Removed translations are installed again when planned again.
+	if err := e.ensureNetmap(ctx, "mesh-a", stale); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := fw.installed[stale]; !ok {
+		t.Error("expected the netmap to be installed again")
+	}
+}
+
+type fakeMeshNode struct {
+	meshnode.Node
+	network meshnet.Manager
+}
+
+func (n *fakeMeshNode) Network() meshnet.Manager { return n.network }
+
+type fakeNetwork struct {
+	meshnet.Manager
+	fw firewall.Firewall
+}
+
+func (n *fakeNetwork) Firewall() firewall.Firewall { return n.fw }
+
+type recordingFirewall struct {
+	firewall.Firewall
+	installed map[firewall.NetmapOptions]struct{}
+}
+
+func (fw *recordingFirewall) AddNetmap(ctx context.Context, opts firewall.NetmapOptions) error {
+	fw.installed[opts] = struct{}{}
+	return nil
+}
+
+func (fw *recordingFirewall) RemoveNetmap(ctx context.Context, opts firewall.NetmapOptions) error {
+	delete(fw.installed, opts)
+	return nil
+}
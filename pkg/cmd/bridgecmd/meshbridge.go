@@ -29,14 +29,10 @@ import (
 	"strconv"
 	"sync"
 	"syscall"
-	"time"
-
-	v1 "github.com/webmeshproj/api/go/v1"
 
 	"github.com/webmeshproj/webmesh/pkg/config"
 	"github.com/webmeshproj/webmesh/pkg/context"
 	"github.com/webmeshproj/webmesh/pkg/meshnet/system/dns"
-	"github.com/webmeshproj/webmesh/pkg/meshnet/transport"
 	"github.com/webmeshproj/webmesh/pkg/meshnode"
 	"github.com/webmeshproj/webmesh/pkg/services"
 	"github.com/webmeshproj/webmesh/pkg/services/meshdns"
@@ -53,8 +49,8 @@ func RunBridgeConnection(ctx context.Context, conf config.BridgeOptions) error {
 	meshes := make(map[string]meshnode.Node)
 	for meshID, meshConfig := range conf.Meshes {
 		id := meshID
-		// For now we only allow IPv6 on bridged meshes.
-		meshConfig.Mesh.DisableIPv4 = true
+		// IPv4 is only enabled on meshes that export IPv4 prefixes.
+		meshConfig.Mesh.DisableIPv4 = !conf.ExportsIPv4(id)
 		// We handle DNS on the bridge level only.
 		meshConfig.Mesh.MeshDNSAdvertisePort = 0
 		meshConfig.Mesh.UseMeshDNS = false
@@ -179,7 +175,10 @@ func RunBridgeConnection(ctx context.Context, conf config.BridgeOptions) error {
 			RequestTimeout:    conf.MeshDNS.RequestTimeout,
 			Forwarders:        conf.MeshDNS.Forwarders,
 			CacheSize:         conf.MeshDNS.CacheSize,
+			ServeStale:        conf.MeshDNS.ServeStale,
+			PrefetchThreshold: conf.MeshDNS.PrefetchThreshold,
 			DisableForwarding: false,
+			SplitHorizon:      conf.MeshDNS.SplitHorizon,
 		})
 		// Register each mesh to the server
 		for meshID, meshConn := range meshes {
@@ -189,6 +188,7 @@ func RunBridgeConnection(ctx context.Context, conf config.BridgeOptions) error {
 				MeshStorage:         meshConn.Storage(),
 				IPv6Only:            true,
 				SubscribeForwarders: false,
+				Interface:           meshConn.Network().WireGuard().Name(),
 			})
 			if err != nil {
 				return handleErr(fmt.Errorf("failed to register mesh %q with meshdns: %w", meshID, err))
@@ -226,67 +226,17 @@ func RunBridgeConnection(ctx context.Context, conf config.BridgeOptions) error {
 	}
 
 	// Last but not least, dial each mesh and tell them about the other meshes.
-	for meshID, meshConn := range meshes {
-		var toBroadcast []string
-		for otherID, otherMesh := range meshes {
-			if otherID != meshID {
-				toBroadcast = append(toBroadcast, otherMesh.Network().NetworkV6().String())
-			}
-			// TODO: Check if any unique non-internal routes are broadcasted
-			// by the other meshes and add them to this list (per a configuration flag).
-			// Will need to subscribe to route updates from the other meshes.
-			meshConfig := conf.Meshes[otherID]
-			req := &v1.UpdateRequest{
-				Id:     meshConn.ID().String(),
-				Routes: toBroadcast,
-				Features: meshConfig.Services.NewFeatureSet(
-					meshConn.Storage(),
-					meshConfig.Services.API.ListenPort(),
-				),
-			}
-			// If we are bridging DNS, add it to our feature set
-			if conf.MeshDNS.Enabled {
-				req.Features = append(req.Features, &v1.FeaturePort{
-					Feature: v1.Feature_MESH_DNS,
-					Port:    int32(dnsPort),
-				}, &v1.FeaturePort{
-					Feature: v1.Feature_FORWARD_MESH_DNS,
-					Port:    int32(dnsPort),
-				})
-			}
-			log.Info("Broadcasting routes and features to mesh", slog.String("mesh-id", meshID))
-			// Make retries configurable
-			var tries int
-			var maxTries int = 5
-			var err error
-		UpdateRetry:
-			for tries <= maxTries {
-				if ctx.Err() != nil {
-					return handleErr(fmt.Errorf("timed out starting up mesh bridge: %w", ctx.Err()))
-				}
-				var c transport.RPCClientConn
-				c, err = meshConn.DialLeader(ctx)
-				if err != nil {
-					tries++
-					log.Error("Failed to dial mesh leader", slog.String("error", err.Error()))
-					time.Sleep(time.Second)
-					continue
-				}
-				defer c.Close()
-				_, err = v1.NewMembershipClient(c).Update(ctx, req)
-				if err != nil {
-					tries++
-					log.Error("Failed to send update RPC to mesh leader", slog.String("error", err.Error()))
-					time.Sleep(time.Second)
-					continue
-				}
-				break UpdateRetry
-			}
-			if err != nil {
-				return handleErr(fmt.Errorf("failed to send update RPC to mesh leader: %w", err))
-			}
-		}
+	exporter := newExporter(ctx, conf, meshes, dnsPort)
+	if err := exporter.refresh(ctx); err != nil {
+		return handleErr(fmt.Errorf("failed to broadcast routes to meshes: %w", err))
 	}
+	exportCtx, cancelExports := context.WithCancel(ctx)
+	defer cancelExports()
+	go func() {
+		if err := exporter.run(exportCtx); err != nil {
+			log.Error("Failed to watch bridged meshes for changes", slog.String("error", err.Error()))
+		}
+	}()
 
 	// All done, wait for errors or a signal.
 	log.Info("Mesh bridge is ready")
@@ -0,0 +1,256 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ctlcmd
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/webmeshproj/webmesh/pkg/cmd/ctlcmd/manifests"
+	"github.com/webmeshproj/webmesh/pkg/meshnet"
+	"github.com/webmeshproj/webmesh/pkg/services/admin"
+)
+
+var (
+	aclTestCIDRs     []string
+	aclFiles         []string
+	aclRecursive     bool
+	aclMatrixOutput  string
+	aclMatrixDetails bool
+)
+
+func init() {
+	testFlags := aclTestCmd.Flags()
+	testFlags.StringArrayVar(&aclTestCIDRs, "cidr", nil, "destination prefix to check instead of the addresses and routes of DST, may be repeated")
+	testFlags.StringArrayVarP(&aclFiles, "filename", "f", nil, "NetworkACL manifests to evaluate in place of the stored ACLs with the same names")
+	testFlags.BoolVarP(&aclRecursive, "recursive", "R", false, "Read manifests from directories recursively")
+
+	matrixFlags := aclMatrixCmd.Flags()
+	matrixFlags.StringVarP(&aclMatrixOutput, "output", "o", "table", "Output format, one of table, dot or json")
+	matrixFlags.BoolVar(&aclMatrixDetails, "details", false, "Include the deciding ACL in table cells")
+	matrixFlags.StringArrayVarP(&aclFiles, "filename", "f", nil, "NetworkACL manifests to evaluate in place of the stored ACLs with the same names")
+	matrixFlags.BoolVarP(&aclRecursive, "recursive", "R", false, "Read manifests from directories recursively")
+	cobra.CheckErr(aclMatrixCmd.RegisterFlagCompletionFunc("output", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return []string{"table", "dot", "json"}, cobra.ShellCompDirectiveNoFileComp
+	}))
+
+	aclCmd.AddCommand(aclTestCmd)
+	aclCmd.AddCommand(aclMatrixCmd)
+	rootCmd.AddCommand(aclCmd)
+}
+
+var aclCmd = &cobra.Command{
+	Use:   "acl",
+	Short: "Simulate connectivity against the network ACLs in the mesh",
+	Long: `Simulate connectivity against the network ACLs in the mesh.
+
+Verdicts are computed the same way nodes compute their peers. Pass NetworkACL
+manifests with -f to see the effect of a policy change before applying it.`,
+}
+
+var aclTestCmd = &cobra.Command{
+	Use:   "test SRC DST",
+	Short: "Check whether a node may reach another node",
+	Long: `Check whether a node may reach another node.
+
+Without --cidr, the private addresses of DST and the prefixes of all of its
+routes are checked. A single denied route filters out the whole node. The
+output includes every action that was evaluated and the ACL that matched it.`,
+	Example: `  wmctl acl test node-a node-b
+  wmctl acl test node-a router --cidr 10.10.0.0/16
+  wmctl acl test node-a node-b -f acls/`,
+	Args:              cobra.ExactArgs(2),
+	ValidArgsFunction: completeNodes(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		req := admin.ACLSimulationRequest{
+			Source:      args[0],
+			Destination: args[1],
+			CIDRs:       aclTestCIDRs,
+		}
+		if err := addProposedACLs(&req); err != nil {
+			return err
+		}
+		conn, err := cliConfig.DialCurrent()
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		verdict, err := admin.TestNetworkACLs(cmd.Context(), conn, req)
+		if err != nil {
+			return err
+		}
+		return encodeJSONToStdout(cmd, verdict)
+	},
+}
+
+var aclMatrixCmd = &cobra.Command{
+	Use:   "matrix",
+	Short: "Show which nodes may reach each other",
+	Long: `Show which nodes may reach each other.
+
+The table output has a row for each source and a column for each destination.
+The dot output draws the edges of "wmctl get graph" colored by whether their
+source may reach their target, and requires the mesh API.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var req admin.ACLSimulationRequest
+		if err := addProposedACLs(&req); err != nil {
+			return err
+		}
+		conn, err := cliConfig.DialCurrent()
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		verdicts, err := admin.NetworkACLMatrix(cmd.Context(), conn, req)
+		if err != nil {
+			return err
+		}
+		switch aclMatrixOutput {
+		case "json":
+			return encodeJSONToStdout(cmd, verdicts)
+		case "table":
+			return writeACLMatrixTable(cmd.OutOrStdout(), verdicts, aclMatrixDetails)
+		case "dot":
+			client, closer, err := cliConfig.NewMeshClient()
+			if err != nil {
+				return err
+			}
+			defer closer.Close()
+			graph, err := client.GetMeshGraph(cmd.Context(), &emptypb.Empty{})
+			if err != nil {
+				return err
+			}
+			return writeACLMatrixDOT(cmd.OutOrStdout(), graph, verdicts)
+		}
+		return fmt.Errorf("unknown output format %q", aclMatrixOutput)
+	},
+}
+
+// addProposedACLs adds the NetworkACLs in the manifest files to the request.
+func addProposedACLs(req *admin.ACLSimulationRequest) error {
+	if len(aclFiles) == 0 {
+		return nil
+	}
+	resources, err := manifests.Load(aclFiles, aclRecursive)
+	if err != nil {
+		return err
+	}
+	for _, res := range resources {
+		if res.Kind != manifests.KindNetworkACL {
+			continue
+		}
+		if err := req.AddProposed(res.Object.(*v1.NetworkACL)); err != nil {
+			return fmt.Errorf("%s: %w", res.Source, err)
+		}
+	}
+	return nil
+}
+
+func writeACLMatrixTable(w io.Writer, verdicts []meshnet.ConnectivityVerdict, details bool) error {
+	nodes := matrixNodes(verdicts)
+	cells := make(map[[2]string]meshnet.ConnectivityVerdict, len(verdicts))
+	for _, verdict := range verdicts {
+		cells[[2]string{verdict.Source, verdict.Destination}] = verdict
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprint(tw, "SRC \\ DST")
+	for _, dst := range nodes {
+		fmt.Fprintf(tw, "\t%s", dst)
+	}
+	fmt.Fprintln(tw)
+	for _, src := range nodes {
+		fmt.Fprint(tw, src)
+		for _, dst := range nodes {
+			verdict, ok := cells[[2]string{src, dst}]
+			if !ok {
+				fmt.Fprint(tw, "\t-")
+				continue
+			}
+			cell := "deny"
+			if verdict.Allowed {
+				cell = "allow"
+			}
+			if details {
+				cell += " (" + decidingACL(verdict) + ")"
+			}
+			fmt.Fprintf(tw, "\t%s", cell)
+		}
+		fmt.Fprintln(tw)
+	}
+	return tw.Flush()
+}
+
+func writeACLMatrixDOT(w io.Writer, graph *v1.MeshGraph, verdicts []meshnet.ConnectivityVerdict) error {
+	cells := make(map[[2]string]meshnet.ConnectivityVerdict, len(verdicts))
+	for _, verdict := range verdicts {
+		cells[[2]string{verdict.Source, verdict.Destination}] = verdict
+	}
+	fmt.Fprintln(w, "strict digraph {")
+	for _, node := range graph.GetNodes() {
+		fmt.Fprintf(w, "  %q;\n", node)
+	}
+	for _, edge := range graph.GetEdges() {
+		verdict := cells[[2]string{edge.GetSource(), edge.GetTarget()}]
+		attrs := `color="red", style="dashed"`
+		if verdict.Allowed {
+			attrs = `color="green"`
+		}
+		fmt.Fprintf(w, "  %q -> %q [ %s, label=%q, weight=%d ];\n", edge.GetSource(), edge.GetTarget(), attrs, decidingACL(verdict), edge.GetWeight())
+	}
+	_, err := fmt.Fprintln(w, "}")
+	return err
+}
+
+// matrixNodes returns the sorted nodes in the verdicts.
+func matrixNodes(verdicts []meshnet.ConnectivityVerdict) []string {
+	seen := make(map[string]struct{})
+	for _, verdict := range verdicts {
+		seen[verdict.Source] = struct{}{}
+		seen[verdict.Destination] = struct{}{}
+	}
+	nodes := make([]string, 0, len(seen))
+	for node := range seen {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	return nodes
+}
+
+// decidingACL returns the name of the ACL that decided the verdict. A denied
+// verdict is decided by the trailing denied checks.
+func decidingACL(verdict meshnet.ConnectivityVerdict) string {
+	if verdict.Allowed {
+		for _, check := range verdict.Checks {
+			if check.Allowed {
+				return check.ACL
+			}
+		}
+	}
+	for i := len(verdict.Checks) - 1; i >= 0 && !verdict.Checks[i].Allowed; i-- {
+		if verdict.Checks[i].ACL != "" {
+			return verdict.Checks[i].ACL
+		}
+	}
+	return "default deny"
+}
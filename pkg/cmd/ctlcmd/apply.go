@@ -0,0 +1,111 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ctlcmd
+
+import (
+	"errors"
+
+	"github.com/spf13/cobra"
+
+	"github.com/webmeshproj/webmesh/pkg/cmd/ctlcmd/manifests"
+)
+
+var (
+	applyFiles     []string
+	applyRecursive bool
+	applySet       string
+	applyPrune     bool
+	applyDryRun    bool
+)
+
+func init() {
+	applyFlags := applyCmd.Flags()
+	applyFlags.StringArrayVarP(&applyFiles, "filename", "f", nil, "Manifest files or directories to apply")
+	applyFlags.BoolVarP(&applyRecursive, "recursive", "R", false, "Read manifests from directories recursively")
+	applyFlags.StringVar(&applySet, "managed-by", manifests.DefaultSet, "Name of the set of resources managed by these manifests")
+	applyFlags.BoolVar(&applyPrune, "prune", false, "Delete managed resources that are no longer in the manifests")
+	applyFlags.BoolVar(&applyDryRun, "dry-run", false, "Only show the plan and any drift without applying it")
+	cobra.CheckErr(applyCmd.MarkFlagRequired("filename"))
+	rootCmd.AddCommand(applyCmd)
+}
+
+var applyCmd = &cobra.Command{
+	Use:   "apply -f DIR",
+	Short: "Declaratively apply resource manifests to the mesh",
+	Long: `Declaratively apply resource manifests to the mesh.
+
+Manifests are YAML or JSON documents of the following form, where the spec uses
+the JSON field names of the API objects:
+
+  apiVersion: webmesh.io/v1
+  kind: NetworkACL
+  spec:
+    name: allow-admins
+    priority: 100
+    action: ACTION_ACCEPT
+    sourceNodes: ["group:admins"]
+    destinationCIDRs: ["0.0.0.0/0"]
+
+Supported kinds are Group, Role, RoleBinding, NetworkACL, Route and Edge. The
+current state is read from the admin API and a plan of creates (+), updates (~),
+deletes (-) and adoptions of matching existing resources (=) is shown before it
+is applied. Resources created by apply are recorded in a role named
+"` + manifests.InventoryPrefix + `<managed-by>", and only those are deleted when
+--prune is given. Managed resources that no longer match their manifests are
+reported as drift.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if applySet == "" {
+			return errors.New("--managed-by cannot be empty")
+		}
+		desired, err := manifests.Load(applyFiles, applyRecursive)
+		if err != nil {
+			return err
+		}
+		client, closer, err := cliConfig.NewAdminClient()
+		if err != nil {
+			return err
+		}
+		defer closer.Close()
+		state, err := manifests.FetchState(cmd.Context(), client)
+		if err != nil {
+			return err
+		}
+		plan := manifests.NewPlan(applySet, desired, state, applyPrune)
+		if plan.Empty() {
+			cmd.Println("No changes, the mesh matches the manifests")
+			return nil
+		}
+		for _, change := range plan.Changes {
+			cmd.Println(change.String())
+		}
+		for _, ref := range plan.Unpruned {
+			cmd.Println("  " + ref.String() + " is no longer in the manifests, use --prune to delete it")
+		}
+		if drift := plan.Drift(); len(drift) > 0 {
+			cmd.Printf("%d managed resource(s) drifted from their manifests\n", len(drift))
+		}
+		if applyDryRun {
+			return nil
+		}
+		if err := plan.Apply(cmd.Context(), client); err != nil {
+			return err
+		}
+		cmd.Printf("Applied %d change(s)\n", len(plan.Changes))
+		return nil
+	},
+}
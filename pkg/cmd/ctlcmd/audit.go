@@ -0,0 +1,106 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ctlcmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	auditsvc "github.com/webmeshproj/webmesh/pkg/services/audit"
+	"github.com/webmeshproj/webmesh/pkg/storage/audit"
+)
+
+var (
+	auditSince     string
+	auditUntil     string
+	auditPrincipal string
+	auditMethod    string
+	auditResource  string
+	auditLimit     int
+	auditJSONLines bool
+)
+
+func init() {
+	flags := auditCmd.Flags()
+	flags.StringVar(&auditSince, "since", "", "only show entries recorded after this time, as RFC3339 or a duration ago (e.g. 1h)")
+	flags.StringVar(&auditUntil, "until", "", "only show entries recorded before this time, as RFC3339 or a duration ago (e.g. 1h)")
+	flags.StringVar(&auditPrincipal, "principal", "", "only show entries made by or proxied for this identity")
+	flags.StringVar(&auditMethod, "method", "", "only show entries whose RPC method contains this string")
+	flags.StringVar(&auditResource, "resource", "", "only show entries whose resource starts with this string (e.g. roles/admin)")
+	flags.IntVar(&auditLimit, "limit", 0, "only show this many of the most recent entries")
+	flags.BoolVar(&auditJSONLines, "jsonl", false, "write entries as JSON lines for export")
+	rootCmd.AddCommand(auditCmd)
+}
+
+var auditCmd = &cobra.Command{
+	Use:   "audit",
+	Short: "Query the audit log of mesh mutations",
+	Long: `Query the audit log of mesh mutations.
+
+Every admin, membership and publish mutation is recorded with the caller, the
+identity it was proxied for, the RPC method, the resource and the keys it
+changed. Entries are shown oldest first. Use --jsonl to export them as JSON
+lines.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var f audit.Filter
+		var err error
+		if f.Since, err = parseAuditTime(auditSince); err != nil {
+			return fmt.Errorf("invalid --since: %w", err)
+		}
+		if f.Until, err = parseAuditTime(auditUntil); err != nil {
+			return fmt.Errorf("invalid --until: %w", err)
+		}
+		f.Principal = auditPrincipal
+		f.Method = auditMethod
+		f.Resource = auditResource
+		f.Limit = auditLimit
+		conn, err := cliConfig.DialCurrent()
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		entries, err := auditsvc.List(cmd.Context(), conn, f)
+		if err != nil {
+			return err
+		}
+		if !auditJSONLines {
+			return encodeJSONToStdout(cmd, entries)
+		}
+		enc := json.NewEncoder(cmd.OutOrStdout())
+		for _, entry := range entries {
+			if err := enc.Encode(entry); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+}
+
+// parseAuditTime parses an RFC3339 time or a duration before now.
+func parseAuditTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
@@ -0,0 +1,111 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ctlcmd
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/webmeshproj/webmesh/pkg/services/admin"
+)
+
+var authCanIAs string
+
+func init() {
+	authCanICmd.Flags().StringVar(&authCanIAs, "as", "", "node or user to evaluate the action for, defaults to the current user")
+	cobra.CheckErr(authCanICmd.RegisterFlagCompletionFunc("as", completeNodes(1)))
+
+	authCmd.AddCommand(authCanICmd)
+	authCmd.AddCommand(authWhoCanCmd)
+	rootCmd.AddCommand(authCmd)
+}
+
+var authCmd = &cobra.Command{
+	Use:   "auth",
+	Short: "Review access against the roles and rolebindings in the mesh",
+}
+
+var authCanICmd = &cobra.Command{
+	Use:   "can-i VERB RESOURCE [NAME]",
+	Short: "Check whether a node or user may perform an action",
+	Long: `Check whether a node or user may perform an action.
+
+The action is evaluated against the stored roles and rolebindings. The output
+includes the rolebinding and rule that granted access, or the rolebindings that
+were considered when access is denied.`,
+	Example: `  wmctl auth can-i put routes
+  wmctl auth can-i put routes my-route --as node-a
+  wmctl auth can-i get pubsub /team-a/events --as alice`,
+	Args:              cobra.RangeArgs(2, 3),
+	ValidArgsFunction: completeAuthAction,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		req := newRBACReviewRequest(args)
+		req.Subject = authCanIAs
+		conn, err := cliConfig.DialCurrent()
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		decision, err := admin.CanI(cmd.Context(), conn, req)
+		if err != nil {
+			return err
+		}
+		return encodeJSONToStdout(cmd, decision)
+	},
+}
+
+var authWhoCanCmd = &cobra.Command{
+	Use:   "who-can VERB RESOURCE [NAME]",
+	Short: "List the nodes and users that may perform an action",
+	Long: `List the nodes and users that may perform an action.
+
+Each subject is listed with the rolebinding and rule that grant it access. A
+subject named "*" stands for every node or user of its type.`,
+	Example: `  wmctl auth who-can put roles
+  wmctl auth who-can delete networkacls my-acl`,
+	Args:              cobra.RangeArgs(2, 3),
+	ValidArgsFunction: completeAuthAction,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		conn, err := cliConfig.DialCurrent()
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		decisions, err := admin.WhoCan(cmd.Context(), conn, newRBACReviewRequest(args))
+		if err != nil {
+			return err
+		}
+		return encodeJSONToStdout(cmd, decisions)
+	},
+}
+
+func newRBACReviewRequest(args []string) admin.RBACReviewRequest {
+	req := admin.RBACReviewRequest{Verb: args[0], Resource: args[1]}
+	if len(args) == 3 {
+		req.ResourceName = args[2]
+	}
+	return req
+}
+
+func completeAuthAction(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	switch len(args) {
+	case 0:
+		return []string{"get", "put", "delete", "*"}, cobra.ShellCompDirectiveNoFileComp
+	case 1:
+		return []string{"votes", "roles", "rolebindings", "groups", "networkacls", "datachannels", "pubsub", "observers", "routes", "edges", "*"}, cobra.ShellCompDirectiveNoFileComp
+	}
+	return nil, cobra.ShellCompDirectiveNoFileComp
+}
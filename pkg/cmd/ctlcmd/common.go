@@ -17,6 +17,7 @@ limitations under the License.
 package ctlcmd
 
 import (
+	"encoding/json"
 	"strings"
 
 	"github.com/spf13/cobra"
@@ -67,6 +68,15 @@ func encodeListToStdout[T proto.Message](cmd *cobra.Command, resp []T) error {
 	return nil
 }
 
+func encodeJSONToStdout(cmd *cobra.Command, v any) error {
+	out, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	cmd.Println(string(out))
+	return nil
+}
+
 func completeNodes(maxNodes int) func(*cobra.Command, []string, string) ([]string, cobra.ShellCompDirective) {
 	return func(cmd *cobra.Command, args []string, _ string) ([]string, cobra.ShellCompDirective) {
 		if maxNodes > 0 && len(args) >= maxNodes {
@@ -17,8 +17,15 @@ limitations under the License.
 package ctlcmd
 
 import (
+	"strings"
+
 	"github.com/spf13/cobra"
 	v1 "github.com/webmeshproj/api/go/v1"
+
+	"github.com/webmeshproj/webmesh/pkg/services/dnsrecords"
+	"github.com/webmeshproj/webmesh/pkg/services/federation"
+	"github.com/webmeshproj/webmesh/pkg/services/namespaces"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
 )
 
 var (
@@ -32,6 +39,9 @@ func init() {
 	deleteCmd.AddCommand(deleteGroupsCmd)
 	deleteCmd.AddCommand(deleteNetworkACLsCmd)
 	deleteCmd.AddCommand(deleteRoutesCmd)
+	deleteCmd.AddCommand(deleteNamespacesCmd)
+	deleteCmd.AddCommand(deleteFederationsCmd)
+	deleteCmd.AddCommand(deleteDNSRecordsCmd)
 
 	deleteEdgesCmd.Flags().StringVar(&getEdgeFrom, "from", "", "The source node ID")
 	deleteEdgesCmd.Flags().StringVar(&getEdgeTo, "to", "", "The destination node ID")
@@ -181,3 +191,68 @@ var deleteEdgesCmd = &cobra.Command{
 		return err
 	},
 }
+
+var deleteNamespacesCmd = &cobra.Command{
+	Use:     "namespaces",
+	Short:   "Delete key-value namespaces from the mesh, keeping their keys",
+	Aliases: []string{"namespace", "ns"},
+	Args:    cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		conn, err := cliConfig.DialCurrent()
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		for _, arg := range args {
+			if err := namespaces.Delete(cmd.Context(), conn, arg); err != nil {
+				return err
+			}
+			cmd.Println("Deleted namespace", arg)
+		}
+		return nil
+	},
+}
+
+var deleteFederationsCmd = &cobra.Command{
+	Use:     "federations",
+	Short:   "Stop federating with meshes and remove their routes",
+	Aliases: []string{"federation", "fed"},
+	Args:    cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		conn, err := cliConfig.DialCurrent()
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		for _, arg := range args {
+			if err := federation.Delete(cmd.Context(), conn, arg); err != nil {
+				return err
+			}
+			cmd.Println("Deleted federation", arg)
+		}
+		return nil
+	},
+}
+
+var deleteDNSRecordsCmd = &cobra.Command{
+	Use:   "dnsrecords NAME TYPE",
+	Short: "Delete a custom DNS record served by MeshDNS",
+	Long: `Delete a custom DNS record served by MeshDNS.
+
+Names are relative to the mesh domain unless they end in a dot.`,
+	Aliases: []string{"dnsrecord", "dns"},
+	Args:    cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		conn, err := cliConfig.DialCurrent()
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		ref := types.DNSRecordRef{Name: args[0], Type: strings.ToUpper(args[1])}
+		if err := dnsrecords.Delete(cmd.Context(), conn, ref); err != nil {
+			return err
+		}
+		cmd.Println("Deleted dns record", ref.Name, ref.Type)
+		return nil
+	},
+}
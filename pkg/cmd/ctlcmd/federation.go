@@ -0,0 +1,110 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ctlcmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/webmeshproj/webmesh/pkg/crypto"
+	"github.com/webmeshproj/webmesh/pkg/services/federation"
+)
+
+var (
+	federationBundleGateways []string
+	federationBundleNodes    []string
+	federationBundlePrefixes []string
+	federationBundleKeyFile  string
+	federationBundleCAFile   string
+	federationBundleExpires  time.Duration
+)
+
+func init() {
+	bundleFlags := federationBundleCmd.Flags()
+	bundleFlags.StringSliceVar(&federationBundleGateways, "gateway", nil, "ID of a node that peers with federated meshes, may be repeated")
+	bundleFlags.StringSliceVar(&federationBundleNodes, "node", nil, "ID of a node federated meshes may reference in network ACLs, may be repeated")
+	bundleFlags.StringSliceVar(&federationBundlePrefixes, "prefix", nil, "prefix reachable through the gateways, defaults to the mesh networks")
+	bundleFlags.StringVar(&federationBundleKeyFile, "key", "", "path to the private key used to sign the bundle (see wmctl genkey)")
+	bundleFlags.StringVar(&federationBundleCAFile, "ca", "", "path to a PEM encoded CA for the mesh APIs to include in the bundle")
+	bundleFlags.DurationVar(&federationBundleExpires, "expires", 0, "how long the bundle is valid for, zero never expires")
+	cobra.CheckErr(federationBundleCmd.MarkFlagRequired("gateway"))
+	cobra.CheckErr(federationBundleCmd.MarkFlagRequired("key"))
+
+	federationCmd.AddCommand(federationBundleCmd)
+	rootCmd.AddCommand(federationCmd)
+}
+
+var federationCmd = &cobra.Command{
+	Use:   "federation",
+	Short: "Manage trust bundles for federating with other meshes",
+}
+
+var federationBundleCmd = &cobra.Command{
+	Use:   "bundle",
+	Short: "Create a signed trust bundle describing this mesh",
+	Long: `Create a signed trust bundle describing this mesh.
+
+The bundle is written to stdout and should be handed to the administrators of
+the mesh to federate with, who import it with "wmctl put federations". The
+fingerprint of the signing key is written to stderr and must be shared with
+them through a separate trusted channel. Keep the signing key safe: updates to
+an imported bundle must be signed by the same key.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		key, err := crypto.DecodePrivateKeyFromFile(federationBundleKeyFile)
+		if err != nil {
+			return err
+		}
+		conn, err := cliConfig.DialCurrent()
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		bundle, err := federation.Bundle(cmd.Context(), conn, federation.BundleRequest{
+			Gateways: federationBundleGateways,
+			Nodes:    federationBundleNodes,
+			Prefixes: federationBundlePrefixes,
+		})
+		if err != nil {
+			return err
+		}
+		if federationBundleCAFile != "" {
+			ca, err := os.ReadFile(federationBundleCAFile)
+			if err != nil {
+				return err
+			}
+			bundle.CA = string(ca)
+		}
+		if federationBundleExpires < 0 {
+			return errors.New("expires must not be negative")
+		}
+		if federationBundleExpires > 0 {
+			bundle.Expires = time.Now().Add(federationBundleExpires).UTC().Truncate(time.Second)
+		}
+		if err := bundle.Sign(key); err != nil {
+			return err
+		}
+		fmt.Fprintln(cmd.ErrOrStderr(), "signing key fingerprint:", bundle.SigningKeyFingerprint())
+		// Make sure the bundle can be redirected to a file
+		cmd.SetOutput(cmd.OutOrStdout())
+		return encodeJSONToStdout(cmd, bundle)
+	},
+}
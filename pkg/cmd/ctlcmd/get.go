@@ -18,18 +18,29 @@ package ctlcmd
 
 import (
 	"fmt"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
 	v1 "github.com/webmeshproj/api/go/v1"
 	"google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/webmeshproj/webmesh/pkg/services/dnsrecords"
+	"github.com/webmeshproj/webmesh/pkg/services/federation"
+	"github.com/webmeshproj/webmesh/pkg/services/namespaces"
+	"github.com/webmeshproj/webmesh/pkg/services/presence"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
 )
 
 var (
-	getEdgeFrom string
-	getEdgeTo   string
+	getEdgeFrom      string
+	getEdgeTo        string
+	getNodesPresence bool
 )
 
 func init() {
+	getNodesCmd.Flags().BoolVar(&getNodesPresence, "presence", false, "Show the presence of the nodes instead")
 	getCmd.AddCommand(getNodesCmd)
 	getCmd.AddCommand(getGraphCmd)
 	getCmd.AddCommand(getRolesCmd)
@@ -37,6 +48,9 @@ func init() {
 	getCmd.AddCommand(getGroupsCmd)
 	getCmd.AddCommand(getNetworkACLsCmd)
 	getCmd.AddCommand(getRoutesCmd)
+	getCmd.AddCommand(getNamespacesCmd)
+	getCmd.AddCommand(getFederationsCmd)
+	getCmd.AddCommand(getDNSRecordsCmd)
 
 	getEdgesCmd.Flags().StringVar(&getEdgeFrom, "from", "", "The source node ID")
 	getEdgesCmd.Flags().StringVar(&getEdgeTo, "to", "", "The destination node ID")
@@ -59,6 +73,9 @@ var getNodesCmd = &cobra.Command{
 	Args:              cobra.MaximumNArgs(1),
 	ValidArgsFunction: completeNodes(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
+		if getNodesPresence {
+			return getNodesPresenceRun(cmd, args)
+		}
 		client, closer, err := cliConfig.NewMeshClient()
 		if err != nil {
 			return err
@@ -81,6 +98,59 @@ var getNodesCmd = &cobra.Command{
 	},
 }
 
+// nodePresence is the presence of a node as shown by get nodes.
+type nodePresence struct {
+	ID           string    `json:"id"`
+	Status       string    `json:"status"`
+	LastSeen     time.Time `json:"lastSeen,omitempty"`
+	AgentVersion string    `json:"agentVersion,omitempty"`
+	Endpoints    []string  `json:"endpoints,omitempty"`
+}
+
+func getNodesPresenceRun(cmd *cobra.Command, args []string) error {
+	client, closer, err := cliConfig.NewMeshClient()
+	if err != nil {
+		return err
+	}
+	defer closer.Close()
+	nodes, err := client.ListNodes(cmd.Context(), &emptypb.Empty{})
+	if err != nil {
+		return err
+	}
+	conn, err := cliConfig.DialCurrent()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	leases, err := presence.List(cmd.Context(), conn)
+	if err != nil {
+		return err
+	}
+	byID := make(map[types.NodeID]types.Presence, len(leases))
+	for _, lease := range leases {
+		byID[lease.NodeID] = lease
+	}
+	now := time.Now()
+	out := make([]nodePresence, 0, len(nodes.Nodes))
+	for _, node := range nodes.Nodes {
+		if len(args) == 1 && node.GetId() != args[0] {
+			continue
+		}
+		np := nodePresence{ID: node.GetId(), Status: "unknown"}
+		if lease, ok := byID[types.NodeID(node.GetId())]; ok {
+			np.Status = "offline"
+			if lease.Online(now) {
+				np.Status = "online"
+			}
+			np.LastSeen = lease.LastSeen
+			np.AgentVersion = lease.AgentVersion
+			np.Endpoints = lease.Endpoints
+		}
+		out = append(out, np)
+	}
+	return encodeJSONToStdout(cmd, out)
+}
+
 var getGraphCmd = &cobra.Command{
 	Use:   "graph",
 	Short: "Get the mesh graph in DOT format",
@@ -276,3 +346,91 @@ var getEdgesCmd = &cobra.Command{
 		return encodeListToStdout(cmd, resp.Items)
 	},
 }
+
+var getNamespacesCmd = &cobra.Command{
+	Use:     "namespaces [NAME]",
+	Short:   "Get key-value namespaces and their usage from the mesh",
+	Aliases: []string{"namespace", "ns"},
+	Args:    cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		conn, err := cliConfig.DialCurrent()
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		if len(args) == 1 {
+			resp, err := namespaces.Get(cmd.Context(), conn, args[0])
+			if err != nil {
+				return err
+			}
+			return encodeJSONToStdout(cmd, resp)
+		}
+		resp, err := namespaces.List(cmd.Context(), conn)
+		if err != nil {
+			return err
+		}
+		return encodeJSONToStdout(cmd, resp)
+	},
+}
+
+var getFederationsCmd = &cobra.Command{
+	Use:     "federations [DOMAIN]",
+	Short:   "Get the meshes federated with the mesh",
+	Aliases: []string{"federation", "fed"},
+	Args:    cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		conn, err := cliConfig.DialCurrent()
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		if len(args) == 1 {
+			resp, err := federation.Get(cmd.Context(), conn, args[0])
+			if err != nil {
+				return err
+			}
+			return encodeJSONToStdout(cmd, resp)
+		}
+		resp, err := federation.List(cmd.Context(), conn)
+		if err != nil {
+			return err
+		}
+		return encodeJSONToStdout(cmd, resp)
+	},
+}
+
+var getDNSRecordsCmd = &cobra.Command{
+	Use:   "dnsrecords [NAME] [TYPE]",
+	Short: "Get the custom DNS records served by MeshDNS",
+	Long: `Get the custom DNS records served by MeshDNS.
+
+Names are relative to the mesh domain unless they end in a dot.`,
+	Aliases: []string{"dnsrecord", "dns"},
+	Args:    cobra.MaximumNArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		conn, err := cliConfig.DialCurrent()
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		if len(args) == 2 {
+			resp, err := dnsrecords.Get(cmd.Context(), conn, types.DNSRecordRef{Name: args[0], Type: args[1]})
+			if err != nil {
+				return err
+			}
+			return encodeJSONToStdout(cmd, resp)
+		}
+		resp, err := dnsrecords.List(cmd.Context(), conn)
+		if err != nil {
+			return err
+		}
+		if len(args) == 1 {
+			// Match the name either fully qualified or relative to its zone.
+			name := strings.TrimSuffix(strings.ToLower(args[0]), ".")
+			resp = slices.DeleteFunc(resp, func(rec types.DNSRecord) bool {
+				return rec.Name != name && rec.Name != name+"."+rec.Zone
+			})
+		}
+		return encodeJSONToStdout(cmd, resp)
+	},
+}
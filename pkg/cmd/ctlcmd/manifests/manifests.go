@@ -0,0 +1,272 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package manifests contains declarative manifests for mesh resources and the
+// planning logic used by wmctl apply.
+package manifests
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"gopkg.in/yaml.v3"
+)
+
+// APIVersion is the API version of resource manifests.
+const APIVersion = "webmesh.io/v1"
+
+// Kind is the kind of a mesh resource.
+type Kind string
+
+const (
+	// KindGroup is the kind for groups.
+	KindGroup Kind = "Group"
+	// KindRole is the kind for roles.
+	KindRole Kind = "Role"
+	// KindRoleBinding is the kind for role bindings.
+	KindRoleBinding Kind = "RoleBinding"
+	// KindNetworkACL is the kind for network ACLs.
+	KindNetworkACL Kind = "NetworkACL"
+	// KindRoute is the kind for routes.
+	KindRoute Kind = "Route"
+	// KindEdge is the kind for edges.
+	KindEdge Kind = "Edge"
+)
+
+// Kinds are all supported kinds in the order they are applied. Deletes happen
+// in the reverse order.
+var Kinds = []Kind{KindGroup, KindRole, KindRoleBinding, KindNetworkACL, KindRoute, KindEdge}
+
+// ParseKind parses a kind case-insensitively. Plural and lower-case forms as
+// used by wmctl get are accepted.
+func ParseKind(s string) (Kind, error) {
+	name := strings.TrimSuffix(strings.ToLower(s), "s")
+	for _, kind := range Kinds {
+		if strings.ToLower(string(kind)) == name {
+			return kind, nil
+		}
+	}
+	return "", fmt.Errorf("unknown kind %q", s)
+}
+
+// Ref is a reference to a single resource.
+type Ref struct {
+	Kind Kind
+	Name string
+}
+
+// String returns the reference as kind/name.
+func (r Ref) String() string {
+	return string(r.Kind) + "/" + r.Name
+}
+
+// Resource is a single mesh resource read from a manifest.
+type Resource struct {
+	// Kind is the kind of the resource.
+	Kind Kind
+	// Object is the resource itself.
+	Object proto.Message
+	// Source is the file the resource was read from.
+	Source string
+}
+
+// Ref returns the reference for the resource.
+func (r Resource) Ref() Ref {
+	return Ref{Kind: r.Kind, Name: NameOf(r.Object)}
+}
+
+// NameOf returns the name of the given resource. Edges are named by their
+// source and target as "source:target".
+func NameOf(obj proto.Message) string {
+	switch v := obj.(type) {
+	case *v1.Group:
+		return v.GetName()
+	case *v1.Role:
+		return v.GetName()
+	case *v1.RoleBinding:
+		return v.GetName()
+	case *v1.NetworkACL:
+		return v.GetName()
+	case *v1.Route:
+		return v.GetName()
+	case *v1.MeshEdge:
+		return v.GetSource() + ":" + v.GetTarget()
+	}
+	return ""
+}
+
+// NewObject returns a new empty object for the given kind.
+func NewObject(kind Kind) (proto.Message, error) {
+	switch kind {
+	case KindGroup:
+		return &v1.Group{}, nil
+	case KindRole:
+		return &v1.Role{}, nil
+	case KindRoleBinding:
+		return &v1.RoleBinding{}, nil
+	case KindNetworkACL:
+		return &v1.NetworkACL{}, nil
+	case KindRoute:
+		return &v1.Route{}, nil
+	case KindEdge:
+		return &v1.MeshEdge{}, nil
+	}
+	return nil, fmt.Errorf("unknown kind %q", kind)
+}
+
+// manifest is the on-disk form of a resource. The spec uses the JSON names
+// of the API fields, e.g.:
+//
+//	apiVersion: webmesh.io/v1
+//	kind: NetworkACL
+//	spec:
+//	  name: allow-admins
+//	  priority: 100
+//	  action: ACTION_ACCEPT
+//	  sourceNodes: ["group:admins"]
+//	  destinationCIDRs: ["0.0.0.0/0"]
+type manifest struct {
+	APIVersion string         `yaml:"apiVersion" json:"apiVersion"`
+	Kind       string         `yaml:"kind" json:"kind"`
+	Spec       map[string]any `yaml:"spec" json:"spec"`
+}
+
+// Parse parses all resources from the given YAML or JSON data. YAML data may
+// contain multiple documents separated by "---". The source is used in errors.
+func Parse(data []byte, source string) ([]Resource, error) {
+	var out []Resource
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	for i := 0; ; i++ {
+		var m manifest
+		err := dec.Decode(&m)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: document %d: %w", source, i, err)
+		}
+		if m.APIVersion == "" && m.Kind == "" && m.Spec == nil {
+			// Empty document
+			continue
+		}
+		res, err := m.resource(source)
+		if err != nil {
+			return nil, fmt.Errorf("%s: document %d: %w", source, i, err)
+		}
+		out = append(out, res)
+	}
+	return out, nil
+}
+
+func (m manifest) resource(source string) (Resource, error) {
+	if m.APIVersion != APIVersion {
+		return Resource{}, fmt.Errorf("unsupported apiVersion %q", m.APIVersion)
+	}
+	kind, err := ParseKind(m.Kind)
+	if err != nil {
+		return Resource{}, err
+	}
+	obj, err := NewObject(kind)
+	if err != nil {
+		return Resource{}, err
+	}
+	spec, err := json.Marshal(m.Spec)
+	if err != nil {
+		return Resource{}, fmt.Errorf("encode spec: %w", err)
+	}
+	if err := protojson.Unmarshal(spec, obj); err != nil {
+		return Resource{}, fmt.Errorf("invalid %s spec: %w", kind, err)
+	}
+	res := Resource{Kind: kind, Object: obj, Source: source}
+	if kind == KindEdge {
+		if res.Object.(*v1.MeshEdge).GetSource() == "" || res.Object.(*v1.MeshEdge).GetTarget() == "" {
+			return Resource{}, fmt.Errorf("edge must have a source and target")
+		}
+	} else if NameOf(obj) == "" {
+		return Resource{}, fmt.Errorf("%s must have a name", kind)
+	}
+	if kind == KindRole && strings.HasPrefix(NameOf(obj), InventoryPrefix) {
+		return Resource{}, fmt.Errorf("role names starting with %q are reserved", InventoryPrefix)
+	}
+	return res, nil
+}
+
+// Load reads all resources from the given files and directories. Directories are
+// read for files ending in .yaml, .yml or .json, and descended into if recursive
+// is true. Resources defined more than once are an error.
+func Load(paths []string, recursive bool) ([]Resource, error) {
+	var files []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		err = filepath.WalkDir(path, func(p string, d os.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() {
+				if p != path && !recursive {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			switch strings.ToLower(filepath.Ext(p)) {
+			case ".yaml", ".yml", ".json":
+				files = append(files, p)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.Strings(files)
+	var out []Resource
+	seen := make(map[Ref]string)
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		resources, err := Parse(data, file)
+		if err != nil {
+			return nil, err
+		}
+		for _, res := range resources {
+			if prev, ok := seen[res.Ref()]; ok {
+				return nil, fmt.Errorf("%s is defined in both %s and %s", res.Ref(), prev, file)
+			}
+			seen[res.Ref()] = file
+			out = append(out, res)
+		}
+	}
+	return out, nil
+}
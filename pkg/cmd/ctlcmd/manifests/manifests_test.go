@@ -0,0 +1,183 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manifests
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+const testManifests = `
+apiVersion: webmesh.io/v1
+kind: Group
+spec:
+  name: admins
+  subjects:
+    - name: alice
+      type: SUBJECT_USER
+---
+apiVersion: webmesh.io/v1
+kind: networkacls
+spec:
+  name: allow-admins
+  priority: 100
+  action: ACTION_ACCEPT
+  sourceNodes: ["group:admins"]
+  destinationCIDRs: ["0.0.0.0/0"]
+---
+apiVersion: webmesh.io/v1
+kind: Edge
+spec:
+  source: a
+  target: b
+  weight: 5
+`
+
+func TestParse(t *testing.T) {
+	t.Parallel()
+	resources, err := Parse([]byte(testManifests), "test.yaml")
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if len(resources) != 3 {
+		t.Fatalf("expected 3 resources, got %d", len(resources))
+	}
+	want := []Ref{
+		{Kind: KindGroup, Name: "admins"},
+		{Kind: KindNetworkACL, Name: "allow-admins"},
+		{Kind: KindEdge, Name: "a:b"},
+	}
+	for i, res := range resources {
+		if res.Ref() != want[i] {
+			t.Errorf("resource %d = %s, want %s", i, res.Ref(), want[i])
+		}
+	}
+	acl := resources[1].Object.(*v1.NetworkACL)
+	if acl.GetPriority() != 100 || acl.GetAction() != v1.ACLAction_ACTION_ACCEPT {
+		t.Errorf("unexpected acl %v", acl)
+	}
+
+	invalid := map[string]string{
+		"UnknownKind":  "apiVersion: webmesh.io/v1\nkind: Node\nspec: {name: a}\n",
+		"BadVersion":   "apiVersion: v2\nkind: Group\nspec: {name: a}\n",
+		"MissingName":  "apiVersion: webmesh.io/v1\nkind: Group\nspec: {}\n",
+		"UnknownField": "apiVersion: webmesh.io/v1\nkind: Group\nspec: {name: a, color: blue}\n",
+		"Inventory":    "apiVersion: webmesh.io/v1\nkind: Role\nspec: {name: wmctl-managed-default}\n",
+	}
+	for name, data := range invalid {
+		if _, err := Parse([]byte(data), name); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "a.yaml"), []byte(testManifests), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "README.md"), []byte("# not a manifest"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	resources, err := Load([]string{dir}, false)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if len(resources) != 3 {
+		t.Fatalf("expected 3 resources, got %d", len(resources))
+	}
+	// Duplicates across files are an error.
+	if err := os.WriteFile(filepath.Join(dir, "b.json"), []byte(`{"apiVersion":"webmesh.io/v1","kind":"Group","spec":{"name":"admins"}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load([]string{dir}, false); err == nil {
+		t.Fatal("expected error for duplicate resources")
+	}
+}
+
+func TestPlan(t *testing.T) {
+	t.Parallel()
+	desired, err := Parse([]byte(testManifests), "test.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Live state: the group exists with different subjects, the edge matches,
+	// and a previously managed route was removed from the manifests.
+	inventory := Inventory{
+		{Kind: KindGroup, Name: "admins"}:     {},
+		{Kind: KindRoute, Name: "old-route"}:  {},
+		{Kind: KindRoute, Name: "gone-route"}: {},
+	}
+	state := State{
+		{Kind: KindGroup, Name: "admins"}:                 &v1.Group{Name: "admins"},
+		{Kind: KindEdge, Name: "a:b"}:                     proto.Clone(desired[2].Object),
+		{Kind: KindRoute, Name: "old-route"}:              &v1.Route{Name: "old-route"},
+		{Kind: KindRoute, Name: "manual"}:                 &v1.Route{Name: "manual"},
+		{Kind: KindRole, Name: InventoryName(DefaultSet)}: inventory.Role(DefaultSet),
+	}
+
+	plan := NewPlan(DefaultSet, desired, state, true)
+	got := map[Ref]Action{}
+	for _, c := range plan.Changes {
+		got[c.Ref] = c.Action
+	}
+	want := map[Ref]Action{
+		{Kind: KindGroup, Name: "admins"}:            ActionUpdate,
+		{Kind: KindNetworkACL, Name: "allow-admins"}: ActionCreate,
+		{Kind: KindEdge, Name: "a:b"}:                ActionAdopt,
+		{Kind: KindRoute, Name: "old-route"}:         ActionDelete,
+	}
+	if len(got) != len(want) {
+		t.Fatalf("plan = %v, want %v", plan.Changes, want)
+	}
+	for ref, action := range want {
+		if got[ref] != action {
+			t.Errorf("%s: action = %q, want %q", ref, got[ref], action)
+		}
+	}
+	if drift := plan.Drift(); len(drift) != 1 || drift[0].Ref.Name != "admins" {
+		t.Errorf("expected drift on the managed group only, got %v", drift)
+	}
+	if _, ok := plan.Inventory[Ref{Kind: KindRoute, Name: "old-route"}]; ok {
+		t.Error("pruned route should not remain in the inventory")
+	}
+	if _, ok := plan.Inventory[Ref{Kind: KindRoute, Name: "manual"}]; ok {
+		t.Error("unmanaged route should not be added to the inventory")
+	}
+
+	// Without pruning, the stale route is kept and stays managed.
+	plan = NewPlan(DefaultSet, desired, state, false)
+	for _, c := range plan.Changes {
+		if c.Action == ActionDelete {
+			t.Errorf("unexpected delete without prune: %s", c)
+		}
+	}
+	if len(plan.Unpruned) != 1 || plan.Unpruned[0].Name != "old-route" {
+		t.Errorf("expected old-route to be unpruned, got %v", plan.Unpruned)
+	}
+
+	// Round trip the inventory through its role.
+	inv := InventoryFromRole(plan.Inventory.Role(DefaultSet))
+	if len(inv) != len(plan.Inventory) {
+		t.Errorf("inventory round trip = %v, want %v", inv, plan.Inventory)
+	}
+}
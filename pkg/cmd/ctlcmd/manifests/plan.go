@@ -0,0 +1,427 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manifests
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+)
+
+// InventoryPrefix is the prefix of the roles used to record which resources are
+// managed by a set of manifests. The mesh API has no labels on most resources, so
+// the managed-by marker is kept in a role whose rules list the managed resource names
+// per kind. Inventory roles grant nothing unless bound and should not be bound.
+const InventoryPrefix = "wmctl-managed-"
+
+// DefaultSet is the default name of a set of managed resources.
+const DefaultSet = "default"
+
+// InventoryName returns the name of the inventory role for the given set.
+func InventoryName(set string) string {
+	return InventoryPrefix + set
+}
+
+var kindResources = map[Kind]v1.RuleResource{
+	KindGroup:       v1.RuleResource_RESOURCE_GROUPS,
+	KindRole:        v1.RuleResource_RESOURCE_ROLES,
+	KindRoleBinding: v1.RuleResource_RESOURCE_ROLE_BINDINGS,
+	KindNetworkACL:  v1.RuleResource_RESOURCE_NETWORK_ACLS,
+	KindRoute:       v1.RuleResource_RESOURCE_ROUTES,
+	KindEdge:        v1.RuleResource_RESOURCE_EDGES,
+}
+
+// Inventory is the set of resources managed by a set of manifests.
+type Inventory map[Ref]struct{}
+
+// InventoryFromRole returns the inventory recorded in the given role.
+func InventoryFromRole(role *v1.Role) Inventory {
+	inv := make(Inventory)
+	for _, rule := range role.GetRules() {
+		for _, resource := range rule.GetResources() {
+			for kind, r := range kindResources {
+				if r != resource {
+					continue
+				}
+				for _, name := range rule.GetResourceNames() {
+					inv[Ref{Kind: kind, Name: name}] = struct{}{}
+				}
+			}
+		}
+	}
+	return inv
+}
+
+// Role returns the inventory role for the given set, or nil if the inventory is empty.
+func (i Inventory) Role(set string) *v1.Role {
+	names := make(map[Kind][]string)
+	for ref := range i {
+		names[ref.Kind] = append(names[ref.Kind], ref.Name)
+	}
+	role := &v1.Role{Name: InventoryName(set)}
+	for _, kind := range Kinds {
+		if len(names[kind]) == 0 {
+			continue
+		}
+		sort.Strings(names[kind])
+		role.Rules = append(role.Rules, &v1.Rule{
+			Resources:     []v1.RuleResource{kindResources[kind]},
+			Verbs:         []v1.RuleVerb{v1.RuleVerb_VERB_GET},
+			ResourceNames: names[kind],
+		})
+	}
+	if len(role.Rules) == 0 {
+		return nil
+	}
+	return role
+}
+
+// State is the live state of the resources in the mesh.
+type State map[Ref]proto.Message
+
+// FetchState fetches the current state of all supported resources from the admin API.
+func FetchState(ctx context.Context, client v1.AdminClient) (State, error) {
+	state := make(State)
+	add := func(kind Kind, objs ...proto.Message) {
+		for _, obj := range objs {
+			state[Ref{Kind: kind, Name: NameOf(obj)}] = obj
+		}
+	}
+	groups, err := client.ListGroups(ctx, &emptypb.Empty{})
+	if err != nil {
+		return nil, fmt.Errorf("list groups: %w", err)
+	}
+	for _, obj := range groups.GetItems() {
+		add(KindGroup, obj)
+	}
+	roles, err := client.ListRoles(ctx, &emptypb.Empty{})
+	if err != nil {
+		return nil, fmt.Errorf("list roles: %w", err)
+	}
+	for _, obj := range roles.GetItems() {
+		add(KindRole, obj)
+	}
+	rbs, err := client.ListRoleBindings(ctx, &emptypb.Empty{})
+	if err != nil {
+		return nil, fmt.Errorf("list rolebindings: %w", err)
+	}
+	for _, obj := range rbs.GetItems() {
+		add(KindRoleBinding, obj)
+	}
+	acls, err := client.ListNetworkACLs(ctx, &emptypb.Empty{})
+	if err != nil {
+		return nil, fmt.Errorf("list networkacls: %w", err)
+	}
+	for _, obj := range acls.GetItems() {
+		add(KindNetworkACL, obj)
+	}
+	routes, err := client.ListRoutes(ctx, &emptypb.Empty{})
+	if err != nil {
+		return nil, fmt.Errorf("list routes: %w", err)
+	}
+	for _, obj := range routes.GetItems() {
+		add(KindRoute, obj)
+	}
+	edges, err := client.ListEdges(ctx, &emptypb.Empty{})
+	if err != nil {
+		return nil, fmt.Errorf("list edges: %w", err)
+	}
+	for _, obj := range edges.GetItems() {
+		add(KindEdge, obj)
+	}
+	return state, nil
+}
+
+// Inventory returns the inventory recorded in the state for the given set.
+func (s State) Inventory(set string) Inventory {
+	role, ok := s[Ref{Kind: KindRole, Name: InventoryName(set)}]
+	if !ok {
+		return make(Inventory)
+	}
+	return InventoryFromRole(role.(*v1.Role))
+}
+
+// Action is an action taken on a resource.
+type Action string
+
+const (
+	// ActionCreate creates a resource that does not exist.
+	ActionCreate Action = "create"
+	// ActionUpdate updates a resource that differs from its manifest.
+	ActionUpdate Action = "update"
+	// ActionDelete deletes a managed resource that was removed from the manifests.
+	ActionDelete Action = "delete"
+	// ActionAdopt starts managing an existing resource that matches its manifest.
+	ActionAdopt Action = "adopt"
+)
+
+// Change is a planned change to a single resource.
+type Change struct {
+	// Action is the action to take.
+	Action Action
+	// Ref is the resource being changed.
+	Ref Ref
+	// Desired is the desired state of the resource. It is nil for deletes.
+	Desired proto.Message
+	// Live is the current state of the resource. It is nil for creates.
+	Live proto.Message
+	// Fields are the names of the fields that differ for updates.
+	Fields []string
+	// Drift is true if the resource was already managed and no longer matches
+	// its manifest, or was deleted outside of apply.
+	Drift bool
+}
+
+// String returns a human readable one line summary of the change.
+func (c Change) String() string {
+	var sb strings.Builder
+	switch c.Action {
+	case ActionCreate:
+		sb.WriteString("+ ")
+	case ActionUpdate:
+		sb.WriteString("~ ")
+	case ActionDelete:
+		sb.WriteString("- ")
+	case ActionAdopt:
+		sb.WriteString("= ")
+	}
+	sb.WriteString(c.Ref.String())
+	if len(c.Fields) > 0 {
+		sb.WriteString(" (" + strings.Join(c.Fields, ", ") + ")")
+	}
+	if c.Drift {
+		sb.WriteString(" [drift]")
+	}
+	return sb.String()
+}
+
+// Plan is a set of changes that bring the mesh to the state described by a set
+// of manifests.
+type Plan struct {
+	// Set is the name of the managed set.
+	Set string
+	// Changes are the planned changes in the order they are applied.
+	Changes []Change
+	// Inventory is the inventory after the plan is applied.
+	Inventory Inventory
+	// Unpruned are managed resources that are no longer in the manifests but
+	// were kept because pruning is disabled.
+	Unpruned []Ref
+	previous Inventory
+}
+
+// NewPlan computes the changes needed to bring the given live state to the desired
+// resources. Managed resources missing from desired are deleted if prune is true.
+func NewPlan(set string, desired []Resource, state State, prune bool) *Plan {
+	previous := state.Inventory(set)
+	plan := &Plan{
+		Set:       set,
+		Inventory: make(Inventory),
+		previous:  previous,
+	}
+	wanted := make(map[Ref]struct{}, len(desired))
+	for _, kind := range Kinds {
+		for _, res := range desired {
+			if res.Kind != kind {
+				continue
+			}
+			ref := res.Ref()
+			wanted[ref] = struct{}{}
+			plan.Inventory[ref] = struct{}{}
+			_, managed := previous[ref]
+			live, ok := state[ref]
+			if !ok {
+				plan.Changes = append(plan.Changes, Change{
+					Action:  ActionCreate,
+					Ref:     ref,
+					Desired: res.Object,
+					Drift:   managed,
+				})
+				continue
+			}
+			fields := DiffFields(res.Object, live)
+			if len(fields) == 0 {
+				if !managed {
+					plan.Changes = append(plan.Changes, Change{Action: ActionAdopt, Ref: ref, Desired: res.Object, Live: live})
+				}
+				continue
+			}
+			plan.Changes = append(plan.Changes, Change{
+				Action:  ActionUpdate,
+				Ref:     ref,
+				Desired: res.Object,
+				Live:    live,
+				Fields:  fields,
+				Drift:   managed,
+			})
+		}
+	}
+	for i := len(Kinds) - 1; i >= 0; i-- {
+		var stale []Ref
+		for ref := range previous {
+			if _, ok := wanted[ref]; !ok && ref.Kind == Kinds[i] {
+				stale = append(stale, ref)
+			}
+		}
+		sort.Slice(stale, func(i, j int) bool { return stale[i].Name < stale[j].Name })
+		for _, ref := range stale {
+			live, ok := state[ref]
+			if !ok {
+				// Already gone, just drop it from the inventory.
+				continue
+			}
+			if !prune {
+				plan.Unpruned = append(plan.Unpruned, ref)
+				plan.Inventory[ref] = struct{}{}
+				continue
+			}
+			plan.Changes = append(plan.Changes, Change{Action: ActionDelete, Ref: ref, Live: live})
+		}
+	}
+	return plan
+}
+
+// Empty returns true if the plan makes no changes to resources or the inventory.
+func (p *Plan) Empty() bool {
+	if len(p.Changes) > 0 || len(p.Inventory) != len(p.previous) {
+		return false
+	}
+	for ref := range p.Inventory {
+		if _, ok := p.previous[ref]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// Drift returns the changes to resources that drifted from their manifests.
+func (p *Plan) Drift() []Change {
+	var out []Change
+	for _, c := range p.Changes {
+		if c.Drift {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// Apply applies the plan using the given client and records the new inventory.
+// Changes are applied in order and applying stops at the first error.
+func (p *Plan) Apply(ctx context.Context, client v1.AdminClient) error {
+	for _, c := range p.Changes {
+		var err error
+		switch c.Action {
+		case ActionCreate, ActionUpdate:
+			err = put(ctx, client, c.Desired)
+		case ActionDelete:
+			err = del(ctx, client, c.Live)
+		}
+		if err != nil {
+			return fmt.Errorf("%s %s: %w", c.Action, c.Ref, err)
+		}
+	}
+	if p.Empty() {
+		return nil
+	}
+	role := p.Inventory.Role(p.Set)
+	if role == nil {
+		_, err := client.DeleteRole(ctx, &v1.Role{Name: InventoryName(p.Set)})
+		if err != nil {
+			return fmt.Errorf("delete inventory: %w", err)
+		}
+		return nil
+	}
+	if _, err := client.PutRole(ctx, role); err != nil {
+		return fmt.Errorf("put inventory: %w", err)
+	}
+	return nil
+}
+
+func put(ctx context.Context, client v1.AdminClient, obj proto.Message) error {
+	var err error
+	switch v := obj.(type) {
+	case *v1.Group:
+		_, err = client.PutGroup(ctx, v)
+	case *v1.Role:
+		_, err = client.PutRole(ctx, v)
+	case *v1.RoleBinding:
+		_, err = client.PutRoleBinding(ctx, v)
+	case *v1.NetworkACL:
+		_, err = client.PutNetworkACL(ctx, v)
+	case *v1.Route:
+		_, err = client.PutRoute(ctx, v)
+	case *v1.MeshEdge:
+		_, err = client.PutEdge(ctx, v)
+	default:
+		err = fmt.Errorf("unsupported resource %T", obj)
+	}
+	return err
+}
+
+func del(ctx context.Context, client v1.AdminClient, obj proto.Message) error {
+	var err error
+	switch v := obj.(type) {
+	case *v1.Group:
+		_, err = client.DeleteGroup(ctx, v)
+	case *v1.Role:
+		_, err = client.DeleteRole(ctx, v)
+	case *v1.RoleBinding:
+		_, err = client.DeleteRoleBinding(ctx, v)
+	case *v1.NetworkACL:
+		_, err = client.DeleteNetworkACL(ctx, v)
+	case *v1.Route:
+		_, err = client.DeleteRoute(ctx, v)
+	case *v1.MeshEdge:
+		_, err = client.DeleteEdge(ctx, v)
+	default:
+		err = fmt.Errorf("unsupported resource %T", obj)
+	}
+	return err
+}
+
+// DiffFields returns the JSON names of the top-level fields that differ between
+// the two messages of the same type.
+func DiffFields(a, b proto.Message) []string {
+	if proto.Equal(a, b) {
+		return nil
+	}
+	am, bm := a.ProtoReflect(), b.ProtoReflect()
+	var out []string
+	fields := am.Descriptor().Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		if !proto.Equal(onlyField(am, fd), onlyField(bm, fd)) {
+			out = append(out, fd.JSONName())
+		}
+	}
+	return out
+}
+
+func onlyField(m protoreflect.Message, fd protoreflect.FieldDescriptor) proto.Message {
+	out := m.Type().New()
+	if m.Has(fd) {
+		out.Set(fd, m.Get(fd))
+	}
+	return out.Interface()
+}
@@ -0,0 +1,331 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ctlcmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	cmdconfig "github.com/webmeshproj/webmesh/pkg/cmd/ctlcmd/config"
+	"github.com/webmeshproj/webmesh/pkg/cmd/daemoncmd"
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/crypto"
+)
+
+var (
+	profilesDaemonAddr     string
+	profilesNamespace      string
+	profilesTokenFile      string
+	profilesPassphraseFile string
+	profilesExportOutput   string
+	profilesExportEncrypt  bool
+	profilesExportContext  bool
+	profilesExportAuto     bool
+	profilesExportIDKey    string
+	profilesImportID       string
+	profilesImportConnect  bool
+)
+
+// profilesPassphraseEnv is the environment variable read for bundle passphrases.
+const profilesPassphraseEnv = "WMCTL_BUNDLE_PASSPHRASE"
+
+func init() {
+	profilesFlags := profilesCmd.PersistentFlags()
+	profilesFlags.StringVar(&profilesDaemonAddr, "daemon-addr", daemoncmd.DefaultDaemonSocket(), "Address of the app daemon")
+	profilesFlags.StringVar(&profilesNamespace, "namespace", "", "Profile namespace to use on the app daemon")
+	profilesFlags.StringVar(&profilesTokenFile, "daemon-token-file", "", "File containing a bearer token for the app daemon")
+	profilesFlags.StringVar(&profilesPassphraseFile, "passphrase-file", "", "File containing the bundle passphrase, defaults to $"+profilesPassphraseEnv)
+
+	exportFlags := profilesExportCmd.Flags()
+	exportFlags.StringVarP(&profilesExportOutput, "output", "o", "-", "File to write the bundle to, - for stdout")
+	exportFlags.BoolVar(&profilesExportEncrypt, "encrypt", false, "Encrypt the bundle with a passphrase")
+	exportFlags.BoolVar(&profilesExportContext, "from-context", false, "Build the profile from the current wmctl context instead of the daemon")
+	exportFlags.BoolVar(&profilesExportAuto, "auto-connect", false, "Mark the profile to be connected when the daemon starts")
+	exportFlags.StringVar(&profilesExportIDKey, "id-key-file", "", "File containing the private key to embed in profiles using ID authentication")
+
+	importFlags := profilesImportCmd.Flags()
+	importFlags.StringVar(&profilesImportID, "id", "", "Import the profile under a different ID")
+	importFlags.BoolVar(&profilesImportConnect, "connect", false, "Connect the profile after importing it")
+
+	profilesCmd.AddCommand(profilesExportCmd)
+	profilesCmd.AddCommand(profilesImportCmd)
+	rootCmd.AddCommand(profilesCmd)
+}
+
+var profilesCmd = &cobra.Command{
+	Use:     "profiles",
+	Short:   "Manage connection profiles on the app daemon",
+	Aliases: []string{"profile"},
+}
+
+var profilesExportCmd = &cobra.Command{
+	Use:   "export <id>",
+	Short: "Export a connection profile and its credentials to a bundle",
+	Long: `Export a connection profile and its credentials to a single bundle file.
+
+By default the profile is read from the app daemon. With --from-context the profile
+is built from the cluster and user of the current wmctl context instead, which
+allows handing out onboarding bundles without running a daemon.
+
+The app daemon does not return private keys, so profiles using ID authentication
+embed the key from --id-key-file. When the profile is connected, the key must
+match the node ID the daemon connected with.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var profile daemoncmd.Profile
+		var err error
+		if profilesExportContext {
+			profile, err = profileFromContext(args[0])
+		} else {
+			profile, err = profileFromDaemon(cmd.Context(), args[0])
+		}
+		if err != nil {
+			return err
+		}
+		if profilesExportAuto {
+			if profile.Metadata == nil {
+				profile.Metadata = &structpb.Struct{Fields: map[string]*structpb.Value{}}
+			}
+			profile.Metadata.Fields[daemoncmd.AutoConnectMetadataKey] = structpb.NewBoolValue(true)
+		}
+		bundle, err := daemoncmd.NewProfileBundle(profile)
+		if err != nil {
+			return err
+		}
+		if profilesExportEncrypt {
+			passphrase, err := readPassphrase()
+			if err != nil {
+				return err
+			}
+			if err := bundle.Encrypt(passphrase); err != nil {
+				return err
+			}
+		}
+		if profilesExportOutput == "-" {
+			_, err = bundle.WriteTo(cmd.OutOrStdout())
+			return err
+		}
+		f, err := os.OpenFile(profilesExportOutput, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if _, err := bundle.WriteTo(f); err != nil {
+			return err
+		}
+		cmd.PrintErrln("Exported profile", profile.GetId(), "to", profilesExportOutput)
+		return nil
+	},
+}
+
+var profilesImportCmd = &cobra.Command{
+	Use:   "import <file>",
+	Short: "Import a connection profile bundle into the app daemon",
+	Long: `Import a connection profile bundle into the app daemon. Use - to read the
+bundle from stdin. Encrypted bundles are decrypted with the passphrase from
+--passphrase-file or $` + profilesPassphraseEnv + `.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var in io.Reader = cmd.InOrStdin()
+		if args[0] != "-" {
+			f, err := os.Open(args[0])
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			in = f
+		}
+		bundle, err := daemoncmd.ReadProfileBundle(in)
+		if err != nil {
+			return err
+		}
+		if bundle.Encrypted() {
+			passphrase, err := readPassphrase()
+			if err != nil {
+				return err
+			}
+			if err := bundle.Decrypt(passphrase); err != nil {
+				return err
+			}
+		}
+		profile, err := bundle.Unpack()
+		if err != nil {
+			return err
+		}
+		if profilesImportID != "" {
+			profile.Id = profilesImportID
+		}
+		ctx, client, closer, err := newDaemonClient(cmd.Context())
+		if err != nil {
+			return err
+		}
+		defer closer.Close()
+		resp, err := client.PutConnection(ctx, profile.PutConnectionRequest)
+		if err != nil {
+			return err
+		}
+		cmd.Println("Imported profile", resp.GetId())
+		if !profilesImportConnect {
+			return nil
+		}
+		conn, err := client.Connect(ctx, &v1.ConnectRequest{Id: resp.GetId()})
+		if err != nil {
+			return err
+		}
+		return encodeToStdout(cmd, conn)
+	},
+}
+
+func newDaemonClient(ctx context.Context) (context.Context, v1.AppDaemonClient, io.Closer, error) {
+	var token string
+	if profilesTokenFile != "" {
+		data, err := os.ReadFile(profilesTokenFile)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("read daemon token: %w", err)
+		}
+		token = strings.TrimSpace(string(data))
+	}
+	c, err := daemoncmd.Dial(ctx, profilesDaemonAddr)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("dial app daemon: %w", err)
+	}
+	return daemoncmd.NewClientContext(ctx, profilesNamespace, token), v1.NewAppDaemonClient(c), c, nil
+}
+
+func readPassphrase() ([]byte, error) {
+	if profilesPassphraseFile != "" {
+		data, err := os.ReadFile(profilesPassphraseFile)
+		if err != nil {
+			return nil, fmt.Errorf("read passphrase: %w", err)
+		}
+		return []byte(strings.TrimRight(string(data), "\r\n")), nil
+	}
+	if passphrase := os.Getenv(profilesPassphraseEnv); passphrase != "" {
+		return []byte(passphrase), nil
+	}
+	return nil, errors.New("a passphrase is required, use --passphrase-file or $" + profilesPassphraseEnv)
+}
+
+func profileFromDaemon(ctx context.Context, id string) (daemoncmd.Profile, error) {
+	ctx, client, closer, err := newDaemonClient(ctx)
+	if err != nil {
+		return daemoncmd.Profile{}, err
+	}
+	defer closer.Close()
+	resp, err := client.GetConnection(ctx, &v1.GetConnectionRequest{Id: id})
+	if err != nil {
+		return daemoncmd.Profile{}, err
+	}
+	profile := daemoncmd.Profile{PutConnectionRequest: &v1.PutConnectionRequest{
+		Id:         id,
+		Parameters: resp.GetParameters(),
+		Metadata:   resp.GetMetadata(),
+	}}
+	if resp.GetParameters().GetAuthMethod() != v1.NetworkAuthMethod_ID {
+		return profile, nil
+	}
+	// The daemon does not return the key the profile connects with.
+	key, err := exportIDKey(resp.GetNode().GetId())
+	if err != nil {
+		return daemoncmd.Profile{}, err
+	}
+	if profile.Metadata == nil {
+		profile.Metadata = &structpb.Struct{Fields: map[string]*structpb.Value{}}
+	}
+	profile.Metadata.Fields[daemoncmd.IDKeyMetadataKey] = structpb.NewStringValue(key)
+	return profile, nil
+}
+
+// exportIDKey returns the encoded private key to embed in profiles using ID
+// authentication. If the node ID of the profile is known, the key must belong
+// to it.
+func exportIDKey(nodeID string) (string, error) {
+	if profilesExportIDKey == "" {
+		return "", errors.New("the profile uses ID authentication, use --id-key-file to embed its key")
+	}
+	data, err := os.ReadFile(profilesExportIDKey)
+	if err != nil {
+		return "", fmt.Errorf("read id key: %w", err)
+	}
+	encoded := strings.TrimSpace(string(data))
+	key, err := crypto.DecodePrivateKey(encoded)
+	if err != nil {
+		return "", fmt.Errorf("decode id key: %w", err)
+	}
+	if nodeID != "" && key.ID() != nodeID {
+		return "", fmt.Errorf("the id key is for node %q, the profile is connected as %q", key.ID(), nodeID)
+	}
+	return encoded, nil
+}
+
+// profileFromContext builds a connection profile joining the cluster of the current
+// context with the credentials of the current user.
+func profileFromContext(id string) (daemoncmd.Profile, error) {
+	cluster := cliConfig.GetCurrentCluster()
+	user := cliConfig.GetCurrentUser()
+	if cluster == nil {
+		return daemoncmd.Profile{}, errors.New("no cluster configured for the current context")
+	}
+	if user == nil {
+		user = &cmdconfig.UserConfig{}
+	}
+	server := cluster.Server
+	if server == "" {
+		server = cmdconfig.DefaultServer
+	}
+	params := &v1.ConnectionParameters{
+		AddrType: v1.ConnectionParameters_ADDR,
+		Addrs:    []string{server},
+		Tls: &v1.MeshConnTLS{
+			Enabled:         !cluster.Insecure,
+			CaCertData:      cluster.CertificateAuthorityData,
+			VerifyChainOnly: cluster.TLSVerifyChainOnly,
+			SkipVerify:      cluster.TLSSkipVerify,
+		},
+		AuthCredentials: map[string]string{},
+	}
+	metadata := &structpb.Struct{Fields: map[string]*structpb.Value{}}
+	switch {
+	case user.IDAuthPrivateKey != "":
+		params.AuthMethod = v1.NetworkAuthMethod_ID
+		metadata.Fields[daemoncmd.IDKeyMetadataKey] = structpb.NewStringValue(user.IDAuthPrivateKey)
+	case user.ClientCertificateData != "" && user.ClientKeyData != "":
+		params.AuthMethod = v1.NetworkAuthMethod_MTLS
+		params.Tls.CertData = user.ClientCertificateData
+		params.Tls.KeyData = user.ClientKeyData
+	case user.BasicAuthUsername != "":
+		params.AuthMethod = v1.NetworkAuthMethod_BASIC
+		params.AuthCredentials[v1.ConnectionParameters_BASIC_USERNAME.String()] = user.BasicAuthUsername
+		params.AuthCredentials[v1.ConnectionParameters_BASIC_PASSWORD.String()] = user.BasicAuthPassword
+	case user.LDAPUsername != "":
+		params.AuthMethod = v1.NetworkAuthMethod_LDAP
+		params.AuthCredentials[v1.ConnectionParameters_LDAP_USERNAME.String()] = user.LDAPUsername
+		params.AuthCredentials[v1.ConnectionParameters_LDAP_PASSWORD.String()] = user.LDAPPassword
+	}
+	return daemoncmd.Profile{PutConnectionRequest: &v1.PutConnectionRequest{
+		Id:         id,
+		Parameters: params,
+		Metadata:   metadata,
+	}}, nil
+}
@@ -17,10 +17,19 @@ limitations under the License.
 package ctlcmd
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
+	"os"
 
 	"github.com/spf13/cobra"
 	v1 "github.com/webmeshproj/api/go/v1"
+
+	"github.com/webmeshproj/webmesh/pkg/services/dnsrecords"
+	"github.com/webmeshproj/webmesh/pkg/services/federation"
+	"github.com/webmeshproj/webmesh/pkg/services/namespaces"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
 )
 
 var (
@@ -53,6 +62,19 @@ var (
 	putEdgeWeight int32
 	putEdgeICE    bool
 	putEdgeLibp2p bool
+
+	putNamespaceMaxKeys         int64
+	putNamespaceMaxBytes        int64
+	putNamespaceMaxKeysPerNode  int64
+	putNamespaceMaxBytesPerNode int64
+
+	putFederationGateways    []string
+	putFederationFingerprint string
+
+	putDNSRecordType   string
+	putDNSRecordZone   string
+	putDNSRecordTTL    uint32
+	putDNSRecordValues []string
 )
 
 func init() {
@@ -112,12 +134,38 @@ func init() {
 	cobra.CheckErr(putEdgeCmd.MarkFlagRequired("from"))
 	cobra.CheckErr(putEdgeCmd.MarkFlagRequired("to"))
 
+	putNamespaceFlags := putNamespaceCmd.Flags()
+	putNamespaceFlags.Int64Var(&putNamespaceMaxKeys, "max-keys", 0, "maximum number of keys in the namespace")
+	putNamespaceFlags.Int64Var(&putNamespaceMaxBytes, "max-bytes", 0, "maximum total size of the keys and values in the namespace")
+	putNamespaceFlags.Int64Var(&putNamespaceMaxKeysPerNode, "max-keys-per-node", 0, "maximum number of keys a single node may own in the namespace")
+	putNamespaceFlags.Int64Var(&putNamespaceMaxBytesPerNode, "max-bytes-per-node", 0, "maximum total size of the keys and values a single node may own in the namespace")
+
+	putFederationFlags := putFederationCmd.Flags()
+	putFederationFlags.StringSliceVar(&putFederationGateways, "gateway", nil, "ID of a local node that peers with the gateways of the federated mesh, may be repeated")
+	putFederationFlags.StringVar(&putFederationFingerprint, "signing-key-fingerprint", "", "fingerprint of the key that signs the bundle, obtained from the administrators of the other mesh")
+	cobra.CheckErr(putFederationCmd.MarkFlagRequired("gateway"))
+	cobra.CheckErr(putFederationCmd.MarkFlagRequired("signing-key-fingerprint"))
+
+	putDNSRecordFlags := putDNSRecordCmd.Flags()
+	putDNSRecordFlags.StringVar(&putDNSRecordType, "type", "", "type of the record (A, AAAA, CNAME, TXT, or SRV)")
+	putDNSRecordFlags.StringVar(&putDNSRecordZone, "zone", "", "zone of the record (default = mesh domain)")
+	putDNSRecordFlags.Uint32Var(&putDNSRecordTTL, "ttl", types.DefaultDNSRecordTTL, "time-to-live of the record in seconds")
+	putDNSRecordFlags.StringArrayVar(&putDNSRecordValues, "value", nil, "value of the record, may be repeated")
+	cobra.CheckErr(putDNSRecordCmd.MarkFlagRequired("type"))
+	cobra.CheckErr(putDNSRecordCmd.MarkFlagRequired("value"))
+	cobra.CheckErr(putDNSRecordCmd.RegisterFlagCompletionFunc("type", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return types.DNSRecordTypes, cobra.ShellCompDirectiveNoFileComp
+	}))
+
 	putCmd.AddCommand(putRoleCmd)
 	putCmd.AddCommand(putRoleBindingCmd)
 	putCmd.AddCommand(putGroupCmd)
 	putCmd.AddCommand(putNetworkACLCmd)
 	putCmd.AddCommand(putRouteCmd)
 	putCmd.AddCommand(putEdgeCmd)
+	putCmd.AddCommand(putNamespaceCmd)
+	putCmd.AddCommand(putFederationCmd)
+	putCmd.AddCommand(putDNSRecordCmd)
 
 	rootCmd.AddCommand(putCmd)
 }
@@ -409,3 +457,122 @@ var putEdgeCmd = &cobra.Command{
 		return nil
 	},
 }
+
+var putNamespaceCmd = &cobra.Command{
+	Use:     "namespaces [NAME]",
+	Short:   "Create or update a key-value namespace in the mesh",
+	Aliases: []string{"namespace", "ns"},
+	Args:    cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) == 0 {
+			return errors.New("no namespace name specified")
+		}
+		ns := types.Namespace{
+			Name:            args[0],
+			MaxKeys:         putNamespaceMaxKeys,
+			MaxBytes:        putNamespaceMaxBytes,
+			MaxKeysPerNode:  putNamespaceMaxKeysPerNode,
+			MaxBytesPerNode: putNamespaceMaxBytesPerNode,
+		}
+		if err := ns.Validate(); err != nil {
+			return err
+		}
+		conn, err := cliConfig.DialCurrent()
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		if err := namespaces.Put(cmd.Context(), conn, ns); err != nil {
+			return err
+		}
+		cmd.Println("put namespace", ns.Name)
+		return nil
+	},
+}
+
+var putFederationCmd = &cobra.Command{
+	Use:   "federations [BUNDLE_FILE]",
+	Short: "Federate with another mesh using its trust bundle",
+	Long: `Federate with another mesh using its trust bundle.
+
+The bundle is read from the given file or stdin and is created by the
+administrators of the other mesh with "wmctl federation bundle". The local
+gateways peer with the gateways in the bundle and route the prefixes of the
+other mesh. Nodes of the other mesh can then be referenced in network ACLs
+as "node@domain".
+
+The fingerprint of the signing key is printed by "wmctl federation bundle"
+and must be obtained from the administrators of the other mesh through a
+separate trusted channel. The bundle is rejected if it is signed by another
+key. Expired bundles are ignored until they are replaced.`,
+	Aliases: []string{"federation", "fed"},
+	Args:    cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var data []byte
+		var err error
+		if len(args) == 0 || args[0] == "-" {
+			data, err = io.ReadAll(cmd.InOrStdin())
+		} else {
+			data, err = os.ReadFile(args[0])
+		}
+		if err != nil {
+			return err
+		}
+		fed := types.Federation{
+			Gateways:              putFederationGateways,
+			SigningKeyFingerprint: putFederationFingerprint,
+		}
+		if err := json.Unmarshal(data, &fed.Bundle); err != nil {
+			return fmt.Errorf("invalid trust bundle: %w", err)
+		}
+		if err := fed.Validate(); err != nil {
+			return err
+		}
+		conn, err := cliConfig.DialCurrent()
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		if err := federation.Put(cmd.Context(), conn, fed); err != nil {
+			return err
+		}
+		cmd.Println("put federation", fed.Domain())
+		return nil
+	},
+}
+
+var putDNSRecordCmd = &cobra.Command{
+	Use:   "dnsrecords [NAME]",
+	Short: "Create or update a custom DNS record served by MeshDNS",
+	Long: `Create or update a custom DNS record served by MeshDNS.
+
+Names not ending in the zone are relative to it, and "@" is the zone itself.
+The zone defaults to the mesh domain. Records in other zones are served by
+MeshDNS as well, for zones delegated to it. Every value of the record is
+given with --value, and putting a record replaces all of its values.`,
+	Example: `  wmctl put dnsrecords db --type A --value 172.16.0.10
+  wmctl put dnsrecords _postgres._tcp.db --type SRV --value "10 5 5432 db.webmesh.internal."
+  wmctl put dnsrecords api --zone corp.example --type CNAME --value node-a.webmesh.internal.`,
+	Aliases: []string{"dnsrecord", "dns"},
+	Args:    cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		rec := types.DNSRecord{
+			Name:   args[0],
+			Type:   putDNSRecordType,
+			Zone:   putDNSRecordZone,
+			TTL:    putDNSRecordTTL,
+			Values: putDNSRecordValues,
+		}
+		conn, err := cliConfig.DialCurrent()
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		stored, err := dnsrecords.Put(cmd.Context(), conn, rec)
+		if err != nil {
+			return err
+		}
+		cmd.Println("put dns record", stored.Name, stored.Type)
+		return nil
+	},
+}
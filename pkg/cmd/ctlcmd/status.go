@@ -19,9 +19,14 @@ package ctlcmd
 import (
 	"github.com/spf13/cobra"
 	v1 "github.com/webmeshproj/api/go/v1"
+
+	"github.com/webmeshproj/webmesh/pkg/services/autopilot"
 )
 
+var statusAutopilot bool
+
 func init() {
+	statusCmd.Flags().BoolVar(&statusAutopilot, "autopilot", false, "Show the state and recent decisions of the raft autopilot instead")
 	rootCmd.AddCommand(statusCmd)
 }
 
@@ -31,6 +36,9 @@ var statusCmd = &cobra.Command{
 	Args:              cobra.MaximumNArgs(1),
 	ValidArgsFunction: completeNodes(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
+		if statusAutopilot {
+			return statusAutopilotRun(cmd, args)
+		}
 		client, closer, err := cliConfig.NewNodeClient()
 		if err != nil {
 			return err
@@ -47,3 +55,25 @@ var statusCmd = &cobra.Command{
 		return encodeToStdout(cmd, status)
 	},
 }
+
+func statusAutopilotRun(cmd *cobra.Command, args []string) error {
+	conn, err := cliConfig.DialCurrent()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	state, err := autopilot.GetState(cmd.Context(), conn)
+	if err != nil {
+		return err
+	}
+	if len(args) == 1 {
+		servers := state.Servers[:0]
+		for _, srv := range state.Servers {
+			if srv.ID.String() == args[0] {
+				servers = append(servers, srv)
+			}
+		}
+		state.Servers = servers
+	}
+	return encodeJSONToStdout(cmd, state)
+}
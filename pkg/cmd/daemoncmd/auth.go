@@ -0,0 +1,248 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package daemoncmd
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/subtle"
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+)
+
+// UserNamespace returns the profile namespace for the OS user with the given ID.
+func UserNamespace(uid uint32) string {
+	return "user-" + strconv.FormatUint(uint64(uid), 10)
+}
+
+// authorizer authorizes calls to the daemon and assigns callers to namespaces.
+type authorizer struct {
+	conf      Auth
+	localBind bool
+	selfUID   uint32
+	tokens    map[string]string
+	allowUIDs map[uint32]struct{}
+	allowGIDs map[string]struct{}
+	hasPolicy bool
+}
+
+func newAuthorizer(conf Config) (*authorizer, error) {
+	a := &authorizer{
+		conf:      conf.Auth,
+		localBind: isLocalSocket(conf.Bind),
+		selfUID:   uint32(os.Getuid()),
+		allowUIDs: make(map[uint32]struct{}),
+		allowGIDs: make(map[string]struct{}),
+	}
+	for _, uid := range conf.Auth.AllowedUIDs {
+		a.allowUIDs[uint32(uid)] = struct{}{}
+	}
+	for _, gid := range conf.Auth.AllowedGIDs {
+		a.allowGIDs[strconv.Itoa(gid)] = struct{}{}
+	}
+	a.hasPolicy = len(a.allowUIDs) > 0 || len(a.allowGIDs) > 0
+	if conf.Auth.TokenFile != "" {
+		data, err := os.ReadFile(conf.Auth.TokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("read token file: %w", err)
+		}
+		a.tokens, err = parseTokens(data)
+		if err != nil {
+			return nil, fmt.Errorf("parse token file: %w", err)
+		}
+	}
+	return a, nil
+}
+
+// parseTokens parses a token file. Each non-empty line that is not a comment
+// contains a token optionally followed by the namespace its callers are
+// restricted to:
+//
+//	# token for the shared workstation
+//	s3cr3t
+//	0th3rs3cr3t alice
+func parseTokens(data []byte) (map[string]string, error) {
+	tokens := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	var lineno int
+	for scanner.Scan() {
+		lineno++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		switch len(fields) {
+		case 1:
+			tokens[fields[0]] = ""
+		case 2:
+			tokens[fields[0]] = fields[1]
+		default:
+			return nil, fmt.Errorf("line %d: expected a token and an optional namespace", lineno)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("no tokens defined")
+	}
+	return tokens, nil
+}
+
+// authorize checks the caller for the given context and returns a context with the
+// authenticated caller and its namespace set.
+func (a *authorizer) authorize(ctx context.Context) (context.Context, error) {
+	if creds, ok := PeerCredsFromContext(ctx); ok {
+		if !a.allowed(creds) {
+			return nil, status.Errorf(codes.PermissionDenied, "user %d is not allowed to use the daemon", creds.UID)
+		}
+		ctx = context.WithAuthenticatedCaller(ctx, "uid:"+strconv.FormatUint(uint64(creds.UID), 10))
+		if a.conf.UserNamespaces && creds.UID != 0 {
+			// Root may act in any namespace, everyone else is confined to their own.
+			ctx = withNamespace(ctx, UserNamespace(creds.UID))
+		}
+		return ctx, nil
+	}
+	if a.localBind {
+		// Platforms that cannot report peer credentials rely on the permissions
+		// of the socket alone.
+		if peerCredsSupported && (a.hasPolicy || a.conf.UserNamespaces) {
+			return nil, status.Errorf(codes.PermissionDenied, "could not determine peer credentials")
+		}
+		return ctx, nil
+	}
+	if len(a.tokens) == 0 {
+		return ctx, nil
+	}
+	token, ok := bearerToken(ctx)
+	if !ok {
+		return nil, status.Errorf(codes.Unauthenticated, "missing bearer token")
+	}
+	for t, namespace := range a.tokens {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) != 1 {
+			continue
+		}
+		caller := "token"
+		if namespace != "" {
+			caller = "token:" + namespace
+			ctx = withNamespace(ctx, namespace)
+		}
+		return context.WithAuthenticatedCaller(ctx, caller), nil
+	}
+	return nil, status.Errorf(codes.Unauthenticated, "invalid bearer token")
+}
+
+// allowed returns true if the given credentials are allowed by the policy. Root and
+// the user running the daemon are always allowed.
+func (a *authorizer) allowed(creds PeerCreds) bool {
+	if !a.hasPolicy || creds.UID == 0 || creds.UID == a.selfUID {
+		return true
+	}
+	if _, ok := a.allowUIDs[creds.UID]; ok {
+		return true
+	}
+	if len(a.allowGIDs) == 0 {
+		return false
+	}
+	if _, ok := a.allowGIDs[strconv.FormatUint(uint64(creds.GID), 10)]; ok {
+		return true
+	}
+	// Check supplementary groups of the user.
+	u, err := user.LookupId(strconv.FormatUint(uint64(creds.UID), 10))
+	if err != nil {
+		return false
+	}
+	gids, err := u.GroupIds()
+	if err != nil {
+		return false
+	}
+	for _, gid := range gids {
+		if _, ok := a.allowGIDs[gid]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// UnaryInterceptor returns a unary server interceptor that authorizes calls.
+func (a *authorizer) UnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		authctx, err := a.authorize(ctx)
+		if err != nil {
+			context.LoggerFrom(ctx).Warn("Rejected daemon call", "method", info.FullMethod, "error", err.Error())
+			return nil, err
+		}
+		return handler(authctx, req)
+	}
+}
+
+// StreamInterceptor returns a stream server interceptor that authorizes calls.
+func (a *authorizer) StreamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		authctx, err := a.authorize(ss.Context())
+		if err != nil {
+			context.LoggerFrom(ss.Context()).Warn("Rejected daemon call", "method", info.FullMethod, "error", err.Error())
+			return err
+		}
+		return handler(srv, &authorizedServerStream{ss, authctx})
+	}
+}
+
+type authorizedServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authorizedServerStream) Context() context.Context {
+	return s.ctx
+}
+
+// withNamespace overrides the namespace requested by the caller in the incoming metadata.
+func withNamespace(ctx context.Context, namespace string) context.Context {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		md = metadata.MD{}
+	} else {
+		md = md.Copy()
+	}
+	md.Set(DaemonNamespaceHeader, namespace)
+	return metadata.NewIncomingContext(ctx, md)
+}
+
+func bearerToken(ctx context.Context) (string, bool) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return "", false
+	}
+	for _, v := range md.Get("authorization") {
+		if token, ok := strings.CutPrefix(v, "Bearer "); ok {
+			return strings.TrimSpace(token), true
+		}
+	}
+	return "", false
+}
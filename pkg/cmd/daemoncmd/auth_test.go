@@ -0,0 +1,211 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package daemoncmd
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	mctx "github.com/webmeshproj/webmesh/pkg/context"
+)
+
+func TestParseTokens(t *testing.T) {
+	t.Parallel()
+	tc := []struct {
+		name    string
+		data    string
+		want    map[string]string
+		wantErr bool
+	}{
+		{
+			name: "tokens and namespaces",
+			data: "# comment\n\ns3cr3t\n  0th3r alice  \n",
+			want: map[string]string{"s3cr3t": "", "0th3r": "alice"},
+		},
+		{
+			name:    "too many fields",
+			data:    "s3cr3t alice bob\n",
+			wantErr: true,
+		},
+		{
+			name:    "no tokens",
+			data:    "# only a comment\n",
+			wantErr: true,
+		},
+	}
+	for _, c := range tc {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := parseTokens([]byte(c.data))
+			if c.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(got) != len(c.want) {
+				t.Fatalf("expected %d tokens, got %d", len(c.want), len(got))
+			}
+			for token, namespace := range c.want {
+				if got[token] != namespace {
+					t.Errorf("token %q: expected namespace %q, got %q", token, namespace, got[token])
+				}
+			}
+		})
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	t.Parallel()
+	self := uint32(os.Getuid())
+	other := self + 1000
+	withCreds := func(uid uint32) context.Context {
+		return context.WithValue(context.Background(), peerCredsKey{}, PeerCreds{UID: uid, GID: uid})
+	}
+	withToken := func(token string) context.Context {
+		return metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer "+token))
+	}
+	namespaceOf := func(ctx context.Context) string {
+		md, _ := metadata.FromIncomingContext(ctx)
+		if v := md.Get(DaemonNamespaceHeader); len(v) > 0 {
+			return v[0]
+		}
+		return ""
+	}
+
+	tc := []struct {
+		name      string
+		auth      *authorizer
+		ctx       context.Context
+		code      codes.Code
+		caller    string
+		namespace string
+		// needsPeerCreds skips the case on platforms without peer credentials.
+		needsPeerCreds bool
+	}{
+		{
+			name:   "no policy allows any user",
+			auth:   &authorizer{localBind: true, selfUID: self},
+			ctx:    withCreds(other),
+			caller: "uid:" + itoa(other),
+		},
+		{
+			name: "policy rejects other users",
+			auth: &authorizer{localBind: true, selfUID: self, hasPolicy: true, allowUIDs: map[uint32]struct{}{}},
+			ctx:  withCreds(other),
+			code: codes.PermissionDenied,
+		},
+		{
+			name:   "policy allows listed users",
+			auth:   &authorizer{localBind: true, selfUID: self, hasPolicy: true, allowUIDs: map[uint32]struct{}{other: {}}},
+			ctx:    withCreds(other),
+			caller: "uid:" + itoa(other),
+		},
+		{
+			name:   "policy allows the daemon user",
+			auth:   &authorizer{localBind: true, selfUID: self, hasPolicy: true, allowUIDs: map[uint32]struct{}{}},
+			ctx:    withCreds(self),
+			caller: "uid:" + itoa(self),
+		},
+		{
+			name:      "user namespaces confine non-root users",
+			auth:      &authorizer{localBind: true, selfUID: self, conf: Auth{UserNamespaces: true}},
+			ctx:       withCreds(other),
+			caller:    "uid:" + itoa(other),
+			namespace: UserNamespace(other),
+		},
+		{
+			name:   "user namespaces do not confine root",
+			auth:   &authorizer{localBind: true, selfUID: self, conf: Auth{UserNamespaces: true}},
+			ctx:    withCreds(0),
+			caller: "uid:0",
+		},
+		{
+			name: "no peer credentials without policy",
+			auth: &authorizer{localBind: true, selfUID: self},
+			ctx:  context.Background(),
+		},
+		{
+			name:           "no peer credentials with policy",
+			auth:           &authorizer{localBind: true, selfUID: self, hasPolicy: true},
+			ctx:            context.Background(),
+			code:           codes.PermissionDenied,
+			needsPeerCreds: true,
+		},
+		{
+			name: "missing token",
+			auth: &authorizer{tokens: map[string]string{"s3cr3t": ""}},
+			ctx:  context.Background(),
+			code: codes.Unauthenticated,
+		},
+		{
+			name: "invalid token",
+			auth: &authorizer{tokens: map[string]string{"s3cr3t": ""}},
+			ctx:  withToken("wrong"),
+			code: codes.Unauthenticated,
+		},
+		{
+			name:   "valid token",
+			auth:   &authorizer{tokens: map[string]string{"s3cr3t": ""}},
+			ctx:    withToken("s3cr3t"),
+			caller: "token",
+		},
+		{
+			name:      "valid token with namespace",
+			auth:      &authorizer{tokens: map[string]string{"s3cr3t": "alice"}},
+			ctx:       withToken("s3cr3t"),
+			caller:    "token:alice",
+			namespace: "alice",
+		},
+	}
+	for _, c := range tc {
+		t.Run(c.name, func(t *testing.T) {
+			if c.needsPeerCreds && !peerCredsSupported {
+				t.Skip("peer credentials are not supported on this platform")
+			}
+			ctx, err := c.auth.authorize(c.ctx)
+			if c.code != codes.OK {
+				if status.Code(err) != c.code {
+					t.Fatalf("expected code %v, got %v", c.code, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			caller, _ := mctx.AuthenticatedCallerFrom(ctx)
+			if caller != c.caller {
+				t.Errorf("expected caller %q, got %q", c.caller, caller)
+			}
+			if ns := namespaceOf(ctx); ns != c.namespace {
+				t.Errorf("expected namespace %q, got %q", c.namespace, ns)
+			}
+		})
+	}
+}
+
+func itoa(uid uint32) string {
+	return strconv.FormatUint(uint64(uid), 10)
+}
@@ -0,0 +1,226 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package daemoncmd
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+	"golang.org/x/crypto/scrypt"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+const (
+	// BundleAPIVersion is the API version of profile bundles.
+	BundleAPIVersion = "webmesh.io/v1"
+	// BundleKind is the kind of profile bundles.
+	BundleKind = "ProfileBundle"
+)
+
+// Parameters used for encrypting bundles.
+const (
+	bundleKDF       = "scrypt"
+	bundleCipher    = "aes-256-gcm"
+	bundleScryptN   = 1 << 15
+	bundleScryptR   = 8
+	bundleScryptP   = 1
+	bundleKeyLength = 32
+	bundleSaltSize  = 16
+)
+
+// ErrBundleEncrypted is returned when reading the profile from an encrypted bundle
+// without decrypting it first.
+var ErrBundleEncrypted = errors.New("profile bundle is encrypted")
+
+// ErrBundlePassphrase is returned when a bundle cannot be decrypted with the
+// given passphrase.
+var ErrBundlePassphrase = errors.New("invalid passphrase or corrupted profile bundle")
+
+// ProfileBundle is a portable, single file representation of a connection profile.
+// All credentials used by the profile, such as TLS material, basic or LDAP credentials,
+// and ID keys, are carried inline in the profile. The profile can optionally be
+// encrypted with a passphrase.
+type ProfileBundle struct {
+	// APIVersion is the API version of the bundle.
+	APIVersion string `json:"apiVersion"`
+	// Kind is always ProfileBundle.
+	Kind string `json:"kind"`
+	// Profile is the JSON encoded connection profile. It is empty when the
+	// bundle is encrypted.
+	Profile json.RawMessage `json:"profile,omitempty"`
+	// Encryption are the parameters used to encrypt the profile.
+	Encryption *BundleEncryption `json:"encryption,omitempty"`
+	// Data is the encrypted profile.
+	Data []byte `json:"data,omitempty"`
+}
+
+// BundleEncryption are the parameters used to encrypt a profile bundle.
+type BundleEncryption struct {
+	// KDF is the key derivation function used for the passphrase.
+	KDF string `json:"kdf"`
+	// Cipher is the cipher used to encrypt the profile.
+	Cipher string `json:"cipher"`
+	// Salt is the salt used for key derivation.
+	Salt []byte `json:"salt"`
+	// Nonce is the nonce used for encryption.
+	Nonce []byte `json:"nonce"`
+	// N, R and P are the scrypt cost parameters.
+	N int `json:"n"`
+	R int `json:"r"`
+	P int `json:"p"`
+}
+
+// NewProfileBundle returns a new unencrypted bundle for the given profile.
+func NewProfileBundle(profile Profile) (*ProfileBundle, error) {
+	data, err := protojson.Marshal(profile.PutConnectionRequest)
+	if err != nil {
+		return nil, fmt.Errorf("marshal profile: %w", err)
+	}
+	return &ProfileBundle{
+		APIVersion: BundleAPIVersion,
+		Kind:       BundleKind,
+		Profile:    data,
+	}, nil
+}
+
+// ReadProfileBundle reads a profile bundle from the given reader.
+func ReadProfileBundle(r io.Reader) (*ProfileBundle, error) {
+	var bundle ProfileBundle
+	if err := json.NewDecoder(r).Decode(&bundle); err != nil {
+		return nil, fmt.Errorf("decode profile bundle: %w", err)
+	}
+	if bundle.Kind != BundleKind {
+		return nil, fmt.Errorf("unexpected kind %q, expected %q", bundle.Kind, BundleKind)
+	}
+	if bundle.APIVersion != BundleAPIVersion {
+		return nil, fmt.Errorf("unsupported bundle api version %q", bundle.APIVersion)
+	}
+	return &bundle, nil
+}
+
+// WriteTo writes the bundle to the given writer.
+func (b *ProfileBundle) WriteTo(w io.Writer) (int64, error) {
+	data, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return 0, fmt.Errorf("marshal profile bundle: %w", err)
+	}
+	n, err := w.Write(append(data, '\n'))
+	return int64(n), err
+}
+
+// Encrypted returns true if the bundle is encrypted.
+func (b *ProfileBundle) Encrypted() bool {
+	return b.Encryption != nil
+}
+
+// Encrypt encrypts the profile in the bundle with the given passphrase.
+func (b *ProfileBundle) Encrypt(passphrase []byte) error {
+	if b.Encrypted() {
+		return errors.New("profile bundle is already encrypted")
+	}
+	if len(passphrase) == 0 {
+		return errors.New("passphrase cannot be empty")
+	}
+	enc := &BundleEncryption{
+		KDF:    bundleKDF,
+		Cipher: bundleCipher,
+		Salt:   make([]byte, bundleSaltSize),
+		N:      bundleScryptN,
+		R:      bundleScryptR,
+		P:      bundleScryptP,
+	}
+	if _, err := rand.Read(enc.Salt); err != nil {
+		return fmt.Errorf("generate salt: %w", err)
+	}
+	aead, err := enc.aead(passphrase)
+	if err != nil {
+		return err
+	}
+	enc.Nonce = make([]byte, aead.NonceSize())
+	if _, err := rand.Read(enc.Nonce); err != nil {
+		return fmt.Errorf("generate nonce: %w", err)
+	}
+	b.Data = aead.Seal(nil, enc.Nonce, b.Profile, b.additionalData())
+	b.Encryption = enc
+	b.Profile = nil
+	return nil
+}
+
+// Decrypt decrypts the profile in the bundle with the given passphrase.
+func (b *ProfileBundle) Decrypt(passphrase []byte) error {
+	if !b.Encrypted() {
+		return nil
+	}
+	if b.Encryption.KDF != bundleKDF || b.Encryption.Cipher != bundleCipher {
+		return fmt.Errorf("unsupported bundle encryption %s/%s", b.Encryption.KDF, b.Encryption.Cipher)
+	}
+	aead, err := b.Encryption.aead(passphrase)
+	if err != nil {
+		return err
+	}
+	if len(b.Encryption.Nonce) != aead.NonceSize() {
+		return ErrBundlePassphrase
+	}
+	data, err := aead.Open(nil, b.Encryption.Nonce, b.Data, b.additionalData())
+	if err != nil {
+		return ErrBundlePassphrase
+	}
+	b.Profile = data
+	b.Encryption = nil
+	b.Data = nil
+	return nil
+}
+
+// Unpack returns the profile contained in the bundle. The bundle must be
+// decrypted first.
+func (b *ProfileBundle) Unpack() (Profile, error) {
+	if b.Encrypted() {
+		return Profile{}, ErrBundleEncrypted
+	}
+	var req v1.PutConnectionRequest
+	if err := protojson.Unmarshal(b.Profile, &req); err != nil {
+		return Profile{}, fmt.Errorf("unmarshal profile: %w", err)
+	}
+	return Profile{PutConnectionRequest: &req}, nil
+}
+
+// additionalData binds the ciphertext to the bundle header.
+func (b *ProfileBundle) additionalData() []byte {
+	return []byte(b.APIVersion + "/" + b.Kind)
+}
+
+func (e *BundleEncryption) aead(passphrase []byte) (cipher.AEAD, error) {
+	// Guard against hostile bundles demanding absurd amounts of memory.
+	if e.N <= 1 || e.N > 1<<20 || e.R <= 0 || e.R > 32 || e.P <= 0 || e.P > 16 {
+		return nil, fmt.Errorf("invalid scrypt parameters")
+	}
+	key, err := scrypt.Key(passphrase, e.Salt, e.N, e.R, e.P, bundleKeyLength)
+	if err != nil {
+		return nil, fmt.Errorf("derive key: %w", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("create cipher: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
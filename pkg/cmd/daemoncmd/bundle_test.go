@@ -0,0 +1,148 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package daemoncmd
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+func TestProfileBundle(t *testing.T) {
+	t.Parallel()
+	metadata, err := structpb.NewStruct(map[string]any{AutoConnectMetadataKey: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	profile := Profile{PutConnectionRequest: &v1.PutConnectionRequest{
+		Id: "office",
+		Parameters: &v1.ConnectionParameters{
+			AuthMethod: v1.NetworkAuthMethod_BASIC,
+			AuthCredentials: map[string]string{
+				v1.ConnectionParameters_BASIC_USERNAME.String(): "alice",
+				v1.ConnectionParameters_BASIC_PASSWORD.String(): "hunter2",
+			},
+			Addrs: []string{"join.example.com:8443"},
+			Tls:   &v1.MeshConnTLS{Enabled: true, CaCertData: "Y2EtZGF0YQ=="},
+		},
+		Metadata: metadata,
+	}}
+
+	t.Run("Plain", func(t *testing.T) {
+		t.Parallel()
+		bundle, err := NewProfileBundle(profile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := roundTripBundle(t, bundle)
+		unpacked, err := got.Unpack()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !proto.Equal(unpacked.PutConnectionRequest, profile.PutConnectionRequest) {
+			t.Fatalf("unpacked profile = %v, want %v", unpacked, profile)
+		}
+	})
+
+	t.Run("Encrypted", func(t *testing.T) {
+		t.Parallel()
+		bundle, err := NewProfileBundle(profile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := bundle.Encrypt([]byte("correct horse")); err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		if _, err := bundle.WriteTo(&buf); err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Contains(buf.Bytes(), []byte("hunter2")) {
+			t.Fatal("encrypted bundle contains plaintext credentials")
+		}
+		got, err := ReadProfileBundle(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := got.Unpack(); !errors.Is(err, ErrBundleEncrypted) {
+			t.Fatalf("expected ErrBundleEncrypted, got %v", err)
+		}
+		if err := got.Decrypt([]byte("wrong horse")); !errors.Is(err, ErrBundlePassphrase) {
+			t.Fatalf("expected ErrBundlePassphrase, got %v", err)
+		}
+		if err := got.Decrypt([]byte("correct horse")); err != nil {
+			t.Fatal(err)
+		}
+		unpacked, err := got.Unpack()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !proto.Equal(unpacked.PutConnectionRequest, profile.PutConnectionRequest) {
+			t.Fatalf("unpacked profile = %v, want %v", unpacked, profile)
+		}
+		if !unpacked.AutoConnect() {
+			t.Fatal("expected auto-connect metadata to survive the round trip")
+		}
+	})
+
+	t.Run("InvalidKind", func(t *testing.T) {
+		t.Parallel()
+		_, err := ReadProfileBundle(bytes.NewBufferString(`{"apiVersion":"webmesh.io/v1","kind":"Config"}`))
+		if err == nil {
+			t.Fatal("expected error for invalid kind")
+		}
+	})
+}
+
+func roundTripBundle(t *testing.T, bundle *ProfileBundle) *ProfileBundle {
+	t.Helper()
+	var buf bytes.Buffer
+	if _, err := bundle.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadProfileBundle(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return got
+}
+
+func TestProfilePublicMetadata(t *testing.T) {
+	t.Parallel()
+	metadata, err := structpb.NewStruct(map[string]any{
+		AutoConnectMetadataKey: true,
+		IDKeyMetadataKey:       "secret",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	profile := Profile{PutConnectionRequest: &v1.PutConnectionRequest{Id: "office", Metadata: metadata}}
+	public := profile.PublicMetadata()
+	if _, ok := public.GetFields()[IDKeyMetadataKey]; ok {
+		t.Fatal("expected the id key to be removed")
+	}
+	if !public.GetFields()[AutoConnectMetadataKey].GetBoolValue() {
+		t.Fatal("expected other metadata to be kept")
+	}
+	if _, ok := profile.GetMetadata().GetFields()[IDKeyMetadataKey]; !ok {
+		t.Fatal("expected the stored profile to keep its id key")
+	}
+}
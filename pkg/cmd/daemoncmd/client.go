@@ -0,0 +1,63 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package daemoncmd
+
+import (
+	"net"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+)
+
+// Dial dials the app daemon at the given address. The address takes the same
+// forms as the daemon's bind address and defaults to DefaultDaemonSocket.
+func Dial(ctx context.Context, addr string, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
+	if addr == "" {
+		addr = DefaultDaemonSocket()
+	}
+	opts = append([]grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}, opts...)
+	switch {
+	case strings.HasPrefix(addr, "unix://"):
+		addr = addr[7:]
+	case strings.HasPrefix(addr, "tcp://"):
+		return grpc.DialContext(ctx, addr[6:], opts...)
+	case !isLocalSocket(addr):
+		return grpc.DialContext(ctx, addr, opts...)
+	}
+	opts = append(opts, grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, "unix", addr)
+	}))
+	return grpc.DialContext(ctx, "passthrough:///"+addr, opts...)
+}
+
+// NewClientContext returns an outgoing context for calls to the app daemon in
+// the given namespace, authenticated with the given bearer token. Empty values
+// are omitted.
+func NewClientContext(ctx context.Context, namespace, token string) context.Context {
+	if namespace != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, DaemonNamespaceHeader, namespace)
+	}
+	if token != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+token)
+	}
+	return ctx
+}
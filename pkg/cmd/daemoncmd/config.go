@@ -26,6 +26,7 @@ import (
 
 	"github.com/spf13/pflag"
 
+	"github.com/webmeshproj/webmesh/pkg/config"
 	"github.com/webmeshproj/webmesh/pkg/crypto"
 	"github.com/webmeshproj/webmesh/pkg/logging"
 	"github.com/webmeshproj/webmesh/pkg/meshnet/wireguard"
@@ -64,6 +65,8 @@ type Config struct {
 	Bind string `koanf:"bind"`
 	// InsecureSocket uses an insecure socket when binding to a unix socket.
 	InsecureSocket bool `koanf:"insecure-socket"`
+	// Auth are options for authorizing clients of the daemon.
+	Auth Auth `koanf:"auth"`
 	// GRPCWeb enables gRPC-Web support.
 	GRPCWeb bool `koanf:"grpc-web"`
 	// CORS are options for configuring CORS. These are only applicable when
@@ -73,6 +76,8 @@ type Config struct {
 	UI WebUI `koanf:"ui"`
 	// Persistence are options for persisting mesh data.
 	Persistence Persistence `koanf:"persistence"`
+	// Reconnect are options for reconnecting dropped connections.
+	Reconnect Reconnect `koanf:"reconnect"`
 	// WireGuardStartPort is the starting port for WireGuard connections.
 	WireGuardStartPort uint16 `koanf:"wireguard-start-port"`
 	// LogLevel is the log level for the daemon.
@@ -106,20 +111,67 @@ type Persistence struct {
 	// Path is the root path to store mesh connection data.
 	// Each connection will receive its own subdirectory.
 	Path string `koanf:"path"`
+	// Encryption are the options for encrypting persisted profiles and
+	// connection storage. Keys derived from the WireGuard key are not
+	// supported since the daemon's key may rotate.
+	Encryption config.StorageEncryptionOptions `koanf:"encryption"`
+}
+
+// Auth are options for authorizing clients of the daemon.
+type Auth struct {
+	// AllowedUIDs are the user IDs allowed to use the daemon over a unix socket.
+	// Root and the user running the daemon are always allowed. When both this and
+	// AllowedGIDs are empty, any user able to open the socket is allowed.
+	AllowedUIDs []int `koanf:"allowed-uids"`
+	// AllowedGIDs are the group IDs allowed to use the daemon over a unix socket.
+	// Both the primary and supplementary groups of the caller are checked.
+	AllowedGIDs []int `koanf:"allowed-gids"`
+	// TokenFile is a file of bearer tokens that clients must present when the daemon
+	// is bound to a TCP address. Each line contains a token optionally followed by
+	// the namespace its callers are restricted to.
+	TokenFile string `koanf:"token-file"`
+	// UserNamespaces places every non-root user connecting over a unix socket in
+	// its own profile namespace, ignoring the namespace requested by the client.
+	// It is only supported on platforms that report peer credentials.
+	UserNamespaces bool `koanf:"user-namespaces"`
+}
+
+// Reconnect are options for reconnecting dropped connections.
+type Reconnect struct {
+	// Disabled disables reconnecting dropped connections.
+	Disabled bool `koanf:"disabled"`
+	// CheckInterval is the interval to check the health of connections.
+	CheckInterval time.Duration `koanf:"check-interval"`
+	// InitialBackoff is the initial time to wait between reconnect attempts.
+	InitialBackoff time.Duration `koanf:"initial-backoff"`
+	// MaxBackoff is the maximum time to wait between reconnect attempts.
+	MaxBackoff time.Duration `koanf:"max-backoff"`
+	// WatchNetwork re-detects endpoints and re-joins connections when the
+	// default route or interface addresses change. This is only supported
+	// on Linux.
+	WatchNetwork bool `koanf:"watch-network"`
 }
 
 // NewDefaultConfig returns the default configuration.
 func NewDefaultConfig() *Config {
 	return &Config{
-		Enabled:            false,
-		NodeID:             "",
-		KeyFile:            "",
-		KeyRotation:        0,
-		Bind:               DefaultDaemonSocket(),
-		InsecureSocket:     false,
-		GRPCWeb:            false,
-		CORS:               CORS{AllowedOrigins: []string{"*"}},
-		UI:                 WebUI{Enabled: false, ListenAddress: "127.0.0.1:8080"},
+		Enabled:        false,
+		NodeID:         "",
+		KeyFile:        "",
+		KeyRotation:    0,
+		Bind:           DefaultDaemonSocket(),
+		InsecureSocket: false,
+		GRPCWeb:        false,
+		CORS:           CORS{AllowedOrigins: []string{"*"}},
+		UI:             WebUI{Enabled: false, ListenAddress: "127.0.0.1:8080"},
+		Auth:           Auth{},
+		Reconnect: Reconnect{
+			Disabled:       false,
+			CheckInterval:  time.Second * 15,
+			InitialBackoff: time.Second,
+			MaxBackoff:     time.Minute * 2,
+			WatchNetwork:   runtime.GOOS == "linux",
+		},
 		WireGuardStartPort: wireguard.DefaultListenPort,
 		LogLevel:           "info",
 		LogFormat:          "json",
@@ -149,6 +201,8 @@ func (conf *Config) BindFlags(prefix string, flagset *pflag.FlagSet) *Config {
 	conf.CORS.BindFlags(prefix+"cors.", flagset)
 	conf.UI.BindFlags(prefix+"ui.", flagset)
 	conf.Persistence.BindFlags(prefix+"persistence.", flagset)
+	conf.Reconnect.BindFlags(prefix+"reconnect.", flagset)
+	conf.Auth.BindFlags(prefix+"auth.", flagset)
 	return conf
 }
 
@@ -167,6 +221,27 @@ func (conf *WebUI) BindFlags(prefix string, flagset *pflag.FlagSet) {
 // BindFlags binds the persistence flags to the given flagset.
 func (conf *Persistence) BindFlags(prefix string, flagset *pflag.FlagSet) {
 	flagset.StringVar(&conf.Path, prefix+"path", conf.Path, "Root path to store mesh connection data")
+	flagset.StringVar(&conf.Encryption.KeyFile, prefix+"encryption.key-file", conf.Encryption.KeyFile, "Path to a file containing the encryption key for persisted data")
+	flagset.StringVar(&conf.Encryption.KeyEnv, prefix+"encryption.key-env", conf.Encryption.KeyEnv, "Environment variable containing the encryption key for persisted data")
+	flagset.StringVar(&conf.Encryption.PreviousKeyFile, prefix+"encryption.previous-key-file", conf.Encryption.PreviousKeyFile, "Path to a file containing the previous encryption key, used for rotation")
+	flagset.StringVar(&conf.Encryption.PreviousKeyEnv, prefix+"encryption.previous-key-env", conf.Encryption.PreviousKeyEnv, "Environment variable containing the previous encryption key, used for rotation")
+}
+
+// BindFlags binds the auth flags to the given flagset.
+func (conf *Auth) BindFlags(prefix string, flagset *pflag.FlagSet) {
+	flagset.IntSliceVar(&conf.AllowedUIDs, prefix+"allowed-uids", conf.AllowedUIDs, "User IDs allowed to use the daemon over a unix socket")
+	flagset.IntSliceVar(&conf.AllowedGIDs, prefix+"allowed-gids", conf.AllowedGIDs, "Group IDs allowed to use the daemon over a unix socket")
+	flagset.StringVar(&conf.TokenFile, prefix+"token-file", conf.TokenFile, "File of bearer tokens required of clients on TCP listeners")
+	flagset.BoolVar(&conf.UserNamespaces, prefix+"user-namespaces", conf.UserNamespaces, "Give each non-root OS user its own profile namespace")
+}
+
+// BindFlags binds the reconnect flags to the given flagset.
+func (conf *Reconnect) BindFlags(prefix string, flagset *pflag.FlagSet) {
+	flagset.BoolVar(&conf.Disabled, prefix+"disabled", conf.Disabled, "Disable reconnecting dropped connections")
+	flagset.DurationVar(&conf.CheckInterval, prefix+"check-interval", conf.CheckInterval, "Interval to check the health of connections")
+	flagset.DurationVar(&conf.InitialBackoff, prefix+"initial-backoff", conf.InitialBackoff, "Initial time to wait between reconnect attempts")
+	flagset.DurationVar(&conf.MaxBackoff, prefix+"max-backoff", conf.MaxBackoff, "Maximum time to wait between reconnect attempts")
+	flagset.BoolVar(&conf.WatchNetwork, prefix+"watch-network", conf.WatchNetwork, "Re-join connections when the default route or interface addresses change")
 }
 
 // Validate validates the configuration.
@@ -184,6 +259,38 @@ func (conf *Config) Validate() error {
 		if isLocalSocket(conf.Bind) {
 			return fmt.Errorf("ui cannot be enabled with a file socket")
 		}
+		if conf.Auth.TokenFile != "" {
+			return fmt.Errorf("ui cannot be enabled with token authentication")
+		}
+	}
+	if isLocalSocket(conf.Bind) {
+		if conf.Auth.TokenFile != "" {
+			return fmt.Errorf("token file can only be used with a tcp bind address")
+		}
+		if !peerCredsSupported && (len(conf.Auth.AllowedUIDs) > 0 || len(conf.Auth.AllowedGIDs) > 0 || conf.Auth.UserNamespaces) {
+			return fmt.Errorf("allowed uids and gids and user namespaces are not supported on this platform")
+		}
+	} else if len(conf.Auth.AllowedUIDs) > 0 || len(conf.Auth.AllowedGIDs) > 0 {
+		return fmt.Errorf("allowed uids and gids can only be used with a unix socket")
+	}
+	if !conf.Persistence.Encryption.IsEmpty() {
+		if conf.Persistence.Path == "" {
+			return fmt.Errorf("persistence encryption requires a persistence path")
+		}
+		if conf.Persistence.Encryption.FromWireGuard {
+			return fmt.Errorf("persistence encryption cannot be derived from the wireguard key")
+		}
+		if err := conf.Persistence.Encryption.Validate(); err != nil {
+			return err
+		}
+	}
+	if !conf.Reconnect.Disabled {
+		if conf.Reconnect.CheckInterval <= 0 {
+			return fmt.Errorf("reconnect check interval must be greater than zero")
+		}
+		if conf.Reconnect.InitialBackoff <= 0 || conf.Reconnect.MaxBackoff < conf.Reconnect.InitialBackoff {
+			return fmt.Errorf("reconnect backoff must be greater than zero and max backoff at least the initial backoff")
+		}
 	}
 	return nil
 }
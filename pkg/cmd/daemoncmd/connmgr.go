@@ -56,15 +56,19 @@ var (
 
 // ConnManager manages the connections for the daemon.
 type ConnManager struct {
-	nodeID   types.NodeID
-	key      crypto.PrivateKey
-	conf     Config
-	profiles ProfileStore
-	conns    map[NamespacedConn]embed.Node
-	ports    map[uint16]NamespacedConn
-	utuns    map[uint16]NamespacedConn
-	log      *slog.Logger
-	mu       sync.RWMutex
+	nodeID      types.NodeID
+	key         crypto.PrivateKey
+	conf        Config
+	profiles    ProfileStore
+	conns       map[NamespacedConn]embed.Node
+	ports       map[uint16]NamespacedConn
+	utuns       map[uint16]NamespacedConn
+	supervisors map[NamespacedConn]*supervisor
+	events      *connEvents
+	newNode     func(context.Context, embed.Options) (embed.Node, error)
+	cancel      context.CancelFunc
+	log         *slog.Logger
+	mu          sync.RWMutex
 }
 
 // NamespacedConn is a namespaced connection.
@@ -89,12 +93,16 @@ func NewConnManager(conf Config) (*ConnManager, error) {
 	if err != nil {
 		return nil, fmt.Errorf("load key: %w", err)
 	}
+	keys, err := conf.Persistence.Encryption.NewKeys(nil)
+	if err != nil {
+		return nil, fmt.Errorf("load encryption keys: %w", err)
+	}
 	profiles, err := NewProfileStore(func() string {
 		if conf.Persistence.Path == "" {
 			return ""
 		}
 		return filepath.Join(conf.Persistence.Path, "profiles")
-	}())
+	}(), keys)
 	if err != nil {
 		return nil, fmt.Errorf("create profile store: %w", err)
 	}
@@ -104,16 +112,25 @@ func NewConnManager(conf Config) (*ConnManager, error) {
 	} else {
 		nodeID = types.NodeID(key.ID())
 	}
-	return &ConnManager{
-		nodeID:   nodeID,
-		key:      key,
-		conf:     conf,
-		profiles: profiles,
-		conns:    make(map[NamespacedConn]embed.Node),
-		ports:    make(map[uint16]NamespacedConn),
-		utuns:    make(map[uint16]NamespacedConn),
-		log:      log,
-	}, nil
+	ctx, cancel := context.WithCancel(context.WithLogger(context.Background(), log))
+	m := &ConnManager{
+		nodeID:      nodeID,
+		key:         key,
+		conf:        conf,
+		profiles:    profiles,
+		conns:       make(map[NamespacedConn]embed.Node),
+		ports:       make(map[uint16]NamespacedConn),
+		utuns:       make(map[uint16]NamespacedConn),
+		supervisors: make(map[NamespacedConn]*supervisor),
+		events:      newConnEvents(),
+		newNode:     embed.NewNode,
+		cancel:      cancel,
+		log:         log,
+	}
+	if !conf.Reconnect.Disabled && conf.Reconnect.WatchNetwork {
+		go m.watchNetwork(ctx)
+	}
+	return m, nil
 }
 
 // NodeID returns the node ID used for connections.
@@ -127,6 +144,19 @@ func (m *ConnManager) PublicKey() string {
 	return encoded
 }
 
+// identityFor returns the node ID and key to use for connections with the given
+// profile. Profiles carrying their own ID key use it instead of the daemon's key.
+func (m *ConnManager) identityFor(profile Profile) (types.NodeID, crypto.PrivateKey, error) {
+	key, err := profile.IDKey()
+	if err != nil {
+		return "", nil, err
+	}
+	if key == nil {
+		return m.nodeID, m.key, nil
+	}
+	return types.NodeID(key.ID()), key, nil
+}
+
 // Profiles returns the profiles store.
 func (m *ConnManager) Profiles() ProfileStore {
 	return m.profiles
@@ -135,9 +165,13 @@ func (m *ConnManager) Profiles() ProfileStore {
 // Close closes the connection manager and all connections. It is not
 // safe to use the connection manager after calling Close.
 func (m *ConnManager) Close() error {
+	m.cancel()
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	defer m.profiles.Close()
+	for _, sv := range m.supervisors {
+		sv.stop()
+	}
 	for id, conn := range m.conns {
 		m.log.Info("Stopping connection", "id", id)
 		err := conn.Stop(context.WithLogger(context.Background(), m.log))
@@ -166,6 +200,10 @@ func (m *ConnManager) Get(ctx context.Context, connID string) (embed.Node, bool)
 func (m *ConnManager) GetStatus(ctx context.Context, connID string) v1.DaemonConnStatus {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
+	// A reconnecting connection has no node between failed attempts.
+	if sv, ok := m.supervisors[NamespacedConnFromContext(ctx, connID)]; ok && sv.reconnecting.Load() {
+		return v1.DaemonConnStatus_CONNECTING
+	}
 	c, ok := m.getConn(ctx, connID)
 	if !ok {
 		return v1.DaemonConnStatus_DISCONNECTED
@@ -228,29 +266,45 @@ func (m *ConnManager) NewConn(ctx context.Context, req *v1.ConnectRequest) (id s
 		}
 		return "", nil, status.Errorf(codes.Internal, "failed to get profile: %v", err)
 	}
-	cfg, err := m.buildConnConfig(ctx, profile.GetParameters(), connID, port)
+	nodeID, key, err := m.identityFor(profile)
+	if err != nil {
+		return "", nil, status.Errorf(codes.InvalidArgument, "invalid profile: %v", err)
+	}
+	cfg, err := m.buildConnConfig(ctx, profile.GetParameters(), nodeID, connID, port)
 	if err != nil {
 		return "", nil, err
 	}
 	m.log.Debug("Generated webmesh node configuration", "id", connID, "config", cfg.ToMapStructure())
-	node, err = embed.NewNode(ctx, embed.Options{
+	node, err = m.newNode(ctx, embed.Options{
 		Config: cfg,
-		Key:    m.key,
+		Key:    key,
 		Logger: m.log.With("connection-id", connID),
 	})
 	if err != nil {
 		return "", nil, status.Errorf(codes.Internal, "failed to create node: %v", err)
 	}
 	m.conns[NamespacedConnFromContext(ctx, connID)] = node
+	m.events.publish(NamespacedConnFromContext(ctx, connID), v1.DaemonConnStatus_CONNECTING)
 	return connID, node, nil
 }
 
 // Disconnect disconnects the connection for the given ID.
 func (m *ConnManager) Disconnect(ctx context.Context, connID string) error {
-	m.mu.RLock()
+	m.mu.Lock()
+	sv, supervised := m.supervisors[NamespacedConnFromContext(ctx, connID)]
+	if supervised {
+		// Stop the supervisor first so it does not try to reconnect.
+		sv.stop()
+		delete(m.supervisors, NamespacedConnFromContext(ctx, connID))
+	}
 	conn, ok := m.getConn(ctx, connID)
-	m.mu.RUnlock()
+	m.mu.Unlock()
 	if !ok {
+		if supervised {
+			// The supervisor was between reconnect attempts.
+			m.RemoveConn(ctx, connID)
+			return nil
+		}
 		return ErrNotConnected
 	}
 	defer m.RemoveConn(ctx, connID)
@@ -261,7 +315,12 @@ func (m *ConnManager) Disconnect(ctx context.Context, connID string) error {
 func (m *ConnManager) RemoveConn(ctx context.Context, connID string) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
+	if sv, ok := m.supervisors[NamespacedConnFromContext(ctx, connID)]; ok {
+		sv.stop()
+		delete(m.supervisors, NamespacedConnFromContext(ctx, connID))
+	}
 	delete(m.conns, NamespacedConnFromContext(ctx, connID))
+	m.events.publish(NamespacedConnFromContext(ctx, connID), v1.DaemonConnStatus_DISCONNECTED)
 	delete(m.ports, m.portByConnID(ctx, connID))
 	if runtime.GOOS == "darwin" {
 		delete(m.utuns, m.utunByConnID(ctx, connID))
@@ -346,8 +405,8 @@ func (m *ConnManager) assignUTUNIndex(ctx context.Context, connID string) (uint1
 	}
 }
 
-func (m *ConnManager) buildConnConfig(ctx context.Context, req *v1.ConnectionParameters, connID string, listenPort uint16) (*config.Config, error) {
-	conf := config.NewDefaultConfig(m.nodeID.String())
+func (m *ConnManager) buildConnConfig(ctx context.Context, req *v1.ConnectionParameters, nodeID types.NodeID, connID string, listenPort uint16) (*config.Config, error) {
+	conf := config.NewDefaultConfig(nodeID.String())
 	conf.Global.LogLevel = m.conf.LogLevel
 	conf.Global.LogFormat = m.conf.LogFormat
 	conf.Storage.LogLevel = m.conf.LogLevel
@@ -356,6 +415,7 @@ func (m *ConnManager) buildConnConfig(ctx context.Context, req *v1.ConnectionPar
 	if m.conf.Persistence.Path != "" {
 		conf.Storage.InMemory = false
 		conf.Storage.Path = m.DataDir(connID)
+		conf.Storage.Encryption = m.conf.Persistence.Encryption
 	}
 	conf.WireGuard.ListenPort = int(listenPort)
 	var eps endpoints.PrefixList
@@ -407,7 +467,7 @@ func (m *ConnManager) buildConnConfig(ctx context.Context, req *v1.ConnectionPar
 	conf.Mesh.UseMeshDNS = req.GetNetworking().GetUseDNS()
 	conf.Bootstrap.Enabled = req.GetBootstrap().GetEnabled()
 	if conf.Bootstrap.Enabled {
-		conf.Bootstrap.Admin = m.nodeID.String()
+		conf.Bootstrap.Admin = nodeID.String()
 		conf.Bootstrap.DisableRBAC = !req.GetBootstrap().GetRbacEnabled()
 		conf.Bootstrap.IPv4Network = storage.DefaultIPv4Network
 		conf.Bootstrap.MeshDomain = storage.DefaultMeshDomain
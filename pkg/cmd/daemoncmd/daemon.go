@@ -52,7 +52,7 @@ import (
 func Run(ctx context.Context, conf Config) error {
 	log := conf.NewLogger()
 	// Setup the listener
-	listener, err := newListener(conf.Bind, conf.InsecureSocket)
+	listener, err := newListener(log, conf.Bind, conf.InsecureSocket)
 	if err != nil {
 		return err
 	}
@@ -63,12 +63,18 @@ func Run(ctx context.Context, conf Config) error {
 		return err
 	}
 	defer srv.Close()
+	auth, err := newAuthorizer(conf)
+	if err != nil {
+		return err
+	}
 	unarymiddlewares := []grpc.UnaryServerInterceptor{
 		context.LogInjectUnaryServerInterceptor(log.With("appdaemon", "grpc")),
+		auth.UnaryInterceptor(),
 		logging.ContextUnaryServerInterceptor(),
 	}
 	streammiddlewares := []grpc.StreamServerInterceptor{
 		context.LogInjectStreamServerInterceptor(log.With("appdaemon", "grpc")),
+		auth.StreamInterceptor(),
 		logging.ContextStreamServerInterceptor(),
 	}
 	grpcServer := grpc.NewServer(
@@ -76,6 +82,9 @@ func Run(ctx context.Context, conf Config) error {
 		grpc.ChainStreamInterceptor(streammiddlewares...),
 	)
 	v1.RegisterAppDaemonServer(grpcServer, srv)
+	if err := RegisterAppDaemonEventsServer(grpcServer, srv); err != nil {
+		return fmt.Errorf("register events service: %w", err)
+	}
 	reflection.Register(grpcServer)
 	// Time to go to work
 	sig := make(chan os.Signal, 1)
@@ -88,6 +97,7 @@ func Run(ctx context.Context, conf Config) error {
 		case <-ctx.Done():
 		}
 	}()
+	go srv.AutoConnect(ctx)
 	if conf.UI.Enabled {
 		go runWebUI(ctx, log, listener, conf.UI.ListenAddress)
 	}
@@ -131,7 +141,8 @@ func runGRPCWebServer(ctx context.Context, log *slog.Logger, srv *grpc.Server, l
 		srv.ServeHTTP(resp, req)
 	})
 	httpSrv := &http.Server{
-		Handler: h2c.NewHandler(handler, &http2.Server{}),
+		Handler:     h2c.NewHandler(handler, &http2.Server{}),
+		ConnContext: connContextWithPeerCreds,
 	}
 	go func() {
 		<-ctx.Done()
@@ -196,17 +207,17 @@ func runWebUI(ctx context.Context, log *slog.Logger, srvln net.Listener, laddr s
 	}
 }
 
-func newListener(bindAddr string, insecure bool) (net.Listener, error) {
+func newListener(log *slog.Logger, bindAddr string, insecure bool) (net.Listener, error) {
 	if bindAddr == "" {
 		bindAddr = DefaultDaemonSocket()
 	}
 	switch {
 	case strings.HasPrefix(bindAddr, "/"), strings.HasPrefix(bindAddr, "\\\\"):
 		// Unix socket
-		return newUnixSocket(bindAddr, insecure)
+		return newUnixSocket(log, bindAddr, insecure)
 	case strings.HasPrefix(bindAddr, "unix://"):
 		// Unix socket
-		return newUnixSocket(bindAddr[7:], insecure)
+		return newUnixSocket(log, bindAddr[7:], insecure)
 	case strings.HasPrefix(bindAddr, "tcp://"):
 		// TCP socket
 		return net.Listen("tcp", bindAddr[6:])
@@ -216,7 +227,7 @@ func newListener(bindAddr string, insecure bool) (net.Listener, error) {
 	}
 }
 
-func newUnixSocket(socketPath string, insecure bool) (net.Listener, error) {
+func newUnixSocket(log *slog.Logger, socketPath string, insecure bool) (net.Listener, error) {
 	if runtime.GOOS != "windows" {
 		// Ensure the socket directory exists.
 		sockDir := filepath.Dir(socketPath)
@@ -248,5 +259,9 @@ func newUnixSocket(socketPath string, insecure bool) (net.Listener, error) {
 			return nil, err
 		}
 	}
-	return net.Listen("unix", socketPath)
+	ln, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return nil, err
+	}
+	return &peerCredListener{Listener: ln, log: log}, nil
 }
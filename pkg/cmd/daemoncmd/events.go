@@ -0,0 +1,239 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package daemoncmd
+
+import (
+	"sync"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/webmeshproj/webmesh/pkg/common"
+	"github.com/webmeshproj/webmesh/pkg/context"
+)
+
+// ConnEvent is a status transition of a daemon connection.
+type ConnEvent struct {
+	// Conn is the connection that changed.
+	Conn NamespacedConn
+	// Status is the new status of the connection.
+	Status v1.DaemonConnStatus
+}
+
+// connEventBuffer is the number of events buffered for each subscriber. Events
+// for subscribers that fall further behind are dropped.
+const connEventBuffer = 64
+
+type connEvents struct {
+	subs map[chan ConnEvent]struct{}
+	mu   sync.Mutex
+}
+
+func newConnEvents() *connEvents {
+	return &connEvents{subs: make(map[chan ConnEvent]struct{})}
+}
+
+func (e *connEvents) publish(conn NamespacedConn, status v1.DaemonConnStatus) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for sub := range e.subs {
+		select {
+		case sub <- ConnEvent{Conn: conn, Status: status}:
+		default:
+		}
+	}
+}
+
+func (e *connEvents) subscribe() (<-chan ConnEvent, func()) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	ch := make(chan ConnEvent, connEventBuffer)
+	e.subs[ch] = struct{}{}
+	return ch, func() {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		delete(e.subs, ch)
+	}
+}
+
+// Subscribe returns a channel of connection status transitions for all namespaces
+// and a function to cancel the subscription.
+func (m *ConnManager) Subscribe() (<-chan ConnEvent, context.CancelFunc) {
+	return m.events.subscribe()
+}
+
+// AppDaemonEventsServiceName is the fully qualified name of the app daemon events service.
+const AppDaemonEventsServiceName = "v1.AppDaemonEvents"
+
+// SubscribeConnectionStatusFullMethodName is the full method name for SubscribeConnectionStatus.
+const SubscribeConnectionStatusFullMethodName = "/" + AppDaemonEventsServiceName + "/SubscribeConnectionStatus"
+
+// AppDaemonEventsServer is the server API for streaming app daemon events.
+type AppDaemonEventsServer interface {
+	// SubscribeConnectionStatus streams status transitions for the requested
+	// connections, or for all connections in the caller's namespace if none are
+	// requested. The current status of each connection is sent first. Each
+	// response contains only the connections that changed.
+	SubscribeConnectionStatus(*v1.ListConnectionsRequest, ConnectionStatusServerStream) error
+}
+
+// ConnectionStatusServerStream is the server stream for SubscribeConnectionStatus.
+type ConnectionStatusServerStream interface {
+	Send(*v1.ListConnectionsResponse) error
+	grpc.ServerStream
+}
+
+// AppDaemonEventsServiceDesc is the grpc.ServiceDesc for the app daemon events service.
+var AppDaemonEventsServiceDesc = grpc.ServiceDesc{
+	ServiceName: AppDaemonEventsServiceName,
+	HandlerType: (*AppDaemonEventsServer)(nil),
+	Methods:     []grpc.MethodDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "SubscribeConnectionStatus",
+			Handler:       subscribeConnectionStatusHandler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "v1/app_events.proto",
+}
+
+// RegisterAppDaemonEventsServer registers the app daemon events service with the given registrar.
+func RegisterAppDaemonEventsServer(s grpc.ServiceRegistrar, srv AppDaemonEventsServer) error {
+	err := common.RegisterServiceFile(&AppDaemonEventsServiceDesc, common.ServiceMethod{
+		Name:            "SubscribeConnectionStatus",
+		Input:           &v1.ListConnectionsRequest{},
+		Output:          &v1.ListConnectionsResponse{},
+		ServerStreaming: true,
+	})
+	if err != nil {
+		return err
+	}
+	s.RegisterService(&AppDaemonEventsServiceDesc, srv)
+	return nil
+}
+
+func subscribeConnectionStatusHandler(srv any, stream grpc.ServerStream) error {
+	var req v1.ListConnectionsRequest
+	if err := stream.RecvMsg(&req); err != nil {
+		return err
+	}
+	return srv.(AppDaemonEventsServer).SubscribeConnectionStatus(&req, &connectionStatusServerStream{stream})
+}
+
+type connectionStatusServerStream struct {
+	grpc.ServerStream
+}
+
+func (s *connectionStatusServerStream) Send(resp *v1.ListConnectionsResponse) error {
+	return s.ServerStream.SendMsg(resp)
+}
+
+// ConnectionStatusClientStream is the client stream for SubscribeConnectionStatus.
+type ConnectionStatusClientStream interface {
+	Recv() (*v1.ListConnectionsResponse, error)
+	grpc.ClientStream
+}
+
+// SubscribeConnectionStatus subscribes to connection status transitions on the
+// app daemon at the other end of the given connection.
+func SubscribeConnectionStatus(ctx context.Context, cc grpc.ClientConnInterface, req *v1.ListConnectionsRequest, opts ...grpc.CallOption) (ConnectionStatusClientStream, error) {
+	stream, err := cc.NewStream(ctx, &AppDaemonEventsServiceDesc.Streams[0], SubscribeConnectionStatusFullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &connectionStatusClientStream{stream}
+	if err := x.ClientStream.SendMsg(req); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type connectionStatusClientStream struct {
+	grpc.ClientStream
+}
+
+func (x *connectionStatusClientStream) Recv() (*v1.ListConnectionsResponse, error) {
+	m := new(v1.ListConnectionsResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// SubscribeConnectionStatus implements AppDaemonEventsServer.
+func (app *AppDaemon) SubscribeConnectionStatus(req *v1.ListConnectionsRequest, stream ConnectionStatusServerStream) error {
+	ctx := stream.Context()
+	err := app.validator.Validate(req)
+	if err != nil {
+		return newInvalidError(err)
+	}
+	// Subscribe before reading the current state so no transitions are missed.
+	events, cancel := app.connmgr.Subscribe()
+	defer cancel()
+	ids := make(map[string]struct{})
+	for _, id := range req.GetIds() {
+		ids[id] = struct{}{}
+	}
+	if len(ids) == 0 {
+		profileIDs, err := app.connmgr.Profiles().ListProfileIDs(ctx)
+		if err != nil {
+			return status.Errorf(codes.Internal, "failed to list connections: %v", err)
+		}
+		for _, id := range profileIDs {
+			ids[id.String()] = struct{}{}
+		}
+	}
+	initial := &v1.ListConnectionsResponse{
+		Connections: make(map[string]*v1.GetConnectionResponse),
+	}
+	for id := range ids {
+		initial.Connections[id] = &v1.GetConnectionResponse{
+			Status: app.connmgr.GetStatus(ctx, id),
+		}
+	}
+	if err := stream.Send(initial); err != nil {
+		return err
+	}
+	namespace := NamespaceFromContext(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case ev := <-events:
+			if ev.Conn.Namespace != namespace {
+				continue
+			}
+			if _, ok := ids[ev.Conn.ConnID]; !ok && len(req.GetIds()) > 0 {
+				continue
+			}
+			err := stream.Send(&v1.ListConnectionsResponse{
+				Connections: map[string]*v1.GetConnectionResponse{
+					ev.Conn.ConnID: {Status: ev.Status},
+				},
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,106 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package daemoncmd
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+
+	"google.golang.org/grpc/peer"
+)
+
+// PeerCreds are the credentials of a process connected over a unix socket.
+type PeerCreds struct {
+	// UID is the user ID of the process.
+	UID uint32
+	// GID is the primary group ID of the process.
+	GID uint32
+	// PID is the process ID. It is zero on platforms that do not report it.
+	PID int32
+}
+
+type peerCredsKey struct{}
+
+// PeerCredsFromContext returns the peer credentials of the client for the current
+// context. It returns false if the client did not connect over a unix socket or
+// the credentials could not be determined.
+func PeerCredsFromContext(ctx context.Context) (PeerCreds, bool) {
+	if p, ok := peer.FromContext(ctx); ok {
+		if addr, ok := p.Addr.(peerCredAddr); ok {
+			return addr.creds, true
+		}
+	}
+	creds, ok := ctx.Value(peerCredsKey{}).(PeerCreds)
+	return creds, ok
+}
+
+// peerCredListener is a unix socket listener that records the credentials of the
+// peer for every accepted connection.
+type peerCredListener struct {
+	net.Listener
+	log *slog.Logger
+}
+
+func (l *peerCredListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	uc, ok := c.(*net.UnixConn)
+	if !ok {
+		return c, nil
+	}
+	creds, err := getPeerCreds(uc)
+	if err != nil {
+		// Don't fail the accept, calls will be rejected if a policy is configured.
+		l.log.Debug("Could not determine peer credentials", "error", err.Error())
+		return c, nil
+	}
+	return &peerCredConn{Conn: c, creds: creds}, nil
+}
+
+// peerCredConn is a connection with known peer credentials. The credentials are
+// exposed to gRPC through the remote address.
+type peerCredConn struct {
+	net.Conn
+	creds PeerCreds
+}
+
+func (c *peerCredConn) RemoteAddr() net.Addr {
+	return peerCredAddr{creds: c.creds}
+}
+
+type peerCredAddr struct {
+	creds PeerCreds
+}
+
+func (a peerCredAddr) Network() string { return "unix" }
+
+func (a peerCredAddr) String() string {
+	return fmt.Sprintf("uid=%d,gid=%d,pid=%d", a.creds.UID, a.creds.GID, a.creds.PID)
+}
+
+// connContextWithPeerCreds is used as the ConnContext of HTTP servers so that peer
+// credentials are available to gRPC-Web requests.
+func connContextWithPeerCreds(ctx context.Context, c net.Conn) context.Context {
+	if pc, ok := c.(*peerCredConn); ok {
+		return context.WithValue(ctx, peerCredsKey{}, pc.creds)
+	}
+	return ctx
+}
@@ -0,0 +1,53 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package daemoncmd
+
+import (
+	"fmt"
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+// peerCredsSupported is true because the credentials of unix socket peers are
+// available through LOCAL_PEERCRED.
+const peerCredsSupported = true
+
+// getPeerCreds returns the credentials of the process on the other end of the
+// given unix socket using LOCAL_PEERCRED. The PID is not available.
+func getPeerCreds(conn *net.UnixConn) (PeerCreds, error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return PeerCreds{}, fmt.Errorf("get raw connection: %w", err)
+	}
+	var cred *unix.Xucred
+	var credErr error
+	err = raw.Control(func(fd uintptr) {
+		cred, credErr = unix.GetsockoptXucred(int(fd), unix.SOL_LOCAL, unix.LOCAL_PEERCRED)
+	})
+	if err != nil {
+		return PeerCreds{}, fmt.Errorf("control raw connection: %w", err)
+	}
+	if credErr != nil {
+		return PeerCreds{}, fmt.Errorf("get peer credentials: %w", credErr)
+	}
+	creds := PeerCreds{UID: cred.Uid}
+	if cred.Ngroups > 0 {
+		creds.GID = cred.Groups[0]
+	}
+	return creds, nil
+}
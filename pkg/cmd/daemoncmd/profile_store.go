@@ -22,6 +22,7 @@ import (
 
 	"github.com/webmeshproj/webmesh/pkg/context"
 	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/encryption"
 	"github.com/webmeshproj/webmesh/pkg/storage/providers/backends/badgerdb"
 )
 
@@ -33,6 +34,8 @@ type ProfileStore interface {
 	Get(ctx context.Context, id ProfileID) (Profile, error)
 	// List lists all profiles.
 	List(ctx context.Context) (Profiles, error)
+	// ListAllNamespaces lists all profiles in every namespace, keyed by namespace.
+	ListAllNamespaces(ctx context.Context) (map[string]Profiles, error)
 	// ListProfileIDs lists all profile IDs.
 	ListProfileIDs(ctx context.Context) (ProfileIDs, error)
 	// Delete deletes a profile.
@@ -42,14 +45,16 @@ type ProfileStore interface {
 }
 
 // NewProfileStore returns a new ProfileStore. If diskPath is an empty
-// string, an in-memory store is returned.
-func NewProfileStore(diskPath string) (ProfileStore, error) {
+// string, an in-memory store is returned. Profiles on disk are encrypted
+// with the given keys when they are set.
+func NewProfileStore(diskPath string, keys encryption.Keys) (ProfileStore, error) {
 	var st storage.MeshStorage
 	var err error
 	if diskPath != "" {
 		st, err = badgerdb.New(badgerdb.Options{
 			DiskPath:   diskPath,
 			SyncWrites: true,
+			Encryption: keys,
 		})
 	} else {
 		st, err = badgerdb.NewInMemory(badgerdb.Options{})
@@ -113,6 +118,29 @@ func (s *profileStore) List(ctx context.Context) (Profiles, error) {
 	return profiles, nil
 }
 
+func (s *profileStore) ListAllNamespaces(ctx context.Context) (map[string]Profiles, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	namespaces := make(map[string]Profiles)
+	err := s.st.IterPrefix(ctx, ProfilesPrefix, func(key, value []byte) error {
+		var profile Profile
+		err := profile.UnmarshalProto(value)
+		if err != nil {
+			return fmt.Errorf("unmarshal profile: %w", err)
+		}
+		namespace := NamespaceFromKey(key)
+		if _, ok := namespaces[namespace]; !ok {
+			namespaces[namespace] = make(Profiles)
+		}
+		namespaces[namespace][ProfileIDFromKey(key)] = profile
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("iterate profiles: %w", err)
+	}
+	return namespaces, nil
+}
+
 func (s *profileStore) ListProfileIDs(ctx context.Context) (ProfileIDs, error) {
 	var ids ProfileIDs
 	keys, err := s.st.ListKeys(ctx, NamespacedPrefixFromContext(ctx))
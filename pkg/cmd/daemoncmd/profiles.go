@@ -18,13 +18,16 @@ package daemoncmd
 
 import (
 	"bytes"
+	"fmt"
 
 	v1 "github.com/webmeshproj/api/go/v1"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/protobuf/encoding/protojson"
 	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
 
 	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/crypto"
 )
 
 // DaemonNamespaceHeader is the header used to set the namespace for daemon
@@ -36,7 +39,6 @@ const DaemonNamespaceHeader = "x-webmesh-namespace"
 const DefaultNamespace = "global"
 
 // NamespaceFromContext returns the namespace for the current context.
-
 func NamespaceFromContext(ctx context.Context) string {
 	md, ok := metadata.FromIncomingContext(ctx)
 	if !ok {
@@ -48,6 +50,13 @@ func NamespaceFromContext(ctx context.Context) string {
 	return md[DaemonNamespaceHeader][0]
 }
 
+// ContextWithNamespace returns a context that is treated as an incoming
+// request for the given namespace. This is used for operations the daemon
+// performs on behalf of a namespace, such as auto-connecting profiles.
+func ContextWithNamespace(ctx context.Context, namespace string) context.Context {
+	return metadata.NewIncomingContext(ctx, metadata.Pairs(DaemonNamespaceHeader, namespace))
+}
+
 // ProfileID is a profile ID.
 type ProfileID string
 
@@ -57,6 +66,15 @@ func ProfileIDFromKey(key []byte) ProfileID {
 	return ProfileID(spl[len(spl)-1])
 }
 
+// NamespaceFromKey returns the namespace from the storage key.
+func NamespaceFromKey(key []byte) string {
+	spl := bytes.Split(bytes.TrimPrefix(key, ProfilesPrefix), []byte("/"))
+	if len(spl) < 3 {
+		return DefaultNamespace
+	}
+	return string(spl[1])
+}
+
 // String returns the string representation of the profile ID.
 func (id ProfileID) String() string {
 	return string(id)
@@ -122,6 +140,52 @@ type Profile struct {
 	*v1.PutConnectionRequest
 }
 
+// AutoConnectMetadataKey is the metadata key for flagging a profile to be
+// connected when the daemon starts.
+const AutoConnectMetadataKey = "autoConnect"
+
+// AutoConnect returns true if the profile should be connected when the daemon
+// starts.
+func (p Profile) AutoConnect() bool {
+	v, ok := p.GetMetadata().GetFields()[AutoConnectMetadataKey]
+	return ok && v.GetBoolValue()
+}
+
+// IDKeyMetadataKey is the metadata key for an encoded private key to use for
+// connections with the profile instead of the daemon's key. This allows profiles
+// using ID authentication to be moved between machines.
+const IDKeyMetadataKey = "idKey"
+
+// IDKey returns the private key stored with the profile, or nil if the profile
+// uses the daemon's key.
+func (p Profile) IDKey() (crypto.PrivateKey, error) {
+	v, ok := p.GetMetadata().GetFields()[IDKeyMetadataKey]
+	if !ok || v.GetStringValue() == "" {
+		return nil, nil
+	}
+	key, err := crypto.DecodePrivateKey(v.GetStringValue())
+	if err != nil {
+		return nil, fmt.Errorf("decode id key: %w", err)
+	}
+	return key, nil
+}
+
+// PublicMetadata returns the metadata of the profile without the ID key, for
+// returning the profile to clients.
+func (p Profile) PublicMetadata() *structpb.Struct {
+	fields := p.GetMetadata().GetFields()
+	if _, ok := fields[IDKeyMetadataKey]; !ok {
+		return p.GetMetadata()
+	}
+	public := &structpb.Struct{Fields: make(map[string]*structpb.Value, len(fields)-1)}
+	for k, v := range fields {
+		if k != IDKeyMetadataKey {
+			public.Fields[k] = v
+		}
+	}
+	return public
+}
+
 // MarshalJSON marshals the profile to JSON.
 func (p Profile) MarshalJSON() ([]byte, error) {
 	return protojson.Marshal(p.PutConnectionRequest)
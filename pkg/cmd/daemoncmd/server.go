@@ -64,6 +64,29 @@ func NewServer(conf Config) (*AppDaemon, error) {
 	}, nil
 }
 
+// AutoConnect connects all profiles in all namespaces that are marked for
+// auto-connect. Failures are logged and do not stop other profiles from connecting.
+func (app *AppDaemon) AutoConnect(ctx context.Context) {
+	namespaces, err := app.connmgr.Profiles().ListAllNamespaces(ctx)
+	if err != nil {
+		app.log.Error("Failed to list profiles for auto-connect", "error", err.Error())
+		return
+	}
+	for namespace, profiles := range namespaces {
+		for _, profile := range profiles {
+			if !profile.AutoConnect() {
+				continue
+			}
+			log := app.log.With("namespace", namespace, "id", profile.GetId())
+			log.Info("Auto-connecting profile")
+			_, err := app.Connect(ContextWithNamespace(ctx, namespace), &v1.ConnectRequest{Id: profile.GetId()})
+			if err != nil {
+				log.Error("Failed to auto-connect profile", "error", err.Error())
+			}
+		}
+	}
+}
+
 func (app *AppDaemon) Close() error {
 	return app.connmgr.Close()
 }
@@ -83,6 +106,7 @@ func (app *AppDaemon) Connect(ctx context.Context, req *v1.ConnectRequest) (*v1.
 		defer app.connmgr.RemoveConn(ctx, connID)
 		return nil, status.Errorf(codes.Internal, "failed to start node: %v", err)
 	}
+	app.connmgr.Supervise(ctx, connID)
 	return &v1.ConnectResponse{
 		Id:          connID,
 		NodeID:      string(node.MeshNode().ID()),
@@ -199,7 +223,7 @@ func (app *AppDaemon) GetConnection(ctx context.Context, req *v1.GetConnectionRe
 	resp := &v1.GetConnectionResponse{
 		Status:     app.connmgr.GetStatus(ctx, req.GetId()),
 		Parameters: conn.GetParameters(),
-		Metadata:   conn.GetMetadata(),
+		Metadata:   conn.PublicMetadata(),
 	}
 	if resp.Status == v1.DaemonConnStatus_CONNECTED {
 		if c, ok := app.connmgr.Get(ctx, req.GetId()); ok {
@@ -264,7 +288,7 @@ func (app *AppDaemon) ListConnections(ctx context.Context, req *v1.ListConnectio
 		conndetails := &v1.GetConnectionResponse{
 			Status:     app.connmgr.GetStatus(ctx, id.String()),
 			Parameters: profile.GetParameters(),
-			Metadata:   profile.GetMetadata(),
+			Metadata:   profile.PublicMetadata(),
 		}
 		if conndetails.Status == v1.DaemonConnStatus_CONNECTED {
 			if c, ok := app.connmgr.Get(ctx, id.String()); ok {
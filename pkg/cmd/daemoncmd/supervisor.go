@@ -0,0 +1,318 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package daemoncmd
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/embed"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/system/routes"
+	storageerrors "github.com/webmeshproj/webmesh/pkg/storage/errors"
+)
+
+// networkChangeDebounce is how long to wait for network changes to settle
+// before re-joining connections.
+const networkChangeDebounce = time.Second * 2
+
+// healthCheckTimeout is the timeout for a single connection health check.
+const healthCheckTimeout = time.Second * 10
+
+// supervisor watches a single connection and reconnects it when it is lost.
+type supervisor struct {
+	conn         NamespacedConn
+	netchanges   chan struct{}
+	reconnecting atomic.Bool
+	cancel       context.CancelFunc
+	once         sync.Once
+}
+
+func (s *supervisor) stop() {
+	s.once.Do(s.cancel)
+}
+
+// notifyNetworkChange signals the supervisor that the network changed. It does not block.
+func (s *supervisor) notifyNetworkChange() {
+	select {
+	case s.netchanges <- struct{}{}:
+	default:
+	}
+}
+
+// Supervise starts watching the connection with the given ID. If the connection
+// is lost, or the network changes, it is re-created and re-joined with exponential
+// backoff until it succeeds or the connection is disconnected.
+func (m *ConnManager) Supervise(ctx context.Context, connID string) {
+	if m.conf.Reconnect.Disabled {
+		m.events.publish(NamespacedConnFromContext(ctx, connID), v1.DaemonConnStatus_CONNECTED)
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	nsconn := NamespacedConnFromContext(ctx, connID)
+	if sv, ok := m.supervisors[nsconn]; ok {
+		sv.stop()
+	}
+	// The supervisor outlives the request that created it, but keeps its namespace.
+	svctx, cancel := context.WithCancel(ContextWithNamespace(context.WithLogger(context.Background(), m.log), nsconn.Namespace))
+	sv := &supervisor{
+		conn:       nsconn,
+		netchanges: make(chan struct{}, 1),
+		cancel:     cancel,
+	}
+	m.supervisors[nsconn] = sv
+	m.events.publish(nsconn, v1.DaemonConnStatus_CONNECTED)
+	go m.runSupervisor(svctx, sv)
+}
+
+func (m *ConnManager) runSupervisor(ctx context.Context, sv *supervisor) {
+	log := m.log.With("connection-id", sv.conn.ConnID, "namespace", sv.conn.Namespace)
+	t := time.NewTicker(m.conf.Reconnect.CheckInterval)
+	defer t.Stop()
+	for {
+		m.mu.RLock()
+		node, ok := m.conns[sv.conn]
+		m.mu.RUnlock()
+		if !ok {
+			return
+		}
+		var reason string
+		select {
+		case <-ctx.Done():
+			return
+		case err := <-node.Errors():
+			reason = fmt.Sprintf("node error: %v", err)
+		case <-sv.netchanges:
+			reason = "network changed"
+		case <-t.C:
+			err := m.checkHealth(ctx, node)
+			if err == nil {
+				continue
+			}
+			reason = fmt.Sprintf("health check failed: %v", err)
+		}
+		log.Warn("Connection lost, reconnecting", "reason", reason)
+		if err := m.reconnect(ctx, sv); err != nil {
+			// Only returns an error when the supervisor was stopped.
+			log.Debug("Stopped reconnecting", "error", err.Error())
+			return
+		}
+		log.Info("Connection re-established")
+	}
+}
+
+func (m *ConnManager) checkHealth(ctx context.Context, node embed.Node) error {
+	if !node.MeshNode().Started() {
+		return errors.New("node is not started")
+	}
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+	errs := make(chan error, 1)
+	go func() {
+		_, err := node.MeshNode().LeaderID()
+		errs <- err
+	}()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case err := <-errs:
+		return err
+	}
+}
+
+// reconnect stops the current node for the supervised connection and re-creates it
+// until it starts successfully. It only returns an error if the context is canceled.
+func (m *ConnManager) reconnect(ctx context.Context, sv *supervisor) error {
+	sv.reconnecting.Store(true)
+	defer sv.reconnecting.Store(false)
+	m.events.publish(sv.conn, v1.DaemonConnStatus_CONNECTING)
+	log := m.log.With("connection-id", sv.conn.ConnID, "namespace", sv.conn.Namespace)
+	m.mu.RLock()
+	old, ok := m.conns[sv.conn]
+	m.mu.RUnlock()
+	if ok {
+		if err := old.Stop(ctx); err != nil {
+			log.Warn("Failed to stop lost connection", "error", err.Error())
+		}
+		m.dropNode(sv.conn, old)
+	}
+	backoff := m.conf.Reconnect.InitialBackoff
+	for attempt := 1; ; attempt++ {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		node, err := m.rebuildConn(ctx, sv.conn.ConnID)
+		if err == nil {
+			err = node.Start(ctx)
+			if err == nil {
+				if ctx.Err() != nil {
+					// We were disconnected while starting.
+					_ = node.Stop(context.WithLogger(context.Background(), m.log))
+					m.dropNode(sv.conn, node)
+					return ctx.Err()
+				}
+				m.events.publish(sv.conn, v1.DaemonConnStatus_CONNECTED)
+				return nil
+			}
+			// Don't leave a node that never started behind for callers to use.
+			m.dropNode(sv.conn, node)
+		}
+		if status.Code(err) == codes.NotFound {
+			// The profile was removed, there is nothing to reconnect to.
+			m.RemoveConn(ctx, sv.conn.ConnID)
+			return err
+		}
+		// Add up to 20% jitter so reconnecting clients don't move in lockstep.
+		wait := backoff + time.Duration(rand.Int63n(int64(backoff)/5+1))
+		log.Warn("Reconnect attempt failed", "attempt", attempt, "error", err.Error(), "retry-in", wait.String())
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+		backoff *= 2
+		if backoff > m.conf.Reconnect.MaxBackoff {
+			backoff = m.conf.Reconnect.MaxBackoff
+		}
+	}
+}
+
+// dropNode removes the given node for the connection if it is still the current one.
+func (m *ConnManager) dropNode(conn NamespacedConn, node embed.Node) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if current, ok := m.conns[conn]; ok && current == node {
+		delete(m.conns, conn)
+	}
+}
+
+// rebuildConn re-creates the node for an existing connection with a freshly generated
+// configuration, re-using the connection's listen port and interface. Start must be
+// called on the returned node.
+func (m *ConnManager) rebuildConn(ctx context.Context, connID string) (embed.Node, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	port := m.portByConnID(ctx, connID)
+	if port == 0 {
+		var err error
+		port, err = m.assignListenPort(ctx, connID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	profile, err := m.profiles.Get(ctx, ProfileID(connID))
+	if err != nil {
+		if storageerrors.IsNotFound(err) {
+			return nil, status.Errorf(codes.NotFound, "profile not found")
+		}
+		return nil, fmt.Errorf("get profile: %w", err)
+	}
+	if index := m.utunByConnID(ctx, connID); index != 0 {
+		// Release the index so the same one is assigned again.
+		delete(m.utuns, index)
+	}
+	nodeID, key, err := m.identityFor(profile)
+	if err != nil {
+		return nil, fmt.Errorf("get profile identity: %w", err)
+	}
+	cfg, err := m.buildConnConfig(ctx, profile.GetParameters(), nodeID, connID, port)
+	if err != nil {
+		return nil, err
+	}
+	node, err := m.newNode(ctx, embed.Options{
+		Config: cfg,
+		Key:    key,
+		Logger: m.log.With("connection-id", connID),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("create node: %w", err)
+	}
+	m.conns[NamespacedConnFromContext(ctx, connID)] = node
+	return node, nil
+}
+
+// watchNetwork watches for changes to the system network configuration and notifies
+// supervisors so connections re-detect their endpoints and re-join.
+func (m *ConnManager) watchNetwork(ctx context.Context) {
+	changes, err := routes.WatchChanges(ctx)
+	if err != nil {
+		if errors.Is(err, routes.ErrWatchNotSupported) {
+			m.log.Debug("Not watching for network changes", "error", err.Error())
+			return
+		}
+		m.log.Error("Failed to watch for network changes", "error", err.Error())
+		return
+	}
+	var debounce <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case change, ok := <-changes:
+			if !ok {
+				return
+			}
+			if m.isOwnChange(change) {
+				continue
+			}
+			m.log.Debug("Detected network change", "type", change.Type, "removed", change.Removed, "addr", change.Addr.String())
+			debounce = time.After(networkChangeDebounce)
+		case <-debounce:
+			debounce = nil
+			m.log.Info("Network changed, re-joining connections")
+			m.mu.RLock()
+			for _, sv := range m.supervisors {
+				sv.notifyNetworkChange()
+			}
+			m.mu.RUnlock()
+		}
+	}
+}
+
+// isOwnChange returns true if the change was caused by one of our own interfaces
+// or is otherwise irrelevant for endpoint detection.
+func (m *ConnManager) isOwnChange(change routes.Change) bool {
+	if change.Addr.IsValid() && (change.Addr.IsLinkLocalUnicast() || change.Addr.IsLoopback()) {
+		return true
+	}
+	m.mu.RLock()
+	names := m.listInterfaceNames()
+	m.mu.RUnlock()
+	for _, name := range names {
+		iface, err := net.InterfaceByName(name)
+		if err != nil {
+			continue
+		}
+		if iface.Index == change.LinkIndex {
+			return true
+		}
+	}
+	return change.Type == routes.AddressChange && !change.Addr.IsValid()
+}
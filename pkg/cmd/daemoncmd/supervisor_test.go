@@ -0,0 +1,242 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package daemoncmd
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/embed"
+)
+
+func TestReconnect(t *testing.T) {
+	t.Parallel()
+
+	t.Run("ReplacesLostNode", func(t *testing.T) {
+		t.Parallel()
+		ctx := context.Background()
+		m, nodes := newTestConnManager(t)
+		lost := newFakeNode(nil)
+		m.conns[NamespacedConnFromContext(ctx, "office")] = lost
+		events, cancel := m.Subscribe()
+		defer cancel()
+
+		replacement := newFakeNode(nil)
+		nodes.push(replacement)
+		m.Supervise(ctx, "office")
+		expectEvent(t, events, v1.DaemonConnStatus_CONNECTED)
+		lost.errs <- errors.New("lost")
+		expectEvent(t, events, v1.DaemonConnStatus_CONNECTING)
+		expectEvent(t, events, v1.DaemonConnStatus_CONNECTED)
+		if !lost.stopped.Load() {
+			t.Fatal("expected the lost node to be stopped")
+		}
+		if node, ok := m.Get(ctx, "office"); !ok || node != replacement {
+			t.Fatal("expected the lost node to be replaced")
+		}
+
+		if err := m.Disconnect(ctx, "office"); err != nil {
+			t.Fatal(err)
+		}
+		expectEvent(t, events, v1.DaemonConnStatus_DISCONNECTED)
+		if !replacement.stopped.Load() {
+			t.Fatal("expected the replacement node to be stopped on disconnect")
+		}
+	})
+
+	t.Run("DropsNodesThatFailToStart", func(t *testing.T) {
+		t.Parallel()
+		ctx := context.Background()
+		m, nodes := newTestConnManager(t)
+		conn := NamespacedConnFromContext(ctx, "office")
+		m.conns[conn] = newFakeNode(nil)
+		events, cancel := m.Subscribe()
+		defer cancel()
+
+		failed := newFakeNode(errors.New("failed to join"))
+		started := newFakeNode(nil)
+		nodes.push(failed, started)
+		var leftBehind bool
+		nodes.onCreate = func(created int) {
+			// Called with the manager locked before the new node is stored.
+			if created == 2 {
+				_, leftBehind = m.conns[conn]
+			}
+		}
+		if err := m.reconnect(ctx, &supervisor{conn: conn}); err != nil {
+			t.Fatal(err)
+		}
+		if leftBehind {
+			t.Fatal("expected the node that failed to start to be removed")
+		}
+		expectEvent(t, events, v1.DaemonConnStatus_CONNECTING)
+		expectEvent(t, events, v1.DaemonConnStatus_CONNECTED)
+		if node, ok := m.Get(ctx, "office"); !ok || node != started {
+			t.Fatal("expected the started node to be the current connection")
+		}
+	})
+
+	t.Run("DisconnectWhileReconnecting", func(t *testing.T) {
+		t.Parallel()
+		ctx := context.Background()
+		m, nodes := newTestConnManager(t)
+		lost := newFakeNode(nil)
+		m.conns[NamespacedConnFromContext(ctx, "office")] = lost
+		events, cancel := m.Subscribe()
+		defer cancel()
+
+		nodes.fail = errors.New("failed to join")
+		m.Supervise(ctx, "office")
+		expectEvent(t, events, v1.DaemonConnStatus_CONNECTED)
+		lost.errs <- errors.New("lost")
+		expectEvent(t, events, v1.DaemonConnStatus_CONNECTING)
+		if status := m.GetStatus(ctx, "office"); status != v1.DaemonConnStatus_CONNECTING {
+			t.Fatalf("expected status %s, got %s", v1.DaemonConnStatus_CONNECTING, status)
+		}
+
+		if err := m.Disconnect(ctx, "office"); err != nil {
+			t.Fatal(err)
+		}
+		expectEvent(t, events, v1.DaemonConnStatus_DISCONNECTED)
+		if status := m.GetStatus(ctx, "office"); status != v1.DaemonConnStatus_DISCONNECTED {
+			t.Fatalf("expected status %s, got %s", v1.DaemonConnStatus_DISCONNECTED, status)
+		}
+	})
+}
+
+func TestConnEvents(t *testing.T) {
+	t.Parallel()
+	events := newConnEvents()
+	conn := NamespacedConn{ConnID: "office"}
+
+	first, cancelFirst := events.subscribe()
+	second, cancelSecond := events.subscribe()
+	defer cancelSecond()
+	events.publish(conn, v1.DaemonConnStatus_CONNECTING)
+	expectEvent(t, first, v1.DaemonConnStatus_CONNECTING)
+	expectEvent(t, second, v1.DaemonConnStatus_CONNECTING)
+
+	cancelFirst()
+	events.publish(conn, v1.DaemonConnStatus_CONNECTED)
+	expectEvent(t, second, v1.DaemonConnStatus_CONNECTED)
+	select {
+	case ev := <-first:
+		t.Fatalf("expected no events after canceling, got %s", ev.Status)
+	default:
+	}
+
+	// Slow subscribers drop events instead of blocking publishers.
+	for i := 0; i < connEventBuffer+1; i++ {
+		events.publish(conn, v1.DaemonConnStatus_CONNECTED)
+	}
+	if len(second) != connEventBuffer {
+		t.Fatalf("expected %d buffered events, got %d", connEventBuffer, len(second))
+	}
+}
+
+func newTestConnManager(t *testing.T) (*ConnManager, *fakeNodes) {
+	t.Helper()
+	conf := NewDefaultConfig()
+	conf.Reconnect.CheckInterval = time.Hour
+	conf.Reconnect.InitialBackoff = time.Millisecond * 10
+	conf.Reconnect.MaxBackoff = time.Millisecond * 50
+	conf.Reconnect.WatchNetwork = false
+	m, err := NewConnManager(*conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { m.Close() })
+	err = m.Profiles().Put(context.Background(), "office", Profile{PutConnectionRequest: &v1.PutConnectionRequest{
+		Id:         "office",
+		Parameters: &v1.ConnectionParameters{Addrs: []string{"join.example.com:8443"}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodes := &fakeNodes{}
+	m.newNode = nodes.newNode
+	return m, nodes
+}
+
+func expectEvent(t *testing.T, events <-chan ConnEvent, want v1.DaemonConnStatus) {
+	t.Helper()
+	select {
+	case ev := <-events:
+		if ev.Status != want {
+			t.Fatalf("expected event %s, got %s", want, ev.Status)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatalf("timed out waiting for event %s", want)
+	}
+}
+
+// fakeNodes hands out queued fake nodes, or nodes failing with fail once the
+// queue is empty.
+type fakeNodes struct {
+	queue    []*fakeNode
+	fail     error
+	created  int
+	onCreate func(created int)
+	mu       sync.Mutex
+}
+
+func (f *fakeNodes) push(nodes ...*fakeNode) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.queue = append(f.queue, nodes...)
+}
+
+func (f *fakeNodes) newNode(ctx context.Context, opts embed.Options) (embed.Node, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.created++
+	if f.onCreate != nil {
+		f.onCreate(f.created)
+	}
+	if len(f.queue) == 0 {
+		return newFakeNode(f.fail), nil
+	}
+	node := f.queue[0]
+	f.queue = f.queue[1:]
+	return node, nil
+}
+
+type fakeNode struct {
+	embed.Node
+	startErr error
+	errs     chan error
+	stopped  atomic.Bool
+}
+
+func newFakeNode(startErr error) *fakeNode {
+	return &fakeNode{startErr: startErr, errs: make(chan error, 1)}
+}
+
+func (n *fakeNode) Start(ctx context.Context) error { return n.startErr }
+
+func (n *fakeNode) Stop(ctx context.Context) error {
+	n.stopped.Store(true)
+	return nil
+}
+
+func (n *fakeNode) Errors() <-chan error { return n.errs }
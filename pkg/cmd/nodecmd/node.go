@@ -53,6 +53,9 @@ var (
 )
 
 func Execute() error {
+	if len(os.Args) > 1 && os.Args[1] == "recover" {
+		return Recover(os.Args[2:])
+	}
 	// Parse flags and read in configurations
 	err := flagset.Parse(os.Args[1:])
 	if err != nil {
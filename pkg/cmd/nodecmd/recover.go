@@ -0,0 +1,248 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nodecmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"github.com/spf13/pflag"
+
+	"github.com/webmeshproj/webmesh/pkg/config"
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/crypto"
+	"github.com/webmeshproj/webmesh/pkg/logging"
+	"github.com/webmeshproj/webmesh/pkg/storage/providers/raftstorage"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// Recover runs the offline recovery tool for a node that lost quorum.
+func Recover(args []string) error {
+	fs := pflag.NewFlagSet("webmesh-node recover", pflag.ContinueOnError)
+	help := fs.Bool("help", false, "Print usage information and exit")
+	configFile := fs.String("config", "", "Path to the configuration file of the node")
+	dataDir := fs.String("data-dir", "", "Path to the storage directory (defaults to storage.path)")
+	newVoters := fs.StringSlice("new-voters", nil, "Voters of the recovered cluster as id or id=raft-address (defaults to only this node)")
+	inspect := fs.Bool("inspect", false, "Print the raft state and exit without changing it")
+	jsonOut := fs.Bool("json", false, "Print the raft state as JSON")
+	conf := config.NewDefaultConfig("").BindFlags("", fs)
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, `Usage: webmesh-node recover [flags]
+
+Recover rewrites the raft configuration of a stopped storage node whose
+cluster permanently lost a majority of its voters. Run it with the same
+--new-voters on every surviving voter before restarting any of them. With
+no --new-voters the node is restarted as the only voter of a new cluster
+with the state it has. Start recovered nodes without a join address. The
+data directory is copied next to itself before it is changed.
+
+Flags:
+`)
+		w := tabwriter.NewWriter(os.Stderr, 0, 0, 2, ' ', 0)
+		for _, name := range []string{"config", "data-dir", "mesh.node-id", "new-voters", "inspect", "json"} {
+			f := fs.Lookup(name)
+			fmt.Fprintf(w, "  --%s\t%s\n", f.Name, f.Usage)
+		}
+		w.Flush()
+		fmt.Fprintln(os.Stderr, "\nThe storage.encryption.* and wireguard.key-file flags of the node are also used.")
+	}
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, pflag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+	if *help {
+		fs.Usage()
+		return nil
+	}
+	var configs []string
+	if *configFile != "" {
+		configs = append(configs, *configFile)
+	}
+	if err := conf.LoadFrom(fs, configs); err != nil {
+		return err
+	}
+	log := logging.SetupLogging(conf.Global.LogLevel, conf.Global.LogFormat)
+	ctx := context.WithLogger(context.Background(), log)
+
+	opts, err := recoveryOptions(conf, *dataDir)
+	if err != nil {
+		return err
+	}
+	var state *raftstorage.RaftState
+	if *inspect {
+		state, err = raftstorage.Inspect(opts)
+	} else {
+		var voters []raft.Server
+		voters, err = parseVoters(*newVoters)
+		if err != nil {
+			return err
+		}
+		var backup string
+		backup, err = backupDataDir(opts.DataDir)
+		if err != nil {
+			return err
+		}
+		log.Info("Backed up data directory", "path", backup)
+		state, err = raftstorage.Recover(ctx, opts, voters)
+	}
+	if err != nil {
+		return err
+	}
+	if *jsonOut {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(state)
+	}
+	if !*inspect {
+		fmt.Println("Recovered raft configuration. Restart the node to elect a leader.")
+		fmt.Println()
+	}
+	printRaftState(os.Stdout, state)
+	return nil
+}
+
+// recoveryOptions returns the raft storage options for opening the data
+// directory of the node offline.
+func recoveryOptions(conf *config.Config, dataDir string) (raftstorage.Options, error) {
+	opts := raftstorage.NewOptions(types.NodeID(conf.Mesh.NodeID), nil)
+	opts.DataDir = conf.Storage.Path
+	if dataDir != "" {
+		opts.DataDir = dataDir
+	}
+	opts.SnapshotRetention = conf.Storage.Raft.SnapshotRetention
+	opts.LogLevel = conf.Storage.LogLevel
+	opts.LogFormat = conf.Storage.LogFormat
+	if err := conf.Storage.Encryption.Validate(); err != nil {
+		return opts, err
+	}
+	var wireguardKey crypto.PrivateKey
+	if conf.Storage.Encryption.FromWireGuard {
+		if conf.WireGuard.KeyFile == "" {
+			return opts, fmt.Errorf("wireguard.key-file is required to derive the storage encryption key")
+		}
+		// Never load or rotate the key through the usual path here.
+		key, err := crypto.DecodePrivateKeyFromFile(conf.WireGuard.KeyFile)
+		if err != nil {
+			return opts, fmt.Errorf("read wireguard key: %w", err)
+		}
+		wireguardKey = key
+	}
+	keys, err := conf.Storage.Encryption.NewKeys(wireguardKey)
+	if err != nil {
+		return opts, fmt.Errorf("load storage encryption keys: %w", err)
+	}
+	opts.Encryption = keys
+	return opts, nil
+}
+
+// backupDataDir copies the data directory next to itself and returns the
+// path of the copy.
+func backupDataDir(dataDir string) (string, error) {
+	dataDir = filepath.Clean(dataDir)
+	backup := fmt.Sprintf("%s.backup-%s", dataDir, time.Now().UTC().Format("20060102T150405Z"))
+	err := filepath.WalkDir(dataDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dataDir, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(backup, rel)
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return os.MkdirAll(target, info.Mode().Perm())
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		return copyFile(path, target, info.Mode().Perm())
+	})
+	if err != nil {
+		return "", fmt.Errorf("back up data directory: %w", err)
+	}
+	return backup, nil
+}
+
+func copyFile(src, dst string, perm os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// parseVoters parses voters given as id or id=address.
+func parseVoters(in []string) ([]raft.Server, error) {
+	var voters []raft.Server
+	for _, v := range in {
+		id, addr, _ := strings.Cut(strings.TrimSpace(v), "=")
+		if !types.IsValidNodeID(id) {
+			return nil, fmt.Errorf("invalid voter ID %q", id)
+		}
+		voters = append(voters, raft.Server{
+			ID:      raft.ServerID(id),
+			Address: raft.ServerAddress(addr),
+		})
+	}
+	return voters, nil
+}
+
+func printRaftState(out io.Writer, state *raftstorage.RaftState) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	defer w.Flush()
+	fmt.Fprintf(w, "Node ID:\t%s\n", state.NodeID)
+	fmt.Fprintf(w, "Current term:\t%d\n", state.CurrentTerm)
+	if state.FirstIndex > 0 {
+		fmt.Fprintf(w, "Log entries:\t%d-%d\n", state.FirstIndex, state.LastIndex)
+	} else {
+		fmt.Fprintln(w, "Log entries:\tnone")
+	}
+	fmt.Fprintf(w, "Last index:\t%d (term %d)\n", state.LastIndex, state.LastTerm)
+	fmt.Fprintf(w, "Configuration index:\t%d\n", state.ConfigurationIndex)
+	fmt.Fprintln(w, "\nMEMBER\tSUFFRAGE\tADDRESS")
+	for _, server := range state.Configuration.Servers {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", server.ID, server.Suffrage, server.Address)
+	}
+	fmt.Fprintln(w, "\nSNAPSHOT\tINDEX\tTERM\tSIZE")
+	for _, snapshot := range state.Snapshots {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\n", snapshot.ID, snapshot.Index, snapshot.Term, snapshot.Size)
+	}
+}
@@ -58,7 +58,9 @@ The order of precedence for parsing is:
 		
 	1. Files
 	2. Environment variables
-	3. Command line flags`,
+	3. Command line flags
+
+Run "webmesh-node recover --help" to recover a storage cluster that lost quorum.`,
 		Prefixes:     configPrefixes,
 		Flagset:      flagset,
 		SkipPrefixes: []string{"bridge"},
@@ -0,0 +1,101 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// ServiceMethod describes a method of a gRPC service that is implemented
+// with existing protobuf message types.
+type ServiceMethod struct {
+	// Name is the name of the method.
+	Name string
+	// Input is the request message type.
+	Input proto.Message
+	// Output is the response message type.
+	Output proto.Message
+	// ClientStreaming is true if the client sends a stream of requests.
+	ClientStreaming bool
+	// ServerStreaming is true if the server sends a stream of responses.
+	ServerStreaming bool
+}
+
+var registerServiceMu sync.Mutex
+
+// RegisterServiceFile registers a protobuf file descriptor for a service that
+// is described by hand instead of generated from a proto file. This allows the
+// service to be discovered with server reflection. The file path is taken from
+// the Metadata of the service description and the package from the service name.
+// Registering the same file more than once is a no-op.
+func RegisterServiceFile(desc *grpc.ServiceDesc, methods ...ServiceMethod) error {
+	registerServiceMu.Lock()
+	defer registerServiceMu.Unlock()
+	path, ok := desc.Metadata.(string)
+	if !ok || path == "" {
+		return fmt.Errorf("service %s has no file path in its metadata", desc.ServiceName)
+	}
+	if _, err := protoregistry.GlobalFiles.FindFileByPath(path); err == nil {
+		return nil
+	}
+	i := strings.LastIndex(desc.ServiceName, ".")
+	if i <= 0 {
+		return fmt.Errorf("service name %q has no package", desc.ServiceName)
+	}
+	svc := &descriptorpb.ServiceDescriptorProto{
+		Name: proto.String(desc.ServiceName[i+1:]),
+	}
+	var deps []string
+	seen := make(map[string]struct{})
+	addDep := func(msg proto.Message) string {
+		md := msg.ProtoReflect().Descriptor()
+		dep := md.ParentFile().Path()
+		if _, ok := seen[dep]; !ok {
+			seen[dep] = struct{}{}
+			deps = append(deps, dep)
+		}
+		return "." + string(md.FullName())
+	}
+	for _, method := range methods {
+		svc.Method = append(svc.Method, &descriptorpb.MethodDescriptorProto{
+			Name:            proto.String(method.Name),
+			InputType:       proto.String(addDep(method.Input)),
+			OutputType:      proto.String(addDep(method.Output)),
+			ClientStreaming: proto.Bool(method.ClientStreaming),
+			ServerStreaming: proto.Bool(method.ServerStreaming),
+		})
+	}
+	fd, err := protodesc.NewFile(&descriptorpb.FileDescriptorProto{
+		Name:       proto.String(path),
+		Package:    proto.String(desc.ServiceName[:i]),
+		Dependency: deps,
+		Syntax:     proto.String("proto3"),
+		Service:    []*descriptorpb.ServiceDescriptorProto{svc},
+	}, protoregistry.GlobalFiles)
+	if err != nil {
+		return fmt.Errorf("build file descriptor for %s: %w", desc.ServiceName, err)
+	}
+	return protoregistry.GlobalFiles.RegisterFile(fd)
+}
@@ -18,6 +18,7 @@ package config
 
 import (
 	"fmt"
+	"net/netip"
 	"os"
 	"strings"
 	"time"
@@ -35,6 +36,34 @@ type BridgeOptions struct {
 	MeshDNS BridgeMeshDNSOptions `koanf:"meshdns,omitempty"`
 	// UseMeshDNS is true if the bridge should use the meshdns server for local name resolution.
 	UseMeshDNS bool `koanf:"use-meshdns,omitempty"`
+	// Exports are prefixes advertised from one bridged mesh into another. When no
+	// exports are configured between two meshes, the IPv6 network of each is
+	// advertised to the other.
+	Exports []BridgeExportOptions `koanf:"exports,omitempty"`
+}
+
+// BridgeExportOptions are options for advertising the prefixes of one bridged
+// mesh into another. The bridge advertises exported prefixes as routes it owns
+// in the destination mesh. Prefixes that overlap the networks or routes of the
+// destination mesh are translated one to one into prefixes allocated from the
+// NAT pool. The bridge host must be able to route the original prefixes through
+// the source mesh.
+type BridgeExportOptions struct {
+	// From is the ID of the mesh to export prefixes from.
+	From string `koanf:"from,omitempty"`
+	// To is the ID of the mesh to advertise the prefixes to.
+	To string `koanf:"to,omitempty"`
+	// CIDRs are the prefixes to export. Defaults to the networks of the source mesh.
+	CIDRs []string `koanf:"cidrs,omitempty"`
+	// Nodes restricts the export to the addresses of the given nodes in the
+	// source mesh that fall within the CIDRs.
+	Nodes []string `koanf:"nodes,omitempty"`
+	// Routes also exports the routes of the source mesh that fall within the
+	// CIDRs, or that are owned by one of the nodes if nodes are given.
+	Routes bool `koanf:"routes,omitempty"`
+	// NATPool is the prefix to allocate translated prefixes from. Exported
+	// prefixes that overlap the destination mesh are skipped if it is not set.
+	NATPool string `koanf:"nat-pool,omitempty"`
 }
 
 // NewBridgeOptions returns a new empty BridgeOptions.
@@ -104,6 +133,12 @@ type BridgeMeshDNSOptions struct {
 	DisableForwarding bool `koanf:"disable-forwarding,omitempty"`
 	// CacheSize is the size of the remote DNS cache.
 	CacheSize int `koanf:"cache-size,omitempty"`
+	// ServeStale is how long expired cache entries may be served while they are refreshed.
+	ServeStale time.Duration `koanf:"serve-stale,omitempty"`
+	// PrefetchThreshold is the number of cache hits after which entries are refreshed before expiring.
+	PrefetchThreshold int `koanf:"prefetch-threshold,omitempty"`
+	// SplitHorizon only answers with the nodes the querying node can reach under network ACLs.
+	SplitHorizon bool `koanf:"split-horizon,omitempty"`
 }
 
 // NewBridgeMeshDNSOptions returns a new BridgeMeshDNSOptions with sensible defaults.
@@ -119,6 +154,9 @@ func NewBridgeMeshDNSOptions() BridgeMeshDNSOptions {
 		SubscribeForwarders: true,
 		DisableForwarding:   false,
 		CacheSize:           0,
+		ServeStale:          0,
+		PrefetchThreshold:   0,
+		SplitHorizon:        false,
 	}
 }
 
@@ -134,6 +172,9 @@ func (m *BridgeMeshDNSOptions) BindFlags(fl *pflag.FlagSet) {
 	fl.BoolVar(&m.SubscribeForwarders, "bridge.meshdns.subscribe-forwarders", m.SubscribeForwarders, "Subscribe to new nodes that can forward requests.")
 	fl.BoolVar(&m.DisableForwarding, "bridge.meshdns.disable-forwarding", m.DisableForwarding, "Disable forwarding requests.")
 	fl.IntVar(&m.CacheSize, "bridge.meshdns.cache-size", m.CacheSize, "Size of the remote DNS cache (0 = disabled).")
+	fl.DurationVar(&m.ServeStale, "bridge.meshdns.serve-stale", m.ServeStale, "How long expired cache entries may be served while they are refreshed (0 = disabled).")
+	fl.IntVar(&m.PrefetchThreshold, "bridge.meshdns.prefetch-threshold", m.PrefetchThreshold, "Cache hits after which entries are refreshed before expiring (0 = disabled).")
+	fl.BoolVar(&m.SplitHorizon, "bridge.meshdns.split-horizon", m.SplitHorizon, "Only answer with nodes the querying node can reach under network ACLs.")
 }
 
 // Validate recursively validates the config.
@@ -149,9 +190,53 @@ func (b *BridgeOptions) Validate() error {
 			return err
 		}
 	}
+	for i, export := range b.Exports {
+		if err := export.Validate(b.Meshes); err != nil {
+			return fmt.Errorf("bridge.exports[%d]: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// Validate validates the export against the bridged meshes.
+func (e *BridgeExportOptions) Validate(meshes map[string]*Config) error {
+	if _, ok := meshes[e.From]; !ok {
+		return fmt.Errorf("from must be one of the bridged meshes, got %q", e.From)
+	}
+	if _, ok := meshes[e.To]; !ok {
+		return fmt.Errorf("to must be one of the bridged meshes, got %q", e.To)
+	}
+	if e.From == e.To {
+		return fmt.Errorf("from and to must be different meshes")
+	}
+	for _, cidr := range e.CIDRs {
+		if _, err := netip.ParsePrefix(cidr); err != nil {
+			return fmt.Errorf("invalid cidr %q: %w", cidr, err)
+		}
+	}
+	if e.NATPool != "" {
+		if _, err := netip.ParsePrefix(e.NATPool); err != nil {
+			return fmt.Errorf("invalid nat-pool %q: %w", e.NATPool, err)
+		}
+	}
 	return nil
 }
 
+// ExportsIPv4 returns true if any export includes IPv4 prefixes from the given mesh.
+func (b *BridgeOptions) ExportsIPv4(meshID string) bool {
+	for _, export := range b.Exports {
+		if export.From != meshID {
+			continue
+		}
+		for _, cidr := range export.CIDRs {
+			if prefix, err := netip.ParsePrefix(cidr); err == nil && prefix.Addr().Is4() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 // Validate validates the bridge dns options.
 func (m *BridgeMeshDNSOptions) Validate() error {
 	if !m.Enabled {
@@ -169,5 +254,11 @@ func (m *BridgeMeshDNSOptions) Validate() error {
 	if m.CacheSize < 0 {
 		return fmt.Errorf("bridge.meshdns.cache-size must be >= 0")
 	}
+	if m.ServeStale < 0 {
+		return fmt.Errorf("bridge.meshdns.serve-stale must be >= 0")
+	}
+	if m.PrefetchThreshold < 0 {
+		return fmt.Errorf("bridge.meshdns.prefetch-threshold must be >= 0")
+	}
 	return nil
 }
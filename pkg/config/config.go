@@ -154,7 +154,7 @@ var ErrNoMesh = fmt.Errorf("no mesh configured")
 func (o *Config) Validate() error {
 	// Make sure we are either bootstrapping or joining a mesh when not in bridge mode
 	if !o.Bootstrap.Enabled && len(o.Bridge.Meshes) == 0 {
-		if len(o.Mesh.JoinAddresses) == 0 && len(o.Mesh.JoinMultiaddrs) == 0 {
+		if !o.Mesh.HasJoinTargets() {
 			if !o.Discovery.Discover || o.Discovery.Rendezvous == "" {
 				return ErrNoMesh
 			}
@@ -191,6 +191,12 @@ func (o *Config) Validate() error {
 	if err != nil {
 		return fmt.Errorf("invalid wireguard options: %w", err)
 	}
+	if o.Storage.Encryption.FromWireGuard && o.WireGuard.KeyFile == "" {
+		return fmt.Errorf("storage encryption from the wireguard key requires a wireguard key file")
+	}
+	if o.Storage.Raft.AutopilotTargetVoters > 0 && o.Storage.Raft.AutopilotMaxLag > 0 && o.Mesh.PresenceInterval <= 0 {
+		return fmt.Errorf("raft.autopilot-max-lag requires mesh.presence-interval to measure lag")
+	}
 	err = o.Discovery.Validate()
 	if err != nil {
 		return fmt.Errorf("invalid discovery options: %w", err)
@@ -210,7 +216,7 @@ func (o *Config) NodeID(ctx context.Context) (string, error) {
 	}
 	// Check if we are using ID authentication.
 	if o.Auth.IDAuth.Enabled {
-		key, err := o.WireGuard.LoadKey(ctx)
+		key, err := o.LoadWireGuardKey(ctx)
 		if err != nil {
 			return "", fmt.Errorf("load wireguard key: %w", err)
 		}
@@ -20,6 +20,7 @@ import (
 	"encoding/base64"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/webmeshproj/webmesh/pkg/context"
 	"github.com/webmeshproj/webmesh/pkg/logging"
@@ -149,3 +150,22 @@ pRDk03qyLXoqXgaBfNpXFT3JbXvVAiEArCafC3sy1pOrvPI7mcAyk6/xvP8nRm7t
 lASd5X/aQkw=
 -----END CERTIFICATE-----
 `
+
+func TestAutopilotMaxLagRequiresPresence(t *testing.T) {
+	t.Parallel()
+	conf := NewDefaultConfig("node")
+	conf.Bootstrap.Enabled = true
+	conf.Storage.InMemory = true
+	conf.Storage.Raft.AutopilotTargetVoters = 3
+	if err := conf.Validate(); err != nil {
+		t.Fatalf("expected the autopilot to be valid without a max lag, got %v", err)
+	}
+	conf.Storage.Raft.AutopilotMaxLag = 100
+	if err := conf.Validate(); err == nil {
+		t.Fatal("expected an error for a max lag without presence leases")
+	}
+	conf.Mesh.PresenceInterval = time.Second * 10
+	if err := conf.Validate(); err != nil {
+		t.Fatalf("expected a max lag with presence leases to be valid, got %v", err)
+	}
+}
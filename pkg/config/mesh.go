@@ -40,6 +40,7 @@ import (
 	"github.com/webmeshproj/webmesh/pkg/meshnet"
 	"github.com/webmeshproj/webmesh/pkg/meshnet/transport"
 	"github.com/webmeshproj/webmesh/pkg/meshnet/transport/libp2p"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/transport/resolvers"
 	"github.com/webmeshproj/webmesh/pkg/meshnet/transport/tcp"
 	"github.com/webmeshproj/webmesh/pkg/meshnode"
 	"github.com/webmeshproj/webmesh/pkg/plugins/builtins/basicauth"
@@ -65,6 +66,15 @@ type MeshOptions struct {
 	// JoinMultiaddrs are multiaddresses to attempt to join over libp2p.
 	// These cannot be used with JoinAddresses.
 	JoinMultiaddrs []string `koanf:"join-multiaddrs,omitempty"`
+	// JoinSRVDomain is a domain to look up _webmesh._tcp SRV records in for
+	// addresses to join. These cannot be used with JoinMultiaddrs.
+	JoinSRVDomain string `koanf:"join-srv-domain,omitempty"`
+	// JoinAddressFile is a file of addresses to join. The file is watched for
+	// changes. These cannot be used with JoinMultiaddrs.
+	JoinAddressFile string `koanf:"join-address-file,omitempty"`
+	// JoinResolverRefresh is the interval to refresh addresses resolved from
+	// JoinSRVDomain and JoinAddressFile.
+	JoinResolverRefresh time.Duration `koanf:"join-resolver-refresh,omitempty"`
 	// MaxJoinRetries is the maximum number of join retries.
 	MaxJoinRetries int `koanf:"max-join-retries,omitempty"`
 	// Routes are additional routes to advertise to the mesh. These routes are advertised to all peers.
@@ -98,6 +108,13 @@ type MeshOptions struct {
 	DisableDefaultIPAM bool `koanf:"disable-default-ipam,omitempty"`
 	// DefaultIPAMStaticIPv4 are static IPv4 assignments to use for the default IPAM.
 	DefaultIPAMStaticIPv4 map[string]string `koanf:"default-ipam-static-ipv4,omitempty"`
+	// PresenceInterval is how often the node renews its presence lease. Zero disables presence leases.
+	// Every renewal is a write to the mesh registry, so leases are opt-in.
+	PresenceInterval time.Duration `koanf:"presence-interval,omitempty"`
+	// PresencePurgeAfter is how long after their presence lease expired members that do not
+	// provide storage are removed from the mesh. This is enforced by the storage leader
+	// and requires a presence interval.
+	PresencePurgeAfter time.Duration `koanf:"presence-purge-after,omitempty"`
 }
 
 // NewMeshOptions returns a new MeshOptions with the default values. If node id
@@ -108,6 +125,9 @@ func NewMeshOptions(nodeID string) MeshOptions {
 		PrimaryEndpoint:             "",
 		ZoneAwarenessID:             "",
 		JoinAddresses:               nil,
+		JoinSRVDomain:               "",
+		JoinAddressFile:             "",
+		JoinResolverRefresh:         resolvers.DefaultRefreshInterval,
 		MaxJoinRetries:              15,
 		Routes:                      nil,
 		ICEPeers:                    []string{},
@@ -123,6 +143,8 @@ func NewMeshOptions(nodeID string) MeshOptions {
 		DisableFeatureAdvertisement: false,
 		DisableDefaultIPAM:          false,
 		DefaultIPAMStaticIPv4:       map[string]string{},
+		PresenceInterval:            0,
+		PresencePurgeAfter:          0,
 	}
 }
 
@@ -133,6 +155,9 @@ func (o *MeshOptions) BindFlags(prefix string, fs *pflag.FlagSet) {
 	fs.StringVar(&o.ZoneAwarenessID, prefix+"zone-awareness-id", o.ZoneAwarenessID, "Zone awareness ID.")
 	fs.StringSliceVar(&o.JoinAddresses, prefix+"join-addresses", o.JoinAddresses, "Addresses of nodes to join.")
 	fs.StringSliceVar(&o.JoinMultiaddrs, prefix+"join-multiaddrs", o.JoinMultiaddrs, "Multiaddresses of nodes to join.")
+	fs.StringVar(&o.JoinSRVDomain, prefix+"join-srv-domain", o.JoinSRVDomain, "Domain to look up _webmesh._tcp SRV records in for nodes to join.")
+	fs.StringVar(&o.JoinAddressFile, prefix+"join-address-file", o.JoinAddressFile, "File of addresses of nodes to join. The file is watched for changes.")
+	fs.DurationVar(&o.JoinResolverRefresh, prefix+"join-resolver-refresh", o.JoinResolverRefresh, "Interval to refresh addresses resolved from SRV records or the join address file.")
 	fs.IntVar(&o.MaxJoinRetries, prefix+"max-join-retries", o.MaxJoinRetries, "Maximum number of join retries.")
 	fs.StringSliceVar(&o.Routes, prefix+"routes", o.Routes, "Additional routes to advertise to the mesh.")
 	fs.StringSliceVar(&o.ICEPeers, prefix+"ice-peers", o.ICEPeers, "Peers to request direct edges to over ICE.")
@@ -148,6 +173,8 @@ func (o *MeshOptions) BindFlags(prefix string, fs *pflag.FlagSet) {
 	fs.BoolVar(&o.DisableFeatureAdvertisement, prefix+"disable-feature-advertisement", o.DisableFeatureAdvertisement, "Disable feature advertisement.")
 	fs.BoolVar(&o.DisableDefaultIPAM, prefix+"disable-default-ipam", o.DisableDefaultIPAM, "Disable the default IPAM.")
 	fs.StringToStringVar(&o.DefaultIPAMStaticIPv4, prefix+"default-ipam-static-ipv4", o.DefaultIPAMStaticIPv4, "Static IPv4 assignments to use for the default IPAM.")
+	fs.DurationVar(&o.PresenceInterval, prefix+"presence-interval", o.PresenceInterval, "How often to renew the presence lease of this node (0 = disabled).")
+	fs.DurationVar(&o.PresencePurgeAfter, prefix+"presence-purge-after", o.PresencePurgeAfter, "Remove members that are offline for longer than this when leader (0 = never).")
 }
 
 // Validate validates the options.
@@ -163,9 +190,23 @@ func (o *MeshOptions) Validate() error {
 	if o.DisableIPv4 && o.DisableIPv6 {
 		return fmt.Errorf("cannot disable both IPv4 and IPv6")
 	}
-	if (len(o.JoinAddresses) > 0 || len(o.JoinMultiaddrs) > 0) && o.MaxJoinRetries <= 0 {
+	if o.PresenceInterval < 0 || o.PresencePurgeAfter < 0 {
+		return fmt.Errorf("presence interval and purge after must be >= 0")
+	}
+	if o.PresencePurgeAfter > 0 && o.PresenceInterval == 0 {
+		return fmt.Errorf("presence purge after requires a presence interval")
+	}
+	if o.HasJoinTargets() && o.MaxJoinRetries <= 0 {
 		return fmt.Errorf("max join retries must be >= 0")
 	}
+	if (o.JoinSRVDomain != "" || o.JoinAddressFile != "") && len(o.JoinMultiaddrs) > 0 {
+		return fmt.Errorf("cannot use join SRV domain or address file with join multiaddrs")
+	}
+	if o.JoinSRVDomain != "" || o.JoinAddressFile != "" {
+		if o.JoinResolverRefresh <= 0 {
+			return fmt.Errorf("join resolver refresh must be > 0")
+		}
+	}
 	for _, addr := range o.JoinAddresses {
 		if _, _, err := net.SplitHostPort(addr); err != nil {
 			return fmt.Errorf("invalid join address: %w", err)
@@ -234,6 +275,12 @@ func (o *MeshOptions) Validate() error {
 	return nil
 }
 
+// HasJoinTargets returns true if any addresses or resolvers for joining a mesh
+// are configured.
+func (o *MeshOptions) HasJoinTargets() bool {
+	return len(o.JoinAddresses) > 0 || len(o.JoinMultiaddrs) > 0 || o.JoinSRVDomain != "" || o.JoinAddressFile != ""
+}
+
 // IsStorageMember returns true if the node is a storage provider.
 func (o *Config) IsStorageMember() bool {
 	return o.Bootstrap.Enabled || o.Mesh.RequestVote || o.Mesh.RequestObserver
@@ -244,20 +291,26 @@ func (o *Config) IsStorageMember() bool {
 func (o *Config) NewMeshConfig(ctx context.Context, key crypto.PrivateKey) (conf meshnode.Config, err error) {
 	log := context.LoggerFrom(ctx)
 	if key == nil {
-		key, err = o.WireGuard.LoadKey(ctx)
+		key, err = o.LoadWireGuardKey(ctx)
 		if err != nil {
 			return
 		}
 	}
 	conf = meshnode.Config{
-		Key:                     key,
-		HeartbeatPurgeThreshold: o.Storage.Raft.HeartbeatPurgeThreshold,
-		ZoneAwarenessID:         o.Mesh.ZoneAwarenessID,
-		UseMeshDNS:              o.Mesh.UseMeshDNS,
-		DisableIPv4:             o.Mesh.DisableIPv4,
-		DisableIPv6:             o.Mesh.DisableIPv6,
-		DisableDefaultIPAM:      o.Mesh.DisableDefaultIPAM,
-		DefaultIPAMStaticIPv4:   o.Mesh.DefaultIPAMStaticIPv4,
+		Key:                      key,
+		HeartbeatPurgeThreshold:  o.Storage.Raft.HeartbeatPurgeThreshold,
+		PresenceInterval:         o.Mesh.PresenceInterval,
+		PresencePurgeAfter:       o.Mesh.PresencePurgeAfter,
+		AutopilotTargetVoters:    o.Storage.Raft.AutopilotTargetVoters,
+		AutopilotInterval:        o.Storage.Raft.AutopilotInterval,
+		AutopilotMaxLag:          o.Storage.Raft.AutopilotMaxLag,
+		AutopilotDeadServerAfter: o.Storage.Raft.AutopilotDeadServerAfter,
+		ZoneAwarenessID:          o.Mesh.ZoneAwarenessID,
+		UseMeshDNS:               o.Mesh.UseMeshDNS,
+		DisableIPv4:              o.Mesh.DisableIPv4,
+		DisableIPv6:              o.Mesh.DisableIPv6,
+		DisableDefaultIPAM:       o.Mesh.DisableDefaultIPAM,
+		DefaultIPAMStaticIPv4:    o.Mesh.DefaultIPAMStaticIPv4,
 	}
 	// Check if we are serving a local DNS server
 	if o.Services.MeshDNS.Enabled {
@@ -551,11 +604,33 @@ func (o *Config) NewJoinTransport(ctx context.Context, nodeID string, conn meshn
 		// Our join transport is nil and we either bootstrap or recover from storage.
 		return nil, nil
 	}
-	if len(o.Mesh.JoinAddresses) > 0 {
+	if len(o.Mesh.JoinAddresses) > 0 || o.Mesh.JoinSRVDomain != "" || o.Mesh.JoinAddressFile != "" {
+		var chain []transport.FeatureResolver
+		if o.Mesh.JoinSRVDomain != "" {
+			chain = append(chain, resolvers.NewSRVResolver(resolvers.SRVOptions{
+				Domain:          o.Mesh.JoinSRVDomain,
+				RefreshInterval: o.Mesh.JoinResolverRefresh,
+			}))
+		}
+		if o.Mesh.JoinAddressFile != "" {
+			fileResolver, err := resolvers.NewFileResolver(ctx, resolvers.FileOptions{
+				Path:            o.Mesh.JoinAddressFile,
+				RefreshInterval: o.Mesh.JoinResolverRefresh,
+			})
+			if err != nil {
+				return nil, fmt.Errorf("create join address file resolver: %w", err)
+			}
+			chain = append(chain, fileResolver)
+		}
+		var resolver transport.FeatureResolver
+		if len(chain) > 0 {
+			resolver = resolvers.Chain(chain...)
+		}
 		return tcp.NewJoinRoundTripper(tcp.RoundTripOptions{
 			Addrs:          o.Mesh.JoinAddresses,
 			Credentials:    conn.Credentials(),
 			AddressTimeout: time.Second * 3,
+			Resolver:       resolver,
 		}), nil
 	}
 	if len(o.Mesh.JoinMultiaddrs) > 0 {
@@ -18,6 +18,7 @@ package config
 
 import (
 	"testing"
+	"time"
 
 	"github.com/spf13/pflag"
 
@@ -111,6 +112,63 @@ func TestMeshConfigValidation(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name: "ValidJoinSRVDomain",
+			cfg: &MeshOptions{
+				NodeID:               "test-node",
+				JoinSRVDomain:        "example.com",
+				JoinResolverRefresh:  time.Minute,
+				MaxJoinRetries:       10,
+				GRPCAdvertisePort:    services.DefaultGRPCPort,
+				MeshDNSAdvertisePort: meshdns.DefaultAdvertisePort,
+			},
+			wantErr: false,
+		},
+		{
+			name: "InvalidJoinResolverRefresh",
+			cfg: &MeshOptions{
+				NodeID:               "test-node",
+				JoinAddressFile:      "/etc/webmesh/join-addresses",
+				MaxJoinRetries:       10,
+				GRPCAdvertisePort:    services.DefaultGRPCPort,
+				MeshDNSAdvertisePort: meshdns.DefaultAdvertisePort,
+			},
+			wantErr: true,
+		},
+		{
+			name: "JoinSRVDomainWithMultiaddrs",
+			cfg: &MeshOptions{
+				NodeID:               "test-node",
+				JoinSRVDomain:        "example.com",
+				JoinResolverRefresh:  time.Minute,
+				JoinMultiaddrs:       []string{"/ip4/127.0.0.1/tcp/8080"},
+				MaxJoinRetries:       10,
+				GRPCAdvertisePort:    services.DefaultGRPCPort,
+				MeshDNSAdvertisePort: meshdns.DefaultAdvertisePort,
+			},
+			wantErr: true,
+		},
+		{
+			name: "PresencePurgeWithoutInterval",
+			cfg: &MeshOptions{
+				NodeID:               "test-node",
+				GRPCAdvertisePort:    services.DefaultGRPCPort,
+				MeshDNSAdvertisePort: meshdns.DefaultAdvertisePort,
+				PresencePurgeAfter:   time.Hour,
+			},
+			wantErr: true,
+		},
+		{
+			name: "PresencePurgeWithInterval",
+			cfg: &MeshOptions{
+				NodeID:               "test-node",
+				GRPCAdvertisePort:    services.DefaultGRPCPort,
+				MeshDNSAdvertisePort: meshdns.DefaultAdvertisePort,
+				PresenceInterval:     time.Minute,
+				PresencePurgeAfter:   time.Hour,
+			},
+			wantErr: false,
+		},
 		{
 			name: "InvalidSuffrage",
 			cfg: &MeshOptions{
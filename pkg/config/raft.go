@@ -58,27 +58,46 @@ type RaftOptions struct {
 	SnapshotRetention uint64 `koanf:"snapshot-retention,omitempty"`
 	// ObserverChanBuffer is the buffer size for the observer channel.
 	ObserverChanBuffer int `koanf:"observer-chan-buffer,omitempty"`
+	// WatchHistory is the number of changes kept in memory for resuming watches.
+	WatchHistory int `koanf:"watch-history,omitempty"`
 	// HeartbeatPurgeThreshold is the threshold of failed heartbeats before purging a peer.
 	HeartbeatPurgeThreshold int `koanf:"heartbeat-purge-threshold,omitempty"`
+	// AutopilotTargetVoters is the number of voters the leader keeps in the cluster
+	// by promoting and demoting servers. Zero disables the autopilot.
+	AutopilotTargetVoters int `koanf:"autopilot-target-voters,omitempty"`
+	// AutopilotInterval is how often the autopilot checks the cluster.
+	AutopilotInterval time.Duration `koanf:"autopilot-interval,omitempty"`
+	// AutopilotMaxLag is the number of log entries a server can be behind the leader
+	// before the autopilot considers it unhealthy. Lag is measured from presence
+	// leases, so this requires a presence interval. Zero disables the check.
+	AutopilotMaxLag uint64 `koanf:"autopilot-max-lag,omitempty"`
+	// AutopilotDeadServerAfter is how long a server can fail heartbeats before the
+	// autopilot removes it. Zero disables removing dead servers.
+	AutopilotDeadServerAfter time.Duration `koanf:"autopilot-dead-server-after,omitempty"`
 }
 
 // NewRaftOptions returns a new RaftOptions with the default values.
 func NewRaftOptions() RaftOptions {
 	return RaftOptions{
-		ListenAddress:           raftstorage.DefaultListenAddress,
-		ConnectionPoolCount:     0,
-		ConnectionTimeout:       3 * time.Second,
-		HeartbeatTimeout:        time.Second * 2,
-		ElectionTimeout:         time.Second * 2,
-		ApplyTimeout:            10 * time.Second,
-		CommitTimeout:           10 * time.Second,
-		MaxAppendEntries:        64,
-		LeaderLeaseTimeout:      time.Second * 2,
-		SnapshotInterval:        30 * time.Second,
-		SnapshotThreshold:       8192,
-		SnapshotRetention:       2,
-		ObserverChanBuffer:      100,
-		HeartbeatPurgeThreshold: 25,
+		ListenAddress:            raftstorage.DefaultListenAddress,
+		ConnectionPoolCount:      0,
+		ConnectionTimeout:        3 * time.Second,
+		HeartbeatTimeout:         time.Second * 2,
+		ElectionTimeout:          time.Second * 2,
+		ApplyTimeout:             10 * time.Second,
+		CommitTimeout:            10 * time.Second,
+		MaxAppendEntries:         64,
+		LeaderLeaseTimeout:       time.Second * 2,
+		SnapshotInterval:         30 * time.Second,
+		SnapshotThreshold:        8192,
+		SnapshotRetention:        2,
+		ObserverChanBuffer:       100,
+		WatchHistory:             raftstorage.DefaultWatchHistory,
+		HeartbeatPurgeThreshold:  25,
+		AutopilotTargetVoters:    0,
+		AutopilotInterval:        10 * time.Second,
+		AutopilotMaxLag:          0,
+		AutopilotDeadServerAfter: 10 * time.Minute,
 	}
 }
 
@@ -97,7 +116,12 @@ func (o *RaftOptions) BindFlags(prefix string, fs *pflag.FlagSet) {
 	fs.Uint64Var(&o.SnapshotThreshold, prefix+"snapshot-threshold", o.SnapshotThreshold, "Raft snapshot threshold.")
 	fs.Uint64Var(&o.SnapshotRetention, prefix+"snapshot-retention", o.SnapshotRetention, "Raft snapshot retention.")
 	fs.IntVar(&o.ObserverChanBuffer, prefix+"observer-chan-buffer", o.ObserverChanBuffer, "Raft observer channel buffer.")
+	fs.IntVar(&o.WatchHistory, prefix+"watch-history", o.WatchHistory, "Number of changes kept in memory for resuming watches.")
 	fs.IntVar(&o.HeartbeatPurgeThreshold, prefix+"heartbeat-purge-threshold", o.HeartbeatPurgeThreshold, "Raft heartbeat purge threshold.")
+	fs.IntVar(&o.AutopilotTargetVoters, prefix+"autopilot-target-voters", o.AutopilotTargetVoters, "Number of voters the autopilot keeps in the cluster (0 = disabled).")
+	fs.DurationVar(&o.AutopilotInterval, prefix+"autopilot-interval", o.AutopilotInterval, "How often the autopilot checks the cluster.")
+	fs.Uint64Var(&o.AutopilotMaxLag, prefix+"autopilot-max-lag", o.AutopilotMaxLag, "Log entries a server can lag behind the leader before the autopilot considers it unhealthy (0 = disabled, requires mesh.presence-interval).")
+	fs.DurationVar(&o.AutopilotDeadServerAfter, prefix+"autopilot-dead-server-after", o.AutopilotDeadServerAfter, "How long a server can fail heartbeats before the autopilot removes it (0 = never).")
 }
 
 // Validate validates the options.
@@ -109,6 +133,12 @@ func (o RaftOptions) Validate(dataDir string, inMemory bool) error {
 	if err != nil {
 		return fmt.Errorf("raft.listen-address is invalid: %w", err)
 	}
+	if o.AutopilotTargetVoters < 0 || o.AutopilotDeadServerAfter < 0 {
+		return fmt.Errorf("raft.autopilot-target-voters and raft.autopilot-dead-server-after must be >= 0")
+	}
+	if o.AutopilotTargetVoters > 0 && o.AutopilotInterval <= 0 {
+		return fmt.Errorf("raft.autopilot-interval must be > 0 when the autopilot is enabled")
+	}
 	if !inMemory && dataDir == "" {
 		return fmt.Errorf("storage.data-dir is required when not running in-memory")
 	}
@@ -47,18 +47,26 @@ import (
 	"github.com/webmeshproj/webmesh/pkg/plugins/builtins/idauth"
 	"github.com/webmeshproj/webmesh/pkg/services"
 	"github.com/webmeshproj/webmesh/pkg/services/admin"
+	auditsvc "github.com/webmeshproj/webmesh/pkg/services/audit"
+	"github.com/webmeshproj/webmesh/pkg/services/autopilot"
+	"github.com/webmeshproj/webmesh/pkg/services/dnsrecords"
+	"github.com/webmeshproj/webmesh/pkg/services/federation"
 	"github.com/webmeshproj/webmesh/pkg/services/leaderproxy"
+	"github.com/webmeshproj/webmesh/pkg/services/locks"
 	"github.com/webmeshproj/webmesh/pkg/services/membership"
 	"github.com/webmeshproj/webmesh/pkg/services/meshapi"
 	"github.com/webmeshproj/webmesh/pkg/services/meshdns"
 	"github.com/webmeshproj/webmesh/pkg/services/metrics"
+	"github.com/webmeshproj/webmesh/pkg/services/namespaces"
 	"github.com/webmeshproj/webmesh/pkg/services/node"
+	"github.com/webmeshproj/webmesh/pkg/services/presence"
 	"github.com/webmeshproj/webmesh/pkg/services/rbac"
 	"github.com/webmeshproj/webmesh/pkg/services/registrar"
 	"github.com/webmeshproj/webmesh/pkg/services/storage"
 	"github.com/webmeshproj/webmesh/pkg/services/turn"
 	"github.com/webmeshproj/webmesh/pkg/services/webrtc"
 	meshstorage "github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/audit"
 	"github.com/webmeshproj/webmesh/pkg/version"
 )
 
@@ -183,6 +191,10 @@ type APIOptions struct {
 	MeshEnabled bool `koanf:"mesh-enabled,omitempty"`
 	// AdminEnabled is true if the admin API should be registered.
 	AdminEnabled bool `koanf:"admin-enabled,omitempty"`
+	// DisableAudit is true if mutations should not be recorded in the audit log.
+	DisableAudit bool `koanf:"disable-audit,omitempty"`
+	// AuditRetention is how long entries are kept in the audit log. Zero keeps them forever.
+	AuditRetention time.Duration `koanf:"audit-retention,omitempty"`
 }
 
 // LibP2PAPIOptions are options for serving the API over libp2p.
@@ -209,6 +221,7 @@ func NewAPIOptions(disabled bool) APIOptions {
 		Disabled:       disabled,
 		ListenAddress:  services.DefaultGRPCListenAddress,
 		AllowedOrigins: []string{"*"},
+		AuditRetention: audit.DefaultRetention,
 	}
 }
 
@@ -216,9 +229,10 @@ func NewAPIOptions(disabled bool) APIOptions {
 // and insecure set to true.
 func NewInsecureAPIOptions(disabled bool) APIOptions {
 	return APIOptions{
-		Disabled:      disabled,
-		ListenAddress: services.DefaultGRPCListenAddress,
-		Insecure:      true,
+		Disabled:       disabled,
+		ListenAddress:  services.DefaultGRPCListenAddress,
+		Insecure:       true,
+		AuditRetention: audit.DefaultRetention,
 	}
 }
 
@@ -239,6 +253,8 @@ func (a *APIOptions) BindFlags(prefix string, fl *pflag.FlagSet) {
 	fl.BoolVar(&a.Insecure, prefix+"insecure", a.Insecure, "Disable TLS.")
 	fl.BoolVar(&a.MeshEnabled, prefix+"mesh-enabled", a.MeshEnabled, "Enable and register the MeshAPI.")
 	fl.BoolVar(&a.AdminEnabled, prefix+"admin-enabled", a.AdminEnabled, "Enable and register the AdminAPI.")
+	fl.BoolVar(&a.DisableAudit, prefix+"disable-audit", a.DisableAudit, "Disable recording mutations in the audit log.")
+	fl.DurationVar(&a.AuditRetention, prefix+"audit-retention", a.AuditRetention, "How long to keep entries in the audit log (0 = forever).")
 	a.LibP2P.BindFlags(prefix+"libp2p.", fl)
 }
 
@@ -494,8 +510,25 @@ type MeshDNSOptions struct {
 	DisableForwarding bool `koanf:"disable-forwarding,omitempty"`
 	// CacheSize is the size of the remote DNS cache.
 	CacheSize int `koanf:"cache-size,omitempty"`
+	// ServeStale is how long expired cache entries may be served while they are refreshed.
+	ServeStale time.Duration `koanf:"serve-stale,omitempty"`
+	// PrefetchThreshold is the number of cache hits after which entries are refreshed before expiring.
+	PrefetchThreshold int `koanf:"prefetch-threshold,omitempty"`
 	// IPv6Only will only respond to IPv6 requests.
 	IPv6Only bool `koanf:"ipv6-only,omitempty"`
+	// SplitHorizon only answers with the nodes the querying node can reach under network ACLs.
+	SplitHorizon bool `koanf:"split-horizon,omitempty"`
+	// DNSSEC signs the mesh zone with a key held in mesh storage.
+	DNSSEC bool `koanf:"dnssec,omitempty"`
+	// ListenTLS is the address to listen on for DNS-over-TLS requests.
+	ListenTLS string `koanf:"listen-tls,omitempty"`
+	// ListenHTTPS is the address to listen on for DNS-over-HTTPS requests.
+	ListenHTTPS string `koanf:"listen-https,omitempty"`
+	// TLSCertFile is the certificate for the DNS-over-TLS and DNS-over-HTTPS listeners.
+	// Defaults to the API certificate, or a self-signed certificate if there is none.
+	TLSCertFile string `koanf:"tls-cert-file,omitempty"`
+	// TLSKeyFile is the key for the DNS-over-TLS and DNS-over-HTTPS listeners.
+	TLSKeyFile string `koanf:"tls-key-file,omitempty"`
 }
 
 // NewMeshDNSOptions returns a new MeshDNSOptions with the default values.
@@ -512,7 +545,13 @@ func NewMeshDNSOptions() MeshDNSOptions {
 		SubscribeForwarders:    false,
 		DisableForwarding:      false,
 		CacheSize:              100,
+		ServeStale:             0,
+		PrefetchThreshold:      10,
 		IPv6Only:               false,
+		SplitHorizon:           false,
+		DNSSEC:                 false,
+		ListenTLS:              "",
+		ListenHTTPS:            "",
 	}
 }
 
@@ -529,7 +568,15 @@ func (m *MeshDNSOptions) BindFlags(prefix string, fl *pflag.FlagSet) {
 	fl.BoolVar(&m.SubscribeForwarders, prefix+"subscribe-forwarders", m.SubscribeForwarders, "Subscribe to new nodes that can forward requests.")
 	fl.BoolVar(&m.DisableForwarding, prefix+"disable-forwarding", m.DisableForwarding, "Disable forwarding requests.")
 	fl.IntVar(&m.CacheSize, prefix+"cache-size", m.CacheSize, "Size of the remote DNS cache (0 = disabled).")
+	fl.DurationVar(&m.ServeStale, prefix+"serve-stale", m.ServeStale, "How long expired cache entries may be served while they are refreshed (0 = disabled).")
+	fl.IntVar(&m.PrefetchThreshold, prefix+"prefetch-threshold", m.PrefetchThreshold, "Cache hits after which entries are refreshed before expiring (0 = disabled).")
 	fl.BoolVar(&m.IPv6Only, prefix+"ipv6-only", m.IPv6Only, "Only respond to IPv6 requests.")
+	fl.BoolVar(&m.SplitHorizon, prefix+"split-horizon", m.SplitHorizon, "Only answer with nodes the querying node can reach under network ACLs.")
+	fl.BoolVar(&m.DNSSEC, prefix+"dnssec", m.DNSSEC, "Sign the mesh zone with a key held in mesh storage.")
+	fl.StringVar(&m.ListenTLS, prefix+"listen-tls", m.ListenTLS, "Address to listen on for DNS-over-TLS requests (e.g. [::]:853).")
+	fl.StringVar(&m.ListenHTTPS, prefix+"listen-https", m.ListenHTTPS, "Address to listen on for DNS-over-HTTPS requests (e.g. [::]:443).")
+	fl.StringVar(&m.TLSCertFile, prefix+"tls-cert-file", m.TLSCertFile, "Certificate for encrypted DNS (default = API certificate).")
+	fl.StringVar(&m.TLSKeyFile, prefix+"tls-key-file", m.TLSKeyFile, "Key for encrypted DNS (default = API key).")
 }
 
 // ListenPort returns the listen port for the MeshDNS server is enabled.
@@ -568,6 +615,27 @@ func (m MeshDNSOptions) Validate() error {
 			return fmt.Errorf("services.meshdns.listen-udp is invalid: %w", err)
 		}
 	}
+	if m.ListenTLS != "" {
+		_, _, err := net.SplitHostPort(m.ListenTLS)
+		if err != nil {
+			return fmt.Errorf("services.meshdns.listen-tls is invalid: %w", err)
+		}
+	}
+	if m.ListenHTTPS != "" {
+		_, _, err := net.SplitHostPort(m.ListenHTTPS)
+		if err != nil {
+			return fmt.Errorf("services.meshdns.listen-https is invalid: %w", err)
+		}
+	}
+	if m.ServeStale < 0 {
+		return fmt.Errorf("services.meshdns.serve-stale must be >= 0")
+	}
+	if m.PrefetchThreshold < 0 {
+		return fmt.Errorf("services.meshdns.prefetch-threshold must be >= 0")
+	}
+	if (m.TLSCertFile == "") != (m.TLSKeyFile == "") {
+		return fmt.Errorf("services.meshdns.tls-cert-file and services.meshdns.tls-key-file must be set together")
+	}
 	if runtime.GOOS == "linux" {
 		if m.ReusePort < 0 {
 			return fmt.Errorf("services.meshdns.reuse-port must be >= 0")
@@ -683,11 +751,23 @@ func (o *ServiceOptions) NewServiceOptions(ctx context.Context, conn meshnode.No
 			unarymiddlewares = append(unarymiddlewares, leaderProxy.UnaryInterceptor())
 			streammiddlewares = append(streammiddlewares, leaderProxy.StreamInterceptor())
 		}
+		// Record mutations after the leader proxy, on the node that handles them
+		if !o.API.DisableAudit && conn.Storage().Consensus().IsMember() {
+			auditor := auditsvc.NewInterceptor(ctx, conn.ID(), conn.Storage().MeshStorage(), o.API.AuditRetention)
+			unarymiddlewares = append(unarymiddlewares, auditor.UnaryInterceptor())
+		}
 		conf.ServerOptions = append(conf.ServerOptions, grpc.ChainUnaryInterceptor(unarymiddlewares...))
 		conf.ServerOptions = append(conf.ServerOptions, grpc.ChainStreamInterceptor(streammiddlewares...))
 	}
 	// Append the enabled mesh services
 	if o.MeshDNS.Enabled {
+		var dnsTLSConfig *tls.Config
+		if o.MeshDNS.ListenTLS != "" || o.MeshDNS.ListenHTTPS != "" {
+			dnsTLSConfig, err = o.newMeshDNSTLSConfig(ctx)
+			if err != nil {
+				return conf, fmt.Errorf("failed to load meshdns tls config: %w", err)
+			}
+		}
 		dnsServer := meshdns.NewServer(ctx, &meshdns.Options{
 			UDPListenAddr:          o.MeshDNS.ListenUDP,
 			TCPListenAddr:          o.MeshDNS.ListenTCP,
@@ -698,6 +778,13 @@ func (o *ServiceOptions) NewServiceOptions(ctx context.Context, conn meshnode.No
 			IncludeSystemResolvers: o.MeshDNS.IncludeSystemResolvers,
 			DisableForwarding:      o.MeshDNS.DisableForwarding,
 			CacheSize:              o.MeshDNS.CacheSize,
+			ServeStale:             o.MeshDNS.ServeStale,
+			PrefetchThreshold:      o.MeshDNS.PrefetchThreshold,
+			SplitHorizon:           o.MeshDNS.SplitHorizon,
+			DNSSEC:                 o.MeshDNS.DNSSEC,
+			TLSListenAddr:          o.MeshDNS.ListenTLS,
+			HTTPSListenAddr:        o.MeshDNS.ListenHTTPS,
+			TLSConfig:              dnsTLSConfig,
 		})
 		// Automatically register the local domain
 		err := dnsServer.RegisterDomain(meshdns.DomainOptions{
@@ -731,6 +818,31 @@ func (o *ServiceOptions) NewServiceOptions(ctx context.Context, conn meshnode.No
 	return
 }
 
+// newMeshDNSTLSConfig returns the TLS configuration for encrypted DNS. It falls back
+// to the API certificate and then to a self-signed certificate.
+func (o *ServiceOptions) newMeshDNSTLSConfig(ctx context.Context) (*tls.Config, error) {
+	certFile, keyFile := o.MeshDNS.TLSCertFile, o.MeshDNS.TLSKeyFile
+	if certFile == "" {
+		certFile, keyFile = o.API.TLSCertFile, o.API.TLSKeyFile
+	}
+	if certFile != "" && keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		return &tls.Config{Certificates: []tls.Certificate{cert}}, nil
+	}
+	context.LoggerFrom(ctx).Info("Generating self-signed certificate for encrypted MeshDNS")
+	key, cert, err := crypto.GenerateSelfSignedServerCert()
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{Certificates: []tls.Certificate{{
+		Certificate: [][]byte{cert.Raw},
+		PrivateKey:  key,
+	}}}, nil
+}
+
 // NewServerOptions returns new options for the gRPC server.
 func (o *ServiceOptions) NewServerOptions(ctx context.Context) (grpc.ServerOption, error) {
 	if o.API.Insecure {
@@ -859,15 +971,63 @@ func (o *ServiceOptions) RegisterAPIs(ctx context.Context, opts APIRegistrationO
 		log.Debug("Registering storage service")
 		storageSrv := storage.NewServer(ctx, opts.Node.Storage(), rbacEvaluator, opts.Node.Network())
 		v1.RegisterStorageQueryServiceServer(opts.Server, storageSrv)
+		log.Debug("Registering locks service")
+		locksSrv := locks.NewServer(ctx, opts.Node.ID(), opts.Node.Storage(), rbacEvaluator, opts.Node.Network())
+		if err := locks.RegisterMeshLocksServer(opts.Server, locksSrv); err != nil {
+			return fmt.Errorf("register locks service: %w", err)
+		}
+		log.Debug("Registering presence service")
+		presenceSrv := presence.NewServer(ctx, opts.Node.Storage(), opts.Node.Network())
+		if err := presence.RegisterMeshPresenceServer(opts.Server, presenceSrv); err != nil {
+			return fmt.Errorf("register presence service: %w", err)
+		}
+		log.Debug("Registering autopilot service")
+		if err := autopilot.RegisterMeshAutopilotServer(opts.Server, autopilot.NewServer(opts.Node.Storage())); err != nil {
+			return fmt.Errorf("register autopilot service: %w", err)
+		}
+		log.Debug("Registering audit service")
+		auditSrv := auditsvc.NewServer(ctx, opts.Node.Storage(), rbacEvaluator, opts.Node.Network())
+		if err := auditsvc.RegisterMeshAuditServer(opts.Server, auditSrv); err != nil {
+			return fmt.Errorf("register audit service: %w", err)
+		}
+	} else if meshstorage.IsReadReplica(opts.Node.Storage()) {
+		// Read replicas serve queries and watches from their local copy.
+		log.Debug("Registering storage service for read replica")
+		storageSrv := storage.NewServer(ctx, opts.Node.Storage(), rbacEvaluator, opts.Node.Network())
+		v1.RegisterStorageQueryServiceServer(opts.Server, storageSrv)
 	}
 	// Register any other enabled APIs
 	if o.API.MeshEnabled {
 		log.Debug("Registering mesh api")
-		v1.RegisterMeshServer(opts.Server, meshapi.NewServer(opts.Node.Storage().MeshDB()))
+		v1.RegisterMeshServer(opts.Server, meshapi.NewServer(opts.Node.Storage()))
 	}
 	if o.API.AdminEnabled {
 		log.Debug("Registering admin api")
-		v1.RegisterAdminServer(opts.Server, admin.NewServer(opts.Node.Storage(), rbacEvaluator))
+		adminSrv := admin.NewServer(opts.Node.Storage(), rbacEvaluator)
+		v1.RegisterAdminServer(opts.Server, adminSrv)
+		if err := admin.RegisterAdminRBACServer(opts.Server, adminSrv); err != nil {
+			return fmt.Errorf("register admin rbac service: %w", err)
+		}
+		if err := admin.RegisterAdminNetworkACLsServer(opts.Server, adminSrv); err != nil {
+			return fmt.Errorf("register admin network acls service: %w", err)
+		}
+		if opts.Node.Storage().Consensus().IsMember() {
+			log.Debug("Registering namespaces service")
+			namespacesSrv := namespaces.NewServer(ctx, opts.Node.Storage(), rbacEvaluator)
+			if err := namespaces.RegisterMeshNamespacesServer(opts.Server, namespacesSrv); err != nil {
+				return fmt.Errorf("register namespaces service: %w", err)
+			}
+			log.Debug("Registering federation service")
+			federationSrv := federation.NewServer(ctx, opts.Node.Storage(), rbacEvaluator)
+			if err := federation.RegisterMeshFederationServer(opts.Server, federationSrv); err != nil {
+				return fmt.Errorf("register federation service: %w", err)
+			}
+			log.Debug("Registering dns records service")
+			dnsRecordsSrv := dnsrecords.NewServer(ctx, opts.Node.Storage(), rbacEvaluator)
+			if err := dnsrecords.RegisterMeshDNSRecordsServer(opts.Server, dnsRecordsSrv); err != nil {
+				return fmt.Errorf("register dns records service: %w", err)
+			}
+		}
 	}
 	if o.WebRTC.Enabled {
 		log.Debug("Registering WebRTC api")
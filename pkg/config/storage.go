@@ -28,8 +28,10 @@ import (
 	"google.golang.org/protobuf/types/known/structpb"
 
 	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/crypto"
 	"github.com/webmeshproj/webmesh/pkg/meshnode"
 	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/encryption"
 	extstorage "github.com/webmeshproj/webmesh/pkg/storage/providers/external"
 	passthroughstorage "github.com/webmeshproj/webmesh/pkg/storage/providers/passthrough"
 	raftstorage "github.com/webmeshproj/webmesh/pkg/storage/providers/raftstorage"
@@ -71,6 +73,12 @@ type StorageOptions struct {
 	Raft RaftOptions `koanf:"raft,omitempty"`
 	// External are the external storage options.
 	External ExternalStorageOptions `koanf:"external,omitempty"`
+	// Encryption are the options for encrypting storage at rest.
+	Encryption StorageEncryptionOptions `koanf:"encryption,omitempty"`
+	// ReadReplica keeps a local read-only copy of the parts of the mesh
+	// registry read by MeshDB on nodes that are not storage members, so
+	// those reads are served locally instead of by a storage node.
+	ReadReplica bool `koanf:"read-replica,omitempty"`
 	// LogLevel is the log level for the storage provider.
 	LogLevel string `koanf:"log-level,omitempty"`
 	// LogFormat is the log format for the storage provider.
@@ -93,10 +101,12 @@ func (o *StorageOptions) BindFlags(prefix string, fs *pflag.FlagSet) {
 	fs.BoolVar(&o.InMemory, prefix+"in-memory", o.InMemory, "Use in-memory storage")
 	fs.StringVar(&o.Path, prefix+"path", o.Path, "Path to the storage directory")
 	fs.StringVar(&o.Provider, prefix+"provider", o.Provider, "Storage provider (defaults to raftstorage or passthrough depending on other options)")
+	fs.BoolVar(&o.ReadReplica, prefix+"read-replica", o.ReadReplica, "Keep a local read-only copy of the mesh registry when not a storage member")
 	fs.StringVar(&o.LogLevel, prefix+"log-level", o.LogLevel, "Log level for the storage provider")
 	fs.StringVar(&o.LogFormat, prefix+"log-format", o.LogFormat, "Log format for the storage provider")
 	o.Raft.BindFlags(prefix+"raft.", fs)
 	o.External.BindFlags(prefix+"external.", fs)
+	o.Encryption.BindFlags(prefix+"encryption.", fs)
 }
 
 // Validate validates the storage options.
@@ -117,6 +127,15 @@ func (o StorageOptions) Validate(isMember bool) error {
 			return err
 		}
 	}
+	if o.ReadReplica && isMember && provider != StorageProviderPassThrough {
+		return fmt.Errorf("read replicas are only supported on nodes that are not storage members")
+	}
+	if !o.Encryption.IsEmpty() && provider != StorageProviderRaft && provider != "" {
+		return fmt.Errorf("storage encryption is only supported by the raft storage provider")
+	}
+	if err := o.Encryption.Validate(); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -133,15 +152,32 @@ func (o StorageOptions) ListenPort() int {
 // is required for the passthrough storage provider.
 func (o *Config) NewStorageProvider(ctx context.Context, node meshnode.Node, force bool) (storage.Provider, error) {
 	if !o.IsStorageMember() {
-		return passthroughstorage.NewProvider(o.Storage.NewPassthroughOptions(ctx, node)), nil
+		return o.Storage.NewPassthroughStorageProvider(ctx, node)
 	}
 	switch StorageProvider(o.Storage.Provider) {
 	case StorageProviderRaft, "":
-		return o.Storage.NewRaftStorageProvider(ctx, node, force)
+		if !o.Storage.Encryption.FromWireGuard || o.Storage.InMemory || o.WireGuard.PreviousKey() == nil {
+			return o.Storage.NewRaftStorageProvider(ctx, node, force)
+		}
+		// Re-encrypt storage with the rotated WireGuard key before the
+		// previous one is discarded.
+		o.Storage.Encryption.previousWireGuardKey = o.WireGuard.PreviousKey()
+		opts, err := o.Storage.NewRaftOptions(ctx, node, force)
+		if err != nil {
+			return nil, err
+		}
+		if err := raftstorage.RotateEncryption(opts); err != nil {
+			return nil, err
+		}
+		o.Storage.Encryption.previousWireGuardKey = nil
+		if err := o.WireGuard.ForgetPreviousKey(); err != nil {
+			return nil, err
+		}
+		return raftstorage.NewProvider(opts), nil
 	case StorageProviderExternal:
 		return o.Storage.NewExternalStorageProvider(ctx, node.ID())
 	case StorageProviderPassThrough:
-		return passthroughstorage.NewProvider(o.Storage.NewPassthroughOptions(ctx, node)), nil
+		return o.Storage.NewPassthroughStorageProvider(ctx, node)
 	default:
 		return nil, fmt.Errorf("invalid storage provider: %s", o.Storage.Provider)
 	}
@@ -156,6 +192,15 @@ func (o StorageOptions) NewRaftStorageProvider(ctx context.Context, node meshnod
 	return raftstorage.NewProvider(opts), nil
 }
 
+// NewPassthroughStorageProvider returns a new passthrough storage provider for the current configuration.
+func (o StorageOptions) NewPassthroughStorageProvider(ctx context.Context, node meshnode.Node) (storage.Provider, error) {
+	opts := o.NewPassthroughOptions(ctx, node)
+	if o.ReadReplica {
+		return passthroughstorage.NewReplicaProvider(opts)
+	}
+	return passthroughstorage.NewProvider(opts), nil
+}
+
 // NewExternalStorageProvider returns a new external storage provider for the current configuration.
 func (o StorageOptions) NewExternalStorageProvider(ctx context.Context, nodeID types.NodeID) (storage.Provider, error) {
 	opts, err := o.NewExternalStorageOptions(ctx, nodeID)
@@ -187,8 +232,15 @@ func (o StorageOptions) NewRaftOptions(ctx context.Context, node meshnode.Node,
 	opts.SnapshotThreshold = o.Raft.SnapshotThreshold
 	opts.SnapshotRetention = o.Raft.SnapshotRetention
 	opts.ObserverChanBuffer = o.Raft.ObserverChanBuffer
+	opts.WatchHistory = o.Raft.WatchHistory
 	opts.LogLevel = o.LogLevel
 	opts.LogFormat = o.LogFormat
+	if !o.InMemory {
+		opts.Encryption, err = o.Encryption.NewKeys(node.Key())
+		if err != nil {
+			return raftstorage.Options{}, fmt.Errorf("load storage encryption keys: %w", err)
+		}
+	}
 	return opts, nil
 }
 
@@ -230,6 +282,90 @@ func (o StorageOptions) NewExternalStorageOptions(ctx context.Context, nodeID ty
 	return opts, nil
 }
 
+// StorageEncryptionOptions are options for encrypting storage at rest.
+// At most one source may be set for the current key and one for the
+// previous key. Keys are 16, 24 or 32 bytes, given raw, hex or base64
+// encoded.
+type StorageEncryptionOptions struct {
+	// KeyFile is the path to a file containing the encryption key.
+	KeyFile string `koanf:"key-file,omitempty"`
+	// KeyEnv is the name of an environment variable containing the encryption key.
+	KeyEnv string `koanf:"key-env,omitempty"`
+	// FromWireGuard derives the encryption key from the node's WireGuard key.
+	// The WireGuard key must be persisted to a key file. When it is rotated,
+	// the old key is kept next to it with a ".previous" suffix until storage
+	// has been re-encrypted with the new key.
+	FromWireGuard bool `koanf:"from-wireguard,omitempty"`
+	// PreviousKeyFile is the path to a file containing the key storage was
+	// previously encrypted with. Set it along with a new key to rotate keys,
+	// or alone to decrypt storage.
+	PreviousKeyFile string `koanf:"previous-key-file,omitempty"`
+	// PreviousKeyEnv is the name of an environment variable containing the
+	// key storage was previously encrypted with.
+	PreviousKeyEnv string `koanf:"previous-key-env,omitempty"`
+
+	// previousWireGuardKey is the WireGuard key replaced by a rotation on this start.
+	previousWireGuardKey crypto.PrivateKey `koanf:"-"`
+}
+
+// BindFlags binds the storage encryption options to the flag set.
+func (o *StorageEncryptionOptions) BindFlags(prefix string, fs *pflag.FlagSet) {
+	fs.StringVar(&o.KeyFile, prefix+"key-file", o.KeyFile, "Path to a file containing the storage encryption key")
+	fs.StringVar(&o.KeyEnv, prefix+"key-env", o.KeyEnv, "Environment variable containing the storage encryption key")
+	fs.BoolVar(&o.FromWireGuard, prefix+"from-wireguard", o.FromWireGuard, "Derive the storage encryption key from the WireGuard key")
+	fs.StringVar(&o.PreviousKeyFile, prefix+"previous-key-file", o.PreviousKeyFile, "Path to a file containing the previous storage encryption key, used for rotation")
+	fs.StringVar(&o.PreviousKeyEnv, prefix+"previous-key-env", o.PreviousKeyEnv, "Environment variable containing the previous storage encryption key, used for rotation")
+}
+
+// Validate validates the storage encryption options.
+func (o StorageEncryptionOptions) Validate() error {
+	sources := 0
+	for _, set := range []bool{o.KeyFile != "", o.KeyEnv != "", o.FromWireGuard} {
+		if set {
+			sources++
+		}
+	}
+	if sources > 1 {
+		return fmt.Errorf("only one of key-file, key-env or from-wireguard may be set for storage encryption")
+	}
+	if o.PreviousKeyFile != "" && o.PreviousKeyEnv != "" {
+		return fmt.Errorf("only one of previous-key-file or previous-key-env may be set for storage encryption")
+	}
+	return nil
+}
+
+// IsEmpty returns true if no keys are configured.
+func (o StorageEncryptionOptions) IsEmpty() bool {
+	return o.KeyFile == "" && o.KeyEnv == "" && !o.FromWireGuard && o.PreviousKeyFile == "" && o.PreviousKeyEnv == ""
+}
+
+// NewKeys loads the configured encryption keys. The WireGuard key is
+// only used when FromWireGuard is set.
+func (o StorageEncryptionOptions) NewKeys(wireguardKey crypto.PrivateKey) (encryption.Keys, error) {
+	var keys encryption.Keys
+	var err error
+	switch {
+	case o.KeyFile != "":
+		keys.Current, err = encryption.KeyFromFile(o.KeyFile)
+	case o.KeyEnv != "":
+		keys.Current, err = encryption.KeyFromEnv(o.KeyEnv)
+	case o.FromWireGuard:
+		keys.Current, err = encryption.KeyFromWireGuard(wireguardKey)
+	}
+	if err != nil {
+		return keys, err
+	}
+	switch {
+	case o.PreviousKeyFile != "":
+		keys.Previous, err = encryption.KeyFromFile(o.PreviousKeyFile)
+	case o.PreviousKeyEnv != "":
+		keys.Previous, err = encryption.KeyFromEnv(o.PreviousKeyEnv)
+	case o.FromWireGuard && o.previousWireGuardKey != nil:
+		keys.Previous, err = encryption.KeyFromWireGuard(o.previousWireGuardKey)
+	}
+	return keys, err
+}
+
 // ExternalStorageOptions are the external storage options.
 type ExternalStorageOptions struct {
 	// Server is the address of a server for the plugin.
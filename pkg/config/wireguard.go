@@ -17,6 +17,7 @@ limitations under the License.
 package config
 
 import (
+	"errors"
 	"fmt"
 	"log/slog"
 	"os"
@@ -70,6 +71,10 @@ type WireGuardOptions struct {
 
 	// loaded is an already loaded key from the configuration.
 	loaded crypto.PrivateKey `koanf:"-"`
+	// previous is the key replaced by a rotation in LoadKey.
+	previous crypto.PrivateKey `koanf:"-"`
+	// keepPrevious keeps a rotated key on disk until ForgetPreviousKey is called.
+	keepPrevious bool `koanf:"-"`
 }
 
 // NewWireGuardOptions returns a new WireGuardOptions with sensible defaults.
@@ -92,6 +97,30 @@ func NewWireGuardOptions() WireGuardOptions {
 	}
 }
 
+// PreviousKey returns the key that was replaced if LoadKey rotated the
+// key file, or nil.
+func (o *WireGuardOptions) PreviousKey() crypto.PrivateKey {
+	return o.previous
+}
+
+// ForgetPreviousKey removes a rotated key kept on disk once nothing
+// depends on it anymore.
+func (o *WireGuardOptions) ForgetPreviousKey() error {
+	o.previous = nil
+	if o.KeyFile == "" {
+		return nil
+	}
+	if err := os.Remove(o.previousKeyFile()); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("remove previous wireguard key file: %w", err)
+	}
+	return nil
+}
+
+// previousKeyFile is where a rotated key is kept until it is forgotten.
+func (o *WireGuardOptions) previousKeyFile() string {
+	return o.KeyFile + ".previous"
+}
+
 // SetKey is a convenience method for setting a preloaded key to these wireguard options
 // so that calls to LoadKey will return the preloaded key.
 func (o *WireGuardOptions) SetKey(key crypto.PrivateKey) {
@@ -175,13 +204,39 @@ func (o *WireGuardOptions) LoadKey(ctx context.Context) (crypto.PrivateKey, erro
 	if stat.IsDir() {
 		return nil, fmt.Errorf("wireguard key file is a directory")
 	}
+	if o.keepPrevious {
+		// A key kept by an earlier rotation is still needed until whatever
+		// was derived from it has been rotated.
+		previous, err := readKeyFile(o.previousKeyFile())
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("read previous wireguard key file: %w", err)
+		}
+		if previous != nil {
+			o.previous = previous
+		}
+	}
 	// Check if the key is expired
-	if o.KeyRotationInterval > 0 {
-		if stat.ModTime().Add(o.KeyRotationInterval).Before(time.Now()) {
-			// Delete the key file if it's older than the key rotation interval.
-			log.Debug("Removing expired WireGuard key file", slog.String("file", o.KeyFile))
-			if err := os.Remove(o.KeyFile); err != nil {
-				return nil, fmt.Errorf("remove expired wireguard key file: %w", err)
+	if o.KeyRotationInterval > 0 && stat.ModTime().Add(o.KeyRotationInterval).Before(time.Now()) {
+		if o.previous != nil {
+			log.Warn("Postponing WireGuard key rotation until the previous key is no longer needed", slog.String("file", o.KeyFile))
+		} else {
+			if o.keepPrevious {
+				// Keep the expired key around so anything derived from it can be rotated.
+				log.Debug("Keeping expired WireGuard key file", slog.String("file", o.previousKeyFile()))
+				previous, err := readKeyFile(o.KeyFile)
+				if err != nil {
+					return nil, fmt.Errorf("read expired wireguard key file: %w", err)
+				}
+				if err := os.Rename(o.KeyFile, o.previousKeyFile()); err != nil {
+					return nil, fmt.Errorf("keep expired wireguard key file: %w", err)
+				}
+				o.previous = previous
+			} else {
+				// Delete the key file if it's older than the key rotation interval.
+				log.Debug("Removing expired WireGuard key file", slog.String("file", o.KeyFile))
+				if err := os.Remove(o.KeyFile); err != nil {
+					return nil, fmt.Errorf("remove expired wireguard key file: %w", err)
+				}
 			}
 			// Generate a new key and save it to the file
 			log.Debug("Generating new WireGuard key and saving to file", slog.String("file", o.KeyFile))
@@ -199,7 +254,24 @@ func (o *WireGuardOptions) LoadKey(ctx context.Context) (crypto.PrivateKey, erro
 	}
 	// Load the key from the file
 	log.Debug("Loading WireGuard key from file", slog.String("file", o.KeyFile))
-	keyData, err := os.ReadFile(o.KeyFile)
+	key, err := readKeyFile(o.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	o.loaded = key
+	return key, nil
+}
+
+// LoadWireGuardKey loads the WireGuard key of the node. When storage is
+// encrypted with a key derived from it, a rotated key is kept until
+// NewStorageProvider has re-encrypted storage with the new one.
+func (o *Config) LoadWireGuardKey(ctx context.Context) (crypto.PrivateKey, error) {
+	o.WireGuard.keepPrevious = o.Storage.Encryption.FromWireGuard && o.IsStorageMember() && !o.Storage.InMemory
+	return o.WireGuard.LoadKey(ctx)
+}
+
+func readKeyFile(path string) (crypto.PrivateKey, error) {
+	keyData, err := os.ReadFile(path)
 	if err != nil {
 		return nil, fmt.Errorf("read key file: %w", err)
 	}
@@ -207,6 +279,5 @@ func (o *WireGuardOptions) LoadKey(ctx context.Context) (crypto.PrivateKey, erro
 	if err != nil {
 		return nil, fmt.Errorf("decode key: %w", err)
 	}
-	o.loaded = key
 	return key, nil
 }
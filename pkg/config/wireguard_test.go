@@ -0,0 +1,110 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/crypto"
+)
+
+func TestLoadWireGuardKeyRotation(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	newExpiredKey := func(t *testing.T) (crypto.PrivateKey, string) {
+		t.Helper()
+		keyFile := filepath.Join(t.TempDir(), "wireguard.key")
+		key := crypto.MustGenerateKey()
+		if err := crypto.EncodeKeyToFile(key, keyFile); err != nil {
+			t.Fatal(err)
+		}
+		expired := time.Now().Add(-time.Hour)
+		if err := os.Chtimes(keyFile, expired, expired); err != nil {
+			t.Fatal(err)
+		}
+		return key, keyFile
+	}
+	newConfig := func(keyFile string, fromWireGuard bool) *Config {
+		conf := NewDefaultConfig("node")
+		conf.Bootstrap.Enabled = true
+		conf.Storage.Encryption.FromWireGuard = fromWireGuard
+		conf.WireGuard.KeyFile = keyFile
+		conf.WireGuard.KeyRotationInterval = time.Minute
+		return conf
+	}
+
+	t.Run("RemovesExpiredKey", func(t *testing.T) {
+		t.Parallel()
+		old, keyFile := newExpiredKey(t)
+		conf := newConfig(keyFile, false)
+		key, err := conf.LoadWireGuardKey(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if key.Equals(old) {
+			t.Fatal("expected the expired key to be rotated")
+		}
+		if _, err := os.Stat(keyFile + ".previous"); !os.IsNotExist(err) {
+			t.Fatalf("expected no previous key file, got %v", err)
+		}
+	})
+
+	t.Run("KeepsKeyUsedForStorage", func(t *testing.T) {
+		t.Parallel()
+		old, keyFile := newExpiredKey(t)
+		conf := newConfig(keyFile, true)
+		key, err := conf.LoadWireGuardKey(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if key.Equals(old) {
+			t.Fatal("expected the expired key to be rotated")
+		}
+		if prev := conf.WireGuard.PreviousKey(); prev == nil || !prev.Equals(old) {
+			t.Fatal("expected the expired key to be returned as the previous key")
+		}
+
+		// An interrupted start picks the kept key up again and
+		// does not rotate over it.
+		if err := os.Chtimes(keyFile, time.Now().Add(-time.Hour), time.Now().Add(-time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+		restarted := newConfig(keyFile, true)
+		reloaded, err := restarted.LoadWireGuardKey(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reloaded.Equals(key) {
+			t.Fatal("expected rotation to wait for the previous key to be forgotten")
+		}
+		if prev := restarted.WireGuard.PreviousKey(); prev == nil || !prev.Equals(old) {
+			t.Fatal("expected the kept key to be loaded as the previous key")
+		}
+
+		if err := restarted.WireGuard.ForgetPreviousKey(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(keyFile + ".previous"); !os.IsNotExist(err) {
+			t.Fatalf("expected the previous key file to be removed, got %v", err)
+		}
+	})
+}
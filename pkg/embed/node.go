@@ -25,6 +25,8 @@ import (
 	"net/netip"
 	"sync"
 
+	"google.golang.org/grpc"
+
 	"github.com/webmeshproj/webmesh/pkg/config"
 	"github.com/webmeshproj/webmesh/pkg/context"
 	"github.com/webmeshproj/webmesh/pkg/crypto"
@@ -35,6 +37,7 @@ import (
 	"github.com/webmeshproj/webmesh/pkg/services"
 	"github.com/webmeshproj/webmesh/pkg/services/meshdns"
 	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/locks"
 	"github.com/webmeshproj/webmesh/pkg/storage/types"
 	"github.com/webmeshproj/webmesh/pkg/version"
 )
@@ -64,6 +67,13 @@ type Node interface {
 	AddressV4() netip.Prefix
 	// AddressV6 returns the IPv6 address of the node.
 	AddressV6() netip.Prefix
+	// Locker returns a Locker for acquiring distributed locks and running
+	// leader elections in the mesh storage. The node must be started.
+	Locker(opts locks.Options) (*locks.Locker, error)
+	// AppliedIndex returns the highest storage log index returned to connections
+	// dialed from the node. Reads made through those connections are served
+	// once the answering node has applied it, so they observe earlier writes.
+	AppliedIndex() uint64
 }
 
 // Options are the options for creating a new embedded webmesh node.
@@ -122,6 +132,7 @@ type node struct {
 	services *services.Server
 	meshdns  *meshdns.Server
 	errs     chan error
+	tracker  storage.IndexTracker
 	mu       sync.Mutex
 }
 
@@ -153,6 +164,13 @@ func (n *node) AddressV6() netip.Prefix {
 	return n.mesh.Network().WireGuard().AddressV6()
 }
 
+func (n *node) Locker(opts locks.Options) (*locks.Locker, error) {
+	if opts.NodeID == "" {
+		opts.NodeID = n.mesh.ID()
+	}
+	return locks.NewLocker(n.storage.MeshStorage(), opts)
+}
+
 func (n *node) Start(ctx context.Context) error {
 	n.mu.Lock()
 	defer n.mu.Unlock()
@@ -232,11 +250,29 @@ func (s *node) Dial(ctx context.Context, network, address string) (net.Conn, err
 }
 
 func (s *node) DialLeader(ctx context.Context) (transport.RPCClientConn, error) {
-	return s.mesh.DialLeader(ctx)
+	c, err := s.mesh.DialLeader(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &trackedConn{ClientConnInterface: s.tracker.Conn(c), Closer: c}, nil
 }
 
 func (s *node) DialNode(ctx context.Context, nodeID types.NodeID) (transport.RPCClientConn, error) {
-	return s.mesh.DialNode(ctx, nodeID)
+	c, err := s.mesh.DialNode(ctx, nodeID)
+	if err != nil {
+		return nil, err
+	}
+	return &trackedConn{ClientConnInterface: s.tracker.Conn(c), Closer: c}, nil
+}
+
+func (n *node) AppliedIndex() uint64 {
+	return n.tracker.Index()
+}
+
+// trackedConn is a connection dialed from the node that reads its own writes.
+type trackedConn struct {
+	grpc.ClientConnInterface
+	io.Closer
 }
 
 func (n *node) Stop(ctx context.Context) error {
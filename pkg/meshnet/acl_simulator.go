@@ -0,0 +1,212 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshnet
+
+import (
+	"fmt"
+	"net/netip"
+	"sort"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// ACLCheck is the evaluation of a single network action against the network ACLs.
+type ACLCheck struct {
+	// SrcCIDR is the source address of the action.
+	SrcCIDR string `json:"srcCIDR"`
+	// DstCIDR is the destination address or prefix of the action.
+	DstCIDR string `json:"dstCIDR"`
+	// Route is the route of the destination node that exposes the prefix. It
+	// is empty for the private addresses of the node and requested prefixes.
+	Route string `json:"route,omitempty"`
+	// ACL is the name of the first ACL that matched the action. It is empty
+	// when no ACL matched and the action was denied by default.
+	ACL string `json:"acl,omitempty"`
+	// Priority is the priority of the matching ACL.
+	Priority int32 `json:"priority,omitempty"`
+	// Allowed is true if the action was accepted.
+	Allowed bool `json:"allowed"`
+}
+
+// ConnectivityVerdict explains whether a node may reach another node.
+type ConnectivityVerdict struct {
+	// Source is the node the traffic originates from.
+	Source string `json:"source"`
+	// Destination is the node the traffic is sent to.
+	Destination string `json:"destination"`
+	// Allowed is true if the source may reach the destination.
+	Allowed bool `json:"allowed"`
+	// Reason describes the check that decided the verdict.
+	Reason string `json:"reason"`
+	// Checks are the actions that were evaluated, in order.
+	Checks []ACLCheck `json:"checks"`
+}
+
+// ACLSimulator evaluates connectivity between nodes against the network ACLs
+// the same way FilterGraph does when computing the peers of a node.
+type ACLSimulator struct {
+	graph types.PeerGraph
+	nw    storage.Networking
+	acls  types.NetworkACLs
+}
+
+// NewACLSimulator returns a simulator for the network ACLs in the given database.
+// Proposed ACLs replace the stored ACLs with the same names or are added to them,
+// which allows a policy change to be checked before it is applied.
+func NewACLSimulator(ctx context.Context, db storage.MeshDB, proposed ...types.NetworkACL) (*ACLSimulator, error) {
+	acls, err := loadNetworkACLs(ctx, db, proposed...)
+	if err != nil {
+		return nil, err
+	}
+	return &ACLSimulator{
+		graph: db.Peers().Graph(),
+		nw:    db.Networking(),
+		acls:  acls,
+	}, nil
+}
+
+// Test returns the verdict for traffic from the source to the destination node.
+// When no prefixes are given, the private addresses of the destination and the
+// prefixes of all of its routes are checked. Like FilterGraph, a single denied
+// route denies the whole node. When prefixes are given, only they are checked
+// as destinations.
+func (s *ACLSimulator) Test(ctx context.Context, src, dst types.NodeID, prefixes []netip.Prefix) (ConnectivityVerdict, error) {
+	verdict := ConnectivityVerdict{Source: src.String(), Destination: dst.String()}
+	srcNode, err := s.graph.Vertex(src)
+	if err != nil {
+		return verdict, fmt.Errorf("get source node: %w", err)
+	}
+	dstNode, err := s.graph.Vertex(dst)
+	if err != nil {
+		return verdict, fmt.Errorf("get destination node: %w", err)
+	}
+	if len(s.acls) == 0 {
+		verdict.Reason = "no network ACLs are defined, all traffic is denied"
+		return verdict, nil
+	}
+	if len(prefixes) > 0 {
+		for _, prefix := range prefixes {
+			check := s.check(ctx, srcNode, dstNode, sourceAddr(srcNode, prefix), prefix.String(), "")
+			verdict.Checks = append(verdict.Checks, check)
+			if !check.Allowed {
+				verdict.Reason = fmt.Sprintf("traffic to %s is %s", prefix, deniedBy(check))
+				return verdict, nil
+			}
+		}
+		verdict.Allowed = true
+		verdict.Reason = "all requested prefixes are accepted"
+		return verdict, nil
+	}
+	// The nodes may communicate if either address family is accepted.
+	v4 := s.check(ctx, srcNode, dstNode, srcNode.GetPrivateIPv4(), dstNode.GetPrivateIPv4(), "")
+	v6 := s.check(ctx, srcNode, dstNode, srcNode.GetPrivateIPv6(), dstNode.GetPrivateIPv6(), "")
+	verdict.Checks = append(verdict.Checks, v4, v6)
+	if !v4.Allowed && !v6.Allowed {
+		denied := v4
+		if denied.ACL == "" {
+			denied = v6
+		}
+		verdict.Reason = fmt.Sprintf("traffic to the node addresses is %s", deniedBy(denied))
+		return verdict, nil
+	}
+	routes, err := s.nw.GetRoutesByNode(ctx, dst)
+	if err != nil {
+		return verdict, fmt.Errorf("get routes by node: %w", err)
+	}
+	for _, route := range routes {
+		for _, prefix := range route.DestinationPrefixes() {
+			check := s.check(ctx, srcNode, dstNode, sourceAddr(srcNode, prefix), prefix.String(), route.GetName())
+			verdict.Checks = append(verdict.Checks, check)
+			if !check.Allowed {
+				verdict.Reason = fmt.Sprintf("traffic to %s via route %q is %s, which filters out the whole node", prefix, route.GetName(), deniedBy(check))
+				return verdict, nil
+			}
+		}
+	}
+	verdict.Allowed = true
+	if v4.ACL != "" && v4.Allowed {
+		verdict.Reason = fmt.Sprintf("accepted by ACL %q", v4.ACL)
+	} else {
+		verdict.Reason = fmt.Sprintf("accepted by ACL %q", v6.ACL)
+	}
+	return verdict, nil
+}
+
+// Matrix returns the verdicts for every ordered pair of nodes in the mesh,
+// sorted by source and then destination.
+func (s *ACLSimulator) Matrix(ctx context.Context) ([]ConnectivityVerdict, error) {
+	adjacency, err := s.graph.AdjacencyMap()
+	if err != nil {
+		return nil, fmt.Errorf("get adjacency map: %w", err)
+	}
+	nodes := make([]types.NodeID, 0, len(adjacency))
+	for id := range adjacency {
+		nodes = append(nodes, id)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i] < nodes[j] })
+	out := make([]ConnectivityVerdict, 0, len(nodes)*len(nodes))
+	for _, src := range nodes {
+		for _, dst := range nodes {
+			if src == dst {
+				continue
+			}
+			verdict, err := s.Test(ctx, src, dst, nil)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, verdict)
+		}
+	}
+	return out, nil
+}
+
+func (s *ACLSimulator) check(ctx context.Context, src, dst types.MeshNode, srcCIDR, dstCIDR, route string) ACLCheck {
+	check := ACLCheck{SrcCIDR: srcCIDR, DstCIDR: dstCIDR, Route: route}
+	acl, ok := s.acls.Evaluate(ctx, types.NetworkAction{
+		NetworkAction: &v1.NetworkAction{
+			SrcNode: src.GetId(),
+			SrcCIDR: srcCIDR,
+			DstNode: dst.GetId(),
+			DstCIDR: dstCIDR,
+		},
+	})
+	if ok {
+		check.ACL = acl.GetName()
+		check.Priority = acl.GetPriority()
+		check.Allowed = acl.GetAction() == v1.ACLAction_ACTION_ACCEPT
+	}
+	return check
+}
+
+// sourceAddr returns the private address of the node in the family of the prefix.
+func sourceAddr(node types.MeshNode, prefix netip.Prefix) string {
+	if prefix.Addr().Is4() {
+		return node.GetPrivateIPv4()
+	}
+	return node.GetPrivateIPv6()
+}
+
+func deniedBy(check ACLCheck) string {
+	if check.ACL == "" {
+		return "denied because no ACL matches"
+	}
+	return fmt.Sprintf("denied by ACL %q", check.ACL)
+}
@@ -0,0 +1,146 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshnet
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+func TestACLSimulator(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	db := setupGraphTest(t, graphSetup{
+		nodes: []types.MeshNode{
+			{
+				MeshNode: &v1.MeshNode{
+					Id:          "node-a",
+					PublicKey:   generateEncodedKey(t),
+					PrivateIPv4: "172.16.0.1/32",
+					PrivateIPv6: "fe80::1/128",
+				},
+			},
+			{
+				MeshNode: &v1.MeshNode{
+					Id:          "node-b",
+					PublicKey:   generateEncodedKey(t),
+					PrivateIPv4: "172.16.0.2/32",
+					PrivateIPv6: "fe80::2/128",
+				},
+			},
+		},
+		edges: []types.MeshEdge{
+			{MeshEdge: &v1.MeshEdge{Source: "node-a", Target: "node-b"}},
+			{MeshEdge: &v1.MeshEdge{Source: "node-b", Target: "node-a"}},
+		},
+		acls: []*v1.NetworkACL{
+			{
+				Name:             "deny-private",
+				Priority:         100,
+				Action:           v1.ACLAction_ACTION_DENY,
+				SourceNodes:      []string{"*"},
+				DestinationNodes: []string{"*"},
+				DestinationCIDRs: []string{"10.10.0.0/16"},
+			},
+			{
+				Name:             "allow-all",
+				Action:           v1.ACLAction_ACTION_ACCEPT,
+				SourceNodes:      []string{"*"},
+				DestinationNodes: []string{"*"},
+			},
+		},
+		routes: []*v1.Route{
+			{
+				Name:             "node-b-private",
+				Node:             "node-b",
+				DestinationCIDRs: []string{"10.10.0.0/16"},
+			},
+		},
+	})
+
+	t.Run("RouteDeniesNode", func(t *testing.T) {
+		sim, err := NewACLSimulator(ctx, db)
+		if err != nil {
+			t.Fatalf("new simulator: %v", err)
+		}
+		verdict, err := sim.Test(ctx, "node-a", "node-b", nil)
+		if err != nil {
+			t.Fatalf("test: %v", err)
+		}
+		if verdict.Allowed {
+			t.Fatal("expected a denied route to deny the node")
+		}
+		last := verdict.Checks[len(verdict.Checks)-1]
+		if last.Route != "node-b-private" || last.ACL != "deny-private" {
+			t.Fatalf("expected route to be denied by deny-private, got %+v", last)
+		}
+		// The reverse direction has no routes and is allowed.
+		verdict, err = sim.Test(ctx, "node-b", "node-a", nil)
+		if err != nil {
+			t.Fatalf("test: %v", err)
+		}
+		if !verdict.Allowed {
+			t.Fatalf("expected node-b to reach node-a: %s", verdict.Reason)
+		}
+	})
+
+	t.Run("CIDRs", func(t *testing.T) {
+		sim, err := NewACLSimulator(ctx, db)
+		if err != nil {
+			t.Fatalf("new simulator: %v", err)
+		}
+		verdict, err := sim.Test(ctx, "node-a", "node-b", []netip.Prefix{netip.MustParsePrefix("172.16.0.2/32")})
+		if err != nil {
+			t.Fatalf("test: %v", err)
+		}
+		if !verdict.Allowed || len(verdict.Checks) != 1 || verdict.Checks[0].ACL != "allow-all" {
+			t.Fatalf("expected the prefix to be allowed by allow-all, got %+v", verdict)
+		}
+	})
+
+	t.Run("Proposed", func(t *testing.T) {
+		proposed := types.NetworkACL{NetworkACL: &v1.NetworkACL{
+			Name:             "deny-private",
+			Priority:         100,
+			Action:           v1.ACLAction_ACTION_DENY,
+			SourceNodes:      []string{"node-c"},
+			DestinationNodes: []string{"*"},
+		}}
+		sim, err := NewACLSimulator(ctx, db, proposed)
+		if err != nil {
+			t.Fatalf("new simulator: %v", err)
+		}
+		matrix, err := sim.Matrix(ctx)
+		if err != nil {
+			t.Fatalf("matrix: %v", err)
+		}
+		if len(matrix) != 2 {
+			t.Fatalf("expected 2 verdicts, got %d", len(matrix))
+		}
+		for _, verdict := range matrix {
+			if !verdict.Allowed {
+				t.Errorf("expected %s to reach %s with the proposed ACL: %s", verdict.Source, verdict.Destination, verdict.Reason)
+			}
+		}
+	})
+}
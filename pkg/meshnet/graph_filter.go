@@ -43,18 +43,13 @@ func FilterGraph(ctx context.Context, db storage.MeshDB, thisNodeID types.NodeID
 	}
 
 	// Gather all the ACLs and the current adjacency map
-	acls, err := db.Networking().ListNetworkACLs(ctx)
+	acls, err := loadNetworkACLs(ctx, db)
 	if err != nil {
-		return nil, fmt.Errorf("list network acls: %w", err)
+		return nil, err
 	}
 	if len(acls) == 0 {
 		return nil, nil
 	}
-	err = storage.ExpandACLs(ctx, db.RBAC(), acls)
-	if err != nil {
-		return nil, fmt.Errorf("expand network acls: %w", err)
-	}
-	acls.Sort(types.SortDescending)
 	fullMap, err := types.NewAdjacencyMap(graph)
 	if err != nil {
 		return nil, fmt.Errorf("build adjacency map: %w", err)
@@ -174,3 +169,38 @@ Nodes:
 	log.Debug("Filtered adjacency map", "from", thisNode.Id, "map", filtered)
 	return filtered, nil
 }
+
+// loadNetworkACLs returns the network ACLs in the database sorted by priority
+// with group and federated node references expanded. Proposed ACLs replace the
+// stored ACLs with the same names or are added to them.
+func loadNetworkACLs(ctx context.Context, db storage.MeshDB, proposed ...types.NetworkACL) (types.NetworkACLs, error) {
+	acls, err := db.Networking().ListNetworkACLs(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("list network acls: %w", err)
+	}
+Proposed:
+	for _, acl := range proposed {
+		for i, stored := range acls {
+			if stored.GetName() == acl.GetName() {
+				acls[i] = acl
+				continue Proposed
+			}
+		}
+		acls = append(acls, acl)
+	}
+	if len(acls) == 0 {
+		return acls, nil
+	}
+	err = storage.ExpandACLs(ctx, db.RBAC(), acls)
+	if err != nil {
+		return nil, fmt.Errorf("expand network acls: %w", err)
+	}
+	if fed, ok := storage.FederationOf(db); ok {
+		err = storage.ExpandRemoteNodes(ctx, fed, acls)
+		if err != nil {
+			return nil, fmt.Errorf("expand federated nodes in network acls: %w", err)
+		}
+	}
+	acls.Sort(types.SortDescending)
+	return acls, nil
+}
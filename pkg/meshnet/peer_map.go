@@ -194,6 +194,64 @@ func WireGuardPeersFor(ctx context.Context, st storage.MeshDB, peerID types.Node
 		}
 		peer.AllowedIPs = newAllowedIPs
 	}
+	if fed, ok := storage.FederationOf(st); ok {
+		federated, err := federatedPeersFor(ctx, fed, peerID)
+		if err != nil {
+			return nil, fmt.Errorf("get federated peers: %w", err)
+		}
+		if len(federated) > 0 {
+			// Other gateways advertise the same prefixes, but we reach them
+			// directly through the federated gateways.
+			var prefixes []string
+			for _, peer := range federated {
+				prefixes = append(prefixes, peer.GetAllowedIPs()...)
+			}
+			isFederated := func(prefix string) bool { return slices.Contains(prefixes, prefix) }
+			for _, peer := range out {
+				peer.AllowedIPs = slices.DeleteFunc(peer.AllowedIPs, isFederated)
+				peer.AllowedRoutes = slices.DeleteFunc(peer.AllowedRoutes, isFederated)
+			}
+		}
+		out = append(out, federated...)
+	}
+	return out, nil
+}
+
+// federatedPeersFor returns the gateways of federated meshes the given node
+// peers with as a gateway of this mesh. The prefixes of each federated mesh
+// are routed through its gateway. Federations with an expired bundle are
+// skipped.
+func federatedPeersFor(ctx context.Context, fed storage.Federation, peerID types.NodeID) ([]*v1.WireGuardPeer, error) {
+	feds, err := storage.ActiveFederations(ctx, fed)
+	if err != nil {
+		return nil, err
+	}
+	var out []*v1.WireGuardPeer
+	for _, f := range feds {
+		gw, ok := f.RemoteGatewayFor(peerID)
+		if !ok {
+			continue
+		}
+		node := &v1.MeshNode{
+			Id:                 gw.ID + types.RemoteNodeSeparator + f.Domain(),
+			PublicKey:          gw.PublicKey,
+			PrimaryEndpoint:    gw.Endpoints[0],
+			WireguardEndpoints: gw.Endpoints,
+		}
+		for _, addr := range types.ToPrefixes(gw.Addresses) {
+			if addr.Addr().Is4() && node.PrivateIPv4 == "" {
+				node.PrivateIPv4 = addr.String()
+			} else if addr.Addr().Is6() && node.PrivateIPv6 == "" {
+				node.PrivateIPv6 = addr.String()
+			}
+		}
+		out = append(out, &v1.WireGuardPeer{
+			Node:          node,
+			Proto:         v1.ConnectProtocol_CONNECT_NATIVE,
+			AllowedIPs:    f.Bundle.Prefixes,
+			AllowedRoutes: f.Bundle.Prefixes,
+		})
+	}
 	return out, nil
 }
 
@@ -0,0 +1,255 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshnet
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+	"time"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/crypto"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/meshdb"
+	"github.com/webmeshproj/webmesh/pkg/storage/providers/backends/badgerdb"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+func TestWireGuardPeersWithFederation(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	st := badgerdb.NewTestStorage(false)
+	defer st.Close()
+	db := meshdb.NewFromStorage(st)
+	err := db.MeshState().SetMeshState(ctx, types.NetworkState{
+		NetworkState: &v1.NetworkState{
+			NetworkV4: "172.16.0.0/12",
+			NetworkV6: "2001:db8::/64",
+			Domain:    "example.com",
+		},
+	})
+	if err != nil {
+		t.Fatalf("set network state: %v", err)
+	}
+	for id, addr := range map[string]string{"gateway": "172.16.0.1/32", "node-a": "172.16.0.2/32", "node-b": "172.16.0.3/32"} {
+		err := db.Peers().Put(ctx, types.MeshNode{MeshNode: &v1.MeshNode{
+			Id:          id,
+			PublicKey:   mustGeneratePublicKey(t),
+			PrivateIPv4: addr,
+		}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, edge := range [][2]string{{"gateway", "node-a"}, {"node-a", "node-b"}} {
+		err := db.Peers().PutEdge(ctx, types.MeshEdge{MeshEdge: &v1.MeshEdge{Source: edge[0], Target: edge[1]}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = db.Networking().PutNetworkACL(ctx, types.NetworkACL{NetworkACL: &v1.NetworkACL{
+		Name:             "allow-all",
+		Action:           v1.ACLAction_ACTION_ACCEPT,
+		SourceNodes:      []string{"*"},
+		DestinationNodes: []string{"*"},
+		SourceCIDRs:      []string{"*"},
+		DestinationCIDRs: []string{"*"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bundle := types.TrustBundle{
+		Domain:   "other.example.com",
+		Prefixes: []string{"10.50.0.0/16"},
+		Gateways: []types.FederationGateway{{
+			ID:        "remote-gateway",
+			PublicKey: mustGeneratePublicKey(t),
+			Endpoints: []string{"203.0.113.10:51820"},
+			Addresses: []string{"10.50.0.1/32"},
+		}},
+		Nodes: []types.FederatedNode{{ID: "db", Addresses: []string{"10.50.0.5/32"}}},
+	}
+	if err := bundle.Sign(crypto.MustGenerateKey()); err != nil {
+		t.Fatalf("sign bundle: %v", err)
+	}
+	fed, ok := storage.FederationOf(db)
+	if !ok {
+		t.Fatal("expected test database to support federation")
+	}
+	err = fed.PutFederation(ctx, types.Federation{
+		Bundle:                bundle,
+		Gateways:              []string{"gateway"},
+		SigningKeyFingerprint: bundle.SigningKeyFingerprint(),
+	})
+	if err != nil {
+		t.Fatalf("put federation: %v", err)
+	}
+
+	t.Run("GatewayPeersWithRemoteGateway", func(t *testing.T) {
+		peers, err := WireGuardPeersFor(ctx, db, "gateway")
+		if err != nil {
+			t.Fatal(err)
+		}
+		remote := findPeer(peers, "remote-gateway@other.example.com")
+		if remote == nil {
+			t.Fatalf("expected a peer for the remote gateway, got %v", peers)
+		}
+		if !slices.Equal(remote.AllowedIPs, []string{"10.50.0.0/16"}) {
+			t.Fatalf("expected remote prefixes in allowed IPs, got %v", remote.AllowedIPs)
+		}
+	})
+
+	t.Run("NodesRouteThroughGateway", func(t *testing.T) {
+		peers, err := WireGuardPeersFor(ctx, db, "node-b")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if findPeer(peers, "remote-gateway@other.example.com") != nil {
+			t.Fatal("expected only gateways to peer with the remote gateway")
+		}
+		peer := findPeer(peers, "node-a")
+		if peer == nil || !slices.Contains(peer.AllowedRoutes, "10.50.0.0/16") {
+			t.Fatalf("expected remote prefixes to be routed towards the gateway, got %v", peers)
+		}
+	})
+
+	t.Run("RemoteNodeACLs", func(t *testing.T) {
+		err := db.Networking().PutNetworkACL(ctx, types.NetworkACL{NetworkACL: &v1.NetworkACL{
+			Name:             "deny-remote-db",
+			Priority:         100,
+			Action:           v1.ACLAction_ACTION_DENY,
+			SourceNodes:      []string{"node-b"},
+			DestinationNodes: []string{"db@other.example.com"},
+		}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() {
+			_ = db.Networking().DeleteNetworkACL(ctx, "deny-remote-db")
+		}()
+		peers, err := WireGuardPeersFor(ctx, db, "node-b")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if peer := findPeer(peers, "node-a"); peer != nil && slices.Contains(peer.AllowedRoutes, "10.50.0.0/16") {
+			t.Fatalf("expected the route to the remote node to be denied, got %v", peer.AllowedRoutes)
+		}
+	})
+
+	t.Run("SigningKeyIsPinned", func(t *testing.T) {
+		resigned := bundle
+		if err := resigned.Sign(crypto.MustGenerateKey()); err != nil {
+			t.Fatal(err)
+		}
+		err := fed.PutFederation(ctx, types.Federation{
+			Bundle:                resigned,
+			Gateways:              []string{"gateway"},
+			SigningKeyFingerprint: resigned.SigningKeyFingerprint(),
+		})
+		if err == nil {
+			t.Fatal("expected bundle signed by a different key to be rejected")
+		}
+	})
+
+	t.Run("SigningKeyFingerprintIsRequired", func(t *testing.T) {
+		other := bundle
+		other.Domain = "unpinned.example.com"
+		other.Prefixes = []string{"10.70.0.0/16"}
+		if err := other.Sign(crypto.MustGenerateKey()); err != nil {
+			t.Fatal(err)
+		}
+		for _, fingerprint := range []string{"", bundle.SigningKeyFingerprint()} {
+			err := fed.PutFederation(ctx, types.Federation{
+				Bundle:                other,
+				Gateways:              []string{"gateway"},
+				SigningKeyFingerprint: fingerprint,
+			})
+			if err == nil {
+				t.Fatalf("expected bundle not signed by the key with fingerprint %q to be rejected", fingerprint)
+			}
+		}
+	})
+
+	t.Run("ExpiredFederationsAreIgnored", func(t *testing.T) {
+		expired := types.TrustBundle{
+			Domain:   "expired.example.com",
+			Prefixes: []string{"10.60.0.0/16"},
+			Gateways: []types.FederationGateway{{
+				ID:        "remote-gateway",
+				PublicKey: mustGeneratePublicKey(t),
+				Endpoints: []string{"203.0.113.20:51820"},
+			}},
+			Nodes:   []types.FederatedNode{{ID: "cache", Addresses: []string{"10.60.0.5/32"}}},
+			Expires: time.Now().Add(-time.Minute),
+		}
+		if err := expired.Sign(crypto.MustGenerateKey()); err != nil {
+			t.Fatal(err)
+		}
+		// Expired bundles are rejected when put, store one that expired since.
+		data, err := json.Marshal(types.Federation{
+			Bundle:                expired,
+			Gateways:              []string{"gateway"},
+			SigningKeyFingerprint: expired.SigningKeyFingerprint(),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		key := types.FederationsPrefix.ForString(expired.Domain)
+		if err := st.PutValue(ctx, key, data, 0); err != nil {
+			t.Fatal(err)
+		}
+		defer func() {
+			_ = st.Delete(ctx, key)
+		}()
+		peers, err := WireGuardPeersFor(ctx, db, "gateway")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if findPeer(peers, "remote-gateway@expired.example.com") != nil {
+			t.Fatal("expected no peer for the gateway of an expired federation")
+		}
+		acls := types.NetworkACLs{{NetworkACL: &v1.NetworkACL{
+			Name:             "allow-cache",
+			Action:           v1.ACLAction_ACTION_ACCEPT,
+			SourceNodes:      []string{"*"},
+			DestinationNodes: []string{"cache@expired.example.com"},
+		}}}
+		if err := storage.ExpandRemoteNodes(ctx, fed, acls); err != nil {
+			t.Fatal(err)
+		}
+		action := types.NetworkAction{NetworkAction: &v1.NetworkAction{
+			SrcNode: "node-a",
+			DstNode: "gateway",
+			DstCIDR: "10.60.0.5/32",
+		}}
+		if acls[0].Matches(ctx, action) {
+			t.Fatal("expected nodes of an expired federation not to be resolved")
+		}
+	})
+}
+
+func findPeer(peers []*v1.WireGuardPeer, id string) *v1.WireGuardPeer {
+	for _, peer := range peers {
+		if peer.GetNode().GetId() == id {
+			return peer
+		}
+	}
+	return nil
+}
@@ -19,6 +19,7 @@ package firewall
 
 import (
 	"context"
+	"fmt"
 	"net/netip"
 )
 
@@ -28,6 +29,11 @@ type Firewall interface {
 	AddWireguardForwarding(ctx context.Context, ifaceName string) error
 	// AddMasquerade should configure the firewall to masquerade outbound traffic on the wireguard interface.
 	AddMasquerade(ctx context.Context, ifaceName string) error
+	// AddNetmap should configure the firewall to translate traffic for one prefix
+	// to another of the same size, mapping addresses one to one.
+	AddNetmap(ctx context.Context, opts NetmapOptions) error
+	// RemoveNetmap should remove a translation previously added with AddNetmap.
+	RemoveNetmap(ctx context.Context, opts NetmapOptions) error
 	// Clear should clear any changes made to the firewall.
 	Clear(ctx context.Context) error
 	// Close should close any resources used by the firewall. It should also perform a Clear.
@@ -83,6 +89,36 @@ type DNATOptions struct {
 	PortRange *PortRange
 }
 
+// NetmapOptions are options for a one to one translation between two
+// prefixes of the same size.
+type NetmapOptions struct {
+	// InInterface is the interface translated traffic arrives on.
+	InInterface string
+	// OutInterface is the interface translated traffic leaves on. Translated
+	// traffic is masqueraded as the address of this interface so replies are
+	// routed back through the firewall.
+	OutInterface string
+	// Prefix is the destination prefix of traffic arriving on the in interface.
+	Prefix netip.Prefix
+	// To is the prefix the destination is translated to. It must be of the same
+	// family and size as Prefix.
+	To netip.Prefix
+}
+
+// Validate validates the netmap options.
+func (o NetmapOptions) Validate() error {
+	if o.InInterface == "" || o.OutInterface == "" {
+		return fmt.Errorf("netmap requires an in and out interface")
+	}
+	if !o.Prefix.IsValid() || !o.To.IsValid() {
+		return fmt.Errorf("netmap requires valid prefixes")
+	}
+	if o.Prefix.Addr().Is4() != o.To.Addr().Is4() || o.Prefix.Bits() != o.To.Bits() {
+		return fmt.Errorf("netmap prefixes %s and %s must be of the same family and size", o.Prefix, o.To)
+	}
+	return nil
+}
+
 // PortRange is a range of ports.
 type PortRange struct {
 	// Start is the start of the port range.
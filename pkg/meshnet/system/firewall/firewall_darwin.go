@@ -91,6 +91,56 @@ func (pf *pfctlFirewall) AddMasquerade(ctx context.Context, ifaceName string) er
 	return err
 }
 
+// AddNetmap should configure the firewall to translate traffic for one prefix
+// to another of the same size, mapping addresses one to one.
+func (pf *pfctlFirewall) AddNetmap(ctx context.Context, opts NetmapOptions) error {
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(pf.anchorFile, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("open anchor file: %w", err)
+	}
+	defer f.Close()
+	_, err = f.WriteString(netmapRules(opts))
+	if err != nil {
+		return fmt.Errorf("write anchor file: %w", err)
+	}
+	// Reload pfctl
+	err = common.Exec(ctx, "pfctl", "-f", anchorFile)
+	return err
+}
+
+// RemoveNetmap should remove a translation previously added with AddNetmap.
+func (pf *pfctlFirewall) RemoveNetmap(ctx context.Context, opts NetmapOptions) error {
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+	data, err := os.ReadFile(pf.anchorFile)
+	if err != nil {
+		return fmt.Errorf("read anchor file: %w", err)
+	}
+	rules := strings.Replace(string(data), netmapRules(opts), "", 1)
+	err = os.WriteFile(pf.anchorFile, []byte(rules), 0644)
+	if err != nil {
+		return fmt.Errorf("write anchor file: %w", err)
+	}
+	// Reload pfctl
+	err = common.Exec(ctx, "pfctl", "-f", anchorFile)
+	return err
+}
+
+// netmapRules returns the anchor rules for the given netmap.
+func netmapRules(opts NetmapOptions) string {
+	af := "inet"
+	if opts.Prefix.Addr().Is6() {
+		af = "inet6"
+	}
+	rules := fmt.Sprintf("rdr on %s %s from any to %s -> %s bitmask\n", opts.InInterface, af, opts.Prefix.Masked(), opts.To.Masked())
+	rules += fmt.Sprintf("nat on %s %s from any to %s -> (%s:0)\n", opts.OutInterface, af, opts.To.Masked(), opts.OutInterface)
+	return rules
+}
+
 // Clear should clear any changes made to the firewall.
 func (pf *pfctlFirewall) Clear(ctx context.Context) error {
 	// Clear the anchor file
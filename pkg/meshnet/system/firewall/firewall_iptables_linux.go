@@ -58,6 +58,40 @@ func (fw *iptablesFirewall) AddMasquerade(ctx context.Context, ifaceName string)
 	return fw.exec(ctx, "-t", "nat", "-A", "POSTROUTING", "-o", ifaceName, "-j", "MASQUERADE")
 }
 
+// AddNetmap should configure the firewall to translate traffic for one prefix
+// to another of the same size, mapping addresses one to one.
+func (fw *iptablesFirewall) AddNetmap(ctx context.Context, opts NetmapOptions) error {
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+	if opts.Prefix.Addr().Is6() {
+		return fmt.Errorf("netmap is only supported for IPv4 with iptables")
+	}
+	return fw.execNetmap(ctx, "-A", opts)
+}
+
+// RemoveNetmap should remove a translation previously added with AddNetmap.
+func (fw *iptablesFirewall) RemoveNetmap(ctx context.Context, opts NetmapOptions) error {
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+	if opts.Prefix.Addr().Is6() {
+		return fmt.Errorf("netmap is only supported for IPv4 with iptables")
+	}
+	return fw.execNetmap(ctx, "-D", opts)
+}
+
+// execNetmap appends or deletes the rules of a netmap depending on the given command.
+func (fw *iptablesFirewall) execNetmap(ctx context.Context, cmd string, opts NetmapOptions) error {
+	err := fw.exec(ctx, "-t", "nat", cmd, "PREROUTING", "-i", opts.InInterface,
+		"-d", opts.Prefix.Masked().String(), "-j", "NETMAP", "--to", opts.To.Masked().String())
+	if err != nil {
+		return err
+	}
+	return fw.exec(ctx, "-t", "nat", cmd, "POSTROUTING", "-o", opts.OutInterface,
+		"-d", opts.To.Masked().String(), "-j", "MASQUERADE")
+}
+
 // Clear should clear any changes made to the firewall.
 func (fw *iptablesFirewall) Clear(ctx context.Context) error {
 	err := fw.exec(ctx, "-F")
@@ -92,6 +92,7 @@ func (fw *firewall) initTables() error {
 	if err != nil {
 		return fmt.Errorf("failed to load raw table: %w", err)
 	}
+	fw.natTable = natTable
 	fw.filterchains = filterchains.Chains()
 	fw.natchains = natchains.Chains()
 	fw.rawchains = rawchains.Chains()
@@ -17,14 +17,17 @@ limitations under the License.
 package firewall
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"net"
 	"strings"
 
 	"github.com/containernetworking/plugins/pkg/ns"
 	"github.com/google/nftables"
 	"github.com/google/nftables/expr"
 	"github.com/sbezverk/nftableslib"
+	"golang.org/x/sys/unix"
 )
 
 // firewall is a firewall manager that uses nftables.
@@ -32,6 +35,8 @@ type firewall struct {
 	opts *Options
 	conn *nftables.Conn
 	ns   ns.NetNS
+	// natTable is the name of the NAT table
+	natTable string
 	// nftables interfaces
 	ti           nftableslib.TableFuncs
 	natchains    nftableslib.ChainFuncs
@@ -145,6 +150,118 @@ func (fw *firewall) AddMasquerade(ctx context.Context, ifaceName string) error {
 	return fw.conn.Flush()
 }
 
+// AddNetmap should configure the firewall to translate traffic for one prefix
+// to another of the same size, mapping addresses one to one.
+func (fw *firewall) AddNetmap(ctx context.Context, opts NetmapOptions) error {
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+	var family byte = unix.NFPROTO_IPV4
+	var offset uint32 = 16
+	addrLen := uint32(net.IPv4len)
+	if opts.Prefix.Addr().Is6() {
+		family = unix.NFPROTO_IPV6
+		offset = 24
+		addrLen = net.IPv6len
+	}
+	netmask := []byte(net.CIDRMask(opts.Prefix.Bits(), int(addrLen)*8))
+	hostmask := make([]byte, len(netmask))
+	for i := range netmask {
+		hostmask[i] = ^netmask[i]
+	}
+	zero := make([]byte, addrLen)
+	matchFamily := []expr.Any{
+		&expr.Meta{Key: expr.MetaKeyNFPROTO, Register: 1},
+		&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte{family}},
+	}
+	loadDaddr := &expr.Payload{
+		DestRegister: 1,
+		Base:         expr.PayloadBaseNetworkHeader,
+		Offset:       offset,
+		Len:          addrLen,
+	}
+	table := &nftables.Table{Name: fw.natTable, Family: nftables.TableFamilyINet}
+	// Rewrite the network bits of destinations in the prefix, keeping the host bits.
+	dnat := append(append([]expr.Any{}, matchFamily...),
+		&expr.Meta{Key: expr.MetaKeyIIFNAME, Register: 1},
+		&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: ifname(opts.InInterface)},
+		loadDaddr,
+		&expr.Bitwise{SourceRegister: 1, DestRegister: 1, Len: addrLen, Mask: netmask, Xor: zero},
+		&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: opts.Prefix.Masked().Addr().AsSlice()},
+		loadDaddr,
+		&expr.Bitwise{SourceRegister: 1, DestRegister: 1, Len: addrLen, Mask: hostmask, Xor: opts.To.Masked().Addr().AsSlice()},
+		&expr.NAT{Type: expr.NATTypeDestNAT, Family: uint32(family), RegAddrMin: 1, RegAddrMax: 1},
+	)
+	fw.conn.AddRule(&nftables.Rule{
+		Table:    table,
+		Chain:    &nftables.Chain{Name: inetPreroutingChain, Table: table},
+		Exprs:    dnat,
+		UserData: netmapComment(opts),
+	})
+	// Masquerade translated traffic so replies return through us.
+	masq := append(append([]expr.Any{}, matchFamily...),
+		&expr.Meta{Key: expr.MetaKeyIIFNAME, Register: 1},
+		&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: ifname(opts.InInterface)},
+		&expr.Meta{Key: expr.MetaKeyOIFNAME, Register: 1},
+		&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: ifname(opts.OutInterface)},
+		loadDaddr,
+		&expr.Bitwise{SourceRegister: 1, DestRegister: 1, Len: addrLen, Mask: netmask, Xor: zero},
+		&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: opts.To.Masked().Addr().AsSlice()},
+		&expr.Masq{},
+	)
+	fw.conn.AddRule(&nftables.Rule{
+		Table:    table,
+		Chain:    &nftables.Chain{Name: inetPostRoutingChain, Table: table},
+		Exprs:    masq,
+		UserData: netmapMasqComment(opts),
+	})
+	return fw.conn.Flush()
+}
+
+// RemoveNetmap should remove a translation previously added with AddNetmap.
+func (fw *firewall) RemoveNetmap(ctx context.Context, opts NetmapOptions) error {
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+	table := &nftables.Table{Name: fw.natTable, Family: nftables.TableFamilyINet}
+	for chain, comment := range map[string][]byte{
+		inetPreroutingChain:  netmapComment(opts),
+		inetPostRoutingChain: netmapMasqComment(opts),
+	} {
+		rules, err := fw.conn.GetRules(table, &nftables.Chain{Name: chain, Table: table})
+		if err != nil {
+			return fmt.Errorf("failed to list %s rules: %w", chain, err)
+		}
+		for _, rule := range rules {
+			if !bytes.Equal(rule.UserData, comment) {
+				continue
+			}
+			if err := fw.conn.DelRule(rule); err != nil {
+				return fmt.Errorf("failed to delete netmap rule: %w", err)
+			}
+		}
+	}
+	return fw.conn.Flush()
+}
+
+// netmapComment returns the comment identifying the translation rule of a netmap.
+func netmapComment(opts NetmapOptions) []byte {
+	return nftableslib.MakeRuleComment(fmt.Sprintf("Netmap %s to %s on %s", opts.Prefix, opts.To, opts.InInterface))
+}
+
+// netmapMasqComment returns the comment identifying the masquerade rule of a netmap.
+func netmapMasqComment(opts NetmapOptions) []byte {
+	return nftableslib.MakeRuleComment(fmt.Sprintf("Masquerade netmap %s to %s on %s", opts.Prefix, opts.To, opts.OutInterface))
+}
+
+// ifname returns the interface name as compared by nftables.
+func ifname(name string) []byte {
+	if len(name) > 15 {
+		name = name[:15]
+	}
+	return append([]byte(name), 0)
+}
+
 // Clear should clear any changes made to the firewall.
 func (fw *firewall) Clear(ctx context.Context) error {
 	for _, table := range []string{inetNatTable, inetFilterTable, inetRawTable} {
@@ -83,6 +83,17 @@ func (wf *winFirewall) AddMasquerade(ctx context.Context, ifaceName string) erro
 	return nil
 }
 
+// AddNetmap should configure the firewall to translate traffic for one prefix
+// to another of the same size, mapping addresses one to one.
+func (wf *winFirewall) AddNetmap(ctx context.Context, opts NetmapOptions) error {
+	return fmt.Errorf("netmap is not supported on windows")
+}
+
+// RemoveNetmap should remove a translation previously added with AddNetmap.
+func (wf *winFirewall) RemoveNetmap(ctx context.Context, opts NetmapOptions) error {
+	return fmt.Errorf("netmap is not supported on windows")
+}
+
 // Clear should clear any changes made to the firewall.
 func (wf *winFirewall) Clear(ctx context.Context) error {
 	for _, name := range []string{"webmesh-forward-inbound", "webmesh-forward-outbound"} {
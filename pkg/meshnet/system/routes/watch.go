@@ -0,0 +1,49 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package routes
+
+import (
+	"errors"
+	"net/netip"
+)
+
+// ErrWatchNotSupported is returned when watching for network changes is not
+// supported on the current platform.
+var ErrWatchNotSupported = errors.New("watching for network changes is not supported on this platform")
+
+// ChangeType is the type of a network change.
+type ChangeType string
+
+const (
+	// DefaultRouteChange is a change to a default route.
+	DefaultRouteChange ChangeType = "default-route"
+	// AddressChange is a change to an interface address.
+	AddressChange ChangeType = "address"
+)
+
+// Change is a change to the network configuration of the system.
+type Change struct {
+	// Type is the type of the change.
+	Type ChangeType
+	// Removed is true if the route or address was removed.
+	Removed bool
+	// LinkIndex is the index of the interface the change occurred on.
+	LinkIndex int
+	// Addr is the changed address for address changes, or the gateway
+	// for default route changes. It may be invalid.
+	Addr netip.Addr
+}
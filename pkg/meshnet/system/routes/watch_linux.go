@@ -0,0 +1,96 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package routes
+
+import (
+	"fmt"
+	"net/netip"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+)
+
+// WatchChanges watches rtnetlink for changes to default routes and interface
+// addresses. The returned channel is closed when the context is canceled.
+func WatchChanges(ctx context.Context) (<-chan Change, error) {
+	routes := make(chan netlink.RouteUpdate, 16)
+	addrs := make(chan netlink.AddrUpdate, 16)
+	done := make(chan struct{})
+	if err := netlink.RouteSubscribe(routes, done); err != nil {
+		close(done)
+		return nil, fmt.Errorf("subscribe to route updates: %w", err)
+	}
+	if err := netlink.AddrSubscribe(addrs, done); err != nil {
+		close(done)
+		return nil, fmt.Errorf("subscribe to address updates: %w", err)
+	}
+	changes := make(chan Change, 16)
+	go func() {
+		defer close(changes)
+		defer close(done)
+		for {
+			var change Change
+			select {
+			case <-ctx.Done():
+				return
+			case update, ok := <-routes:
+				if !ok {
+					return
+				}
+				if !isDefaultRoute(update.Route) {
+					continue
+				}
+				change = Change{
+					Type:      DefaultRouteChange,
+					Removed:   update.Type == unix.RTM_DELROUTE,
+					LinkIndex: update.LinkIndex,
+				}
+				if gw, ok := netip.AddrFromSlice(update.Gw); ok {
+					change.Addr = gw.Unmap()
+				}
+			case update, ok := <-addrs:
+				if !ok {
+					return
+				}
+				change = Change{
+					Type:      AddressChange,
+					Removed:   !update.NewAddr,
+					LinkIndex: update.LinkIndex,
+				}
+				if addr, ok := netip.AddrFromSlice(update.LinkAddress.IP); ok {
+					change.Addr = addr.Unmap()
+				}
+			}
+			select {
+			case changes <- change:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return changes, nil
+}
+
+func isDefaultRoute(route netlink.Route) bool {
+	if route.Dst == nil {
+		return true
+	}
+	ones, _ := route.Dst.Mask.Size()
+	return ones == 0
+}
@@ -16,7 +16,11 @@ limitations under the License.
 
 package testutil
 
-import "context"
+import (
+	"context"
+
+	"github.com/webmeshproj/webmesh/pkg/meshnet/system/firewall"
+)
 
 // Firewall is a mock firewall.
 type Firewall struct{}
@@ -31,6 +35,17 @@ func (fw *Firewall) AddMasquerade(ctx context.Context, ifaceName string) error {
 	return nil
 }
 
+// AddNetmap should configure the firewall to translate traffic for one prefix
+// to another of the same size, mapping addresses one to one.
+func (fw *Firewall) AddNetmap(ctx context.Context, opts firewall.NetmapOptions) error {
+	return nil
+}
+
+// RemoveNetmap should remove a translation previously added with AddNetmap.
+func (fw *Firewall) RemoveNetmap(ctx context.Context, opts firewall.NetmapOptions) error {
+	return nil
+}
+
 // Clear should clear any changes made to the firewall.
 func (fw *Firewall) Clear(ctx context.Context) error {
 	return nil
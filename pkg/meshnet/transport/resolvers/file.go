@@ -0,0 +1,242 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resolvers
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	v1 "github.com/webmeshproj/api/go/v1"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/transport"
+)
+
+// FileOptions are options for a file resolver.
+type FileOptions struct {
+	// Path is the path to the file of targets. See ParseTargets for
+	// the format of the file.
+	Path string
+	// RefreshInterval is the interval to re-read the file in addition to
+	// watching it for changes. Defaults to DefaultRefreshInterval.
+	RefreshInterval time.Duration
+	// Resolver is the DNS resolver to use for hostname targets.
+	// Defaults to net.DefaultResolver.
+	Resolver *net.Resolver
+}
+
+// FileResolver is a feature resolver that reads targets from a local file.
+// The file is watched for changes until the resolver is closed.
+type FileResolver struct {
+	FileOptions
+	targets []Target
+	closec  chan struct{}
+	once    sync.Once
+	mu      sync.RWMutex
+}
+
+// NewFileResolver returns a new file resolver. The file is read once before
+// returning and then watched for changes in the background until Close is
+// called or the context is canceled.
+func NewFileResolver(ctx context.Context, opts FileOptions) (*FileResolver, error) {
+	if opts.RefreshInterval <= 0 {
+		opts.RefreshInterval = DefaultRefreshInterval
+	}
+	if opts.Resolver == nil {
+		opts.Resolver = net.DefaultResolver
+	}
+	r := &FileResolver{
+		FileOptions: opts,
+		closec:      make(chan struct{}),
+	}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("create file watcher: %w", err)
+	}
+	err = watcher.Add(filepath.Dir(opts.Path))
+	if err != nil {
+		watcher.Close()
+		return nil, fmt.Errorf("watch %s: %w", opts.Path, err)
+	}
+	go r.watch(ctx, watcher)
+	return r, nil
+}
+
+// Resolve implements transport.FeatureResolver.
+func (r *FileResolver) Resolve(ctx context.Context, lookup v1.Feature) ([]netip.AddrPort, error) {
+	r.mu.RLock()
+	targets := make([]Target, 0, len(r.targets))
+	for _, t := range r.targets {
+		if t.Serves(lookup) {
+			targets = append(targets, t)
+		}
+	}
+	r.mu.RUnlock()
+	return Expand(ctx, r.Resolver, Order(targets))
+}
+
+// Targets returns the currently loaded targets.
+func (r *FileResolver) Targets() []Target {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	out := make([]Target, len(r.targets))
+	copy(out, r.targets)
+	return out
+}
+
+// Close stops watching the file.
+func (r *FileResolver) Close() error {
+	r.once.Do(func() { close(r.closec) })
+	return nil
+}
+
+func (r *FileResolver) reload() error {
+	data, err := os.ReadFile(r.Path)
+	if err != nil {
+		return fmt.Errorf("read %s: %w", r.Path, err)
+	}
+	targets, err := ParseTargets(data)
+	if err != nil {
+		return fmt.Errorf("parse %s: %w", r.Path, err)
+	}
+	r.mu.Lock()
+	r.targets = targets
+	r.mu.Unlock()
+	return nil
+}
+
+func (r *FileResolver) watch(ctx context.Context, watcher *fsnotify.Watcher) {
+	defer watcher.Close()
+	log := context.LoggerFrom(ctx).With("component", "file-resolver", "watched-file", r.Path)
+	filename := filepath.Base(r.Path)
+	t := time.NewTicker(r.RefreshInterval)
+	defer t.Stop()
+	reload := func() {
+		if err := r.reload(); err != nil {
+			// Keep the last good set of targets.
+			log.Warn("Failed to reload targets", slog.String("error", err.Error()))
+			return
+		}
+		log.Debug("Reloaded targets", slog.Int("targets", len(r.Targets())))
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-r.closec:
+			return
+		case <-t.C:
+			reload()
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			if filepath.Base(event.Name) != filename {
+				continue
+			}
+			if event.Has(fsnotify.Write) || event.Has(fsnotify.Create) {
+				reload()
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Error("File watcher error", slog.String("error", err.Error()))
+		}
+	}
+}
+
+// ParseTargets parses targets from the given data. Each non-empty line that is not
+// a comment contains a host:port followed by optional key=value fields:
+//
+//	# comment
+//	10.0.0.1:8443
+//	join.example.com:8443 priority=10 weight=5
+//	10.0.0.2:8443 features=storage-provider,membership
+//
+// Priority and weight have the same semantics as in SRV records.
+func ParseTargets(data []byte) ([]Target, error) {
+	var targets []Target
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	var lineno int
+	for scanner.Scan() {
+		lineno++
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		host, portstr, err := net.SplitHostPort(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid address %q: %w", lineno, fields[0], err)
+		}
+		port, err := strconv.ParseUint(portstr, 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid port %q: %w", lineno, portstr, err)
+		}
+		target := Target{Host: host, Port: uint16(port)}
+		for _, field := range fields[1:] {
+			key, value, ok := strings.Cut(field, "=")
+			if !ok {
+				return nil, fmt.Errorf("line %d: invalid field %q", lineno, field)
+			}
+			switch key {
+			case "priority", "weight":
+				n, err := strconv.ParseUint(value, 10, 16)
+				if err != nil {
+					return nil, fmt.Errorf("line %d: invalid %s %q: %w", lineno, key, value, err)
+				}
+				if key == "priority" {
+					target.Priority = uint16(n)
+				} else {
+					target.Weight = uint16(n)
+				}
+			case "features":
+				for _, name := range strings.Split(value, ",") {
+					feature, err := ParseFeature(name)
+					if err != nil {
+						return nil, fmt.Errorf("line %d: %w", lineno, err)
+					}
+					target.Features = append(target.Features, feature)
+				}
+			default:
+				return nil, fmt.Errorf("line %d: unknown field %q", lineno, key)
+			}
+		}
+		targets = append(targets, target)
+	}
+	return targets, scanner.Err()
+}
+
+var _ transport.FeatureResolver = (*FileResolver)(nil)
@@ -0,0 +1,214 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package resolvers contains transport.FeatureResolver implementations backed by
+// infrastructure outside of the mesh, such as DNS SRV records or local files.
+package resolvers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/netip"
+	"sort"
+	"strings"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+
+	"github.com/webmeshproj/webmesh/pkg/meshnet/transport"
+)
+
+// DefaultService is the service name used for SRV lookups and file entries
+// of nodes that accept join requests.
+const DefaultService = "webmesh"
+
+// Target is a single resolvable target with RFC 2782 style priority and weight.
+type Target struct {
+	// Host is the hostname or IP address of the target.
+	Host string
+	// Port is the port of the target.
+	Port uint16
+	// Priority is the priority of the target. Lower values are tried first.
+	Priority uint16
+	// Weight is the relative weight of targets with the same priority.
+	Weight uint16
+	// Features are the features served by the target. An empty list matches
+	// any feature.
+	Features []v1.Feature
+}
+
+// Serves returns true if the target serves the given feature.
+func (t Target) Serves(feature v1.Feature) bool {
+	if len(t.Features) == 0 {
+		return true
+	}
+	for _, f := range t.Features {
+		if f == feature {
+			return true
+		}
+	}
+	return false
+}
+
+// ServiceName returns the SRV service name used for the given feature. Join
+// related features map to DefaultService, while all others are prefixed with
+// it, e.g. "webmesh-storage-provider".
+func ServiceName(feature v1.Feature) string {
+	switch feature {
+	case v1.Feature_FEATURE_NONE, v1.Feature_NODES, v1.Feature_MEMBERSHIP:
+		return DefaultService
+	}
+	return DefaultService + "-" + strings.ReplaceAll(strings.ToLower(feature.String()), "_", "-")
+}
+
+// ParseFeature parses a feature from its lower-case dashed name, e.g.
+// "storage-provider", or its protobuf enum name.
+func ParseFeature(name string) (v1.Feature, error) {
+	name = strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(name), "-", "_"))
+	if v, ok := v1.Feature_value[name]; ok {
+		return v1.Feature(v), nil
+	}
+	return v1.Feature_FEATURE_NONE, fmt.Errorf("unknown feature %q", name)
+}
+
+// Order sorts the given targets by ascending priority. Targets with the same priority
+// are ordered with a weighted random selection as described in RFC 2782.
+func Order(targets []Target) []Target {
+	out := make([]Target, len(targets))
+	copy(out, targets)
+	sort.SliceStable(out, func(i, j int) bool {
+		return out[i].Priority < out[j].Priority
+	})
+	for start := 0; start < len(out); {
+		end := start + 1
+		for end < len(out) && out[end].Priority == out[start].Priority {
+			end++
+		}
+		shuffleByWeight(out[start:end])
+		start = end
+	}
+	return out
+}
+
+func shuffleByWeight(targets []Target) {
+	for i := 0; i < len(targets)-1; i++ {
+		var total int
+		for _, t := range targets[i:] {
+			total += int(t.Weight)
+		}
+		if total == 0 {
+			// All remaining weights are zero, so each is equally likely.
+			j := i + rand.Intn(len(targets)-i)
+			targets[i], targets[j] = targets[j], targets[i]
+			continue
+		}
+		n := rand.Intn(total + 1)
+		for j := i; j < len(targets); j++ {
+			n -= int(targets[j].Weight)
+			if n <= 0 {
+				targets[i], targets[j] = targets[j], targets[i]
+				break
+			}
+		}
+	}
+}
+
+// Expand resolves the hosts of the given ordered targets into address ports, preserving
+// the order of the targets. Targets that fail to resolve are skipped. An error
+// is only returned if no targets could be resolved.
+func Expand(ctx context.Context, resolver *net.Resolver, targets []Target) ([]netip.AddrPort, error) {
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	var out []netip.AddrPort
+	var errs []error
+	seen := make(map[netip.AddrPort]struct{})
+	for _, target := range targets {
+		host := strings.TrimSuffix(target.Host, ".")
+		var addrs []netip.Addr
+		if addr, err := netip.ParseAddr(host); err == nil {
+			addrs = []netip.Addr{addr}
+		} else {
+			addrs, err = resolver.LookupNetIP(ctx, "ip", host)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("lookup %s: %w", host, err))
+				continue
+			}
+		}
+		for _, addr := range addrs {
+			addrport := netip.AddrPortFrom(addr.Unmap(), target.Port)
+			if _, ok := seen[addrport]; ok {
+				continue
+			}
+			seen[addrport] = struct{}{}
+			out = append(out, addrport)
+		}
+	}
+	if len(out) == 0 && len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return out, nil
+}
+
+// Chain returns a resolver that returns the combined results of all the given resolvers
+// in order. Errors are only returned if every resolver fails. Closing the returned
+// resolver closes any of the given resolvers that implement io.Closer.
+func Chain(resolvers ...transport.FeatureResolver) transport.FeatureResolver {
+	return chain(resolvers)
+}
+
+type chain []transport.FeatureResolver
+
+// Resolve implements transport.FeatureResolver.
+func (c chain) Resolve(ctx context.Context, lookup v1.Feature) ([]netip.AddrPort, error) {
+	var out []netip.AddrPort
+	var errs []error
+	seen := make(map[netip.AddrPort]struct{})
+	for _, r := range c {
+		addrs, err := r.Resolve(ctx, lookup)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		for _, addr := range addrs {
+			if _, ok := seen[addr]; ok {
+				continue
+			}
+			seen[addr] = struct{}{}
+			out = append(out, addr)
+		}
+	}
+	if len(out) == 0 && len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return out, nil
+}
+
+// Close closes any of the underlying resolvers that implement io.Closer.
+func (c chain) Close() error {
+	var errs []error
+	for _, r := range c {
+		if closer, ok := r.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,236 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resolvers
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/transport"
+)
+
+func TestServiceName(t *testing.T) {
+	t.Parallel()
+	tc := map[v1.Feature]string{
+		v1.Feature_MEMBERSHIP:       "webmesh",
+		v1.Feature_NODES:            "webmesh",
+		v1.Feature_STORAGE_PROVIDER: "webmesh-storage-provider",
+		v1.Feature_MESH_DNS:         "webmesh-mesh-dns",
+	}
+	for feature, want := range tc {
+		if got := ServiceName(feature); got != want {
+			t.Errorf("ServiceName(%s) = %q, want %q", feature, got, want)
+		}
+		name := strings.ToLower(strings.ReplaceAll(feature.String(), "_", "-"))
+		parsed, err := ParseFeature(name)
+		if err != nil {
+			t.Errorf("ParseFeature(%q) returned error: %v", name, err)
+			continue
+		}
+		if parsed != feature {
+			t.Errorf("ParseFeature(%q) = %s, want %s", name, parsed, feature)
+		}
+	}
+}
+
+func TestParseTargets(t *testing.T) {
+	t.Parallel()
+	tc := []struct {
+		name    string
+		data    string
+		want    []Target
+		wantErr bool
+	}{
+		{
+			name: "Empty",
+			data: "# nothing here\n\n",
+			want: nil,
+		},
+		{
+			name: "AddressesOnly",
+			data: "10.0.0.1:8443\n[::1]:8443\n",
+			want: []Target{
+				{Host: "10.0.0.1", Port: 8443},
+				{Host: "::1", Port: 8443},
+			},
+		},
+		{
+			name: "AllFields",
+			data: "join.example.com:8443 priority=10 weight=5 features=membership,storage-provider # trailing comment\n",
+			want: []Target{
+				{
+					Host:     "join.example.com",
+					Port:     8443,
+					Priority: 10,
+					Weight:   5,
+					Features: []v1.Feature{v1.Feature_MEMBERSHIP, v1.Feature_STORAGE_PROVIDER},
+				},
+			},
+		},
+		{
+			name:    "InvalidAddress",
+			data:    "10.0.0.1\n",
+			wantErr: true,
+		},
+		{
+			name:    "InvalidPriority",
+			data:    "10.0.0.1:8443 priority=high\n",
+			wantErr: true,
+		},
+		{
+			name:    "UnknownField",
+			data:    "10.0.0.1:8443 color=blue\n",
+			wantErr: true,
+		},
+		{
+			name:    "UnknownFeature",
+			data:    "10.0.0.1:8443 features=teleport\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tc {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			got, err := ParseTargets([]byte(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseTargets() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("ParseTargets() = %+v, want %+v", got, tt.want)
+			}
+			for i := range got {
+				if got[i].Host != tt.want[i].Host || got[i].Port != tt.want[i].Port ||
+					got[i].Priority != tt.want[i].Priority || got[i].Weight != tt.want[i].Weight ||
+					len(got[i].Features) != len(tt.want[i].Features) {
+					t.Fatalf("ParseTargets()[%d] = %+v, want %+v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestOrder(t *testing.T) {
+	t.Parallel()
+	targets := []Target{
+		{Host: "c", Priority: 20, Weight: 1},
+		{Host: "a", Priority: 10, Weight: 0},
+		{Host: "b", Priority: 10, Weight: 100},
+		{Host: "d", Priority: 30},
+	}
+	var bFirst int
+	for i := 0; i < 100; i++ {
+		ordered := Order(targets)
+		if len(ordered) != len(targets) {
+			t.Fatalf("expected %d targets, got %d", len(targets), len(ordered))
+		}
+		if ordered[0].Priority != 10 || ordered[1].Priority != 10 {
+			t.Fatalf("expected lowest priority targets first, got %+v", ordered)
+		}
+		if ordered[2].Host != "c" || ordered[3].Host != "d" {
+			t.Fatalf("expected higher priority targets last, got %+v", ordered)
+		}
+		if ordered[0].Host == "b" {
+			bFirst++
+		}
+	}
+	// A zero weight target should only very rarely be selected first.
+	if bFirst < 90 {
+		t.Fatalf("expected weighted target to be selected first most of the time, got %d/100", bFirst)
+	}
+}
+
+func TestFileResolver(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	path := filepath.Join(t.TempDir(), "join-addresses")
+	err := os.WriteFile(path, []byte("127.0.0.1:8443 priority=20\n127.0.0.2:8443 priority=10 features=storage-provider\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewFileResolver(ctx, FileOptions{Path: path, RefreshInterval: time.Millisecond * 100})
+	if err != nil {
+		t.Fatalf("NewFileResolver() error = %v", err)
+	}
+	defer r.Close()
+
+	addrs, err := r.Resolve(ctx, v1.Feature_MEMBERSHIP)
+	if err != nil {
+		t.Fatalf("Resolve() error = %v", err)
+	}
+	if len(addrs) != 1 || addrs[0] != netip.MustParseAddrPort("127.0.0.1:8443") {
+		t.Fatalf("Resolve(MEMBERSHIP) = %v, want [127.0.0.1:8443]", addrs)
+	}
+	addrs, err = r.Resolve(ctx, v1.Feature_STORAGE_PROVIDER)
+	if err != nil {
+		t.Fatalf("Resolve() error = %v", err)
+	}
+	if len(addrs) != 2 || addrs[0] != netip.MustParseAddrPort("127.0.0.2:8443") {
+		t.Fatalf("Resolve(STORAGE_PROVIDER) = %v, want priority ordered addresses", addrs)
+	}
+
+	// Changes to the file should be picked up.
+	err = os.WriteFile(path, []byte("127.0.0.3:8443\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ok := false
+	for i := 0; i < 50; i++ {
+		addrs, err = r.Resolve(ctx, v1.Feature_MEMBERSHIP)
+		if err == nil && len(addrs) == 1 && addrs[0] == netip.MustParseAddrPort("127.0.0.3:8443") {
+			ok = true
+			break
+		}
+		time.Sleep(time.Millisecond * 50)
+	}
+	if !ok {
+		t.Fatalf("expected updated addresses, got %v", addrs)
+	}
+}
+
+func TestChain(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	first := transport.FeatureResolverFunc(func(ctx context.Context, lookup v1.Feature) ([]netip.AddrPort, error) {
+		return []netip.AddrPort{netip.MustParseAddrPort("127.0.0.1:1"), netip.MustParseAddrPort("127.0.0.2:1")}, nil
+	})
+	failing := transport.FeatureResolverFunc(func(ctx context.Context, lookup v1.Feature) ([]netip.AddrPort, error) {
+		return nil, os.ErrNotExist
+	})
+	second := transport.FeatureResolverFunc(func(ctx context.Context, lookup v1.Feature) ([]netip.AddrPort, error) {
+		return []netip.AddrPort{netip.MustParseAddrPort("127.0.0.2:1"), netip.MustParseAddrPort("127.0.0.3:1")}, nil
+	})
+	addrs, err := Chain(first, failing, second).Resolve(ctx, v1.Feature_MEMBERSHIP)
+	if err != nil {
+		t.Fatalf("Resolve() error = %v", err)
+	}
+	if len(addrs) != 3 {
+		t.Fatalf("expected 3 deduplicated addresses, got %v", addrs)
+	}
+	_, err = Chain(failing).Resolve(ctx, v1.Feature_MEMBERSHIP)
+	if err == nil {
+		t.Fatal("expected error when all resolvers fail")
+	}
+}
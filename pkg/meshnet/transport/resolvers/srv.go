@@ -0,0 +1,125 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resolvers
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/transport"
+)
+
+// DefaultRefreshInterval is the default interval for refreshing resolved targets.
+const DefaultRefreshInterval = time.Minute
+
+// SRVOptions are options for a DNS SRV resolver.
+type SRVOptions struct {
+	// Domain is the domain to look up SRV records in. A lookup for the
+	// membership feature in example.com will query _webmesh._tcp.example.com.
+	Domain string
+	// RefreshInterval is how long looked up records are cached before they
+	// are queried again. Defaults to DefaultRefreshInterval.
+	RefreshInterval time.Duration
+	// Resolver is the DNS resolver to use. Defaults to net.DefaultResolver.
+	Resolver *net.Resolver
+}
+
+// NewSRVResolver returns a new feature resolver that looks up targets with DNS
+// SRV records. Records are ordered by priority and weight on every call.
+// If a refresh fails, the previously looked up records are used until a
+// refresh succeeds.
+func NewSRVResolver(opts SRVOptions) transport.FeatureResolver {
+	if opts.RefreshInterval <= 0 {
+		opts.RefreshInterval = DefaultRefreshInterval
+	}
+	if opts.Resolver == nil {
+		opts.Resolver = net.DefaultResolver
+	}
+	opts.Domain = strings.TrimSuffix(opts.Domain, ".")
+	return &srvResolver{
+		SRVOptions: opts,
+		cache:      make(map[v1.Feature]srvCacheEntry),
+	}
+}
+
+type srvResolver struct {
+	SRVOptions
+	cache map[v1.Feature]srvCacheEntry
+	mu    sync.Mutex
+}
+
+type srvCacheEntry struct {
+	targets []Target
+	expires time.Time
+}
+
+// Resolve implements transport.FeatureResolver.
+func (r *srvResolver) Resolve(ctx context.Context, lookup v1.Feature) ([]netip.AddrPort, error) {
+	targets, err := r.targets(ctx, lookup)
+	if err != nil {
+		return nil, err
+	}
+	return Expand(ctx, r.Resolver, Order(targets))
+}
+
+func (r *srvResolver) targets(ctx context.Context, lookup v1.Feature) ([]Target, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	cached, ok := r.cache[lookup]
+	if ok && time.Now().Before(cached.expires) {
+		return cached.targets, nil
+	}
+	service := ServiceName(lookup)
+	_, records, err := r.Resolver.LookupSRV(ctx, service, "tcp", r.Domain)
+	if err != nil {
+		if ok {
+			context.LoggerFrom(ctx).Warn("Failed to refresh SRV records, using cached targets",
+				slog.String("service", service),
+				slog.String("domain", r.Domain),
+				slog.String("error", err.Error()),
+			)
+			return cached.targets, nil
+		}
+		return nil, fmt.Errorf("lookup _%s._tcp.%s: %w", service, r.Domain, err)
+	}
+	targets := make([]Target, 0, len(records))
+	for _, rec := range records {
+		if rec.Target == "." {
+			// A target of "." means the service is decidedly not available.
+			continue
+		}
+		targets = append(targets, Target{
+			Host:     rec.Target,
+			Port:     rec.Port,
+			Priority: rec.Priority,
+			Weight:   rec.Weight,
+		})
+	}
+	r.cache[lookup] = srvCacheEntry{
+		targets: targets,
+		expires: time.Now().Add(r.RefreshInterval),
+	}
+	return targets, nil
+}
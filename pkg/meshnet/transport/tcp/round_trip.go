@@ -18,6 +18,8 @@ package tcp
 
 import (
 	"errors"
+	"fmt"
+	"io"
 	"time"
 
 	v1 "github.com/webmeshproj/api/go/v1"
@@ -37,10 +39,19 @@ type RoundTripOptions struct {
 	// AddressTimeout is the timeout for dialing each address. If not set
 	// any timeout on the context will be used.
 	AddressTimeout time.Duration
+	// Resolver is an optional resolver to look up additional addresses with
+	// on every round trip. Resolved addresses are tried after Addrs. If the
+	// resolver implements io.Closer, it is closed with the round tripper.
+	Resolver transport.FeatureResolver
+	// Feature is the feature to look up with the Resolver.
+	Feature v1.Feature
 }
 
 // NewJoinRoundTripper creates a new gRPC round tripper for issuing a Join Request.
 func NewJoinRoundTripper(opts RoundTripOptions) transport.JoinRoundTripper {
+	if opts.Feature == v1.Feature_FEATURE_NONE {
+		opts.Feature = v1.Feature_MEMBERSHIP
+	}
 	return NewRoundTripper[v1.JoinRequest, v1.JoinResponse](opts, v1.Membership_Join_FullMethodName)
 }
 
@@ -57,14 +68,23 @@ type grpcRoundTripper[REQ, RESP any] struct {
 	method string
 }
 
-func (rt *grpcRoundTripper[REQ, RESP]) Close() error { return nil }
+func (rt *grpcRoundTripper[REQ, RESP]) Close() error {
+	if closer, ok := rt.Resolver.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
 
 func (rt *grpcRoundTripper[REQ, RESP]) RoundTrip(ctx context.Context, req *REQ) (*RESP, error) {
 	var dialCtx context.Context
 	var cancel context.CancelFunc
 	var err error
+	addrs, err := rt.addrs(ctx)
+	if err != nil {
+		return nil, err
+	}
 	t := NewGRPCTransport(TransportOptions{Credentials: rt.Credentials})
-	for _, addr := range rt.Addrs {
+	for _, addr := range addrs {
 		if ctx.Err() != nil {
 			return nil, ctx.Err()
 		}
@@ -106,3 +126,24 @@ func (rt *grpcRoundTripper[REQ, RESP]) RoundTrip(ctx context.Context, req *REQ)
 	// We should never get here.
 	return nil, errors.New("no addresses to dial")
 }
+
+// addrs returns the addresses to try for a round trip. Resolved addresses are
+// looked up fresh on every call so retries follow infrastructure changes.
+func (rt *grpcRoundTripper[REQ, RESP]) addrs(ctx context.Context) ([]string, error) {
+	if rt.Resolver == nil {
+		return rt.Addrs, nil
+	}
+	addrs := make([]string, len(rt.Addrs))
+	copy(addrs, rt.Addrs)
+	resolved, err := rt.Resolver.Resolve(ctx, rt.Feature)
+	if err != nil {
+		if len(addrs) == 0 {
+			return nil, fmt.Errorf("resolve %s addresses: %w", rt.Feature, err)
+		}
+		context.LoggerFrom(ctx).Warn("Failed to resolve addresses", "feature", rt.Feature.String(), "error", err.Error())
+	}
+	for _, addr := range resolved {
+		addrs = append(addrs, addr.String())
+	}
+	return addrs, nil
+}
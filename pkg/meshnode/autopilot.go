@@ -0,0 +1,378 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshnode
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/raft"
+	v1 "github.com/webmeshproj/api/go/v1"
+
+	storageautopilot "github.com/webmeshproj/webmesh/pkg/storage/autopilot"
+	storagepresence "github.com/webmeshproj/webmesh/pkg/storage/presence"
+	"github.com/webmeshproj/webmesh/pkg/storage/providers/raftstorage"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// maxAutopilotDecisions is the number of recent decisions kept in the autopilot state.
+const maxAutopilotDecisions = 20
+
+// autopilot tracks the health of the consensus group between autopilot runs.
+type autopilot struct {
+	// failing are the servers failing heartbeats by when they were last heard from.
+	failing   map[raft.ServerID]time.Time
+	decisions []types.AutopilotDecision
+	// stored is the last state written to storage, if any.
+	stored *types.AutopilotState
+	mu     sync.Mutex
+}
+
+// failedHeartbeat records a failed heartbeat to a server.
+func (a *autopilot) failedHeartbeat(id raft.ServerID, lastContact time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.failing == nil {
+		a.failing = make(map[raft.ServerID]time.Time)
+	}
+	if _, ok := a.failing[id]; ok {
+		return
+	}
+	if lastContact.IsZero() {
+		lastContact = time.Now()
+	}
+	a.failing[id] = lastContact.UTC()
+}
+
+// forget clears the heartbeat failures of a server.
+func (a *autopilot) forget(id raft.ServerID) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.failing, id)
+}
+
+// failingSince returns when a server failing heartbeats was last heard from.
+func (a *autopilot) failingSince(id raft.ServerID) (time.Time, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	since, ok := a.failing[id]
+	return since, ok
+}
+
+// record records a decision and returns the most recent ones.
+func (a *autopilot) record(d *types.AutopilotDecision) []types.AutopilotDecision {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if d != nil {
+		a.decisions = append(a.decisions, *d)
+		if len(a.decisions) > maxAutopilotDecisions {
+			a.decisions = a.decisions[len(a.decisions)-maxAutopilotDecisions:]
+		}
+	}
+	return slices.Clone(a.decisions)
+}
+
+// changed returns true if the state differs from the one last stored, apart
+// from when it was taken.
+func (a *autopilot) changed(state types.AutopilotState) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.stored == nil {
+		return true
+	}
+	last := *a.stored
+	last.UpdatedAt = state.UpdatedAt
+	return !reflect.DeepEqual(last, state)
+}
+
+// setStored records the state last written to storage. A nil state forces the
+// next one to be written.
+func (a *autopilot) setStored(state *types.AutopilotState) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.stored = state
+}
+
+// runAutopilot manages the voters of the consensus group while this node is
+// the leader, until the node is closed. At most one change is made per round
+// so the group settles before the next one.
+func (s *meshStore) runAutopilot(provider *raftstorage.Provider) {
+	t := time.NewTicker(s.opts.AutopilotInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-s.closec:
+			return
+		case <-t.C:
+		}
+		if !provider.Consensus().IsLeader() {
+			// Another leader may write the state in the meantime.
+			s.autopilot.setStored(nil)
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), s.opts.AutopilotInterval)
+		s.autopilotRound(ctx, provider)
+		cancel()
+	}
+}
+
+// autopilotRound checks the health of the consensus group, applies the next
+// change it needs, and records the resulting state when it changed.
+func (s *meshStore) autopilotRound(ctx context.Context, provider *raftstorage.Provider) {
+	servers := s.autopilotServers(ctx, provider)
+	policy := autopilotPolicy{
+		targetVoters:    s.opts.AutopilotTargetVoters,
+		deadServerAfter: s.opts.AutopilotDeadServerAfter,
+	}
+	var decision *types.AutopilotDecision
+	if d, ok := planAutopilot(policy, servers, time.Now().UTC()); ok {
+		s.applyAutopilotDecision(ctx, provider, &d, servers)
+		decision = &d
+		servers = s.autopilotServers(ctx, provider)
+	}
+	state := types.AutopilotState{
+		Leader:           s.ID(),
+		UpdatedAt:        time.Now().UTC(),
+		TargetVoters:     s.opts.AutopilotTargetVoters,
+		FailureTolerance: failureTolerance(servers),
+		Servers:          servers,
+		Decisions:        s.autopilot.record(decision),
+	}
+	if !s.autopilot.changed(state) {
+		// Every write goes through consensus.
+		return
+	}
+	if err := storageautopilot.New(provider.MeshStorage()).Put(ctx, state); err != nil {
+		s.log.Warn("Failed to store autopilot state", slog.String("error", err.Error()))
+		return
+	}
+	s.autopilot.setStored(&state)
+}
+
+// autopilotServers returns the health of the servers in the consensus group,
+// sorted by ID. Log lag is measured against the applied index members report
+// in their presence leases.
+func (s *meshStore) autopilotServers(ctx context.Context, provider *raftstorage.Provider) []types.AutopilotServer {
+	leases := make(map[types.NodeID]types.Presence)
+	if s.opts.PresenceInterval > 0 {
+		list, err := storagepresence.New(provider.MeshStorage()).List(ctx)
+		if err != nil {
+			s.log.Warn("Failed to list presence leases", slog.String("error", err.Error()))
+		}
+		for _, lease := range list {
+			leases[lease.NodeID] = lease
+		}
+	}
+	leaderIndex := provider.AppliedIndex()
+	now := time.Now()
+	var servers []types.AutopilotServer
+	for _, srv := range provider.GetRaftConfiguration().Servers {
+		server := types.AutopilotServer{
+			ID:      types.NodeID(srv.ID),
+			Address: string(srv.Address),
+			Voter:   srv.Suffrage == raft.Voter,
+			Leader:  string(srv.ID) == s.nodeID,
+		}
+		if node, err := provider.MeshDB().Peers().Get(ctx, server.ID); err == nil {
+			server.ZoneAwarenessID = node.GetZoneAwarenessID()
+		}
+		if !server.Leader {
+			lease, hasLease := leases[server.ID]
+			if hasLease && lease.AppliedIndex < leaderIndex {
+				server.Lag = leaderIndex - lease.AppliedIndex
+			}
+			since, failing := s.autopilot.failingSince(srv.ID)
+			if failing {
+				server.FailingSince = since
+			}
+			switch {
+			case failing && now.Sub(since) >= s.opts.AutopilotInterval:
+				server.Reason = "failing heartbeats"
+			case hasLease && !lease.Online(now):
+				server.Reason = "presence lease expired"
+			case s.opts.AutopilotMaxLag > 0 && server.Lag > s.opts.AutopilotMaxLag:
+				server.Reason = fmt.Sprintf("%d log entries behind the leader", server.Lag)
+			}
+		}
+		server.Healthy = server.Reason == ""
+		servers = append(servers, server)
+	}
+	sort.Slice(servers, func(i, j int) bool {
+		return servers[i].ID < servers[j].ID
+	})
+	return servers
+}
+
+// applyAutopilotDecision makes the change to the consensus group. The error of
+// a failed change is recorded on the decision.
+func (s *meshStore) applyAutopilotDecision(ctx context.Context, provider *raftstorage.Provider, d *types.AutopilotDecision, servers []types.AutopilotServer) {
+	peer := types.StoragePeer{StoragePeer: &v1.StoragePeer{Id: d.NodeID.String()}}
+	for _, srv := range servers {
+		if srv.ID == d.NodeID {
+			peer.Address = srv.Address
+		}
+	}
+	log := s.log.With(slog.String("peer", d.NodeID.String()), slog.String("reason", d.Reason))
+	log.Info("Autopilot changing consensus group", slog.String("action", d.Action))
+	consensus := provider.Consensus()
+	var err error
+	switch d.Action {
+	case types.AutopilotPromote:
+		err = consensus.AddVoter(ctx, peer)
+	case types.AutopilotDemote:
+		err = consensus.DemoteVoter(ctx, peer)
+	case types.AutopilotRemove:
+		err = consensus.RemovePeer(ctx, peer, true)
+		if err == nil {
+			s.autopilot.forget(raft.ServerID(d.NodeID))
+			if err := provider.MeshDB().Peers().Delete(ctx, d.NodeID); err != nil {
+				log.Warn("Failed to remove peer from database", slog.String("error", err.Error()))
+			}
+			s.releaseLocks(ctx, d.NodeID)
+		}
+	}
+	if err != nil {
+		log.Warn("Autopilot failed to change consensus group", slog.String("action", d.Action), slog.String("error", err.Error()))
+		d.Error = err.Error()
+	}
+}
+
+// autopilotPolicy are the goals of the autopilot.
+type autopilotPolicy struct {
+	// targetVoters is the number of voters to keep.
+	targetVoters int
+	// deadServerAfter is how long a server can fail heartbeats before it is
+	// removed. Zero never removes servers.
+	deadServerAfter time.Duration
+}
+
+// planAutopilot returns the next change to make to the consensus group, if any.
+// In order of priority it removes dead servers, promotes healthy observers when
+// short of healthy voters or when they cover a new zone, demotes unhealthy voters,
+// and demotes voters beyond the target. No change leaves fewer healthy voters
+// than a quorum of the resulting group, and the leader is never changed.
+func planAutopilot(policy autopilotPolicy, servers []types.AutopilotServer, now time.Time) (types.AutopilotDecision, bool) {
+	var voters, healthyVoters int
+	zones := make(map[string]int)
+	for _, srv := range servers {
+		if !srv.Voter {
+			continue
+		}
+		voters++
+		if srv.Healthy {
+			healthyVoters++
+			zones[srv.ZoneAwarenessID]++
+		}
+	}
+	decide := func(action string, srv types.AutopilotServer, reason string) (types.AutopilotDecision, bool) {
+		return types.AutopilotDecision{Time: now, Action: action, NodeID: srv.ID, Reason: reason}, true
+	}
+	if policy.deadServerAfter > 0 {
+		for _, srv := range servers {
+			if srv.Leader || srv.FailingSince.IsZero() || now.Sub(srv.FailingSince) < policy.deadServerAfter {
+				continue
+			}
+			if srv.Voter && healthyVoters < quorumSize(voters-1) {
+				continue
+			}
+			return decide(types.AutopilotRemove, srv, fmt.Sprintf("no contact since %s", srv.FailingSince.Format(time.RFC3339)))
+		}
+	}
+	newZone := func(srv types.AutopilotServer) bool {
+		return srv.ZoneAwarenessID != "" && zones[srv.ZoneAwarenessID] == 0
+	}
+	var candidate *types.AutopilotServer
+	for i, srv := range servers {
+		if srv.Voter || !srv.Healthy {
+			continue
+		}
+		if candidate == nil ||
+			(newZone(srv) && !newZone(*candidate)) ||
+			(newZone(srv) == newZone(*candidate) && srv.Lag < candidate.Lag) {
+			candidate = &servers[i]
+		}
+	}
+	if candidate != nil {
+		if healthyVoters < policy.targetVoters {
+			return decide(types.AutopilotPromote, *candidate, fmt.Sprintf("%d of %d target voters are healthy", healthyVoters, policy.targetVoters))
+		}
+		if newZone(*candidate) && sharesZone(zones) {
+			return decide(types.AutopilotPromote, *candidate, fmt.Sprintf("spreads voters to zone %q", candidate.ZoneAwarenessID))
+		}
+	}
+	for _, srv := range servers {
+		if srv.Voter && !srv.Healthy && !srv.Leader && healthyVoters >= quorumSize(voters-1) {
+			return decide(types.AutopilotDemote, srv, srv.Reason)
+		}
+	}
+	if voters > policy.targetVoters && healthyVoters-1 >= quorumSize(voters-1) {
+		var excess *types.AutopilotServer
+		for i, srv := range servers {
+			if !srv.Voter || srv.Leader {
+				continue
+			}
+			shared := srv.ZoneAwarenessID != "" && zones[srv.ZoneAwarenessID] > 1
+			if excess == nil {
+				excess = &servers[i]
+				continue
+			}
+			excessShared := excess.ZoneAwarenessID != "" && zones[excess.ZoneAwarenessID] > 1
+			if (shared && !excessShared) || (shared == excessShared && srv.Lag > excess.Lag) {
+				excess = &servers[i]
+			}
+		}
+		if excess != nil {
+			return decide(types.AutopilotDemote, *excess, fmt.Sprintf("%d voters exceed the target of %d", voters, policy.targetVoters))
+		}
+	}
+	return types.AutopilotDecision{}, false
+}
+
+// failureTolerance returns how many voters can fail without losing quorum.
+func failureTolerance(servers []types.AutopilotServer) int {
+	var voters, healthyVoters int
+	for _, srv := range servers {
+		if srv.Voter {
+			voters++
+			if srv.Healthy {
+				healthyVoters++
+			}
+		}
+	}
+	return max(0, healthyVoters-quorumSize(voters))
+}
+
+// quorumSize returns the number of voters needed for a quorum.
+func quorumSize(voters int) int {
+	return voters/2 + 1
+}
+
+// sharesZone returns true if more than one healthy voter is in the same zone.
+func sharesZone(zones map[string]int) bool {
+	for zone, count := range zones {
+		if zone != "" && count > 1 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,182 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshnode
+
+import (
+	"testing"
+	"time"
+
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+func TestPlanAutopilot(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	policy := autopilotPolicy{targetVoters: 3, deadServerAfter: time.Minute}
+	voter := func(id, zone string, healthy bool) types.AutopilotServer {
+		return types.AutopilotServer{ID: types.NodeID(id), Voter: true, ZoneAwarenessID: zone, Healthy: healthy, Leader: id == "a"}
+	}
+	observer := func(id, zone string, healthy bool) types.AutopilotServer {
+		return types.AutopilotServer{ID: types.NodeID(id), ZoneAwarenessID: zone, Healthy: healthy}
+	}
+	dead := func(srv types.AutopilotServer, since time.Duration) types.AutopilotServer {
+		srv.Healthy = false
+		srv.FailingSince = now.Add(-since)
+		return srv
+	}
+	tc := []struct {
+		name    string
+		servers []types.AutopilotServer
+		action  string
+		node    string
+	}{
+		{
+			name:    "AtTarget",
+			servers: []types.AutopilotServer{voter("a", "z1", true), voter("b", "z2", true), voter("c", "z3", true), observer("d", "z1", true)},
+		},
+		{
+			name:    "PromotesForMissingVoter",
+			servers: []types.AutopilotServer{voter("a", "z1", true), voter("b", "z2", true), observer("d", "z1", true), observer("e", "z3", true)},
+			action:  types.AutopilotPromote,
+			node:    "e",
+		},
+		{
+			name:    "PromotesLeastLagging",
+			servers: []types.AutopilotServer{voter("a", "", true), voter("b", "", true), {ID: "d", Healthy: true, Lag: 50}, {ID: "e", Healthy: true, Lag: 5}},
+			action:  types.AutopilotPromote,
+			node:    "e",
+		},
+		{
+			name:    "SkipsUnhealthyObservers",
+			servers: []types.AutopilotServer{voter("a", "z1", true), voter("b", "z2", true), observer("d", "z3", false)},
+		},
+		{
+			name:    "PromotesReplacementBeforeDemoting",
+			servers: []types.AutopilotServer{voter("a", "z1", true), voter("b", "z2", true), voter("c", "z3", false), observer("d", "z3", true)},
+			action:  types.AutopilotPromote,
+			node:    "d",
+		},
+		{
+			name:    "DemotesUnhealthyVoter",
+			servers: []types.AutopilotServer{voter("a", "z1", true), voter("b", "z2", true), voter("c", "z3", false), voter("d", "z3", true)},
+			action:  types.AutopilotDemote,
+			node:    "c",
+		},
+		{
+			name:    "SpreadsAcrossZones",
+			servers: []types.AutopilotServer{voter("a", "z1", true), voter("b", "z1", true), voter("c", "z2", true), observer("d", "z3", true)},
+			action:  types.AutopilotPromote,
+			node:    "d",
+		},
+		{
+			name:    "DemotesExcessVoterSharingZone",
+			servers: []types.AutopilotServer{voter("a", "z1", true), voter("b", "z1", true), voter("c", "z2", true), voter("d", "z3", true)},
+			action:  types.AutopilotDemote,
+			node:    "b",
+		},
+		{
+			name:    "NeverDemotesLeader",
+			servers: []types.AutopilotServer{voter("a", "z1", true), voter("b", "z2", true)},
+			action:  "",
+		},
+		{
+			name:    "RemovesDeadObserver",
+			servers: []types.AutopilotServer{voter("a", "z1", true), voter("b", "z2", true), voter("c", "z3", true), dead(observer("d", "z1", true), time.Hour)},
+			action:  types.AutopilotRemove,
+			node:    "d",
+		},
+		{
+			name:    "WaitsBeforeRemovingDeadServer",
+			servers: []types.AutopilotServer{voter("a", "z1", true), voter("b", "z2", true), voter("c", "z3", true), dead(observer("d", "z1", true), time.Second)},
+		},
+		{
+			name:    "RemovesDeadVoterKeepingQuorum",
+			servers: []types.AutopilotServer{voter("a", "z1", true), voter("b", "z2", true), dead(voter("c", "z3", true), time.Hour)},
+			action:  types.AutopilotRemove,
+			node:    "c",
+		},
+		{
+			name:    "KeepsDeadVotersNeededForQuorum",
+			servers: []types.AutopilotServer{voter("a", "z1", true), dead(voter("b", "z2", true), time.Hour), dead(voter("c", "z3", true), time.Hour)},
+		},
+	}
+	for _, tt := range tc {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			decision, ok := planAutopilot(policy, tt.servers, now)
+			if tt.action == "" {
+				if ok {
+					t.Fatalf("expected no decision, got %+v", decision)
+				}
+				return
+			}
+			if !ok {
+				t.Fatalf("expected %s of %s, got no decision", tt.action, tt.node)
+			}
+			if decision.Action != tt.action || decision.NodeID.String() != tt.node {
+				t.Fatalf("expected %s of %s, got %+v", tt.action, tt.node, decision)
+			}
+		})
+	}
+}
+
+func TestFailureTolerance(t *testing.T) {
+	t.Parallel()
+	servers := []types.AutopilotServer{
+		{ID: "a", Voter: true, Healthy: true},
+		{ID: "b", Voter: true, Healthy: true},
+		{ID: "c", Voter: true, Healthy: true},
+		{ID: "d", Voter: true, Healthy: false},
+		{ID: "e", Healthy: true},
+	}
+	if got := failureTolerance(servers); got != 0 {
+		t.Fatalf("expected tolerance 0 with 3 of 4 voters healthy, got %d", got)
+	}
+	servers[3].Healthy = true
+	if got := failureTolerance(servers); got != 1 {
+		t.Fatalf("expected tolerance 1 with 4 healthy voters, got %d", got)
+	}
+}
+
+func TestAutopilotStateChanged(t *testing.T) {
+	t.Parallel()
+	var a autopilot
+	state := types.AutopilotState{
+		Leader:       "a",
+		UpdatedAt:    time.Now().UTC(),
+		TargetVoters: 3,
+		Servers:      []types.AutopilotServer{{ID: "a", Voter: true, Leader: true, Healthy: true}},
+	}
+	if !a.changed(state) {
+		t.Fatal("expected a state to be written when none was stored")
+	}
+	a.setStored(&state)
+	later := state
+	later.UpdatedAt = state.UpdatedAt.Add(time.Minute)
+	later.Servers = []types.AutopilotServer{{ID: "a", Voter: true, Leader: true, Healthy: true}}
+	if a.changed(later) {
+		t.Fatal("expected only a new timestamp to leave the state unchanged")
+	}
+	later.Servers = append(later.Servers, types.AutopilotServer{ID: "b", Healthy: true})
+	if !a.changed(later) {
+		t.Fatal("expected a new server to change the state")
+	}
+	a.setStored(nil)
+	if !a.changed(state) {
+		t.Fatal("expected a reset to write the next state")
+	}
+}
@@ -26,6 +26,10 @@ import (
 
 	"github.com/multiformats/go-multiaddr"
 	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 
 	"github.com/webmeshproj/webmesh/pkg/crypto"
 	"github.com/webmeshproj/webmesh/pkg/meshnet"
@@ -247,7 +251,7 @@ func (s *meshStore) Connect(ctx context.Context, opts ConnectOptions) (err error
 		raft.OnObservation(s.newObserver())
 	}
 	// Register an update hook to watch for network changes.
-	if s.storage.Consensus().IsMember() {
+	if s.storage.Consensus().IsMember() || storage.IsReadReplica(s.storage) {
 		s.log.Debug("Subscribing to peer updates from local storage")
 		s.kvSubCancel, err = s.storage.MeshDB().Peers().Subscribe(context.Background(), s.onPeerUpdate)
 		if err != nil {
@@ -259,6 +263,9 @@ func (s *meshStore) Connect(ctx context.Context, opts ConnectOptions) (err error
 		var subctx context.Context
 		subctx, s.kvSubCancel = context.WithCancel(context.Background())
 		go func() {
+			// revision is the revision of the last configuration received, used to
+			// resume the subscription without a full resync when reconnecting.
+			var revision uint64
 			for {
 				s.log.Debug("Dialing network leader for membership updates")
 				c, err := s.DialLeader(subctx)
@@ -272,9 +279,14 @@ func (s *meshStore) Connect(ctx context.Context, opts ConnectOptions) (err error
 				}
 				defer c.Close()
 				s.log.Debug("Subscribing to peer updates from the network leader")
-				stream, err := v1.NewMembershipClient(c).SubscribePeers(subctx, &v1.SubscribePeersRequest{
+				streamctx := subctx
+				if revision > 0 {
+					streamctx = storage.ContextWithWatchRevision(subctx, revision)
+				}
+				var header metadata.MD
+				stream, err := v1.NewMembershipClient(c).SubscribePeers(streamctx, &v1.SubscribePeersRequest{
 					Id: s.ID().String(),
-				})
+				}, grpc.Header(&header))
 				if err != nil {
 					if subctx.Err() != nil {
 						return
@@ -292,6 +304,10 @@ func (s *meshStore) Connect(ctx context.Context, opts ConnectOptions) (err error
 						if subctx.Err() != nil {
 							return
 						}
+						if status.Code(err) == codes.Unimplemented {
+							// The leader cannot resume subscriptions.
+							revision = 0
+						}
 						s.log.Error("Failed to receive peer updates, will retry", slog.String("error", err.Error()))
 						time.Sleep(time.Second)
 						break
@@ -306,10 +322,19 @@ func (s *meshStore) Connect(ctx context.Context, opts ConnectOptions) (err error
 						time.Sleep(time.Second)
 						break
 					}
+					if rev := storage.RevisionFromHeader(header); rev > 0 {
+						revision = rev
+					}
 				}
 			}
 		}()
 	}
+	if s.opts.PresenceInterval > 0 {
+		go s.runPresence(opts)
+	}
+	if raft, ok := s.storage.(*raftstorage.Provider); ok && s.opts.AutopilotTargetVoters > 0 && s.opts.AutopilotInterval > 0 {
+		go s.runAutopilot(raft)
+	}
 	return nil
 }
 
@@ -103,6 +103,26 @@ type Config struct {
 	// assuming a peer is offline. This is only applicable when currently
 	// the leader of the raft group.
 	HeartbeatPurgeThreshold int
+	// PresenceInterval is how often the node renews its presence lease.
+	// Zero disables presence leases.
+	PresenceInterval time.Duration
+	// PresencePurgeAfter is how long after its presence lease expired a
+	// member that does not provide storage is removed from the mesh. This
+	// only applies while the node is the storage leader and renewing its own
+	// presence lease. Zero disables it.
+	PresencePurgeAfter time.Duration
+	// AutopilotTargetVoters is the number of voters the autopilot keeps in
+	// the consensus group while this node is the leader. Zero disables the
+	// autopilot.
+	AutopilotTargetVoters int
+	// AutopilotInterval is how often the autopilot checks the consensus group.
+	AutopilotInterval time.Duration
+	// AutopilotMaxLag is the number of log entries a server can be behind the
+	// leader before it is considered unhealthy.
+	AutopilotMaxLag uint64
+	// AutopilotDeadServerAfter is how long a server can fail heartbeats before
+	// the autopilot removes it. Zero disables removing dead servers.
+	AutopilotDeadServerAfter time.Duration
 	// ZoneAwarenessID is an to use with zone-awareness to determine
 	// peers in the same LAN segment.
 	ZoneAwarenessID string
@@ -168,6 +188,7 @@ type meshStore struct {
 	dnsUpdateGroup   *errgroup.Group
 	leaveRTT         transport.LeaveRoundTripper
 	closec           chan struct{}
+	autopilot        autopilot
 	log              *slog.Logger
 	mu               sync.Mutex
 	// a flag set on test stores to indicate skipping certain operations
@@ -25,6 +25,7 @@ import (
 	v1 "github.com/webmeshproj/api/go/v1"
 
 	"github.com/webmeshproj/webmesh/pkg/meshnet"
+	"github.com/webmeshproj/webmesh/pkg/storage/locks"
 	"github.com/webmeshproj/webmesh/pkg/storage/providers/raftstorage"
 	"github.com/webmeshproj/webmesh/pkg/storage/types"
 )
@@ -38,6 +39,7 @@ func (s *meshStore) newObserver() func(context.Context, raft.Observation) {
 		consensus := provider.Consensus()
 		switch data := ev.Data.(type) {
 		case raft.FailedHeartbeatObservation:
+			s.autopilot.failedHeartbeat(data.PeerID, data.LastContact)
 			if s.opts.HeartbeatPurgeThreshold <= 0 {
 				return
 			}
@@ -53,13 +55,18 @@ func (s *meshStore) newObserver() func(context.Context, raft.Observation) {
 				if err := provider.MeshDB().Peers().Delete(ctx, types.NodeID(data.PeerID)); err != nil {
 					log.Warn("Failed to remove peer from database", slog.String("error", err.Error()))
 				}
+				s.releaseLocks(ctx, types.NodeID(data.PeerID))
 				delete(failedHeartBeats, data.PeerID)
 			}
 		case raft.ResumedHeartbeatObservation:
+			s.autopilot.forget(data.PeerID)
 			if s.opts.HeartbeatPurgeThreshold > 0 {
 				delete(failedHeartBeats, data.PeerID)
 			}
 		case raft.PeerObservation:
+			if data.Removed {
+				s.autopilot.forget(data.Peer.ID)
+			}
 			if s.testStore {
 				return
 			}
@@ -115,3 +122,19 @@ func (s *meshStore) newObserver() func(context.Context, raft.Observation) {
 		}
 	}
 }
+
+// releaseLocks releases the locks held by a node that was removed from the mesh.
+func (s *meshStore) releaseLocks(ctx context.Context, nodeID types.NodeID) {
+	locker, err := locks.NewLocker(s.Storage().MeshStorage(), locks.Options{NodeID: s.ID()})
+	if err != nil {
+		return
+	}
+	released, err := locker.ReleaseNode(ctx, nodeID)
+	if err != nil {
+		s.log.Warn("Failed to release locks of removed peer", slog.String("peer", nodeID.String()), slog.String("error", err.Error()))
+		return
+	}
+	if released > 0 {
+		s.log.Info("Released locks of removed peer", slog.String("peer", nodeID.String()), slog.Int("locks", released))
+	}
+}
@@ -0,0 +1,174 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshnode
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/webmeshproj/webmesh/pkg/services/presence"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	storagepresence "github.com/webmeshproj/webmesh/pkg/storage/presence"
+	"github.com/webmeshproj/webmesh/pkg/storage/providers/raftstorage"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+	"github.com/webmeshproj/webmesh/pkg/version"
+)
+
+// presenceTTLMultiplier is the number of renewal intervals a presence lease
+// lasts, so that a couple of missed renewals do not mark a member offline.
+const presenceTTLMultiplier = 3
+
+// runPresence renews the presence lease of this node until it is closed. While
+// this node is the storage leader it also purges members whose lease expired
+// longer than the configured grace period ago. A new leader waits for a full
+// lease TTL before purging, so that members get a chance to renew leases that
+// went stale while there was no leader to accept them.
+func (s *meshStore) runPresence(opts ConnectOptions) {
+	lease := types.Presence{
+		NodeID:       s.ID(),
+		AgentVersion: version.Version,
+		TTL:          s.opts.PresenceInterval * presenceTTLMultiplier,
+	}
+	if opts.PrimaryEndpoint.IsValid() {
+		lease.Endpoints = append(lease.Endpoints, opts.PrimaryEndpoint.String())
+	}
+	for _, ep := range opts.WireGuardEndpoints {
+		lease.Endpoints = append(lease.Endpoints, ep.String())
+	}
+	t := time.NewTicker(s.opts.PresenceInterval)
+	defer t.Stop()
+	var leaderSince time.Time
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), s.opts.PresenceInterval)
+		if err := s.renewPresence(ctx, lease); err != nil {
+			if status.Code(err) == codes.NotFound {
+				s.log.Error("This node is no longer a member of the mesh, it must rejoin", slog.String("error", err.Error()))
+			} else {
+				s.log.Warn("Failed to renew presence lease", slog.String("error", err.Error()))
+			}
+		}
+		if s.storage.Consensus().IsLeader() {
+			if leaderSince.IsZero() {
+				leaderSince = time.Now()
+			}
+			if s.opts.PresencePurgeAfter > 0 && time.Since(leaderSince) >= lease.TTL {
+				s.purgeOfflineMembers(ctx)
+			}
+		} else {
+			leaderSince = time.Time{}
+		}
+		cancel()
+		select {
+		case <-s.closec:
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// renewPresence renews the presence lease of this node. Storage members write it
+// directly, other members ask the leader to.
+func (s *meshStore) renewPresence(ctx context.Context, lease types.Presence) error {
+	if s.storage.Consensus().IsMember() {
+		if raft, ok := s.storage.(*raftstorage.Provider); ok {
+			lease.AppliedIndex = raft.AppliedIndex()
+		}
+		lease.LastSeen = time.Now().UTC()
+		return storagepresence.New(s.storage.MeshStorage()).Put(ctx, lease)
+	}
+	c, err := s.DialLeader(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	return presence.Renew(ctx, c, lease)
+}
+
+// purgeOfflineMembers removes members whose presence lease expired longer than
+// the purge grace period ago. Storage providers are left to the consensus
+// heartbeats.
+func (s *meshStore) purgeOfflineMembers(ctx context.Context) {
+	store := storagepresence.New(s.storage.MeshStorage())
+	leases, err := store.List(ctx)
+	if err != nil {
+		s.log.Warn("Failed to list presence leases", slog.String("error", err.Error()))
+		return
+	}
+	now := time.Now()
+	for _, lease := range leases {
+		if lease.NodeID == s.ID() || !lease.Expired(now, s.opts.PresencePurgeAfter) {
+			continue
+		}
+		node, err := s.storage.MeshDB().Peers().Get(ctx, lease.NodeID)
+		if err != nil {
+			if errors.IsNodeNotFound(err) {
+				// The member already left, drop the stale lease.
+				if err := store.Delete(ctx, lease.NodeID); err != nil {
+					s.log.Warn("Failed to delete presence lease", slog.String("error", err.Error()))
+				}
+			}
+			continue
+		}
+		if node.PortFor(v1.Feature_STORAGE_PROVIDER) != 0 {
+			continue
+		}
+		log := s.log.With(slog.String("peer", lease.NodeID.String()), slog.Time("lastSeen", lease.LastSeen))
+		log.Info("Presence lease expired past the grace period, removing member")
+		if err := s.purgeMember(ctx, node); err != nil {
+			log.Warn("Failed to remove offline member", slog.String("error", err.Error()))
+			continue
+		}
+		if err := store.Delete(ctx, lease.NodeID); err != nil {
+			log.Warn("Failed to delete presence lease", slog.String("error", err.Error()))
+		}
+	}
+}
+
+// purgeMember removes a member that went away without leaving, along with its
+// routes, addresses, and locks.
+func (s *meshStore) purgeMember(ctx context.Context, node types.MeshNode) error {
+	id := types.NodeID(node.GetId())
+	nw := s.storage.MeshDB().Networking()
+	routes, err := nw.GetRoutesByNode(ctx, id)
+	if err != nil {
+		return err
+	}
+	for _, route := range routes {
+		if err := nw.DeleteRoute(ctx, route.GetName()); err != nil {
+			return err
+		}
+	}
+	if err := s.storage.MeshDB().Peers().Delete(ctx, id); err != nil {
+		return err
+	}
+	for _, addr := range []string{node.GetPrivateIPv4(), node.GetPrivateIPv6()} {
+		if addr == "" {
+			continue
+		}
+		err := s.plugins.ReleaseIP(ctx, &v1.ReleaseIPRequest{NodeID: id.String(), Ip: addr})
+		if err != nil {
+			s.log.Warn("Failed to release address of removed member", slog.String("peer", id.String()), slog.String("error", err.Error()))
+		}
+	}
+	s.releaseLocks(ctx, id)
+	return nil
+}
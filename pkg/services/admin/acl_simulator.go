@@ -0,0 +1,180 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package admin provides the admin gRPC server.
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/netip"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+
+	"github.com/webmeshproj/webmesh/pkg/common"
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/meshnet"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// ACLServiceName is the fully qualified name of the network ACL simulation service.
+const ACLServiceName = "v1.AdminNetworkACLs"
+
+const (
+	// TestNetworkACLsFullMethodName is the full method name for TestNetworkACLs.
+	TestNetworkACLsFullMethodName = "/" + ACLServiceName + "/Test"
+	// NetworkACLMatrixFullMethodName is the full method name for NetworkACLMatrix.
+	NetworkACLMatrixFullMethodName = "/" + ACLServiceName + "/Matrix"
+)
+
+// AdminNetworkACLsServer is the server API for simulating connectivity against
+// the network ACLs. Requests and verdicts are exchanged as JSON.
+type AdminNetworkACLsServer interface {
+	// TestNetworkACLs returns the JSON encoded meshnet.ConnectivityVerdict
+	// for the JSON encoded ACLSimulationRequest.
+	TestNetworkACLs(context.Context, *wrapperspb.BytesValue) (*wrapperspb.BytesValue, error)
+	// NetworkACLMatrix returns the JSON encoded meshnet.ConnectivityVerdict
+	// list for every pair of nodes. The source, destination and CIDRs of the
+	// JSON encoded ACLSimulationRequest are ignored.
+	NetworkACLMatrix(context.Context, *wrapperspb.BytesValue) (*wrapperspb.BytesValue, error)
+}
+
+// ACLSimulationRequest is a request to simulate connectivity against the
+// network ACLs.
+type ACLSimulationRequest struct {
+	// Source is the node the traffic originates from.
+	Source string `json:"source,omitempty"`
+	// Destination is the node the traffic is sent to.
+	Destination string `json:"destination,omitempty"`
+	// CIDRs are destination prefixes to check instead of the addresses and
+	// routes of the destination node.
+	CIDRs []string `json:"cidrs,omitempty"`
+	// Proposed are protobuf JSON encoded network ACLs that replace the stored
+	// ACLs with the same names, or are added to them, for the simulation.
+	Proposed []json.RawMessage `json:"proposed,omitempty"`
+}
+
+// AddProposed adds a proposed network ACL to the request.
+func (r *ACLSimulationRequest) AddProposed(acl *v1.NetworkACL) error {
+	data, err := protojson.Marshal(acl)
+	if err != nil {
+		return fmt.Errorf("marshal network acl: %w", err)
+	}
+	r.Proposed = append(r.Proposed, data)
+	return nil
+}
+
+// ACLServiceDesc is the grpc.ServiceDesc for the network ACL simulation service.
+var ACLServiceDesc = grpc.ServiceDesc{
+	ServiceName: ACLServiceName,
+	HandlerType: (*AdminNetworkACLsServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{MethodName: "Test", Handler: testNetworkACLsHandler},
+		{MethodName: "Matrix", Handler: networkACLMatrixHandler},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "v1/admin_network_acls.proto",
+}
+
+// RegisterAdminNetworkACLsServer registers the network ACL simulation service with the given registrar.
+func RegisterAdminNetworkACLsServer(s grpc.ServiceRegistrar, srv AdminNetworkACLsServer) error {
+	err := common.RegisterServiceFile(&ACLServiceDesc,
+		common.ServiceMethod{Name: "Test", Input: &wrapperspb.BytesValue{}, Output: &wrapperspb.BytesValue{}},
+		common.ServiceMethod{Name: "Matrix", Input: &wrapperspb.BytesValue{}, Output: &wrapperspb.BytesValue{}},
+	)
+	if err != nil {
+		return err
+	}
+	s.RegisterService(&ACLServiceDesc, srv)
+	return nil
+}
+
+func testNetworkACLsHandler(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+	in := new(wrapperspb.BytesValue)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	handler := func(ctx context.Context, req any) (any, error) {
+		return srv.(AdminNetworkACLsServer).TestNetworkACLs(ctx, req.(*wrapperspb.BytesValue))
+	}
+	if interceptor == nil {
+		return handler(ctx, in)
+	}
+	return interceptor(ctx, in, &grpc.UnaryServerInfo{Server: srv, FullMethod: TestNetworkACLsFullMethodName}, handler)
+}
+
+func networkACLMatrixHandler(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+	in := new(wrapperspb.BytesValue)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	handler := func(ctx context.Context, req any) (any, error) {
+		return srv.(AdminNetworkACLsServer).NetworkACLMatrix(ctx, req.(*wrapperspb.BytesValue))
+	}
+	if interceptor == nil {
+		return handler(ctx, in)
+	}
+	return interceptor(ctx, in, &grpc.UnaryServerInfo{Server: srv, FullMethod: NetworkACLMatrixFullMethodName}, handler)
+}
+
+// TestNetworkACLs returns the verdict for the connectivity in the request from
+// the node at the other end of the given connection.
+func TestNetworkACLs(ctx context.Context, cc grpc.ClientConnInterface, req ACLSimulationRequest, opts ...grpc.CallOption) (meshnet.ConnectivityVerdict, error) {
+	var verdict meshnet.ConnectivityVerdict
+	err := invokeJSON(ctx, cc, TestNetworkACLsFullMethodName, req, &verdict, opts...)
+	return verdict, err
+}
+
+// NetworkACLMatrix returns the verdicts for every pair of nodes from the node
+// at the other end of the given connection.
+func NetworkACLMatrix(ctx context.Context, cc grpc.ClientConnInterface, req ACLSimulationRequest, opts ...grpc.CallOption) ([]meshnet.ConnectivityVerdict, error) {
+	var verdicts []meshnet.ConnectivityVerdict
+	err := invokeJSON(ctx, cc, NetworkACLMatrixFullMethodName, req, &verdicts, opts...)
+	return verdicts, err
+}
+
+// decodeACLSimulationRequest decodes the request and its proposed ACLs and CIDRs.
+func decodeACLSimulationRequest(in *wrapperspb.BytesValue) (ACLSimulationRequest, []types.NetworkACL, []netip.Prefix, error) {
+	var req ACLSimulationRequest
+	if err := json.Unmarshal(in.GetValue(), &req); err != nil {
+		return req, nil, nil, fmt.Errorf("invalid request: %w", err)
+	}
+	proposed := make([]types.NetworkACL, len(req.Proposed))
+	for i, data := range req.Proposed {
+		if err := proposed[i].UnmarshalProtoJSON(data); err != nil {
+			return req, nil, nil, fmt.Errorf("invalid proposed network acl: %w", err)
+		}
+		if err := proposed[i].Validate(); err != nil {
+			return req, nil, nil, fmt.Errorf("invalid proposed network acl %q: %w", proposed[i].GetName(), err)
+		}
+	}
+	prefixes := make([]netip.Prefix, len(req.CIDRs))
+	for i, cidr := range req.CIDRs {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			addr, aerr := netip.ParseAddr(cidr)
+			if aerr != nil {
+				return req, nil, nil, fmt.Errorf("invalid cidr %q: %w", cidr, err)
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		prefixes[i] = prefix
+	}
+	return req, proposed, prefixes, nil
+}
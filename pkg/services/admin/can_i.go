@@ -0,0 +1,53 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package admin provides the admin gRPC server.
+package admin
+
+import (
+	"encoding/json"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/services/rbac"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+func (s *Server) CanI(ctx context.Context, in *wrapperspb.BytesValue) (*wrapperspb.BytesValue, error) {
+	req, action, err := decodeRBACReviewRequest(in)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	subject := req.Subject
+	if subject == "" {
+		subject, err = rbac.CallerFrom(ctx)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, "subject is required when the caller is not authenticated")
+		}
+	}
+	decision, err := rbac.Explain(ctx, s.db.RBAC(), types.NodeID(subject), action)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	data, err := json.Marshal(decision)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return wrapperspb.Bytes(data), nil
+}
@@ -0,0 +1,145 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+
+	"github.com/webmeshproj/webmesh/pkg/services/rbac"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+)
+
+func newRBACReviewBytes(t *testing.T, req RBACReviewRequest) *wrapperspb.BytesValue {
+	t.Helper()
+	data, err := json.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return wrapperspb.Bytes(data)
+}
+
+// putDanglingRoleBinding binds the node with the given ID to a role that is
+// deleted afterwards.
+func putDanglingRoleBinding(t *testing.T, server *Server, nodeID string) {
+	t.Helper()
+	ctx := context.Background()
+	_, err := server.PutRole(ctx, &v1.Role{
+		Name: "deleted-role",
+		Rules: []*v1.Rule{{
+			Resources: []v1.RuleResource{v1.RuleResource_RESOURCE_ALL},
+			Verbs:     []v1.RuleVerb{v1.RuleVerb_VERB_ALL},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = server.PutRoleBinding(ctx, &v1.RoleBinding{
+		Name:     "dangling",
+		Role:     "deleted-role",
+		Subjects: []*v1.Subject{{Name: nodeID, Type: v1.SubjectType_SUBJECT_NODE}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.DeleteRole(ctx, &v1.Role{Name: "deleted-role"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCanI(t *testing.T) {
+	t.Parallel()
+
+	server := newTestServer(t)
+
+	tc := []testCase[wrapperspb.BytesValue]{
+		{
+			name: "invalid verb",
+			req:  newRBACReviewBytes(t, RBACReviewRequest{Subject: "admin", Verb: "patch", Resource: "routes"}),
+			code: codes.InvalidArgument,
+		},
+		{
+			name: "invalid resource",
+			req:  newRBACReviewBytes(t, RBACReviewRequest{Subject: "admin", Verb: "put", Resource: "widgets"}),
+			code: codes.InvalidArgument,
+		},
+		{
+			name: "no subject or caller",
+			req:  newRBACReviewBytes(t, RBACReviewRequest{Verb: "put", Resource: "routes"}),
+			code: codes.InvalidArgument,
+		},
+		{
+			name: "valid request",
+			req:  newRBACReviewBytes(t, RBACReviewRequest{Subject: "admin", Verb: "put", Resource: "routes"}),
+			code: codes.OK,
+		},
+	}
+
+	runTestCases(t, tc, server.CanI)
+
+	canI := func(t *testing.T, req RBACReviewRequest) rbac.Decision {
+		t.Helper()
+		resp, err := server.CanI(context.Background(), newRBACReviewBytes(t, req))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		var decision rbac.Decision
+		if err := json.Unmarshal(resp.GetValue(), &decision); err != nil {
+			t.Fatalf("unmarshal decision: %v", err)
+		}
+		return decision
+	}
+
+	t.Run("admin is granted by the admin rolebinding", func(t *testing.T) {
+		decision := canI(t, RBACReviewRequest{Subject: "admin", Verb: "put", Resource: "routes", ResourceName: "my-route"})
+		if !decision.Allowed {
+			t.Fatalf("expected admin to be allowed: %s", decision.Reason)
+		}
+		if decision.Grant == nil || decision.Grant.RoleBinding != string(storage.MeshAdminRoleBinding) {
+			t.Fatalf("expected grant from the admin rolebinding, got %+v", decision.Grant)
+		}
+		if decision.SubjectType != "node" {
+			t.Fatalf("expected node subject type, got %q", decision.SubjectType)
+		}
+	})
+
+	t.Run("unbound node is denied", func(t *testing.T) {
+		decision := canI(t, RBACReviewRequest{Subject: "stranger", Verb: "put", Resource: "routes"})
+		if decision.Allowed {
+			t.Fatal("expected unbound node to be denied")
+		}
+		if decision.Grant != nil || len(decision.RoleBindings) != 0 {
+			t.Fatalf("expected no grant or rolebindings, got %+v", decision)
+		}
+	})
+
+	t.Run("rolebinding to a missing role denies", func(t *testing.T) {
+		putDanglingRoleBinding(t, server, "admin")
+		decision := canI(t, RBACReviewRequest{Subject: "admin", Verb: "put", Resource: "routes"})
+		if decision.Allowed {
+			t.Fatal("expected admin to be denied like the store evaluator")
+		}
+		if len(decision.RoleBindings) != 1 || decision.RoleBindings[0] != "dangling" {
+			t.Fatalf("expected the dangling rolebinding in the decision, got %+v", decision)
+		}
+	})
+}
@@ -0,0 +1,171 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package admin provides the admin gRPC server.
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+
+	"github.com/webmeshproj/webmesh/pkg/common"
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/services/rbac"
+)
+
+// RBACServiceName is the fully qualified name of the RBAC review service.
+const RBACServiceName = "v1.AdminRBAC"
+
+const (
+	// CanIFullMethodName is the full method name for CanI.
+	CanIFullMethodName = "/" + RBACServiceName + "/CanI"
+	// WhoCanFullMethodName is the full method name for WhoCan.
+	WhoCanFullMethodName = "/" + RBACServiceName + "/WhoCan"
+)
+
+// AdminRBACServer is the server API for reviewing access against the stored
+// roles and rolebindings. Requests and decisions are exchanged as JSON.
+type AdminRBACServer interface {
+	// CanI returns the JSON encoded rbac.Decision for the JSON encoded
+	// RBACReviewRequest.
+	CanI(context.Context, *wrapperspb.BytesValue) (*wrapperspb.BytesValue, error)
+	// WhoCan returns the JSON encoded rbac.Decision list of subjects allowed
+	// to perform the action in the JSON encoded RBACReviewRequest.
+	WhoCan(context.Context, *wrapperspb.BytesValue) (*wrapperspb.BytesValue, error)
+}
+
+// RBACReviewRequest is a request to review an action against the stored
+// roles and rolebindings.
+type RBACReviewRequest struct {
+	// Subject is the node or user to evaluate the action for. It defaults
+	// to the caller and is ignored by WhoCan.
+	Subject string `json:"subject,omitempty"`
+	// Verb is the verb of the action, such as "put".
+	Verb string `json:"verb"`
+	// Resource is the resource of the action, such as "routes".
+	Resource string `json:"resource"`
+	// ResourceName is the optional name of the resource.
+	ResourceName string `json:"resourceName,omitempty"`
+}
+
+// Action returns the rbac.Action for the request.
+func (r RBACReviewRequest) Action() (*rbac.Action, error) {
+	verb, err := rbac.ParseVerb(r.Verb)
+	if err != nil {
+		return nil, err
+	}
+	resource, err := rbac.ParseResource(r.Resource)
+	if err != nil {
+		return nil, err
+	}
+	return &rbac.Action{Verb: verb, Resource: resource, ResourceName: r.ResourceName}, nil
+}
+
+// RBACServiceDesc is the grpc.ServiceDesc for the RBAC review service.
+var RBACServiceDesc = grpc.ServiceDesc{
+	ServiceName: RBACServiceName,
+	HandlerType: (*AdminRBACServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{MethodName: "CanI", Handler: canIHandler},
+		{MethodName: "WhoCan", Handler: whoCanHandler},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "v1/admin_rbac.proto",
+}
+
+// RegisterAdminRBACServer registers the RBAC review service with the given registrar.
+func RegisterAdminRBACServer(s grpc.ServiceRegistrar, srv AdminRBACServer) error {
+	err := common.RegisterServiceFile(&RBACServiceDesc,
+		common.ServiceMethod{Name: "CanI", Input: &wrapperspb.BytesValue{}, Output: &wrapperspb.BytesValue{}},
+		common.ServiceMethod{Name: "WhoCan", Input: &wrapperspb.BytesValue{}, Output: &wrapperspb.BytesValue{}},
+	)
+	if err != nil {
+		return err
+	}
+	s.RegisterService(&RBACServiceDesc, srv)
+	return nil
+}
+
+func canIHandler(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+	in := new(wrapperspb.BytesValue)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	handler := func(ctx context.Context, req any) (any, error) {
+		return srv.(AdminRBACServer).CanI(ctx, req.(*wrapperspb.BytesValue))
+	}
+	if interceptor == nil {
+		return handler(ctx, in)
+	}
+	return interceptor(ctx, in, &grpc.UnaryServerInfo{Server: srv, FullMethod: CanIFullMethodName}, handler)
+}
+
+func whoCanHandler(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+	in := new(wrapperspb.BytesValue)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	handler := func(ctx context.Context, req any) (any, error) {
+		return srv.(AdminRBACServer).WhoCan(ctx, req.(*wrapperspb.BytesValue))
+	}
+	if interceptor == nil {
+		return handler(ctx, in)
+	}
+	return interceptor(ctx, in, &grpc.UnaryServerInfo{Server: srv, FullMethod: WhoCanFullMethodName}, handler)
+}
+
+// CanI evaluates the action in the request for its subject on the node at the
+// other end of the given connection.
+func CanI(ctx context.Context, cc grpc.ClientConnInterface, req RBACReviewRequest, opts ...grpc.CallOption) (rbac.Decision, error) {
+	var decision rbac.Decision
+	err := invokeJSON(ctx, cc, CanIFullMethodName, req, &decision, opts...)
+	return decision, err
+}
+
+// WhoCan returns the subjects allowed to perform the action in the request
+// from the node at the other end of the given connection.
+func WhoCan(ctx context.Context, cc grpc.ClientConnInterface, req RBACReviewRequest, opts ...grpc.CallOption) ([]rbac.Decision, error) {
+	var decisions []rbac.Decision
+	err := invokeJSON(ctx, cc, WhoCanFullMethodName, req, &decisions, opts...)
+	return decisions, err
+}
+
+// invokeJSON invokes a method that exchanges the JSON encoded request and
+// response in BytesValues.
+func invokeJSON(ctx context.Context, cc grpc.ClientConnInterface, method string, req, resp any, opts ...grpc.CallOption) error {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("marshal request: %w", err)
+	}
+	out := new(wrapperspb.BytesValue)
+	if err := cc.Invoke(ctx, method, wrapperspb.Bytes(data), out, opts...); err != nil {
+		return err
+	}
+	return json.Unmarshal(out.GetValue(), resp)
+}
+
+// decodeRBACReviewRequest decodes the request and its action.
+func decodeRBACReviewRequest(in *wrapperspb.BytesValue) (RBACReviewRequest, *rbac.Action, error) {
+	var req RBACReviewRequest
+	if err := json.Unmarshal(in.GetValue(), &req); err != nil {
+		return req, nil, fmt.Errorf("invalid request: %w", err)
+	}
+	action, err := req.Action()
+	return req, action, err
+}
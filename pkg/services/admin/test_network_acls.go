@@ -0,0 +1,58 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package admin provides the admin gRPC server.
+package admin
+
+import (
+	"encoding/json"
+
+	"github.com/dominikbraun/graph"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/meshnet"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+func (s *Server) TestNetworkACLs(ctx context.Context, in *wrapperspb.BytesValue) (*wrapperspb.BytesValue, error) {
+	req, proposed, prefixes, err := decodeACLSimulationRequest(in)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if req.Source == "" || req.Destination == "" {
+		return nil, status.Error(codes.InvalidArgument, "source and destination are required")
+	}
+	sim, err := meshnet.NewACLSimulator(ctx, s.db, proposed...)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	verdict, err := sim.Test(ctx, types.NodeID(req.Source), types.NodeID(req.Destination), prefixes)
+	if err != nil {
+		if errors.Is(err, graph.ErrVertexNotFound) {
+			return nil, status.Error(codes.NotFound, err.Error())
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	data, err := json.Marshal(verdict)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return wrapperspb.Bytes(data), nil
+}
@@ -0,0 +1,89 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+
+	"github.com/webmeshproj/webmesh/pkg/services/rbac"
+)
+
+func TestWhoCan(t *testing.T) {
+	t.Parallel()
+
+	server := newTestServer(t)
+
+	tc := []testCase[wrapperspb.BytesValue]{
+		{
+			name: "invalid request",
+			req:  wrapperspb.Bytes([]byte("not json")),
+			code: codes.InvalidArgument,
+		},
+		{
+			name: "valid request",
+			req:  newRBACReviewBytes(t, RBACReviewRequest{Verb: "put", Resource: "roles"}),
+			code: codes.OK,
+		},
+	}
+
+	runTestCases(t, tc, server.WhoCan)
+
+	t.Run("admin can put roles", func(t *testing.T) {
+		resp, err := server.WhoCan(context.Background(), newRBACReviewBytes(t, RBACReviewRequest{Verb: "put", Resource: "roles"}))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		var decisions []rbac.Decision
+		if err := json.Unmarshal(resp.GetValue(), &decisions); err != nil {
+			t.Fatalf("unmarshal decisions: %v", err)
+		}
+		var found bool
+		for _, decision := range decisions {
+			if decision.Subject == "admin" && decision.Grant != nil {
+				found = true
+			}
+			if decision.SubjectType == "group" {
+				t.Errorf("expected group subjects to be skipped, got %+v", decision)
+			}
+		}
+		if !found {
+			t.Fatalf("expected admin in who-can results, got %+v", decisions)
+		}
+	})
+
+	t.Run("subjects bound to missing roles are left out", func(t *testing.T) {
+		putDanglingRoleBinding(t, server, "admin")
+		resp, err := server.WhoCan(context.Background(), newRBACReviewBytes(t, RBACReviewRequest{Verb: "put", Resource: "roles"}))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		var decisions []rbac.Decision
+		if err := json.Unmarshal(resp.GetValue(), &decisions); err != nil {
+			t.Fatalf("unmarshal decisions: %v", err)
+		}
+		for _, decision := range decisions {
+			if decision.Subject == "admin" {
+				t.Fatalf("expected admin to be left out, got %+v", decision)
+			}
+		}
+	})
+}
@@ -0,0 +1,146 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit
+
+import (
+	"log/slog"
+	"strings"
+	"time"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/grpc"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/services/leaderproxy"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/audit"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// AuditedMethods maps the methods recorded in the audit log to the kind of
+// resource they mutate.
+var AuditedMethods = map[string]string{
+	// Admin API
+	v1.Admin_PutRole_FullMethodName:           "roles",
+	v1.Admin_DeleteRole_FullMethodName:        "roles",
+	v1.Admin_PutRoleBinding_FullMethodName:    "rolebindings",
+	v1.Admin_DeleteRoleBinding_FullMethodName: "rolebindings",
+	v1.Admin_PutGroup_FullMethodName:          "groups",
+	v1.Admin_DeleteGroup_FullMethodName:       "groups",
+	v1.Admin_PutNetworkACL_FullMethodName:     "networkacls",
+	v1.Admin_DeleteNetworkACL_FullMethodName:  "networkacls",
+	v1.Admin_PutRoute_FullMethodName:          "routes",
+	v1.Admin_DeleteRoute_FullMethodName:       "routes",
+	v1.Admin_PutEdge_FullMethodName:           "edges",
+	v1.Admin_DeleteEdge_FullMethodName:        "edges",
+
+	// Membership API
+	v1.Membership_Join_FullMethodName:   "nodes",
+	v1.Membership_Update_FullMethodName: "nodes",
+	v1.Membership_Leave_FullMethodName:  "nodes",
+
+	// Storage API
+	v1.StorageQueryService_Publish_FullMethodName: "kv",
+}
+
+// recordTimeout is how long to wait for an entry to be written to the log.
+const recordTimeout = 10 * time.Second
+
+// Interceptor records the mutations handled by this node in the audit log.
+// It must come after the leader proxy in the chain, so that requests are
+// recorded by the node that handles them.
+type Interceptor struct {
+	nodeID types.NodeID
+	store  *audit.Store
+	log    *slog.Logger
+}
+
+// NewInterceptor returns a new audit interceptor writing entries that expire
+// after the given retention.
+func NewInterceptor(ctx context.Context, nodeID types.NodeID, st storage.MeshStorage, retention time.Duration) *Interceptor {
+	return &Interceptor{
+		nodeID: nodeID,
+		store:  audit.New(st, retention),
+		log:    context.LoggerFrom(ctx).With("component", "audit"),
+	}
+}
+
+// UnaryInterceptor returns a gRPC unary interceptor that records audited
+// methods in the audit log.
+func (i *Interceptor) UnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		kind, ok := AuditedMethods[info.FullMethod]
+		if !ok {
+			return handler(ctx, req)
+		}
+		rctx, rec := audit.ContextWithRecorder(ctx)
+		resp, err := handler(rctx, req)
+		changes := rec.Changes()
+		if len(changes) == 0 {
+			// Nothing was changed, including by calls that failed early.
+			return resp, err
+		}
+		entry := types.AuditEntry{
+			Time:     time.Now().UTC(),
+			Node:     i.nodeID,
+			Method:   info.FullMethod,
+			Resource: resourceName(kind, req),
+			Changes:  changes,
+		}
+		entry.Caller, _ = context.AuthenticatedCallerFrom(ctx)
+		entry.ProxiedFor, _ = leaderproxy.ProxiedFor(ctx)
+		if err != nil {
+			entry.Error = err.Error()
+		}
+		// The mutation is done, so record it in the background even if the
+		// caller goes away.
+		go i.record(entry)
+		return resp, err
+	}
+}
+
+func (i *Interceptor) record(entry types.AuditEntry) {
+	ctx, cancel := context.WithTimeout(context.WithLogger(context.Background(), i.log), recordTimeout)
+	defer cancel()
+	if err := i.store.Put(ctx, entry); err != nil {
+		i.log.Error("Failed to record audit entry",
+			slog.String("method", entry.Method),
+			slog.String("resource", entry.Resource),
+			slog.String("error", err.Error()),
+		)
+	}
+}
+
+// resourceName returns the name of the resource targeted by the request.
+func resourceName(kind string, req any) string {
+	var name string
+	switch r := req.(type) {
+	case *v1.MeshEdge:
+		name = r.GetSource() + "/" + r.GetTarget()
+	case *v1.PublishRequest:
+		name = string(r.GetKey())
+	case interface{ GetName() string }:
+		name = r.GetName()
+	case interface{ GetId() string }:
+		name = r.GetId()
+	}
+	name = strings.TrimPrefix(name, "/")
+	if name == "" {
+		return kind
+	}
+	return kind + "/" + name
+}
@@ -0,0 +1,168 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package audit provides the server for querying the audit log of mesh
+// mutations and the interceptor that records them.
+package audit
+
+import (
+	"encoding/json"
+	"log/slog"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+
+	"github.com/webmeshproj/webmesh/pkg/common"
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/meshnet"
+	"github.com/webmeshproj/webmesh/pkg/services/rbac"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/audit"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// ServiceName is the fully qualified name of the audit service.
+const ServiceName = "v1.MeshAudit"
+
+// ListFullMethodName is the full method name for List.
+const ListFullMethodName = "/" + ServiceName + "/List"
+
+// MeshAuditServer is the server API for the audit log. Filters and entries
+// are exchanged as JSON.
+type MeshAuditServer interface {
+	// List returns the JSON encoded types.AuditEntry list selected by the
+	// JSON encoded audit.Filter.
+	List(context.Context, *wrapperspb.BytesValue) (*wrapperspb.BytesValue, error)
+}
+
+// ServiceDesc is the grpc.ServiceDesc for the audit service.
+var ServiceDesc = grpc.ServiceDesc{
+	ServiceName: ServiceName,
+	HandlerType: (*MeshAuditServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{MethodName: "List", Handler: listHandler},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "v1/audit.proto",
+}
+
+// RegisterMeshAuditServer registers the audit service with the given registrar.
+func RegisterMeshAuditServer(s grpc.ServiceRegistrar, srv MeshAuditServer) error {
+	err := common.RegisterServiceFile(&ServiceDesc,
+		common.ServiceMethod{Name: "List", Input: &wrapperspb.BytesValue{}, Output: &wrapperspb.BytesValue{}},
+	)
+	if err != nil {
+		return err
+	}
+	s.RegisterService(&ServiceDesc, srv)
+	return nil
+}
+
+func listHandler(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+	in := new(wrapperspb.BytesValue)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	handler := func(ctx context.Context, req any) (any, error) {
+		return srv.(MeshAuditServer).List(ctx, req.(*wrapperspb.BytesValue))
+	}
+	if interceptor == nil {
+		return handler(ctx, in)
+	}
+	return interceptor(ctx, in, &grpc.UnaryServerInfo{Server: srv, FullMethod: ListFullMethodName}, handler)
+}
+
+// List returns the audit entries selected by the filter from the node at the
+// other end of the given connection.
+func List(ctx context.Context, cc grpc.ClientConnInterface, f audit.Filter, opts ...grpc.CallOption) ([]types.AuditEntry, error) {
+	data, err := json.Marshal(f)
+	if err != nil {
+		return nil, err
+	}
+	out := new(wrapperspb.BytesValue)
+	if err := cc.Invoke(ctx, ListFullMethodName, wrapperspb.Bytes(data), out, opts...); err != nil {
+		return nil, err
+	}
+	var entries []types.AuditEntry
+	err = json.Unmarshal(out.GetValue(), &entries)
+	return entries, err
+}
+
+// canListAction is the action required to read the audit log. The log holds
+// the changes made to every resource, so reading it requires access to all
+// of them.
+var canListAction = rbac.Actions{
+	{
+		Verb:     v1.RuleVerb_VERB_GET,
+		Resource: v1.RuleResource_RESOURCE_ALL,
+	},
+}
+
+// Server is the audit server.
+type Server struct {
+	store *audit.Store
+	rbac  rbac.Evaluator
+	mnet  meshnet.Manager
+	log   *slog.Logger
+}
+
+// NewServer returns a new audit Server.
+func NewServer(ctx context.Context, storage storage.Provider, rbac rbac.Evaluator, mnet meshnet.Manager) *Server {
+	return &Server{
+		store: audit.New(storage.MeshStorage(), 0),
+		rbac:  rbac,
+		mnet:  mnet,
+		log:   context.LoggerFrom(ctx).With("component", "audit-server"),
+	}
+}
+
+// List implements MeshAuditServer.
+func (s *Server) List(ctx context.Context, req *wrapperspb.BytesValue) (*wrapperspb.BytesValue, error) {
+	if !context.IsInNetwork(ctx, s.mnet) {
+		addr, _ := context.PeerAddrFrom(ctx)
+		s.log.Warn("Received audit request from out of network", slog.String("peer", addr.String()))
+		return nil, status.Errorf(codes.PermissionDenied, "request is not in-network")
+	}
+	allowed, err := s.rbac.Evaluate(ctx, canListAction)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to evaluate audit permissions: %v", err)
+	}
+	if !allowed {
+		s.log.Warn("caller not allowed to read the audit log")
+		return nil, status.Error(codes.PermissionDenied, "not allowed")
+	}
+	var f audit.Filter
+	if len(req.GetValue()) > 0 {
+		if err := json.Unmarshal(req.GetValue(), &f); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid filter: %v", err)
+		}
+	}
+	entries, err := s.store.List(ctx, f)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if entries == nil {
+		entries = []types.AuditEntry{}
+	}
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return wrapperspb.Bytes(data), nil
+}
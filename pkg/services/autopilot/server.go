@@ -0,0 +1,123 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package autopilot provides the server for inspecting the raft autopilot.
+package autopilot
+
+import (
+	"encoding/json"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+
+	"github.com/webmeshproj/webmesh/pkg/common"
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/autopilot"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// ServiceName is the fully qualified name of the autopilot service.
+const ServiceName = "v1.MeshAutopilot"
+
+// GetStateFullMethodName is the full method name for GetState.
+const GetStateFullMethodName = "/" + ServiceName + "/GetState"
+
+// MeshAutopilotServer is the server API for the raft autopilot. The state
+// is exchanged as JSON.
+type MeshAutopilotServer interface {
+	// GetState returns the JSON encoded types.AutopilotState last recorded
+	// by the leader.
+	GetState(context.Context, *emptypb.Empty) (*wrapperspb.BytesValue, error)
+}
+
+// ServiceDesc is the grpc.ServiceDesc for the autopilot service.
+var ServiceDesc = grpc.ServiceDesc{
+	ServiceName: ServiceName,
+	HandlerType: (*MeshAutopilotServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{MethodName: "GetState", Handler: getStateHandler},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "v1/autopilot.proto",
+}
+
+// RegisterMeshAutopilotServer registers the autopilot service with the given registrar.
+func RegisterMeshAutopilotServer(s grpc.ServiceRegistrar, srv MeshAutopilotServer) error {
+	err := common.RegisterServiceFile(&ServiceDesc,
+		common.ServiceMethod{Name: "GetState", Input: &emptypb.Empty{}, Output: &wrapperspb.BytesValue{}},
+	)
+	if err != nil {
+		return err
+	}
+	s.RegisterService(&ServiceDesc, srv)
+	return nil
+}
+
+func getStateHandler(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+	in := new(emptypb.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	handler := func(ctx context.Context, req any) (any, error) {
+		return srv.(MeshAutopilotServer).GetState(ctx, req.(*emptypb.Empty))
+	}
+	if interceptor == nil {
+		return handler(ctx, in)
+	}
+	return interceptor(ctx, in, &grpc.UnaryServerInfo{Server: srv, FullMethod: GetStateFullMethodName}, handler)
+}
+
+// GetState returns the autopilot state from the node at the other end of the given connection.
+func GetState(ctx context.Context, cc grpc.ClientConnInterface, opts ...grpc.CallOption) (types.AutopilotState, error) {
+	var state types.AutopilotState
+	out := new(wrapperspb.BytesValue)
+	if err := cc.Invoke(ctx, GetStateFullMethodName, new(emptypb.Empty), out, opts...); err != nil {
+		return state, err
+	}
+	err := json.Unmarshal(out.GetValue(), &state)
+	return state, err
+}
+
+// Server is the autopilot server.
+type Server struct {
+	store *autopilot.Store
+}
+
+// NewServer returns a new autopilot Server.
+func NewServer(storage storage.Provider) *Server {
+	return &Server{store: autopilot.New(storage.MeshStorage())}
+}
+
+// GetState implements MeshAutopilotServer.
+func (s *Server) GetState(ctx context.Context, _ *emptypb.Empty) (*wrapperspb.BytesValue, error) {
+	state, err := s.store.Get(ctx)
+	if err != nil {
+		if errors.Is(err, errors.ErrAutopilotNotRunning) {
+			return nil, status.Error(codes.NotFound, err.Error())
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return wrapperspb.Bytes(data), nil
+}
@@ -0,0 +1,346 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package dnsrecords provides the server for managing custom DNS records.
+package dnsrecords
+
+import (
+	"encoding/json"
+	"log/slog"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+
+	"github.com/webmeshproj/webmesh/pkg/common"
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/services/rbac"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/dnsrecords"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// ServiceName is the fully qualified name of the DNS records service.
+const ServiceName = "v1.MeshDNSRecords"
+
+const (
+	// PutFullMethodName is the full method name for Put.
+	PutFullMethodName = "/" + ServiceName + "/Put"
+	// GetFullMethodName is the full method name for Get.
+	GetFullMethodName = "/" + ServiceName + "/Get"
+	// DeleteFullMethodName is the full method name for Delete.
+	DeleteFullMethodName = "/" + ServiceName + "/Delete"
+	// ListFullMethodName is the full method name for List.
+	ListFullMethodName = "/" + ServiceName + "/List"
+)
+
+// MeshDNSRecordsServer is the server API for custom DNS records. Records and
+// references to them are exchanged as JSON.
+type MeshDNSRecordsServer interface {
+	// Put creates or updates the JSON encoded types.DNSRecord and returns
+	// the record as it was stored.
+	Put(context.Context, *wrapperspb.BytesValue) (*wrapperspb.BytesValue, error)
+	// Get returns the JSON encoded types.DNSRecord for the JSON encoded
+	// types.DNSRecordRef.
+	Get(context.Context, *wrapperspb.BytesValue) (*wrapperspb.BytesValue, error)
+	// Delete removes the record for the JSON encoded types.DNSRecordRef.
+	Delete(context.Context, *wrapperspb.BytesValue) (*emptypb.Empty, error)
+	// List returns the JSON encoded types.DNSRecords the caller can read.
+	List(context.Context, *emptypb.Empty) (*wrapperspb.BytesValue, error)
+}
+
+// ServiceDesc is the grpc.ServiceDesc for the DNS records service.
+var ServiceDesc = grpc.ServiceDesc{
+	ServiceName: ServiceName,
+	HandlerType: (*MeshDNSRecordsServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{MethodName: "Put", Handler: putHandler},
+		{MethodName: "Get", Handler: getHandler},
+		{MethodName: "Delete", Handler: deleteHandler},
+		{MethodName: "List", Handler: listHandler},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "v1/dnsrecords.proto",
+}
+
+// RegisterMeshDNSRecordsServer registers the DNS records service with the given registrar.
+func RegisterMeshDNSRecordsServer(s grpc.ServiceRegistrar, srv MeshDNSRecordsServer) error {
+	err := common.RegisterServiceFile(&ServiceDesc,
+		common.ServiceMethod{Name: "Put", Input: &wrapperspb.BytesValue{}, Output: &wrapperspb.BytesValue{}},
+		common.ServiceMethod{Name: "Get", Input: &wrapperspb.BytesValue{}, Output: &wrapperspb.BytesValue{}},
+		common.ServiceMethod{Name: "Delete", Input: &wrapperspb.BytesValue{}, Output: &emptypb.Empty{}},
+		common.ServiceMethod{Name: "List", Input: &emptypb.Empty{}, Output: &wrapperspb.BytesValue{}},
+	)
+	if err != nil {
+		return err
+	}
+	s.RegisterService(&ServiceDesc, srv)
+	return nil
+}
+
+func putHandler(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+	in := new(wrapperspb.BytesValue)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	handler := func(ctx context.Context, req any) (any, error) {
+		return srv.(MeshDNSRecordsServer).Put(ctx, req.(*wrapperspb.BytesValue))
+	}
+	if interceptor == nil {
+		return handler(ctx, in)
+	}
+	return interceptor(ctx, in, &grpc.UnaryServerInfo{Server: srv, FullMethod: PutFullMethodName}, handler)
+}
+
+func getHandler(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+	in := new(wrapperspb.BytesValue)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	handler := func(ctx context.Context, req any) (any, error) {
+		return srv.(MeshDNSRecordsServer).Get(ctx, req.(*wrapperspb.BytesValue))
+	}
+	if interceptor == nil {
+		return handler(ctx, in)
+	}
+	return interceptor(ctx, in, &grpc.UnaryServerInfo{Server: srv, FullMethod: GetFullMethodName}, handler)
+}
+
+func deleteHandler(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+	in := new(wrapperspb.BytesValue)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	handler := func(ctx context.Context, req any) (any, error) {
+		return srv.(MeshDNSRecordsServer).Delete(ctx, req.(*wrapperspb.BytesValue))
+	}
+	if interceptor == nil {
+		return handler(ctx, in)
+	}
+	return interceptor(ctx, in, &grpc.UnaryServerInfo{Server: srv, FullMethod: DeleteFullMethodName}, handler)
+}
+
+func listHandler(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+	in := new(emptypb.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	handler := func(ctx context.Context, req any) (any, error) {
+		return srv.(MeshDNSRecordsServer).List(ctx, req.(*emptypb.Empty))
+	}
+	if interceptor == nil {
+		return handler(ctx, in)
+	}
+	return interceptor(ctx, in, &grpc.UnaryServerInfo{Server: srv, FullMethod: ListFullMethodName}, handler)
+}
+
+// Put creates or updates a record on the node at the other end of the given connection.
+func Put(ctx context.Context, cc grpc.ClientConnInterface, rec types.DNSRecord, opts ...grpc.CallOption) (types.DNSRecord, error) {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return rec, err
+	}
+	out := new(wrapperspb.BytesValue)
+	if err := cc.Invoke(ctx, PutFullMethodName, wrapperspb.Bytes(data), out, opts...); err != nil {
+		return rec, err
+	}
+	var stored types.DNSRecord
+	err = json.Unmarshal(out.GetValue(), &stored)
+	return stored, err
+}
+
+// Get returns a record from the node at the other end of the given connection.
+func Get(ctx context.Context, cc grpc.ClientConnInterface, ref types.DNSRecordRef, opts ...grpc.CallOption) (types.DNSRecord, error) {
+	var rec types.DNSRecord
+	data, err := json.Marshal(ref)
+	if err != nil {
+		return rec, err
+	}
+	out := new(wrapperspb.BytesValue)
+	if err := cc.Invoke(ctx, GetFullMethodName, wrapperspb.Bytes(data), out, opts...); err != nil {
+		return rec, err
+	}
+	err = json.Unmarshal(out.GetValue(), &rec)
+	return rec, err
+}
+
+// Delete removes a record on the node at the other end of the given connection.
+func Delete(ctx context.Context, cc grpc.ClientConnInterface, ref types.DNSRecordRef, opts ...grpc.CallOption) error {
+	data, err := json.Marshal(ref)
+	if err != nil {
+		return err
+	}
+	return cc.Invoke(ctx, DeleteFullMethodName, wrapperspb.Bytes(data), new(emptypb.Empty), opts...)
+}
+
+// List returns the records from the node at the other end of the given connection.
+func List(ctx context.Context, cc grpc.ClientConnInterface, opts ...grpc.CallOption) ([]types.DNSRecord, error) {
+	out := new(wrapperspb.BytesValue)
+	if err := cc.Invoke(ctx, ListFullMethodName, new(emptypb.Empty), out, opts...); err != nil {
+		return nil, err
+	}
+	var recs []types.DNSRecord
+	err := json.Unmarshal(out.GetValue(), &recs)
+	return recs, err
+}
+
+// Server is the DNS records server.
+type Server struct {
+	storage storage.Provider
+	store   *dnsrecords.Store
+	rbac    rbac.Evaluator
+	log     *slog.Logger
+}
+
+// NewServer returns a new DNS records Server.
+func NewServer(ctx context.Context, storage storage.Provider, rbac rbac.Evaluator) *Server {
+	return &Server{
+		storage: storage,
+		store:   dnsrecords.New(storage.MeshStorage()),
+		rbac:    rbac,
+		log:     context.LoggerFrom(ctx).With("component", "dnsrecords-server"),
+	}
+}
+
+// Put implements MeshDNSRecordsServer.
+func (s *Server) Put(ctx context.Context, req *wrapperspb.BytesValue) (*wrapperspb.BytesValue, error) {
+	var rec types.DNSRecord
+	if err := json.Unmarshal(req.GetValue(), &rec); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid dns record: %v", err)
+	}
+	// Authorize the name as it will be stored, since it may be relative.
+	meshState, err := s.storage.MeshDB().MeshState().GetMeshState(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "get mesh state: %v", err)
+	}
+	rec = rec.Normalize(meshState.Domain())
+	if err := s.authorize(ctx, rec.Name, v1.RuleVerb_VERB_PUT); err != nil {
+		return nil, err
+	}
+	stored, err := s.store.Put(ctx, rec)
+	if err != nil {
+		if errors.Is(err, errors.ErrInvalidDNSRecord) {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	data, err := json.Marshal(stored)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return wrapperspb.Bytes(data), nil
+}
+
+// Get implements MeshDNSRecordsServer.
+func (s *Server) Get(ctx context.Context, req *wrapperspb.BytesValue) (*wrapperspb.BytesValue, error) {
+	ref, err := s.parseRef(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.authorize(ctx, ref.Name, v1.RuleVerb_VERB_GET); err != nil {
+		return nil, err
+	}
+	rec, err := s.store.Get(ctx, ref)
+	if err != nil {
+		if errors.Is(err, errors.ErrDNSRecordNotFound) {
+			return nil, status.Error(codes.NotFound, err.Error())
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return wrapperspb.Bytes(data), nil
+}
+
+// Delete implements MeshDNSRecordsServer.
+func (s *Server) Delete(ctx context.Context, req *wrapperspb.BytesValue) (*emptypb.Empty, error) {
+	ref, err := s.parseRef(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.authorize(ctx, ref.Name, v1.RuleVerb_VERB_DELETE); err != nil {
+		return nil, err
+	}
+	if err := s.store.Delete(ctx, ref); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &emptypb.Empty{}, nil
+}
+
+// List implements MeshDNSRecordsServer.
+func (s *Server) List(ctx context.Context, _ *emptypb.Empty) (*wrapperspb.BytesValue, error) {
+	recs, err := s.store.List(ctx)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	out := make([]types.DNSRecord, 0, len(recs))
+	for _, rec := range recs {
+		allowed, err := s.rbac.Evaluate(ctx, action(v1.RuleVerb_VERB_GET).For(rec.Name))
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to evaluate dns record permissions: %v", err)
+		}
+		if allowed {
+			out = append(out, rec)
+		}
+	}
+	data, err := json.Marshal(out)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return wrapperspb.Bytes(data), nil
+}
+
+// parseRef parses a record reference. Relative names are resolved against the
+// mesh domain.
+func (s *Server) parseRef(ctx context.Context, req *wrapperspb.BytesValue) (types.DNSRecordRef, error) {
+	var ref types.DNSRecordRef
+	if err := json.Unmarshal(req.GetValue(), &ref); err != nil {
+		return ref, status.Errorf(codes.InvalidArgument, "invalid dns record reference: %v", err)
+	}
+	if ref.Name == "" || ref.Type == "" {
+		return ref, status.Error(codes.InvalidArgument, "name and type are required")
+	}
+	meshState, err := s.storage.MeshDB().MeshState().GetMeshState(ctx)
+	if err != nil {
+		return ref, status.Errorf(codes.Internal, "get mesh state: %v", err)
+	}
+	return ref.Normalize(meshState.Domain()), nil
+}
+
+// authorize checks that the caller may perform the verb on the record with
+// the given name. Records are authorized as routes by their fully qualified
+// name, since they direct traffic in the same way.
+func (s *Server) authorize(ctx context.Context, name string, verb v1.RuleVerb) error {
+	allowed, err := s.rbac.Evaluate(ctx, action(verb).For(name))
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to evaluate dns record permissions: %v", err)
+	}
+	if !allowed {
+		s.log.Warn("caller not allowed to access dns record", slog.String("name", name))
+		return status.Error(codes.PermissionDenied, "not allowed")
+	}
+	return nil
+}
+
+func action(verb v1.RuleVerb) rbac.Actions {
+	return rbac.Actions{{Verb: verb, Resource: v1.RuleResource_RESOURCE_ROUTES}}
+}
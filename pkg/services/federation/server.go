@@ -0,0 +1,453 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package federation provides the server for federating with other meshes.
+package federation
+
+import (
+	"encoding/json"
+	"log/slog"
+	"strings"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+
+	"github.com/webmeshproj/webmesh/pkg/common"
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/services/rbac"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// ServiceName is the fully qualified name of the federation service.
+const ServiceName = "v1.MeshFederation"
+
+const (
+	// PutFullMethodName is the full method name for Put.
+	PutFullMethodName = "/" + ServiceName + "/Put"
+	// GetFullMethodName is the full method name for Get.
+	GetFullMethodName = "/" + ServiceName + "/Get"
+	// DeleteFullMethodName is the full method name for Delete.
+	DeleteFullMethodName = "/" + ServiceName + "/Delete"
+	// ListFullMethodName is the full method name for List.
+	ListFullMethodName = "/" + ServiceName + "/List"
+	// BundleFullMethodName is the full method name for Bundle.
+	BundleFullMethodName = "/" + ServiceName + "/Bundle"
+)
+
+// BundleRequest is a request for an unsigned trust bundle describing this mesh.
+type BundleRequest struct {
+	// Gateways are the IDs of the nodes that peer with federated meshes.
+	Gateways []string `json:"gateways"`
+	// Nodes are the IDs of nodes that federated meshes may reference in ACLs.
+	Nodes []string `json:"nodes,omitempty"`
+	// Prefixes are the prefixes reachable through the gateways. Defaults to
+	// the networks of the mesh.
+	Prefixes []string `json:"prefixes,omitempty"`
+}
+
+// MeshFederationServer is the server API for federating with other meshes.
+// Federations and trust bundles are exchanged as JSON.
+type MeshFederationServer interface {
+	// Put creates or updates the JSON encoded types.Federation.
+	Put(context.Context, *wrapperspb.BytesValue) (*emptypb.Empty, error)
+	// Get returns the JSON encoded types.Federation with the given domain.
+	Get(context.Context, *wrapperspb.StringValue) (*wrapperspb.BytesValue, error)
+	// Delete removes the federation with the given domain.
+	Delete(context.Context, *wrapperspb.StringValue) (*emptypb.Empty, error)
+	// List returns the JSON encoded types.Federations the caller can read.
+	List(context.Context, *emptypb.Empty) (*wrapperspb.BytesValue, error)
+	// Bundle returns a JSON encoded unsigned types.TrustBundle for this mesh
+	// built from the JSON encoded BundleRequest.
+	Bundle(context.Context, *wrapperspb.BytesValue) (*wrapperspb.BytesValue, error)
+}
+
+// ServiceDesc is the grpc.ServiceDesc for the federation service.
+var ServiceDesc = grpc.ServiceDesc{
+	ServiceName: ServiceName,
+	HandlerType: (*MeshFederationServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{MethodName: "Put", Handler: putHandler},
+		{MethodName: "Get", Handler: getHandler},
+		{MethodName: "Delete", Handler: deleteHandler},
+		{MethodName: "List", Handler: listHandler},
+		{MethodName: "Bundle", Handler: bundleHandler},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "v1/federation.proto",
+}
+
+// RegisterMeshFederationServer registers the federation service with the given registrar.
+func RegisterMeshFederationServer(s grpc.ServiceRegistrar, srv MeshFederationServer) error {
+	err := common.RegisterServiceFile(&ServiceDesc,
+		common.ServiceMethod{Name: "Put", Input: &wrapperspb.BytesValue{}, Output: &emptypb.Empty{}},
+		common.ServiceMethod{Name: "Get", Input: &wrapperspb.StringValue{}, Output: &wrapperspb.BytesValue{}},
+		common.ServiceMethod{Name: "Delete", Input: &wrapperspb.StringValue{}, Output: &emptypb.Empty{}},
+		common.ServiceMethod{Name: "List", Input: &emptypb.Empty{}, Output: &wrapperspb.BytesValue{}},
+		common.ServiceMethod{Name: "Bundle", Input: &wrapperspb.BytesValue{}, Output: &wrapperspb.BytesValue{}},
+	)
+	if err != nil {
+		return err
+	}
+	s.RegisterService(&ServiceDesc, srv)
+	return nil
+}
+
+func putHandler(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+	in := new(wrapperspb.BytesValue)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	handler := func(ctx context.Context, req any) (any, error) {
+		return srv.(MeshFederationServer).Put(ctx, req.(*wrapperspb.BytesValue))
+	}
+	if interceptor == nil {
+		return handler(ctx, in)
+	}
+	return interceptor(ctx, in, &grpc.UnaryServerInfo{Server: srv, FullMethod: PutFullMethodName}, handler)
+}
+
+func getHandler(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+	in := new(wrapperspb.StringValue)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	handler := func(ctx context.Context, req any) (any, error) {
+		return srv.(MeshFederationServer).Get(ctx, req.(*wrapperspb.StringValue))
+	}
+	if interceptor == nil {
+		return handler(ctx, in)
+	}
+	return interceptor(ctx, in, &grpc.UnaryServerInfo{Server: srv, FullMethod: GetFullMethodName}, handler)
+}
+
+func deleteHandler(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+	in := new(wrapperspb.StringValue)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	handler := func(ctx context.Context, req any) (any, error) {
+		return srv.(MeshFederationServer).Delete(ctx, req.(*wrapperspb.StringValue))
+	}
+	if interceptor == nil {
+		return handler(ctx, in)
+	}
+	return interceptor(ctx, in, &grpc.UnaryServerInfo{Server: srv, FullMethod: DeleteFullMethodName}, handler)
+}
+
+func listHandler(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+	in := new(emptypb.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	handler := func(ctx context.Context, req any) (any, error) {
+		return srv.(MeshFederationServer).List(ctx, req.(*emptypb.Empty))
+	}
+	if interceptor == nil {
+		return handler(ctx, in)
+	}
+	return interceptor(ctx, in, &grpc.UnaryServerInfo{Server: srv, FullMethod: ListFullMethodName}, handler)
+}
+
+func bundleHandler(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+	in := new(wrapperspb.BytesValue)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	handler := func(ctx context.Context, req any) (any, error) {
+		return srv.(MeshFederationServer).Bundle(ctx, req.(*wrapperspb.BytesValue))
+	}
+	if interceptor == nil {
+		return handler(ctx, in)
+	}
+	return interceptor(ctx, in, &grpc.UnaryServerInfo{Server: srv, FullMethod: BundleFullMethodName}, handler)
+}
+
+// Put creates or updates a federation on the node at the other end of the given connection.
+func Put(ctx context.Context, cc grpc.ClientConnInterface, fed types.Federation, opts ...grpc.CallOption) error {
+	data, err := json.Marshal(fed)
+	if err != nil {
+		return err
+	}
+	return cc.Invoke(ctx, PutFullMethodName, wrapperspb.Bytes(data), new(emptypb.Empty), opts...)
+}
+
+// Get returns a federation from the node at the other end of the given connection.
+func Get(ctx context.Context, cc grpc.ClientConnInterface, domain string, opts ...grpc.CallOption) (types.Federation, error) {
+	var fed types.Federation
+	out := new(wrapperspb.BytesValue)
+	if err := cc.Invoke(ctx, GetFullMethodName, wrapperspb.String(domain), out, opts...); err != nil {
+		return fed, err
+	}
+	err := json.Unmarshal(out.GetValue(), &fed)
+	return fed, err
+}
+
+// Delete removes a federation on the node at the other end of the given connection.
+func Delete(ctx context.Context, cc grpc.ClientConnInterface, domain string, opts ...grpc.CallOption) error {
+	return cc.Invoke(ctx, DeleteFullMethodName, wrapperspb.String(domain), new(emptypb.Empty), opts...)
+}
+
+// List returns the federations from the node at the other end of the given connection.
+func List(ctx context.Context, cc grpc.ClientConnInterface, opts ...grpc.CallOption) ([]types.Federation, error) {
+	out := new(wrapperspb.BytesValue)
+	if err := cc.Invoke(ctx, ListFullMethodName, new(emptypb.Empty), out, opts...); err != nil {
+		return nil, err
+	}
+	var feds []types.Federation
+	err := json.Unmarshal(out.GetValue(), &feds)
+	return feds, err
+}
+
+// Bundle returns an unsigned trust bundle for the mesh of the node at the other
+// end of the given connection.
+func Bundle(ctx context.Context, cc grpc.ClientConnInterface, req BundleRequest, opts ...grpc.CallOption) (types.TrustBundle, error) {
+	var bundle types.TrustBundle
+	data, err := json.Marshal(req)
+	if err != nil {
+		return bundle, err
+	}
+	out := new(wrapperspb.BytesValue)
+	if err := cc.Invoke(ctx, BundleFullMethodName, wrapperspb.Bytes(data), out, opts...); err != nil {
+		return bundle, err
+	}
+	err = json.Unmarshal(out.GetValue(), &bundle)
+	return bundle, err
+}
+
+// Server is the federation server.
+type Server struct {
+	storage storage.Provider
+	rbac    rbac.Evaluator
+	log     *slog.Logger
+}
+
+// NewServer returns a new federation Server.
+func NewServer(ctx context.Context, storage storage.Provider, rbac rbac.Evaluator) *Server {
+	return &Server{
+		storage: storage,
+		rbac:    rbac,
+		log:     context.LoggerFrom(ctx).With("component", "federation-server"),
+	}
+}
+
+// Put implements MeshFederationServer.
+func (s *Server) Put(ctx context.Context, req *wrapperspb.BytesValue) (*emptypb.Empty, error) {
+	var fed types.Federation
+	if err := json.Unmarshal(req.GetValue(), &fed); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid federation: %v", err)
+	}
+	if err := s.authorize(ctx, fed.Domain(), v1.RuleVerb_VERB_PUT); err != nil {
+		return nil, err
+	}
+	store, err := s.federation()
+	if err != nil {
+		return nil, err
+	}
+	if err := store.PutFederation(ctx, fed); err != nil {
+		if errors.Is(err, errors.ErrInvalidFederation) {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &emptypb.Empty{}, nil
+}
+
+// Get implements MeshFederationServer.
+func (s *Server) Get(ctx context.Context, req *wrapperspb.StringValue) (*wrapperspb.BytesValue, error) {
+	if err := s.authorize(ctx, req.GetValue(), v1.RuleVerb_VERB_GET); err != nil {
+		return nil, err
+	}
+	store, err := s.federation()
+	if err != nil {
+		return nil, err
+	}
+	fed, err := store.GetFederation(ctx, req.GetValue())
+	if err != nil {
+		if errors.Is(err, errors.ErrFederationNotFound) {
+			return nil, status.Error(codes.NotFound, err.Error())
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	data, err := json.Marshal(fed)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return wrapperspb.Bytes(data), nil
+}
+
+// Delete implements MeshFederationServer.
+func (s *Server) Delete(ctx context.Context, req *wrapperspb.StringValue) (*emptypb.Empty, error) {
+	if err := s.authorize(ctx, req.GetValue(), v1.RuleVerb_VERB_DELETE); err != nil {
+		return nil, err
+	}
+	store, err := s.federation()
+	if err != nil {
+		return nil, err
+	}
+	if err := store.DeleteFederation(ctx, req.GetValue()); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &emptypb.Empty{}, nil
+}
+
+// List implements MeshFederationServer.
+func (s *Server) List(ctx context.Context, _ *emptypb.Empty) (*wrapperspb.BytesValue, error) {
+	store, err := s.federation()
+	if err != nil {
+		return nil, err
+	}
+	feds, err := store.ListFederations(ctx)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	out := make([]types.Federation, 0, len(feds))
+	for _, fed := range feds {
+		allowed, err := s.rbac.Evaluate(ctx, action(v1.RuleVerb_VERB_GET).For(fed.Domain()))
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to evaluate federation permissions: %v", err)
+		}
+		if allowed {
+			out = append(out, fed)
+		}
+	}
+	data, err := json.Marshal(out)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return wrapperspb.Bytes(data), nil
+}
+
+// Bundle implements MeshFederationServer.
+func (s *Server) Bundle(ctx context.Context, req *wrapperspb.BytesValue) (*wrapperspb.BytesValue, error) {
+	var breq BundleRequest
+	if err := json.Unmarshal(req.GetValue(), &breq); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid bundle request: %v", err)
+	}
+	if len(breq.Gateways) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "at least one gateway is required")
+	}
+	db := s.storage.MeshDB()
+	meshState, err := db.MeshState().GetMeshState(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "get mesh state: %v", err)
+	}
+	domain := strings.TrimSuffix(meshState.Domain(), ".")
+	if err := s.authorize(ctx, domain, v1.RuleVerb_VERB_GET); err != nil {
+		return nil, err
+	}
+	bundle := types.TrustBundle{
+		Domain:   domain,
+		Prefixes: breq.Prefixes,
+	}
+	if len(bundle.Prefixes) == 0 {
+		for _, network := range []string{meshState.GetNetworkV4(), meshState.GetNetworkV6()} {
+			if network != "" {
+				bundle.Prefixes = append(bundle.Prefixes, network)
+			}
+		}
+	}
+	for _, id := range breq.Gateways {
+		node, err := db.Peers().Get(ctx, types.NodeID(id))
+		if err != nil {
+			if errors.IsNodeNotFound(err) {
+				return nil, status.Errorf(codes.NotFound, "gateway %q not found", id)
+			}
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		gw := types.FederationGateway{
+			ID:        node.GetId(),
+			PublicKey: node.GetPublicKey(),
+			Endpoints: node.GetWireguardEndpoints(),
+			Addresses: nodeAddresses(node),
+		}
+		if node.PrivateDNSAddrV4().IsValid() {
+			gw.DNS = append(gw.DNS, node.PrivateDNSAddrV4().String())
+		}
+		if node.PrivateDNSAddrV6().IsValid() {
+			gw.DNS = append(gw.DNS, node.PrivateDNSAddrV6().String())
+		}
+		bundle.Gateways = append(bundle.Gateways, gw)
+	}
+	for _, id := range breq.Nodes {
+		node, err := db.Peers().Get(ctx, types.NodeID(id))
+		if err != nil {
+			if errors.IsNodeNotFound(err) {
+				return nil, status.Errorf(codes.NotFound, "node %q not found", id)
+			}
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		bundle.Nodes = append(bundle.Nodes, types.FederatedNode{
+			ID:        node.GetId(),
+			Addresses: nodeAddresses(node),
+		})
+	}
+	if err := bundle.Validate(); err != nil {
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+	data, err := json.Marshal(bundle)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return wrapperspb.Bytes(data), nil
+}
+
+func (s *Server) federation() (storage.Federation, error) {
+	fed, ok := storage.FederationOf(s.storage.MeshDB())
+	if !ok {
+		return nil, status.Error(codes.Unimplemented, "storage does not support federation")
+	}
+	return fed, nil
+}
+
+// authorize checks that the caller may perform the verb on the federation
+// with the given domain. Federations are authorized as the routes they
+// install.
+func (s *Server) authorize(ctx context.Context, domain string, verb v1.RuleVerb) error {
+	domain = strings.TrimSuffix(domain, ".")
+	if !types.IsValidID(domain) {
+		return status.Errorf(codes.InvalidArgument, "invalid domain %q", domain)
+	}
+	allowed, err := s.rbac.Evaluate(ctx, action(verb).For(domain))
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to evaluate federation permissions: %v", err)
+	}
+	if !allowed {
+		s.log.Warn("caller not allowed to access federation", slog.String("domain", domain))
+		return status.Error(codes.PermissionDenied, "not allowed")
+	}
+	return nil
+}
+
+func action(verb v1.RuleVerb) rbac.Actions {
+	return rbac.Actions{{Verb: verb, Resource: v1.RuleResource_RESOURCE_ROUTES}}
+}
+
+func nodeAddresses(node types.MeshNode) []string {
+	var out []string
+	if node.PrivateAddrV4().IsValid() {
+		out = append(out, node.PrivateAddrV4().String())
+	}
+	if node.PrivateAddrV6().IsValid() {
+		out = append(out, node.PrivateAddrV6().String())
+	}
+	return out
+}
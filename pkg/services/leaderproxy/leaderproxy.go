@@ -83,6 +83,10 @@ func (i *Interceptor) UnaryInterceptor() grpc.UnaryServerInterceptor {
 				return handler(ctx, req)
 			case AllowNonLeader:
 				log.Debug("Request allows non-leader handling", slog.String("method", info.FullMethod))
+				if HasLinearizableReadMeta(ctx) {
+					log.Debug("Requestor wants a linearizable read", slog.String("method", info.FullMethod))
+					return i.proxyUnaryToLeader(ctx, req, info, handler)
+				}
 				if HasPreferLeaderMeta(ctx) {
 					log.Debug("Requestor prefers leader handling", slog.String("method", info.FullMethod))
 					return i.proxyUnaryToLeader(ctx, req, info, handler)
@@ -157,15 +161,27 @@ func (i *Interceptor) proxyUnaryToLeader(ctx context.Context, req any, info *grp
 
 	//Storage API
 	case v1.StorageQueryService_Query_FullMethodName:
-		return v1.NewStorageQueryServiceClient(conn).Query(ctx, req.(*v1.QueryRequest))
+		var header metadata.MD
+		defer relayHeader(ctx, &header, storage.VersionMeta, storage.AppliedIndexMeta)
+		ctx = forwardMeta(ctx, storage.ConsistencyMeta, storage.MaxStalenessMeta, storage.MinIndexMeta)
+		return v1.NewStorageQueryServiceClient(conn).Query(ctx, req.(*v1.QueryRequest), grpc.Header(&header))
 	case v1.StorageQueryService_Publish_FullMethodName:
-		return v1.NewStorageQueryServiceClient(conn).Publish(ctx, req.(*v1.PublishRequest))
+		var header metadata.MD
+		defer relayHeader(ctx, &header, storage.TxnSucceededMeta, storage.AppliedIndexMeta)
+		ctx = forwardMeta(ctx, storage.TxnMeta)
+		return v1.NewStorageQueryServiceClient(conn).Publish(ctx, req.(*v1.PublishRequest), grpc.Header(&header))
 
 	// Mesh API
 	case v1.Mesh_GetNode_FullMethodName:
-		return v1.NewMeshClient(conn).GetNode(ctx, req.(*v1.GetNodeRequest))
+		var header metadata.MD
+		defer relayHeader(ctx, &header, storage.AppliedIndexMeta)
+		ctx = forwardMeta(ctx, storage.ConsistencyMeta, storage.MaxStalenessMeta, storage.MinIndexMeta)
+		return v1.NewMeshClient(conn).GetNode(ctx, req.(*v1.GetNodeRequest), grpc.Header(&header))
 	case v1.Mesh_ListNodes_FullMethodName:
-		return v1.NewMeshClient(conn).ListNodes(ctx, req.(*emptypb.Empty))
+		var header metadata.MD
+		defer relayHeader(ctx, &header, storage.AppliedIndexMeta)
+		ctx = forwardMeta(ctx, storage.ConsistencyMeta, storage.MaxStalenessMeta, storage.MinIndexMeta)
+		return v1.NewMeshClient(conn).ListNodes(ctx, req.(*emptypb.Empty), grpc.Header(&header))
 	case v1.Mesh_GetMeshGraph_FullMethodName:
 		return v1.NewMeshClient(conn).GetMeshGraph(ctx, req.(*emptypb.Empty))
 
@@ -257,10 +273,13 @@ func (i *Interceptor) proxyStreamToLeader(srv any, ss grpc.ServerStream, info *g
 		if err := ss.RecvMsg(&req); err != nil {
 			return err
 		}
-		stream, err := client.SubscribePeers(ctx, req)
+		stream, err := client.SubscribePeers(forwardMeta(ctx, storage.WatchRevisionMeta), req)
 		if err != nil {
 			return err
 		}
+		if err := relayStreamHeader(ss, stream, storage.RevisionMeta); err != nil {
+			return err
+		}
 		return proxyStream[v1.SubscribePeersRequest, v1.PeerConfigurations](ctx, ss, stream)
 
 	// WebRTC API
@@ -328,3 +347,51 @@ func proxyStream[S, R any](ctx context.Context, ss grpc.ServerStream, cs grpc.Cl
 		}
 	}
 }
+
+// forwardMeta copies the given keys from the incoming metadata to the outgoing
+// metadata of the context.
+func forwardMeta(ctx context.Context, keys ...string) context.Context {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ctx
+	}
+	for _, key := range keys {
+		for _, val := range md.Get(key) {
+			ctx = metadata.AppendToOutgoingContext(ctx, key, val)
+		}
+	}
+	return ctx
+}
+
+// relayHeader sends the given keys of a header received from the leader
+// in the header of the response to the caller.
+func relayHeader(ctx context.Context, header *metadata.MD, keys ...string) {
+	out := metadata.MD{}
+	for _, key := range keys {
+		if vals := header.Get(key); len(vals) > 0 {
+			out.Set(key, vals...)
+		}
+	}
+	if out.Len() > 0 {
+		_ = grpc.SetHeader(ctx, out)
+	}
+}
+
+// relayStreamHeader sends the given keys of the header of a stream from the
+// leader in the header of the stream to the caller.
+func relayStreamHeader(ss grpc.ServerStream, cs grpc.ClientStream, keys ...string) error {
+	header, err := cs.Header()
+	if err != nil {
+		return err
+	}
+	out := metadata.MD{}
+	for _, key := range keys {
+		if vals := header.Get(key); len(vals) > 0 {
+			out.Set(key, vals...)
+		}
+	}
+	if out.Len() > 0 {
+		return ss.SendHeader(out)
+	}
+	return nil
+}
@@ -20,6 +20,8 @@ import (
 	"context"
 
 	"google.golang.org/grpc/metadata"
+
+	"github.com/webmeshproj/webmesh/pkg/storage"
 )
 
 const (
@@ -41,6 +43,17 @@ func HasPreferLeaderMeta(ctx context.Context) bool {
 	return len(leaderPref) > 0 && leaderPref[0] == "true"
 }
 
+// HasLinearizableReadMeta returns true if the context requests a linearizable
+// read, which only the leader can serve.
+func HasLinearizableReadMeta(ctx context.Context) bool {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return false
+	}
+	consistency := md.Get(storage.ConsistencyMeta)
+	return len(consistency) > 0 && consistency[0] == string(storage.ConsistencyLinearizable)
+}
+
 // ProxiedFrom returns the node ID of the node that proxied the request.
 // If the request was not proxied then false is returned.
 func ProxiedFrom(ctx context.Context) (string, bool) {
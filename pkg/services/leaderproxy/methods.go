@@ -43,9 +43,77 @@ func RouteRequiresInNetworkSource(route string) bool {
 		route == v1.Node_NegotiateDataChannel_FullMethodName ||
 		route == v1.StorageQueryService_Query_FullMethodName ||
 		route == v1.StorageQueryService_Publish_FullMethodName ||
-		route == v1.StorageQueryService_Subscribe_FullMethodName
+		route == v1.StorageQueryService_Subscribe_FullMethodName ||
+		route == LocksLockFullMethodName ||
+		route == LocksObserveFullMethodName ||
+		route == NamespacesPutFullMethodName ||
+		route == NamespacesGetFullMethodName ||
+		route == NamespacesDeleteFullMethodName ||
+		route == NamespacesListFullMethodName ||
+		route == FederationPutFullMethodName ||
+		route == FederationGetFullMethodName ||
+		route == FederationDeleteFullMethodName ||
+		route == FederationListFullMethodName ||
+		route == FederationBundleFullMethodName ||
+		route == DNSRecordsPutFullMethodName ||
+		route == DNSRecordsGetFullMethodName ||
+		route == DNSRecordsDeleteFullMethodName ||
+		route == DNSRecordsListFullMethodName ||
+		route == PresenceRenewFullMethodName
 }
 
+// Method names of services that are not part of the API module. They are
+// duplicated here to avoid an import cycle with the services that use the
+// leader proxy.
+const (
+	// LocksLockFullMethodName is the full method name for MeshLocks.Lock.
+	LocksLockFullMethodName = "/v1.MeshLocks/Lock"
+	// LocksObserveFullMethodName is the full method name for MeshLocks.Observe.
+	LocksObserveFullMethodName = "/v1.MeshLocks/Observe"
+	// NamespacesPutFullMethodName is the full method name for MeshNamespaces.Put.
+	NamespacesPutFullMethodName = "/v1.MeshNamespaces/Put"
+	// NamespacesGetFullMethodName is the full method name for MeshNamespaces.Get.
+	NamespacesGetFullMethodName = "/v1.MeshNamespaces/Get"
+	// NamespacesDeleteFullMethodName is the full method name for MeshNamespaces.Delete.
+	NamespacesDeleteFullMethodName = "/v1.MeshNamespaces/Delete"
+	// NamespacesListFullMethodName is the full method name for MeshNamespaces.List.
+	NamespacesListFullMethodName = "/v1.MeshNamespaces/List"
+	// FederationPutFullMethodName is the full method name for MeshFederation.Put.
+	FederationPutFullMethodName = "/v1.MeshFederation/Put"
+	// FederationGetFullMethodName is the full method name for MeshFederation.Get.
+	FederationGetFullMethodName = "/v1.MeshFederation/Get"
+	// FederationDeleteFullMethodName is the full method name for MeshFederation.Delete.
+	FederationDeleteFullMethodName = "/v1.MeshFederation/Delete"
+	// FederationListFullMethodName is the full method name for MeshFederation.List.
+	FederationListFullMethodName = "/v1.MeshFederation/List"
+	// FederationBundleFullMethodName is the full method name for MeshFederation.Bundle.
+	FederationBundleFullMethodName = "/v1.MeshFederation/Bundle"
+	// DNSRecordsPutFullMethodName is the full method name for MeshDNSRecords.Put.
+	DNSRecordsPutFullMethodName = "/v1.MeshDNSRecords/Put"
+	// DNSRecordsGetFullMethodName is the full method name for MeshDNSRecords.Get.
+	DNSRecordsGetFullMethodName = "/v1.MeshDNSRecords/Get"
+	// DNSRecordsDeleteFullMethodName is the full method name for MeshDNSRecords.Delete.
+	DNSRecordsDeleteFullMethodName = "/v1.MeshDNSRecords/Delete"
+	// DNSRecordsListFullMethodName is the full method name for MeshDNSRecords.List.
+	DNSRecordsListFullMethodName = "/v1.MeshDNSRecords/List"
+	// PresenceRenewFullMethodName is the full method name for MeshPresence.Renew.
+	PresenceRenewFullMethodName = "/v1.MeshPresence/Renew"
+	// PresenceListFullMethodName is the full method name for MeshPresence.List.
+	PresenceListFullMethodName = "/v1.MeshPresence/List"
+	// AutopilotGetStateFullMethodName is the full method name for MeshAutopilot.GetState.
+	AutopilotGetStateFullMethodName = "/v1.MeshAutopilot/GetState"
+	// AuditListFullMethodName is the full method name for MeshAudit.List.
+	AuditListFullMethodName = "/v1.MeshAudit/List"
+	// AdminRBACCanIFullMethodName is the full method name for AdminRBAC.CanI.
+	AdminRBACCanIFullMethodName = "/v1.AdminRBAC/CanI"
+	// AdminRBACWhoCanFullMethodName is the full method name for AdminRBAC.WhoCan.
+	AdminRBACWhoCanFullMethodName = "/v1.AdminRBAC/WhoCan"
+	// AdminNetworkACLsTestFullMethodName is the full method name for AdminNetworkACLs.Test.
+	AdminNetworkACLsTestFullMethodName = "/v1.AdminNetworkACLs/Test"
+	// AdminNetworkACLsMatrixFullMethodName is the full method name for AdminNetworkACLs.Matrix.
+	AdminNetworkACLsMatrixFullMethodName = "/v1.AdminNetworkACLs/Matrix"
+)
+
 // MethodPolicyMap is a map of method names to their MethodPolicy.
 var MethodPolicyMap = map[string]MethodPolicy{
 	// Membership API
@@ -65,6 +133,38 @@ var MethodPolicyMap = map[string]MethodPolicy{
 	v1.StorageQueryService_Publish_FullMethodName:   AllowNonLeader,
 	v1.StorageQueryService_Subscribe_FullMethodName: RequireLocal,
 
+	// Locks API
+	LocksLockFullMethodName:    RequireLocal,
+	LocksObserveFullMethodName: RequireLocal,
+
+	// Namespaces API
+	NamespacesPutFullMethodName:    RequireLocal,
+	NamespacesGetFullMethodName:    RequireLocal,
+	NamespacesDeleteFullMethodName: RequireLocal,
+	NamespacesListFullMethodName:   RequireLocal,
+
+	// Federation API
+	FederationPutFullMethodName:    RequireLocal,
+	FederationGetFullMethodName:    RequireLocal,
+	FederationDeleteFullMethodName: RequireLocal,
+	FederationListFullMethodName:   RequireLocal,
+	FederationBundleFullMethodName: RequireLocal,
+	// DNS Records API
+	DNSRecordsPutFullMethodName:    RequireLocal,
+	DNSRecordsGetFullMethodName:    RequireLocal,
+	DNSRecordsDeleteFullMethodName: RequireLocal,
+	DNSRecordsListFullMethodName:   RequireLocal,
+
+	// Presence API
+	PresenceRenewFullMethodName: RequireLeader,
+	PresenceListFullMethodName:  AllowNonLeader,
+
+	// Autopilot API
+	AutopilotGetStateFullMethodName: AllowNonLeader,
+
+	// Audit API
+	AuditListFullMethodName: AllowNonLeader,
+
 	// Mesh API
 	v1.Mesh_GetNode_FullMethodName:      AllowNonLeader,
 	v1.Mesh_ListNodes_FullMethodName:    AllowNonLeader,
@@ -103,4 +203,10 @@ var MethodPolicyMap = map[string]MethodPolicy{
 	v1.Admin_DeleteEdge_FullMethodName: RequireLeader,
 	v1.Admin_GetEdge_FullMethodName:    AllowNonLeader,
 	v1.Admin_ListEdges_FullMethodName:  AllowNonLeader,
+
+	AdminRBACCanIFullMethodName:   AllowNonLeader,
+	AdminRBACWhoCanFullMethodName: AllowNonLeader,
+
+	AdminNetworkACLsTestFullMethodName:   AllowNonLeader,
+	AdminNetworkACLsMatrixFullMethodName: AllowNonLeader,
 }
@@ -0,0 +1,327 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package locks provides the mesh locks server.
+package locks
+
+import (
+	"encoding/json"
+	"log/slog"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/webmeshproj/webmesh/pkg/common"
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/meshnet"
+	"github.com/webmeshproj/webmesh/pkg/services/rbac"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/locks"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// ServiceName is the fully qualified name of the mesh locks service.
+const ServiceName = "v1.MeshLocks"
+
+const (
+	// LockFullMethodName is the full method name for Lock.
+	LockFullMethodName = "/" + ServiceName + "/Lock"
+	// ObserveFullMethodName is the full method name for Observe.
+	ObserveFullMethodName = "/" + ServiceName + "/Observe"
+)
+
+// MeshLocksServer is the server API for mesh locks.
+type MeshLocksServer interface {
+	// Lock acquires the lock named by the key of the request and holds it for
+	// as long as the stream is open. The value of the request is attached to
+	// the lock and the TTL, if set, is used as the lease duration. Once the lock
+	// is acquired, its JSON encoded record, including the fencing token, is sent
+	// to the caller. The stream is closed with codes.Aborted if the lock is lost.
+	// Leader elections are locks whose value identifies the leader.
+	Lock(*v1.PublishRequest, LockServerStream) error
+	// Observe sends the JSON encoded record of the lock named by the prefix of
+	// the request every time it changes. An empty value is sent while the lock
+	// is not held.
+	Observe(*v1.SubscribeRequest, ObserveServerStream) error
+}
+
+// LockServerStream is the server stream for Lock.
+type LockServerStream interface {
+	Send(*v1.SubscriptionEvent) error
+	grpc.ServerStream
+}
+
+// ObserveServerStream is the server stream for Observe.
+type ObserveServerStream interface {
+	Send(*v1.SubscriptionEvent) error
+	grpc.ServerStream
+}
+
+// ServiceDesc is the grpc.ServiceDesc for the mesh locks service.
+var ServiceDesc = grpc.ServiceDesc{
+	ServiceName: ServiceName,
+	HandlerType: (*MeshLocksServer)(nil),
+	Methods:     []grpc.MethodDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Lock",
+			Handler:       lockHandler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "Observe",
+			Handler:       observeHandler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "v1/locks.proto",
+}
+
+// RegisterMeshLocksServer registers the mesh locks service with the given registrar.
+func RegisterMeshLocksServer(s grpc.ServiceRegistrar, srv MeshLocksServer) error {
+	err := common.RegisterServiceFile(&ServiceDesc,
+		common.ServiceMethod{
+			Name:            "Lock",
+			Input:           &v1.PublishRequest{},
+			Output:          &v1.SubscriptionEvent{},
+			ServerStreaming: true,
+		},
+		common.ServiceMethod{
+			Name:            "Observe",
+			Input:           &v1.SubscribeRequest{},
+			Output:          &v1.SubscriptionEvent{},
+			ServerStreaming: true,
+		},
+	)
+	if err != nil {
+		return err
+	}
+	s.RegisterService(&ServiceDesc, srv)
+	return nil
+}
+
+func lockHandler(srv any, stream grpc.ServerStream) error {
+	var req v1.PublishRequest
+	if err := stream.RecvMsg(&req); err != nil {
+		return err
+	}
+	return srv.(MeshLocksServer).Lock(&req, &eventServerStream{stream})
+}
+
+func observeHandler(srv any, stream grpc.ServerStream) error {
+	var req v1.SubscribeRequest
+	if err := stream.RecvMsg(&req); err != nil {
+		return err
+	}
+	return srv.(MeshLocksServer).Observe(&req, &eventServerStream{stream})
+}
+
+type eventServerStream struct {
+	grpc.ServerStream
+}
+
+func (s *eventServerStream) Send(ev *v1.SubscriptionEvent) error {
+	return s.ServerStream.SendMsg(ev)
+}
+
+// EventClientStream is the client stream for Lock and Observe.
+type EventClientStream interface {
+	Recv() (*v1.SubscriptionEvent, error)
+	grpc.ClientStream
+}
+
+// Lock acquires a lock on the node at the other end of the given connection.
+// The first event received on the stream carries the lock record. The lock
+// is released when the context is canceled.
+func Lock(ctx context.Context, cc grpc.ClientConnInterface, req *v1.PublishRequest, opts ...grpc.CallOption) (EventClientStream, error) {
+	return newEventStream(ctx, cc, &ServiceDesc.Streams[0], LockFullMethodName, req, opts...)
+}
+
+// Observe observes a lock on the node at the other end of the given connection.
+func Observe(ctx context.Context, cc grpc.ClientConnInterface, req *v1.SubscribeRequest, opts ...grpc.CallOption) (EventClientStream, error) {
+	return newEventStream(ctx, cc, &ServiceDesc.Streams[1], ObserveFullMethodName, req, opts...)
+}
+
+func newEventStream(ctx context.Context, cc grpc.ClientConnInterface, desc *grpc.StreamDesc, method string, req any, opts ...grpc.CallOption) (EventClientStream, error) {
+	stream, err := cc.NewStream(ctx, desc, method, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &eventClientStream{stream}
+	if err := x.ClientStream.SendMsg(req); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type eventClientStream struct {
+	grpc.ClientStream
+}
+
+func (x *eventClientStream) Recv() (*v1.SubscriptionEvent, error) {
+	m := new(v1.SubscriptionEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+var canLockAction = rbac.Actions{
+	{
+		Verb:     v1.RuleVerb_VERB_PUT,
+		Resource: v1.RuleResource_RESOURCE_PUBSUB,
+	},
+}
+
+var canObserveAction = rbac.Actions{
+	{
+		Verb:     v1.RuleVerb_VERB_GET,
+		Resource: v1.RuleResource_RESOURCE_PUBSUB,
+	},
+}
+
+// Server is the mesh locks server.
+type Server struct {
+	nodeID  types.NodeID
+	storage storage.Provider
+	rbac    rbac.Evaluator
+	mnet    meshnet.Manager
+	log     *slog.Logger
+}
+
+// NewServer returns a new mesh locks Server.
+func NewServer(ctx context.Context, nodeID types.NodeID, storage storage.Provider, rbac rbac.Evaluator, mnet meshnet.Manager) *Server {
+	return &Server{
+		nodeID:  nodeID,
+		storage: storage,
+		rbac:    rbac,
+		mnet:    mnet,
+		log:     context.LoggerFrom(ctx).With("component", "locks-server"),
+	}
+}
+
+// Lock implements MeshLocksServer.
+func (s *Server) Lock(req *v1.PublishRequest, stream LockServerStream) error {
+	ctx := stream.Context()
+	name := string(req.GetKey())
+	if req.GetTtl() != nil && req.GetTtl().AsDuration() < locks.MinTTL {
+		return status.Errorf(codes.InvalidArgument, "lock ttl must be at least %s", locks.MinTTL)
+	}
+	if err := s.authorize(ctx, name, canLockAction); err != nil {
+		return err
+	}
+	// Locks taken on behalf of authenticated callers are held in their name,
+	// so they are released with the caller if it is removed from the mesh.
+	holder := s.nodeID
+	if caller, ok := context.AuthenticatedCallerFrom(ctx); ok {
+		holder = types.NodeID(caller)
+	}
+	locker, err := locks.NewLocker(s.storage.MeshStorage(), locks.Options{
+		NodeID: holder,
+		TTL:    req.GetTtl().AsDuration(),
+	})
+	if err != nil {
+		return status.Errorf(codes.Unimplemented, "locks not supported: %v", err)
+	}
+	lock, err := locker.Acquire(ctx, name, req.GetValue())
+	if err != nil {
+		if ctx.Err() != nil {
+			return status.FromContextError(ctx.Err()).Err()
+		}
+		return status.Errorf(codes.Internal, "failed to acquire lock: %v", err)
+	}
+	defer func() {
+		// Use a fresh context, the stream context is likely done.
+		rctx, cancel := context.WithTimeout(context.Background(), locker.TTL())
+		defer cancel()
+		if err := lock.Release(rctx); err != nil {
+			s.log.Warn("Failed to release lock", slog.String("lock", name), slog.String("error", err.Error()))
+		}
+	}()
+	data, err := json.Marshal(lock.Record())
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to encode lock record: %v", err)
+	}
+	if err := stream.Send(&v1.SubscriptionEvent{Key: req.GetKey(), Value: data}); err != nil {
+		return err
+	}
+	select {
+	case <-ctx.Done():
+		return nil
+	case <-lock.Lost():
+		return status.Errorf(codes.Aborted, "%v", lock.Err())
+	}
+}
+
+// Observe implements MeshLocksServer.
+func (s *Server) Observe(req *v1.SubscribeRequest, stream ObserveServerStream) error {
+	ctx := stream.Context()
+	name := string(req.GetPrefix())
+	if err := s.authorize(ctx, name, canObserveAction); err != nil {
+		return err
+	}
+	locker, err := locks.NewLocker(s.storage.MeshStorage(), locks.Options{NodeID: s.nodeID})
+	if err != nil {
+		return status.Errorf(codes.Unimplemented, "locks not supported: %v", err)
+	}
+	records, err := locker.Watch(ctx, name)
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to observe lock: %v", err)
+	}
+	for rec := range records {
+		var data []byte
+		if rec.Session != "" {
+			data, err = json.Marshal(rec)
+			if err != nil {
+				return status.Errorf(codes.Internal, "failed to encode lock record: %v", err)
+			}
+		}
+		if err := stream.Send(&v1.SubscriptionEvent{Key: req.GetPrefix(), Value: data}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Server) authorize(ctx context.Context, name string, action rbac.Actions) error {
+	if !context.IsInNetwork(ctx, s.mnet) {
+		addr, _ := context.PeerAddrFrom(ctx)
+		s.log.Warn("Received lock request from out of network", slog.String("peer", addr.String()))
+		return status.Errorf(codes.PermissionDenied, "request is not in-network")
+	}
+	if !types.IsValidID(name) {
+		return status.Errorf(codes.InvalidArgument, "invalid lock name %q", name)
+	}
+	// Locks are authorized as pub/sub keys.
+	locker, err := locks.NewLocker(s.storage.MeshStorage(), locks.Options{NodeID: s.nodeID})
+	if err != nil {
+		return status.Errorf(codes.Unimplemented, "locks not supported: %v", err)
+	}
+	allowed, err := s.rbac.Evaluate(ctx, action.For(string(locker.Key(name))))
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to evaluate lock permissions: %v", err)
+	}
+	if !allowed {
+		s.log.Warn("caller not allowed to use lock", slog.String("lock", name))
+		return status.Error(codes.PermissionDenied, "not allowed")
+	}
+	return nil
+}
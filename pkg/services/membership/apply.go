@@ -28,6 +28,7 @@ import (
 	"google.golang.org/grpc/status"
 
 	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/storage"
 	"github.com/webmeshproj/webmesh/pkg/storage/providers/raftstorage"
 )
 
@@ -45,8 +46,8 @@ func (s *Server) Apply(ctx context.Context, log *v1.RaftLogEntry) (*v1.RaftApply
 	if !provider.Consensus().IsLeader() {
 		return nil, status.Errorf(codes.FailedPrecondition, "not leader")
 	}
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	// Forwarded writes are not serialized here so that the provider can
+	// batch concurrent entries into a single raft commit.
 	peer, ok := peer.FromContext(ctx)
 	if !ok {
 		return nil, status.Errorf(codes.FailedPrecondition, "no peer")
@@ -68,5 +69,11 @@ func (s *Server) Apply(ctx context.Context, log *v1.RaftLogEntry) (*v1.RaftApply
 	if !found {
 		return nil, status.Errorf(codes.FailedPrecondition, "peer not found in configuration")
 	}
-	return provider.ApplyRaftLog(ctx, log)
+	resp, err := provider.ApplyRaftLog(ctx, log)
+	if err != nil {
+		return nil, err
+	}
+	// Let the follower wait for the entry before it reports the write done.
+	storage.SendAppliedIndex(ctx, provider)
+	return resp, nil
 }
@@ -26,6 +26,7 @@ import (
 	"github.com/webmeshproj/webmesh/pkg/context"
 	"github.com/webmeshproj/webmesh/pkg/services/leaderproxy"
 	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/presence"
 	"github.com/webmeshproj/webmesh/pkg/storage/types"
 )
 
@@ -88,6 +89,10 @@ func (s *Server) Leave(ctx context.Context, req *v1.LeaveRequest) (*v1.LeaveResp
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "failed to delete peer: %v", err)
 	}
+	err = presence.New(s.storage.MeshStorage()).Delete(ctx, types.NodeID(req.GetId()))
+	if err != nil {
+		s.log.Warn("Failed to delete presence lease", slog.String("error", err.Error()))
+	}
 
 	go func() {
 		// Notify any watching plugins
@@ -21,6 +21,7 @@ import (
 	"fmt"
 	"log/slog"
 	"net/netip"
+	"slices"
 	"sync"
 
 	v1 "github.com/webmeshproj/api/go/v1"
@@ -115,7 +116,23 @@ Routes:
 		if err != nil {
 			return true, fmt.Errorf("put route for node %q: %w", nodeID, err)
 		}
-		break
+		return false, nil
+	}
+	// Drop any routes the node no longer advertises from its auto route.
+	for _, r := range current {
+		if r.GetName() != nodeAutoRoute(nodeID) || len(routes) == 0 {
+			continue
+		}
+		for _, cidr := range r.DestinationCIDRs {
+			if !slices.Contains(routes, cidr) {
+				s.log.Debug("Removing stale routes for node", "node", nodeID, "route", cidr)
+				r.DestinationCIDRs = routes
+				if err := nw.PutRoute(ctx, r); err != nil {
+					return false, fmt.Errorf("put route for node %q: %w", nodeID, err)
+				}
+				break
+			}
+		}
 	}
 	return false, nil
 }
@@ -19,16 +19,20 @@ package membership
 import (
 	"log/slog"
 	"slices"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	v1 "github.com/webmeshproj/api/go/v1"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 
 	"github.com/webmeshproj/webmesh/pkg/context"
 	"github.com/webmeshproj/webmesh/pkg/meshnet"
 	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
 	"github.com/webmeshproj/webmesh/pkg/storage/types"
 )
 
@@ -58,15 +62,26 @@ func (s *Server) SubscribePeers(req *v1.SubscribePeersRequest, stream v1.Members
 
 	log.Debug("Received subscribe peers request for peer", slog.String("peer", peerID.String()))
 
+	// Callers resuming from a revision already hold the configuration as of that
+	// revision, so it is only sent again once something changed since.
+	revision, resumed, err := storage.WatchRevisionFromContext(ctx)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
 	var lastIceServers []string
 	var lastDnsServers []string
 	var lastConfig []*v1.WireGuardPeer
+	var sent bool
 
 	var notifymu sync.Mutex
-	notify := func([]types.MeshNode) {
+	notify := func(changed bool) {
 		log.Debug("Checking for wireguard peers changes for remote peer")
 		notifymu.Lock()
 		defer notifymu.Unlock()
+		if resumed && !sent && !changed {
+			return
+		}
 		iceNegServers, err := listICEServers(ctx, db, peerID)
 		if err != nil {
 			log.Error("failed to get ice negotiation servers", "error", err.Error())
@@ -84,7 +99,7 @@ func (s *Server) SubscribePeers(req *v1.SubscribePeersRequest, stream v1.Members
 		}
 		slices.Sort(iceNegServers)
 		slices.Sort(dnsServers)
-		if len(lastConfig) > 0 {
+		if sent {
 			if slices.Equal(lastIceServers, iceNegServers) && slices.Equal(lastDnsServers, dnsServers) && types.WireGuardPeersEqual(lastConfig, peers) {
 				log.Debug("Skipping wireguard peers notification, no changes")
 				return
@@ -104,22 +119,93 @@ func (s *Server) SubscribePeers(req *v1.SubscribePeersRequest, stream v1.Members
 			log.Error("Failed to send wireguard peers", "error", err.Error())
 			return
 		}
+		sent = true
 	}
 
-	subCancel, err := s.storage.MeshDB().Peers().Subscribe(ctx, notify)
+	st, ok := s.storage.MeshStorage().(storage.WatchStorage)
+	if !ok {
+		if resumed {
+			return status.Error(codes.Unimplemented, errors.ErrWatchNotSupported.Error())
+		}
+		subCancel, err := s.storage.MeshDB().Peers().Subscribe(ctx, func([]types.MeshNode) { notify(true) })
+		if err != nil {
+			return status.Errorf(codes.Internal, "failed to subscribe to node changes: %v", err)
+		}
+		defer subCancel()
+		return notifyPeersLoop(ctx, notify, nil)
+	}
+	if !resumed {
+		revision, err = st.Revision(ctx)
+		if err != nil {
+			return status.Errorf(codes.Internal, "failed to get revision: %v", err)
+		}
+	}
+	err = stream.SendHeader(metadata.Pairs(storage.RevisionMeta, strconv.FormatUint(revision, 10)))
 	if err != nil {
-		return status.Errorf(codes.Internal, "failed to subscribe to node changes: %v", err)
+		return err
 	}
-	defer subCancel()
+	// Only node and edge changes are pushed right away. Other registry changes,
+	// such as routes and network ACLs, are picked up on the next tick.
+	var pending atomic.Bool
+	onEvent := func(ev storage.WatchEvent) {
+		if storage.NodesPrefix.Contains(ev.Key) || storage.EdgesPrefix.Contains(ev.Key) {
+			notify(true)
+			return
+		}
+		pending.Store(true)
+	}
+	watch := func(revision uint64) (<-chan error, error) {
+		done, err := st.Watch(ctx, types.RegistryPrefix, revision, onEvent)
+		if errors.Is(err, errors.ErrCompacted) {
+			// The caller missed changes that are no longer in history,
+			// a full configuration brings it back in sync.
+			log.Debug("Watch revision was compacted, sending full configuration", slog.Uint64("revision", revision))
+			notify(true)
+			done, err = st.Watch(ctx, types.RegistryPrefix, 0, onEvent)
+		}
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to watch node changes: %v", err)
+		}
+		return done, nil
+	}
+	tick := func(changed bool) { notify(changed || pending.Swap(false)) }
+	return notifyPeersLoop(ctx, tick, func() (<-chan error, error) {
+		done, err := watch(revision)
+		// Restarted watches pick up from the latest revision.
+		revision = 0
+		return done, err
+	})
+}
 
+// notifyPeersLoop periodically checks for changes to the configuration of a
+// peer until the context is done. If watch is not nil, it is restarted from
+// the latest revision whenever it falls behind the history.
+func notifyPeersLoop(ctx context.Context, notify func(changed bool), watch func() (<-chan error, error)) error {
+	var done <-chan error
+	if watch != nil {
+		var err error
+		done, err = watch()
+		if err != nil {
+			return err
+		}
+	}
 	t := time.NewTicker(time.Second * 5)
 	defer t.Stop()
 	for {
 		select {
 		case <-ctx.Done():
 			return nil
+		case err, ok := <-done:
+			if !ok || err == nil {
+				return nil
+			}
+			notify(true)
+			done, err = watch()
+			if err != nil {
+				return err
+			}
 		case <-t.C:
-			notify(nil)
+			notify(false)
 		}
 	}
 }
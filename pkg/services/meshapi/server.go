@@ -28,6 +28,7 @@ import (
 
 	"github.com/webmeshproj/webmesh/pkg/storage"
 	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/rpcsrv"
 	"github.com/webmeshproj/webmesh/pkg/storage/types"
 )
 
@@ -35,15 +36,21 @@ import (
 type Server struct {
 	v1.UnimplementedMeshServer
 
-	storage storage.MeshDB
+	provider storage.Provider
+	storage  storage.MeshDB
 }
 
-// NewServer returns a new Server.
-func NewServer(storage storage.MeshDB) *Server {
-	return &Server{storage: storage}
+// NewServer returns a new Server. GetNode and ListNodes honor the read
+// consistency requested by callers.
+func NewServer(provider storage.Provider) *Server {
+	return &Server{provider: provider, storage: provider.MeshDB()}
 }
 
 func (s *Server) GetNode(ctx context.Context, req *v1.GetNodeRequest) (*v1.MeshNode, error) {
+	if err := rpcsrv.WaitForRead(ctx, s.provider); err != nil {
+		return nil, err
+	}
+	storage.SendAppliedIndex(ctx, s.provider)
 	node, err := s.storage.Peers().Get(ctx, types.NodeID(req.GetId()))
 	if err != nil {
 		if errors.IsNodeNotFound(err) {
@@ -55,6 +62,10 @@ func (s *Server) GetNode(ctx context.Context, req *v1.GetNodeRequest) (*v1.MeshN
 }
 
 func (s *Server) ListNodes(ctx context.Context, req *emptypb.Empty) (*v1.NodeList, error) {
+	if err := rpcsrv.WaitForRead(ctx, s.provider); err != nil {
+		return nil, err
+	}
+	storage.SendAppliedIndex(ctx, s.provider)
 	nodes, err := s.storage.Peers().List(ctx)
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "failed to get node: %v", err)
@@ -0,0 +1,202 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshdns
+
+import (
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+)
+
+const (
+	// maxNegativeTTL caps how long negative answers are cached (RFC 2308 section 5).
+	maxNegativeTTL = 5 * time.Minute
+	// staleTTL is the TTL of records served from an expired entry (RFC 8767 section 4).
+	staleTTL = 30
+	// prefetchWindow is the fraction of its original TTL an entry must be in
+	// before it is prefetched.
+	prefetchWindow = 0.1
+)
+
+type cacheKey struct {
+	qname  string
+	qtype  uint16
+	qclass uint16
+}
+
+func newCacheKey(q dns.Question) cacheKey {
+	return cacheKey{dns.CanonicalName(q.Name), q.Qtype, q.Qclass}
+}
+
+type cacheValue struct {
+	msg      *dns.Msg
+	stored   time.Time
+	expires  time.Time
+	negative bool
+	hits     atomic.Int64
+}
+
+// newCacheValue returns the cache entry for a forwarded response. Positive
+// answers are cached for their lowest TTL and negative answers for the TTL
+// of the SOA in the authority section. False is returned for responses
+// that must not be cached.
+func newCacheValue(m *dns.Msg) (*cacheValue, bool) {
+	now := time.Now()
+	val := &cacheValue{msg: m.Copy(), stored: now}
+	switch {
+	case m.Rcode == dns.RcodeSuccess && len(m.Answer) > 0:
+		ttl := m.Answer[0].Header().Ttl
+		for _, rr := range m.Answer[1:] {
+			ttl = min(ttl, rr.Header().Ttl)
+		}
+		if ttl == 0 {
+			return nil, false
+		}
+		val.expires = now.Add(time.Duration(ttl) * time.Second)
+	case m.Rcode == dns.RcodeSuccess || m.Rcode == dns.RcodeNameError:
+		var soa *dns.SOA
+		for _, rr := range m.Ns {
+			if v, ok := rr.(*dns.SOA); ok {
+				soa = v
+				break
+			}
+		}
+		if soa == nil {
+			// Without an SOA there is no negative TTL to go by.
+			return nil, false
+		}
+		ttl := time.Duration(min(soa.Hdr.Ttl, soa.Minttl)) * time.Second
+		if ttl == 0 {
+			return nil, false
+		}
+		val.expires = now.Add(min(ttl, maxNegativeTTL))
+		val.negative = true
+	default:
+		return nil, false
+	}
+	return val, true
+}
+
+// response returns a copy of the cached message with TTLs lowered by the time
+// spent in the cache, or set to the stale TTL if the entry has expired.
+func (v *cacheValue) response(now time.Time) *dns.Msg {
+	m := v.msg.Copy()
+	age := uint32(now.Sub(v.stored) / time.Second)
+	stale := !now.Before(v.expires)
+	for _, section := range [][]dns.RR{m.Answer, m.Ns, m.Extra} {
+		for _, rr := range section {
+			hdr := rr.Header()
+			switch {
+			case hdr.Rrtype == dns.TypeOPT:
+			case stale:
+				hdr.Ttl = staleTTL
+			case hdr.Ttl > age:
+				hdr.Ttl -= age
+			default:
+				hdr.Ttl = 0
+			}
+		}
+	}
+	return m
+}
+
+// shouldPrefetch returns true if the entry is popular and close enough to
+// expiring that it should be refreshed ahead of time.
+func (v *cacheValue) shouldPrefetch(now time.Time, threshold int) bool {
+	if threshold <= 0 || v.hits.Load() < int64(threshold) {
+		return false
+	}
+	remaining := v.expires.Sub(now)
+	return remaining < time.Duration(float64(v.expires.Sub(v.stored))*prefetchWindow)
+}
+
+// lookupCache returns the cached response for the request if there is one that
+// can be served. Entries close to expiring and stale entries are refreshed in
+// the background. It must be called with the server lock held.
+func (s *Server) lookupCache(r *dns.Msg) (*dns.Msg, bool) {
+	key := newCacheKey(r.Question[0])
+	val, ok := s.cache.Get(key)
+	if !ok {
+		CacheLookupsTotal.WithLabelValues("miss").Inc()
+		return nil, false
+	}
+	now := time.Now()
+	switch {
+	case now.Before(val.expires):
+		val.hits.Add(1)
+		if val.negative {
+			CacheLookupsTotal.WithLabelValues("negative_hit").Inc()
+		} else {
+			CacheLookupsTotal.WithLabelValues("hit").Inc()
+		}
+		if val.shouldPrefetch(now, s.opts.PrefetchThreshold) {
+			s.refreshCache(key, r, "prefetch")
+		}
+		return val.response(now), true
+	case s.opts.ServeStale > 0 && now.Before(val.expires.Add(s.opts.ServeStale)):
+		s.log.Debug("Serving stale cached response", slog.String("name", key.qname))
+		CacheLookupsTotal.WithLabelValues("stale").Inc()
+		s.refreshCache(key, r, "stale")
+		return val.response(now), true
+	default:
+		s.log.Debug("Cached response has expired")
+		CacheLookupsTotal.WithLabelValues("miss").Inc()
+		s.cache.Remove(key)
+		return nil, false
+	}
+}
+
+// storeCache caches a forwarded response if it is cacheable.
+func (s *Server) storeCache(r, m *dns.Msg) {
+	val, ok := newCacheValue(m)
+	if !ok {
+		return
+	}
+	s.log.Debug("Caching response")
+	s.cache.Add(newCacheKey(r.Question[0]), val)
+}
+
+// refreshCache refreshes the cache entry for the request in the background.
+// Only one refresh runs at a time for each entry.
+func (s *Server) refreshCache(key cacheKey, r *dns.Msg, reason string) {
+	if _, loaded := s.refreshing.LoadOrStore(key, struct{}{}); loaded {
+		return
+	}
+	CacheRefreshesTotal.WithLabelValues(reason).Inc()
+	req := r.Copy()
+	go func() {
+		defer s.refreshing.Delete(key)
+		timeout := s.opts.RequestTimeout
+		if timeout <= 0 {
+			timeout = 5 * time.Second
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		m, err := s.forward(ctx, req)
+		if err != nil {
+			s.log.Debug("Failed to refresh cached response", slog.String("name", key.qname), slog.String("error", err.Error()))
+			return
+		}
+		s.storeCache(req, m)
+	}()
+}
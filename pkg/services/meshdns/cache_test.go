@@ -0,0 +1,121 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshdns
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestCacheValue(t *testing.T) {
+	t.Parallel()
+	req := new(dns.Msg).SetQuestion("example.com.", dns.TypeA)
+
+	t.Run("Positive", func(t *testing.T) {
+		m := new(dns.Msg).SetReply(req)
+		m.Answer = []dns.RR{
+			&dns.A{Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 300}, A: net.ParseIP("192.0.2.1")},
+			&dns.A{Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60}, A: net.ParseIP("192.0.2.2")},
+		}
+		val, ok := newCacheValue(m)
+		if !ok {
+			t.Fatal("expected response to be cacheable")
+		}
+		if ttl := val.expires.Sub(val.stored); ttl != time.Minute {
+			t.Fatalf("expected entry to expire with the lowest TTL, got %s", ttl)
+		}
+		resp := val.response(val.stored.Add(20 * time.Second))
+		for _, rr := range resp.Answer {
+			if rr.Header().Ttl != 40 && rr.Header().Ttl != 280 {
+				t.Fatalf("expected TTLs to be lowered by the entry age, got %d", rr.Header().Ttl)
+			}
+		}
+		resp = val.response(val.expires.Add(time.Second))
+		for _, rr := range resp.Answer {
+			if rr.Header().Ttl != staleTTL {
+				t.Fatalf("expected stale TTL, got %d", rr.Header().Ttl)
+			}
+		}
+	})
+
+	t.Run("Negative", func(t *testing.T) {
+		m := new(dns.Msg).SetReply(req)
+		m.Rcode = dns.RcodeNameError
+		if _, ok := newCacheValue(m); ok {
+			t.Fatal("expected negative response without SOA not to be cacheable")
+		}
+		soa := newSOARecord("example.com.")
+		soa.Hdr.Ttl = 3600
+		soa.Minttl = 120
+		m.Ns = []dns.RR{soa}
+		val, ok := newCacheValue(m)
+		if !ok {
+			t.Fatal("expected negative response to be cacheable")
+		}
+		if !val.negative {
+			t.Fatal("expected entry to be negative")
+		}
+		if ttl := val.expires.Sub(val.stored); ttl != 2*time.Minute {
+			t.Fatalf("expected entry to expire with the SOA minimum, got %s", ttl)
+		}
+		soa.Minttl = 86400
+		val, _ = newCacheValue(m)
+		if ttl := val.expires.Sub(val.stored); ttl != maxNegativeTTL {
+			t.Fatalf("expected negative TTL to be capped, got %s", ttl)
+		}
+	})
+
+	t.Run("Prefetch", func(t *testing.T) {
+		now := time.Now()
+		val := &cacheValue{stored: now, expires: now.Add(100 * time.Second)}
+		val.hits.Store(10)
+		if val.shouldPrefetch(now.Add(50*time.Second), 10) {
+			t.Fatal("expected entry not to be prefetched early")
+		}
+		if !val.shouldPrefetch(now.Add(95*time.Second), 10) {
+			t.Fatal("expected popular entry to be prefetched")
+		}
+		if val.shouldPrefetch(now.Add(95*time.Second), 20) {
+			t.Fatal("expected unpopular entry not to be prefetched")
+		}
+	})
+}
+
+func TestForwarderHealth(t *testing.T) {
+	t.Parallel()
+	var health forwarderHealth
+	health.observe("slow:53", 80*time.Millisecond, nil)
+	health.observe("fast:53", 5*time.Millisecond, nil)
+	for i := 0; i < forwarderFailureThreshold; i++ {
+		health.observe("down:53", 0, errors.New("timeout"))
+	}
+	got := health.order([]string{"down:53", "slow:53", "new:53", "fast:53"})
+	want := []string{"new:53", "fast:53", "slow:53", "down:53"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("expected order %v, got %v", want, got)
+		}
+	}
+	health.observe("down:53", time.Millisecond, nil)
+	if got := health.order([]string{"slow:53", "down:53"}); got[0] != "down:53" {
+		t.Fatalf("expected recovered forwarder to be preferred, got %v", got)
+	}
+}
@@ -19,7 +19,6 @@ package meshdns
 import (
 	"log/slog"
 	"strings"
-	"time"
 
 	"github.com/miekg/dns"
 
@@ -58,29 +57,44 @@ func (s *Server) handleDefault(ctx context.Context, w dns.ResponseWriter, r *dns
 		s.writeMsg(w, r, m, dns.RcodeNameError)
 		return
 	}
-	// Check the cache
-	cachekey := cacheKey{q.Name, q.Qtype}
 	if s.cache != nil {
-		if val, ok := s.cache.Get(cachekey); ok {
+		if m, ok := s.lookupCache(r); ok {
 			s.log.Debug("DNS Cache hit")
-			if val.expires.Before(time.Now()) {
-				// cached response has expired
-				s.log.Debug("Cached response has expired")
-				s.cache.Remove(cachekey)
-			} else {
-				// cached response is still valid
-				s.log.Debug("Cached response is still valid")
-				m := val.msg.Copy()
-				s.writeMsg(w, r, m, m.Rcode)
-				return
-			}
+			s.writeMsg(w, r, m, m.Rcode)
+			return
 		}
 	}
+	m, err := s.forward(ctx, r)
+	if err != nil {
+		s.log.Error("Failed to forward lookup", slog.String("error", err.Error()))
+		m := s.newMsg(meshDomain{}, r)
+		s.writeMsg(w, r, m, dns.RcodeServerFailure)
+		return
+	}
+	if m == nil {
+		// If no forwarder had an answer, return NXDOMAIN with our first
+		// registered mesh as the SOA.
+		m := s.newMsg(s.meshmuxes[0].meshes[0], r)
+		s.writeMsg(w, r, m, dns.RcodeNameError)
+		return
+	}
+	if s.cache != nil {
+		s.storeCache(r, m)
+	}
+	s.writeMsg(w, r, m, m.Rcode)
+}
+
+// forward sends the request to the forwarders until one answers it. The first
+// successful response is returned, or else the first NXDOMAIN response.
+// A nil message is returned if no forwarder had an answer, and an error only
+// if the context is done. It must be called with the server lock held.
+func (s *Server) forward(ctx context.Context, r *dns.Msg) (*dns.Msg, error) {
+	q := r.Question[0]
 	// determine our forwarding order
 	var forwarders []string
 	if q.Qclass == dns.ClassCHAOS {
 		// If this is a CHAOS query, only use the mesh forwarders
-		forwarders = s.allMeshForwarders()
+		forwarders = s.forwarderHealth.order(s.allMeshForwarders())
 	} else {
 		var isMeshDomain bool
 		for _, mux := range s.meshmuxes {
@@ -92,67 +106,47 @@ func (s *Server) handleDefault(ctx context.Context, w dns.ResponseWriter, r *dns
 				break
 			}
 		}
+		meshforwarders := s.forwarderHealth.order(s.allMeshForwarders())
+		extforwarders := s.forwarderHealth.order(s.extforwarders)
 		if isMeshDomain {
 			// Prioritize mesh forwarders
 			// TODO: This should filter to mesh forwarders that can match the query
-			forwarders = append(s.allMeshForwarders(), s.extforwarders...)
+			forwarders = append(meshforwarders, extforwarders...)
 		} else {
 			// Prioritize external forwarders
-			forwarders = append(s.extforwarders, s.allMeshForwarders()...)
+			forwarders = append(extforwarders, meshforwarders...)
 		}
 	}
 	cli := new(dns.Client)
 	cli.Timeout = s.opts.RequestTimeout
+	var negative *dns.Msg
 	for _, forwarder := range forwarders {
 		if ctx.Err() != nil {
-			s.log.Error("Failed to forward lookup", slog.String("error", ctx.Err().Error()))
-			m := s.newMsg(meshDomain{}, r)
-			s.writeMsg(w, r, m, dns.RcodeServerFailure)
-			return
+			return nil, ctx.Err()
 		}
 		s.log.Debug("Forwarding lookup", slog.String("forwarder", forwarder))
 		m, rtt, err := cli.ExchangeContext(ctx, r.Copy(), forwarder)
 		if err != nil {
 			if ctx.Err() != nil {
-				s.log.Error("Failed to forward lookup", slog.String("error", err.Error()))
-				m := s.newMsg(meshDomain{}, r)
-				s.writeMsg(w, r, m, dns.RcodeServerFailure)
-				return
+				return nil, err
 			}
 			// Try the next forwarder
 			s.log.Debug("Forward lookup failed", slog.String("error", err.Error()))
+			s.forwarderHealth.observe(forwarder, rtt, err)
 			continue
 		}
 		s.log.Debug("Forward lookup succeeded", slog.Duration("rtt", rtt))
+		s.forwarderHealth.observe(forwarder, rtt, nil)
 		if m.Rcode == dns.RcodeSuccess {
-			// If the forwarder returned a success response, save it in the cache and return it
 			s.log.Debug("Received success response from forwarder, returning", slog.String("forwarder", forwarder))
-			if s.cache != nil {
-				s.log.Debug("Caching response")
-				cacheValue := cacheValue{
-					msg: m.Copy(),
-					// Use the first TTL in the answer section as the cache TTL
-					expires: func() time.Time {
-						if len(m.Answer) > 0 {
-							ttl := time.Duration(m.Answer[0].Header().Ttl) * time.Second
-							if ttl > 0 {
-								return time.Now().Add(ttl)
-							}
-						}
-						// Default to 5 minutes
-						return time.Now().Add(5 * time.Minute)
-					}(),
-				}
-				s.cache.Add(cachekey, cacheValue)
-			}
-			s.writeMsg(w, r, m, m.Rcode)
-			return
+			return m, nil
+		}
+		if m.Rcode == dns.RcodeNameError && negative == nil {
+			// Keep the first negative answer in case no one else knows the name
+			negative = m
 		}
 		// If the forwarder returned a non success, try the next forwarder
 		s.log.Debug("Received non-success response, trying next forwarder", "response", m.String())
 	}
-	// If all forwarders returned NXDOMAIN, return NXDOMAIN with our first
-	// registered mesh as the SOA.
-	m := s.newMsg(s.meshmuxes[0].meshes[0], r)
-	s.writeMsg(w, r, m, dns.RcodeNameError)
+	return negative, nil
 }
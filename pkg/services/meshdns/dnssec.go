@@ -0,0 +1,331 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshdns
+
+import (
+	"crypto"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// signatureValidity is how long signatures are valid for. Responses are signed
+// as they are served, so this only needs to cover clock skew and caching.
+const signatureValidity = 24 * time.Hour
+
+// zoneKey is the signing key of a zone as kept in mesh storage. Every node serving
+// the zone signs with the same key so that one DS record covers the whole mesh.
+type zoneKey struct {
+	// DNSKEY is the public key in presentation format.
+	DNSKEY string `json:"dnskey"`
+	// PrivateKey is the private key in the BIND private-key format.
+	PrivateKey string `json:"privateKey"`
+}
+
+// zoneSigner signs responses for a zone.
+type zoneSigner struct {
+	zone   string
+	key    *dns.DNSKEY
+	ds     *dns.DS
+	signer crypto.Signer
+}
+
+// zoneSigners are the signers for the zones served by the server.
+type zoneSigners struct {
+	signers map[string]*zoneSigner
+	mu      sync.RWMutex
+}
+
+func (z *zoneSigners) set(zone string, signer *zoneSigner) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	if z.signers == nil {
+		z.signers = make(map[string]*zoneSigner)
+	}
+	if signer == nil {
+		delete(z.signers, zone)
+		return
+	}
+	z.signers[zone] = signer
+}
+
+func (z *zoneSigners) get(zone string) *zoneSigner {
+	z.mu.RLock()
+	defer z.mu.RUnlock()
+	return z.signers[zone]
+}
+
+// forName returns the signer of the closest zone enclosing the given name.
+func (z *zoneSigners) forName(name string) *zoneSigner {
+	z.mu.RLock()
+	defer z.mu.RUnlock()
+	name = dns.CanonicalName(name)
+	var match *zoneSigner
+	for zone, signer := range z.signers {
+		if dns.IsSubDomain(zone, name) && (match == nil || len(zone) > len(match.zone)) {
+			match = signer
+		}
+	}
+	return match
+}
+
+// subscribeZoneKey loads the signing key for the given mesh domain and keeps it up
+// to date. A key is generated when the mesh does not have one yet. Only storage
+// voters can write it, other nodes start signing once a voter has done so.
+func (s *Server) subscribeZoneKey(mux *meshLookupMux, dom meshDomain) error {
+	zone := dns.CanonicalName(dom.domain)
+	keyPath := types.MeshDNSKeysPrefix.ForString(strings.TrimSuffix(zone, "."))
+	st := dom.storage.MeshStorage()
+	load := func(data []byte) {
+		signer, err := newZoneSigner(zone, data)
+		if err != nil {
+			s.log.Error("Failed to load zone signing key", slog.String("zone", zone), slog.String("error", err.Error()))
+			return
+		}
+		s.log.Info("Loaded zone signing key", slog.String("zone", zone), slog.String("ds", signer.ds.String()))
+		s.signers.set(zone, signer)
+	}
+	ctx := context.Background()
+	data, err := st.GetValue(ctx, keyPath)
+	switch {
+	case err == nil:
+		load(data)
+	case errors.IsKeyNotFound(err):
+		data, err = generateZoneKey(zone)
+		if err != nil {
+			return fmt.Errorf("generate zone key: %w", err)
+		}
+		err = st.PutValue(ctx, keyPath, data, 0)
+		if err != nil {
+			s.log.Debug("Could not store zone signing key, waiting for a voter to create it", slog.String("error", err.Error()))
+			break
+		}
+		// Read it back in case another voter raced us.
+		if data, err = st.GetValue(ctx, keyPath); err == nil {
+			load(data)
+		}
+	default:
+		return fmt.Errorf("get zone key: %w", err)
+	}
+	cancel, err := st.Subscribe(ctx, keyPath, func(key, value []byte) {
+		if string(key) != keyPath.String() || len(value) == 0 {
+			return
+		}
+		load(value)
+	})
+	if err != nil {
+		return fmt.Errorf("subscribe to zone key: %w", err)
+	}
+	mux.cancels = append(mux.cancels, cancel)
+	return nil
+}
+
+func generateZoneKey(zone string) ([]byte, error) {
+	key := &dns.DNSKEY{
+		Hdr:       dns.RR_Header{Name: zone, Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET, Ttl: 3600},
+		Flags:     dns.ZONE | dns.SEP,
+		Protocol:  3,
+		Algorithm: dns.ECDSAP256SHA256,
+	}
+	priv, err := key.Generate(256)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(zoneKey{
+		DNSKEY:     key.String(),
+		PrivateKey: key.PrivateKeyString(priv),
+	})
+}
+
+func newZoneSigner(zone string, data []byte) (*zoneSigner, error) {
+	var stored zoneKey
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, err
+	}
+	rr, err := dns.NewRR(stored.DNSKEY)
+	if err != nil {
+		return nil, fmt.Errorf("parse dnskey: %w", err)
+	}
+	key, ok := rr.(*dns.DNSKEY)
+	if !ok {
+		return nil, fmt.Errorf("stored record is not a DNSKEY: %s", rr)
+	}
+	priv, err := key.NewPrivateKey(stored.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("parse private key: %w", err)
+	}
+	signer, ok := priv.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type %T", priv)
+	}
+	return &zoneSigner{
+		zone:   zone,
+		key:    key,
+		ds:     key.ToDS(dns.SHA256),
+		signer: signer,
+	}, nil
+}
+
+// signResponses signs responses for zones with a signing key when the client
+// sets the DNSSEC OK bit.
+func (s *Server) signResponses(next dns.HandlerFunc) dns.HandlerFunc {
+	return func(w dns.ResponseWriter, r *dns.Msg) {
+		if opt := r.IsEdns0(); opt == nil || !opt.Do() {
+			next(w, r)
+			return
+		}
+		signer := s.signers.forName(r.Question[0].Name)
+		if signer == nil {
+			next(w, r)
+			return
+		}
+		next(&signingWriter{ResponseWriter: w, req: r, signer: signer, log: s.log}, r)
+	}
+}
+
+type signingWriter struct {
+	dns.ResponseWriter
+	req    *dns.Msg
+	signer *zoneSigner
+	log    *slog.Logger
+}
+
+func (w *signingWriter) WriteMsg(m *dns.Msg) error {
+	if err := w.signer.sign(w.req, m); err != nil {
+		w.log.Error("Failed to sign DNS response", slog.String("error", err.Error()))
+		m.Rcode = dns.RcodeServerFailure
+		m.Answer, m.Ns, m.Extra = nil, nil, nil
+	}
+	if opt := m.IsEdns0(); opt != nil {
+		opt.SetDo()
+	} else {
+		m.SetEdns0(dns.DefaultMsgSize, true)
+	}
+	return w.ResponseWriter.WriteMsg(m)
+}
+
+// sign signs the records in the response belonging to the zone. Negative answers
+// are turned into NODATA responses covered by a minimal NSEC record for the name
+// in the question, which does not require knowing the rest of the zone.
+func (z *zoneSigner) sign(req, m *dns.Msg) error {
+	q := req.Question[0]
+	name := dns.CanonicalName(q.Name)
+	if m.Rcode == dns.RcodeNameError || (m.Rcode == dns.RcodeSuccess && len(m.Answer) == 0) {
+		covered := []uint16{dns.TypeRRSIG, dns.TypeNSEC}
+		if m.Rcode == dns.RcodeSuccess {
+			// The name exists, so deny only the type that was asked for.
+			covered = recordTypes(z.zone, name, q.Qtype)
+		}
+		m.Rcode = dns.RcodeSuccess
+		m.Ns = []dns.RR{z.soa(), &dns.NSEC{
+			Hdr:        dns.RR_Header{Name: name, Rrtype: dns.TypeNSEC, Class: dns.ClassINET, Ttl: 1},
+			NextDomain: "\\000." + name,
+			TypeBitMap: covered,
+		}}
+	}
+	var err error
+	if m.Answer, err = z.signSection(m.Answer); err != nil {
+		return err
+	}
+	if m.Ns, err = z.signSection(m.Ns); err != nil {
+		return err
+	}
+	m.Extra, err = z.signSection(m.Extra)
+	return err
+}
+
+// signSection appends signatures for each RRset in the section that belongs
+// to the zone.
+func (z *zoneSigner) signSection(section []dns.RR) ([]dns.RR, error) {
+	type rrsetKey struct {
+		name  string
+		rtype uint16
+	}
+	var order []rrsetKey
+	rrsets := make(map[rrsetKey][]dns.RR)
+	for _, rr := range section {
+		hdr := rr.Header()
+		if hdr.Rrtype == dns.TypeOPT || hdr.Rrtype == dns.TypeRRSIG || !dns.IsSubDomain(z.zone, dns.CanonicalName(hdr.Name)) {
+			continue
+		}
+		key := rrsetKey{dns.CanonicalName(hdr.Name), hdr.Rrtype}
+		if _, ok := rrsets[key]; !ok {
+			order = append(order, key)
+		}
+		rrsets[key] = append(rrsets[key], rr)
+	}
+	now := time.Now()
+	for _, key := range order {
+		sig := &dns.RRSIG{
+			Hdr:        dns.RR_Header{Ttl: rrsets[key][0].Header().Ttl},
+			KeyTag:     z.key.KeyTag(),
+			SignerName: z.zone,
+			Algorithm:  z.key.Algorithm,
+			Inception:  uint32(now.Add(-time.Hour).Unix()),
+			Expiration: uint32(now.Add(signatureValidity).Unix()),
+		}
+		if err := sig.Sign(z.signer, rrsets[key]); err != nil {
+			return nil, fmt.Errorf("sign %s %s: %w", key.name, dns.TypeToString[key.rtype], err)
+		}
+		section = append(section, sig)
+	}
+	return section, nil
+}
+
+func (z *zoneSigner) soa() *dns.SOA {
+	return newSOARecord(z.zone)
+}
+
+// recordTypes returns the types that may exist for a name in a mesh zone,
+// excluding the given type.
+func recordTypes(zone, name string, except uint16) []uint16 {
+	all := []uint16{dns.TypeA, dns.TypeTXT, dns.TypeAAAA, dns.TypeRRSIG, dns.TypeNSEC}
+	if name == zone {
+		all = []uint16{dns.TypeNS, dns.TypeSOA, dns.TypeRRSIG, dns.TypeNSEC, dns.TypeDNSKEY}
+	}
+	var out []uint16
+	for _, t := range all {
+		if t != except {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+// newSOARecord returns the SOA record for a mesh zone.
+func newSOARecord(zone string) *dns.SOA {
+	zone = dns.CanonicalName(zone)
+	return &dns.SOA{
+		Hdr:     dns.RR_Header{Name: zone, Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: 1},
+		Ns:      zone,
+		Mbox:    "hostmaster." + zone,
+		Serial:  uint32(time.Now().Unix()),
+		Refresh: 3600,
+		Retry:   600,
+		Expire:  86400,
+		Minttl:  1,
+	}
+}
@@ -0,0 +1,103 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshdns
+
+import (
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestZoneSigner(t *testing.T) {
+	t.Parallel()
+	zone := "webmesh.internal."
+	data, err := generateZoneKey(zone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := newZoneSigner(zone, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signer.ds.KeyTag != signer.key.KeyTag() {
+		t.Fatalf("expected DS to reference key tag %d, got %d", signer.key.KeyTag(), signer.ds.KeyTag)
+	}
+
+	t.Run("SignsAnswers", func(t *testing.T) {
+		req := new(dns.Msg).SetQuestion("Node-A.webmesh.internal.", dns.TypeA)
+		m := new(dns.Msg).SetReply(req)
+		m.Answer = append(m.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: "node-a.webmesh.internal.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 1},
+			A:   net.ParseIP("172.16.0.1"),
+		})
+		// Records outside the zone are left alone
+		m.Extra = append(m.Extra, &dns.A{
+			Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 1},
+			A:   net.ParseIP("192.0.2.1"),
+		})
+		if err := signer.sign(req, m); err != nil {
+			t.Fatal(err)
+		}
+		if len(m.Answer) != 2 {
+			t.Fatalf("expected answer and signature, got %v", m.Answer)
+		}
+		sig, ok := m.Answer[1].(*dns.RRSIG)
+		if !ok {
+			t.Fatalf("expected RRSIG, got %T", m.Answer[1])
+		}
+		if err := sig.Verify(signer.key, m.Answer[:1]); err != nil {
+			t.Fatalf("signature does not verify: %v", err)
+		}
+		if len(m.Extra) != 1 {
+			t.Fatalf("expected out of zone records to be unsigned, got %v", m.Extra)
+		}
+	})
+
+	t.Run("DeniesMissingNames", func(t *testing.T) {
+		req := new(dns.Msg).SetQuestion("missing.webmesh.internal.", dns.TypeA)
+		m := new(dns.Msg).SetReply(req)
+		m.Rcode = dns.RcodeNameError
+		if err := signer.sign(req, m); err != nil {
+			t.Fatal(err)
+		}
+		if m.Rcode != dns.RcodeSuccess {
+			t.Fatalf("expected NODATA response, got %s", dns.RcodeToString[m.Rcode])
+		}
+		var nsec *dns.NSEC
+		var sigs int
+		for _, rr := range m.Ns {
+			switch v := rr.(type) {
+			case *dns.NSEC:
+				nsec = v
+			case *dns.RRSIG:
+				sigs++
+			}
+		}
+		if nsec == nil || nsec.Hdr.Name != "missing.webmesh.internal." {
+			t.Fatalf("expected NSEC for the missing name, got %v", m.Ns)
+		}
+		for _, rtype := range nsec.TypeBitMap {
+			if rtype == dns.TypeA {
+				t.Fatal("expected NSEC not to assert the denied type")
+			}
+		}
+		if sigs != 2 {
+			t.Fatalf("expected SOA and NSEC to be signed, got %d signatures", sigs)
+		}
+	})
+}
@@ -0,0 +1,118 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshdns
+
+import (
+	"encoding/base64"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+
+	"github.com/miekg/dns"
+)
+
+// dohPath is the path DNS-over-HTTPS requests are served on (RFC 8484).
+const dohPath = "/dns-query"
+
+// dohContentType is the media type of DNS-over-HTTPS messages.
+const dohContentType = "application/dns-message"
+
+// dohHandler returns an HTTP handler serving DNS-over-HTTPS requests with the given
+// DNS handler. Both the GET and POST forms of RFC 8484 are supported.
+func (s *Server) dohHandler(next dns.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var data []byte
+		var err error
+		switch r.Method {
+		case http.MethodGet:
+			data, err = base64.RawURLEncoding.DecodeString(r.URL.Query().Get("dns"))
+		case http.MethodPost:
+			if r.Header.Get("Content-Type") != dohContentType {
+				http.Error(w, "unsupported content type", http.StatusUnsupportedMediaType)
+				return
+			}
+			data, err = io.ReadAll(io.LimitReader(r.Body, dns.MaxMsgSize))
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if err != nil || len(data) == 0 {
+			http.Error(w, "invalid dns message", http.StatusBadRequest)
+			return
+		}
+		req := new(dns.Msg)
+		if err := req.Unpack(data); err != nil {
+			http.Error(w, "invalid dns message", http.StatusBadRequest)
+			return
+		}
+		local, _ := r.Context().Value(http.LocalAddrContextKey).(net.Addr)
+		rw := &dohResponseWriter{local: local, remote: tcpAddrOf(r.RemoteAddr)}
+		next(rw, req)
+		if rw.msg == nil {
+			http.Error(w, "no response", http.StatusInternalServerError)
+			return
+		}
+		out, err := rw.msg.Pack()
+		if err != nil {
+			s.log.Error("Failed to pack DNS-over-HTTPS response", slog.String("error", err.Error()))
+			http.Error(w, "failed to pack response", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", dohContentType)
+		w.Header().Set("Cache-Control", "max-age=1")
+		_, _ = w.Write(out)
+	})
+}
+
+// dohResponseWriter is a dns.ResponseWriter collecting the response to a
+// DNS-over-HTTPS request.
+type dohResponseWriter struct {
+	local  net.Addr
+	remote net.Addr
+	msg    *dns.Msg
+}
+
+func (w *dohResponseWriter) LocalAddr() net.Addr  { return w.local }
+func (w *dohResponseWriter) RemoteAddr() net.Addr { return w.remote }
+
+func (w *dohResponseWriter) WriteMsg(m *dns.Msg) error {
+	w.msg = m
+	return nil
+}
+
+func (w *dohResponseWriter) Write(b []byte) (int, error) {
+	m := new(dns.Msg)
+	if err := m.Unpack(b); err != nil {
+		return 0, err
+	}
+	w.msg = m
+	return len(b), nil
+}
+
+func (w *dohResponseWriter) Close() error        { return nil }
+func (w *dohResponseWriter) TsigStatus() error   { return nil }
+func (w *dohResponseWriter) TsigTimersOnly(bool) {}
+func (w *dohResponseWriter) Hijack()             {}
+
+func tcpAddrOf(addr string) net.Addr {
+	tcpAddr, err := net.ResolveTCPAddr("tcp", addr)
+	if err != nil {
+		return &net.TCPAddr{}
+	}
+	return tcpAddr
+}
@@ -0,0 +1,171 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshdns
+
+import (
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// subscribeFederations keeps the federated domains of the given mesh in sync
+// with its federations. Domains of federations are removed when their bundle
+// expires. It must be called with the server lock held.
+func (s *Server) subscribeFederations(mux *meshLookupMux, dom meshDomain) error {
+	fed, ok := storage.FederationOf(dom.storage.MeshDB())
+	if !ok {
+		return nil
+	}
+	list := func() []types.Federation {
+		feds, err := fed.ListFederations(context.Background())
+		if err != nil {
+			s.log.Warn("Failed to list federations", slog.String("error", err.Error()))
+			return nil
+		}
+		return feds
+	}
+	var (
+		expiry   *time.Timer
+		canceled bool
+		expiryMu sync.Mutex
+	)
+	// resync must be called with the server lock held.
+	var resync func(feds []types.Federation)
+	resync = func(feds []types.Federation) {
+		now := time.Now()
+		var active []types.Federation
+		var next time.Time
+		for _, f := range feds {
+			if f.Expired(now) {
+				continue
+			}
+			active = append(active, f)
+			if expires := f.Bundle.Expires; !expires.IsZero() && (next.IsZero() || expires.Before(next)) {
+				next = expires
+			}
+		}
+		expiryMu.Lock()
+		defer expiryMu.Unlock()
+		if canceled {
+			return
+		}
+		s.syncFederations(dom.domain, active)
+		if expiry != nil {
+			expiry.Stop()
+			expiry = nil
+		}
+		if !next.IsZero() {
+			expiry = time.AfterFunc(time.Until(next)+time.Second, func() {
+				feds := list()
+				s.mu.Lock()
+				defer s.mu.Unlock()
+				resync(feds)
+			})
+		}
+	}
+	resync(list())
+	cancel, err := dom.storage.MeshStorage().Subscribe(context.Background(), types.FederationsPrefix, func(_, _ []byte) {
+		feds := list()
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		resync(feds)
+	})
+	if err != nil {
+		return err
+	}
+	mux.cancels = append(mux.cancels, cancel, func() {
+		expiryMu.Lock()
+		defer expiryMu.Unlock()
+		canceled = true
+		if expiry != nil {
+			expiry.Stop()
+		}
+	})
+	return nil
+}
+
+// syncFederations updates the federated domains served on behalf of the given
+// mesh domain. It must be called with the server lock held.
+func (s *Server) syncFederations(meshDomain string, feds []types.Federation) {
+	servers := make(map[string][]string, len(feds))
+	for _, fed := range feds {
+		if dnsServers := fed.Bundle.DNSServers(); len(dnsServers) > 0 {
+			servers[dns.Fqdn(fed.Domain())] = dnsServers
+		}
+	}
+	s.federations[meshDomain] = servers
+	// Recompute the handled domains across all meshes.
+	current := make(map[string][]string)
+	for _, domains := range s.federations {
+		for domain, fwds := range domains {
+			for _, fwd := range fwds {
+				if !slices.Contains(current[domain], fwd) {
+					current[domain] = append(current[domain], fwd)
+				}
+			}
+		}
+	}
+	for domain := range s.federated {
+		if _, ok := current[domain]; !ok {
+			s.log.Info("Removing federated domain", slog.String("domain", domain))
+			s.mux.HandleRemove(domain)
+		}
+	}
+	for domain, fwds := range current {
+		if _, ok := s.federated[domain]; !ok {
+			s.log.Info("Adding federated domain", slog.String("domain", domain), slog.Any("servers", fwds))
+			s.mux.HandleFunc(domain, s.contextHandler(s.handleFederatedLookup(domain)))
+		}
+	}
+	s.federated = current
+}
+
+// handleFederatedLookup returns a handler forwarding lookups in a federated
+// domain to the MeshDNS servers of the federated mesh.
+func (s *Server) handleFederatedLookup(domain string) contextDNSHandler {
+	return func(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) {
+		s.mu.RLock()
+		forwarders := s.federated[domain]
+		s.mu.RUnlock()
+		cli := new(dns.Client)
+		cli.Timeout = s.opts.RequestTimeout
+		for _, forwarder := range forwarders {
+			s.log.Debug("Forwarding federated lookup", slog.String("forwarder", forwarder))
+			m, _, err := cli.ExchangeContext(ctx, r.Copy(), forwarder)
+			if err != nil {
+				if ctx.Err() != nil {
+					break
+				}
+				s.log.Debug("Federated lookup failed", slog.String("error", err.Error()))
+				continue
+			}
+			if m.Rcode == dns.RcodeSuccess || m.Rcode == dns.RcodeNameError {
+				s.writeMsg(w, r, m, m.Rcode)
+				return
+			}
+		}
+		m := s.newMsg(meshDomain{}, r)
+		s.writeMsg(w, r, m, dns.RcodeServerFailure)
+	}
+}
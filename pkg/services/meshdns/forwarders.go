@@ -0,0 +1,120 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshdns
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	// forwarderFailureThreshold is the number of consecutive failures after
+	// which a forwarder is backed off.
+	forwarderFailureThreshold = 3
+	// forwarderMinBackoff is the initial time a failing forwarder is backed off for.
+	forwarderMinBackoff = 5 * time.Second
+	// forwarderMaxBackoff is the maximum time a failing forwarder is backed off for.
+	forwarderMaxBackoff = 2 * time.Minute
+	// forwarderRTTWeight is the weight of a new sample in the smoothed round trip time.
+	forwarderRTTWeight = 0.3
+)
+
+// forwarderHealth tracks the latency and failures of forwarders so that
+// lookups go to the fastest healthy forwarder first.
+type forwarderHealth struct {
+	stats map[string]*forwarderStats
+	mu    sync.Mutex
+}
+
+type forwarderStats struct {
+	// rtt is the smoothed round trip time of successful lookups.
+	rtt time.Duration
+	// failures is the number of consecutive failed lookups.
+	failures int
+	// downUntil is the time the forwarder is backed off until.
+	downUntil time.Time
+}
+
+// observe records the result of a lookup sent to the forwarder.
+func (f *forwarderHealth) observe(addr string, rtt time.Duration, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.stats == nil {
+		f.stats = make(map[string]*forwarderStats)
+	}
+	st, ok := f.stats[addr]
+	if !ok {
+		st = &forwarderStats{}
+		f.stats[addr] = st
+	}
+	if err != nil {
+		ForwarderErrorsTotal.WithLabelValues(addr).Inc()
+		st.failures++
+		if st.failures >= forwarderFailureThreshold {
+			backoff := forwarderMinBackoff << min(st.failures-forwarderFailureThreshold, 5)
+			st.downUntil = time.Now().Add(min(backoff, forwarderMaxBackoff))
+			ForwarderUp.WithLabelValues(addr).Set(0)
+		}
+		return
+	}
+	ForwarderRequestDuration.WithLabelValues(addr).Observe(rtt.Seconds())
+	ForwarderUp.WithLabelValues(addr).Set(1)
+	st.failures = 0
+	st.downUntil = time.Time{}
+	if st.rtt == 0 {
+		st.rtt = rtt
+	} else {
+		st.rtt = time.Duration(forwarderRTTWeight*float64(rtt) + (1-forwarderRTTWeight)*float64(st.rtt))
+	}
+}
+
+// order returns the forwarders sorted with healthy forwarders first, fastest
+// first. Forwarders without samples sort with the fastest so they get probed.
+// Backed off forwarders are still returned last in case nothing else answers.
+func (f *forwarderHealth) order(forwarders []string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	now := time.Now()
+	out := slices.Clone(forwarders)
+	slices.SortStableFunc(out, func(a, b string) int {
+		sa, sb := f.stats[a], f.stats[b]
+		downA := sa != nil && now.Before(sa.downUntil)
+		downB := sb != nil && now.Before(sb.downUntil)
+		if downA != downB {
+			if downA {
+				return 1
+			}
+			return -1
+		}
+		var rttA, rttB time.Duration
+		if sa != nil {
+			rttA = sa.rtt
+		}
+		if sb != nil {
+			rttB = sb.rtt
+		}
+		switch {
+		case rttA < rttB:
+			return -1
+		case rttA > rttB:
+			return 1
+		}
+		return 0
+	})
+	return out
+}
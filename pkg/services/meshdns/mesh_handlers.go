@@ -55,6 +55,8 @@ type meshDomain struct {
 	domain   string
 	storage  storage.Provider
 	ipv6Only bool
+	iface    string
+	view     *meshView
 }
 
 func (s *Server) newMeshLookupMux(dom meshDomain) *meshLookupMux {
@@ -82,11 +84,25 @@ func (s *meshLookupMux) appendMesh(dom meshDomain) {
 func (s *meshLookupMux) handleMeshLookup(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) {
 	s.mu.RLock()
 	s.log.Debug("Handling mesh lookup")
-	for _, mesh := range s.meshes {
+	view := s.viewFor(ctx, w)
+	// Custom records take precedence over the records of nodes.
+	if answer, ok := s.records.lookup(r.Question[0].Name, r.Question[0].Qtype); ok && len(answer) > 0 {
+		m := s.newMsg(view.mesh, r)
+		m.Answer = append(m.Answer, answer...)
+		s.writeMsg(w, r, m, dns.RcodeSuccess)
+		s.mu.RUnlock()
+		return
+	}
+	for _, mesh := range s.ordered(view) {
 		m := s.newMsg(mesh, r)
 		lookup := strings.TrimSuffix(r.Question[0].Name, ".")
 		domain := strings.TrimSuffix(mesh.domain, ".")
 		name := strings.TrimSuffix(strings.TrimSuffix(lookup, domain), ".")
+		if name == "" {
+			s.handleApexLookup(w, r, mesh)
+			s.mu.RUnlock()
+			return
+		}
 		parts := strings.Split(name, ".")
 		if len(parts) > 1 {
 			s.log.Debug("Request is not for the root domain", slog.String("domain", mesh.domain), slog.String("name", name))
@@ -108,6 +124,21 @@ func (s *meshLookupMux) handleMeshLookup(ctx context.Context, w dns.ResponseWrit
 			return
 		}
 		nodeID := parts[0]
+		if mesh == view.mesh {
+			visible, err := s.horizonFor(ctx, view)
+			if err != nil {
+				s.log.Error("Failed to compute split-horizon view", slog.String("error", err.Error()))
+				s.writeMsg(w, r, m, dns.RcodeServerFailure)
+				s.mu.RUnlock()
+				return
+			}
+			if !visible(nodeID) {
+				s.log.Debug("Node is not visible to the querier", slog.String("node-id", nodeID))
+				s.writeMsg(w, r, m, dns.RcodeNameError)
+				s.mu.RUnlock()
+				return
+			}
+		}
 		err := s.appendPeerToMessage(ctx, mesh, r, m, nodeID, s.ipv6Only)
 		if err != nil {
 			if errors.IsNodeNotFound(err) {
@@ -132,10 +163,15 @@ func (s *meshLookupMux) handleLeaderLookup(ctx context.Context, w dns.ResponseWr
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 	s.log.Debug("Handling leader lookup")
-	// We only serve these for the first registered mesh
-	// TODO: Determine where the request came from
-	mesh := s.meshes[0]
+	view := s.viewFor(ctx, w)
+	mesh := view.mesh
 	m := s.newMsg(mesh, r)
+	visible, err := s.horizonFor(ctx, view)
+	if err != nil {
+		s.log.Error("Failed to compute split-horizon view", slog.String("error", err.Error()))
+		s.writeMsg(w, r, m, dns.RcodeServerFailure)
+		return
+	}
 	leader, err := mesh.storage.Consensus().GetLeader(ctx)
 	if err != nil {
 		s.log.Error("Failed to get leader", slog.String("error", err.Error()))
@@ -143,6 +179,10 @@ func (s *meshLookupMux) handleLeaderLookup(ctx context.Context, w dns.ResponseWr
 		return
 	}
 	nodeID := string(leader.GetId())
+	if !visible(nodeID) {
+		s.writeMsg(w, r, m, dns.RcodeNameError)
+		return
+	}
 	m.Answer = append(m.Answer, &dns.CNAME{
 		Hdr:    dns.RR_Header{Name: newFQDN(mesh, "leader"), Rrtype: dns.TypeCNAME, Class: dns.ClassINET, Ttl: 1},
 		Target: newFQDN(mesh, nodeID),
@@ -159,48 +199,72 @@ func (s *meshLookupMux) handleVotersLookup(ctx context.Context, w dns.ResponseWr
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 	s.log.Debug("Handling voters lookup")
-	// We only serve these for the first registered mesh
-	// TODO: Determine where the request came from
-	mesh := s.meshes[0]
-	m := s.newMsg(mesh, r)
-	status := mesh.storage.Status()
-	for _, server := range status.GetPeers() {
-		if status.ClusterStatus == v1.ClusterStatus_CLUSTER_VOTER {
-			m.Answer = append(m.Answer, &dns.CNAME{
-				Hdr:    dns.RR_Header{Name: newFQDN(mesh, "voters"), Rrtype: dns.TypeCNAME, Class: dns.ClassINET, Ttl: 1},
-				Target: newFQDN(mesh, server.GetId()),
-			})
-			err := s.appendPeerToMessage(ctx, mesh, r, m, server.GetId(), s.ipv6Only)
-			if err != nil {
-				s.writeMsg(w, r, m, errToRcode(err))
-				return
-			}
-		}
-	}
-	s.writeMsg(w, r, m, dns.RcodeSuccess)
+	s.handleClusterLookup(ctx, w, r, "voters", v1.ClusterStatus_CLUSTER_VOTER)
 }
 
 func (s *meshLookupMux) handleObserversLookup(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 	s.log.Debug("Handling observers lookup")
-	// We only serve these for the first registered mesh
-	// TODO: Determine where the request came from
-	mesh := s.meshes[0]
+	s.handleClusterLookup(ctx, w, r, "observers", v1.ClusterStatus_CLUSTER_OBSERVER)
+}
+
+// handleClusterLookup answers with the storage peers of the given status. It must
+// be called with the mux lock held.
+func (s *meshLookupMux) handleClusterLookup(ctx context.Context, w dns.ResponseWriter, r *dns.Msg, name string, status v1.ClusterStatus) {
+	view := s.viewFor(ctx, w)
+	mesh := view.mesh
 	m := s.newMsg(mesh, r)
-	status := mesh.storage.Status()
-	for _, server := range status.GetPeers() {
-		if server.ClusterStatus == v1.ClusterStatus_CLUSTER_OBSERVER {
-			m.Answer = append(m.Answer, &dns.CNAME{
-				Hdr:    dns.RR_Header{Name: newFQDN(mesh, "observers"), Rrtype: dns.TypeCNAME, Class: dns.ClassINET, Ttl: 1},
-				Target: newFQDN(mesh, server.GetId()),
-			})
-			err := s.appendPeerToMessage(ctx, mesh, r, m, server.GetId(), s.ipv6Only)
-			if err != nil {
-				s.writeMsg(w, r, m, errToRcode(err))
-				return
+	visible, err := s.horizonFor(ctx, view)
+	if err != nil {
+		s.log.Error("Failed to compute split-horizon view", slog.String("error", err.Error()))
+		s.writeMsg(w, r, m, dns.RcodeServerFailure)
+		return
+	}
+	for _, server := range mesh.storage.Status().GetPeers() {
+		if server.ClusterStatus != status || !visible(server.GetId()) {
+			continue
+		}
+		m.Answer = append(m.Answer, &dns.CNAME{
+			Hdr:    dns.RR_Header{Name: newFQDN(mesh, name), Rrtype: dns.TypeCNAME, Class: dns.ClassINET, Ttl: 1},
+			Target: newFQDN(mesh, server.GetId()),
+		})
+		err := s.appendPeerToMessage(ctx, mesh, r, m, server.GetId(), s.ipv6Only)
+		if err != nil {
+			s.writeMsg(w, r, m, errToRcode(err))
+			return
+		}
+	}
+	s.writeMsg(w, r, m, dns.RcodeSuccess)
+}
+
+// handleApexLookup answers queries for the root of the mesh domain.
+func (s *meshLookupMux) handleApexLookup(w dns.ResponseWriter, r *dns.Msg, mesh meshDomain) {
+	s.log.Debug("Handling apex lookup", slog.String("domain", mesh.domain))
+	m := s.newMsg(mesh, r)
+	signer := s.signers.get(dns.CanonicalName(mesh.domain))
+	for _, q := range r.Question {
+		switch q.Qtype {
+		case dns.TypeNS:
+			m.Answer = append(m.Answer, newNSRecord(mesh))
+		case dns.TypeSOA:
+			m.Answer = append(m.Answer, newSOARecord(mesh.domain))
+		case dns.TypeDNSKEY:
+			if signer != nil {
+				m.Answer = append(m.Answer, signer.key)
+			}
+		case dns.TypeDS:
+			if signer != nil {
+				m.Answer = append(m.Answer, signer.ds)
 			}
 		}
 	}
+	if len(m.Answer) == 0 {
+		answer, _ := s.records.lookup(r.Question[0].Name, r.Question[0].Qtype)
+		m.Answer = append(m.Answer, answer...)
+	}
+	if len(m.Answer) == 0 {
+		m.Ns = []dns.RR{newSOARecord(mesh.domain)}
+	}
 	s.writeMsg(w, r, m, dns.RcodeSuccess)
 }
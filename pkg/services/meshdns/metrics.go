@@ -0,0 +1,92 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshdns
+
+import (
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// MeshDNS Metrics
+var (
+	// QueriesTotal tracks the queries answered by the server by type and response code.
+	QueriesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "webmesh",
+		Name:      "meshdns_queries_total",
+		Help:      "Total DNS queries answered by type and response code.",
+	}, []string{"qtype", "rcode"})
+
+	// CacheLookupsTotal tracks lookups in the forwarder cache by result. Results
+	// are one of hit, negative_hit, stale, or miss.
+	CacheLookupsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "webmesh",
+		Name:      "meshdns_cache_lookups_total",
+		Help:      "Total lookups in the forwarder cache by result.",
+	}, []string{"result"})
+
+	// CacheRefreshesTotal tracks background refreshes of cached entries by reason.
+	// Reasons are one of prefetch or stale.
+	CacheRefreshesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "webmesh",
+		Name:      "meshdns_cache_refreshes_total",
+		Help:      "Total background refreshes of cached entries by reason.",
+	}, []string{"reason"})
+
+	// ForwarderRequestDuration tracks the round trip time of forwarded lookups.
+	ForwarderRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "webmesh",
+		Name:      "meshdns_forwarder_request_duration_seconds",
+		Help:      "Round trip time of lookups sent to forwarders.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 2, 14),
+	}, []string{"forwarder"})
+
+	// ForwarderErrorsTotal tracks lookups that failed to reach a forwarder.
+	ForwarderErrorsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "webmesh",
+		Name:      "meshdns_forwarder_errors_total",
+		Help:      "Total lookups that failed to reach a forwarder.",
+	}, []string{"forwarder"})
+
+	// ForwarderUp tracks whether a forwarder is considered healthy.
+	ForwarderUp = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "webmesh",
+		Name:      "meshdns_forwarder_up",
+		Help:      "Whether a forwarder is considered healthy (1) or backed off (0).",
+	}, []string{"forwarder"})
+)
+
+// recordQueries counts the queries answered by the server.
+func (s *Server) recordQueries(next dns.HandlerFunc) dns.HandlerFunc {
+	return func(w dns.ResponseWriter, r *dns.Msg) {
+		qtype := "NONE"
+		if r != nil && len(r.Question) > 0 {
+			qtype = dns.Type(r.Question[0].Qtype).String()
+		}
+		next(&recordingWriter{ResponseWriter: w, qtype: qtype}, r)
+	}
+}
+
+type recordingWriter struct {
+	dns.ResponseWriter
+	qtype string
+}
+
+func (w *recordingWriter) WriteMsg(m *dns.Msg) error {
+	QueriesTotal.WithLabelValues(w.qtype, dns.RcodeToString[m.Rcode]).Inc()
+	return w.ResponseWriter.WriteMsg(m)
+}
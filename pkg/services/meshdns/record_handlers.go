@@ -0,0 +1,182 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshdns
+
+import (
+	"log/slog"
+	"sync"
+
+	"github.com/miekg/dns"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/storage/dnsrecords"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// maxCNAMEChain is the maximum number of CNAMEs followed within custom records.
+const maxCNAMEChain = 8
+
+// customRecords are the custom records served on behalf of the registered meshes.
+type customRecords struct {
+	// byMesh are the records of each registered mesh domain.
+	byMesh map[string][]types.DNSRecord
+	// index are the RRsets of all meshes by canonical name and type.
+	index map[string]map[uint16][]dns.RR
+	// zones are the additional zones the records are served for.
+	zones map[string]struct{}
+	mu    sync.RWMutex
+}
+
+// lookup returns the records answering the question. The boolean is false when
+// there are no custom records with the name.
+func (c *customRecords) lookup(name string, qtype uint16) ([]dns.RR, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	name = dns.CanonicalName(name)
+	if _, ok := c.index[name]; !ok {
+		return nil, false
+	}
+	var answer []dns.RR
+	for i := 0; i < maxCNAMEChain; i++ {
+		rrsets := c.index[name]
+		if rrs, ok := rrsets[qtype]; ok {
+			return append(answer, rrs...), true
+		}
+		cname, ok := rrsets[dns.TypeCNAME]
+		if !ok {
+			break
+		}
+		answer = append(answer, cname...)
+		name = dns.CanonicalName(cname[0].(*dns.CNAME).Target)
+	}
+	return answer, true
+}
+
+// subscribeRecords keeps the custom records of the given mesh in sync with
+// storage. It must be called with the server lock held.
+func (s *Server) subscribeRecords(mux *meshLookupMux, dom meshDomain) error {
+	store := dnsrecords.New(dom.storage.MeshStorage())
+	list := func() []types.DNSRecord {
+		recs, err := store.List(context.Background())
+		if err != nil {
+			s.log.Warn("Failed to list custom dns records", slog.String("error", err.Error()))
+			return nil
+		}
+		return recs
+	}
+	s.syncRecords(dom.domain, list())
+	cancel, err := dom.storage.MeshStorage().Subscribe(context.Background(), types.DNSRecordsPrefix, func(_, _ []byte) {
+		recs := list()
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.syncRecords(dom.domain, recs)
+	})
+	if err != nil {
+		return err
+	}
+	mux.cancels = append(mux.cancels, cancel)
+	return nil
+}
+
+// syncRecords updates the custom records served on behalf of the given mesh
+// domain. It must be called with the server lock held.
+func (s *Server) syncRecords(meshDomain string, recs []types.DNSRecord) {
+	s.records.mu.Lock()
+	defer s.records.mu.Unlock()
+	if s.records.byMesh == nil {
+		s.records.byMesh = make(map[string][]types.DNSRecord)
+	}
+	if recs == nil {
+		delete(s.records.byMesh, meshDomain)
+	} else {
+		s.records.byMesh[meshDomain] = recs
+	}
+	// Rebuild the index and the set of additional zones across all meshes.
+	meshZones := make(map[string]struct{}, len(s.meshmuxes))
+	for _, mux := range s.meshmuxes {
+		meshZones[dns.CanonicalName(mux.domain)] = struct{}{}
+	}
+	index := make(map[string]map[uint16][]dns.RR)
+	zones := make(map[string]struct{})
+	for _, recs := range s.records.byMesh {
+		for _, rec := range recs {
+			rrs, err := rec.RRs()
+			if err != nil {
+				s.log.Warn("Ignoring invalid custom dns record", slog.String("name", rec.Name), slog.String("error", err.Error()))
+				continue
+			}
+			name := dns.CanonicalName(rec.Name)
+			if index[name] == nil {
+				index[name] = make(map[uint16][]dns.RR)
+			}
+			rtype := dns.StringToType[rec.Type]
+			index[name][rtype] = append(index[name][rtype], rrs...)
+			if zone := dns.CanonicalName(rec.Zone); !isMeshZone(meshZones, zone) {
+				zones[zone] = struct{}{}
+			}
+		}
+	}
+	for zone := range s.records.zones {
+		if _, ok := zones[zone]; !ok {
+			s.log.Info("Removing custom dns zone", slog.String("zone", zone))
+			s.mux.HandleRemove(zone)
+		}
+	}
+	for zone := range zones {
+		if _, ok := s.records.zones[zone]; !ok {
+			s.log.Info("Adding custom dns zone", slog.String("zone", zone))
+			s.mux.HandleFunc(zone, s.contextHandler(s.handleRecordLookup(zone)))
+		}
+	}
+	s.records.index = index
+	s.records.zones = zones
+}
+
+// isMeshZone returns true if the zone is served by a registered mesh.
+func isMeshZone(meshZones map[string]struct{}, zone string) bool {
+	for meshZone := range meshZones {
+		if dns.IsSubDomain(meshZone, zone) {
+			return true
+		}
+	}
+	return false
+}
+
+// handleRecordLookup returns a handler answering lookups in an additional zone
+// from the custom records.
+func (s *Server) handleRecordLookup(zone string) contextDNSHandler {
+	return func(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) {
+		m := s.newMsg(meshDomain{}, r)
+		q := r.Question[0]
+		if dns.CanonicalName(q.Name) == zone && q.Qtype == dns.TypeSOA {
+			m.Answer = append(m.Answer, newSOARecord(zone))
+			s.writeMsg(w, r, m, dns.RcodeSuccess)
+			return
+		}
+		answer, ok := s.records.lookup(q.Name, q.Qtype)
+		if !ok {
+			m.Ns = []dns.RR{newSOARecord(zone)}
+			s.writeMsg(w, r, m, dns.RcodeNameError)
+			return
+		}
+		m.Answer = append(m.Answer, answer...)
+		if len(answer) == 0 {
+			m.Ns = []dns.RR{newSOARecord(zone)}
+		}
+		s.writeMsg(w, r, m, dns.RcodeSuccess)
+	}
+}
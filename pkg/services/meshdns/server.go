@@ -18,9 +18,12 @@ limitations under the License.
 package meshdns
 
 import (
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net"
+	"net/http"
 	"sync"
 	"time"
 
@@ -69,6 +72,24 @@ type Options struct {
 	DisableForwarding bool
 	// CacheSize is the size of the remote DNS cache.
 	CacheSize int
+	// ServeStale is how long expired cache entries may still be served
+	// while they are refreshed in the background. Zero disables it.
+	ServeStale time.Duration
+	// PrefetchThreshold is the number of cache hits after which an entry
+	// is refreshed before it expires. Zero disables prefetching.
+	PrefetchThreshold int
+	// SplitHorizon restricts answers to the nodes the querying
+	// node can reach under the current network ACLs.
+	SplitHorizon bool
+	// DNSSEC signs the mesh zones with keys held in mesh storage.
+	DNSSEC bool
+	// TLSListenAddr is the address to listen on for DNS-over-TLS.
+	TLSListenAddr string
+	// HTTPSListenAddr is the address to listen on for DNS-over-HTTPS.
+	HTTPSListenAddr string
+	// TLSConfig is the TLS configuration for the DNS-over-TLS and
+	// DNS-over-HTTPS listeners.
+	TLSConfig *tls.Config
 }
 
 // NewServer returns a new Mesh DNS server.
@@ -81,10 +102,12 @@ func NewServer(ctx context.Context, o *Options) *Server {
 		extforwarders:  make([]string, 0),
 		meshforwarders: make(map[string][]string),
 		meshmuxes:      make([]*meshLookupMux, 0),
+		federations:    make(map[string]map[string][]string),
+		federated:      make(map[string][]string),
 	}
 	if srv.opts.CacheSize > 0 {
 		var err error
-		srv.cache, err = lru.New[cacheKey, cacheValue](srv.opts.CacheSize)
+		srv.cache, err = lru.New[cacheKey, *cacheValue](srv.opts.CacheSize)
 		if err != nil {
 			log.Warn("failed to create remote lookup cache", slog.String("error", err.Error()))
 		}
@@ -100,16 +123,24 @@ func NewServer(ctx context.Context, o *Options) *Server {
 
 // Server is the MeshDNS server.
 type Server struct {
-	opts           *Options
-	meshmuxes      []*meshLookupMux
-	mux            *dns.ServeMux
-	udpServer      *dns.Server
-	tcpServer      *dns.Server
-	extforwarders  []string
-	meshforwarders map[string][]string
-	cache          *lru.Cache[cacheKey, cacheValue]
-	log            *slog.Logger
-	mu             sync.RWMutex
+	opts            *Options
+	meshmuxes       []*meshLookupMux
+	mux             *dns.ServeMux
+	udpServer       *dns.Server
+	tcpServer       *dns.Server
+	tlsServer       *dns.Server
+	httpsServer     *http.Server
+	extforwarders   []string
+	meshforwarders  map[string][]string
+	federations     map[string]map[string][]string
+	federated       map[string][]string
+	signers         zoneSigners
+	records         customRecords
+	cache           *lru.Cache[cacheKey, *cacheValue]
+	refreshing      sync.Map
+	forwarderHealth forwarderHealth
+	log             *slog.Logger
+	mu              sync.RWMutex
 }
 
 // UpsertForwarder upserts a forwarder into the static forwarders list.
@@ -160,16 +191,6 @@ func (s *Server) ReplaceForwarders(forwarders []string) {
 	s.extforwarders = forwarders
 }
 
-type cacheKey struct {
-	qname string
-	qtype uint16
-}
-
-type cacheValue struct {
-	msg     *dns.Msg
-	expires time.Time
-}
-
 type DomainOptions struct {
 	// NodeID is the node ID to use for this domain.
 	NodeID types.NodeID
@@ -182,6 +203,10 @@ type DomainOptions struct {
 	// SubscribeForwarders indicates that new forwarders added to the mesh should be
 	// appeneded to the current server.
 	SubscribeForwarders bool
+	// Interface is the name of the interface the mesh is reachable on. Queries
+	// sourced from its networks are answered from this mesh when more than one
+	// mesh is registered for the same domain.
+	Interface string
 }
 
 // ListenPortUDP returns the UDP listen port.
@@ -213,7 +238,7 @@ func (s *Server) ListenPort() int {
 func (s *Server) ListenAndServe() error {
 	// Register the default handlers
 	s.mux.HandleFunc(".", s.contextHandler(s.handleDefault))
-	hdlr := s.validateRequest(s.denyZoneTransfers(s.mux.ServeDNS))
+	hdlr := s.recordQueries(s.validateRequest(s.denyZoneTransfers(s.signResponses(s.mux.ServeDNS))))
 	// Start the servers
 	var g errgroup.Group
 	if s.opts.UDPListenAddr != "" {
@@ -238,6 +263,35 @@ func (s *Server) ListenAndServe() error {
 			return s.tcpServer.ListenAndServe()
 		})
 	}
+	if s.opts.TLSListenAddr != "" {
+		s.tlsServer = &dns.Server{
+			Addr:      s.opts.TLSListenAddr,
+			Net:       "tcp-tls",
+			TLSConfig: s.opts.TLSConfig,
+			Handler:   hdlr,
+		}
+		g.Go(func() error {
+			s.log.Info(fmt.Sprintf("starting meshdns dns-over-tls server on %s", s.opts.TLSListenAddr))
+			return s.tlsServer.ListenAndServe()
+		})
+	}
+	if s.opts.HTTPSListenAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle(dohPath, s.dohHandler(hdlr))
+		s.httpsServer = &http.Server{
+			Addr:      s.opts.HTTPSListenAddr,
+			Handler:   mux,
+			TLSConfig: s.opts.TLSConfig,
+		}
+		g.Go(func() error {
+			s.log.Info(fmt.Sprintf("starting meshdns dns-over-https server on %s", s.opts.HTTPSListenAddr))
+			err := s.httpsServer.ListenAndServeTLS("", "")
+			if errors.Is(err, http.ErrServerClosed) {
+				return nil
+			}
+			return err
+		})
+	}
 	return g.Wait()
 }
 
@@ -264,6 +318,16 @@ func (s *Server) Shutdown(ctx context.Context) error {
 			}
 		}
 	}
+	if s.tlsServer != nil {
+		if err := s.tlsServer.Shutdown(); err != nil {
+			closeErr = errors.Join(closeErr, fmt.Errorf("tls server shutdown: %w", err))
+		}
+	}
+	if s.httpsServer != nil {
+		if err := s.httpsServer.Shutdown(ctx); err != nil {
+			closeErr = errors.Join(closeErr, fmt.Errorf("https server shutdown: %w", err))
+		}
+	}
 	return closeErr
 }
 
@@ -275,6 +339,10 @@ func (s *Server) DeregisterDomain(domain string) {
 		if mux.domain == domain {
 			s.meshmuxes = append(s.meshmuxes[:i], s.meshmuxes[i+1:]...)
 			mux.cancel()
+			s.syncFederations(domain, nil)
+			delete(s.federations, domain)
+			s.signers.set(dns.CanonicalName(domain), nil)
+			s.syncRecords(domain, nil)
 			return
 		}
 	}
@@ -289,7 +357,9 @@ func (s *Server) RegisterDomain(opts DomainOptions) error {
 		domain:   opts.MeshDomain,
 		storage:  opts.MeshStorage,
 		ipv6Only: opts.IPv6Only,
+		iface:    opts.Interface,
 	}
+	dom.view = newMeshView(context.WithLogger(context.Background(), s.log), opts.MeshStorage.MeshDB(), opts.Interface)
 	// Check if we have an overlapping domain. This is not a good way to run this,
 	// but we'll support it for test cases. A flag should maybe be exposed to cause
 	// this to error.
@@ -305,7 +375,22 @@ func (s *Server) RegisterDomain(opts DomainOptions) error {
 		mux = s.newMeshLookupMux(dom)
 		s.mux.Handle(dom.domain, mux)
 		s.meshmuxes = append(s.meshmuxes, mux)
+		if s.opts.DNSSEC {
+			// The zone is signed with the key of the first mesh registered for it.
+			if err := s.subscribeZoneKey(mux, dom); err != nil {
+				return fmt.Errorf("failed to load zone signing key: %w", err)
+			}
+		}
+		// Custom records are likewise served from the first mesh registered for the domain.
+		if err := s.subscribeRecords(mux, dom); err != nil {
+			return fmt.Errorf("failed to subscribe to custom dns records: %w", err)
+		}
 	}
+	cancel, err := dom.view.subscribe(context.Background(), dom.storage.MeshStorage())
+	if err != nil {
+		return fmt.Errorf("failed to subscribe to mesh view changes: %w", err)
+	}
+	mux.cancels = append(mux.cancels, cancel)
 	if opts.SubscribeForwarders {
 		// Do an initial list to pre-populate the forwarders
 		peers, err := dom.storage.MeshDB().Peers().List(context.Background(), storage.FilterByFeature(v1.Feature_FORWARD_MESH_DNS))
@@ -333,6 +418,9 @@ func (s *Server) RegisterDomain(opts DomainOptions) error {
 		}
 		mux.cancels = append(mux.cancels, cancel)
 	}
+	if err := s.subscribeFederations(mux, dom); err != nil {
+		return fmt.Errorf("failed to subscribe to federations: %w", err)
+	}
 	return nil
 }
 
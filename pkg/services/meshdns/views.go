@@ -0,0 +1,313 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshdns
+
+import (
+	"log/slog"
+	"net"
+	"net/netip"
+	"sync"
+
+	"github.com/miekg/dns"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/meshnet"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/meshdb/state"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// queryView is the view of a mesh that a query is answered from.
+type queryView struct {
+	mesh meshDomain
+	// source is the node the query came from. It is empty when
+	// the query did not come from a node in the mesh.
+	source types.NodeID
+}
+
+// viewFor selects the mesh to answer a query from. A mesh containing a node with
+// the source address of the query is preferred, followed by meshes whose networks
+// or interface contain the source address, and then the address the query was
+// received on. The first registered mesh is used when nothing matches. It must be
+// called with the mux lock held.
+func (s *meshLookupMux) viewFor(ctx context.Context, w dns.ResponseWriter) queryView {
+	view := queryView{mesh: s.meshes[0]}
+	if len(s.meshes) == 1 && !s.opts.SplitHorizon {
+		return view
+	}
+	src := addrOf(w.RemoteAddr())
+	local := addrOf(w.LocalAddr())
+	bestScore := -1
+	for _, mesh := range s.meshes {
+		candidate := queryView{mesh: mesh}
+		score := 0
+		nodeID, prefixes := mesh.view.lookup(src)
+		if src.IsLoopback() {
+			// Queries from the local resolver are made on behalf of this node.
+			candidate.source = mesh.nodeID
+		} else if nodeID != "" {
+			candidate.source = nodeID
+			score += 4
+		}
+		if prefixesContain(prefixes, src) {
+			score += 2
+		}
+		if local.IsValid() && !local.IsUnspecified() && prefixesContain(prefixes, local) {
+			score++
+		}
+		if score > bestScore {
+			view, bestScore = candidate, score
+		}
+	}
+	s.log.Debug("Selected mesh view for query",
+		slog.String("source", src.String()),
+		slog.String("node-id", view.mesh.nodeID.String()),
+		slog.String("querier", view.source.String()),
+	)
+	return view
+}
+
+// ordered returns the meshes to search for the view, starting with the view's
+// own mesh. When split-horizon is enabled, only the view's own mesh is returned.
+// It must be called with the mux lock held.
+func (s *meshLookupMux) ordered(view queryView) []meshDomain {
+	if s.opts.SplitHorizon {
+		return []meshDomain{view.mesh}
+	}
+	meshes := []meshDomain{view.mesh}
+	for _, mesh := range s.meshes {
+		if mesh != view.mesh {
+			meshes = append(meshes, mesh)
+		}
+	}
+	return meshes
+}
+
+// horizon is used to determine if a node is visible to the source of a query.
+type horizon func(nodeID string) bool
+
+// horizonFor returns the horizon for the given view. When split-horizon is disabled
+// all nodes are visible. Otherwise only nodes the querying node can reach under the
+// current network ACLs are visible. Queries from outside the mesh see what the
+// serving node can reach.
+func (s *meshLookupMux) horizonFor(ctx context.Context, view queryView) (horizon, error) {
+	if !s.opts.SplitHorizon {
+		return func(string) bool { return true }, nil
+	}
+	source := view.source
+	if source == "" {
+		source = view.mesh.nodeID
+	}
+	reachable, err := view.mesh.view.reachable(ctx, source)
+	if err != nil {
+		return nil, err
+	}
+	return func(nodeID string) bool {
+		if nodeID == source.String() {
+			return true
+		}
+		_, ok := reachable[types.NodeID(nodeID)]
+		return ok
+	}, nil
+}
+
+// viewPrefixes are the prefixes of the data views are computed from.
+var viewPrefixes = []types.StoragePrefix{
+	storage.NodesPrefix,
+	storage.EdgesPrefix,
+	storage.NetworkACLsPrefix,
+	storage.RoutesPrefix,
+	types.RegistryPrefix.ForString("groups"),
+	types.StoragePrefix(state.MeshStatePrefix),
+}
+
+// meshView caches what is needed to select and filter the view of a mesh, so
+// that queries do not read the storage while holding the mux lock.
+type meshView struct {
+	db    storage.MeshDB
+	iface string
+	log   *slog.Logger
+	// nodes maps the private addresses of nodes to their IDs.
+	nodes map[netip.Addr]types.NodeID
+	// prefixes are the networks of the mesh and the prefixes of its interface.
+	prefixes []netip.Prefix
+	// horizons are the nodes reachable by each querier, computed on demand.
+	horizons map[types.NodeID]types.AdjacencyMap
+	mu       sync.RWMutex
+}
+
+func newMeshView(ctx context.Context, db storage.MeshDB, iface string) *meshView {
+	v := &meshView{
+		db:    db,
+		iface: iface,
+		log:   context.LoggerFrom(ctx).With("component", "meshdns-view"),
+	}
+	v.refresh(ctx)
+	return v
+}
+
+// subscribe refreshes the view whenever the data it is computed from changes.
+// Refreshes run in the background and changes arriving while one is running
+// are coalesced into the next.
+func (v *meshView) subscribe(ctx context.Context, st storage.MeshStorage) (context.CancelFunc, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	changed := make(chan struct{}, 1)
+	unsubscribe, err := st.Subscribe(ctx, types.RegistryPrefix, func(key, _ []byte) {
+		for _, prefix := range viewPrefixes {
+			if prefix.Contains(key) {
+				select {
+				case changed <- struct{}{}:
+				default:
+				}
+				return
+			}
+		}
+	})
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-changed:
+				v.refresh(ctx)
+			}
+		}
+	}()
+	return func() {
+		unsubscribe()
+		cancel()
+	}, nil
+}
+
+// refresh reloads the addresses of nodes and the prefixes of the mesh, and drops
+// the computed horizons.
+func (v *meshView) refresh(ctx context.Context) {
+	nodes := make(map[netip.Addr]types.NodeID)
+	peers, err := v.db.Peers().List(ctx)
+	if err != nil {
+		v.log.Debug("Failed to list nodes", slog.String("error", err.Error()))
+	}
+	for _, peer := range peers {
+		for _, addr := range []netip.Prefix{peer.PrivateAddrV4(), peer.PrivateAddrV6()} {
+			if addr.IsValid() {
+				nodes[addr.Addr()] = peer.NodeID()
+			}
+		}
+	}
+	prefixes := v.meshPrefixes(ctx)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.nodes = nodes
+	v.prefixes = prefixes
+	v.horizons = make(map[types.NodeID]types.AdjacencyMap)
+}
+
+// lookup returns the ID of the node with the given private address, if any, and
+// the prefixes of the mesh.
+func (v *meshView) lookup(addr netip.Addr) (types.NodeID, []netip.Prefix) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	return v.nodes[addr], v.prefixes
+}
+
+// reachable returns the nodes the given node can reach under the current network
+// ACLs.
+func (v *meshView) reachable(ctx context.Context, source types.NodeID) (types.AdjacencyMap, error) {
+	v.mu.RLock()
+	reachable, ok := v.horizons[source]
+	v.mu.RUnlock()
+	if ok {
+		return reachable, nil
+	}
+	reachable, err := meshnet.FilterGraph(ctx, v.db, source)
+	if err != nil {
+		return nil, err
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.horizons[source] = reachable
+	return reachable, nil
+}
+
+// meshPrefixes returns the networks of the mesh and the prefixes of its interface.
+func (v *meshView) meshPrefixes(ctx context.Context) []netip.Prefix {
+	var prefixes []netip.Prefix
+	state, err := v.db.MeshState().GetMeshState(ctx)
+	if err != nil {
+		v.log.Debug("Failed to get mesh state", slog.String("error", err.Error()))
+	} else {
+		for _, prefix := range []netip.Prefix{state.NetworkV4(), state.NetworkV6()} {
+			if prefix.IsValid() {
+				prefixes = append(prefixes, prefix)
+			}
+		}
+	}
+	if v.iface == "" {
+		return prefixes
+	}
+	iface, err := net.InterfaceByName(v.iface)
+	if err != nil {
+		v.log.Debug("Failed to lookup mesh interface", slog.String("interface", v.iface), slog.String("error", err.Error()))
+		return prefixes
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		v.log.Debug("Failed to list interface addresses", slog.String("interface", v.iface), slog.String("error", err.Error()))
+		return prefixes
+	}
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok {
+			if prefix, err := netip.ParsePrefix(ipnet.String()); err == nil {
+				prefixes = append(prefixes, prefix.Masked())
+			}
+		}
+	}
+	return prefixes
+}
+
+func prefixesContain(prefixes []netip.Prefix, addr netip.Addr) bool {
+	if !addr.IsValid() {
+		return false
+	}
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func addrOf(addr net.Addr) netip.Addr {
+	var ip net.IP
+	switch v := addr.(type) {
+	case *net.UDPAddr:
+		ip = v.IP
+	case *net.TCPAddr:
+		ip = v.IP
+	default:
+		return netip.Addr{}
+	}
+	out, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return netip.Addr{}
+	}
+	return out.Unmap()
+}
@@ -0,0 +1,277 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshdns
+
+import (
+	"log/slog"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	v1 "github.com/webmeshproj/api/go/v1"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/crypto"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/meshdb"
+	"github.com/webmeshproj/webmesh/pkg/storage/providers/backends/badgerdb"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+func TestViewFor(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	meshA := newTestMeshDomain(t, "node-c", "172.16.0.0/12", map[string]string{
+		"node-a": "172.16.0.1/32",
+		"node-b": "172.16.0.2/32",
+		"node-c": "172.16.0.3/32",
+	})
+	meshB := newTestMeshDomain(t, "node-y", "10.10.0.0/16", map[string]string{
+		"node-x": "10.10.0.1/32",
+		"node-y": "10.10.0.2/32",
+	})
+	mux := newTestLookupMux(false, meshA.dom, meshB.dom)
+
+	tc := []struct {
+		name       string
+		remote     string
+		local      string
+		wantMesh   types.NodeID
+		wantSource types.NodeID
+	}{
+		{"NodeInFirstMesh", "172.16.0.2", "", "node-c", "node-b"},
+		{"NodeInSecondMesh", "10.10.0.1", "", "node-y", "node-x"},
+		{"NetworkOfSecondMesh", "10.10.5.5", "", "node-y", ""},
+		{"LocalAddressOfSecondMesh", "192.0.2.1", "10.10.0.53", "node-y", ""},
+		{"Loopback", "127.0.0.1", "127.0.0.1", "node-c", "node-c"},
+		{"NoMatch", "192.0.2.1", "", "node-c", ""},
+	}
+	for _, c := range tc {
+		t.Run(c.name, func(t *testing.T) {
+			view := mux.viewFor(ctx, newTestResponseWriter(c.remote, c.local))
+			if view.mesh.nodeID != c.wantMesh {
+				t.Errorf("expected mesh of %q, got %q", c.wantMesh, view.mesh.nodeID)
+			}
+			if view.source != c.wantSource {
+				t.Errorf("expected querier %q, got %q", c.wantSource, view.source)
+			}
+		})
+	}
+
+	t.Run("RefreshesOnPeerChanges", func(t *testing.T) {
+		cancel, err := meshB.dom.view.subscribe(ctx, meshB.st)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer cancel()
+		ok := eventually(func() bool {
+			// Subscriptions start in the background, so keep writing until
+			// the change is seen.
+			meshB.putNode(t, "node-z", "10.10.0.3/32")
+			return mux.viewFor(ctx, newTestResponseWriter("10.10.0.3", "")).source == "node-z"
+		})
+		if !ok {
+			t.Fatal("expected the new node to be selected as the querier")
+		}
+	})
+}
+
+func TestHorizonFor(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	mesh := newTestMeshDomain(t, "node-c", "172.16.0.0/12", map[string]string{
+		"node-a": "172.16.0.1/32",
+		"node-b": "172.16.0.2/32",
+		"node-c": "172.16.0.3/32",
+	})
+	mesh.putACL(t, &v1.NetworkACL{
+		Name:             "a-and-b",
+		Action:           v1.ACLAction_ACTION_ACCEPT,
+		SourceNodes:      []string{"node-a", "node-b"},
+		DestinationNodes: []string{"node-a", "node-b"},
+		SourceCIDRs:      []string{"*"},
+		DestinationCIDRs: []string{"*"},
+	})
+	mesh.dom.view.refresh(ctx)
+
+	t.Run("Disabled", func(t *testing.T) {
+		mux := newTestLookupMux(false, mesh.dom)
+		visible, err := mux.horizonFor(ctx, queryView{mesh: mesh.dom, source: "node-a"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !visible("node-c") {
+			t.Fatal("expected every node to be visible without split-horizon")
+		}
+	})
+
+	mux := newTestLookupMux(true, mesh.dom)
+	tc := []struct {
+		name    string
+		source  types.NodeID
+		visible map[string]bool
+	}{
+		{"NodeInACL", "node-a", map[string]bool{"node-a": true, "node-b": true, "node-c": false}},
+		{"NodeOutsideACL", "node-c", map[string]bool{"node-a": false, "node-b": false, "node-c": true}},
+		{"OutsideTheMesh", "", map[string]bool{"node-a": false, "node-b": false, "node-c": true}},
+	}
+	for _, c := range tc {
+		t.Run(c.name, func(t *testing.T) {
+			visible, err := mux.horizonFor(ctx, queryView{mesh: mesh.dom, source: c.source})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for node, want := range c.visible {
+				if got := visible(node); got != want {
+					t.Errorf("expected visibility of %s to be %v, got %v", node, want, got)
+				}
+			}
+		})
+	}
+
+	t.Run("RefreshesOnACLChanges", func(t *testing.T) {
+		cancel, err := mesh.dom.view.subscribe(ctx, mesh.st)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer cancel()
+		ok := eventually(func() bool {
+			mesh.putACL(t, &v1.NetworkACL{
+				Name:             "allow-all",
+				Action:           v1.ACLAction_ACTION_ACCEPT,
+				SourceNodes:      []string{"*"},
+				DestinationNodes: []string{"*"},
+				SourceCIDRs:      []string{"*"},
+				DestinationCIDRs: []string{"*"},
+			})
+			visible, err := mux.horizonFor(ctx, queryView{mesh: mesh.dom, source: "node-c"})
+			return err == nil && visible("node-a")
+		})
+		if !ok {
+			t.Fatal("expected the horizon to be recomputed after the ACL change")
+		}
+	})
+}
+
+type testMeshDomain struct {
+	dom meshDomain
+	st  storage.MeshStorage
+	db  storage.MeshDB
+}
+
+func newTestMeshDomain(t *testing.T, nodeID types.NodeID, network string, nodes map[string]string) *testMeshDomain {
+	t.Helper()
+	ctx := context.Background()
+	st := badgerdb.NewTestStorage(false)
+	t.Cleanup(func() { st.Close() })
+	mesh := &testMeshDomain{st: st, db: meshdb.NewFromStorage(st)}
+	err := mesh.db.MeshState().SetMeshState(ctx, types.NetworkState{
+		NetworkState: &v1.NetworkState{
+			NetworkV4: network,
+			NetworkV6: "2001:db8::/64",
+			Domain:    "webmesh.internal",
+		},
+	})
+	if err != nil {
+		t.Fatalf("set mesh state: %v", err)
+	}
+	for id, addr := range nodes {
+		mesh.putNode(t, id, addr)
+	}
+	for src := range nodes {
+		for dst := range nodes {
+			if src == dst {
+				continue
+			}
+			err := mesh.db.Peers().PutEdge(ctx, types.MeshEdge{MeshEdge: &v1.MeshEdge{Source: src, Target: dst}})
+			if err != nil {
+				t.Fatalf("put edge: %v", err)
+			}
+		}
+	}
+	mesh.dom = meshDomain{
+		nodeID: nodeID,
+		domain: "webmesh.internal.",
+		view:   newMeshView(ctx, mesh.db, ""),
+	}
+	return mesh
+}
+
+func (m *testMeshDomain) putNode(t *testing.T, id, addr string) {
+	t.Helper()
+	key, err := crypto.MustGenerateKey().PublicKey().Encode()
+	if err != nil {
+		t.Fatalf("encode key: %v", err)
+	}
+	err = m.db.Peers().Put(context.Background(), types.MeshNode{MeshNode: &v1.MeshNode{
+		Id:          id,
+		PublicKey:   key,
+		PrivateIPv4: addr,
+	}})
+	if err != nil {
+		t.Fatalf("put node: %v", err)
+	}
+}
+
+func (m *testMeshDomain) putACL(t *testing.T, acl *v1.NetworkACL) {
+	t.Helper()
+	if err := m.db.Networking().PutNetworkACL(context.Background(), types.NetworkACL{NetworkACL: acl}); err != nil {
+		t.Fatalf("put network ACL: %v", err)
+	}
+}
+
+func newTestLookupMux(splitHorizon bool, meshes ...meshDomain) *meshLookupMux {
+	return &meshLookupMux{
+		Server: &Server{
+			opts: &Options{SplitHorizon: splitHorizon},
+			log:  slog.Default(),
+		},
+		meshes: meshes,
+	}
+}
+
+// eventually polls the condition until it is true or a few seconds passed.
+func eventually(cond func() bool) bool {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return true
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return false
+}
+
+type testResponseWriter struct {
+	dns.ResponseWriter
+	remote, local net.Addr
+}
+
+func newTestResponseWriter(remote, local string) *testResponseWriter {
+	w := &testResponseWriter{remote: &net.UDPAddr{IP: net.ParseIP(remote), Port: 5353}}
+	if local != "" {
+		w.local = &net.UDPAddr{IP: net.ParseIP(local), Port: 53}
+	} else {
+		w.local = &net.UDPAddr{IP: net.IPv4zero, Port: 53}
+	}
+	return w
+}
+
+func (w *testResponseWriter) RemoteAddr() net.Addr { return w.remote }
+
+func (w *testResponseWriter) LocalAddr() net.Addr { return w.local }
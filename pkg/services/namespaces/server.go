@@ -0,0 +1,327 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package namespaces provides the key-value namespaces server.
+package namespaces
+
+import (
+	"encoding/json"
+	"log/slog"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+
+	"github.com/webmeshproj/webmesh/pkg/common"
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/services/rbac"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/namespaces"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// ServiceName is the fully qualified name of the namespaces service.
+const ServiceName = "v1.MeshNamespaces"
+
+const (
+	// PutFullMethodName is the full method name for Put.
+	PutFullMethodName = "/" + ServiceName + "/Put"
+	// GetFullMethodName is the full method name for Get.
+	GetFullMethodName = "/" + ServiceName + "/Get"
+	// DeleteFullMethodName is the full method name for Delete.
+	DeleteFullMethodName = "/" + ServiceName + "/Delete"
+	// ListFullMethodName is the full method name for List.
+	ListFullMethodName = "/" + ServiceName + "/List"
+)
+
+// Status is a namespace along with its current usage.
+type Status struct {
+	types.Namespace
+	// Usage is the current usage of the namespace.
+	Usage types.NamespaceUsage `json:"usage"`
+}
+
+// MeshNamespacesServer is the server API for key-value namespaces.
+// Namespaces and their statuses are exchanged as JSON.
+type MeshNamespacesServer interface {
+	// Put creates or updates the JSON encoded types.Namespace.
+	Put(context.Context, *wrapperspb.BytesValue) (*emptypb.Empty, error)
+	// Get returns the JSON encoded Status of the named namespace.
+	Get(context.Context, *wrapperspb.StringValue) (*wrapperspb.BytesValue, error)
+	// Delete removes the named namespace. The keys in it are kept.
+	Delete(context.Context, *wrapperspb.StringValue) (*emptypb.Empty, error)
+	// List returns the JSON encoded Statuses of all namespaces the caller
+	// can read.
+	List(context.Context, *emptypb.Empty) (*wrapperspb.BytesValue, error)
+}
+
+// ServiceDesc is the grpc.ServiceDesc for the namespaces service.
+var ServiceDesc = grpc.ServiceDesc{
+	ServiceName: ServiceName,
+	HandlerType: (*MeshNamespacesServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{MethodName: "Put", Handler: putHandler},
+		{MethodName: "Get", Handler: getHandler},
+		{MethodName: "Delete", Handler: deleteHandler},
+		{MethodName: "List", Handler: listHandler},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "v1/namespaces.proto",
+}
+
+// RegisterMeshNamespacesServer registers the namespaces service with the given registrar.
+func RegisterMeshNamespacesServer(s grpc.ServiceRegistrar, srv MeshNamespacesServer) error {
+	err := common.RegisterServiceFile(&ServiceDesc,
+		common.ServiceMethod{Name: "Put", Input: &wrapperspb.BytesValue{}, Output: &emptypb.Empty{}},
+		common.ServiceMethod{Name: "Get", Input: &wrapperspb.StringValue{}, Output: &wrapperspb.BytesValue{}},
+		common.ServiceMethod{Name: "Delete", Input: &wrapperspb.StringValue{}, Output: &emptypb.Empty{}},
+		common.ServiceMethod{Name: "List", Input: &emptypb.Empty{}, Output: &wrapperspb.BytesValue{}},
+	)
+	if err != nil {
+		return err
+	}
+	s.RegisterService(&ServiceDesc, srv)
+	return nil
+}
+
+func putHandler(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+	in := new(wrapperspb.BytesValue)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	handler := func(ctx context.Context, req any) (any, error) {
+		return srv.(MeshNamespacesServer).Put(ctx, req.(*wrapperspb.BytesValue))
+	}
+	if interceptor == nil {
+		return handler(ctx, in)
+	}
+	return interceptor(ctx, in, &grpc.UnaryServerInfo{Server: srv, FullMethod: PutFullMethodName}, handler)
+}
+
+func getHandler(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+	in := new(wrapperspb.StringValue)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	handler := func(ctx context.Context, req any) (any, error) {
+		return srv.(MeshNamespacesServer).Get(ctx, req.(*wrapperspb.StringValue))
+	}
+	if interceptor == nil {
+		return handler(ctx, in)
+	}
+	return interceptor(ctx, in, &grpc.UnaryServerInfo{Server: srv, FullMethod: GetFullMethodName}, handler)
+}
+
+func deleteHandler(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+	in := new(wrapperspb.StringValue)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	handler := func(ctx context.Context, req any) (any, error) {
+		return srv.(MeshNamespacesServer).Delete(ctx, req.(*wrapperspb.StringValue))
+	}
+	if interceptor == nil {
+		return handler(ctx, in)
+	}
+	return interceptor(ctx, in, &grpc.UnaryServerInfo{Server: srv, FullMethod: DeleteFullMethodName}, handler)
+}
+
+func listHandler(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+	in := new(emptypb.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	handler := func(ctx context.Context, req any) (any, error) {
+		return srv.(MeshNamespacesServer).List(ctx, req.(*emptypb.Empty))
+	}
+	if interceptor == nil {
+		return handler(ctx, in)
+	}
+	return interceptor(ctx, in, &grpc.UnaryServerInfo{Server: srv, FullMethod: ListFullMethodName}, handler)
+}
+
+// Put creates or updates a namespace on the node at the other end of the given connection.
+func Put(ctx context.Context, cc grpc.ClientConnInterface, ns types.Namespace, opts ...grpc.CallOption) error {
+	data, err := json.Marshal(ns)
+	if err != nil {
+		return err
+	}
+	return cc.Invoke(ctx, PutFullMethodName, wrapperspb.Bytes(data), new(emptypb.Empty), opts...)
+}
+
+// Get returns the status of a namespace from the node at the other end of the given connection.
+func Get(ctx context.Context, cc grpc.ClientConnInterface, name string, opts ...grpc.CallOption) (Status, error) {
+	var st Status
+	out := new(wrapperspb.BytesValue)
+	if err := cc.Invoke(ctx, GetFullMethodName, wrapperspb.String(name), out, opts...); err != nil {
+		return st, err
+	}
+	err := json.Unmarshal(out.GetValue(), &st)
+	return st, err
+}
+
+// Delete removes a namespace on the node at the other end of the given connection.
+func Delete(ctx context.Context, cc grpc.ClientConnInterface, name string, opts ...grpc.CallOption) error {
+	return cc.Invoke(ctx, DeleteFullMethodName, wrapperspb.String(name), new(emptypb.Empty), opts...)
+}
+
+// List returns the statuses of the namespaces from the node at the other end of the given connection.
+func List(ctx context.Context, cc grpc.ClientConnInterface, opts ...grpc.CallOption) ([]Status, error) {
+	out := new(wrapperspb.BytesValue)
+	if err := cc.Invoke(ctx, ListFullMethodName, new(emptypb.Empty), out, opts...); err != nil {
+		return nil, err
+	}
+	var statuses []Status
+	err := json.Unmarshal(out.GetValue(), &statuses)
+	return statuses, err
+}
+
+// Server is the namespaces server.
+type Server struct {
+	store *namespaces.Store
+	rbac  rbac.Evaluator
+	log   *slog.Logger
+}
+
+// NewServer returns a new namespaces Server.
+func NewServer(ctx context.Context, storage storage.Provider, rbac rbac.Evaluator) *Server {
+	return &Server{
+		store: namespaces.New(storage.MeshStorage()),
+		rbac:  rbac,
+		log:   context.LoggerFrom(ctx).With("component", "namespaces-server"),
+	}
+}
+
+// Put implements MeshNamespacesServer.
+func (s *Server) Put(ctx context.Context, req *wrapperspb.BytesValue) (*emptypb.Empty, error) {
+	var ns types.Namespace
+	if err := json.Unmarshal(req.GetValue(), &ns); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid namespace: %v", err)
+	}
+	if err := ns.Validate(); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err := s.authorize(ctx, ns.Name, v1.RuleVerb_VERB_PUT); err != nil {
+		return nil, err
+	}
+	if err := s.store.Put(ctx, ns); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &emptypb.Empty{}, nil
+}
+
+// Get implements MeshNamespacesServer.
+func (s *Server) Get(ctx context.Context, req *wrapperspb.StringValue) (*wrapperspb.BytesValue, error) {
+	if err := s.authorize(ctx, req.GetValue(), v1.RuleVerb_VERB_GET); err != nil {
+		return nil, err
+	}
+	st, err := s.status(ctx, req.GetValue())
+	if err != nil {
+		if errors.Is(err, errors.ErrNamespaceNotFound) {
+			return nil, status.Error(codes.NotFound, err.Error())
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	data, err := json.Marshal(st)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return wrapperspb.Bytes(data), nil
+}
+
+// Delete implements MeshNamespacesServer.
+func (s *Server) Delete(ctx context.Context, req *wrapperspb.StringValue) (*emptypb.Empty, error) {
+	if err := s.authorize(ctx, req.GetValue(), v1.RuleVerb_VERB_DELETE); err != nil {
+		return nil, err
+	}
+	if err := s.store.Delete(ctx, req.GetValue()); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &emptypb.Empty{}, nil
+}
+
+// List implements MeshNamespacesServer.
+func (s *Server) List(ctx context.Context, _ *emptypb.Empty) (*wrapperspb.BytesValue, error) {
+	list, err := s.store.List(ctx)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	out := make([]Status, 0, len(list))
+	for _, ns := range list {
+		allowed, err := s.rbac.Evaluate(ctx, action(v1.RuleVerb_VERB_GET).For(resourceName(ns.Name)))
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to evaluate namespace permissions: %v", err)
+		}
+		if !allowed {
+			continue
+		}
+		usage, err := s.store.Usage(ctx, ns.Name)
+		if err != nil {
+			if errors.Is(err, errors.ErrNamespaceNotFound) {
+				// Deleted while listing.
+				continue
+			}
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		out = append(out, Status{Namespace: ns, Usage: usage})
+	}
+	data, err := json.Marshal(out)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return wrapperspb.Bytes(data), nil
+}
+
+func (s *Server) status(ctx context.Context, name string) (Status, error) {
+	ns, err := s.store.Get(ctx, name)
+	if err != nil {
+		return Status{}, err
+	}
+	usage, err := s.store.Usage(ctx, name)
+	if err != nil {
+		return Status{}, err
+	}
+	return Status{Namespace: ns, Usage: usage}, nil
+}
+
+// authorize checks that the caller may perform the verb on the named
+// namespace. Namespaces are authorized as the pub/sub key holding them.
+func (s *Server) authorize(ctx context.Context, name string, verb v1.RuleVerb) error {
+	if !types.IsValidID(name) {
+		return status.Errorf(codes.InvalidArgument, "invalid namespace name %q", name)
+	}
+	allowed, err := s.rbac.Evaluate(ctx, action(verb).For(resourceName(name)))
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to evaluate namespace permissions: %v", err)
+	}
+	if !allowed {
+		s.log.Warn("caller not allowed to access namespace", slog.String("namespace", name))
+		return status.Error(codes.PermissionDenied, "not allowed")
+	}
+	return nil
+}
+
+func action(verb v1.RuleVerb) rbac.Actions {
+	return rbac.Actions{{Verb: verb, Resource: v1.RuleResource_RESOURCE_PUBSUB}}
+}
+
+func resourceName(name string) string {
+	return types.NamespacesPrefix.ForString(name).String()
+}
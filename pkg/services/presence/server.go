@@ -0,0 +1,205 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package presence provides the server for member presence leases.
+package presence
+
+import (
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+
+	"github.com/webmeshproj/webmesh/pkg/common"
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/meshnet"
+	"github.com/webmeshproj/webmesh/pkg/services/leaderproxy"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/presence"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// ServiceName is the fully qualified name of the presence service.
+const ServiceName = "v1.MeshPresence"
+
+const (
+	// RenewFullMethodName is the full method name for Renew.
+	RenewFullMethodName = "/" + ServiceName + "/Renew"
+	// ListFullMethodName is the full method name for List.
+	ListFullMethodName = "/" + ServiceName + "/List"
+)
+
+// MaxTTL is the longest lease a member can request.
+const MaxTTL = time.Hour
+
+// MeshPresenceServer is the server API for presence leases. Leases are
+// exchanged as JSON.
+type MeshPresenceServer interface {
+	// Renew renews the JSON encoded types.Presence of the caller. The
+	// last seen time is set by the server.
+	Renew(context.Context, *wrapperspb.BytesValue) (*emptypb.Empty, error)
+	// List returns the JSON encoded types.Presence of every member with a lease.
+	List(context.Context, *emptypb.Empty) (*wrapperspb.BytesValue, error)
+}
+
+// ServiceDesc is the grpc.ServiceDesc for the presence service.
+var ServiceDesc = grpc.ServiceDesc{
+	ServiceName: ServiceName,
+	HandlerType: (*MeshPresenceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{MethodName: "Renew", Handler: renewHandler},
+		{MethodName: "List", Handler: listHandler},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "v1/presence.proto",
+}
+
+// RegisterMeshPresenceServer registers the presence service with the given registrar.
+func RegisterMeshPresenceServer(s grpc.ServiceRegistrar, srv MeshPresenceServer) error {
+	err := common.RegisterServiceFile(&ServiceDesc,
+		common.ServiceMethod{Name: "Renew", Input: &wrapperspb.BytesValue{}, Output: &emptypb.Empty{}},
+		common.ServiceMethod{Name: "List", Input: &emptypb.Empty{}, Output: &wrapperspb.BytesValue{}},
+	)
+	if err != nil {
+		return err
+	}
+	s.RegisterService(&ServiceDesc, srv)
+	return nil
+}
+
+func renewHandler(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+	in := new(wrapperspb.BytesValue)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	handler := func(ctx context.Context, req any) (any, error) {
+		return srv.(MeshPresenceServer).Renew(ctx, req.(*wrapperspb.BytesValue))
+	}
+	if interceptor == nil {
+		return handler(ctx, in)
+	}
+	return interceptor(ctx, in, &grpc.UnaryServerInfo{Server: srv, FullMethod: RenewFullMethodName}, handler)
+}
+
+func listHandler(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+	in := new(emptypb.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	handler := func(ctx context.Context, req any) (any, error) {
+		return srv.(MeshPresenceServer).List(ctx, req.(*emptypb.Empty))
+	}
+	if interceptor == nil {
+		return handler(ctx, in)
+	}
+	return interceptor(ctx, in, &grpc.UnaryServerInfo{Server: srv, FullMethod: ListFullMethodName}, handler)
+}
+
+// Renew renews a presence lease on the node at the other end of the given connection.
+func Renew(ctx context.Context, cc grpc.ClientConnInterface, p types.Presence, opts ...grpc.CallOption) error {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	return cc.Invoke(ctx, RenewFullMethodName, wrapperspb.Bytes(data), new(emptypb.Empty), opts...)
+}
+
+// List returns the presence leases from the node at the other end of the given connection.
+func List(ctx context.Context, cc grpc.ClientConnInterface, opts ...grpc.CallOption) ([]types.Presence, error) {
+	out := new(wrapperspb.BytesValue)
+	if err := cc.Invoke(ctx, ListFullMethodName, new(emptypb.Empty), out, opts...); err != nil {
+		return nil, err
+	}
+	var leases []types.Presence
+	err := json.Unmarshal(out.GetValue(), &leases)
+	return leases, err
+}
+
+// Server is the presence server.
+type Server struct {
+	storage storage.Provider
+	store   *presence.Store
+	mnet    meshnet.Manager
+	log     *slog.Logger
+}
+
+// NewServer returns a new presence Server.
+func NewServer(ctx context.Context, storage storage.Provider, mnet meshnet.Manager) *Server {
+	return &Server{
+		storage: storage,
+		store:   presence.New(storage.MeshStorage()),
+		mnet:    mnet,
+		log:     context.LoggerFrom(ctx).With("component", "presence-server"),
+	}
+}
+
+// Renew implements MeshPresenceServer.
+func (s *Server) Renew(ctx context.Context, req *wrapperspb.BytesValue) (*emptypb.Empty, error) {
+	if !context.IsInNetwork(ctx, s.mnet) {
+		addr, _ := context.PeerAddrFrom(ctx)
+		s.log.Warn("Received Renew request from out of network", slog.String("peer", addr.String()))
+		return nil, status.Errorf(codes.PermissionDenied, "request is not in-network")
+	}
+	var p types.Presence
+	if err := json.Unmarshal(req.GetValue(), &p); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid presence: %v", err)
+	}
+	if !p.NodeID.IsValid() {
+		return nil, status.Error(codes.InvalidArgument, "invalid node id")
+	}
+	if p.TTL <= 0 || p.TTL > MaxTTL {
+		return nil, status.Errorf(codes.InvalidArgument, "ttl must be between 0 and %s", MaxTTL)
+	}
+	// Members can only renew their own lease.
+	caller, ok := leaderproxy.ProxiedFor(ctx)
+	if !ok {
+		caller, ok = context.AuthenticatedCallerFrom(ctx)
+	}
+	if ok && caller != p.NodeID.String() {
+		return nil, status.Errorf(codes.PermissionDenied, "caller is %s, not %s", caller, p.NodeID)
+	}
+	// Only members of the mesh can hold a lease.
+	if _, err := s.storage.MeshDB().Peers().Get(ctx, p.NodeID); err != nil {
+		if errors.IsNodeNotFound(err) {
+			return nil, status.Errorf(codes.NotFound, "node %s is not a member of the mesh", p.NodeID)
+		}
+		return nil, status.Errorf(codes.Internal, "failed to get peer: %v", err)
+	}
+	p.LastSeen = time.Now().UTC()
+	if err := s.store.Put(ctx, p); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &emptypb.Empty{}, nil
+}
+
+// List implements MeshPresenceServer.
+func (s *Server) List(ctx context.Context, _ *emptypb.Empty) (*wrapperspb.BytesValue, error) {
+	leases, err := s.store.List(ctx)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	data, err := json.Marshal(leases)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return wrapperspb.Bytes(data), nil
+}
@@ -0,0 +1,303 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rbac
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// Grant is a rule of a role that a rolebinding grants to a subject.
+type Grant struct {
+	// RoleBinding is the name of the rolebinding.
+	RoleBinding string `json:"roleBinding"`
+	// Role is the name of the role bound by the rolebinding.
+	Role string `json:"role"`
+	// Rule is the rule of the role that matched the action.
+	Rule Rule `json:"rule"`
+}
+
+// Rule is a readable copy of a role rule.
+type Rule struct {
+	Verbs         []string `json:"verbs"`
+	Resources     []string `json:"resources"`
+	ResourceNames []string `json:"resourceNames,omitempty"`
+}
+
+// Decision explains the result of evaluating an action for a subject.
+type Decision struct {
+	// Subject is the node or user the action was evaluated for.
+	Subject string `json:"subject"`
+	// SubjectType is the type of the subject in the rolebinding that
+	// granted access, or empty when access was denied.
+	SubjectType string `json:"subjectType,omitempty"`
+	// Allowed is true if the subject may perform the action.
+	Allowed bool `json:"allowed"`
+	// Reason describes why the action was allowed or denied.
+	Reason string `json:"reason"`
+	// Grant is the rule that allowed the action.
+	Grant *Grant `json:"grant,omitempty"`
+	// RoleBindings are the rolebindings that apply to the subject. When
+	// the action is denied, none of the roles they bind have a matching rule.
+	RoleBindings []string `json:"roleBindings,omitempty"`
+}
+
+// Explain evaluates the action for the given subject against the stored
+// roles and rolebindings and returns the rule and rolebinding that decided
+// it. Like the store evaluator, nodes and users with the same ID are treated
+// as the same subject, and every action is denied when one of the
+// rolebindings of the subject binds a role that does not exist.
+func Explain(ctx context.Context, rbac storage.RBAC, subject types.NodeID, action *Action) (Decision, error) {
+	decision := Decision{Subject: subject.String()}
+	enabled, err := rbac.GetEnabled(ctx)
+	if err != nil {
+		return decision, fmt.Errorf("get rbac enabled: %w", err)
+	}
+	if !enabled {
+		decision.Allowed = true
+		decision.Reason = "RBAC is disabled"
+		return decision, nil
+	}
+	rbs, err := rbac.ListRoleBindings(ctx)
+	if err != nil {
+		return decision, fmt.Errorf("list rolebindings: %w", err)
+	}
+	var subjectType v1.SubjectType
+	for _, rb := range rbs {
+		var rbSubjectType v1.SubjectType
+		switch {
+		case rb.ContainsNodeID(subject):
+			rbSubjectType = v1.SubjectType_SUBJECT_NODE
+		case rb.ContainsUserID(subject):
+			rbSubjectType = v1.SubjectType_SUBJECT_USER
+		default:
+			continue
+		}
+		decision.RoleBindings = append(decision.RoleBindings, rb.GetName())
+		grant, ok, err := evalRoleBinding(ctx, rbac, rb, action)
+		if err != nil {
+			if errors.IsRoleNotFound(err) {
+				return Decision{
+					Subject:      subject.String(),
+					Reason:       fmt.Sprintf("rolebinding %q binds role %q which does not exist, all actions are denied", rb.GetName(), rb.GetRole()),
+					RoleBindings: []string{rb.GetName()},
+				}, nil
+			}
+			return decision, err
+		}
+		// Keep looking for rolebindings to missing roles after a match.
+		if ok && decision.Grant == nil {
+			subjectType = rbSubjectType
+			decision.Grant = grant
+		}
+	}
+	if decision.Grant != nil {
+		decision.Allowed = true
+		decision.SubjectType = subjectTypeString(subjectType)
+		decision.Reason = fmt.Sprintf("rolebinding %q grants role %q", decision.Grant.RoleBinding, decision.Grant.Role)
+		return decision, nil
+	}
+	if len(decision.RoleBindings) == 0 {
+		decision.Reason = fmt.Sprintf("no rolebindings apply to %q", subject)
+	} else {
+		decision.Reason = fmt.Sprintf("no rule in the roles bound by %s matches the action", strings.Join(decision.RoleBindings, ", "))
+	}
+	return decision, nil
+}
+
+// WhoCan returns a decision for every subject in the stored rolebindings that
+// may perform the action. A subject named "*" stands for every node or user
+// of its type. Group subjects are skipped because they are not considered
+// when evaluating actions. Subjects of rolebindings to roles that do not
+// exist are denied every action and left out.
+func WhoCan(ctx context.Context, rbac storage.RBAC, action *Action) ([]Decision, error) {
+	enabled, err := rbac.GetEnabled(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("get rbac enabled: %w", err)
+	}
+	if !enabled {
+		return []Decision{{Subject: "*", Allowed: true, Reason: "RBAC is disabled"}}, nil
+	}
+	rbs, err := rbac.ListRoleBindings(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("list rolebindings: %w", err)
+	}
+	type granted struct {
+		rb    types.RoleBinding
+		grant *Grant
+	}
+	var grants []granted
+	var dangling []types.RoleBinding
+	for _, rb := range rbs {
+		grant, ok, err := evalRoleBinding(ctx, rbac, rb, action)
+		if err != nil {
+			if errors.IsRoleNotFound(err) {
+				dangling = append(dangling, rb)
+				continue
+			}
+			return nil, err
+		}
+		if ok {
+			grants = append(grants, granted{rb: rb, grant: grant})
+		}
+	}
+	out := make([]Decision, 0)
+	for _, g := range grants {
+		for _, subject := range g.rb.GetSubjects() {
+			if subject.GetType() == v1.SubjectType_SUBJECT_GROUP {
+				continue
+			}
+			reason := fmt.Sprintf("rolebinding %q grants role %q", g.grant.RoleBinding, g.grant.Role)
+			if subject.GetName() == "*" {
+				var except []string
+				for _, rb := range dangling {
+					except = append(except, rb.GetName())
+				}
+				if len(except) > 0 {
+					reason += fmt.Sprintf(", except to the subjects of %s which bind missing roles", strings.Join(except, ", "))
+				}
+			} else if slices.ContainsFunc(dangling, func(rb types.RoleBinding) bool {
+				return rb.ContainsID(types.NodeID(subject.GetName()))
+			}) {
+				continue
+			}
+			out = append(out, Decision{
+				Subject:      subject.GetName(),
+				SubjectType:  subjectTypeString(subject.GetType()),
+				Allowed:      true,
+				Reason:       reason,
+				Grant:        g.grant,
+				RoleBindings: []string{g.rb.GetName()},
+			})
+		}
+	}
+	return out, nil
+}
+
+// evalRoleBinding returns the first rule of the role bound by the rolebinding
+// that matches the action. An error matching errors.ErrRoleNotFound is returned
+// if the bound role does not exist.
+func evalRoleBinding(ctx context.Context, rbac storage.RBAC, rb types.RoleBinding, action *Action) (*Grant, bool, error) {
+	role, err := rbac.GetRole(ctx, rb.GetRole())
+	if err != nil {
+		return nil, false, fmt.Errorf("get role: %w", err)
+	}
+	for _, rule := range role.GetRules() {
+		if types.EvalRule(rule, action.action()) {
+			return &Grant{
+				RoleBinding: rb.GetName(),
+				Role:        role.GetName(),
+				Rule:        newRule(rule),
+			}, true, nil
+		}
+	}
+	return nil, false, nil
+}
+
+func newRule(rule *v1.Rule) Rule {
+	out := Rule{ResourceNames: rule.GetResourceNames()}
+	for _, verb := range rule.GetVerbs() {
+		out.Verbs = append(out.Verbs, VerbString(verb))
+	}
+	for _, resource := range rule.GetResources() {
+		out.Resources = append(out.Resources, ResourceString(resource))
+	}
+	return out
+}
+
+func subjectTypeString(t v1.SubjectType) string {
+	switch t {
+	case v1.SubjectType_SUBJECT_NODE:
+		return "node"
+	case v1.SubjectType_SUBJECT_USER:
+		return "user"
+	case v1.SubjectType_SUBJECT_GROUP:
+		return "group"
+	case v1.SubjectType_SUBJECT_ALL:
+		return "*"
+	}
+	return t.String()
+}
+
+// resourceNames are the names used for rule resources on the command line.
+var resourceNames = map[v1.RuleResource]string{
+	v1.RuleResource_RESOURCE_VOTES:         "votes",
+	v1.RuleResource_RESOURCE_ROLES:         "roles",
+	v1.RuleResource_RESOURCE_ROLE_BINDINGS: "rolebindings",
+	v1.RuleResource_RESOURCE_GROUPS:        "groups",
+	v1.RuleResource_RESOURCE_NETWORK_ACLS:  "networkacls",
+	v1.RuleResource_RESOURCE_ROUTES:        "routes",
+	v1.RuleResource_RESOURCE_DATA_CHANNELS: "datachannels",
+	v1.RuleResource_RESOURCE_EDGES:         "edges",
+	v1.RuleResource_RESOURCE_OBSERVERS:     "observers",
+	v1.RuleResource_RESOURCE_PUBSUB:        "pubsub",
+	v1.RuleResource_RESOURCE_ALL:           "*",
+}
+
+// verbNames are the names used for rule verbs on the command line.
+var verbNames = map[v1.RuleVerb]string{
+	v1.RuleVerb_VERB_GET:    "get",
+	v1.RuleVerb_VERB_PUT:    "put",
+	v1.RuleVerb_VERB_DELETE: "delete",
+	v1.RuleVerb_VERB_ALL:    "*",
+}
+
+// ResourceString returns the command line name of the resource.
+func ResourceString(resource v1.RuleResource) string {
+	if name, ok := resourceNames[resource]; ok {
+		return name
+	}
+	return resource.String()
+}
+
+// VerbString returns the command line name of the verb.
+func VerbString(verb v1.RuleVerb) string {
+	if name, ok := verbNames[verb]; ok {
+		return name
+	}
+	return verb.String()
+}
+
+// ParseResource parses a resource from its command line name, such as
+// "networkacls", or its enum name.
+func ParseResource(s string) (v1.RuleResource, error) {
+	for resource, name := range resourceNames {
+		if strings.EqualFold(s, name) || s == resource.String() {
+			return resource, nil
+		}
+	}
+	return v1.RuleResource_RESOURCE_UNKNOWN, fmt.Errorf("unknown resource %q", s)
+}
+
+// ParseVerb parses a verb from its command line name, such as "put", or its
+// enum name.
+func ParseVerb(s string) (v1.RuleVerb, error) {
+	for verb, name := range verbNames {
+		if strings.EqualFold(s, name) || s == verb.String() {
+			return verb, nil
+		}
+	}
+	return v1.RuleVerb_VERB_UNKNOWN, fmt.Errorf("unknown verb %q", s)
+}
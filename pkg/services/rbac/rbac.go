@@ -82,17 +82,9 @@ func (s *storeEvaluator) IsSecure() bool {
 
 // Evaluate returns true if the given action is allowed for the peer information provided in the context.
 func (s *storeEvaluator) Evaluate(ctx context.Context, actions Actions) (bool, error) {
-	var peerName string
-	if proxiedFor, ok := leaderproxy.ProxiedFor(ctx); ok {
-		peerName = proxiedFor
-	} else {
-		peerName, ok = context.AuthenticatedCallerFrom(ctx)
-		if !ok {
-			return false, fmt.Errorf("no peer information in context")
-		}
-	}
-	if peerName == "" {
-		return false, fmt.Errorf("no peer information in context")
+	peerName, err := CallerFrom(ctx)
+	if err != nil {
+		return false, err
 	}
 	// We treat nodes and users as the same entity for the purpose of authorization.
 	nodeRoles, err := s.rbac.ListNodeRoles(ctx, types.NodeID(peerName))
@@ -111,6 +103,25 @@ func (s *storeEvaluator) Evaluate(ctx context.Context, actions Actions) (bool, e
 	return true, nil
 }
 
+// CallerFrom returns the identity actions are evaluated for in the given
+// context. This is the proxied-for identity when the request was proxied
+// by another node, otherwise the authenticated caller.
+func CallerFrom(ctx context.Context) (string, error) {
+	var peerName string
+	if proxiedFor, ok := leaderproxy.ProxiedFor(ctx); ok {
+		peerName = proxiedFor
+	} else {
+		peerName, ok = context.AuthenticatedCallerFrom(ctx)
+		if !ok {
+			return "", fmt.Errorf("no peer information in context")
+		}
+	}
+	if peerName == "" {
+		return "", fmt.Errorf("no peer information in context")
+	}
+	return peerName, nil
+}
+
 // NewNoopEvaluator returns an evaluator that always returns true.
 func NewNoopEvaluator() Evaluator {
 	return &noopEvaluator{}
@@ -18,13 +18,18 @@ package storage
 
 import (
 	"log/slog"
+	"strconv"
 
 	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 
 	"github.com/webmeshproj/webmesh/pkg/context"
 	"github.com/webmeshproj/webmesh/pkg/services/rbac"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
 	"github.com/webmeshproj/webmesh/pkg/storage/types"
 )
 
@@ -45,6 +50,13 @@ func (s *Server) Publish(ctx context.Context, req *v1.PublishRequest) (*v1.Publi
 		// In theory - non-raft members shouldn't even expose the Node service.
 		return nil, status.Error(codes.Unavailable, "node not available to publish")
 	}
+	txn, ok, err := storage.TxnFromContext(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid transaction: %v", err)
+	}
+	if ok {
+		return s.publishTxn(ctx, req, txn)
+	}
 	allowed, err := s.rbac.Evaluate(ctx, canPublishAction.For(string(req.GetKey())))
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "failed to evaluate publish permissions: %v", err)
@@ -56,10 +68,92 @@ func (s *Server) Publish(ctx context.Context, req *v1.PublishRequest) (*v1.Publi
 	if types.IsReservedPrefix(req.GetKey()) {
 		return nil, status.Errorf(codes.InvalidArgument, "key %q is reserved", req.GetKey())
 	}
-	// TODO: Validate key and value and check for overlaps and other issues.
-	err = s.storage.MeshStorage().PutValue(ctx, req.GetKey(), req.GetValue(), req.GetTtl().AsDuration())
+	// TODO: Validate key and value.
+	op := storage.Op{
+		Type:  storage.OpPut,
+		Key:   req.GetKey(),
+		Value: req.GetValue(),
+		TTL:   req.GetTtl().AsDuration(),
+		Owner: callerID(ctx),
+	}
+	if err := s.admit(ctx, []storage.Op{op}); err != nil {
+		return nil, err
+	}
+	if st, ok := s.storage.MeshStorage().(storage.TxnStorage); ok {
+		// Write through a transaction so the owner of the key is recorded.
+		_, err = st.Txn(ctx, &storage.Txn{Success: []storage.Op{op}})
+	} else {
+		err = s.storage.MeshStorage().PutValue(ctx, op.Key, op.Value, op.TTL)
+	}
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "error publishing: %v", err)
 	}
+	storage.SendAppliedIndex(ctx, s.storage)
+	return &v1.PublishResponse{}, nil
+}
+
+// admit checks the given operations against the quotas of the namespaces
+// they write to.
+func (s *Server) admit(ctx context.Context, ops []storage.Op) error {
+	err := s.namespaces.Admit(ctx, ops)
+	if err != nil {
+		if errors.Is(err, errors.ErrQuotaExceeded) {
+			return status.Error(codes.ResourceExhausted, err.Error())
+		}
+		return status.Errorf(codes.Internal, "failed to check namespace quotas: %v", err)
+	}
+	return nil
+}
+
+// publishTxn applies a transaction sent with a Publish request. The caller must
+// be allowed to publish to every key the transaction compares or writes.
+func (s *Server) publishTxn(ctx context.Context, req *v1.PublishRequest, txn *storage.Txn) (*v1.PublishResponse, error) {
+	st, ok := s.storage.MeshStorage().(storage.TxnStorage)
+	if !ok {
+		return nil, status.Error(codes.Unimplemented, errors.ErrTxnNotSupported.Error())
+	}
+	if len(req.GetKey()) > 0 {
+		txn.Success = append(txn.Success, storage.Op{
+			Type:  storage.OpPut,
+			Key:   req.GetKey(),
+			Value: req.GetValue(),
+			TTL:   req.GetTtl().AsDuration(),
+		})
+	}
+	// The revision is always assigned by the storage.
+	txn.Revision = 0
+	if err := txn.Validate(); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	for _, key := range txn.Keys() {
+		allowed, err := s.rbac.Evaluate(ctx, canPublishAction.For(string(key)))
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to evaluate publish permissions: %v", err)
+		}
+		if !allowed {
+			s.log.Warn("caller not allowed to publish", slog.String("key", string(key)))
+			return nil, status.Error(codes.PermissionDenied, "not allowed")
+		}
+	}
+	owner := callerID(ctx)
+	for _, ops := range [][]storage.Op{txn.Success, txn.Failure} {
+		for i := range ops {
+			if ops[i].Type == storage.OpPut {
+				ops[i].Owner = owner
+			}
+		}
+		if err := s.admit(ctx, ops); err != nil {
+			return nil, err
+		}
+	}
+	succeeded, err := st.Txn(ctx, txn)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "error applying transaction: %v", err)
+	}
+	err = grpc.SetHeader(ctx, metadata.Pairs(storage.TxnSucceededMeta, strconv.FormatBool(succeeded)))
+	if err != nil {
+		s.log.Warn("failed to set transaction header", slog.String("error", err.Error()))
+	}
+	storage.SendAppliedIndex(ctx, s.storage)
 	return &v1.PublishResponse{}, nil
 }
@@ -18,13 +18,19 @@ package storage
 
 import (
 	"log/slog"
+	"strconv"
 
 	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 
 	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/services/rbac"
+	"github.com/webmeshproj/webmesh/pkg/storage"
 	"github.com/webmeshproj/webmesh/pkg/storage/rpcsrv"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
 )
 
 func (s *Server) Query(ctx context.Context, req *v1.QueryRequest) (*v1.QueryResponse, error) {
@@ -33,9 +39,128 @@ func (s *Server) Query(ctx context.Context, req *v1.QueryRequest) (*v1.QueryResp
 		s.log.Warn("Received Query request from out of network", slog.String("peer", addr.String()))
 		return nil, status.Errorf(codes.PermissionDenied, "request is not in-network")
 	}
-	if !s.storage.Consensus().IsMember() {
+	if !s.storage.Consensus().IsMember() && !(storage.IsReadReplica(s.storage) && isReadQuery(req)) {
 		// In theory - non-storage members shouldn't even expose the Node service.
+		// Read replicas only serve reads.
 		return nil, status.Error(codes.Unavailable, "node not available to query")
 	}
-	return rpcsrv.ServeQuery(ctx, s.storage, req), nil
+	if err := s.authorizeQuery(ctx, req); err != nil {
+		return nil, err
+	}
+	if isReadQuery(req) {
+		if err := rpcsrv.WaitForRead(ctx, s.storage); err != nil {
+			return nil, err
+		}
+	}
+	var res *v1.QueryResponse
+	if op, ok := kvWriteOp(ctx, req); ok {
+		res = s.writeKV(ctx, req, op)
+	} else {
+		res = rpcsrv.ServeQuery(ctx, s.storage, req)
+	}
+	if req.GetCommand() == v1.QueryRequest_GET && req.GetType() == v1.QueryRequest_VALUE && res.GetError() == "" {
+		s.sendVersion(ctx, req)
+	}
+	storage.SendAppliedIndex(ctx, s.storage)
+	return res, nil
+}
+
+func isReadQuery(req *v1.QueryRequest) bool {
+	return req.GetCommand() == v1.QueryRequest_GET || req.GetCommand() == v1.QueryRequest_LIST
+}
+
+// sendVersion sends the version of a queried value in the response header
+// so it can be used for compare-and-swap.
+func (s *Server) sendVersion(ctx context.Context, req *v1.QueryRequest) {
+	st, ok := s.storage.MeshStorage().(storage.TxnStorage)
+	if !ok {
+		return
+	}
+	query, err := types.ParseStorageQuery(req)
+	if err != nil {
+		return
+	}
+	id, _ := query.Filters().GetID()
+	version, err := st.GetVersion(ctx, []byte(id))
+	if err != nil {
+		return
+	}
+	err = grpc.SetHeader(ctx, metadata.Pairs(storage.VersionMeta, strconv.FormatUint(version, 10)))
+	if err != nil {
+		s.log.Warn("failed to set version header", slog.String("error", err.Error()))
+	}
+}
+
+// authorizeQuery checks that the caller may access the generic keys a query
+// reads or writes. Queries for reserved keys and typed resources are served
+// unchecked, since non-storage members rely on them, except for restricted
+// prefixes.
+func (s *Server) authorizeQuery(ctx context.Context, req *v1.QueryRequest) error {
+	if req.GetType() != v1.QueryRequest_VALUE && req.GetType() != v1.QueryRequest_KEYS {
+		return nil
+	}
+	key, _ := types.ParseQueryFilters(req).GetID()
+	if err := s.authorizeRestricted(ctx, []byte(key)); err != nil {
+		return err
+	}
+	if types.IsReservedPrefix([]byte(key)) {
+		return nil
+	}
+	var verb v1.RuleVerb
+	switch req.GetCommand() {
+	case v1.QueryRequest_GET, v1.QueryRequest_LIST:
+		verb = v1.RuleVerb_VERB_GET
+	case v1.QueryRequest_PUT:
+		verb = v1.RuleVerb_VERB_PUT
+	case v1.QueryRequest_DELETE:
+		verb = v1.RuleVerb_VERB_DELETE
+	default:
+		return nil
+	}
+	action := rbac.Actions{{Verb: verb, Resource: v1.RuleResource_RESOURCE_PUBSUB}}
+	allowed, err := s.rbac.Evaluate(ctx, action.For(key))
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to evaluate query permissions: %v", err)
+	}
+	if !allowed {
+		s.log.Warn("caller not allowed to query key", slog.String("key", key))
+		return status.Error(codes.PermissionDenied, "not allowed")
+	}
+	if op, ok := kvWriteOp(ctx, req); ok && op.Type == storage.OpPut {
+		return s.admit(ctx, []storage.Op{op})
+	}
+	return nil
+}
+
+// kvWriteOp returns the operation for a query that writes a generic key.
+func kvWriteOp(ctx context.Context, req *v1.QueryRequest) (storage.Op, bool) {
+	if req.GetType() != v1.QueryRequest_VALUE {
+		return storage.Op{}, false
+	}
+	key, ok := types.ParseQueryFilters(req).GetID()
+	if !ok || types.IsReservedPrefix([]byte(key)) {
+		return storage.Op{}, false
+	}
+	switch req.GetCommand() {
+	case v1.QueryRequest_PUT:
+		return storage.Op{Type: storage.OpPut, Key: []byte(key), Value: req.GetItem(), Owner: callerID(ctx)}, true
+	case v1.QueryRequest_DELETE:
+		return storage.Op{Type: storage.OpDelete, Key: []byte(key)}, true
+	default:
+		return storage.Op{}, false
+	}
+}
+
+// writeKV applies a query writing a generic key through a transaction, so
+// the owner of the key and the usage of its namespace are recorded the same
+// way as for a Publish.
+func (s *Server) writeKV(ctx context.Context, req *v1.QueryRequest, op storage.Op) *v1.QueryResponse {
+	st, ok := s.storage.MeshStorage().(storage.TxnStorage)
+	if !ok {
+		return rpcsrv.ServeQuery(ctx, s.storage, req)
+	}
+	if _, err := st.Txn(ctx, &storage.Txn{Success: []storage.Op{op}}); err != nil {
+		return &v1.QueryResponse{Error: err.Error()}
+	}
+	return &v1.QueryResponse{}
 }
@@ -21,11 +21,17 @@ import (
 	"log/slog"
 
 	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 
 	"github.com/webmeshproj/webmesh/pkg/context"
 	"github.com/webmeshproj/webmesh/pkg/meshnet"
+	"github.com/webmeshproj/webmesh/pkg/services/leaderproxy"
 	"github.com/webmeshproj/webmesh/pkg/services/rbac"
 	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/namespaces"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
 )
 
 // Ensure we implement the interface.
@@ -35,18 +41,69 @@ var _ v1.StorageQueryServiceServer = (*Server)(nil)
 type Server struct {
 	v1.UnimplementedStorageQueryServiceServer
 
-	storage storage.Provider
-	rbac    rbac.Evaluator
-	mnet    meshnet.Manager
-	log     *slog.Logger
+	storage    storage.Provider
+	namespaces *namespaces.Store
+	rbac       rbac.Evaluator
+	mnet       meshnet.Manager
+	log        *slog.Logger
 }
 
 // NewServer returns a new storage Server.
 func NewServer(ctx context.Context, storage storage.Provider, rbac rbac.Evaluator, mnet meshnet.Manager) *Server {
 	return &Server{
-		storage: storage,
-		rbac:    rbac,
-		mnet:    mnet,
-		log:     context.LoggerFrom(ctx).With("component", "storage-server"),
+		storage:    storage,
+		namespaces: namespaces.New(storage.MeshStorage()),
+		rbac:       rbac,
+		mnet:       mnet,
+		log:        context.LoggerFrom(ctx).With("component", "storage-server"),
 	}
 }
+
+// callerID returns the ID of the node that made the request, or an empty
+// string if it is not known.
+func callerID(ctx context.Context) types.NodeID {
+	if proxiedFor, ok := leaderproxy.ProxiedFor(ctx); ok {
+		return types.NodeID(proxiedFor)
+	}
+	if peer, ok := context.AuthenticatedCallerFrom(ctx); ok {
+		return types.NodeID(peer)
+	}
+	return ""
+}
+
+// authorizeRestricted checks that the caller may access the given key or prefix
+// when it overlaps a prefix that is not shared with every member. Secrets may
+// be accessed by storage members and MeshDNS servers, which sign zones with the
+// keys kept there. The audit log is only served through the audit API.
+func (s *Server) authorizeRestricted(ctx context.Context, key []byte) error {
+	var features []v1.Feature
+	switch {
+	case types.AuditPrefix.Overlaps(key):
+		features = []v1.Feature{v1.Feature_STORAGE_PROVIDER}
+	case types.SecretsPrefix.Overlaps(key):
+		features = []v1.Feature{v1.Feature_STORAGE_PROVIDER, v1.Feature_MESH_DNS}
+	default:
+		return nil
+	}
+	if !s.rbac.IsSecure() {
+		return nil
+	}
+	id := callerID(ctx)
+	if id == "" {
+		return status.Error(codes.PermissionDenied, "not allowed")
+	}
+	node, err := s.storage.MeshDB().Peers().Get(ctx, id)
+	if err != nil {
+		if errors.IsNodeNotFound(err) {
+			return status.Error(codes.PermissionDenied, "not allowed")
+		}
+		return status.Errorf(codes.Internal, "failed to get caller: %v", err)
+	}
+	for _, feature := range features {
+		if node.HasFeature(feature) {
+			return nil
+		}
+	}
+	s.log.Warn("caller not allowed to access restricted keys", slog.String("caller", id.String()), slog.String("key", string(key)))
+	return status.Error(codes.PermissionDenied, "not allowed")
+}
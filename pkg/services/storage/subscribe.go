@@ -18,13 +18,17 @@ package storage
 
 import (
 	"log/slog"
+	"strconv"
 
 	v1 "github.com/webmeshproj/api/go/v1"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 
 	"github.com/webmeshproj/webmesh/pkg/context"
 	"github.com/webmeshproj/webmesh/pkg/services/rbac"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
 	"github.com/webmeshproj/webmesh/pkg/storage/types"
 )
 
@@ -41,10 +45,13 @@ func (s *Server) Subscribe(req *v1.SubscribeRequest, srv v1.StorageQueryService_
 		s.log.Warn("Received Subscribe request from out of network", slog.String("peer", addr.String()))
 		return status.Errorf(codes.PermissionDenied, "request is not in-network")
 	}
-	if !s.storage.Consensus().IsMember() {
+	if !s.storage.Consensus().IsMember() && !storage.IsReadReplica(s.storage) {
 		// In theory - non-raft members shouldn't even expose the Node service.
 		return status.Error(codes.Unavailable, "current node not available to subscribe")
 	}
+	if err := s.authorizeRestricted(srv.Context(), req.GetPrefix()); err != nil {
+		return err
+	}
 	if !types.IsReservedPrefix(req.GetPrefix()) {
 		// Don't allow subscriptions to generic prefixes without permissions
 		allowed, err := s.rbac.Evaluate(srv.Context(), canSubscribeAction.For(string(req.GetPrefix())))
@@ -56,6 +63,13 @@ func (s *Server) Subscribe(req *v1.SubscribeRequest, srv v1.StorageQueryService_
 			return status.Error(codes.PermissionDenied, "not allowed")
 		}
 	}
+	revision, ok, err := storage.WatchRevisionFromContext(srv.Context())
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	if ok {
+		return s.watch(req, revision, srv)
+	}
 	cancel, err := s.storage.MeshStorage().Subscribe(srv.Context(), req.GetPrefix(), func(key, value []byte) {
 		err := srv.Send(&v1.SubscriptionEvent{
 			Key:   key,
@@ -72,3 +86,103 @@ func (s *Server) Subscribe(req *v1.SubscribeRequest, srv v1.StorageQueryService_
 	<-srv.Context().Done()
 	return nil
 }
+
+// watch sends revisioned events for the prefix of the request as JSON encoded
+// storage.WatchEvents, resuming after the given revision.
+func (s *Server) watch(req *v1.SubscribeRequest, revision uint64, srv v1.StorageQueryService_SubscribeServer) error {
+	ctx, cancel := context.WithCancel(srv.Context())
+	defer cancel()
+	st, ok := s.storage.MeshStorage().(storage.WatchStorage)
+	if !ok {
+		return status.Error(codes.Unimplemented, errors.ErrWatchNotSupported.Error())
+	}
+	snapshot := storage.WatchSnapshotFromContext(ctx)
+	if snapshot && revision != 0 {
+		return status.Error(codes.InvalidArgument, "a snapshot can only be sent with a watch from the latest revision")
+	}
+	if revision == 0 {
+		current, err := st.Revision(ctx)
+		if err != nil {
+			return status.Errorf(codes.Internal, "error getting revision: %v", err)
+		}
+		revision = current
+	}
+	err := srv.SetHeader(metadata.Pairs(storage.RevisionMeta, strconv.FormatUint(revision, 10)))
+	if err != nil {
+		return err
+	}
+	events := make(chan storage.WatchEvent, 32)
+	done, err := st.Watch(ctx, req.GetPrefix(), revision, func(ev storage.WatchEvent) {
+		select {
+		case events <- ev:
+		case <-ctx.Done():
+		}
+	})
+	if err != nil {
+		return watchError(revision, err)
+	}
+	// Send the header right away so callers watching from now learn the revision.
+	if err := srv.SendHeader(nil); err != nil {
+		return err
+	}
+	if snapshot {
+		// Changes made while iterating are also delivered by the watch, so
+		// callers applying events in order converge on the latest values.
+		if err := s.sendSnapshot(ctx, req.GetPrefix(), revision, srv); err != nil {
+			return err
+		}
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err, ok := <-done:
+			if !ok || err == nil {
+				return nil
+			}
+			return watchError(revision, err)
+		case ev := <-events:
+			data, err := storage.MarshalWatchEvent(ev)
+			if err != nil {
+				return status.Errorf(codes.Internal, "error encoding watch event: %v", err)
+			}
+			if err := srv.Send(&v1.SubscriptionEvent{Key: ev.Key, Value: data}); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// sendSnapshot sends the current values of the prefix as put events at the
+// given revision, followed by a synced event.
+func (s *Server) sendSnapshot(ctx context.Context, prefix []byte, revision uint64, srv v1.StorageQueryService_SubscribeServer) error {
+	send := func(ev storage.WatchEvent) error {
+		data, err := storage.MarshalWatchEvent(ev)
+		if err != nil {
+			return status.Errorf(codes.Internal, "error encoding watch event: %v", err)
+		}
+		return srv.Send(&v1.SubscriptionEvent{Key: ev.Key, Value: data})
+	}
+	err := s.storage.MeshStorage().IterPrefix(ctx, prefix, func(key, value []byte) error {
+		return send(storage.WatchEvent{
+			Type:     storage.EventPut,
+			Key:      key,
+			Value:    value,
+			Revision: revision,
+		})
+	})
+	if err != nil {
+		if _, ok := status.FromError(err); ok {
+			return err
+		}
+		return status.Errorf(codes.Internal, "error sending snapshot: %v", err)
+	}
+	return send(storage.WatchEvent{Type: storage.EventSynced, Key: prefix, Revision: revision})
+}
+
+func watchError(revision uint64, err error) error {
+	if errors.Is(err, errors.ErrCompacted) {
+		return status.Errorf(codes.OutOfRange, "watch from revision %d: %v", revision, err)
+	}
+	return status.Errorf(codes.Internal, "error watching: %v", err)
+}
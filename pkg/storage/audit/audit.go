@@ -0,0 +1,181 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package audit provides the audit log of mesh mutations on top of the
+// mesh storage.
+package audit
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// DefaultRetention is how long audit entries are kept by default.
+const DefaultRetention = 30 * 24 * time.Hour
+
+// Store manages the audit log in the mesh storage.
+type Store struct {
+	st        storage.MeshStorage
+	retention time.Duration
+}
+
+// New returns a new audit Store using the given storage. Entries expire after
+// the given retention, or are kept forever when it is zero.
+func New(st storage.MeshStorage, retention time.Duration) *Store {
+	return &Store{st: st, retention: retention}
+}
+
+// Put stores an entry in the audit log. The ID and time of the entry are
+// set when they are empty.
+func (s *Store) Put(ctx context.Context, e types.AuditEntry) error {
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	if e.ID == "" {
+		e.ID = newID(e.Time)
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("marshal audit entry: %w", err)
+	}
+	err = s.st.PutValue(ctx, types.AuditPrefix.ForString(e.ID), data, s.retention)
+	if err != nil {
+		return fmt.Errorf("put audit entry: %w", err)
+	}
+	return nil
+}
+
+// Filter selects entries from the audit log. Zero values match everything.
+type Filter struct {
+	// Since matches entries recorded at or after the time.
+	Since time.Time `json:"since,omitempty"`
+	// Until matches entries recorded before the time.
+	Until time.Time `json:"until,omitempty"`
+	// Principal matches entries made by or proxied for the identity.
+	Principal string `json:"principal,omitempty"`
+	// Method matches entries whose full method name contains the string.
+	Method string `json:"method,omitempty"`
+	// Resource matches entries whose resource starts with the string.
+	Resource string `json:"resource,omitempty"`
+	// Limit is the maximum number of entries to return. The most recent
+	// entries are returned when there are more.
+	Limit int `json:"limit,omitempty"`
+}
+
+// Matches returns true if the entry is selected by the filter.
+func (f Filter) Matches(e types.AuditEntry) bool {
+	if !f.Since.IsZero() && e.Time.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && !e.Time.Before(f.Until) {
+		return false
+	}
+	if f.Principal != "" && e.Caller != f.Principal && e.ProxiedFor != f.Principal {
+		return false
+	}
+	if f.Method != "" && !strings.Contains(e.Method, f.Method) {
+		return false
+	}
+	if f.Resource != "" && !strings.HasPrefix(e.Resource, f.Resource) {
+		return false
+	}
+	return true
+}
+
+// List returns the entries selected by the filter, oldest first.
+func (s *Store) List(ctx context.Context, f Filter) ([]types.AuditEntry, error) {
+	var out []types.AuditEntry
+	err := s.st.IterPrefix(ctx, types.AuditPrefix, func(key, value []byte) error {
+		if string(key) == types.AuditPrefix.String() {
+			return nil
+		}
+		var e types.AuditEntry
+		if err := json.Unmarshal(value, &e); err != nil {
+			return fmt.Errorf("unmarshal audit entry: %w", err)
+		}
+		if !f.Matches(e) {
+			return nil
+		}
+		out = append(out, e)
+		if f.Limit > 0 && len(out) > f.Limit {
+			out = out[1:]
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list audit entries: %w", err)
+	}
+	return out, nil
+}
+
+// newID returns an entry ID that sorts by the given time.
+func newID(t time.Time) string {
+	var b [4]byte
+	_, _ = rand.Read(b[:])
+	return fmt.Sprintf("%020d-%s", t.UnixNano(), hex.EncodeToString(b[:]))
+}
+
+type recorderKey struct{}
+
+// Recorder collects the changes made to the mesh storage while a request is
+// handled. Storage providers that support auditing add the changes they apply
+// for a context carrying a Recorder.
+type Recorder struct {
+	changes []types.AuditChange
+	mu      sync.Mutex
+}
+
+// ContextWithRecorder returns a context that records the changes made with it.
+func ContextWithRecorder(ctx context.Context) (context.Context, *Recorder) {
+	r := &Recorder{}
+	return context.WithValue(ctx, recorderKey{}, r), r
+}
+
+// RecorderFrom returns the Recorder of the context, if any.
+func RecorderFrom(ctx context.Context) (*Recorder, bool) {
+	r, ok := ctx.Value(recorderKey{}).(*Recorder)
+	return r, ok
+}
+
+// Record adds a change. Changes to the audit log itself are ignored, and
+// the values of secrets are left out.
+func (r *Recorder) Record(change types.AuditChange) {
+	if types.AuditPrefix.Contains([]byte(change.Key)) {
+		return
+	}
+	if types.SecretsPrefix.Contains([]byte(change.Key)) {
+		change.Before, change.After = "", ""
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.changes = append(r.changes, change)
+}
+
+// Changes returns the changes recorded so far.
+func (r *Recorder) Changes() []types.AuditChange {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]types.AuditChange(nil), r.changes...)
+}
@@ -0,0 +1,91 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/webmeshproj/webmesh/pkg/storage/providers/backends/badgerdb"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+func TestAuditLog(t *testing.T) {
+	ctx := context.Background()
+	st := badgerdb.NewTestStorage(false)
+	defer st.Close()
+	store := New(st, time.Hour)
+	start := time.Now().UTC().Add(-time.Hour)
+	entries := []types.AuditEntry{
+		{Time: start, Caller: "admin", Method: "/v1.Admin/PutRole", Resource: "roles/viewer"},
+		{Time: start.Add(time.Minute), Caller: "node-a", ProxiedFor: "alice", Method: "/v1.Admin/PutGroup", Resource: "groups/devs"},
+		{Time: start.Add(2 * time.Minute), Caller: "bob", Method: "/v1.Membership/Join", Resource: "nodes/bob"},
+	}
+	for _, e := range entries {
+		if err := store.Put(ctx, e); err != nil {
+			t.Fatalf("put entry: %v", err)
+		}
+	}
+
+	tc := []struct {
+		name   string
+		filter Filter
+		want   []string
+	}{
+		{"All", Filter{}, []string{"roles/viewer", "groups/devs", "nodes/bob"}},
+		{"Since", Filter{Since: start.Add(time.Minute)}, []string{"groups/devs", "nodes/bob"}},
+		{"Until", Filter{Until: start.Add(time.Minute)}, []string{"roles/viewer"}},
+		{"ProxiedFor", Filter{Principal: "alice"}, []string{"groups/devs"}},
+		{"Method", Filter{Method: "Admin"}, []string{"roles/viewer", "groups/devs"}},
+		{"Resource", Filter{Resource: "nodes/"}, []string{"nodes/bob"}},
+		{"Limit", Filter{Limit: 2}, []string{"groups/devs", "nodes/bob"}},
+	}
+	for _, c := range tc {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := store.List(ctx, c.filter)
+			if err != nil {
+				t.Fatalf("list entries: %v", err)
+			}
+			if len(got) != len(c.want) {
+				t.Fatalf("expected %d entries, got %d", len(c.want), len(got))
+			}
+			for i, e := range got {
+				if e.Resource != c.want[i] {
+					t.Errorf("entry %d: expected resource %q, got %q", i, c.want[i], e.Resource)
+				}
+				if e.ID == "" {
+					t.Errorf("entry %d: expected an ID to be assigned", i)
+				}
+			}
+		})
+	}
+
+	t.Run("RecorderIgnoresAuditKeysAndSecrets", func(t *testing.T) {
+		_, rec := ContextWithRecorder(ctx)
+		rec.Record(types.AuditChange{Key: types.AuditPrefix.ForString("x").String(), After: "x"})
+		rec.Record(types.AuditChange{Key: "/registry/roles/viewer", After: "{}"})
+		rec.Record(types.AuditChange{Key: types.MeshDNSKeysPrefix.ForString("mesh").String(), After: "private"})
+		changes := rec.Changes()
+		if len(changes) != 2 {
+			t.Fatalf("expected 2 changes, got %d", len(changes))
+		}
+		if changes[1].After != "" {
+			t.Errorf("expected the secret value to be left out, got %q", changes[1].After)
+		}
+	})
+}
@@ -0,0 +1,68 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package autopilot provides the state of the raft autopilot on top of
+// the mesh storage.
+package autopilot
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// Store manages the autopilot state in the mesh storage.
+type Store struct {
+	st storage.MeshStorage
+}
+
+// New returns a new autopilot Store using the given storage.
+func New(st storage.MeshStorage) *Store {
+	return &Store{st: st}
+}
+
+// Put stores the autopilot state.
+func (s *Store) Put(ctx context.Context, state types.AutopilotState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("marshal autopilot state: %w", err)
+	}
+	err = s.st.PutValue(ctx, types.AutopilotStateKey, data, 0)
+	if err != nil {
+		return fmt.Errorf("put autopilot state: %w", err)
+	}
+	return nil
+}
+
+// Get returns the autopilot state.
+func (s *Store) Get(ctx context.Context) (types.AutopilotState, error) {
+	var state types.AutopilotState
+	data, err := s.st.GetValue(ctx, types.AutopilotStateKey)
+	if err != nil {
+		if errors.IsKeyNotFound(err) {
+			return state, errors.ErrAutopilotNotRunning
+		}
+		return state, fmt.Errorf("get autopilot state: %w", err)
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return state, fmt.Errorf("unmarshal autopilot state: %w", err)
+	}
+	return state, nil
+}
@@ -0,0 +1,238 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+)
+
+const (
+	// ConsistencyMeta is the metadata key for the consistency requested for
+	// a Query, GetNode, or ListNodes request.
+	ConsistencyMeta = "x-webmesh-consistency"
+	// MaxStalenessMeta is the metadata key for how far behind the leader a
+	// bounded-staleness read may be.
+	MaxStalenessMeta = "x-webmesh-max-staleness"
+	// MinIndexMeta is the metadata key for the log index a node must have
+	// applied before serving a read.
+	MinIndexMeta = "x-webmesh-min-index"
+	// AppliedIndexMeta is the metadata key for the log index applied by the
+	// node that served a request, sent in the header of the response.
+	AppliedIndexMeta = "x-webmesh-applied-index"
+)
+
+// Consistency is the consistency level of a read.
+type Consistency string
+
+const (
+	// ConsistencyStale reads from the local state of whichever node serves
+	// the request. It is the default.
+	ConsistencyStale Consistency = "stale"
+	// ConsistencyBounded reads from a node that heard from the leader within
+	// the maximum staleness of the read.
+	ConsistencyBounded Consistency = "bounded"
+	// ConsistencyLinearizable reads from the leader after it confirmed its
+	// leadership and applied every committed entry.
+	ConsistencyLinearizable Consistency = "linearizable"
+)
+
+// ReadConsistency is the consistency requested for a read.
+type ReadConsistency struct {
+	// Level is the consistency level. Empty is the same as ConsistencyStale.
+	Level Consistency
+	// MaxStaleness is how long ago a bounded-staleness read may have
+	// last heard from the leader.
+	MaxStaleness time.Duration
+	// MinIndex is the log index that must be applied before the read is
+	// served, regardless of the level. It gives read-your-writes when set to
+	// the index returned by a previous write.
+	MinIndex uint64
+}
+
+// Validate validates the read consistency.
+func (r ReadConsistency) Validate() error {
+	switch r.Level {
+	case "", ConsistencyStale, ConsistencyLinearizable:
+	case ConsistencyBounded:
+		if r.MaxStaleness <= 0 {
+			return fmt.Errorf("%w: bounded reads require a max staleness", errors.ErrInvalidConsistency)
+		}
+	default:
+		return fmt.Errorf("%w: unknown level %q", errors.ErrInvalidConsistency, r.Level)
+	}
+	return nil
+}
+
+// IsStale returns true if the read can be served from any local state.
+func (r ReadConsistency) IsStale() bool {
+	return (r.Level == "" || r.Level == ConsistencyStale) && r.MinIndex == 0
+}
+
+// ConsistentReader is implemented by storage providers that can serve reads
+// at a requested consistency.
+type ConsistentReader interface {
+	// AppliedIndex returns the last log index applied to the local state.
+	AppliedIndex() uint64
+	// WaitForRead blocks until the local state satisfies the read consistency.
+	// It returns errors.ErrNotLeader for linearizable reads on followers and
+	// errors.ErrStaleRead if the consistency cannot be met before the context
+	// is done.
+	WaitForRead(ctx context.Context, rc ReadConsistency) error
+}
+
+// WaitForRead waits until the provider can serve a read with the given
+// consistency. Providers that are not a ConsistentReader only serve stale reads.
+func WaitForRead(ctx context.Context, p Provider, rc ReadConsistency) error {
+	if err := rc.Validate(); err != nil {
+		return err
+	}
+	if rc.IsStale() {
+		return nil
+	}
+	cr, ok := p.(ConsistentReader)
+	if !ok {
+		return errors.ErrConsistencyNotSupported
+	}
+	return cr.WaitForRead(ctx, rc)
+}
+
+// SendAppliedIndex sends the log index applied by the provider in the header
+// of the response. It does nothing for providers that are not a ConsistentReader.
+func SendAppliedIndex(ctx context.Context, p Provider) {
+	cr, ok := p.(ConsistentReader)
+	if !ok {
+		return
+	}
+	_ = grpc.SetHeader(ctx, metadata.Pairs(AppliedIndexMeta, strconv.FormatUint(cr.AppliedIndex(), 10)))
+}
+
+// ContextWithReadConsistency returns an outgoing context that requests the
+// given consistency for reads.
+func ContextWithReadConsistency(ctx context.Context, rc ReadConsistency) context.Context {
+	if rc.Level != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, ConsistencyMeta, string(rc.Level))
+	}
+	if rc.MaxStaleness > 0 {
+		ctx = metadata.AppendToOutgoingContext(ctx, MaxStalenessMeta, rc.MaxStaleness.String())
+	}
+	if rc.MinIndex > 0 {
+		ctx = metadata.AppendToOutgoingContext(ctx, MinIndexMeta, strconv.FormatUint(rc.MinIndex, 10))
+	}
+	return ctx
+}
+
+// ReadConsistencyFromContext returns the consistency requested with an
+// incoming request.
+func ReadConsistencyFromContext(ctx context.Context) (ReadConsistency, error) {
+	var rc ReadConsistency
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return rc, nil
+	}
+	if vals := md.Get(ConsistencyMeta); len(vals) > 0 {
+		rc.Level = Consistency(vals[0])
+	}
+	if vals := md.Get(MaxStalenessMeta); len(vals) > 0 && vals[0] != "" {
+		d, err := time.ParseDuration(vals[0])
+		if err != nil {
+			return rc, fmt.Errorf("%w: invalid max staleness %q", errors.ErrInvalidConsistency, vals[0])
+		}
+		rc.MaxStaleness = d
+	}
+	// Take the highest index when several were appended along the way.
+	for _, val := range md.Get(MinIndexMeta) {
+		idx, err := strconv.ParseUint(val, 10, 64)
+		if err != nil {
+			return rc, fmt.Errorf("%w: invalid min index %q", errors.ErrInvalidConsistency, val)
+		}
+		rc.MinIndex = max(rc.MinIndex, idx)
+	}
+	return rc, rc.Validate()
+}
+
+// AppliedIndexFromHeader returns the applied index sent in the header of a
+// response, or zero if it was not sent.
+func AppliedIndexFromHeader(md metadata.MD) uint64 {
+	vals := md.Get(AppliedIndexMeta)
+	if len(vals) == 0 {
+		return 0
+	}
+	idx, _ := strconv.ParseUint(vals[0], 10, 64)
+	return idx
+}
+
+// IndexTracker tracks the highest applied index returned by storage nodes and
+// requests it as the minimum index of later calls. Calls made through it read
+// their own writes, whichever node serves them.
+type IndexTracker struct {
+	index atomic.Uint64
+}
+
+// Index returns the highest index seen.
+func (t *IndexTracker) Index() uint64 {
+	return t.index.Load()
+}
+
+// Observe records the applied index in the header of a response.
+func (t *IndexTracker) Observe(header metadata.MD) {
+	index := AppliedIndexFromHeader(header)
+	for {
+		current := t.index.Load()
+		if index <= current || t.index.CompareAndSwap(current, index) {
+			return
+		}
+	}
+}
+
+// Conn returns a client connection that tracks the applied index of every
+// unary call made with it. Streams request the index but do not update it.
+func (t *IndexTracker) Conn(cc grpc.ClientConnInterface) grpc.ClientConnInterface {
+	return &trackedConn{cc: cc, t: t}
+}
+
+type trackedConn struct {
+	cc grpc.ClientConnInterface
+	t  *IndexTracker
+}
+
+func (c *trackedConn) Invoke(ctx context.Context, method string, args any, reply any, opts ...grpc.CallOption) error {
+	var header metadata.MD
+	err := c.cc.Invoke(c.t.outgoing(ctx), method, args, reply, append(opts, grpc.Header(&header))...)
+	c.t.Observe(header)
+	return err
+}
+
+func (c *trackedConn) NewStream(ctx context.Context, desc *grpc.StreamDesc, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	return c.cc.NewStream(c.t.outgoing(ctx), desc, method, opts...)
+}
+
+func (t *IndexTracker) outgoing(ctx context.Context) context.Context {
+	index := t.Index()
+	if index == 0 {
+		return ctx
+	}
+	return metadata.AppendToOutgoingContext(ctx, MinIndexMeta, strconv.FormatUint(index, 10))
+}
@@ -0,0 +1,119 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package dnsrecords provides custom DNS records on top of the mesh storage.
+//
+// Records live under the mesh domain or under additional zones delegated to
+// MeshDNS. They are identified by their name and type and are served by
+// every MeshDNS instance in the mesh.
+package dnsrecords
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/meshdb/state"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// Store manages custom DNS records in the mesh storage.
+type Store struct {
+	st    storage.MeshStorage
+	state storage.MeshState
+}
+
+// New returns a new record Store using the given storage.
+func New(st storage.MeshStorage) *Store {
+	return &Store{st: st, state: state.New(st)}
+}
+
+// Put creates or updates a record. The record is normalized against the mesh
+// domain before it is stored and the stored record is returned.
+func (s *Store) Put(ctx context.Context, rec types.DNSRecord) (types.DNSRecord, error) {
+	meshState, err := s.state.GetMeshState(ctx)
+	if err != nil {
+		return rec, fmt.Errorf("get mesh state: %w", err)
+	}
+	rec = rec.Normalize(meshState.Domain())
+	if err := rec.Validate(); err != nil {
+		return rec, fmt.Errorf("%w: %w", errors.ErrInvalidDNSRecord, err)
+	}
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return rec, fmt.Errorf("marshal dns record: %w", err)
+	}
+	err = s.st.PutValue(ctx, types.DNSRecordKey(rec.Name, rec.Type), data, 0)
+	if err != nil {
+		return rec, fmt.Errorf("put dns record: %w", err)
+	}
+	return rec, nil
+}
+
+// Get returns the record with the given name and type.
+func (s *Store) Get(ctx context.Context, ref types.DNSRecordRef) (types.DNSRecord, error) {
+	var rec types.DNSRecord
+	key := types.DNSRecordKey(ref.Name, ref.Type)
+	if !types.IsValidPathID(key.String()) {
+		return rec, errors.ErrDNSRecordNotFound
+	}
+	data, err := s.st.GetValue(ctx, key)
+	if err != nil {
+		if errors.IsKeyNotFound(err) {
+			return rec, errors.ErrDNSRecordNotFound
+		}
+		return rec, fmt.Errorf("get dns record: %w", err)
+	}
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return rec, fmt.Errorf("unmarshal dns record: %w", err)
+	}
+	return rec, nil
+}
+
+// Delete removes the record with the given name and type.
+func (s *Store) Delete(ctx context.Context, ref types.DNSRecordRef) error {
+	key := types.DNSRecordKey(ref.Name, ref.Type)
+	if !types.IsValidPathID(key.String()) {
+		return nil
+	}
+	err := s.st.Delete(ctx, key)
+	if err != nil && !errors.IsKeyNotFound(err) {
+		return fmt.Errorf("delete dns record: %w", err)
+	}
+	return nil
+}
+
+// List returns all records.
+func (s *Store) List(ctx context.Context) ([]types.DNSRecord, error) {
+	var out []types.DNSRecord
+	err := s.st.IterPrefix(ctx, types.DNSRecordsPrefix, func(key, value []byte) error {
+		if string(key) == types.DNSRecordsPrefix.String() {
+			return nil
+		}
+		var rec types.DNSRecord
+		if err := json.Unmarshal(value, &rec); err != nil {
+			return fmt.Errorf("unmarshal dns record: %w", err)
+		}
+		out = append(out, rec)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list dns records: %w", err)
+	}
+	return out, nil
+}
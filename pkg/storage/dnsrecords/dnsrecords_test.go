@@ -0,0 +1,98 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dnsrecords
+
+import (
+	"context"
+	"testing"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/meshdb/state"
+	"github.com/webmeshproj/webmesh/pkg/storage/providers/backends/badgerdb"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+func TestRecords(t *testing.T) {
+	ctx := context.Background()
+	st := badgerdb.NewTestStorage(false)
+	defer st.Close()
+	err := state.New(st).SetMeshState(ctx, types.NetworkState{
+		NetworkState: &v1.NetworkState{
+			NetworkV4: "172.16.0.0/12",
+			NetworkV6: "2001:db8::/64",
+			Domain:    "webmesh.internal",
+		},
+	})
+	if err != nil {
+		t.Fatalf("set network state: %v", err)
+	}
+	store := New(st)
+
+	t.Run("CRUD", func(t *testing.T) {
+		rec, err := store.Put(ctx, types.DNSRecord{Name: "DB", Type: "a", Values: []string{"172.16.0.10"}})
+		if err != nil {
+			t.Fatalf("put record: %v", err)
+		}
+		if rec.Name != "db.webmesh.internal" || rec.Zone != "webmesh.internal" || rec.Type != "A" {
+			t.Fatalf("expected record to be normalized, got %+v", rec)
+		}
+		if rec.TTL != types.DefaultDNSRecordTTL {
+			t.Fatalf("expected default ttl, got %d", rec.TTL)
+		}
+		got, err := store.Get(ctx, rec.Ref())
+		if err != nil {
+			t.Fatalf("get record: %v", err)
+		}
+		if len(got.Values) != 1 || got.Values[0] != "172.16.0.10" {
+			t.Fatalf("expected stored values, got %v", got.Values)
+		}
+		_, err = store.Put(ctx, types.DNSRecord{Name: "api", Zone: "corp.example", Type: "CNAME", Values: []string{"db.webmesh.internal."}})
+		if err != nil {
+			t.Fatalf("put record: %v", err)
+		}
+		list, err := store.List(ctx)
+		if err != nil {
+			t.Fatalf("list records: %v", err)
+		}
+		if len(list) != 2 {
+			t.Fatalf("expected 2 records, got %d", len(list))
+		}
+		if err := store.Delete(ctx, rec.Ref()); err != nil {
+			t.Fatalf("delete record: %v", err)
+		}
+		if _, err := store.Get(ctx, rec.Ref()); !errors.Is(err, errors.ErrDNSRecordNotFound) {
+			t.Fatalf("expected ErrDNSRecordNotFound, got %v", err)
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		tc := []types.DNSRecord{
+			{Name: "mx", Type: "MX", Values: []string{"10 mail.example.com."}},
+			{Name: "empty", Type: "A"},
+			{Name: "bad", Type: "A", Values: []string{"not-an-address"}},
+			{Name: "@", Type: "CNAME", Values: []string{"example.com."}},
+			{Name: "www", Type: "CNAME", Values: []string{"a.example.com.", "b.example.com."}},
+		}
+		for _, rec := range tc {
+			if _, err := store.Put(ctx, rec); !errors.Is(err, errors.ErrInvalidDNSRecord) {
+				t.Errorf("expected ErrInvalidDNSRecord for %+v, got %v", rec, err)
+			}
+		}
+	})
+}
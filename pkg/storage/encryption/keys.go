@@ -0,0 +1,166 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package encryption contains helpers for encrypting storage at rest.
+package encryption
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"golang.org/x/crypto/hkdf"
+
+	"github.com/webmeshproj/webmesh/pkg/crypto"
+)
+
+var (
+	// ErrInvalidKey is returned when an encryption key is not 16, 24 or 32 bytes.
+	ErrInvalidKey = errors.New("encryption key must be 16, 24 or 32 bytes")
+	// ErrUnknownKey is returned when data is encrypted with a key that was not provided.
+	ErrUnknownKey = errors.New("data is encrypted with an unknown key")
+	// ErrTruncated is returned when an encrypted stream ends before its final chunk.
+	ErrTruncated = errors.New("encrypted stream is truncated")
+)
+
+// wireguardKeyInfo is the HKDF info used when deriving a storage key
+// from a node's WireGuard key.
+const wireguardKeyInfo = "webmesh storage encryption"
+
+// Keys are the keys used to encrypt data at rest.
+type Keys struct {
+	// Current is the key new data is encrypted with. An empty key
+	// disables encryption.
+	Current []byte
+	// Previous is a key existing data may still be encrypted with.
+	// Data found encrypted with it is re-encrypted with Current when
+	// the store is opened. Leave empty when not rotating.
+	Previous []byte
+}
+
+// Enabled returns true if data should be encrypted.
+func (k Keys) Enabled() bool {
+	return len(k.Current) > 0
+}
+
+// Rotating returns true if existing data may need to be re-encrypted.
+func (k Keys) Rotating() bool {
+	return len(k.Previous) > 0 && !bytes.Equal(k.Previous, k.Current)
+}
+
+// Validate validates the key lengths.
+func (k Keys) Validate() error {
+	for _, key := range [][]byte{k.Current, k.Previous} {
+		if len(key) > 0 && !validKeyLength(key) {
+			return ErrInvalidKey
+		}
+	}
+	return nil
+}
+
+// Candidates returns the keys existing data may be encrypted with,
+// in the order they should be tried. A nil entry means plaintext.
+func (k Keys) Candidates() [][]byte {
+	candidates := [][]byte{k.Current}
+	if k.Rotating() {
+		candidates = append(candidates, k.Previous)
+	}
+	if k.Enabled() {
+		// Allow encrypting data that was written before encryption was enabled.
+		candidates = append(candidates, nil)
+	}
+	return candidates
+}
+
+// KeyID returns a short, non-secret identifier for the given key.
+// An empty key returns nil.
+func KeyID(key []byte) []byte {
+	if len(key) == 0 {
+		return nil
+	}
+	sum := sha256.Sum256(append([]byte("webmesh-key-id:"), key...))
+	return sum[:8]
+}
+
+// ParseKey parses an encryption key. The data is decoded as hex or
+// base64 when it is valid in either encoding, and is otherwise used
+// as a raw key.
+func ParseKey(data []byte) ([]byte, error) {
+	trimmed := bytes.TrimSpace(data)
+	if key, err := hex.DecodeString(string(trimmed)); err == nil && validKeyLength(key) {
+		return key, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(string(trimmed)); err == nil && validKeyLength(key) {
+		return key, nil
+	}
+	if validKeyLength(data) {
+		return data, nil
+	}
+	if validKeyLength(trimmed) {
+		return trimmed, nil
+	}
+	return nil, ErrInvalidKey
+}
+
+// KeyFromFile reads an encryption key from the given file.
+func KeyFromFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read encryption key file: %w", err)
+	}
+	key, err := ParseKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("parse encryption key file %s: %w", path, err)
+	}
+	return key, nil
+}
+
+// KeyFromEnv reads an encryption key from the given environment variable.
+func KeyFromEnv(name string) ([]byte, error) {
+	data, ok := os.LookupEnv(name)
+	if !ok || data == "" {
+		return nil, fmt.Errorf("encryption key environment variable %s is not set", name)
+	}
+	key, err := ParseKey([]byte(data))
+	if err != nil {
+		return nil, fmt.Errorf("parse encryption key from %s: %w", name, err)
+	}
+	return key, nil
+}
+
+// KeyFromWireGuard derives a 32 byte encryption key from the given
+// WireGuard private key.
+func KeyFromWireGuard(key crypto.PrivateKey) ([]byte, error) {
+	out := make([]byte, 32)
+	_, err := io.ReadFull(hkdf.New(sha256.New, key.Bytes(), nil, []byte(wireguardKeyInfo)), out)
+	if err != nil {
+		return nil, fmt.Errorf("derive encryption key: %w", err)
+	}
+	return out, nil
+}
+
+func validKeyLength(key []byte) bool {
+	switch len(key) {
+	case 16, 24, 32:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,249 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package encryption
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// A stream is a header followed by a series of chunks, each sealed with
+// AES-GCM under a key derived from the master key and the header's salt.
+//
+//	header: magic (6) | key id (8) | salt (16)
+//	chunk:  length (4) | ciphertext (length + 16)
+//
+// Nonces are the chunk counter. The last chunk is marked in its additional
+// data so truncated streams are detected. Every chunk but the last holds
+// exactly ChunkSize bytes of plaintext.
+const (
+	// ChunkSize is the amount of plaintext sealed in each chunk.
+	ChunkSize = 64 * 1024
+
+	magic      = "WMENC\x01"
+	saltSize   = 16
+	headerSize = len(magic) + 8 + saltSize
+	chunkExtra = 4 + 16
+)
+
+// Inspect peeks at the start of r and reports whether it is an encrypted
+// stream and the ID of the key it was written with. The reader is not
+// advanced.
+func Inspect(r *bufio.Reader) (keyID []byte, encrypted bool, err error) {
+	header, err := r.Peek(headerSize)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, false, err
+	}
+	if len(header) < headerSize || string(header[:len(magic)]) != magic {
+		return nil, false, nil
+	}
+	return bytes.Clone(header[len(magic) : len(magic)+8]), true, nil
+}
+
+// PlaintextSize returns the size of the plaintext in an encrypted stream
+// of the given size.
+func PlaintextSize(size int64) int64 {
+	body := size - int64(headerSize) - chunkExtra
+	if body < 0 {
+		return 0
+	}
+	full := body / (ChunkSize + chunkExtra)
+	return full*ChunkSize + body%(ChunkSize+chunkExtra)
+}
+
+// NewWriter returns a writer that encrypts to w with the given key.
+// Close must be called to write the final chunk. It does not close w.
+func NewWriter(w io.Writer, key []byte) (io.WriteCloser, error) {
+	if !validKeyLength(key) {
+		return nil, ErrInvalidKey
+	}
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("generate salt: %w", err)
+	}
+	aead, err := newStreamCipher(key, salt)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, 0, headerSize)
+	header = append(header, magic...)
+	header = append(header, KeyID(key)...)
+	header = append(header, salt...)
+	if _, err := w.Write(header); err != nil {
+		return nil, fmt.Errorf("write header: %w", err)
+	}
+	return &writer{w: w, aead: aead, buf: make([]byte, 0, ChunkSize)}, nil
+}
+
+// NewReader returns a reader that decrypts the stream in r. The key is
+// chosen from keys by the ID in the stream header.
+func NewReader(r io.Reader, keys ...[]byte) (io.Reader, error) {
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("read header: %w", err)
+	}
+	if string(header[:len(magic)]) != magic {
+		return nil, fmt.Errorf("not an encrypted stream")
+	}
+	id := header[len(magic) : len(magic)+8]
+	for _, key := range keys {
+		if len(key) == 0 || !bytes.Equal(KeyID(key), id) {
+			continue
+		}
+		aead, err := newStreamCipher(key, header[len(magic)+8:])
+		if err != nil {
+			return nil, err
+		}
+		return &reader{r: r, aead: aead}, nil
+	}
+	return nil, ErrUnknownKey
+}
+
+func newStreamCipher(key, salt []byte) (cipher.AEAD, error) {
+	streamKey := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, key, salt, []byte(magic)), streamKey); err != nil {
+		return nil, fmt.Errorf("derive stream key: %w", err)
+	}
+	block, err := aes.NewCipher(streamKey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func chunkNonce(aead cipher.AEAD, counter uint64) []byte {
+	nonce := make([]byte, aead.NonceSize())
+	binary.BigEndian.PutUint64(nonce[len(nonce)-8:], counter)
+	return nonce
+}
+
+func chunkAAD(final bool, length int) []byte {
+	aad := make([]byte, 5)
+	if final {
+		aad[0] = 1
+	}
+	binary.BigEndian.PutUint32(aad[1:], uint32(length))
+	return aad
+}
+
+type writer struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	buf     []byte
+	counter uint64
+	closed  bool
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, errors.New("write to closed stream")
+	}
+	written := 0
+	for len(p) > 0 {
+		n := min(ChunkSize-len(w.buf), len(p))
+		w.buf = append(w.buf, p[:n]...)
+		p = p[n:]
+		written += n
+		if len(w.buf) == ChunkSize {
+			if err := w.flush(false); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+func (w *writer) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	return w.flush(true)
+}
+
+func (w *writer) flush(final bool) error {
+	out := make([]byte, 4, 4+len(w.buf)+w.aead.Overhead())
+	binary.BigEndian.PutUint32(out, uint32(len(w.buf)))
+	out = w.aead.Seal(out, chunkNonce(w.aead, w.counter), w.buf, chunkAAD(final, len(w.buf)))
+	w.counter++
+	w.buf = w.buf[:0]
+	_, err := w.w.Write(out)
+	return err
+}
+
+type reader struct {
+	r       io.Reader
+	aead    cipher.AEAD
+	buf     []byte
+	counter uint64
+	done    bool
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		if err := r.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+func (r *reader) next() error {
+	var lenbuf [4]byte
+	if _, err := io.ReadFull(r.r, lenbuf[:]); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return ErrTruncated
+		}
+		return err
+	}
+	length := int(binary.BigEndian.Uint32(lenbuf[:]))
+	if length > ChunkSize {
+		return fmt.Errorf("invalid chunk length %d", length)
+	}
+	sealed := make([]byte, length+r.aead.Overhead())
+	if _, err := io.ReadFull(r.r, sealed); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return ErrTruncated
+		}
+		return err
+	}
+	// Writers flush full chunks eagerly, so only a short chunk is final.
+	final := length < ChunkSize
+	plain, err := r.aead.Open(sealed[:0], chunkNonce(r.aead, r.counter), sealed, chunkAAD(final, length))
+	if err != nil {
+		return fmt.Errorf("decrypt chunk %d: %w", r.counter, err)
+	}
+	r.counter++
+	r.buf = plain
+	r.done = final
+	return nil
+}
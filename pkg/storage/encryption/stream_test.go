@@ -0,0 +1,157 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package encryption
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestStream(t *testing.T) {
+	key := mustKey(t)
+	for _, size := range []int{0, 1, ChunkSize - 1, ChunkSize, ChunkSize + 1, 3*ChunkSize + 17} {
+		plain := make([]byte, size)
+		_, _ = rand.Read(plain)
+		var buf bytes.Buffer
+		w, err := NewWriter(&buf, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(plain); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if got := PlaintextSize(int64(buf.Len())); got != int64(size) {
+			t.Fatalf("size %d: expected plaintext size %d, got %d", size, size, got)
+		}
+		// Short plaintexts can appear in random ciphertext by chance.
+		if size >= 16 && bytes.Contains(buf.Bytes(), plain) {
+			t.Fatalf("size %d: plaintext found in stream", size)
+		}
+		encrypted := bytes.Clone(buf.Bytes())
+
+		id, ok, err := Inspect(bufio.NewReader(bytes.NewReader(encrypted)))
+		if err != nil || !ok || !bytes.Equal(id, KeyID(key)) {
+			t.Fatalf("size %d: inspect returned %x, %v, %v", size, id, ok, err)
+		}
+		r, err := NewReader(bytes.NewReader(encrypted), mustKey(t), key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("size %d: read: %v", size, err)
+		}
+		if !bytes.Equal(out, plain) {
+			t.Fatalf("size %d: decrypted data does not match", size)
+		}
+
+		// Dropping the final chunk must be detected.
+		if size >= ChunkSize {
+			r, err := NewReader(bytes.NewReader(encrypted[:headerSize+ChunkSize+chunkExtra]), key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := io.ReadAll(r); !errors.Is(err, ErrTruncated) {
+				t.Fatalf("size %d: expected ErrTruncated, got %v", size, err)
+			}
+		}
+	}
+
+	t.Run("UnknownKey", func(t *testing.T) {
+		var buf bytes.Buffer
+		w, err := NewWriter(&buf, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = w.Close()
+		if _, err := NewReader(&buf, mustKey(t)); !errors.Is(err, ErrUnknownKey) {
+			t.Fatalf("expected ErrUnknownKey, got %v", err)
+		}
+	})
+
+	t.Run("Tampered", func(t *testing.T) {
+		var buf bytes.Buffer
+		w, err := NewWriter(&buf, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = w.Write([]byte("hello world"))
+		_ = w.Close()
+		data := buf.Bytes()
+		data[len(data)-1] ^= 0xff
+		r, err := NewReader(bytes.NewReader(data), key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.ReadAll(r); err == nil {
+			t.Fatal("expected error reading tampered stream")
+		}
+	})
+
+	t.Run("Plaintext", func(t *testing.T) {
+		_, ok, err := Inspect(bufio.NewReader(bytes.NewReader([]byte("plain"))))
+		if err != nil || ok {
+			t.Fatalf("expected plaintext, got %v, %v", ok, err)
+		}
+	})
+}
+
+func TestParseKey(t *testing.T) {
+	tc := []struct {
+		name    string
+		data    string
+		wantLen int
+	}{
+		{"Raw", "0123456789abcdef", 16},
+		{"Base64", "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=\n", 32},
+		{"Hex", "000102030405060708090a0b0c0d0e0f1011121314151617", 24},
+		{"Invalid", "short", 0},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := ParseKey([]byte(tt.data))
+			if tt.wantLen == 0 {
+				if !errors.Is(err, ErrInvalidKey) {
+					t.Fatalf("expected ErrInvalidKey, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(key) != tt.wantLen {
+				t.Fatalf("expected %d byte key, got %d", tt.wantLen, len(key))
+			}
+		})
+	}
+}
+
+func mustKey(t *testing.T) []byte {
+	t.Helper()
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
@@ -83,6 +83,42 @@ var (
 	ErrInvalidNodeID = errors.New("node ID is invalid")
 	// ErrInvalidQuery is returned when a query is invalid.
 	ErrInvalidQuery = errors.New("invalid query")
+	// ErrInvalidTxn is returned when a transaction is invalid.
+	ErrInvalidTxn = errors.New("invalid transaction")
+	// ErrTxnConditionsFailed is returned when the conditions of a transaction did not hold.
+	ErrTxnConditionsFailed = errors.New("transaction conditions failed")
+	// ErrTxnNotSupported is returned when the storage does not support transactions.
+	ErrTxnNotSupported = errors.New("transactions not supported by storage")
+	// ErrTxnAlreadyApplied is returned when a transaction carries a revision the storage has already applied.
+	ErrTxnAlreadyApplied = errors.New("transaction already applied")
+	// ErrCompacted is returned when a watch is started from, or falls behind, a revision that is no longer in history.
+	ErrCompacted = errors.New("revision has been compacted")
+	// ErrNamespaceNotFound is returned when a key-value namespace is not found.
+	ErrNamespaceNotFound = errors.New("namespace not found")
+	// ErrInvalidNamespace is returned when a key-value namespace is invalid.
+	ErrInvalidNamespace = errors.New("invalid namespace")
+	// ErrQuotaExceeded is returned when a write would exceed the quota of a namespace.
+	ErrQuotaExceeded = errors.New("namespace quota exceeded")
+	// ErrWatchNotSupported is returned when the storage does not support revisioned watches.
+	ErrWatchNotSupported = errors.New("watches not supported by storage")
+	// ErrFederationNotFound is returned when a federated mesh is not found.
+	ErrFederationNotFound = errors.New("federation not found")
+	// ErrInvalidFederation is returned when a federation or its trust bundle is invalid.
+	ErrInvalidFederation = errors.New("invalid federation")
+	// ErrDNSRecordNotFound is returned when a custom DNS record is not found.
+	ErrDNSRecordNotFound = errors.New("dns record not found")
+	// ErrInvalidDNSRecord is returned when a custom DNS record is invalid.
+	ErrInvalidDNSRecord = errors.New("invalid dns record")
+	// ErrPresenceNotFound is returned when a node has no presence lease.
+	ErrPresenceNotFound = errors.New("presence not found")
+	// ErrAutopilotNotRunning is returned when no autopilot state has been recorded.
+	ErrAutopilotNotRunning = errors.New("autopilot is not running")
+	// ErrInvalidConsistency is returned when a requested read consistency is invalid.
+	ErrInvalidConsistency = errors.New("invalid read consistency")
+	// ErrConsistencyNotSupported is returned when the storage cannot serve the requested read consistency.
+	ErrConsistencyNotSupported = errors.New("read consistency not supported by storage")
+	// ErrStaleRead is returned when the local state does not meet the requested read consistency in time.
+	ErrStaleRead = errors.New("local state is too stale for the requested read consistency")
 )
 
 // NewKeyNotFoundError returns a new ErrKeyNotFound error.
@@ -99,7 +135,12 @@ func IsNotFound(err error) bool {
 		IsRoleBindingNotFound(err) ||
 		IsGroupNotFound(err) ||
 		IsACLNotFound(err) ||
-		IsRouteNotFound(err)
+		IsRouteNotFound(err) ||
+		Is(err, ErrNamespaceNotFound) ||
+		Is(err, ErrFederationNotFound) ||
+		Is(err, ErrDNSRecordNotFound) ||
+		Is(err, ErrPresenceNotFound) ||
+		Is(err, ErrAutopilotNotRunning)
 }
 
 // IsKeyNotFoundError returns true if the given error is a ErrKeyNotFound error.
@@ -0,0 +1,105 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"time"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// Federation is the interface for managing the meshes federated with this one.
+type Federation interface {
+	// PutFederation creates or updates a federation. The trust bundle must be
+	// signed by the key with the pinned fingerprint and by the same key as the
+	// bundle it replaces, if any.
+	PutFederation(ctx context.Context, fed types.Federation) error
+	// GetFederation returns the federation with the given domain.
+	GetFederation(ctx context.Context, domain string) (types.Federation, error)
+	// DeleteFederation deletes the federation with the given domain.
+	DeleteFederation(ctx context.Context, domain string) error
+	// ListFederations returns all federations.
+	ListFederations(ctx context.Context) ([]types.Federation, error)
+}
+
+// FederatedMeshDB is implemented by MeshDBs that track federated meshes.
+type FederatedMeshDB interface {
+	// Federation returns the interface for managing federated meshes.
+	Federation() Federation
+}
+
+// FederationOf returns the Federation interface of the given MeshDB if it
+// tracks federated meshes.
+func FederationOf(db MeshDB) (Federation, bool) {
+	fdb, ok := db.(FederatedMeshDB)
+	if !ok || fdb.Federation() == nil {
+		return nil, false
+	}
+	return fdb.Federation(), true
+}
+
+// ActiveFederations returns the federations whose trust bundle has not expired.
+func ActiveFederations(ctx context.Context, fed Federation) ([]types.Federation, error) {
+	feds, err := fed.ListFederations(ctx)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	out := feds[:0]
+	for _, f := range feds {
+		if !f.Expired(now) {
+			out = append(out, f)
+		}
+	}
+	return out, nil
+}
+
+// ExpandRemoteNodes resolves references to nodes of federated meshes, such as
+// "node@otherdomain", in the ACLs to the addresses published in the trust
+// bundles of the federated meshes. References to unknown domains or nodes, or
+// to federations with an expired bundle, are left unresolved and only match
+// actions naming them directly.
+func ExpandRemoteNodes(ctx context.Context, fed Federation, acls types.NetworkACLs) error {
+	feds, err := ActiveFederations(ctx, fed)
+	if err != nil {
+		return err
+	}
+	if len(feds) == 0 {
+		return nil
+	}
+	bundles := make(map[string]types.TrustBundle, len(feds))
+	for _, f := range feds {
+		bundles[f.Domain()] = f.Bundle
+	}
+	for i := range acls {
+		for _, ref := range append(acls[i].GetSourceNodes(), acls[i].GetDestinationNodes()...) {
+			node, domain, ok := types.ParseRemoteNode(ref)
+			if !ok {
+				continue
+			}
+			bundle, ok := bundles[domain]
+			if !ok {
+				continue
+			}
+			if prefixes, ok := bundle.ResolveNode(node); ok {
+				acls[i].ResolveRemoteNode(ref, prefixes)
+			}
+		}
+	}
+	return nil
+}
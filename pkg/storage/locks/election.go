@@ -0,0 +1,53 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package locks
+
+import (
+	"github.com/webmeshproj/webmesh/pkg/context"
+)
+
+// Election is a leader election between nodes campaigning on the same name.
+// The leader is the holder of the lock with that name.
+type Election struct {
+	locker *Locker
+	name   string
+}
+
+// Election returns an election for the given name.
+func (l *Locker) Election(name string) *Election {
+	return &Election{locker: l, name: name}
+}
+
+// Campaign blocks until the caller is elected leader or the context is
+// canceled. The value is published to observers of the election, typically
+// the address of the leader. The returned lock must be released to resign,
+// and its Lost channel is closed if leadership is lost.
+func (e *Election) Campaign(ctx context.Context, value []byte) (*Lock, error) {
+	return e.locker.Acquire(ctx, e.name, value)
+}
+
+// Leader returns the record of the current leader. ErrNotLocked is returned
+// if there is no leader.
+func (e *Election) Leader(ctx context.Context) (Record, error) {
+	return e.locker.Get(ctx, e.name)
+}
+
+// Observe sends the record of the leader every time leadership changes.
+// An empty record is sent while there is no leader.
+func (e *Election) Observe(ctx context.Context) (<-chan Record, error) {
+	return e.locker.Watch(ctx, e.name)
+}
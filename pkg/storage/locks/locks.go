@@ -0,0 +1,449 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package locks provides lease-based distributed locks and leader election
+// on top of the mesh storage.
+//
+// A lock is a key with a TTL that is created with a compare-and-swap and kept
+// alive by its holder. Every acquisition is assigned a fencing token that is
+// strictly greater than the token of any previous acquisition of the same lock,
+// so that resources guarded by the lock can reject writes from stale holders.
+package locks
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+var (
+	// ErrLocked is returned by TryAcquire when the lock is held by someone else.
+	ErrLocked = fmt.Errorf("lock is held")
+	// ErrNotLocked is returned when a lock is not held by anyone.
+	ErrNotLocked = fmt.Errorf("lock is not held")
+	// ErrLockLost is returned when a lease could not be kept alive or the lock
+	// was released by someone else.
+	ErrLockLost = fmt.Errorf("lock lost")
+)
+
+const (
+	// DefaultPrefix is the default storage prefix for locks.
+	DefaultPrefix = "/locks"
+	// DefaultTTL is the default lease duration of a lock.
+	DefaultTTL = 15 * time.Second
+	// MinTTL is the shortest lease duration of a lock. Storage TTLs have a
+	// granularity of one second.
+	MinTTL = time.Second
+)
+
+// Options are options for a Locker.
+type Options struct {
+	// NodeID is the ID of the node acquiring locks. It is recorded as the
+	// holder of every lock acquired by the Locker.
+	NodeID types.NodeID
+	// Prefix is the storage prefix for locks. Defaults to DefaultPrefix.
+	Prefix string
+	// TTL is the lease duration of acquired locks. The lease is renewed at a
+	// third of this interval while the lock is held. Defaults to DefaultTTL
+	// and is raised to MinTTL when shorter.
+	TTL time.Duration
+}
+
+// Record is the value stored for a held lock.
+type Record struct {
+	// Holder is the node holding the lock.
+	Holder types.NodeID `json:"holder"`
+	// Session uniquely identifies the acquisition.
+	Session string `json:"session"`
+	// Token is the fencing token of the acquisition.
+	Token uint64 `json:"token"`
+	// Value is optional data attached by the holder.
+	Value []byte `json:"value,omitempty"`
+}
+
+// Locker acquires locks in the mesh storage.
+type Locker struct {
+	opts Options
+	st   storage.MeshStorage
+	txn  storage.TxnStorage
+}
+
+// NewLocker returns a new Locker using the given storage. The storage must
+// support transactions.
+func NewLocker(st storage.MeshStorage, opts Options) (*Locker, error) {
+	txn, ok := st.(storage.TxnStorage)
+	if !ok {
+		return nil, errors.ErrTxnNotSupported
+	}
+	if opts.Prefix == "" {
+		opts.Prefix = DefaultPrefix
+	}
+	if opts.TTL <= 0 {
+		opts.TTL = DefaultTTL
+	} else if opts.TTL < MinTTL {
+		opts.TTL = MinTTL
+	}
+	return &Locker{opts: opts, st: st, txn: txn}, nil
+}
+
+// TTL returns the lease duration of locks acquired by the Locker.
+func (l *Locker) TTL() time.Duration {
+	return l.opts.TTL
+}
+
+// Key returns the storage key for the named lock.
+func (l *Locker) Key(name string) []byte {
+	return []byte(fmt.Sprintf("%s/held/%s", l.opts.Prefix, name))
+}
+
+func (l *Locker) tokenKey(name string) []byte {
+	return []byte(fmt.Sprintf("%s/tokens/%s", l.opts.Prefix, name))
+}
+
+// Get returns the record of the named lock. ErrNotLocked is returned
+// if the lock is not held.
+func (l *Locker) Get(ctx context.Context, name string) (Record, error) {
+	if !types.IsValidID(name) {
+		return Record{}, errors.ErrInvalidKey
+	}
+	data, err := l.st.GetValue(ctx, l.Key(name))
+	if err != nil {
+		if errors.IsKeyNotFound(err) {
+			return Record{}, ErrNotLocked
+		}
+		return Record{}, err
+	}
+	return decodeRecord(data)
+}
+
+// TryAcquire attempts to acquire the named lock once. ErrLocked is returned
+// if the lock is held by someone else. The value is attached to the lock
+// record and visible to anyone reading the lock.
+func (l *Locker) TryAcquire(ctx context.Context, name string, value []byte) (*Lock, error) {
+	if !types.IsValidID(name) {
+		return nil, errors.ErrInvalidKey
+	}
+	// The token counter can be stale on the replica we read from, in
+	// which case the transaction fails even though the lock is free.
+	// Retry a few times before reporting the lock as held.
+	for i := 0; i < 5; i++ {
+		lock, err := l.tryAcquire(ctx, name, value)
+		if err == nil || !errors.Is(err, errStaleToken) {
+			return lock, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(time.Duration(i+1) * 50 * time.Millisecond):
+		}
+	}
+	return nil, ErrLocked
+}
+
+var errStaleToken = fmt.Errorf("stale token counter")
+
+func (l *Locker) tryAcquire(ctx context.Context, name string, value []byte) (*Lock, error) {
+	key, tokenKey := l.Key(name), l.tokenKey(name)
+	var last uint64
+	data, err := l.st.GetValue(ctx, tokenKey)
+	if err != nil && !errors.IsKeyNotFound(err) {
+		return nil, fmt.Errorf("get fencing token: %w", err)
+	}
+	if err == nil {
+		last, err = strconv.ParseUint(string(data), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("parse fencing token: %w", err)
+		}
+	}
+	tokenVersion, err := l.txn.GetVersion(ctx, tokenKey)
+	if err != nil {
+		return nil, fmt.Errorf("get fencing token version: %w", err)
+	}
+	record := Record{
+		Holder:  l.opts.NodeID,
+		Session: uuid.NewString(),
+		Token:   last + 1,
+		Value:   value,
+	}
+	recordData, err := json.Marshal(record)
+	if err != nil {
+		return nil, fmt.Errorf("encode lock record: %w", err)
+	}
+	ok, err := l.txn.Txn(ctx, &storage.Txn{
+		Compare: []storage.Compare{
+			{Key: key, Target: storage.CompareVersion, Version: 0},
+			{Key: tokenKey, Target: storage.CompareVersion, Version: tokenVersion},
+		},
+		Success: []storage.Op{
+			{Type: storage.OpPut, Key: key, Value: recordData, TTL: l.opts.TTL},
+			{Type: storage.OpPut, Key: tokenKey, Value: []byte(strconv.FormatUint(record.Token, 10))},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("acquire lock: %w", err)
+	}
+	if !ok {
+		if _, err := l.Get(ctx, name); errors.Is(err, ErrNotLocked) {
+			return nil, errStaleToken
+		}
+		return nil, ErrLocked
+	}
+	lock := &Lock{
+		locker: l,
+		name:   name,
+		record: record,
+		data:   recordData,
+		lost:   make(chan struct{}),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go lock.keepAlive(context.LoggerFrom(ctx))
+	return lock, nil
+}
+
+// Acquire blocks until the named lock is acquired or the context is canceled.
+func (l *Locker) Acquire(ctx context.Context, name string, value []byte) (*Lock, error) {
+	if !types.IsValidID(name) {
+		return nil, errors.ErrInvalidKey
+	}
+	changes := make(chan struct{}, 1)
+	cancel, err := l.st.Subscribe(ctx, l.Key(name), func(key, value []byte) {
+		select {
+		case changes <- struct{}{}:
+		default:
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("subscribe to lock: %w", err)
+	}
+	defer cancel()
+	// Expired leases do not always produce events, so poll as well.
+	t := time.NewTicker(l.opts.TTL / 3)
+	defer t.Stop()
+	for {
+		lock, err := l.TryAcquire(ctx, name, value)
+		if err == nil {
+			return lock, nil
+		}
+		if !errors.Is(err, ErrLocked) {
+			return nil, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-changes:
+		case <-t.C:
+		}
+	}
+}
+
+// Watch sends the record of the named lock every time it changes. An empty
+// record is sent when the lock is released. The current record is sent first.
+// The channel is closed when the context is canceled.
+func (l *Locker) Watch(ctx context.Context, name string) (<-chan Record, error) {
+	if !types.IsValidID(name) {
+		return nil, errors.ErrInvalidKey
+	}
+	changes := make(chan struct{}, 1)
+	cancel, err := l.st.Subscribe(ctx, l.Key(name), func(key, value []byte) {
+		select {
+		case changes <- struct{}{}:
+		default:
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("subscribe to lock: %w", err)
+	}
+	out := make(chan Record, 1)
+	go func() {
+		defer close(out)
+		defer cancel()
+		t := time.NewTicker(l.opts.TTL / 3)
+		defer t.Stop()
+		var last Record
+		first := true
+		for {
+			rec, err := l.Get(ctx, name)
+			if err != nil && !errors.Is(err, ErrNotLocked) {
+				context.LoggerFrom(ctx).Debug("Failed to get lock", slog.String("lock", name), slog.String("error", err.Error()))
+			} else if first || rec.Session != last.Session {
+				select {
+				case out <- rec:
+				case <-ctx.Done():
+					return
+				}
+				first, last = false, rec
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-changes:
+			case <-t.C:
+			}
+		}
+	}()
+	return out, nil
+}
+
+// ReleaseNode releases all locks held by the given node. It is used to
+// free the locks of nodes that have been removed from the mesh.
+func (l *Locker) ReleaseNode(ctx context.Context, nodeID types.NodeID) (int, error) {
+	type held struct {
+		key, data []byte
+	}
+	var locks []held
+	prefix := []byte(l.opts.Prefix + "/held/")
+	err := l.st.IterPrefix(ctx, prefix, func(key, value []byte) error {
+		rec, err := decodeRecord(value)
+		if err != nil {
+			return nil
+		}
+		if rec.Holder == nodeID {
+			locks = append(locks, held{key: key, data: value})
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("list locks: %w", err)
+	}
+	var released int
+	for _, lock := range locks {
+		ok, err := l.txn.Txn(ctx, &storage.Txn{
+			Compare: []storage.Compare{{Key: lock.key, Target: storage.CompareValue, Value: lock.data}},
+			Success: []storage.Op{{Type: storage.OpDelete, Key: lock.key}},
+		})
+		if err != nil {
+			return released, fmt.Errorf("release lock %q: %w", lock.key, err)
+		}
+		if ok {
+			released++
+		}
+	}
+	return released, nil
+}
+
+// Lock is a held lock.
+type Lock struct {
+	locker   *Locker
+	name     string
+	record   Record
+	data     []byte
+	lost     chan struct{}
+	stop     chan struct{}
+	done     chan struct{}
+	err      error
+	stopOnce sync.Once
+	mu       sync.Mutex
+}
+
+// Name returns the name of the lock.
+func (k *Lock) Name() string {
+	return k.name
+}
+
+// Token returns the fencing token of the acquisition.
+func (k *Lock) Token() uint64 {
+	return k.record.Token
+}
+
+// Record returns the record stored for the lock.
+func (k *Lock) Record() Record {
+	return k.record
+}
+
+// Lost returns a channel that is closed when the lock is no longer held.
+// Err returns the reason.
+func (k *Lock) Lost() <-chan struct{} {
+	return k.lost
+}
+
+// Err returns ErrLockLost if the lease was lost, or nil if the lock is
+// still held or was released.
+func (k *Lock) Err() error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.err
+}
+
+// Release stops renewing the lease and releases the lock if it is still held.
+func (k *Lock) Release(ctx context.Context) error {
+	k.stopOnce.Do(func() { close(k.stop) })
+	<-k.done
+	if k.Err() != nil {
+		return nil
+	}
+	_, err := k.locker.txn.Txn(ctx, &storage.Txn{
+		Compare: []storage.Compare{{Key: k.locker.Key(k.name), Target: storage.CompareValue, Value: k.data}},
+		Success: []storage.Op{{Type: storage.OpDelete, Key: k.locker.Key(k.name)}},
+	})
+	if err != nil {
+		return fmt.Errorf("release lock: %w", err)
+	}
+	return nil
+}
+
+func (k *Lock) keepAlive(log *slog.Logger) {
+	defer close(k.done)
+	defer close(k.lost)
+	key := k.locker.Key(k.name)
+	ttl := k.locker.opts.TTL
+	t := time.NewTicker(ttl / 3)
+	defer t.Stop()
+	renewed := time.Now()
+	for {
+		select {
+		case <-k.stop:
+			return
+		case <-t.C:
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), ttl/3)
+		ok, err := k.locker.txn.Txn(ctx, &storage.Txn{
+			Compare: []storage.Compare{{Key: key, Target: storage.CompareValue, Value: k.data}},
+			Success: []storage.Op{{Type: storage.OpPut, Key: key, Value: k.data, TTL: ttl}},
+		})
+		cancel()
+		switch {
+		case err != nil && time.Since(renewed) < ttl:
+			log.Warn("Failed to renew lock lease, retrying", slog.String("lock", k.name), slog.String("error", err.Error()))
+			continue
+		case err != nil, !ok:
+			k.mu.Lock()
+			k.err = ErrLockLost
+			k.mu.Unlock()
+			log.Warn("Lost lock", slog.String("lock", k.name))
+			return
+		}
+		renewed = time.Now()
+	}
+}
+
+func decodeRecord(data []byte) (Record, error) {
+	var rec Record
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return rec, fmt.Errorf("decode lock record: %w", err)
+	}
+	return rec, nil
+}
@@ -0,0 +1,178 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package locks
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/webmeshproj/webmesh/pkg/storage/providers/backends/badgerdb"
+)
+
+func TestLocker(t *testing.T) {
+	ctx := context.Background()
+	st := badgerdb.NewTestStorage(false)
+	defer st.Close()
+	a, err := NewLocker(st, Options{NodeID: "node-a", TTL: 3 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewLocker(st, Options{NodeID: "node-b", TTL: 3 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("TTLBounds", func(t *testing.T) {
+		for ttl, want := range map[time.Duration]time.Duration{
+			0:                      DefaultTTL,
+			time.Nanosecond:        MinTTL,
+			500 * time.Millisecond: MinTTL,
+			2 * time.Second:        2 * time.Second,
+		} {
+			l, err := NewLocker(st, Options{NodeID: "node-c", TTL: ttl})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if l.TTL() != want {
+				t.Errorf("ttl %s: expected %s, got %s", ttl, want, l.TTL())
+			}
+		}
+	})
+
+	t.Run("Exclusive", func(t *testing.T) {
+		lock, err := a.TryAcquire(ctx, "exclusive", nil)
+		if err != nil {
+			t.Fatalf("acquire lock: %v", err)
+		}
+		_, err = b.TryAcquire(ctx, "exclusive", nil)
+		if !errors.Is(err, ErrLocked) {
+			t.Fatalf("expected ErrLocked, got %v", err)
+		}
+		rec, err := b.Get(ctx, "exclusive")
+		if err != nil {
+			t.Fatalf("get lock: %v", err)
+		}
+		if rec.Holder != "node-a" || rec.Token != lock.Token() {
+			t.Fatalf("unexpected record: %+v", rec)
+		}
+		if err := lock.Release(ctx); err != nil {
+			t.Fatalf("release lock: %v", err)
+		}
+		_, err = b.Get(ctx, "exclusive")
+		if !errors.Is(err, ErrNotLocked) {
+			t.Fatalf("expected ErrNotLocked, got %v", err)
+		}
+	})
+
+	t.Run("FencingTokens", func(t *testing.T) {
+		var last uint64
+		for i := 0; i < 3; i++ {
+			lock, err := a.TryAcquire(ctx, "fencing", nil)
+			if err != nil {
+				t.Fatalf("acquire lock: %v", err)
+			}
+			if lock.Token() <= last {
+				t.Fatalf("expected token greater than %d, got %d", last, lock.Token())
+			}
+			last = lock.Token()
+			if err := lock.Release(ctx); err != nil {
+				t.Fatalf("release lock: %v", err)
+			}
+		}
+	})
+
+	t.Run("AcquireWaits", func(t *testing.T) {
+		lock, err := a.TryAcquire(ctx, "waits", nil)
+		if err != nil {
+			t.Fatalf("acquire lock: %v", err)
+		}
+		acquired := make(chan *Lock, 1)
+		go func() {
+			lock, err := b.Acquire(ctx, "waits", nil)
+			if err != nil {
+				t.Errorf("acquire lock: %v", err)
+			}
+			acquired <- lock
+		}()
+		select {
+		case <-acquired:
+			t.Fatal("lock acquired while held")
+		case <-time.After(500 * time.Millisecond):
+		}
+		if err := lock.Release(ctx); err != nil {
+			t.Fatalf("release lock: %v", err)
+		}
+		select {
+		case next := <-acquired:
+			if next.Token() <= lock.Token() {
+				t.Fatalf("expected token greater than %d, got %d", lock.Token(), next.Token())
+			}
+			_ = next.Release(ctx)
+		case <-time.After(5 * time.Second):
+			t.Fatal("lock not acquired after release")
+		}
+	})
+
+	t.Run("ReleaseNode", func(t *testing.T) {
+		lock, err := a.TryAcquire(ctx, "purged", nil)
+		if err != nil {
+			t.Fatalf("acquire lock: %v", err)
+		}
+		released, err := b.ReleaseNode(ctx, "node-a")
+		if err != nil {
+			t.Fatalf("release node: %v", err)
+		}
+		if released != 1 {
+			t.Fatalf("expected 1 released lock, got %d", released)
+		}
+		select {
+		case <-lock.Lost():
+		case <-time.After(5 * time.Second):
+			t.Fatal("expected lock to be lost")
+		}
+		if !errors.Is(lock.Err(), ErrLockLost) {
+			t.Fatalf("expected ErrLockLost, got %v", lock.Err())
+		}
+	})
+
+	t.Run("Election", func(t *testing.T) {
+		wctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		leaders, err := b.Election("election").Observe(wctx)
+		if err != nil {
+			t.Fatalf("observe election: %v", err)
+		}
+		if rec := <-leaders; rec.Holder != "" {
+			t.Fatalf("expected no leader, got %+v", rec)
+		}
+		lock, err := a.Election("election").Campaign(ctx, []byte("10.0.0.1"))
+		if err != nil {
+			t.Fatalf("campaign: %v", err)
+		}
+		defer func() { _ = lock.Release(ctx) }()
+		select {
+		case rec := <-leaders:
+			if rec.Holder != "node-a" || string(rec.Value) != "10.0.0.1" {
+				t.Fatalf("unexpected leader: %+v", rec)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("leader not observed")
+		}
+	})
+}
@@ -29,6 +29,7 @@ import (
 	"github.com/webmeshproj/webmesh/pkg/crypto"
 	"github.com/webmeshproj/webmesh/pkg/storage"
 	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/meshdb/federation"
 	"github.com/webmeshproj/webmesh/pkg/storage/meshdb/graphstore"
 	"github.com/webmeshproj/webmesh/pkg/storage/meshdb/networking"
 	"github.com/webmeshproj/webmesh/pkg/storage/meshdb/rbac"
@@ -36,6 +37,16 @@ import (
 	"github.com/webmeshproj/webmesh/pkg/storage/types"
 )
 
+// Prefixes are the registry prefixes read by a MeshDB created with NewFromStorage.
+var Prefixes = append([]types.StoragePrefix{
+	storage.NodesPrefix,
+	storage.EdgesPrefix,
+	storage.NetworkACLsPrefix,
+	storage.RoutesPrefix,
+	state.MeshStatePrefix,
+	types.FederationsPrefix,
+}, rbac.Prefixes...)
+
 // New returns a new MeshDB instance using the given underlying MeshDataStore.
 // Storage operations will be validated before being passed to the underlying
 // MeshDataStore. If the underlying MeshDataStore already performs validation,
@@ -43,7 +54,7 @@ import (
 // methods to perform validation. So any locks used internally must be reentrant.
 func New(db storage.MeshDataStore) storage.MeshDB {
 	graphStore := &ValidatingGraphStore{db.GraphStore()}
-	return &Database{
+	database := &Database{
 		db:         db,
 		graphStore: graphStore,
 		peers: &ValidatingPeerStore{
@@ -54,25 +65,31 @@ func New(db storage.MeshDataStore) storage.MeshDB {
 		state:   &ValidatingMeshStateStore{db.MeshState()},
 		network: &ValidatingNetworkingStore{db.Networking()},
 	}
+	if fdb, ok := db.(storage.FederatedMeshDB); ok {
+		database.federation = fdb.Federation()
+	}
+	return database
 }
 
 // NewFromStorage creates a new MeshDB instance from the given MeshStorage. The same
 // information applies as for New.
 func NewFromStorage(st storage.MeshStorage) storage.MeshDB {
 	return New(&MeshDataStore{
-		graph:   graphstore.NewStore(st),
-		rbac:    rbac.New(st),
-		mesh:    state.New(st),
-		network: networking.New(st),
+		graph:      graphstore.NewStore(st),
+		rbac:       rbac.New(st),
+		mesh:       state.New(st),
+		network:    networking.New(st),
+		federation: federation.New(st),
 	})
 }
 
 // MeshDataStore is a data store using an underlying MeshStorage instance.
 type MeshDataStore struct {
-	graph   storage.GraphStore
-	rbac    storage.RBAC
-	mesh    storage.MeshState
-	network storage.Networking
+	graph      storage.GraphStore
+	rbac       storage.RBAC
+	mesh       storage.MeshState
+	network    storage.Networking
+	federation storage.Federation
 }
 
 // GraphStore returns the underlying storage.MeshDB's GraphStore instance.
@@ -95,6 +112,11 @@ func (m *MeshDataStore) Networking() storage.Networking {
 	return m.network
 }
 
+// Federation returns the underlying storage.MeshDB's Federation instance.
+func (m *MeshDataStore) Federation() storage.Federation {
+	return m.federation
+}
+
 // Database wraps a storage.MeshDataStore and automatically performs the necessary
 // validation on all operations. Note that certain write operations will call into
 // read methods to perform validation. So any locks used internally must be reentrant.
@@ -105,6 +127,7 @@ type Database struct {
 	rbac       storage.RBAC
 	state      storage.MeshState
 	network    storage.Networking
+	federation storage.Federation
 }
 
 // Peers returns the underlying storage.MeshDB's Peers instance with
@@ -137,6 +160,12 @@ func (d *Database) Networking() storage.Networking {
 	return d.network
 }
 
+// Federation returns the underlying storage.MeshDB's Federation instance, or nil
+// if the underlying MeshDataStore does not track federated meshes.
+func (d *Database) Federation() storage.Federation {
+	return d.federation
+}
+
 // ValidatingMeshStateStore wraps a storage.MeshState and automatically performs the
 // necessary validation on all operations.
 type ValidatingMeshStateStore struct {
@@ -0,0 +1,184 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package federation contains the database models for meshes federated with this one.
+package federation
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/meshdb/networking"
+	"github.com/webmeshproj/webmesh/pkg/storage/meshdb/state"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// New returns a new Federation interface.
+func New(st storage.MeshStorage) storage.Federation {
+	return &federation{
+		MeshStorage: st,
+		networking:  networking.New(st),
+		state:       state.New(st),
+	}
+}
+
+type federation struct {
+	storage.MeshStorage
+	networking storage.Networking
+	state      storage.MeshState
+}
+
+// RouteName returns the name of the route through which the given local
+// gateway advertises the prefixes of a federated mesh.
+func RouteName(domain string, gateway string) string {
+	sum := sha256.Sum256([]byte(gateway + types.RemoteNodeSeparator + domain))
+	return "federation-" + hex.EncodeToString(sum[:8])
+}
+
+// PutFederation creates or updates a federation. The prefixes of the
+// federated mesh are routed through the local gateways.
+func (f *federation) PutFederation(ctx context.Context, fed types.Federation) error {
+	fed.Bundle.Domain = strings.TrimSuffix(fed.Bundle.Domain, ".")
+	if err := fed.Validate(); err != nil {
+		return fmt.Errorf("%w: %w", errors.ErrInvalidFederation, err)
+	}
+	meshState, err := f.state.GetMeshState(ctx)
+	if err != nil {
+		return fmt.Errorf("get mesh state: %w", err)
+	}
+	if strings.TrimSuffix(meshState.Domain(), ".") == fed.Domain() {
+		return fmt.Errorf("%w: domain %q is the domain of this mesh", errors.ErrInvalidFederation, fed.Domain())
+	}
+	for _, prefix := range fed.Bundle.PrefixList() {
+		if meshState.NetworkV4().Overlaps(prefix) || meshState.NetworkV6().Overlaps(prefix) {
+			return fmt.Errorf("%w: prefix %s overlaps the mesh network", errors.ErrInvalidFederation, prefix)
+		}
+	}
+	current, err := f.GetFederation(ctx, fed.Domain())
+	if err != nil && !errors.Is(err, errors.ErrFederationNotFound) {
+		return err
+	}
+	if err == nil && current.Bundle.SigningKey != fed.Bundle.SigningKey {
+		return fmt.Errorf("%w: trust bundle for %q is signed by a different key, delete the federation to replace it",
+			errors.ErrInvalidFederation, fed.Domain())
+	}
+	data, err := json.Marshal(fed)
+	if err != nil {
+		return fmt.Errorf("marshal federation: %w", err)
+	}
+	err = f.PutValue(ctx, types.FederationsPrefix.ForString(fed.Domain()), data, 0)
+	if err != nil {
+		return fmt.Errorf("put federation: %w", err)
+	}
+	for _, gw := range fed.Gateways {
+		err := f.networking.PutRoute(ctx, types.Route{Route: &v1.Route{
+			Name:             RouteName(fed.Domain(), gw),
+			Node:             gw,
+			DestinationCIDRs: fed.Bundle.Prefixes,
+		}})
+		if err != nil {
+			return fmt.Errorf("put federation route: %w", err)
+		}
+	}
+	for _, gw := range current.Gateways {
+		if slices.Contains(fed.Gateways, gw) {
+			continue
+		}
+		if err := f.deleteRoute(ctx, fed.Domain(), gw); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetFederation returns the federation with the given domain.
+func (f *federation) GetFederation(ctx context.Context, domain string) (types.Federation, error) {
+	var fed types.Federation
+	domain = strings.TrimSuffix(domain, ".")
+	if !types.IsValidID(domain) {
+		return fed, errors.ErrFederationNotFound
+	}
+	data, err := f.GetValue(ctx, types.FederationsPrefix.ForString(domain))
+	if err != nil {
+		if errors.IsKeyNotFound(err) {
+			return fed, errors.ErrFederationNotFound
+		}
+		return fed, fmt.Errorf("get federation: %w", err)
+	}
+	if err := json.Unmarshal(data, &fed); err != nil {
+		return fed, fmt.Errorf("unmarshal federation: %w", err)
+	}
+	return fed, nil
+}
+
+// DeleteFederation deletes the federation with the given domain along with
+// the routes to its prefixes.
+func (f *federation) DeleteFederation(ctx context.Context, domain string) error {
+	fed, err := f.GetFederation(ctx, domain)
+	if err != nil {
+		if errors.Is(err, errors.ErrFederationNotFound) {
+			return nil
+		}
+		return err
+	}
+	for _, gw := range fed.Gateways {
+		if err := f.deleteRoute(ctx, fed.Domain(), gw); err != nil {
+			return err
+		}
+	}
+	err = f.Delete(ctx, types.FederationsPrefix.ForString(fed.Domain()))
+	if err != nil && !errors.IsKeyNotFound(err) {
+		return fmt.Errorf("delete federation: %w", err)
+	}
+	return nil
+}
+
+// ListFederations returns all federations.
+func (f *federation) ListFederations(ctx context.Context) ([]types.Federation, error) {
+	var out []types.Federation
+	err := f.IterPrefix(ctx, types.FederationsPrefix, func(key, value []byte) error {
+		if string(key) == types.FederationsPrefix.String() {
+			return nil
+		}
+		var fed types.Federation
+		if err := json.Unmarshal(value, &fed); err != nil {
+			return fmt.Errorf("unmarshal federation: %w", err)
+		}
+		out = append(out, fed)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list federations: %w", err)
+	}
+	return out, nil
+}
+
+func (f *federation) deleteRoute(ctx context.Context, domain, gateway string) error {
+	err := f.networking.DeleteRoute(ctx, RouteName(domain, gateway))
+	if err != nil && !errors.IsRouteNotFound(err) && !errors.IsKeyNotFound(err) {
+		return fmt.Errorf("delete federation route: %w", err)
+	}
+	return nil
+}
@@ -37,6 +37,9 @@ var (
 	rbacDisabledKey    = types.RegistryPrefix.ForString("rbac-disabled")
 )
 
+// Prefixes are the registry prefixes RBAC is stored under.
+var Prefixes = []types.StoragePrefix{rolesPrefix, rolebindingsPrefix, groupsPrefix, rbacDisabledKey}
+
 type RBAC = storage.RBAC
 
 // New returns a new RBAC.
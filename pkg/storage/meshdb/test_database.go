@@ -42,3 +42,9 @@ type TestDB struct {
 	storage.MeshDB
 	io.Closer
 }
+
+// Federation returns the Federation interface of the underlying MeshDB.
+func (t *TestDB) Federation() storage.Federation {
+	fed, _ := storage.FederationOf(t.MeshDB)
+	return fed
+}
@@ -0,0 +1,268 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package namespaces provides key-value namespaces with quotas on top of
+// the mesh storage.
+//
+// A namespace holds all keys beginning with "/<name>/". Namespaces carry
+// quotas on the number and total size of their keys, overall and per node.
+// The owner of a key is the node that last wrote it through a transaction
+// carrying an owner. Storage supporting transactions keeps usage counters up
+// to date as keys are written, and a key that expired stays accounted until
+// it is deleted or written again. Quotas are enforced when writes are
+// admitted, so concurrent writes from different nodes may briefly exceed them.
+package namespaces
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// Store manages namespaces in the mesh storage.
+type Store struct {
+	st storage.MeshStorage
+}
+
+// New returns a new namespace Store using the given storage.
+func New(st storage.MeshStorage) *Store {
+	return &Store{st: st}
+}
+
+// Put creates or updates a namespace.
+func (s *Store) Put(ctx context.Context, ns types.Namespace) error {
+	if err := ns.Validate(); err != nil {
+		return fmt.Errorf("%w: %w", errors.ErrInvalidNamespace, err)
+	}
+	data, err := json.Marshal(ns)
+	if err != nil {
+		return fmt.Errorf("marshal namespace: %w", err)
+	}
+	err = s.st.PutValue(ctx, types.NamespacesPrefix.ForString(ns.Name), data, 0)
+	if err != nil {
+		return fmt.Errorf("put namespace: %w", err)
+	}
+	return nil
+}
+
+// Get returns the named namespace.
+func (s *Store) Get(ctx context.Context, name string) (types.Namespace, error) {
+	var ns types.Namespace
+	if !types.IsValidID(name) {
+		return ns, errors.ErrNamespaceNotFound
+	}
+	data, err := s.st.GetValue(ctx, types.NamespacesPrefix.ForString(name))
+	if err != nil {
+		if errors.IsKeyNotFound(err) {
+			return ns, errors.ErrNamespaceNotFound
+		}
+		return ns, fmt.Errorf("get namespace: %w", err)
+	}
+	if err := json.Unmarshal(data, &ns); err != nil {
+		return ns, fmt.Errorf("unmarshal namespace: %w", err)
+	}
+	return ns, nil
+}
+
+// Delete removes the named namespace. The keys in the namespace are kept.
+func (s *Store) Delete(ctx context.Context, name string) error {
+	if !types.IsValidID(name) {
+		return nil
+	}
+	err := s.st.Delete(ctx, types.NamespacesPrefix.ForString(name))
+	if err != nil && !errors.IsKeyNotFound(err) {
+		return fmt.Errorf("delete namespace: %w", err)
+	}
+	return nil
+}
+
+// List returns all namespaces.
+func (s *Store) List(ctx context.Context) ([]types.Namespace, error) {
+	var out []types.Namespace
+	err := s.st.IterPrefix(ctx, types.NamespacesPrefix, func(key, value []byte) error {
+		if string(key) == types.NamespacesPrefix.String() {
+			return nil
+		}
+		var ns types.Namespace
+		if err := json.Unmarshal(value, &ns); err != nil {
+			return fmt.Errorf("unmarshal namespace: %w", err)
+		}
+		out = append(out, ns)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list namespaces: %w", err)
+	}
+	return out, nil
+}
+
+// Usage returns the current usage of the named namespace.
+func (s *Store) Usage(ctx context.Context, name string) (types.NamespaceUsage, error) {
+	ns, err := s.Get(ctx, name)
+	if err != nil {
+		return types.NamespaceUsage{}, err
+	}
+	usage, _, err := s.usage(ctx, ns, nil)
+	return usage, err
+}
+
+// Admit returns errors.ErrQuotaExceeded if applying the given operations
+// would exceed the quotas of the namespaces they write to. Keys outside of
+// any namespace are always admitted.
+func (s *Store) Admit(ctx context.Context, ops []storage.Op) error {
+	byNamespace := make(map[string][]storage.Op)
+	for _, op := range ops {
+		if name := types.NamespaceOf(op.Key); name != "" {
+			byNamespace[name] = append(byNamespace[name], op)
+		}
+	}
+	for name, ops := range byNamespace {
+		ns, err := s.Get(ctx, name)
+		if err != nil {
+			if errors.Is(err, errors.ErrNamespaceNotFound) {
+				continue
+			}
+			return err
+		}
+		if ns.MaxKeys == 0 && ns.MaxBytes == 0 && ns.MaxKeysPerNode == 0 && ns.MaxBytesPerNode == 0 {
+			continue
+		}
+		usage, keys, err := s.usage(ctx, ns, ops)
+		if err != nil {
+			return err
+		}
+		if err := admit(ns, usage, keys, ops); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// usage returns the usage of a namespace along with the accounted usage of
+// the keys written by the given operations. Storage supporting transactions
+// keeps usage counters as keys are written. Other storage is scanned.
+func (s *Store) usage(ctx context.Context, ns types.Namespace, ops []storage.Op) (types.NamespaceUsage, map[string]types.NamespaceKeyUsage, error) {
+	if _, ok := s.st.(storage.TxnStorage); !ok {
+		return s.scan(ctx, ns)
+	}
+	usage := types.NamespaceUsage{Nodes: make(map[types.NodeID]types.NamespaceNodeUsage)}
+	if _, err := s.getJSON(ctx, storage.NamespaceUsageKey(ns.Name), &usage); err != nil {
+		return usage, nil, fmt.Errorf("get namespace usage: %w", err)
+	}
+	keys := make(map[string]types.NamespaceKeyUsage)
+	for _, op := range ops {
+		var key types.NamespaceKeyUsage
+		ok, err := s.getJSON(ctx, storage.UsageKey(op.Key), &key)
+		if err != nil {
+			return usage, nil, fmt.Errorf("get key usage: %w", err)
+		}
+		if ok {
+			keys[string(op.Key)] = key
+		}
+	}
+	return usage, keys, nil
+}
+
+// getJSON unmarshals the value of a key into v. It returns false if the key
+// does not exist.
+func (s *Store) getJSON(ctx context.Context, key []byte, v any) (bool, error) {
+	data, err := s.st.GetValue(ctx, key)
+	if err != nil {
+		if errors.IsKeyNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, json.Unmarshal(data, v)
+}
+
+// scan computes the usage of a namespace along with the size and owner of
+// every key in it.
+func (s *Store) scan(ctx context.Context, ns types.Namespace) (types.NamespaceUsage, map[string]types.NamespaceKeyUsage, error) {
+	usage := types.NamespaceUsage{Nodes: make(map[types.NodeID]types.NamespaceNodeUsage)}
+	keys := make(map[string]types.NamespaceKeyUsage)
+	err := s.st.IterPrefix(ctx, ns.Prefix(), func(key, value []byte) error {
+		keys[string(key)] = types.NamespaceKeyUsage{Size: int64(len(key) + len(value))}
+		return nil
+	})
+	if err != nil {
+		return usage, nil, fmt.Errorf("scan namespace: %w", err)
+	}
+	ownersPrefix := storage.OwnerKey(ns.Prefix())
+	err = s.st.IterPrefix(ctx, ownersPrefix, func(key, value []byte) error {
+		name := "/" + string(types.KVOwnersPrefix.TrimFrom(key))
+		if info, ok := keys[name]; ok {
+			info.Owner = types.NodeID(value)
+			keys[name] = info
+		}
+		return nil
+	})
+	if err != nil {
+		return usage, nil, fmt.Errorf("scan namespace owners: %w", err)
+	}
+	for _, info := range keys {
+		usage.Add(info, 1)
+	}
+	return usage, keys, nil
+}
+
+// admit applies the operations to a copy of the usage of a namespace and
+// checks the result against its quotas. Limits that are already exceeded
+// only reject operations that grow the usage further.
+func admit(ns types.Namespace, before types.NamespaceUsage, keys map[string]types.NamespaceKeyUsage, ops []storage.Op) error {
+	after := types.NamespaceUsage{
+		Keys:  before.Keys,
+		Bytes: before.Bytes,
+		Nodes: make(map[types.NodeID]types.NamespaceNodeUsage, len(before.Nodes)),
+	}
+	for id, u := range before.Nodes {
+		after.Nodes[id] = u
+	}
+	for _, op := range ops {
+		key := string(op.Key)
+		if old, ok := keys[key]; ok {
+			after.Add(old, -1)
+			delete(keys, key)
+		}
+		if op.Type == storage.OpPut {
+			after.Add(op.Usage(), 1)
+			keys[key] = op.Usage()
+		}
+	}
+	exceeds := func(limit, before, after int64) bool {
+		return limit > 0 && after > limit && after > before
+	}
+	if exceeds(ns.MaxKeys, before.Keys, after.Keys) {
+		return fmt.Errorf("%w: namespace %q is limited to %d keys", errors.ErrQuotaExceeded, ns.Name, ns.MaxKeys)
+	}
+	if exceeds(ns.MaxBytes, before.Bytes, after.Bytes) {
+		return fmt.Errorf("%w: namespace %q is limited to %d bytes", errors.ErrQuotaExceeded, ns.Name, ns.MaxBytes)
+	}
+	for id, u := range after.Nodes {
+		prev := before.Nodes[id]
+		if exceeds(ns.MaxKeysPerNode, prev.Keys, u.Keys) {
+			return fmt.Errorf("%w: namespace %q is limited to %d keys per node", errors.ErrQuotaExceeded, ns.Name, ns.MaxKeysPerNode)
+		}
+		if exceeds(ns.MaxBytesPerNode, prev.Bytes, u.Bytes) {
+			return fmt.Errorf("%w: namespace %q is limited to %d bytes per node", errors.ErrQuotaExceeded, ns.Name, ns.MaxBytesPerNode)
+		}
+	}
+	return nil
+}
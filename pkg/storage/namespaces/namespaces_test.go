@@ -0,0 +1,152 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package namespaces
+
+import (
+	"context"
+	"testing"
+
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/providers/backends/badgerdb"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+func TestNamespaces(t *testing.T) {
+	ctx := context.Background()
+	st := badgerdb.NewTestStorage(false)
+	defer st.Close()
+	store := New(st)
+	txns := st.(storage.TxnStorage)
+	put := func(t *testing.T, owner types.NodeID, key, value string) {
+		t.Helper()
+		op := storage.Op{Type: storage.OpPut, Key: []byte(key), Value: []byte(value), Owner: owner}
+		if err := store.Admit(ctx, []storage.Op{op}); err != nil {
+			t.Fatalf("admit %s: %v", key, err)
+		}
+		if _, err := txns.Txn(ctx, &storage.Txn{Success: []storage.Op{op}}); err != nil {
+			t.Fatalf("put %s: %v", key, err)
+		}
+	}
+
+	t.Run("CRUD", func(t *testing.T) {
+		if err := store.Put(ctx, types.Namespace{Name: "registry"}); !errors.Is(err, errors.ErrInvalidNamespace) {
+			t.Fatalf("expected ErrInvalidNamespace for reserved name, got %v", err)
+		}
+		if err := store.Put(ctx, types.Namespace{Name: "crud", MaxKeys: 10}); err != nil {
+			t.Fatalf("put namespace: %v", err)
+		}
+		ns, err := store.Get(ctx, "crud")
+		if err != nil {
+			t.Fatalf("get namespace: %v", err)
+		}
+		if ns.MaxKeys != 10 {
+			t.Fatalf("expected max keys 10, got %d", ns.MaxKeys)
+		}
+		list, err := store.List(ctx)
+		if err != nil {
+			t.Fatalf("list namespaces: %v", err)
+		}
+		if len(list) != 1 {
+			t.Fatalf("expected 1 namespace, got %d", len(list))
+		}
+		if err := store.Delete(ctx, "crud"); err != nil {
+			t.Fatalf("delete namespace: %v", err)
+		}
+		if _, err := store.Get(ctx, "crud"); !errors.Is(err, errors.ErrNamespaceNotFound) {
+			t.Fatalf("expected ErrNamespaceNotFound, got %v", err)
+		}
+	})
+
+	t.Run("Usage", func(t *testing.T) {
+		if err := store.Put(ctx, types.Namespace{Name: "usage"}); err != nil {
+			t.Fatalf("put namespace: %v", err)
+		}
+		put(t, "node-a", "/usage/a", "1")
+		put(t, "node-a", "/usage/b", "22")
+		put(t, "node-b", "/usage/c", "333")
+		// Rewriting a key transfers its ownership.
+		put(t, "node-b", "/usage/a", "1")
+		usage, err := store.Usage(ctx, "usage")
+		if err != nil {
+			t.Fatalf("usage: %v", err)
+		}
+		if usage.Keys != 3 {
+			t.Fatalf("expected 3 keys, got %d", usage.Keys)
+		}
+		if want := int64(len("/usage/a1/usage/b22/usage/c333")); usage.Bytes != want {
+			t.Fatalf("expected %d bytes, got %d", want, usage.Bytes)
+		}
+		if usage.Nodes["node-a"].Keys != 1 || usage.Nodes["node-b"].Keys != 2 {
+			t.Fatalf("unexpected node usage: %+v", usage.Nodes)
+		}
+		// Deleting keys releases their usage.
+		_, err = txns.Txn(ctx, &storage.Txn{Success: []storage.Op{
+			{Type: storage.OpDelete, Key: []byte("/usage/b")},
+			{Type: storage.OpDelete, Key: []byte("/usage/missing")},
+		}})
+		if err != nil {
+			t.Fatalf("delete: %v", err)
+		}
+		usage, err = store.Usage(ctx, "usage")
+		if err != nil {
+			t.Fatalf("usage: %v", err)
+		}
+		if want := int64(len("/usage/a1/usage/c333")); usage.Keys != 2 || usage.Bytes != want {
+			t.Fatalf("expected 2 keys and %d bytes, got %+v", want, usage)
+		}
+		if _, ok := usage.Nodes["node-a"]; ok {
+			t.Fatalf("expected node-a to own no keys, got %+v", usage.Nodes)
+		}
+	})
+
+	t.Run("Quotas", func(t *testing.T) {
+		err := store.Put(ctx, types.Namespace{Name: "quotas", MaxKeys: 3, MaxKeysPerNode: 2, MaxBytes: 64})
+		if err != nil {
+			t.Fatalf("put namespace: %v", err)
+		}
+		put(t, "node-a", "/quotas/a", "")
+		put(t, "node-a", "/quotas/b", "")
+		// node-a is at its per-node limit, but may still update its keys.
+		put(t, "node-a", "/quotas/a", "updated")
+		err = store.Admit(ctx, []storage.Op{{Type: storage.OpPut, Key: []byte("/quotas/c"), Owner: "node-a"}})
+		if !errors.Is(err, errors.ErrQuotaExceeded) {
+			t.Fatalf("expected ErrQuotaExceeded for per-node keys, got %v", err)
+		}
+		put(t, "node-b", "/quotas/c", "")
+		err = store.Admit(ctx, []storage.Op{{Type: storage.OpPut, Key: []byte("/quotas/d"), Owner: "node-b"}})
+		if !errors.Is(err, errors.ErrQuotaExceeded) {
+			t.Fatalf("expected ErrQuotaExceeded for keys, got %v", err)
+		}
+		// Deleting a key in the same batch makes room.
+		err = store.Admit(ctx, []storage.Op{
+			{Type: storage.OpDelete, Key: []byte("/quotas/c")},
+			{Type: storage.OpPut, Key: []byte("/quotas/d"), Owner: "node-b"},
+		})
+		if err != nil {
+			t.Fatalf("admit: %v", err)
+		}
+		err = store.Admit(ctx, []storage.Op{{Type: storage.OpPut, Key: []byte("/quotas/c"), Value: make([]byte, 64), Owner: "node-b"}})
+		if !errors.Is(err, errors.ErrQuotaExceeded) {
+			t.Fatalf("expected ErrQuotaExceeded for bytes, got %v", err)
+		}
+		// Keys outside of any namespace are always admitted.
+		if err := store.Admit(ctx, []storage.Op{{Type: storage.OpPut, Key: []byte("/other/a")}}); err != nil {
+			t.Fatalf("admit: %v", err)
+		}
+	})
+}
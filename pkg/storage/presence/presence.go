@@ -0,0 +1,106 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package presence provides the presence leases of mesh members on top of
+// the mesh storage.
+package presence
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// Store manages presence leases in the mesh storage.
+type Store struct {
+	st storage.MeshStorage
+}
+
+// New returns a new presence Store using the given storage.
+func New(st storage.MeshStorage) *Store {
+	return &Store{st: st}
+}
+
+// Put stores the presence lease of a member.
+func (s *Store) Put(ctx context.Context, p types.Presence) error {
+	if !p.NodeID.IsValid() {
+		return fmt.Errorf("invalid node id %q", p.NodeID)
+	}
+	data, err := json.Marshal(p)
+	if err != nil {
+		return fmt.Errorf("marshal presence: %w", err)
+	}
+	err = s.st.PutValue(ctx, types.PresencePrefix.For(p.NodeID.Bytes()), data, 0)
+	if err != nil {
+		return fmt.Errorf("put presence: %w", err)
+	}
+	return nil
+}
+
+// Get returns the presence lease of a member.
+func (s *Store) Get(ctx context.Context, id types.NodeID) (types.Presence, error) {
+	var p types.Presence
+	if !id.IsValid() {
+		return p, errors.ErrPresenceNotFound
+	}
+	data, err := s.st.GetValue(ctx, types.PresencePrefix.For(id.Bytes()))
+	if err != nil {
+		if errors.IsKeyNotFound(err) {
+			return p, errors.ErrPresenceNotFound
+		}
+		return p, fmt.Errorf("get presence: %w", err)
+	}
+	if err := json.Unmarshal(data, &p); err != nil {
+		return p, fmt.Errorf("unmarshal presence: %w", err)
+	}
+	return p, nil
+}
+
+// Delete removes the presence lease of a member.
+func (s *Store) Delete(ctx context.Context, id types.NodeID) error {
+	if !id.IsValid() {
+		return nil
+	}
+	err := s.st.Delete(ctx, types.PresencePrefix.For(id.Bytes()))
+	if err != nil && !errors.IsKeyNotFound(err) {
+		return fmt.Errorf("delete presence: %w", err)
+	}
+	return nil
+}
+
+// List returns the presence leases of all members that have one.
+func (s *Store) List(ctx context.Context) ([]types.Presence, error) {
+	var out []types.Presence
+	err := s.st.IterPrefix(ctx, types.PresencePrefix, func(key, value []byte) error {
+		if string(key) == types.PresencePrefix.String() {
+			return nil
+		}
+		var p types.Presence
+		if err := json.Unmarshal(value, &p); err != nil {
+			return fmt.Errorf("unmarshal presence: %w", err)
+		}
+		out = append(out, p)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list presence: %w", err)
+	}
+	return out, nil
+}
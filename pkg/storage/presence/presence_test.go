@@ -0,0 +1,81 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package presence
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/providers/backends/badgerdb"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+func TestPresence(t *testing.T) {
+	ctx := context.Background()
+	st := badgerdb.NewTestStorage(false)
+	defer st.Close()
+	store := New(st)
+
+	t.Run("CRUD", func(t *testing.T) {
+		lease := types.Presence{
+			NodeID:       "laptop",
+			AgentVersion: "v0.0.0",
+			Endpoints:    []string{"192.0.2.1:51820"},
+			TTL:          time.Minute,
+			LastSeen:     time.Now().UTC(),
+		}
+		if err := store.Put(ctx, lease); err != nil {
+			t.Fatalf("put presence: %v", err)
+		}
+		got, err := store.Get(ctx, "laptop")
+		if err != nil {
+			t.Fatalf("get presence: %v", err)
+		}
+		if got.AgentVersion != lease.AgentVersion || got.TTL != lease.TTL || !got.LastSeen.Equal(lease.LastSeen) {
+			t.Fatalf("expected %+v, got %+v", lease, got)
+		}
+		list, err := store.List(ctx)
+		if err != nil {
+			t.Fatalf("list presence: %v", err)
+		}
+		if len(list) != 1 {
+			t.Fatalf("expected 1 lease, got %d", len(list))
+		}
+		if err := store.Delete(ctx, "laptop"); err != nil {
+			t.Fatalf("delete presence: %v", err)
+		}
+		if _, err := store.Get(ctx, "laptop"); !errors.Is(err, errors.ErrPresenceNotFound) {
+			t.Fatalf("expected ErrPresenceNotFound, got %v", err)
+		}
+	})
+
+	t.Run("Expiry", func(t *testing.T) {
+		now := time.Now()
+		lease := types.Presence{NodeID: "laptop", TTL: time.Minute, LastSeen: now.Add(-2 * time.Minute)}
+		if lease.Online(now) {
+			t.Fatal("expected expired lease to be offline")
+		}
+		if lease.Expired(now, 5*time.Minute) {
+			t.Fatal("expected lease within the grace period not to be purged")
+		}
+		if !lease.Expired(now, 30*time.Second) {
+			t.Fatal("expected lease past the grace period to be purged")
+		}
+	})
+}
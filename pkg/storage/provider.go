@@ -45,7 +45,8 @@ type Provider interface {
 	// ListenPort should return the TCP port that the storage provider is listening on.
 	ListenPort() uint16
 	// MeshDB returns the underlying MeshDB instance. The provider does not
-	// need to guarantee consistency on read operations.
+	// need to guarantee consistency on read operations. Providers that can
+	// offer stronger reads implement ConsistentReader, see WaitForRead.
 	MeshDB() MeshDB
 	// Consensus returns the underlying Consensus instance for managing voting/observing
 	// nodes and leader election.
@@ -56,6 +57,23 @@ type Provider interface {
 	MeshStorage() MeshStorage
 }
 
+// ReadReplica is implemented by providers that can keep a local read-only copy
+// of the mesh registry without being a member of the storage group.
+type ReadReplica interface {
+	// IsReplica returns true if the provider keeps a local copy.
+	IsReplica() bool
+	// ReplicaSynced returns true while the local copy is in sync with a
+	// storage node and reads are served from it.
+	ReplicaSynced() bool
+}
+
+// IsReadReplica returns true if the provider keeps a local copy of the mesh
+// registry.
+func IsReadReplica(p Provider) bool {
+	r, ok := p.(ReadReplica)
+	return ok && r.IsReplica()
+}
+
 // MeshDB is the interface for the mesh database. It provides access to all
 // storage interfaces.
 type MeshDB interface {
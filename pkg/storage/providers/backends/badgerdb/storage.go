@@ -40,6 +40,7 @@ import (
 	"github.com/webmeshproj/webmesh/pkg/context"
 	"github.com/webmeshproj/webmesh/pkg/logging"
 	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/encryption"
 	"github.com/webmeshproj/webmesh/pkg/storage/errors"
 	"github.com/webmeshproj/webmesh/pkg/storage/types"
 )
@@ -56,6 +57,10 @@ func init() {
 	}
 }
 
+// EncryptedIndexCacheSize is the index cache size used for encrypted
+// databases. Badger requires a cache when encryption is enabled.
+var EncryptedIndexCacheSize int64 = 100 << 20
+
 // Options are the options for creating a new NutsDB storage.
 type Options struct {
 	// InMemory specifies whether to use an in-memory storage.
@@ -66,6 +71,13 @@ type Options struct {
 	SyncWrites bool
 	// Debug specifies whether to enable debug logging.
 	Debug bool
+	// Encryption are the keys used to encrypt data on disk. Data written
+	// before encryption was enabled stays readable and is encrypted as
+	// it is compacted.
+	Encryption encryption.Keys
+	// ReadOnly opens the database without writing to it. The key registry
+	// is not rotated, so the previous key may still be in use.
+	ReadOnly bool
 }
 
 type badgerDB struct {
@@ -90,6 +102,25 @@ func New(opts Options) (storage.DualStorage, error) {
 	if opts.Debug {
 		badgeropts = badgeropts.WithLoggingLevel(badger.DEBUG).WithLogger(logging.NewBadgerLogger("debug", "text"))
 	}
+	if err := opts.Encryption.Validate(); err != nil {
+		return nil, err
+	}
+	key := opts.Encryption.Current
+	if opts.ReadOnly {
+		var err error
+		key, err = registryKey(opts.DiskPath, opts.Encryption)
+		if err != nil {
+			return nil, fmt.Errorf("find encryption key: %w", err)
+		}
+		badgeropts = badgeropts.WithReadOnly(true)
+	} else if err := RotateKey(opts.DiskPath, opts.Encryption); err != nil {
+		return nil, fmt.Errorf("rotate encryption key: %w", err)
+	}
+	if len(key) > 0 {
+		badgeropts = badgeropts.
+			WithEncryptionKey(key).
+			WithIndexCacheSize(EncryptedIndexCacheSize)
+	}
 	db, err := badger.Open(badgeropts)
 	if err != nil {
 		return nil, err
@@ -107,6 +138,54 @@ func New(opts Options) (storage.DualStorage, error) {
 	return bdb, nil
 }
 
+// RotateKey re-encrypts the key registry of the database in dir with the
+// current key. The registry may be encrypted with the current key, the
+// previous key or not at all. This is a no-op if it already uses the
+// current key.
+func RotateKey(dir string, keys encryption.Keys) error {
+	key, err := registryKey(dir, keys)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(key, keys.Current) {
+		return nil
+	}
+	opts := keyRegistryOptions(dir, key)
+	kr, err := badger.OpenKeyRegistry(opts)
+	if err != nil {
+		return err
+	}
+	defer kr.Close()
+	opts.EncryptionKey = keys.Current
+	return badger.WriteKeyRegistry(kr, opts)
+}
+
+// registryKey returns the key the registry of the database in dir is
+// encrypted with. A nil key means it is not encrypted.
+func registryKey(dir string, keys encryption.Keys) ([]byte, error) {
+	for _, key := range keys.Candidates() {
+		kr, err := badger.OpenKeyRegistry(keyRegistryOptions(dir, key))
+		if err != nil {
+			if errors.Is(err, badger.ErrEncryptionKeyMismatch) {
+				continue
+			}
+			return nil, err
+		}
+		kr.Close()
+		return key, nil
+	}
+	return nil, encryption.ErrUnknownKey
+}
+
+func keyRegistryOptions(dir string, key []byte) badger.KeyRegistryOptions {
+	return badger.KeyRegistryOptions{
+		Dir:                           dir,
+		ReadOnly:                      true,
+		EncryptionKey:                 key,
+		EncryptionKeyRotationDuration: badger.DefaultOptions(dir).EncryptionKeyRotationDuration,
+	}
+}
+
 // NewInMemory creates a new in-memory BadgerDB storage.
 func NewInMemory(opts Options) (storage.DualStorage, error) {
 	badgeropts := badger.DefaultOptions("").
@@ -170,6 +249,16 @@ func (t *TempDiskStorage) Close() error {
 	return os.RemoveAll(t.tmp)
 }
 
+// Txn implements storage.TxnStorage.
+func (t *TempDiskStorage) Txn(ctx context.Context, txn *storage.Txn) (bool, error) {
+	return t.DualStorage.(storage.TxnStorage).Txn(ctx, txn)
+}
+
+// GetVersion implements storage.TxnStorage.
+func (t *TempDiskStorage) GetVersion(ctx context.Context, key []byte) (uint64, error) {
+	return t.DualStorage.(storage.TxnStorage).GetVersion(ctx, key)
+}
+
 // DropAll deletes all keys.
 func (db *badgerDB) DropAll(ctx context.Context) error {
 	db.mu.Lock()
@@ -336,34 +425,7 @@ func (db *badgerDB) Snapshot(ctx context.Context) (io.Reader, error) {
 	defer db.mu.Unlock()
 	snapshot := &v1.RaftSnapshot{}
 	err := db.db.View(func(txn *badger.Txn) error {
-		opts := badger.DefaultIteratorOptions
-		opts.Prefix = types.RegistryPrefix
-		it := txn.NewIterator(opts)
-		defer it.Close()
-		for it.Rewind(); it.Valid(); it.Next() {
-			item := it.Item()
-			if item.IsDeletedOrExpired() {
-				return nil
-			}
-			var ttl time.Duration
-			if item.ExpiresAt() > 0 {
-				ttl = time.Until(time.Unix(int64(item.ExpiresAt()), 0))
-			}
-			k := item.KeyCopy(nil)
-			value, err := item.ValueCopy(nil)
-			if err != nil {
-				return err
-			}
-			snapshot.Kv = append(snapshot.Kv, &v1.RaftDataItem{
-				Key:   k,
-				Value: value,
-				Ttl:   durationpb.New(ttl),
-			})
-			if err != nil {
-				return err
-			}
-		}
-		return nil
+		return snapshotKeys(txn, snapshot)
 	})
 	if err != nil {
 		return nil, fmt.Errorf("badger snapshot: %w", err)
@@ -375,6 +437,31 @@ func (db *badgerDB) Snapshot(ctx context.Context) (io.Reader, error) {
 	return bytes.NewReader(data), nil
 }
 
+func snapshotKeys(txn *badger.Txn, snapshot *v1.RaftSnapshot) error {
+	it := txn.NewIterator(badger.DefaultIteratorOptions)
+	defer it.Close()
+	for it.Rewind(); it.Valid(); it.Next() {
+		item := it.Item()
+		if item.IsDeletedOrExpired() || !types.IsSnapshotKey(item.Key()) {
+			continue
+		}
+		var ttl time.Duration
+		if item.ExpiresAt() > 0 {
+			ttl = time.Until(time.Unix(int64(item.ExpiresAt()), 0))
+		}
+		value, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+		snapshot.Kv = append(snapshot.Kv, &v1.RaftDataItem{
+			Key:   item.KeyCopy(nil),
+			Value: value,
+			Ttl:   durationpb.New(ttl),
+		})
+	}
+	return nil
+}
+
 // Restore restores a snapshot of the storage.
 func (db *badgerDB) Restore(ctx context.Context, r io.Reader) error {
 	db.mu.Lock()
@@ -545,6 +632,12 @@ func (db *badgerDB) DeleteRange(min, max uint64) error {
 	if err != nil {
 		return fmt.Errorf("delete range: %w", err)
 	}
+	first, last, err := getFirstAndLastIndex(db.db)
+	if err != nil {
+		return fmt.Errorf("delete range: %w", err)
+	}
+	db.firstIdx.Store(first)
+	db.lastIdx.Store(last)
 	return nil
 }
 
@@ -635,7 +728,7 @@ func getFirstAndLastIndex(db *badger.DB) (first, last uint64, err error) {
 			if err != nil {
 				return err
 			}
-			if index < first {
+			if first == 0 || index < first {
 				first = index
 			}
 			if index > last {
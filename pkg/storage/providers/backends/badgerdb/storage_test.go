@@ -0,0 +1,156 @@
+//go:build !wasm
+
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package badgerdb
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"testing"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/encryption"
+)
+
+func TestEncryptionKeyRotation(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	key, value := []byte("/registry/secret"), []byte("value")
+	first, second := newKey(t), newKey(t)
+
+	open := func(keys encryption.Keys) error {
+		db, err := New(Options{DiskPath: dir, Encryption: keys})
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		if err := db.PutValue(ctx, key, value, 0); err != nil {
+			t.Fatalf("put: %v", err)
+		}
+		got, err := db.GetValue(ctx, key)
+		if err != nil {
+			t.Fatalf("get: %v", err)
+		}
+		if !bytes.Equal(got, value) {
+			t.Fatalf("expected %q, got %q", value, got)
+		}
+		return nil
+	}
+
+	// Plaintext, then enable encryption.
+	if err := open(encryption.Keys{}); err != nil {
+		t.Fatalf("open plaintext: %v", err)
+	}
+	if err := open(encryption.Keys{Current: first}); err != nil {
+		t.Fatalf("enable encryption: %v", err)
+	}
+	// Opening without the key or with the wrong key must fail.
+	if err := open(encryption.Keys{}); !errors.Is(err, encryption.ErrUnknownKey) {
+		t.Fatalf("expected ErrUnknownKey without a key, got %v", err)
+	}
+	if err := open(encryption.Keys{Current: second}); !errors.Is(err, encryption.ErrUnknownKey) {
+		t.Fatalf("expected ErrUnknownKey with the wrong key, got %v", err)
+	}
+	// Rotate, and make sure the rotation is idempotent.
+	for i := 0; i < 2; i++ {
+		if err := open(encryption.Keys{Current: second, Previous: first}); err != nil {
+			t.Fatalf("rotate: %v", err)
+		}
+	}
+	if err := open(encryption.Keys{Current: second}); err != nil {
+		t.Fatalf("open with rotated key: %v", err)
+	}
+	if err := open(encryption.Keys{Current: first}); !errors.Is(err, encryption.ErrUnknownKey) {
+		t.Fatalf("expected ErrUnknownKey with the old key, got %v", err)
+	}
+}
+
+func TestSnapshotRestoreTxn(t *testing.T) {
+	ctx := context.Background()
+	key, token := []byte("/app/config"), []byte("/locks/tokens/leader")
+	src := newInMemoryDB(t)
+	for _, k := range [][]byte{key, token} {
+		ok, err := src.Txn(ctx, storage.CompareVersionAndSwap(k, 0, []byte("value1"), 0))
+		if err != nil || !ok {
+			t.Fatalf("expected the first write to %s to succeed, got %v, %v", k, ok, err)
+		}
+	}
+	if err := src.PutValue(ctx, []byte("/raft/log/1"), []byte("log"), 0); err != nil {
+		t.Fatal(err)
+	}
+	snapshot, err := src.Snapshot(ctx)
+	if err != nil {
+		t.Fatalf("snapshot: %v", err)
+	}
+
+	dst := newInMemoryDB(t)
+	if err := dst.Restore(ctx, snapshot); err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	for _, k := range [][]byte{key, token} {
+		got, err := dst.GetValue(ctx, k)
+		if err != nil {
+			t.Fatalf("expected %s to be restored: %v", k, err)
+		}
+		if string(got) != "value1" {
+			t.Fatalf("expected value1 for %s, got %q", k, got)
+		}
+	}
+	if _, err := dst.GetValue(ctx, []byte("/raft/log/1")); err == nil {
+		t.Fatal("expected consensus keys to be left out of the snapshot")
+	}
+
+	// Compares see the same versions and values as on the source.
+	for _, db := range []*badgerDB{src, dst} {
+		ok, err := db.Txn(ctx, storage.CompareVersionAndSwap(key, 0, []byte("value2"), 0))
+		if err != nil || ok {
+			t.Fatalf("expected a create over an existing key to fail, got %v, %v", ok, err)
+		}
+		ok, err = db.Txn(ctx, &storage.Txn{
+			Compare: []storage.Compare{
+				{Key: key, Target: storage.CompareVersion, Version: 1},
+				{Key: token, Target: storage.CompareValue, Value: []byte("value1")},
+			},
+			Success: []storage.Op{{Type: storage.OpPut, Key: key, Value: []byte("value2")}},
+		})
+		if err != nil || !ok {
+			t.Fatalf("expected compares against the snapshot state to succeed, got %v, %v", ok, err)
+		}
+	}
+}
+
+func newInMemoryDB(t *testing.T) *badgerDB {
+	t.Helper()
+	db, err := NewInMemory(Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db.(*badgerDB)
+}
+
+func newKey(t *testing.T) []byte {
+	t.Helper()
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
@@ -0,0 +1,244 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package badgerdb
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/dgraph-io/badger/v4"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// Ensure we satisfy the TxnStorage interface.
+var _ storage.TxnStorage = &badgerDB{}
+
+// Txn atomically evaluates the conditions of the transaction and applies its
+// success or failure operations in a single badger transaction. The versions
+// and owners of written keys, the usage counters of their namespaces and the
+// revision of the store are updated in the same transaction, so replaying an
+// already applied revision is a no-op that returns errors.ErrTxnAlreadyApplied.
+func (db *badgerDB) Txn(ctx context.Context, t *storage.Txn) (bool, error) {
+	if err := t.Validate(); err != nil {
+		return false, err
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	var succeeded bool
+	err := db.db.Update(func(txn *badger.Txn) error {
+		current, err := getUint64(txn, types.KVRevisionKey)
+		if err != nil {
+			return err
+		}
+		rev := t.Revision
+		if rev == 0 {
+			rev = current + 1
+		} else if rev <= current {
+			return errors.ErrTxnAlreadyApplied
+		}
+		succeeded = true
+		for _, cmp := range t.Compare {
+			value, err := getValue(txn, cmp.Key)
+			if err != nil {
+				return err
+			}
+			version, err := getUint64(txn, storage.VersionKey(cmp.Key))
+			if err != nil {
+				return err
+			}
+			if !cmp.Matches(value, version) {
+				succeeded = false
+				break
+			}
+		}
+		ops := t.Success
+		if !succeeded {
+			ops = t.Failure
+		}
+		version := []byte(strconv.FormatUint(rev, 10))
+		usage := &usageTracker{txn: txn, usage: make(map[string]*types.NamespaceUsage)}
+		for _, op := range ops {
+			if err := usage.apply(op); err != nil {
+				return err
+			}
+			switch op.Type {
+			case storage.OpPut:
+				entry := badger.NewEntry(op.Key, op.Value)
+				versionEntry := badger.NewEntry(storage.VersionKey(op.Key), version)
+				ownerEntry := badger.NewEntry(storage.OwnerKey(op.Key), op.Owner.Bytes())
+				if op.TTL > 0 {
+					entry = entry.WithTTL(op.TTL)
+					versionEntry = versionEntry.WithTTL(op.TTL)
+					ownerEntry = ownerEntry.WithTTL(op.TTL)
+				}
+				if err := txn.SetEntry(entry); err != nil {
+					return err
+				}
+				if err := txn.SetEntry(versionEntry); err != nil {
+					return err
+				}
+				if op.Owner == "" {
+					err = txn.Delete(storage.OwnerKey(op.Key))
+				} else {
+					err = txn.SetEntry(ownerEntry)
+				}
+				if err != nil {
+					return err
+				}
+			case storage.OpDelete:
+				for _, key := range [][]byte{op.Key, storage.VersionKey(op.Key), storage.OwnerKey(op.Key)} {
+					if err := txn.Delete(key); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		if err := usage.flush(); err != nil {
+			return err
+		}
+		return txn.Set(types.KVRevisionKey, version)
+	})
+	if err != nil {
+		if errors.Is(err, errors.ErrTxnAlreadyApplied) {
+			return false, err
+		}
+		return false, fmt.Errorf("badger txn: %w", err)
+	}
+	return succeeded, nil
+}
+
+// GetVersion returns the version of a key.
+func (db *badgerDB) GetVersion(ctx context.Context, key []byte) (uint64, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	var version uint64
+	err := db.db.View(func(txn *badger.Txn) error {
+		var err error
+		version, err = getUint64(txn, storage.VersionKey(key))
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("badger get version: %w", err)
+	}
+	return version, nil
+}
+
+// usageTracker keeps the usage counters of namespaces up to date with the
+// operations of a transaction. The accounted usage of every key is kept
+// without a TTL, so a key that expired stays accounted until it is deleted
+// or written again.
+type usageTracker struct {
+	txn   *badger.Txn
+	usage map[string]*types.NamespaceUsage
+}
+
+// apply accounts a single operation to the namespace of its key.
+func (u *usageTracker) apply(op storage.Op) error {
+	name := types.NamespaceOf(op.Key)
+	if name == "" {
+		return nil
+	}
+	usage, ok := u.usage[name]
+	if !ok {
+		usage = &types.NamespaceUsage{}
+		data, err := getValue(u.txn, storage.NamespaceUsageKey(name))
+		if err != nil {
+			return err
+		}
+		if data != nil {
+			if err := json.Unmarshal(data, usage); err != nil {
+				return fmt.Errorf("unmarshal namespace usage: %w", err)
+			}
+		}
+		u.usage[name] = usage
+	}
+	data, err := getValue(u.txn, storage.UsageKey(op.Key))
+	if err != nil {
+		return err
+	}
+	if data != nil {
+		var prev types.NamespaceKeyUsage
+		if err := json.Unmarshal(data, &prev); err != nil {
+			return fmt.Errorf("unmarshal key usage: %w", err)
+		}
+		usage.Add(prev, -1)
+	}
+	if op.Type != storage.OpPut {
+		return u.txn.Delete(storage.UsageKey(op.Key))
+	}
+	usage.Add(op.Usage(), 1)
+	data, err = json.Marshal(op.Usage())
+	if err != nil {
+		return fmt.Errorf("marshal key usage: %w", err)
+	}
+	return u.txn.Set(storage.UsageKey(op.Key), data)
+}
+
+// flush writes the updated counters.
+func (u *usageTracker) flush() error {
+	for name, usage := range u.usage {
+		if usage.Keys == 0 {
+			if err := u.txn.Delete(storage.NamespaceUsageKey(name)); err != nil {
+				return err
+			}
+			continue
+		}
+		data, err := json.Marshal(usage)
+		if err != nil {
+			return fmt.Errorf("marshal namespace usage: %w", err)
+		}
+		if err := u.txn.Set(storage.NamespaceUsageKey(name), data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getValue returns the value of a key within a transaction, or nil if the key
+// does not exist.
+func getValue(txn *badger.Txn, key []byte) ([]byte, error) {
+	item, err := txn.Get(key)
+	if err != nil {
+		if errors.Is(err, badger.ErrKeyNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	value, err := item.ValueCopy(nil)
+	if err != nil {
+		return nil, err
+	}
+	if value == nil {
+		value = []byte{}
+	}
+	return value, nil
+}
+
+// getUint64 returns the uint64 stored at a key within a transaction, or zero
+// if the key does not exist.
+func getUint64(txn *badger.Txn, key []byte) (uint64, error) {
+	value, err := getValue(txn, key)
+	if err != nil || value == nil {
+		return 0, err
+	}
+	return strconv.ParseUint(string(value), 10, 64)
+}
@@ -21,12 +21,17 @@ package passthrough
 
 import (
 	"context"
+	"fmt"
 	"log/slog"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/durationpb"
 
@@ -35,6 +40,7 @@ import (
 	"github.com/webmeshproj/webmesh/pkg/storage"
 	"github.com/webmeshproj/webmesh/pkg/storage/errors"
 	"github.com/webmeshproj/webmesh/pkg/storage/meshdb"
+	"github.com/webmeshproj/webmesh/pkg/storage/providers/backends/badgerdb"
 	"github.com/webmeshproj/webmesh/pkg/storage/types"
 )
 
@@ -42,6 +48,9 @@ import (
 var _ storage.Provider = &Provider{}
 var _ storage.Consensus = &Consensus{}
 var _ storage.MeshStorage = &Storage{}
+var _ storage.TxnStorage = &Storage{}
+var _ storage.WatchStorage = &Storage{}
+var _ storage.ReadReplica = &Provider{}
 
 // Options are the passthrough options.
 type Options struct {
@@ -53,6 +62,9 @@ type Options struct {
 	LogLevel string
 	// LogFormat is the log format to use.
 	LogFormat string
+	// ReplicaHistory is the number of changes a replica keeps in memory
+	// for resuming watches. Defaults to DefaultReplicaHistory.
+	ReplicaHistory int
 }
 
 // Provider is a storage provider that passes through all storage operations to another node
@@ -64,12 +76,40 @@ type Provider struct {
 	consensus  storage.Consensus
 	log        *slog.Logger
 	subCancels []func()
-	closec     chan struct{}
-	mu         sync.Mutex
+	// tracker gives read-your-writes across the storage nodes queried.
+	tracker storage.IndexTracker
+	// replica is the local copy of the registry when replicating.
+	replica *replica
+	closec  chan struct{}
+	mu      sync.Mutex
 }
 
 // NewProvider returns a new passthrough storage provider.
 func NewProvider(opts Options) *Provider {
+	p := newProvider(opts)
+	p.meshDB = meshdb.New(NewMeshDataStore(opts.Dialer))
+	return p
+}
+
+// NewReplicaProvider returns a passthrough storage provider that keeps a local
+// read-only copy of the parts of the mesh registry read by MeshDB. Once it is
+// in sync with a storage node, MeshDB reads, subscriptions and watches of those
+// keys are served from it. Writes and other keys are still passed through.
+func NewReplicaProvider(opts Options) (*Provider, error) {
+	p := newProvider(opts)
+	local, err := badgerdb.NewInMemory(badgerdb.Options{})
+	if err != nil {
+		return nil, fmt.Errorf("create replica storage: %w", err)
+	}
+	st := p.storage.(*Storage)
+	p.replica = newReplica(local, meshdb.Prefixes, opts.ReplicaHistory, st.watchRegistry, p.log.With("component", "storage-replica"))
+	// MeshDB reads go through the storage so they are served locally
+	// once the replica is in sync.
+	p.meshDB = meshdb.NewFromStorage(st)
+	return p, nil
+}
+
+func newProvider(opts Options) *Provider {
 	p := &Provider{
 		Options: opts,
 		log:     logging.NewLogger(opts.LogLevel, opts.LogFormat).With("component", "passthrough-storage"),
@@ -77,7 +117,6 @@ func NewProvider(opts Options) *Provider {
 	}
 	p.storage = &Storage{Provider: p}
 	p.consensus = &Consensus{Provider: p}
-	p.meshDB = meshdb.New(NewMeshDataStore(opts.Dialer))
 	return p
 }
 
@@ -94,9 +133,30 @@ func (p *Provider) Consensus() storage.Consensus {
 }
 
 func (p *Provider) Start(ctx context.Context) error {
+	if p.replica == nil {
+		return nil
+	}
+	// Storage nodes may not be reachable until the node has joined, so the
+	// replica syncs in the background and reads pass through until then.
+	ctx, cancel := context.WithCancel(context.Background())
+	p.mu.Lock()
+	p.subCancels = append(p.subCancels, cancel)
+	p.mu.Unlock()
+	go p.replica.run(ctx)
 	return nil
 }
 
+// IsReplica returns true if the provider keeps a local copy of the registry.
+func (p *Provider) IsReplica() bool {
+	return p.replica != nil
+}
+
+// ReplicaSynced returns true once the local copy of the registry is in sync
+// with a storage node.
+func (p *Provider) ReplicaSynced() bool {
+	return p.replica != nil && p.replica.synced.Load()
+}
+
 func (p *Provider) ListenPort() uint16 {
 	return 0
 }
@@ -112,6 +172,9 @@ func (p *Provider) Close() error {
 		for _, cancel := range p.subCancels {
 			cancel()
 		}
+		if p.replica != nil {
+			return p.replica.local.Close()
+		}
 	}
 	return nil
 }
@@ -250,6 +313,9 @@ type Storage struct {
 
 // GetValue returns the value of a key.
 func (p *Storage) GetValue(ctx context.Context, key []byte) ([]byte, error) {
+	if p.replicated(key) {
+		return p.replica.local.GetValue(ctx, key)
+	}
 	cli, close, err := p.newStorageClient(ctx)
 	if err != nil {
 		return nil, err
@@ -292,6 +358,70 @@ func (p *Storage) PutValue(ctx context.Context, key, value []byte, ttl time.Dura
 	return err
 }
 
+// Txn sends the transaction with a Publish request to a storage node.
+func (p *Storage) Txn(ctx context.Context, txn *storage.Txn) (bool, error) {
+	if err := txn.Validate(); err != nil {
+		return false, err
+	}
+	cli, close, err := p.newStorageClient(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer close()
+	ctx, err = storage.ContextWithTxn(ctx, txn)
+	if err != nil {
+		return false, err
+	}
+	var header metadata.MD
+	_, err = cli.Publish(ctx, &v1.PublishRequest{}, grpc.Header(&header))
+	if err != nil {
+		if status.Code(err) == codes.Unimplemented {
+			return false, errors.ErrTxnNotSupported
+		}
+		return false, err
+	}
+	vals := header.Get(storage.TxnSucceededMeta)
+	if len(vals) == 0 {
+		return false, errors.ErrTxnNotSupported
+	}
+	return strconv.ParseBool(vals[0])
+}
+
+// GetVersion returns the version of a key as seen by a storage node.
+func (p *Storage) GetVersion(ctx context.Context, key []byte) (uint64, error) {
+	if !types.IsValidPathID(string(key)) {
+		return 0, errors.ErrInvalidKey
+	}
+	cli, close, err := p.newStorageClient(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer close()
+	var header metadata.MD
+	resp, err := cli.Query(ctx, &v1.QueryRequest{
+		Command: v1.QueryRequest_GET,
+		Type:    v1.QueryRequest_VALUE,
+		Query:   types.NewQueryFilters().WithID(string(key)).Encode(),
+	}, grpc.Header(&header))
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return 0, nil
+		}
+		return 0, err
+	}
+	if resp.GetError() != "" {
+		if strings.Contains(resp.GetError(), errors.ErrKeyNotFound.Error()) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("query version: %s", resp.GetError())
+	}
+	vals := header.Get(storage.VersionMeta)
+	if len(vals) == 0 {
+		return 0, errors.ErrTxnNotSupported
+	}
+	return strconv.ParseUint(vals[0], 10, 64)
+}
+
 // Delete removes a key.
 func (p *Storage) Delete(ctx context.Context, key []byte) error {
 	return errors.ErrNotStorageNode
@@ -299,6 +429,9 @@ func (p *Storage) Delete(ctx context.Context, key []byte) error {
 
 // ListKeys returns all keys with a given prefix.
 func (p *Storage) ListKeys(ctx context.Context, prefix []byte) ([][]byte, error) {
+	if p.replicated(prefix) {
+		return p.replica.local.ListKeys(ctx, prefix)
+	}
 	cli, close, err := p.newStorageClient(ctx)
 	if err != nil {
 		return nil, err
@@ -319,6 +452,9 @@ func (p *Storage) ListKeys(ctx context.Context, prefix []byte) ([][]byte, error)
 // that the iterator not attempt any write operations as this will cause
 // a deadlock.
 func (p *Storage) IterPrefix(ctx context.Context, prefix []byte, fn storage.PrefixIterator) error {
+	if p.replicated(prefix) {
+		return p.replica.local.IterPrefix(ctx, prefix, fn)
+	}
 	keys, err := p.ListKeys(ctx, prefix)
 	if err != nil {
 		return err
@@ -345,6 +481,10 @@ func (p *Storage) Subscribe(ctx context.Context, prefix []byte, fn storage.KVSub
 	p.mu.Lock()
 	p.subCancels = append(p.subCancels, cancel)
 	p.mu.Unlock()
+	if p.replica != nil && p.replica.replicates(prefix) {
+		go p.replica.subscribe(ctx, prefix, fn)
+		return cancel, nil
+	}
 	go func() {
 		var started bool
 		for {
@@ -400,6 +540,106 @@ func (p *Storage) Subscribe(ctx context.Context, prefix []byte, fn storage.KVSub
 	return cancel, nil
 }
 
+// Revision returns the latest revision of a storage node, or of the
+// replica once it is in sync.
+func (p *Storage) Revision(ctx context.Context) (uint64, error) {
+	if p.ReplicaSynced() {
+		return p.replica.latest(), nil
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, close, err := p.newWatchStream(ctx, types.RegistryPrefix, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer close()
+	header, err := stream.Header()
+	if err != nil {
+		return 0, err
+	}
+	return storage.RevisionFromHeader(header), nil
+}
+
+// Watch watches changes to a prefix on a storage node after the given revision.
+// A revision that is no longer in the history of the storage node is reported
+// on the returned channel. Watches of the registry are served by the replica
+// once it is in sync.
+func (p *Storage) Watch(ctx context.Context, prefix []byte, revision uint64, fn storage.WatchFunc) (<-chan error, error) {
+	if p.replicated(prefix) {
+		return p.replica.watch(ctx, prefix, revision, fn)
+	}
+	return p.watch(ctx, prefix, revision, fn)
+}
+
+// watchRegistry watches the registry on a storage node for the replica.
+func (p *Storage) watchRegistry(ctx context.Context, revision uint64, snapshot bool, fn storage.WatchFunc) (<-chan error, error) {
+	if snapshot {
+		ctx = storage.ContextWithWatchSnapshot(ctx)
+	}
+	return p.watch(ctx, types.RegistryPrefix, revision, fn)
+}
+
+func (p *Storage) watch(ctx context.Context, prefix []byte, revision uint64, fn storage.WatchFunc) (<-chan error, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	stream, closeConn, err := p.newWatchStream(ctx, prefix, revision)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	done := make(chan error, 1)
+	go func() {
+		defer close(done)
+		defer closeConn()
+		defer cancel()
+		for {
+			res, err := stream.Recv()
+			if err != nil {
+				if ctx.Err() == nil && status.Code(err) == codes.OutOfRange {
+					done <- errors.ErrCompacted
+				}
+				return
+			}
+			ev, err := storage.UnmarshalWatchEvent(res.GetValue())
+			if err != nil {
+				p.log.Error("error decoding watch event", "error", err.Error())
+				continue
+			}
+			fn(ev)
+		}
+	}()
+	return done, nil
+}
+
+func (p *Storage) newWatchStream(ctx context.Context, prefix []byte, revision uint64) (v1.StorageQueryService_SubscribeClient, func(), error) {
+	cli, close, err := p.newStorageClient(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	stream, err := cli.Subscribe(storage.ContextWithWatchRevision(ctx, revision), &v1.SubscribeRequest{
+		Prefix: prefix,
+	})
+	if err == nil {
+		// Wait for the header so rejected watches fail here.
+		_, err = stream.Header()
+	}
+	if err != nil {
+		close()
+		return nil, nil, watchError(err)
+	}
+	return stream, close, nil
+}
+
+func watchError(err error) error {
+	switch status.Code(err) {
+	case codes.Unimplemented:
+		return errors.ErrWatchNotSupported
+	case codes.OutOfRange:
+		return errors.ErrCompacted
+	default:
+		return err
+	}
+}
+
 func (p *Storage) doSubscribe(ctx context.Context, prefix []byte, fn storage.KVSubscribeFunc) error {
 	cli, close, err := p.newStorageClient(ctx)
 	if err != nil {
@@ -434,6 +674,12 @@ func (p *Storage) Close() error {
 	return nil
 }
 
+// replicated returns true if reads of the key or prefix are served from
+// the replica.
+func (p *Storage) replicated(key []byte) bool {
+	return p.ReplicaSynced() && p.replica.replicates(key)
+}
+
 func (p *Storage) newStorageClient(ctx context.Context) (v1.StorageQueryServiceClient, func(), error) {
 	select {
 	case <-p.closec:
@@ -444,7 +690,7 @@ func (p *Storage) newStorageClient(ctx context.Context) (v1.StorageQueryServiceC
 	if err != nil {
 		return nil, nil, err
 	}
-	return v1.NewStorageQueryServiceClient(c), func() { _ = c.Close() }, nil
+	return v1.NewStorageQueryServiceClient(p.tracker.Conn(c)), func() { _ = c.Close() }, nil
 }
 
 func (p *Storage) checkErr(fn func() error) {
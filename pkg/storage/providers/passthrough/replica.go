@@ -0,0 +1,302 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package passthrough
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// DefaultReplicaHistory is the default number of changes a read replica keeps
+// in memory for resuming watches.
+const DefaultReplicaHistory = 10000
+
+// replicaWatchFunc starts a watch of the registry on a storage node. A snapshot
+// watch starts at the latest revision with the current values of the registry.
+type replicaWatchFunc func(ctx context.Context, revision uint64, snapshot bool, fn storage.WatchFunc) (<-chan error, error)
+
+// replica keeps a local read-only copy of prefixes of the mesh registry. It is
+// loaded from a snapshot sent by a storage node and kept in sync with the
+// changes that follow it. Changes to other keys only advance its revision.
+// Revisions are those of the storage node, so watches can move between the
+// replica and storage nodes.
+type replica struct {
+	local    storage.MeshStorage
+	prefixes []types.StoragePrefix
+	watchFn  replicaWatchFunc
+	log      *slog.Logger
+	synced   atomic.Bool
+	ready    chan struct{}
+	// loading holds the keys received in a snapshot until it is complete.
+	loading map[string]struct{}
+
+	size      int
+	events    []storage.WatchEvent
+	revision  uint64
+	compacted uint64
+	notify    chan struct{}
+	mu        sync.RWMutex
+}
+
+func newReplica(local storage.MeshStorage, prefixes []types.StoragePrefix, size int, watchFn replicaWatchFunc, log *slog.Logger) *replica {
+	if size <= 0 {
+		size = DefaultReplicaHistory
+	}
+	return &replica{
+		local:    local,
+		prefixes: prefixes,
+		watchFn:  watchFn,
+		log:      log,
+		ready:    make(chan struct{}),
+		size:     size,
+		notify:   make(chan struct{}),
+	}
+}
+
+// run keeps the replica in sync until the context is done. Watches are resumed
+// from the last applied revision and a new snapshot is only loaded when that
+// revision is no longer in the history of the storage node.
+func (r *replica) run(ctx context.Context) {
+	resync := true
+	for {
+		var done <-chan error
+		var err error
+		if resync {
+			r.loading = make(map[string]struct{})
+			done, err = r.watchFn(ctx, 0, true, r.apply)
+		} else {
+			done, err = r.watchFn(ctx, r.latest(), false, r.apply)
+		}
+		if err == nil {
+			resync = false
+			err = <-done
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		if errors.Is(err, errors.ErrCompacted) {
+			r.log.Warn("Replica fell behind the storage history, reloading the registry")
+			resync = true
+			continue
+		}
+		if err != nil {
+			r.log.Error("error replicating storage, retrying in 3 seconds", "error", err.Error())
+		}
+		if r.loading != nil {
+			// The snapshot did not complete.
+			resync = true
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(3 * time.Second):
+		}
+	}
+}
+
+// replicates returns true if the key or prefix is within the replicated
+// prefixes.
+func (r *replica) replicates(key []byte) bool {
+	for _, prefix := range r.prefixes {
+		if prefix.Contains(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// apply applies an event received from a storage node.
+func (r *replica) apply(ev storage.WatchEvent) {
+	ctx := context.Background()
+	if (ev.Type == storage.EventPut || ev.Type == storage.EventDelete) && !r.replicates(ev.Key) {
+		if r.loading == nil {
+			r.mu.Lock()
+			r.revision = max(r.revision, ev.Revision)
+			r.mu.Unlock()
+		}
+		return
+	}
+	var err error
+	switch ev.Type {
+	case storage.EventPut:
+		if r.loading != nil {
+			r.loading[string(ev.Key)] = struct{}{}
+		}
+		err = r.local.PutValue(ctx, ev.Key, ev.Value, 0)
+	case storage.EventDelete:
+		err = r.local.Delete(ctx, ev.Key)
+	case storage.EventSynced:
+		r.finishSnapshot(ctx, ev.Revision)
+		return
+	default:
+		return
+	}
+	if err != nil {
+		r.log.Error("error applying replicated change", "key", string(ev.Key), "error", err.Error())
+	}
+	if r.loading != nil {
+		// Snapshot values are not changes, watchers resync after it.
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, ev)
+	for len(r.events) > r.size {
+		r.compact(r.events[0].Revision)
+	}
+	if ev.Revision > r.revision {
+		r.revision = ev.Revision
+	}
+	r.broadcast()
+}
+
+// finishSnapshot removes the keys missing from a completed snapshot and starts
+// the history over from its revision.
+func (r *replica) finishSnapshot(ctx context.Context, revision uint64) {
+	keys, err := r.local.ListKeys(ctx, types.RegistryPrefix)
+	if err != nil {
+		r.log.Error("error listing replicated keys", "error", err.Error())
+	}
+	for _, key := range keys {
+		if _, ok := r.loading[string(key)]; ok {
+			continue
+		}
+		if err := r.local.Delete(ctx, key); err != nil {
+			r.log.Error("error removing replicated key", "key", string(key), "error", err.Error())
+		}
+	}
+	r.loading = nil
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.revision = revision
+	r.compact(revision)
+	r.broadcast()
+	if !r.synced.Swap(true) {
+		r.log.Info("Replica is in sync with storage", slog.Uint64("revision", revision))
+		close(r.ready)
+	}
+}
+
+// compact drops all events up to and including the given revision. The caller
+// must hold the lock.
+func (r *replica) compact(revision uint64) {
+	if revision > r.compacted {
+		r.compacted = revision
+	}
+	i := sort.Search(len(r.events), func(i int) bool {
+		return r.events[i].Revision > r.compacted
+	})
+	r.events = append(r.events[:0], r.events[i:]...)
+}
+
+// broadcast wakes up all watchers. The caller must hold the lock.
+func (r *replica) broadcast() {
+	close(r.notify)
+	r.notify = make(chan struct{})
+}
+
+// latest returns the revision of the last applied change.
+func (r *replica) latest() uint64 {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.revision
+}
+
+// since returns the events for the prefix after the given revision, the
+// revision they are current to, and a channel closed on the next change.
+func (r *replica) since(prefix []byte, revision uint64) ([]storage.WatchEvent, uint64, <-chan struct{}, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if revision < r.compacted {
+		return nil, 0, nil, errors.ErrCompacted
+	}
+	i := sort.Search(len(r.events), func(i int) bool {
+		return r.events[i].Revision > revision
+	})
+	var out []storage.WatchEvent
+	for _, ev := range r.events[i:] {
+		if bytes.HasPrefix(ev.Key, prefix) {
+			out = append(out, ev)
+		}
+	}
+	return out, max(revision, r.revision), r.notify, nil
+}
+
+// watch calls fn for every change to the prefix after the given revision until
+// the context is done or the watcher falls behind the history.
+func (r *replica) watch(ctx context.Context, prefix []byte, revision uint64, fn storage.WatchFunc) (<-chan error, error) {
+	if revision == 0 {
+		revision = r.latest()
+	}
+	events, next, notify, err := r.since(prefix, revision)
+	if err != nil {
+		return nil, err
+	}
+	done := make(chan error, 1)
+	go func() {
+		defer close(done)
+		for {
+			for _, ev := range events {
+				fn(ev)
+			}
+			revision = next
+			select {
+			case <-ctx.Done():
+				return
+			case <-notify:
+			}
+			events, next, notify, err = r.since(prefix, revision)
+			if err != nil {
+				done <- err
+				return
+			}
+		}
+	}()
+	return done, nil
+}
+
+// subscribe calls fn with the current values of the prefix once the replica
+// is in sync, and then with every change to it.
+func (r *replica) subscribe(ctx context.Context, prefix []byte, fn storage.KVSubscribeFunc) {
+	select {
+	case <-ctx.Done():
+		return
+	case <-r.ready:
+	}
+	// Subscribe before iterating so no change is missed in between.
+	_, err := r.local.Subscribe(ctx, prefix, fn)
+	if err != nil {
+		r.log.Error("error subscribing to replica", "error", err.Error())
+		return
+	}
+	err = r.local.IterPrefix(ctx, prefix, func(key, value []byte) error {
+		fn(key, value)
+		return nil
+	})
+	if err != nil && ctx.Err() == nil {
+		r.log.Error("error iterating replica", "error", err.Error())
+	}
+}
@@ -0,0 +1,173 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package passthrough
+
+import (
+	"context"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/providers/backends/badgerdb"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// fakeUpstream is a storage node that sends the events given to each watch.
+type fakeUpstream struct {
+	watches chan fakeWatch
+}
+
+type fakeWatch struct {
+	revision uint64
+	snapshot bool
+	events   chan storage.WatchEvent
+	done     chan error
+}
+
+func (f *fakeUpstream) watch(ctx context.Context, revision uint64, snapshot bool, fn storage.WatchFunc) (<-chan error, error) {
+	w := fakeWatch{revision: revision, snapshot: snapshot, events: make(chan storage.WatchEvent), done: make(chan error, 1)}
+	done := make(chan error, 1)
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case ev := <-w.events:
+				fn(ev)
+			case err := <-w.done:
+				done <- err
+				return
+			}
+		}
+	}()
+	f.watches <- w
+	return done, nil
+}
+
+func TestReplica(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	local := badgerdb.NewTestStorage(false)
+	defer local.Close()
+	up := &fakeUpstream{watches: make(chan fakeWatch)}
+	r := newReplica(local, []types.StoragePrefix{storage.NodesPrefix}, 0, up.watch, slog.Default())
+	go r.run(ctx)
+
+	key := func(name string) []byte { return storage.NodesPrefix.ForString(name) }
+	put := func(name, value string, rev uint64) storage.WatchEvent {
+		return storage.WatchEvent{Type: storage.EventPut, Key: key(name), Value: []byte(value), Revision: rev}
+	}
+	nextWatch := func(snapshot bool) fakeWatch {
+		t.Helper()
+		select {
+		case w := <-up.watches:
+			if w.snapshot != snapshot {
+				t.Fatalf("expected snapshot %v, got %v", snapshot, w.snapshot)
+			}
+			return w
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for watch")
+		}
+		return fakeWatch{}
+	}
+	expectValues := func(want map[string]string) {
+		t.Helper()
+		got := map[string]string{}
+		err := local.IterPrefix(ctx, types.RegistryPrefix, func(k, v []byte) error {
+			got[string(k)] = string(v)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != len(want) {
+			t.Fatalf("expected %v, got %v", want, got)
+		}
+		for name, value := range want {
+			if got[string(key(name))] != value {
+				t.Fatalf("expected %v, got %v", want, got)
+			}
+		}
+	}
+
+	// Load the initial snapshot.
+	w := nextWatch(true)
+	w.events <- put("a", "1", 5)
+	w.events <- put("b", "2", 5)
+	if r.synced.Load() {
+		t.Fatal("replica synced before the snapshot completed")
+	}
+	w.events <- storage.WatchEvent{Type: storage.EventSynced, Key: types.RegistryPrefix, Revision: 5}
+	select {
+	case <-r.ready:
+	case <-time.After(5 * time.Second):
+		t.Fatal("replica did not sync")
+	}
+	expectValues(map[string]string{"a": "1", "b": "2"})
+
+	// Watches of the replica see the changes that follow.
+	events := make(chan storage.WatchEvent, 10)
+	watchDone, err := r.watch(ctx, types.RegistryPrefix, 5, func(ev storage.WatchEvent) { events <- ev })
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.events <- put("c", "3", 6)
+	w.events <- storage.WatchEvent{Type: storage.EventDelete, Key: key("a"), Revision: 7}
+	// Keys outside of the replicated prefixes are not copied.
+	w.events <- storage.WatchEvent{Type: storage.EventPut, Key: types.PresencePrefix.ForString("a"), Value: []byte("1"), Revision: 8}
+	w.events <- put("e", "5", 9)
+	for _, want := range []uint64{6, 7, 9} {
+		select {
+		case ev := <-events:
+			if ev.Revision != want {
+				t.Fatalf("expected revision %d, got %d", want, ev.Revision)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for watch event")
+		}
+	}
+	expectValues(map[string]string{"b": "2", "c": "3", "e": "5"})
+	if r.latest() != 9 {
+		t.Fatalf("expected revision 9, got %d", r.latest())
+	}
+	if !r.replicates(key("b")) || r.replicates(types.PresencePrefix) || r.replicates(types.RegistryPrefix) {
+		t.Fatal("unexpected replicated prefixes")
+	}
+
+	// Falling behind the storage node reloads the registry and compacts
+	// the history of the replica.
+	w.done <- errors.ErrCompacted
+	w = nextWatch(true)
+	w.events <- put("b", "2", 10)
+	w.events <- put("d", "4", 10)
+	w.events <- storage.WatchEvent{Type: storage.EventSynced, Key: types.RegistryPrefix, Revision: 10}
+	select {
+	case err := <-watchDone:
+		if !errors.Is(err, errors.ErrCompacted) {
+			t.Fatalf("expected ErrCompacted, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("watch was not compacted")
+	}
+	expectValues(map[string]string{"b": "2", "d": "4"})
+	if _, err := r.watch(ctx, types.RegistryPrefix, 7, func(storage.WatchEvent) {}); !errors.Is(err, errors.ErrCompacted) {
+		t.Fatalf("expected ErrCompacted, got %v", err)
+	}
+}
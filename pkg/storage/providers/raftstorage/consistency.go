@@ -0,0 +1,91 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package raftstorage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/raft"
+
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+)
+
+// Ensure we satisfy the consistent reader interface.
+var _ storage.ConsistentReader = &Provider{}
+
+// readPollInterval is how often a read waiting for the log checks the applied index.
+const readPollInterval = 10 * time.Millisecond
+
+// WaitForRead blocks until the local state satisfies the read consistency.
+// Linearizable reads follow the raft read-index protocol: the commit index is
+// captured, leadership is confirmed with a quorum, and the read waits until the
+// captured index is applied.
+func (r *Provider) WaitForRead(ctx context.Context, rc storage.ReadConsistency) error {
+	if !r.started.Load() {
+		return errors.ErrClosed
+	}
+	index := rc.MinIndex
+	switch rc.Level {
+	case storage.ConsistencyLinearizable:
+		if r.raft.State() != raft.Leader {
+			return errors.ErrNotLeader
+		}
+		index = max(index, r.raft.CommitIndex())
+		if err := r.raft.VerifyLeader().Error(); err != nil {
+			if errors.Is(err, raft.ErrNotLeader) || errors.Is(err, raft.ErrLeadershipLost) {
+				return errors.ErrNotLeader
+			}
+			return fmt.Errorf("verify leader: %w", err)
+		}
+	case storage.ConsistencyBounded:
+		if r.raft.State() != raft.Leader {
+			since := time.Since(r.raft.LastContact())
+			if since > rc.MaxStaleness {
+				return fmt.Errorf("%w: last contact with the leader was %s ago", errors.ErrStaleRead, since.Truncate(time.Millisecond))
+			}
+		}
+	}
+	return r.waitForIndex(ctx, index)
+}
+
+// waitForIndex blocks until the given index is applied to the FSM. Contexts
+// without a deadline wait for at most the apply timeout.
+func (r *Provider) waitForIndex(ctx context.Context, index uint64) error {
+	if index == 0 || r.raft.AppliedIndex() >= index {
+		return nil
+	}
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.Options.ApplyTimeout)
+		defer cancel()
+	}
+	t := time.NewTicker(readPollInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w: index %d not applied, at %d", errors.ErrStaleRead, index, r.raft.AppliedIndex())
+		case <-t.C:
+			if r.raft.AppliedIndex() >= index {
+				return nil
+			}
+		}
+	}
+}
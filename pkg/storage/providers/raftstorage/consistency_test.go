@@ -0,0 +1,81 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package raftstorage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/transport/tcp"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+)
+
+func TestWaitForRead(t *testing.T) {
+	ctx := context.Background()
+	transport, err := tcp.NewRaftTransport(nil, tcp.RaftTransportOptions{
+		Addr:    "[::]:0",
+		MaxPool: 10,
+		Timeout: time.Second,
+	})
+	if err != nil {
+		t.Fatalf("failed to create raft transport: %v", err)
+	}
+	p := NewProvider(newTestOptions(transport))
+	if err := p.Start(ctx); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	t.Cleanup(func() { _ = p.Close() })
+	if err := p.Bootstrap(ctx); err != nil {
+		t.Fatalf("bootstrap: %v", err)
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for !p.Consensus().IsLeader() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for leadership")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if err := p.MeshStorage().PutValue(ctx, []byte("/consistency"), []byte("value"), 0); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+	written := p.AppliedIndex()
+
+	t.Run("Linearizable", func(t *testing.T) {
+		err := p.WaitForRead(ctx, storage.ReadConsistency{Level: storage.ConsistencyLinearizable, MinIndex: written})
+		if err != nil {
+			t.Fatalf("expected linearizable read on the leader, got %v", err)
+		}
+	})
+
+	t.Run("Bounded", func(t *testing.T) {
+		err := p.WaitForRead(ctx, storage.ReadConsistency{Level: storage.ConsistencyBounded, MaxStaleness: time.Second})
+		if err != nil {
+			t.Fatalf("expected bounded read on the leader, got %v", err)
+		}
+	})
+
+	t.Run("FutureIndex", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+		defer cancel()
+		err := p.WaitForRead(ctx, storage.ReadConsistency{MinIndex: written + 1000})
+		if !errors.Is(err, errors.ErrStaleRead) {
+			t.Fatalf("expected ErrStaleRead, got %v", err)
+		}
+	})
+}
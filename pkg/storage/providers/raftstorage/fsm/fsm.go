@@ -54,6 +54,12 @@ type RaftFSM struct {
 type Options struct {
 	// ApplyTimeout is the timeout for applying a log entry.
 	ApplyTimeout time.Duration
+	// OnApply is called with every command applied to the store and its response.
+	// It is called with the FSM lock held.
+	OnApply func(index uint64, cmd *v1.RaftLogEntry, res *v1.RaftApplyResponse)
+	// OnRestore is called after the store is restored from a snapshot. It is
+	// called with the FSM lock held.
+	OnRestore func()
 }
 
 // New returns a new RaftFSM. The storage interface must be a direct
@@ -94,6 +100,9 @@ func (r *RaftFSM) Restore(rdr io.ReadCloser) error {
 	if err != nil {
 		return fmt.Errorf("restore snapshot: %w", err)
 	}
+	if r.opts.OnRestore != nil {
+		r.opts.OnRestore()
+	}
 	return nil
 }
 
@@ -103,7 +112,9 @@ func (r *RaftFSM) ApplyBatch(logs []*raft.Log) []any {
 	r.log.Debug("Applying batch", slog.Int("count", len(logs)))
 	res := make([]any, len(logs))
 	for i, l := range logs {
-		_, res[i] = r.applyLog(l)
+		cmd, resp := r.applyLog(l)
+		r.onApply(l, cmd, resp)
+		res[i] = resp
 	}
 	r.mu.Unlock()
 	return res
@@ -112,11 +123,18 @@ func (r *RaftFSM) ApplyBatch(logs []*raft.Log) []any {
 // Apply applies a Raft log entry to the store.
 func (r *RaftFSM) Apply(l *raft.Log) any {
 	r.mu.Lock()
-	_, res := r.applyLog(l)
+	cmd, res := r.applyLog(l)
+	r.onApply(l, cmd, res)
 	r.mu.Unlock()
 	return res
 }
 
+func (r *RaftFSM) onApply(l *raft.Log, cmd *v1.RaftLogEntry, res *v1.RaftApplyResponse) {
+	if cmd != nil && r.opts.OnApply != nil {
+		r.opts.OnApply(l.Index, cmd, res)
+	}
+}
+
 func (r *RaftFSM) applyLog(l *raft.Log) (cmd *v1.RaftLogEntry, res *v1.RaftApplyResponse) {
 	log := r.log.With(slog.Int("index", int(l.Index)), slog.Int("term", int(l.Term)))
 	log.Debug("applying log", "type", l.Type.String())
@@ -176,7 +194,7 @@ func (r *RaftFSM) applyLog(l *raft.Log) (cmd *v1.RaftLogEntry, res *v1.RaftApply
 	ctx = context.WithLogger(ctx, log)
 
 	// Apply the log entry to the database.
-	return cmd, raftlogs.Apply(ctx, r.store, cmd)
+	return cmd, raftlogs.Apply(ctx, r.store, l.Index, cmd)
 }
 
 // MarshalLogEntry marshals a RaftLogEntry.
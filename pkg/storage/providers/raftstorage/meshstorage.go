@@ -24,22 +24,29 @@ import (
 
 	"github.com/hashicorp/raft"
 	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/protobuf/types/known/durationpb"
 
 	"github.com/webmeshproj/webmesh/pkg/context"
 	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/audit"
 	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/providers/raftstorage/raftlogs"
 	"github.com/webmeshproj/webmesh/pkg/storage/types"
 )
 
-// Ensure we satisfy the MeshStorage interface.
+// Ensure we satisfy the MeshStorage, TxnStorage, and WatchStorage interfaces.
 var _ storage.MeshStorage = &RaftStorage{}
+var _ storage.TxnStorage = &RaftStorage{}
+var _ storage.WatchStorage = &RaftStorage{}
 
 // RaftStorage wraps the storage.Storage interface to force write operations through the Raft log.
 type RaftStorage struct {
 	storage    storage.MeshStorage
 	writecount atomic.Int32
 	raft       *Provider
+	history    *watchHistory
 }
 
 // Close closes the storage.
@@ -85,6 +92,23 @@ func (rs *RaftStorage) Subscribe(ctx context.Context, prefix []byte, fn storage.
 	return rs.storage.Subscribe(ctx, prefix, fn)
 }
 
+// Revision returns the raft index of the last change applied to the local replica.
+func (rs *RaftStorage) Revision(ctx context.Context) (uint64, error) {
+	if !rs.raft.started.Load() {
+		return 0, errors.ErrClosed
+	}
+	return rs.history.latest(), nil
+}
+
+// Watch watches changes to a prefix applied to the local replica after the given
+// revision. Revisions are raft indexes, so they are the same on every replica.
+func (rs *RaftStorage) Watch(ctx context.Context, prefix []byte, revision uint64, fn storage.WatchFunc) (<-chan error, error) {
+	if !rs.raft.started.Load() {
+		return nil, errors.ErrClosed
+	}
+	return rs.history.watch(ctx, prefix, revision, fn)
+}
+
 // Put sets the value of a key.
 func (rs *RaftStorage) PutValue(ctx context.Context, key, value []byte, ttl time.Duration) error {
 	if !rs.raft.started.Load() {
@@ -102,12 +126,20 @@ func (rs *RaftStorage) PutValue(ctx context.Context, key, value []byte, ttl time
 		Value: value,
 		Ttl:   durationpb.New(ttl),
 	}
+	ops := []storage.Op{{Type: storage.OpPut, Key: key, Value: value}}
+	record := rs.auditChanges(ctx, ops)
+	var err error
 	if rs.raft.Consensus().IsLeader() {
 		// lock is taken in the FSM
-		return rs.applyLog(ctx, &logEntry)
+		err = rs.applyLog(ctx, &logEntry)
+	} else {
+		// We need to forward the request to the leader.
+		err = rs.sendLogToLeader(ctx, &logEntry)
 	}
-	// We need to forward the request to the leader.
-	return rs.sendLogToLeader(ctx, &logEntry)
+	if err == nil {
+		record(ops)
+	}
+	return err
 }
 
 // Delete removes a key.
@@ -125,12 +157,104 @@ func (rs *RaftStorage) Delete(ctx context.Context, key []byte) error {
 		Type: v1.RaftCommandType_DELETE,
 		Key:  key,
 	}
+	ops := []storage.Op{{Type: storage.OpDelete, Key: key}}
+	record := rs.auditChanges(ctx, ops)
+	var err error
 	if rs.raft.Consensus().IsLeader() {
 		// lock is taken in the FSM
-		return rs.applyLog(ctx, &logEntry)
+		err = rs.applyLog(ctx, &logEntry)
+	} else {
+		// We need to forward the request to the leader.
+		err = rs.sendLogToLeader(ctx, &logEntry)
+	}
+	if err == nil {
+		record(ops)
+	}
+	return err
+}
+
+// Txn replicates a transaction as a single raft log entry. It returns true if
+// the conditions of the transaction held and its success operations were applied.
+func (rs *RaftStorage) Txn(ctx context.Context, txn *storage.Txn) (bool, error) {
+	if !rs.raft.started.Load() {
+		return false, errors.ErrClosed
+	}
+	if _, ok := rs.storage.(storage.TxnStorage); !ok {
+		return false, errors.ErrTxnNotSupported
+	}
+	if err := txn.Validate(); err != nil {
+		return false, err
+	}
+	if !rs.raft.isVoter() {
+		return false, errors.ErrNotVoter
+	}
+	data, err := storage.MarshalTxn(txn)
+	if err != nil {
+		return false, fmt.Errorf("marshal txn: %w", err)
+	}
+	logEntry := v1.RaftLogEntry{
+		Type:  raftlogs.CommandTxn,
+		Value: data,
+	}
+	record := rs.auditChanges(ctx, append(append([]storage.Op(nil), txn.Success...), txn.Failure...))
+	if rs.raft.Consensus().IsLeader() {
+		// lock is taken in the FSM
+		err = rs.applyLog(ctx, &logEntry)
+	} else {
+		// We need to forward the request to the leader.
+		err = rs.sendLogToLeader(ctx, &logEntry)
+	}
+	if err != nil {
+		if errors.Is(err, errors.ErrTxnConditionsFailed) {
+			record(txn.Failure)
+			return false, nil
+		}
+		return false, err
+	}
+	record(txn.Success)
+	return true, nil
+}
+
+// GetVersion returns the version of a key as seen by the local replica.
+func (rs *RaftStorage) GetVersion(ctx context.Context, key []byte) (uint64, error) {
+	if !rs.raft.started.Load() {
+		return 0, errors.ErrClosed
+	}
+	if !types.IsValidPathID(string(key)) {
+		return 0, errors.ErrInvalidKey
+	}
+	st, ok := rs.storage.(storage.TxnStorage)
+	if !ok {
+		return 0, errors.ErrTxnNotSupported
+	}
+	return st.GetVersion(ctx, key)
+}
+
+// auditChanges reads the current values of the keys written by the given
+// operations when the context records changes for the audit log. The returned
+// function records the operations that were applied.
+func (rs *RaftStorage) auditChanges(ctx context.Context, ops []storage.Op) func(applied []storage.Op) {
+	rec, ok := audit.RecorderFrom(ctx)
+	if !ok {
+		return func([]storage.Op) {}
+	}
+	before := make(map[string][]byte, len(ops))
+	for _, op := range ops {
+		if value, err := rs.storage.GetValue(ctx, op.Key); err == nil {
+			before[string(op.Key)] = value
+		}
+	}
+	return func(applied []storage.Op) {
+		for _, op := range applied {
+			change := types.AuditChange{Key: string(op.Key), Before: string(before[string(op.Key)])}
+			if op.Type == storage.OpDelete {
+				change.Deleted = true
+			} else {
+				change.After = string(op.Value)
+			}
+			rec.Record(change)
+		}
 	}
-	// We need to forward the request to the leader.
-	return rs.sendLogToLeader(ctx, &logEntry)
 }
 
 func (rs *RaftStorage) sendLogToLeader(ctx context.Context, logEntry *v1.RaftLogEntry) error {
@@ -142,22 +266,25 @@ func (rs *RaftStorage) sendLogToLeader(ctx context.Context, logEntry *v1.RaftLog
 	}
 	defer c.Close()
 	cli := v1.NewMembershipClient(c)
-	resp, err := cli.Apply(ctx, logEntry)
+	var header metadata.MD
+	resp, err := cli.Apply(ctx, logEntry, grpc.Header(&header))
 	if err != nil {
 		return fmt.Errorf("apply log entry: %w", err)
 	}
 	log.Debug("applied log entry", slog.String("time", resp.GetTime()))
-	if resp.GetError() != "" {
-		return fmt.Errorf("apply log entry: %s", resp.GetError())
+	// Wait for the entry locally so reads served here see our own writes.
+	if index := storage.AppliedIndexFromHeader(header); index > 0 {
+		if err := rs.raft.waitForIndex(ctx, index); err != nil {
+			log.Warn("Log entry not yet applied locally", slog.String("error", err.Error()))
+		}
 	}
-	return nil
+	return responseError(resp)
 }
 
 func (rs *RaftStorage) applyLog(ctx context.Context, logEntry *v1.RaftLogEntry) error {
-	rs.writecount.Add(1)
-	if rs.writecount.Load() >= rs.raft.Options.BarrierThreshold {
+	// Only the writer that crosses the threshold issues the barrier.
+	if count := rs.writecount.Add(1); count >= rs.raft.Options.BarrierThreshold && rs.writecount.CompareAndSwap(count, 0) {
 		defer func() {
-			rs.writecount.Store(0)
 			if err := rs.raft.raft.Barrier(rs.raft.Options.ApplyTimeout).Error(); err != nil {
 				rs.raft.log.Warn("Error issuing barrier", "error", err.Error())
 			}
@@ -170,8 +297,19 @@ func (rs *RaftStorage) applyLog(ctx context.Context, logEntry *v1.RaftLogEntry)
 		}
 		return fmt.Errorf("apply log entry: %w", err)
 	}
-	if res.GetError() != "" {
+	return responseError(res)
+}
+
+func responseError(res *v1.RaftApplyResponse) error {
+	switch res.GetError() {
+	case "":
+		return nil
+	case errors.ErrTxnConditionsFailed.Error():
+		return errors.ErrTxnConditionsFailed
+	case errors.ErrTxnAlreadyApplied.Error():
+		// Replays are not failures.
+		return nil
+	default:
 		return fmt.Errorf("apply log entry data: %s", res.GetError())
 	}
-	return nil
 }
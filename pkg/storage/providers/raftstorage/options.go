@@ -26,6 +26,7 @@ import (
 	"github.com/webmeshproj/webmesh/pkg/context"
 	"github.com/webmeshproj/webmesh/pkg/logging"
 	"github.com/webmeshproj/webmesh/pkg/meshnet/transport"
+	"github.com/webmeshproj/webmesh/pkg/storage/encryption"
 	"github.com/webmeshproj/webmesh/pkg/storage/types"
 )
 
@@ -45,6 +46,9 @@ const (
 	// DefaultBarrierThreshold is the threshold for sending a barrier after
 	// a write operation.
 	DefaultBarrierThreshold = 10
+	// DefaultWatchHistory is the default number of changes kept in memory
+	// for resuming watches.
+	DefaultWatchHistory = 10000
 )
 
 // Options are the raft options.
@@ -86,6 +90,12 @@ type Options struct {
 	ObserverChanBuffer int
 	// BarrierThreshold is the threshold for sending a barrier after a write operation.
 	BarrierThreshold int32
+	// WatchHistory is the number of changes kept in memory for resuming watches.
+	// Watches resumed from revisions older than the history are compacted.
+	WatchHistory int
+	// Encryption are the keys used to encrypt the raft log, mesh state and
+	// snapshots on disk. It is ignored for in-memory storage.
+	Encryption encryption.Keys
 	// LogLevel is the log level for the raft backend.
 	LogLevel string
 	// LogFormat is the log format for the raft backend.
@@ -110,6 +120,7 @@ func NewOptions(nodeID types.NodeID, transport transport.RaftTransport) Options
 		SnapshotRetention:  3,
 		ObserverChanBuffer: 100,
 		BarrierThreshold:   DefaultBarrierThreshold,
+		WatchHistory:       DefaultWatchHistory,
 		LogLevel:           "info",
 	}
 }
@@ -119,6 +130,9 @@ func (o *Options) RaftConfig(ctx context.Context, nodeID string) *raft.Config {
 	config := raft.DefaultConfig()
 	config.LocalID = raft.ServerID(nodeID)
 	config.ShutdownOnRemove = true
+	// Let raft drain up to MaxAppendEntries pending applies at once, writing
+	// them to the log in one call and handing them to the FSM's ApplyBatch.
+	config.BatchApplyCh = true
 	if o.HeartbeatTimeout != 0 {
 		config.HeartbeatTimeout = o.HeartbeatTimeout
 	}
@@ -38,6 +38,7 @@ import (
 	"github.com/webmeshproj/webmesh/pkg/storage/meshdb"
 	"github.com/webmeshproj/webmesh/pkg/storage/providers/backends/badgerdb"
 	"github.com/webmeshproj/webmesh/pkg/storage/providers/raftstorage/fsm"
+	"github.com/webmeshproj/webmesh/pkg/storage/providers/raftstorage/snapshots"
 )
 
 // Ensure we satisfy the provider interface.
@@ -111,7 +112,7 @@ func NewProvider(opts Options) *Provider {
 		log:     logging.NewLogger(opts.LogLevel, opts.LogFormat).With("component", "raftstorage"),
 	}
 	p.consensus = &Consensus{Provider: p}
-	p.raftStorage = &RaftStorage{raft: p}
+	p.raftStorage = &RaftStorage{raft: p, history: newWatchHistory(opts.WatchHistory)}
 	p.meshDB = meshdb.NewFromStorage(p.raftStorage)
 	return p
 }
@@ -166,6 +167,8 @@ func (r *Provider) Start(ctx context.Context) error {
 		r.Options.RaftConfig(ctx, string(r.nodeID)),
 		fsm.New(ctx, storage, fsm.Options{
 			ApplyTimeout: r.Options.ApplyTimeout,
+			OnApply:      r.raftStorage.history.onApply,
+			OnRestore:    r.raftStorage.history.onRestore,
 		}),
 		&MonotonicLogStore{storage},
 		storage,
@@ -342,10 +345,23 @@ func (r *Provider) GetRaftConfiguration() raft.Configuration {
 	return r.raft.GetConfiguration().Configuration()
 }
 
+// AppliedIndex returns the last index applied to the FSM.
+func (r *Provider) AppliedIndex() uint64 {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if !r.started.Load() {
+		return 0
+	}
+	return r.raft.AppliedIndex()
+}
+
 // ApplyRaftLog applies a raft log entry.
 func (r *Provider) ApplyRaftLog(ctx context.Context, log *v1.RaftLogEntry) (*v1.RaftApplyResponse, error) {
-	r.mu.Lock()
-	defer r.mu.Unlock()
+	// Applies only need to exclude Close and membership changes. Holding
+	// a read lock lets concurrent callers reach raft together, where they
+	// are coalesced into a single batch and committed as a group.
+	r.mu.RLock()
+	defer r.mu.RUnlock()
 	if !r.started.Load() {
 		return nil, errors.ErrClosed
 	}
@@ -426,6 +442,7 @@ func (r *Provider) createStorage() (storage.DualStorage, error) {
 	db, err := badgerdb.New(badgerdb.Options{
 		DiskPath:   dataDir,
 		SyncWrites: true,
+		Encryption: r.Options.Encryption,
 		Debug: func() bool {
 			return strings.ToLower(r.Options.LogLevel) == "debug"
 		}(),
@@ -436,16 +453,43 @@ func (r *Provider) createStorage() (storage.DualStorage, error) {
 	return db, nil
 }
 
+// RotateEncryption re-encrypts the data directory and snapshots described
+// by opts with the current key. It is a no-op unless a previous key is set.
+// Callers use it to finish a rotation before discarding the previous key.
+func RotateEncryption(opts Options) error {
+	if opts.InMemory || !opts.Encryption.Rotating() {
+		return nil
+	}
+	dataDir := filepath.Join(opts.DataDir, opts.NodeID.String(), "data")
+	if _, err := os.Stat(dataDir); err == nil {
+		if err := badgerdb.RotateKey(dataDir, opts.Encryption); err != nil {
+			return fmt.Errorf("rotate data encryption key: %w", err)
+		}
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("stat data directory: %w", err)
+	}
+	log := logging.NewLogger(opts.LogLevel, opts.LogFormat).With("component", "snapshotstore")
+	logger := logging.NewHCLogAdapter("", opts.LogLevel, log)
+	if _, err := snapshots.NewEncryptedFileStore(opts.DataDir, int(opts.SnapshotRetention), logger, opts.Encryption); err != nil {
+		return fmt.Errorf("rotate snapshot encryption key: %w", err)
+	}
+	return nil
+}
+
 // createSnapshotStorage creates the snapshot storage.
 func (r *Provider) createSnapshotStorage() (raft.SnapshotStore, error) {
 	if r.Options.InMemory {
 		return raft.NewInmemSnapshotStore(), nil
 	}
-	snapshotStore, err := raft.NewFileSnapshotStoreWithLogger(
-		r.Options.DataDir,
-		int(r.Options.SnapshotRetention),
-		logging.NewHCLogAdapter("", r.Options.LogLevel, r.log.With("component", "snapshotstore")),
-	)
+	logger := logging.NewHCLogAdapter("", r.Options.LogLevel, r.log.With("component", "snapshotstore"))
+	if r.Options.Encryption.Enabled() || r.Options.Encryption.Rotating() {
+		snapshotStore, err := snapshots.NewEncryptedFileStore(r.Options.DataDir, int(r.Options.SnapshotRetention), logger, r.Options.Encryption)
+		if err != nil {
+			return nil, fmt.Errorf("new encrypted snapshot store: %w", err)
+		}
+		return snapshotStore, nil
+	}
+	snapshotStore, err := raft.NewFileSnapshotStoreWithLogger(r.Options.DataDir, int(r.Options.SnapshotRetention), logger)
 	if err != nil {
 		return nil, fmt.Errorf("new file snapshot store: %w", err)
 	}
@@ -0,0 +1,133 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package raftstorage
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/crypto"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/transport/tcp"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// BenchmarkJoins measures how many joins per second a single raft leader
+// can commit. A join is modeled as the node and edge writes the membership
+// server issues for a new peer.
+func BenchmarkJoins(b *testing.B) {
+	for _, inMemory := range []bool{true, false} {
+		name := "OnDisk"
+		if inMemory {
+			name = "InMemory"
+		}
+		b.Run(name, func(b *testing.B) {
+			b.Run("Serial", func(b *testing.B) { benchmarkJoins(b, inMemory, false) })
+			b.Run("Concurrent", func(b *testing.B) { benchmarkJoins(b, inMemory, true) })
+		})
+	}
+}
+
+func benchmarkJoins(b *testing.B, inMemory, concurrent bool) {
+	ctx := context.Background()
+	transport, err := tcp.NewRaftTransport(nil, tcp.RaftTransportOptions{
+		Addr:    "[::]:0",
+		MaxPool: 10,
+		Timeout: time.Second,
+	})
+	if err != nil {
+		b.Fatalf("failed to create raft transport: %v", err)
+	}
+	opts := newTestOptions(transport)
+	opts.InMemory = inMemory
+	if !inMemory {
+		opts.DataDir = b.TempDir()
+	}
+	opts.BarrierThreshold = 0
+	opts.MaxAppendEntries = 64
+	opts.SnapshotThreshold = 8192
+	p := NewProvider(opts)
+	if err := p.Start(ctx); err != nil {
+		b.Fatalf("start: %v", err)
+	}
+	b.Cleanup(func() { _ = p.Close() })
+	if err := p.Bootstrap(ctx); err != nil {
+		b.Fatalf("bootstrap: %v", err)
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for !p.Consensus().IsLeader() {
+		if time.Now().After(deadline) {
+			b.Fatal("timed out waiting for leadership")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		b.Fatal(err)
+	}
+	pubkey, err := key.PublicKey().Encode()
+	if err != nil {
+		b.Fatal(err)
+	}
+	st := p.MeshStorage()
+	var seq atomic.Int64
+	join := func() error {
+		// Write the node and its edge to the bootstrap node directly so we
+		// measure the raft write path and not the graph store's validation.
+		id := types.NodeID(fmt.Sprintf("node-%d", seq.Add(1)))
+		// Keys are unique per node in practice, but key generation is not what
+		// we are measuring here.
+		node, err := types.MeshNode{MeshNode: &v1.MeshNode{Id: id.String(), PublicKey: pubkey}}.MarshalProtoJSON()
+		if err != nil {
+			return err
+		}
+		err = st.PutValue(ctx, storage.NodesPrefix.For(id.Bytes()), node, 0)
+		if err != nil {
+			return err
+		}
+		edge, err := types.MeshEdge{MeshEdge: &v1.MeshEdge{Source: "bootstrap", Target: id.String(), Weight: 1}}.MarshalProtoJSON()
+		if err != nil {
+			return err
+		}
+		return st.PutValue(ctx, storage.EdgesPrefix.For([]byte("bootstrap")).For(id.Bytes()), edge, 0)
+	}
+	b.ResetTimer()
+	start := time.Now()
+	if !concurrent {
+		for i := 0; i < b.N; i++ {
+			if err := join(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	} else {
+		b.SetParallelism(16)
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				if err := join(); err != nil {
+					b.Error(err)
+					return
+				}
+			}
+		})
+	}
+	b.ReportMetric(float64(b.N)/time.Since(start).Seconds(), "joins/s")
+}
@@ -28,14 +28,35 @@ import (
 
 	"github.com/webmeshproj/webmesh/pkg/context"
 	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
 )
 
-// Apply applies a raft log to the given storage.
-func Apply(ctx context.Context, db storage.MeshStorage, logEntry *v1.RaftLogEntry) *v1.RaftApplyResponse {
+// CommandTxn is the command type for transactions. The transaction is carried
+// in the value of the log entry as encoded by storage.MarshalTxn. It is outside
+// of the range of the API enum so it never collides with future command types.
+const CommandTxn v1.RaftCommandType = 1 << 16
+
+// Apply applies a raft log at the given index to the given storage. Writes to keys
+// outside of the reserved prefixes are applied as transactions when the storage
+// supports them, so that their versions are tracked.
+func Apply(ctx context.Context, db storage.MeshStorage, index uint64, logEntry *v1.RaftLogEntry) *v1.RaftApplyResponse {
 	start := time.Now()
 	log := context.LoggerFrom(ctx)
+	txdb, canTxn := db.(storage.TxnStorage)
 	switch logEntry.GetType() {
 	case v1.RaftCommandType_PUT:
+		if canTxn && !types.IsReservedPrefix(logEntry.GetKey()) {
+			return applyTxn(ctx, txdb, start, &storage.Txn{
+				Success: []storage.Op{{
+					Type:  storage.OpPut,
+					Key:   logEntry.GetKey(),
+					Value: logEntry.GetValue(),
+					TTL:   logEntry.Ttl.AsDuration(),
+				}},
+				Revision: index,
+			})
+		}
 		log.Debug("Applying put",
 			slog.String("key", string(logEntry.GetKey())),
 			slog.String("value", string(logEntry.GetValue())),
@@ -48,6 +69,15 @@ func Apply(ctx context.Context, db storage.MeshStorage, logEntry *v1.RaftLogEntr
 		res.Time = time.Since(start).String()
 		return res
 	case v1.RaftCommandType_DELETE:
+		if canTxn && !types.IsReservedPrefix(logEntry.GetKey()) {
+			return applyTxn(ctx, txdb, start, &storage.Txn{
+				Success: []storage.Op{{
+					Type: storage.OpDelete,
+					Key:  logEntry.GetKey(),
+				}},
+				Revision: index,
+			})
+		}
 		log.Debug("Applying delete",
 			slog.String("key", string(logEntry.GetKey())),
 		)
@@ -58,9 +88,45 @@ func Apply(ctx context.Context, db storage.MeshStorage, logEntry *v1.RaftLogEntr
 		}
 		res.Time = time.Since(start).String()
 		return res
+	case CommandTxn:
+		if !canTxn {
+			return &v1.RaftApplyResponse{
+				Error: errors.ErrTxnNotSupported.Error(),
+				Time:  time.Since(start).String(),
+			}
+		}
+		txn, err := storage.UnmarshalTxn(logEntry.GetValue())
+		if err != nil {
+			return &v1.RaftApplyResponse{
+				Error: err.Error(),
+				Time:  time.Since(start).String(),
+			}
+		}
+		txn.Revision = index
+		log.Debug("Applying transaction",
+			slog.Int("compare", len(txn.Compare)),
+			slog.Int("success", len(txn.Success)),
+			slog.Int("failure", len(txn.Failure)),
+		)
+		return applyTxn(ctx, txdb, start, txn)
 	default:
 		return &v1.RaftApplyResponse{
 			Error: fmt.Sprintf("unknown command type: %v", logEntry.GetType()),
 		}
 	}
 }
+
+// applyTxn applies a transaction. A transaction whose conditions did not hold
+// is reported with errors.ErrTxnConditionsFailed. A transaction that was already
+// applied is reported with errors.ErrTxnAlreadyApplied.
+func applyTxn(ctx context.Context, db storage.TxnStorage, start time.Time, txn *storage.Txn) *v1.RaftApplyResponse {
+	succeeded, err := db.Txn(ctx, txn)
+	res := &v1.RaftApplyResponse{}
+	if err != nil {
+		res.Error = err.Error()
+	} else if !succeeded {
+		res.Error = errors.ErrTxnConditionsFailed.Error()
+	}
+	res.Time = time.Since(start).String()
+	return res
+}
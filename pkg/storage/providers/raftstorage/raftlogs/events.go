@@ -0,0 +1,80 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package raftlogs
+
+import (
+	v1 "github.com/webmeshproj/api/go/v1"
+
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+)
+
+// Events returns the watch events produced by applying a raft log at the given
+// index with the given response. The index is the revision of the events. It
+// returns false if the outcome of the log cannot be known, which is the case for
+// transactions that were already applied before a restart.
+func Events(index uint64, logEntry *v1.RaftLogEntry, res *v1.RaftApplyResponse) ([]storage.WatchEvent, bool) {
+	switch res.GetError() {
+	case "", errors.ErrTxnConditionsFailed.Error():
+	case errors.ErrTxnAlreadyApplied.Error():
+		return nil, false
+	default:
+		// Nothing was written.
+		return nil, true
+	}
+	switch logEntry.GetType() {
+	case v1.RaftCommandType_PUT:
+		return []storage.WatchEvent{{
+			Type:     storage.EventPut,
+			Key:      logEntry.GetKey(),
+			Value:    logEntry.GetValue(),
+			Revision: index,
+		}}, true
+	case v1.RaftCommandType_DELETE:
+		return []storage.WatchEvent{{
+			Type:     storage.EventDelete,
+			Key:      logEntry.GetKey(),
+			Revision: index,
+		}}, true
+	case CommandTxn:
+		txn, err := storage.UnmarshalTxn(logEntry.GetValue())
+		if err != nil {
+			return nil, true
+		}
+		ops := txn.Success
+		if res.GetError() != "" {
+			ops = txn.Failure
+		}
+		events := make([]storage.WatchEvent, 0, len(ops))
+		for _, op := range ops {
+			ev := storage.WatchEvent{
+				Type:     storage.EventPut,
+				Key:      op.Key,
+				Value:    op.Value,
+				Revision: index,
+			}
+			if op.Type == storage.OpDelete {
+				ev.Type = storage.EventDelete
+				ev.Value = nil
+			}
+			events = append(events, ev)
+		}
+		return events, true
+	default:
+		return nil, true
+	}
+}
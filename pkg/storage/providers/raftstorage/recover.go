@@ -0,0 +1,245 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package raftstorage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/hashicorp/raft"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/logging"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/providers/backends/badgerdb"
+	"github.com/webmeshproj/webmesh/pkg/storage/providers/raftstorage/fsm"
+	"github.com/webmeshproj/webmesh/pkg/storage/providers/raftstorage/snapshots"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// keyCurrentTerm is the key raft stores the current term under.
+var keyCurrentTerm = []byte("CurrentTerm")
+
+// RaftState is the raft state found in the data directory of a stopped node.
+type RaftState struct {
+	// NodeID is the ID of the node the state belongs to.
+	NodeID types.NodeID `json:"nodeID"`
+	// CurrentTerm is the last term the node knew of.
+	CurrentTerm uint64 `json:"currentTerm"`
+	// FirstIndex is the index of the first entry in the log.
+	FirstIndex uint64 `json:"firstIndex"`
+	// LastIndex is the index of the last entry in the log.
+	LastIndex uint64 `json:"lastIndex"`
+	// LastTerm is the term of the last entry in the log.
+	LastTerm uint64 `json:"lastTerm"`
+	// Snapshots are the snapshots on disk, newest first.
+	Snapshots []*raft.SnapshotMeta `json:"snapshots"`
+	// Configuration is the latest membership found in the log or snapshots.
+	Configuration raft.Configuration `json:"configuration"`
+	// ConfigurationIndex is the index the configuration was written at.
+	ConfigurationIndex uint64 `json:"configurationIndex"`
+}
+
+// FindNodeID returns the ID of the only node with state in the data directory.
+func FindNodeID(dataDir string) (types.NodeID, error) {
+	entries, err := os.ReadDir(dataDir)
+	if err != nil {
+		return "", fmt.Errorf("read data directory: %w", err)
+	}
+	var found []types.NodeID
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(dataDir, entry.Name(), "data")); err == nil {
+			found = append(found, types.NodeID(entry.Name()))
+		}
+	}
+	switch len(found) {
+	case 0:
+		return "", fmt.Errorf("no raft state found in %s", dataDir)
+	case 1:
+		return found[0], nil
+	default:
+		return "", fmt.Errorf("found state for multiple nodes in %s, a node ID is required: %v", dataDir, found)
+	}
+}
+
+// Inspect reads the raft state in the data directory of a stopped node.
+// The node ID, data directory and encryption keys of the options are used.
+func Inspect(opts Options) (*RaftState, error) {
+	p, st, snaps, err := openOffline(opts, true)
+	if err != nil {
+		return nil, err
+	}
+	defer st.Close()
+	return p.inspect(st, snaps)
+}
+
+// Recover replaces the raft configuration in the data directory of a stopped
+// node with the given voters. If no voters are given, the node restarts as the
+// only voter of a new cluster with the state it has. Voters without an address
+// keep the one they had in the last configuration. Every surviving voter must
+// be recovered with the same configuration before any of them is restarted.
+func Recover(ctx context.Context, opts Options, voters []raft.Server) (*RaftState, error) {
+	p, st, snaps, err := openOffline(opts, false)
+	if err != nil {
+		return nil, err
+	}
+	defer st.Close()
+	state, err := p.inspect(st, snaps)
+	if err != nil {
+		return nil, err
+	}
+	if len(voters) == 0 {
+		voters = []raft.Server{{ID: p.nodeID}}
+	}
+	config := raft.Configuration{}
+	for _, voter := range voters {
+		voter.Suffrage = raft.Voter
+		if voter.Address == "" {
+			for _, server := range state.Configuration.Servers {
+				if server.ID == voter.ID {
+					voter.Address = server.Address
+				}
+			}
+		}
+		if voter.Address == "" {
+			return nil, fmt.Errorf("no address known for voter %s, set one with id=address", voter.ID)
+		}
+		config.Servers = append(config.Servers, voter)
+	}
+	// Recovery restores the latest snapshot into the FSM and replays the log
+	// on top of it to take a new snapshot. Restoring drops everything in the
+	// database it is restored into, which here also holds the log and stable
+	// store, so the FSM is built on scratch storage instead.
+	scratch, err := badgerdb.NewInMemory(badgerdb.Options{})
+	if err != nil {
+		return nil, fmt.Errorf("create scratch storage: %w", err)
+	}
+	defer scratch.Close()
+	_, trans := raft.NewInmemTransport(raft.ServerAddress(p.nodeID))
+	err = raft.RecoverCluster(
+		p.Options.RaftConfig(ctx, string(p.nodeID)),
+		fsm.New(ctx, scratch, fsm.Options{ApplyTimeout: p.Options.ApplyTimeout}),
+		&MonotonicLogStore{st},
+		st,
+		snaps,
+		trans,
+		config,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("recover cluster: %w", err)
+	}
+	return p.inspect(st, snaps)
+}
+
+// openOffline opens the storage of a stopped node. Read-only storage is
+// left encrypted with whatever key it was written with.
+func openOffline(opts Options, readOnly bool) (*Provider, storage.DualStorage, raft.SnapshotStore, error) {
+	if opts.InMemory {
+		return nil, nil, nil, fmt.Errorf("cannot recover in-memory storage")
+	}
+	opts.ClearDataDir = false
+	if opts.NodeID == "" {
+		id, err := FindNodeID(opts.DataDir)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		opts.NodeID = id
+	}
+	dataDir := filepath.Join(opts.DataDir, opts.NodeID.String(), "data")
+	if _, err := os.Stat(dataDir); err != nil {
+		return nil, nil, nil, fmt.Errorf("no raft state for node %s: %w", opts.NodeID, err)
+	}
+	p := NewProvider(opts)
+	if !readOnly {
+		st, err := p.createStorage()
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("open storage: %w", err)
+		}
+		snaps, err := p.createSnapshotStorage()
+		if err != nil {
+			st.Close()
+			return nil, nil, nil, fmt.Errorf("open snapshot storage: %w", err)
+		}
+		return p, st, snaps, nil
+	}
+	st, err := badgerdb.New(badgerdb.Options{
+		DiskPath:   dataDir,
+		Encryption: opts.Encryption,
+		ReadOnly:   true,
+	})
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("open storage: %w", err)
+	}
+	logger := logging.NewHCLogAdapter("", opts.LogLevel, p.log.With("component", "snapshotstore"))
+	snaps, err := snapshots.OpenEncryptedFileStore(opts.DataDir, int(opts.SnapshotRetention), logger, opts.Encryption)
+	if err != nil {
+		st.Close()
+		return nil, nil, nil, fmt.Errorf("open snapshot storage: %w", err)
+	}
+	return p, st, snaps, nil
+}
+
+func (r *Provider) inspect(st storage.DualStorage, snaps raft.SnapshotStore) (*RaftState, error) {
+	state := &RaftState{NodeID: r.Options.NodeID}
+	var err error
+	state.CurrentTerm, err = st.GetUint64(keyCurrentTerm)
+	if err != nil {
+		return nil, fmt.Errorf("read current term: %w", err)
+	}
+	state.Snapshots, err = snaps.List()
+	if err != nil {
+		return nil, fmt.Errorf("list snapshots: %w", err)
+	}
+	if len(state.Snapshots) > 0 {
+		latest := state.Snapshots[0]
+		state.Configuration = latest.Configuration
+		state.ConfigurationIndex = latest.ConfigurationIndex
+		state.LastIndex, state.LastTerm = latest.Index, latest.Term
+	}
+	if state.FirstIndex, err = st.FirstIndex(); err != nil {
+		return nil, fmt.Errorf("read first index: %w", err)
+	}
+	lastIndex, err := st.LastIndex()
+	if err != nil {
+		return nil, fmt.Errorf("read last index: %w", err)
+	}
+	if state.FirstIndex == 0 || lastIndex == 0 {
+		return state, nil
+	}
+	for index := max(state.FirstIndex, state.ConfigurationIndex+1); index <= lastIndex; index++ {
+		var log raft.Log
+		if err := st.GetLog(index, &log); err != nil {
+			return nil, fmt.Errorf("read log %d: %w", index, err)
+		}
+		if log.Type == raft.LogConfiguration {
+			state.Configuration = raft.DecodeConfiguration(log.Data)
+			state.ConfigurationIndex = log.Index
+		}
+	}
+	var last raft.Log
+	if err := st.GetLog(lastIndex, &last); err != nil {
+		return nil, fmt.Errorf("read log %d: %w", lastIndex, err)
+	}
+	if last.Index >= state.LastIndex {
+		state.LastIndex, state.LastTerm = last.Index, last.Term
+	}
+	return state, nil
+}
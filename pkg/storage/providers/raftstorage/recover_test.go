@@ -0,0 +1,212 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package raftstorage
+
+import (
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/transport/tcp"
+	"github.com/webmeshproj/webmesh/pkg/storage/encryption"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+func TestRecover(t *testing.T) {
+	ctx := context.Background()
+	dataDir := t.TempDir()
+	key, value := types.RegistryPrefix.ForString("recovered"), []byte("value")
+	encryptionKey := make([]byte, 32)
+	if _, err := rand.Read(encryptionKey); err != nil {
+		t.Fatal(err)
+	}
+
+	start := func(bootstrap bool) *Provider {
+		t.Helper()
+		return startRecoveryTestNode(t, dataDir, encryptionKey, bootstrap)
+	}
+
+	p := start(true)
+	if err := p.MeshStorage().PutValue(ctx, key, value, 0); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+	if err := p.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	// Inspecting with a new key leaves storage encrypted with the old one.
+	newKey := make([]byte, 32)
+	if _, err := rand.Read(newKey); err != nil {
+		t.Fatal(err)
+	}
+	_, err := Inspect(Options{DataDir: dataDir, SnapshotRetention: 3, Encryption: encryption.Keys{Current: newKey, Previous: encryptionKey}})
+	if err != nil {
+		t.Fatalf("inspect with a new key: %v", err)
+	}
+
+	// The node ID is found from the data directory.
+	opts := Options{DataDir: dataDir, SnapshotRetention: 3, Encryption: encryption.Keys{Current: encryptionKey}}
+	state, err := Inspect(opts)
+	if err != nil {
+		t.Fatalf("inspect: %v", err)
+	}
+	if state.NodeID != "recovered-node" || state.LastIndex == 0 || state.LastTerm == 0 {
+		t.Fatalf("unexpected state: %+v", state)
+	}
+	if len(state.Configuration.Servers) != 1 || state.Configuration.Servers[0].ID != "recovered-node" {
+		t.Fatalf("unexpected configuration: %+v", state.Configuration)
+	}
+	address := state.Configuration.Servers[0].Address
+
+	// Voters need an address.
+	_, err = Recover(ctx, opts, []raft.Server{{ID: "recovered-node"}, {ID: "lost-node"}})
+	if err == nil {
+		t.Fatal("expected an error for a voter without an address")
+	}
+
+	// Rewrite the configuration with a second voter.
+	state, err = Recover(ctx, opts, []raft.Server{{ID: "recovered-node"}, {ID: "new-node", Address: "127.0.0.1:1"}})
+	if err != nil {
+		t.Fatalf("recover: %v", err)
+	}
+	servers := state.Configuration.Servers
+	if len(servers) != 2 || servers[0].Address != address || servers[1].ID != "new-node" || servers[1].Suffrage != raft.Voter {
+		t.Fatalf("unexpected configuration: %+v", state.Configuration)
+	}
+
+	// Recover as a single node cluster and make sure the data survived.
+	state, err = Recover(ctx, opts, nil)
+	if err != nil {
+		t.Fatalf("recover: %v", err)
+	}
+	if len(state.Configuration.Servers) != 1 {
+		t.Fatalf("unexpected configuration: %+v", state.Configuration)
+	}
+	p = start(false)
+	defer p.Close()
+	got, err := p.MeshStorage().GetValue(ctx, key)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if string(got) != string(value) {
+		t.Fatalf("expected %q, got %q", value, got)
+	}
+}
+
+func TestRecoverWithTrailingLogs(t *testing.T) {
+	ctx := context.Background()
+	dataDir := t.TempDir()
+	encryptionKey := make([]byte, 32)
+	if _, err := rand.Read(encryptionKey); err != nil {
+		t.Fatal(err)
+	}
+	values := map[string]string{"before-snapshot": "a", "after-snapshot": "b", "last": "c"}
+
+	p := startRecoveryTestNode(t, dataDir, encryptionKey, true)
+	put := func(name string) {
+		t.Helper()
+		if err := p.MeshStorage().PutValue(ctx, types.RegistryPrefix.ForString(name), []byte(values[name]), 0); err != nil {
+			t.Fatalf("put: %v", err)
+		}
+	}
+	put("before-snapshot")
+	if err := p.raft.Snapshot().Error(); err != nil {
+		t.Fatalf("snapshot: %v", err)
+	}
+	put("after-snapshot")
+	put("last")
+	lastIndex := p.raft.LastIndex()
+	// Stop without the snapshot Close takes, as if the node crashed.
+	if err := p.raft.Shutdown().Error(); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+	p.raftStorage.Close()
+	p.Options.Transport.Close()
+
+	// Inspecting the reopened store reads the log past the snapshot.
+	opts := Options{DataDir: dataDir, SnapshotRetention: 3, Encryption: encryption.Keys{Current: encryptionKey}}
+	state, err := Inspect(opts)
+	if err != nil {
+		t.Fatalf("inspect: %v", err)
+	}
+	if len(state.Snapshots) == 0 {
+		t.Fatal("expected a snapshot")
+	}
+	if state.FirstIndex == 0 || state.FirstIndex > state.Snapshots[0].Index+1 {
+		t.Fatalf("expected the log to start at or before the snapshot, got first index %d", state.FirstIndex)
+	}
+	if state.LastIndex != lastIndex || state.LastIndex <= state.Snapshots[0].Index {
+		t.Fatalf("expected last index %d past the snapshot, got %d", lastIndex, state.LastIndex)
+	}
+
+	state, err = Recover(ctx, opts, nil)
+	if err != nil {
+		t.Fatalf("recover: %v", err)
+	}
+	if state.LastIndex != lastIndex {
+		t.Fatalf("expected the recovered state to end at %d, got %d", lastIndex, state.LastIndex)
+	}
+	p = startRecoveryTestNode(t, dataDir, encryptionKey, false)
+	defer p.Close()
+	for name, value := range values {
+		got, err := p.MeshStorage().GetValue(ctx, types.RegistryPrefix.ForString(name))
+		if err != nil {
+			t.Fatalf("get %s: %v", name, err)
+		}
+		if string(got) != value {
+			t.Fatalf("expected %q for %s, got %q", value, name, got)
+		}
+	}
+}
+
+func startRecoveryTestNode(t *testing.T, dataDir string, encryptionKey []byte, bootstrap bool) *Provider {
+	t.Helper()
+	ctx := context.Background()
+	transport, err := tcp.NewRaftTransport(nil, tcp.RaftTransportOptions{
+		Addr:    "[::]:0",
+		MaxPool: 10,
+		Timeout: time.Second,
+	})
+	if err != nil {
+		t.Fatalf("failed to create raft transport: %v", err)
+	}
+	opts := newTestOptions(transport)
+	opts.NodeID = "recovered-node"
+	opts.InMemory = false
+	opts.DataDir = dataDir
+	opts.Encryption = encryption.Keys{Current: encryptionKey}
+	p := NewProvider(opts)
+	if err := p.Start(ctx); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	if bootstrap {
+		if err := p.Bootstrap(ctx); err != nil {
+			t.Fatalf("bootstrap: %v", err)
+		}
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for !p.Consensus().IsLeader() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for leadership")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return p
+}
@@ -0,0 +1,252 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package snapshots
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/raft"
+
+	"github.com/webmeshproj/webmesh/pkg/storage/encryption"
+)
+
+// NewEncryptedFileStore returns a file snapshot store in dir that encrypts
+// snapshots with the current key. Existing snapshots written in plaintext or
+// with the previous key are re-encrypted before the store is returned.
+//
+// Snapshots are only encrypted at rest. Open returns the plaintext, so
+// snapshots are sent to other nodes as they would be without encryption
+// and each node stores them under its own key.
+func NewEncryptedFileStore(dir string, retain int, logger hclog.Logger, keys encryption.Keys) (raft.SnapshotStore, error) {
+	if err := keys.Validate(); err != nil {
+		return nil, err
+	}
+	store, err := raft.NewFileSnapshotStoreWithLogger(dir, retain, logger)
+	if err != nil {
+		return nil, err
+	}
+	es := &encryptedStore{store: store, keys: keys}
+	if err := es.rekey(dir, logger); err != nil {
+		return nil, fmt.Errorf("re-encrypt snapshots: %w", err)
+	}
+	return es, nil
+}
+
+// OpenEncryptedFileStore returns a file snapshot store in dir for reading
+// snapshots of a stopped node. Unlike NewEncryptedFileStore it leaves
+// existing snapshots as they are, so it should not be used to write new ones.
+func OpenEncryptedFileStore(dir string, retain int, logger hclog.Logger, keys encryption.Keys) (raft.SnapshotStore, error) {
+	if err := keys.Validate(); err != nil {
+		return nil, err
+	}
+	store, err := raft.NewFileSnapshotStoreWithLogger(dir, retain, logger)
+	if err != nil {
+		return nil, err
+	}
+	return &encryptedStore{store: store, keys: keys}, nil
+}
+
+type encryptedStore struct {
+	store raft.SnapshotStore
+	keys  encryption.Keys
+}
+
+// Create creates a new snapshot that is encrypted as it is written.
+func (s *encryptedStore) Create(version raft.SnapshotVersion, index, term uint64, configuration raft.Configuration, configurationIndex uint64, trans raft.Transport) (raft.SnapshotSink, error) {
+	sink, err := s.store.Create(version, index, term, configuration, configurationIndex, trans)
+	if err != nil {
+		return nil, err
+	}
+	if !s.keys.Enabled() {
+		return sink, nil
+	}
+	w, err := encryption.NewWriter(sink, s.keys.Current)
+	if err != nil {
+		_ = sink.Cancel()
+		return nil, err
+	}
+	return &encryptedSink{SnapshotSink: sink, w: w}, nil
+}
+
+// List lists the available snapshots with their plaintext sizes.
+func (s *encryptedStore) List() ([]*raft.SnapshotMeta, error) {
+	metas, err := s.store.List()
+	if err != nil {
+		return nil, err
+	}
+	if s.keys.Enabled() {
+		for _, meta := range metas {
+			meta.Size = encryption.PlaintextSize(meta.Size)
+		}
+	}
+	return metas, nil
+}
+
+// Open opens and decrypts the given snapshot.
+func (s *encryptedStore) Open(id string) (*raft.SnapshotMeta, io.ReadCloser, error) {
+	meta, rc, err := s.store.Open(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !s.keys.Enabled() {
+		return meta, rc, nil
+	}
+	r, err := encryption.NewReader(rc, s.keys.Current)
+	if err != nil {
+		rc.Close()
+		return nil, nil, fmt.Errorf("open snapshot %s: %w", id, err)
+	}
+	meta.Size = encryption.PlaintextSize(meta.Size)
+	return meta, &readCloser{Reader: r, Closer: rc}, nil
+}
+
+// stagingDir is the directory under the store where re-encrypted copies of
+// snapshots are written before they replace the originals.
+const stagingDir = "rekey"
+
+// rekey re-encrypts any snapshot not already encrypted with the current
+// key. Each copy is written to a separate staging store, so that it cannot
+// take the name of the original, and moved into place once the original is
+// removed. Copies left in the staging store by an interrupted rekey are moved
+// into place first.
+func (s *encryptedStore) rekey(dir string, logger hclog.Logger) error {
+	snapshotsDir := filepath.Join(dir, "snapshots")
+	staged := filepath.Join(dir, stagingDir, "snapshots")
+	if err := moveStaged(staged, snapshotsDir); err != nil {
+		return err
+	}
+	metas, err := s.store.List()
+	if err != nil {
+		return err
+	}
+	current := encryption.KeyID(s.keys.Current)
+	var staging *encryptedStore
+	// Copy oldest first so retention reaps the originals in order.
+	slices.Reverse(metas)
+	for _, m := range metas {
+		meta, rc, err := s.store.Open(m.ID)
+		if err != nil {
+			return fmt.Errorf("open snapshot %s: %w", m.ID, err)
+		}
+		br := bufio.NewReader(rc)
+		id, encrypted, err := encryption.Inspect(br)
+		if err != nil || bytes.Equal(id, current) {
+			rc.Close()
+			if err != nil {
+				return fmt.Errorf("snapshot %s: %w", meta.ID, err)
+			}
+			continue
+		}
+		if staging == nil {
+			store, err := raft.NewFileSnapshotStoreWithLogger(filepath.Join(dir, stagingDir), len(metas), logger)
+			if err != nil {
+				rc.Close()
+				return fmt.Errorf("create staging store: %w", err)
+			}
+			staging = &encryptedStore{store: store, keys: s.keys}
+		}
+		err = staging.copySnapshot(meta, br, encrypted)
+		rc.Close()
+		if err != nil {
+			return fmt.Errorf("snapshot %s: %w", meta.ID, err)
+		}
+		err = os.RemoveAll(filepath.Join(snapshotsDir, meta.ID))
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("remove snapshot %s: %w", meta.ID, err)
+		}
+		if err := moveStaged(staged, snapshotsDir); err != nil {
+			return err
+		}
+	}
+	return os.RemoveAll(filepath.Join(dir, stagingDir))
+}
+
+// copySnapshot writes a copy of the snapshot encrypted with the current key.
+func (s *encryptedStore) copySnapshot(meta *raft.SnapshotMeta, r io.Reader, encrypted bool) error {
+	if encrypted {
+		var err error
+		r, err = encryption.NewReader(r, s.keys.Previous)
+		if err != nil {
+			return err
+		}
+	}
+	sink, err := s.Create(meta.Version, meta.Index, meta.Term, meta.Configuration, meta.ConfigurationIndex, nil)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(sink, r); err != nil {
+		_ = sink.Cancel()
+		return err
+	}
+	return sink.Close()
+}
+
+// moveStaged moves the complete snapshots in the staging directory into the
+// snapshots directory, replacing an original with the same name. Incomplete
+// snapshots are left for the staging store to clean up.
+func moveStaged(staged, snapshotsDir string) error {
+	entries, err := os.ReadDir(staged)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("read staged snapshots: %w", err)
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() || strings.HasSuffix(entry.Name(), ".tmp") {
+			continue
+		}
+		target := filepath.Join(snapshotsDir, entry.Name())
+		if err := os.RemoveAll(target); err != nil {
+			return fmt.Errorf("remove snapshot %s: %w", entry.Name(), err)
+		}
+		if err := os.Rename(filepath.Join(staged, entry.Name()), target); err != nil {
+			return fmt.Errorf("move staged snapshot %s: %w", entry.Name(), err)
+		}
+	}
+	return nil
+}
+
+type encryptedSink struct {
+	raft.SnapshotSink
+	w io.WriteCloser
+}
+
+func (s *encryptedSink) Write(p []byte) (int, error) {
+	return s.w.Write(p)
+}
+
+func (s *encryptedSink) Close() error {
+	if err := s.w.Close(); err != nil {
+		_ = s.SnapshotSink.Cancel()
+		return err
+	}
+	return s.SnapshotSink.Close()
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,197 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package snapshots
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/raft"
+
+	"github.com/webmeshproj/webmesh/pkg/storage/encryption"
+)
+
+func TestEncryptedFileStore(t *testing.T) {
+	dir := t.TempDir()
+	data := []byte("snapshot contents that should not be stored in plaintext")
+	first, second := newKey(t), newKey(t)
+
+	open := func(keys encryption.Keys) (raft.SnapshotStore, error) {
+		return NewEncryptedFileStore(dir, 2, hclog.NewNullLogger(), keys)
+	}
+	read := func(store raft.SnapshotStore) ([]byte, error) {
+		t.Helper()
+		metas, err := store.List()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(metas) != 1 {
+			t.Fatalf("expected 1 snapshot, got %d", len(metas))
+		}
+		if metas[0].Size != int64(len(data)) {
+			t.Fatalf("expected listed size %d, got %d", len(data), metas[0].Size)
+		}
+		meta, rc, err := store.Open(metas[0].ID)
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+		if meta.Index != 10 || meta.Term != 2 || meta.Size != int64(len(data)) {
+			t.Fatalf("unexpected snapshot meta: %+v", meta)
+		}
+		return io.ReadAll(rc)
+	}
+	onDisk := func() []byte {
+		t.Helper()
+		matches, err := filepath.Glob(filepath.Join(dir, "snapshots", "*", "state.bin"))
+		if err != nil || len(matches) != 1 {
+			t.Fatalf("expected 1 state file, got %v, %v", matches, err)
+		}
+		contents, err := os.ReadFile(matches[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return contents
+	}
+
+	// Write a plaintext snapshot.
+	store, err := open(encryption.Keys{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink, err := store.Create(1, 10, 2, raft.Configuration{}, 1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := sink.Write(data); err != nil || n != len(data) {
+		t.Fatalf("write: %d, %v", n, err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(onDisk(), data) {
+		t.Fatal("expected plaintext snapshot on disk")
+	}
+
+	// Enabling encryption re-encrypts it.
+	store, err = open(encryption.Keys{Current: first})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(onDisk(), data) {
+		t.Fatal("snapshot was not encrypted")
+	}
+	got, err := read(store)
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("read encrypted snapshot: %q, %v", got, err)
+	}
+
+	// Rotating re-encrypts it with the new key.
+	store, err = open(encryption.Keys{Current: second, Previous: first})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err = read(store)
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("read rotated snapshot: %q, %v", got, err)
+	}
+
+	// The old key no longer works.
+	if _, err := open(encryption.Keys{Current: first}); !errors.Is(err, encryption.ErrUnknownKey) {
+		t.Fatalf("expected ErrUnknownKey, got %v", err)
+	}
+}
+
+func TestEncryptedFileStoreResumesRekey(t *testing.T) {
+	dir := t.TempDir()
+	data := []byte("snapshot contents that should not be stored in plaintext")
+	key := newKey(t)
+	write := func(store raft.SnapshotStore, index uint64) {
+		t.Helper()
+		sink, err := store.Create(1, index, 2, raft.Configuration{}, 1, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := sink.Write(data); err != nil {
+			t.Fatal(err)
+		}
+		if err := sink.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A plaintext snapshot and a copy staged by an interrupted rekey
+	// whose original was already removed.
+	plain, err := NewEncryptedFileStore(dir, 2, hclog.NewNullLogger(), encryption.Keys{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	write(plain, 10)
+	staged, err := NewEncryptedFileStore(filepath.Join(dir, stagingDir), 2, hclog.NewNullLogger(), encryption.Keys{Current: key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	write(staged, 5)
+
+	store, err := NewEncryptedFileStore(dir, 2, hclog.NewNullLogger(), encryption.Keys{Current: key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, stagingDir)); !os.IsNotExist(err) {
+		t.Fatalf("expected the staging directory to be removed, got %v", err)
+	}
+	metas, err := store.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(metas) != 2 {
+		t.Fatalf("expected 2 snapshots, got %d", len(metas))
+	}
+	for _, meta := range metas {
+		_, rc, err := store.Open(meta.ID)
+		if err != nil {
+			t.Fatalf("open snapshot %s: %v", meta.ID, err)
+		}
+		got, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil || !bytes.Equal(got, data) {
+			t.Fatalf("read snapshot %s: %q, %v", meta.ID, got, err)
+		}
+		contents, err := os.ReadFile(filepath.Join(dir, "snapshots", meta.ID, "state.bin"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Contains(contents, data) {
+			t.Fatalf("snapshot %s was not encrypted", meta.ID)
+		}
+	}
+}
+
+func newKey(t *testing.T) []byte {
+	t.Helper()
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
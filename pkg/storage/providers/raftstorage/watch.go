@@ -0,0 +1,168 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package raftstorage
+
+import (
+	"bytes"
+	"sort"
+	"sync"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/providers/raftstorage/raftlogs"
+)
+
+// watchHistory keeps the most recent changes applied by the FSM so that
+// watches can be resumed from a revision. Revisions are raft indexes.
+type watchHistory struct {
+	size   int
+	events []storage.WatchEvent
+	// revision is the index of the last applied command.
+	revision uint64
+	// compacted is the highest revision whose changes may be missing
+	// from history.
+	compacted uint64
+	// restored is set after a snapshot restore until the next command
+	// is applied.
+	restored bool
+	notify   chan struct{}
+	mu       sync.RWMutex
+}
+
+func newWatchHistory(size int) *watchHistory {
+	if size <= 0 {
+		size = DefaultWatchHistory
+	}
+	return &watchHistory{
+		size:   size,
+		notify: make(chan struct{}),
+	}
+}
+
+// onApply records the changes made by a command applied by the FSM.
+func (h *watchHistory) onApply(index uint64, cmd *v1.RaftLogEntry, res *v1.RaftApplyResponse) {
+	events, complete := raftlogs.Events(index, cmd, res)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.restored {
+		// Everything before this command came from the snapshot.
+		h.restored = false
+		h.compact(index - 1)
+	}
+	if !complete {
+		h.compact(index)
+	} else {
+		h.events = append(h.events, events...)
+		for len(h.events) > h.size {
+			h.compact(h.events[0].Revision)
+		}
+	}
+	if index > h.revision {
+		h.revision = index
+	}
+	h.broadcast()
+}
+
+// onRestore marks the history as incomplete after a snapshot restore.
+func (h *watchHistory) onRestore() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.restored = true
+	h.compact(h.revision)
+	h.broadcast()
+}
+
+// compact drops all events up to and including the given revision. The caller
+// must hold the lock.
+func (h *watchHistory) compact(revision uint64) {
+	if revision > h.compacted {
+		h.compacted = revision
+	}
+	i := sort.Search(len(h.events), func(i int) bool {
+		return h.events[i].Revision > h.compacted
+	})
+	h.events = append(h.events[:0], h.events[i:]...)
+}
+
+// broadcast wakes up all watchers. The caller must hold the lock.
+func (h *watchHistory) broadcast() {
+	close(h.notify)
+	h.notify = make(chan struct{})
+}
+
+// latest returns the revision of the last applied command.
+func (h *watchHistory) latest() uint64 {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.revision
+}
+
+// since returns the events for the prefix after the given revision, the
+// revision they are current to, and a channel closed on the next change.
+func (h *watchHistory) since(prefix []byte, revision uint64) ([]storage.WatchEvent, uint64, <-chan struct{}, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if revision < h.compacted {
+		return nil, 0, nil, errors.ErrCompacted
+	}
+	i := sort.Search(len(h.events), func(i int) bool {
+		return h.events[i].Revision > revision
+	})
+	var out []storage.WatchEvent
+	for _, ev := range h.events[i:] {
+		if bytes.HasPrefix(ev.Key, prefix) {
+			out = append(out, ev)
+		}
+	}
+	return out, max(revision, h.revision), h.notify, nil
+}
+
+// watch calls fn for every change to the prefix after the given revision until
+// the context is done or the watcher falls behind the history.
+func (h *watchHistory) watch(ctx context.Context, prefix []byte, revision uint64, fn storage.WatchFunc) (<-chan error, error) {
+	if revision == 0 {
+		revision = h.latest()
+	}
+	events, next, notify, err := h.since(prefix, revision)
+	if err != nil {
+		return nil, err
+	}
+	done := make(chan error, 1)
+	go func() {
+		defer close(done)
+		for {
+			for _, ev := range events {
+				fn(ev)
+			}
+			revision = next
+			select {
+			case <-ctx.Done():
+				return
+			case <-notify:
+			}
+			events, next, notify, err = h.since(prefix, revision)
+			if err != nil {
+				done <- err
+				return
+			}
+		}
+	}()
+	return done, nil
+}
@@ -0,0 +1,99 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package raftstorage
+
+import (
+	"testing"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/providers/raftstorage/raftlogs"
+)
+
+func TestWatchHistory(t *testing.T) {
+	put := func(h *watchHistory, index uint64, key string) {
+		h.onApply(index, &v1.RaftLogEntry{Type: v1.RaftCommandType_PUT, Key: []byte(key)}, &v1.RaftApplyResponse{})
+	}
+
+	t.Run("Since", func(t *testing.T) {
+		h := newWatchHistory(10)
+		put(h, 1, "/a/1")
+		put(h, 2, "/b/1")
+		put(h, 3, "/a/2")
+		events, next, _, err := h.since([]byte("/a/"), 1)
+		if err != nil {
+			t.Fatalf("since: %v", err)
+		}
+		if len(events) != 1 || events[0].Revision != 3 || events[0].Type != storage.EventPut {
+			t.Fatalf("unexpected events: %+v", events)
+		}
+		if next != 3 {
+			t.Fatalf("expected next revision 3, got %d", next)
+		}
+	})
+
+	t.Run("Compaction", func(t *testing.T) {
+		h := newWatchHistory(2)
+		for i := uint64(1); i <= 4; i++ {
+			put(h, i, "/a")
+		}
+		if _, _, _, err := h.since(nil, 1); !errors.Is(err, errors.ErrCompacted) {
+			t.Fatalf("expected ErrCompacted, got %v", err)
+		}
+		events, _, _, err := h.since(nil, 2)
+		if err != nil {
+			t.Fatalf("since: %v", err)
+		}
+		if len(events) != 2 {
+			t.Fatalf("expected 2 events, got %d", len(events))
+		}
+	})
+
+	t.Run("Replays", func(t *testing.T) {
+		h := newWatchHistory(10)
+		put(h, 1, "/a")
+		// A transaction replayed after a restart has an unknown outcome.
+		h.onApply(2, &v1.RaftLogEntry{Type: raftlogs.CommandTxn}, &v1.RaftApplyResponse{
+			Error: errors.ErrTxnAlreadyApplied.Error(),
+		})
+		if _, _, _, err := h.since(nil, 1); !errors.Is(err, errors.ErrCompacted) {
+			t.Fatalf("expected ErrCompacted, got %v", err)
+		}
+		if _, _, _, err := h.since(nil, 2); err != nil {
+			t.Fatalf("since: %v", err)
+		}
+	})
+
+	t.Run("Restore", func(t *testing.T) {
+		h := newWatchHistory(10)
+		put(h, 1, "/a")
+		h.onRestore()
+		put(h, 10, "/a")
+		if _, _, _, err := h.since(nil, 1); !errors.Is(err, errors.ErrCompacted) {
+			t.Fatalf("expected ErrCompacted, got %v", err)
+		}
+		events, _, _, err := h.since(nil, 9)
+		if err != nil {
+			t.Fatalf("since: %v", err)
+		}
+		if len(events) != 1 || events[0].Revision != 10 {
+			t.Fatalf("unexpected events: %+v", events)
+		}
+	})
+}
@@ -0,0 +1,49 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rpcsrv
+
+import (
+	"context"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+)
+
+// WaitForRead waits until the provider can serve a read with the consistency
+// requested by the incoming request. The returned error is a gRPC status.
+func WaitForRead(ctx context.Context, db storage.Provider) error {
+	rc, err := storage.ReadConsistencyFromContext(ctx)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	err = storage.WaitForRead(ctx, db, rc)
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, errors.ErrInvalidConsistency):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, errors.ErrConsistencyNotSupported):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, errors.ErrNotLeader), errors.Is(err, errors.ErrStaleRead), errors.Is(err, errors.ErrClosed):
+		return status.Error(codes.Unavailable, err.Error())
+	default:
+		return status.Error(codes.Internal, err.Error())
+	}
+}
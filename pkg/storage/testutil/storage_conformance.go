@@ -369,6 +369,7 @@ func TestConsensusStorageConformance(ctx context.Context, t *testing.T, raftStor
 	snapshotKV := map[string][]byte{
 		"/registry/Snapshot/key1": []byte("value1"),
 		"/registry/Snapshot/key2": []byte("value2"),
+		"/secrets/Snapshot/key3":  []byte("value3"),
 	}
 
 	if meshStorage, ok := raftStorage.(storage.MeshStorage); ok {
@@ -623,6 +624,223 @@ func TestMeshStorageConformance(ctx context.Context, t *testing.T, meshStorage s
 		}
 	})
 
+	t.Run("Txn", func(t *testing.T) {
+		txnStorage, ok := meshStorage.(storage.TxnStorage)
+		if !ok {
+			t.Skip("storage does not support transactions")
+		}
+		key, other := []byte("Txn/key"), []byte("Txn/other")
+		defer func() {
+			_ = meshStorage.Delete(ctx, key)
+			_ = meshStorage.Delete(ctx, other)
+		}()
+		// A missing key should have a zero version.
+		version, err := txnStorage.GetVersion(ctx, key)
+		if err != nil {
+			t.Fatalf("failed to get version: %v", err)
+		}
+		if version != 0 {
+			t.Fatalf("expected version 0 for missing key, got %d", version)
+		}
+		// Creating the key when it doesn't exist should succeed.
+		ok, err = txnStorage.Txn(ctx, storage.CompareVersionAndSwap(key, 0, []byte("value1"), 0))
+		if err != nil {
+			t.Fatalf("failed to apply txn: %v", err)
+		}
+		if !ok {
+			t.Fatal("expected create to succeed")
+		}
+		version, err = txnStorage.GetVersion(ctx, key)
+		if err != nil {
+			t.Fatalf("failed to get version: %v", err)
+		}
+		if version == 0 {
+			t.Fatal("expected non-zero version after put")
+		}
+		// Creating it again should fail and leave the value alone.
+		ok, err = txnStorage.Txn(ctx, storage.CompareVersionAndSwap(key, 0, []byte("value2"), 0))
+		if err != nil {
+			t.Fatalf("failed to apply txn: %v", err)
+		}
+		if ok {
+			t.Fatal("expected create of existing key to fail")
+		}
+		got, err := meshStorage.GetValue(ctx, key)
+		if err != nil {
+			t.Fatalf("failed to get key: %v", err)
+		}
+		if string(got) != "value1" {
+			t.Fatalf("expected %q, got %q", "value1", got)
+		}
+		// Swapping on the current version and value should succeed.
+		ok, err = txnStorage.Txn(ctx, storage.CompareVersionAndSwap(key, version, []byte("value2"), 0))
+		if err != nil {
+			t.Fatalf("failed to apply txn: %v", err)
+		}
+		if !ok {
+			t.Fatal("expected swap on current version to succeed")
+		}
+		ok, err = txnStorage.Txn(ctx, storage.CompareAndSwap(key, []byte("value1"), []byte("value3"), 0))
+		if err != nil {
+			t.Fatalf("failed to apply txn: %v", err)
+		}
+		if ok {
+			t.Fatal("expected swap on stale value to fail")
+		}
+		ok, err = txnStorage.Txn(ctx, storage.CompareAndSwap(key, []byte("value2"), []byte("value3"), 0))
+		if err != nil {
+			t.Fatalf("failed to apply txn: %v", err)
+		}
+		if !ok {
+			t.Fatal("expected swap on current value to succeed")
+		}
+		newVersion, err := txnStorage.GetVersion(ctx, key)
+		if err != nil {
+			t.Fatalf("failed to get version: %v", err)
+		}
+		if newVersion <= version {
+			t.Fatalf("expected version to increase from %d, got %d", version, newVersion)
+		}
+		// Multi-key transactions should apply the failure branch when a condition fails.
+		ok, err = txnStorage.Txn(ctx, &storage.Txn{
+			Compare: []storage.Compare{
+				{Key: key, Target: storage.CompareValue, Value: []byte("value3")},
+				{Key: other, Target: storage.CompareVersion, Version: 1},
+			},
+			Success: []storage.Op{
+				{Type: storage.OpDelete, Key: key},
+			},
+			Failure: []storage.Op{
+				{Type: storage.OpPut, Key: other, Value: []byte("failed")},
+			},
+		})
+		if err != nil {
+			t.Fatalf("failed to apply txn: %v", err)
+		}
+		if ok {
+			t.Fatal("expected txn to fail")
+		}
+		got, err = meshStorage.GetValue(ctx, other)
+		if err != nil {
+			t.Fatalf("failed to get key: %v", err)
+		}
+		if string(got) != "failed" {
+			t.Fatalf("expected %q, got %q", "failed", got)
+		}
+		// And the success branch when all conditions hold.
+		ok, err = txnStorage.Txn(ctx, &storage.Txn{
+			Compare: []storage.Compare{
+				{Key: key, Target: storage.CompareValue, Value: []byte("value3")},
+				{Key: other, Target: storage.CompareValue, Value: []byte("failed")},
+			},
+			Success: []storage.Op{
+				{Type: storage.OpDelete, Key: key},
+				{Type: storage.OpPut, Key: other, Value: []byte("succeeded")},
+			},
+		})
+		if err != nil {
+			t.Fatalf("failed to apply txn: %v", err)
+		}
+		if !ok {
+			t.Fatal("expected txn to succeed")
+		}
+		_, err = meshStorage.GetValue(ctx, key)
+		if !errors.IsKeyNotFound(err) {
+			t.Errorf("expected ErrKeyNotFound, got %v", err)
+		}
+		version, err = txnStorage.GetVersion(ctx, key)
+		if err != nil {
+			t.Fatalf("failed to get version: %v", err)
+		}
+		if version != 0 {
+			t.Errorf("expected version 0 for deleted key, got %d", version)
+		}
+		got, err = meshStorage.GetValue(ctx, other)
+		if err != nil {
+			t.Fatalf("failed to get key: %v", err)
+		}
+		if string(got) != "succeeded" {
+			t.Fatalf("expected %q, got %q", "succeeded", got)
+		}
+		// Transactions on reserved keys should be rejected.
+		_, err = txnStorage.Txn(ctx, storage.CompareVersionAndSwap([]byte("/registry/txn"), 0, []byte("value"), 0))
+		if err == nil {
+			t.Fatal("expected txn on reserved key to fail")
+		}
+	})
+
+	t.Run("Watch", func(t *testing.T) {
+		watchStorage, ok := meshStorage.(storage.WatchStorage)
+		if !ok {
+			t.Skip("storage does not support watches")
+		}
+		prefix := []byte("Watch/")
+		start, err := watchStorage.Revision(ctx)
+		if err != nil {
+			t.Fatalf("failed to get revision: %v", err)
+		}
+		if err := meshStorage.PutValue(ctx, []byte("Watch/a"), []byte("value"), 0); err != nil {
+			t.Fatalf("failed to put key: %v", err)
+		}
+		if err := meshStorage.Delete(ctx, []byte("Watch/a")); err != nil {
+			t.Fatalf("failed to delete key: %v", err)
+		}
+		if err := meshStorage.PutValue(ctx, []byte("Watch/b"), []byte(""), 0); err != nil {
+			t.Fatalf("failed to put key: %v", err)
+		}
+		defer func() { _ = meshStorage.Delete(ctx, []byte("Watch/b")) }()
+		collect := func(revision uint64, count int) []storage.WatchEvent {
+			t.Helper()
+			wctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+			defer cancel()
+			events := make(chan storage.WatchEvent, count)
+			_, err := watchStorage.Watch(wctx, prefix, revision, func(ev storage.WatchEvent) {
+				select {
+				case events <- ev:
+				default:
+				}
+			})
+			if err != nil {
+				t.Fatalf("failed to watch: %v", err)
+			}
+			var out []storage.WatchEvent
+			for len(out) < count {
+				select {
+				case ev := <-events:
+					out = append(out, ev)
+				case <-wctx.Done():
+					t.Fatalf("timed out waiting for events, got %d of %d", len(out), count)
+				}
+			}
+			return out
+		}
+		// Watching from before the writes should replay all of them in order.
+		events := collect(start, 3)
+		expected := []struct {
+			typ storage.EventType
+			key string
+		}{
+			{storage.EventPut, "Watch/a"},
+			{storage.EventDelete, "Watch/a"},
+			{storage.EventPut, "Watch/b"},
+		}
+		var last uint64
+		for i, ev := range events {
+			if ev.Type != expected[i].typ || string(ev.Key) != expected[i].key {
+				t.Fatalf("expected %s %s, got %s %s", expected[i].typ, expected[i].key, ev.Type, ev.Key)
+			}
+			if ev.Revision <= last {
+				t.Fatalf("expected revision greater than %d, got %d", last, ev.Revision)
+			}
+			last = ev.Revision
+		}
+		// Resuming from an event should only deliver the ones after it.
+		resumed := collect(events[0].Revision, 2)
+		if resumed[0].Revision != events[1].Revision || resumed[1].Revision != events[2].Revision {
+			t.Fatalf("unexpected resumed events: %+v", resumed)
+		}
+	})
+
 	t.Run("Subscribe", func(t *testing.T) {
 		SkipOnCI(t, "Skipping on CI due to flakiness")
 		var subscribeTimeout = 15 * time.Second
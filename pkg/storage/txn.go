@@ -0,0 +1,281 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"google.golang.org/grpc/metadata"
+
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+const (
+	// TxnMeta is the metadata key for a transaction sent with a Publish request.
+	TxnMeta = "x-webmesh-txn-bin"
+	// TxnSucceededMeta is the metadata key for the outcome of a transaction
+	// in the header of a Publish response.
+	TxnSucceededMeta = "x-webmesh-txn-succeeded"
+	// VersionMeta is the metadata key for the version of a key in the header
+	// of a Query response for a single value.
+	VersionMeta = "x-webmesh-version"
+)
+
+// TxnStorage is implemented by MeshStorage that supports atomic
+// compare-and-swap and multi-key transactions.
+type TxnStorage interface {
+	// Txn atomically evaluates the conditions of the transaction and applies
+	// its success operations if all of them hold, or its failure operations
+	// otherwise. It returns true if the success operations were applied.
+	Txn(ctx context.Context, txn *Txn) (bool, error)
+	// GetVersion returns the version of a key. The version is the revision
+	// of the transaction that last modified the key, or zero if the key
+	// does not exist.
+	GetVersion(ctx context.Context, key []byte) (uint64, error)
+}
+
+// CompareTarget is the attribute of a key checked by a Compare.
+type CompareTarget string
+
+const (
+	// CompareValue compares the value of a key. An empty value also matches
+	// a key that does not exist.
+	CompareValue CompareTarget = "value"
+	// CompareVersion compares the version of a key. A version of zero matches
+	// a key that does not exist.
+	CompareVersion CompareTarget = "version"
+)
+
+// Compare is a condition on a single key in a transaction.
+type Compare struct {
+	// Key is the key to compare.
+	Key []byte `json:"key"`
+	// Target is the attribute of the key to compare.
+	Target CompareTarget `json:"target"`
+	// Value is the expected value when Target is CompareValue.
+	Value []byte `json:"value,omitempty"`
+	// Version is the expected version when Target is CompareVersion.
+	Version uint64 `json:"version,omitempty"`
+}
+
+// OpType is the type of an operation in a transaction.
+type OpType string
+
+const (
+	// OpPut sets the value of a key.
+	OpPut OpType = "put"
+	// OpDelete removes a key.
+	OpDelete OpType = "delete"
+)
+
+// Op is a write operation in a transaction.
+type Op struct {
+	// Type is the type of operation.
+	Type OpType `json:"type"`
+	// Key is the key to write.
+	Key []byte `json:"key"`
+	// Value is the value to put.
+	Value []byte `json:"value,omitempty"`
+	// TTL is the optional time-to-live of a put.
+	TTL time.Duration `json:"ttl,omitempty"`
+	// Owner is the optional node that owns the key after a put. It is
+	// used for accounting the usage of namespaces by node.
+	Owner types.NodeID `json:"owner,omitempty"`
+}
+
+// Txn is a transaction on the mesh storage.
+type Txn struct {
+	// Compare are the conditions of the transaction.
+	Compare []Compare `json:"compare,omitempty"`
+	// Success are the operations applied when all conditions hold.
+	Success []Op `json:"success,omitempty"`
+	// Failure are the operations applied when any condition fails.
+	Failure []Op `json:"failure,omitempty"`
+	// Revision is the revision assigned to the transaction by the consensus
+	// layer. It becomes the version of every key written by the transaction.
+	// Backends assign the next revision themselves when it is zero, and skip
+	// transactions with a revision they have already applied by returning
+	// errors.ErrTxnAlreadyApplied.
+	Revision uint64 `json:"revision,omitempty"`
+}
+
+// CompareAndSwap returns a transaction that puts the given value if the current
+// value of the key matches old. An empty old value also matches a key that does
+// not exist.
+func CompareAndSwap(key, old, value []byte, ttl time.Duration) *Txn {
+	return &Txn{
+		Compare: []Compare{{Key: key, Target: CompareValue, Value: old}},
+		Success: []Op{{Type: OpPut, Key: key, Value: value, TTL: ttl}},
+	}
+}
+
+// CompareVersionAndSwap returns a transaction that puts the given value if the
+// current version of the key matches version. A version of zero matches a key
+// that does not exist.
+func CompareVersionAndSwap(key []byte, version uint64, value []byte, ttl time.Duration) *Txn {
+	return &Txn{
+		Compare: []Compare{{Key: key, Target: CompareVersion, Version: version}},
+		Success: []Op{{Type: OpPut, Key: key, Value: value, TTL: ttl}},
+	}
+}
+
+// Keys returns all keys referenced by the transaction.
+func (t *Txn) Keys() [][]byte {
+	var keys [][]byte
+	seen := make(map[string]struct{})
+	add := func(key []byte) {
+		if _, ok := seen[string(key)]; ok {
+			return
+		}
+		seen[string(key)] = struct{}{}
+		keys = append(keys, key)
+	}
+	for _, c := range t.Compare {
+		add(c.Key)
+	}
+	for _, op := range t.Success {
+		add(op.Key)
+	}
+	for _, op := range t.Failure {
+		add(op.Key)
+	}
+	return keys
+}
+
+// Validate returns an error if the transaction is malformed or touches
+// reserved keys.
+func (t *Txn) Validate() error {
+	if len(t.Compare) == 0 && len(t.Success) == 0 && len(t.Failure) == 0 {
+		return fmt.Errorf("%w: empty transaction", errors.ErrInvalidTxn)
+	}
+	for _, key := range t.Keys() {
+		if !types.IsValidPathID(string(key)) {
+			return fmt.Errorf("%w: %q", errors.ErrInvalidKey, key)
+		}
+		if types.IsReservedPrefix(key) {
+			return fmt.Errorf("%w: key %q is reserved", errors.ErrInvalidTxn, key)
+		}
+	}
+	for _, c := range t.Compare {
+		switch c.Target {
+		case CompareValue, CompareVersion:
+		default:
+			return fmt.Errorf("%w: unknown compare target %q", errors.ErrInvalidTxn, c.Target)
+		}
+	}
+	for _, op := range append(append([]Op{}, t.Success...), t.Failure...) {
+		switch op.Type {
+		case OpPut, OpDelete:
+		default:
+			return fmt.Errorf("%w: unknown operation %q", errors.ErrInvalidTxn, op.Type)
+		}
+		if op.TTL < 0 {
+			return fmt.Errorf("%w: negative ttl for %q", errors.ErrInvalidTxn, op.Key)
+		}
+	}
+	return nil
+}
+
+// Matches evaluates the comparison against the current value and version of its key.
+// A nil value and zero version indicate that the key does not exist.
+func (c Compare) Matches(value []byte, version uint64) bool {
+	switch c.Target {
+	case CompareValue:
+		if value == nil {
+			return len(c.Value) == 0
+		}
+		return bytes.Equal(value, c.Value)
+	case CompareVersion:
+		return version == c.Version
+	default:
+		return false
+	}
+}
+
+// MarshalTxn encodes a transaction for replication or transport.
+func MarshalTxn(t *Txn) ([]byte, error) {
+	return json.Marshal(t)
+}
+
+// UnmarshalTxn decodes a transaction encoded with MarshalTxn.
+func UnmarshalTxn(data []byte) (*Txn, error) {
+	var t Txn
+	if err := json.Unmarshal(data, &t); err != nil {
+		return nil, fmt.Errorf("%w: %v", errors.ErrInvalidTxn, err)
+	}
+	return &t, nil
+}
+
+// VersionKey returns the key holding the version of the given key.
+func VersionKey(key []byte) []byte {
+	return types.KVVersionsPrefix.For(key)
+}
+
+// OwnerKey returns the key holding the owner of the given key.
+func OwnerKey(key []byte) []byte {
+	return types.KVOwnersPrefix.For(key)
+}
+
+// UsageKey returns the key holding the accounted usage of the given key.
+func UsageKey(key []byte) []byte {
+	return types.KVUsagePrefix.For(key)
+}
+
+// NamespaceUsageKey returns the key holding the usage counters of the
+// named namespace.
+func NamespaceUsageKey(name string) []byte {
+	return types.NamespaceUsagePrefix.ForString(name)
+}
+
+// Usage returns the usage a put operation accounts to its namespace.
+func (op Op) Usage() types.NamespaceKeyUsage {
+	return types.NamespaceKeyUsage{Size: int64(len(op.Key) + len(op.Value)), Owner: op.Owner}
+}
+
+// ContextWithTxn returns an outgoing context that sends the transaction with a
+// Publish request. The key and value of the request, if set, are added to the
+// success operations of the transaction.
+func ContextWithTxn(ctx context.Context, t *Txn) (context.Context, error) {
+	data, err := MarshalTxn(t)
+	if err != nil {
+		return nil, err
+	}
+	return metadata.AppendToOutgoingContext(ctx, TxnMeta, string(data)), nil
+}
+
+// TxnFromContext returns the transaction sent with an incoming Publish request.
+// If no transaction was sent then false is returned.
+func TxnFromContext(ctx context.Context) (*Txn, bool, error) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return nil, false, nil
+	}
+	vals := md.Get(TxnMeta)
+	if len(vals) == 0 || vals[0] == "" {
+		return nil, false, nil
+	}
+	t, err := UnmarshalTxn([]byte(vals[0]))
+	if err != nil {
+		return nil, true, err
+	}
+	return t, true, nil
+}
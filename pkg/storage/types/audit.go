@@ -0,0 +1,67 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package types
+
+import "time"
+
+// AuditEntry is the record of a mutation made through the mesh APIs.
+type AuditEntry struct {
+	// ID is the unique ID of the entry. IDs sort in the order the entries
+	// were recorded.
+	ID string `json:"id"`
+	// Time is when the mutation was made.
+	Time time.Time `json:"time"`
+	// Node is the node that handled the request.
+	Node NodeID `json:"node"`
+	// Caller is the authenticated caller of the RPC. When the request was
+	// proxied by another node, this is the node that proxied it.
+	Caller string `json:"caller,omitempty"`
+	// ProxiedFor is the authenticated caller on whose behalf the request
+	// was proxied to the handling node.
+	ProxiedFor string `json:"proxiedFor,omitempty"`
+	// Method is the full method name of the RPC.
+	Method string `json:"method"`
+	// Resource is the resource the mutation targeted, such as roles/admin.
+	Resource string `json:"resource,omitempty"`
+	// Changes are the changes the mutation made to the mesh storage.
+	Changes []AuditChange `json:"changes,omitempty"`
+	// Error is the error returned by the RPC, if any.
+	Error string `json:"error,omitempty"`
+}
+
+// Principal returns the identity the mutation was made for. This is the
+// proxied-for identity when set, otherwise the caller.
+func (e AuditEntry) Principal() string {
+	if e.ProxiedFor != "" {
+		return e.ProxiedFor
+	}
+	return e.Caller
+}
+
+// AuditChange is a change to a single key made by a mutation.
+type AuditChange struct {
+	// Key is the key that changed.
+	Key string `json:"key"`
+	// Before is the value of the key before the change. It is empty when
+	// the key did not exist.
+	Before string `json:"before,omitempty"`
+	// After is the value of the key after the change. It is empty when the
+	// key was deleted.
+	After string `json:"after,omitempty"`
+	// Deleted is true if the key was deleted.
+	Deleted bool `json:"deleted,omitempty"`
+}
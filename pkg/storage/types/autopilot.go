@@ -0,0 +1,85 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package types
+
+import "time"
+
+// Autopilot actions.
+const (
+	// AutopilotPromote promotes an observer to a voter.
+	AutopilotPromote = "promote"
+	// AutopilotDemote demotes a voter to an observer.
+	AutopilotDemote = "demote"
+	// AutopilotRemove removes a dead server from the consensus group.
+	AutopilotRemove = "remove"
+)
+
+// AutopilotState is the state of the consensus group as last seen by the
+// autopilot running on the leader.
+type AutopilotState struct {
+	// Leader is the ID of the leader running the autopilot.
+	Leader NodeID `json:"leader"`
+	// UpdatedAt is when the state last changed.
+	UpdatedAt time.Time `json:"updatedAt"`
+	// TargetVoters is the number of voters the autopilot maintains.
+	TargetVoters int `json:"targetVoters"`
+	// FailureTolerance is the number of voters that can fail without
+	// losing quorum.
+	FailureTolerance int `json:"failureTolerance"`
+	// Servers are the servers in the consensus group.
+	Servers []AutopilotServer `json:"servers"`
+	// Decisions are the most recent changes made by the autopilot, oldest first.
+	Decisions []AutopilotDecision `json:"decisions,omitempty"`
+}
+
+// AutopilotServer is the health of a server in the consensus group.
+type AutopilotServer struct {
+	// ID is the ID of the server.
+	ID NodeID `json:"id"`
+	// Address is the raft address of the server.
+	Address string `json:"address"`
+	// Voter is true if the server is a voter.
+	Voter bool `json:"voter"`
+	// Leader is true if the server is the leader.
+	Leader bool `json:"leader,omitempty"`
+	// ZoneAwarenessID is the zone of the server.
+	ZoneAwarenessID string `json:"zoneAwarenessID,omitempty"`
+	// Healthy is true if the server is reachable and not lagging behind.
+	Healthy bool `json:"healthy"`
+	// Reason explains why the server is unhealthy.
+	Reason string `json:"reason,omitempty"`
+	// Lag is how many log entries the server was behind the leader when
+	// it last reported its applied index.
+	Lag uint64 `json:"lag"`
+	// FailingSince is when the leader last heard from a server that is
+	// failing heartbeats.
+	FailingSince time.Time `json:"failingSince,omitempty"`
+}
+
+// AutopilotDecision is a change made to the consensus group by the autopilot.
+type AutopilotDecision struct {
+	// Time is when the change was made.
+	Time time.Time `json:"time"`
+	// Action is one of the autopilot actions.
+	Action string `json:"action"`
+	// NodeID is the server that was changed.
+	NodeID NodeID `json:"nodeID"`
+	// Reason explains the change.
+	Reason string `json:"reason"`
+	// Error is set if the change failed.
+	Error string `json:"error,omitempty"`
+}
@@ -0,0 +1,146 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package types
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// DNSRecordTypes are the types of custom DNS records that are supported.
+var DNSRecordTypes = []string{"A", "AAAA", "CNAME", "TXT", "SRV"}
+
+// DefaultDNSRecordTTL is the TTL of custom DNS records that do not set one.
+const DefaultDNSRecordTTL = 60
+
+// DNSRecord is a custom record served by MeshDNS. A record is identified by
+// its name and type and holds every value of the resulting RRset.
+type DNSRecord struct {
+	// Name is the fully qualified name of the record, without the trailing dot.
+	Name string `json:"name"`
+	// Type is the type of the record, one of DNSRecordTypes.
+	Type string `json:"type"`
+	// Zone is the zone the record belongs to. It is the mesh domain unless the
+	// record is served for an additional zone delegated to MeshDNS.
+	Zone string `json:"zone"`
+	// TTL is the time-to-live of the record in seconds.
+	TTL uint32 `json:"ttl,omitempty"`
+	// Values are the data of the record in presentation format, such as
+	// "10.0.0.1" for A records or "10 5 5432 db.example.com." for SRV records.
+	Values []string `json:"values"`
+}
+
+// DNSRecordRef references a custom DNS record.
+type DNSRecordRef struct {
+	// Name is the fully qualified name of the record.
+	Name string `json:"name"`
+	// Type is the type of the record.
+	Type string `json:"type"`
+}
+
+// Normalize returns the record with a canonical name, zone, and type. Names
+// not ending in the zone are treated as relative to it and "@" is the zone
+// itself. The zone defaults to the given domain.
+func (r DNSRecord) Normalize(defaultZone string) DNSRecord {
+	r.Zone = canonicalDomain(r.Zone)
+	if r.Zone == "" {
+		r.Zone = canonicalDomain(defaultZone)
+	}
+	r.Name = canonicalDomain(r.Name)
+	switch {
+	case r.Name == "@" || r.Name == "":
+		r.Name = r.Zone
+	case r.Name != r.Zone && !strings.HasSuffix(r.Name, "."+r.Zone):
+		r.Name = r.Name + "." + r.Zone
+	}
+	r.Type = strings.ToUpper(r.Type)
+	if r.TTL == 0 {
+		r.TTL = DefaultDNSRecordTTL
+	}
+	return r
+}
+
+// Ref returns a reference to the record.
+func (r DNSRecord) Ref() DNSRecordRef {
+	return DNSRecordRef{Name: r.Name, Type: r.Type}
+}
+
+// Normalize returns the reference with a canonical name and type. Names ending
+// in a dot are absolute, other names not ending in the given zone are relative
+// to it.
+func (r DNSRecordRef) Normalize(defaultZone string) DNSRecordRef {
+	if strings.HasSuffix(r.Name, ".") {
+		return DNSRecordRef{Name: canonicalDomain(r.Name), Type: strings.ToUpper(r.Type)}
+	}
+	return DNSRecord{Name: r.Name, Type: r.Type}.Normalize(defaultZone).Ref()
+}
+
+// Validate validates a normalized record.
+func (r DNSRecord) Validate() error {
+	if _, ok := dns.IsDomainName(r.Zone); !ok || r.Zone == "" {
+		return fmt.Errorf("invalid zone %q", r.Zone)
+	}
+	if _, ok := dns.IsDomainName(r.Name); !ok || !dns.IsSubDomain(r.Zone, r.Name) {
+		return fmt.Errorf("name %q is not in zone %q", r.Name, r.Zone)
+	}
+	if !slices.Contains(DNSRecordTypes, r.Type) {
+		return fmt.Errorf("unsupported record type %q, must be one of %s", r.Type, strings.Join(DNSRecordTypes, ", "))
+	}
+	if len(r.Values) == 0 {
+		return fmt.Errorf("record must have at least one value")
+	}
+	if r.Type == "CNAME" && (len(r.Values) != 1 || r.Name == r.Zone) {
+		return fmt.Errorf("CNAME records must have exactly one value and cannot be at the zone apex")
+	}
+	_, err := r.RRs()
+	return err
+}
+
+// RRs returns the record as a DNS RRset.
+func (r DNSRecord) RRs() ([]dns.RR, error) {
+	out := make([]dns.RR, 0, len(r.Values))
+	for _, value := range r.Values {
+		rr, err := dns.NewRR(fmt.Sprintf("%s %d IN %s %s", dns.Fqdn(r.Name), r.TTL, r.Type, value))
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s value %q: %w", r.Type, value, err)
+		}
+		if rr == nil {
+			return nil, fmt.Errorf("invalid %s value %q", r.Type, value)
+		}
+		out = append(out, rr)
+	}
+	return out, nil
+}
+
+// DNSRecordKey returns the storage key of the record with the given name and
+// type. The labels of the name are reversed so records sort by zone.
+func DNSRecordKey(name, rtype string) StoragePrefix {
+	labels := dns.SplitDomainName(canonicalDomain(name))
+	slices.Reverse(labels)
+	key := DNSRecordsPrefix
+	for _, label := range labels {
+		key = key.ForString(label)
+	}
+	return key.ForString(strings.ToUpper(rtype))
+}
+
+func canonicalDomain(name string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(name)), ".")
+}
@@ -0,0 +1,306 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package types
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/webmeshproj/webmesh/pkg/crypto"
+)
+
+// RemoteNodeSeparator separates a node ID from the domain of the federated
+// mesh it belongs to in references such as "node@otherdomain".
+const RemoteNodeSeparator = "@"
+
+// Federation is a mesh federated with this one. The trust bundle describes
+// the remote mesh and the gateways are the local nodes that peer with its
+// gateways.
+type Federation struct {
+	// Bundle is the trust bundle of the remote mesh.
+	Bundle TrustBundle `json:"bundle"`
+	// SigningKeyFingerprint is the fingerprint of the key that signs the
+	// bundle, obtained from the administrators of the remote mesh out of
+	// band. The bundle carries its own signing key, so the fingerprint is
+	// what ties the bundle to the remote mesh.
+	SigningKeyFingerprint string `json:"signingKeyFingerprint"`
+	// Gateways are the IDs of the local nodes that peer with the gateways
+	// of the remote mesh. The local gateway at index i peers with the remote
+	// gateway at index i modulo the number of remote gateways.
+	Gateways []string `json:"gateways"`
+}
+
+// Domain returns the domain of the federated mesh.
+func (f Federation) Domain() string {
+	return f.Bundle.Domain
+}
+
+// Expired returns true if the bundle of the federation has expired at the
+// given time. Expired federations are ignored until their bundle is renewed.
+func (f Federation) Expired(now time.Time) bool {
+	return f.Bundle.Expired(now)
+}
+
+// Validate validates the federation, verifies the signature of its bundle
+// and checks that the bundle is signed by the pinned signing key.
+func (f Federation) Validate() error {
+	if err := f.Bundle.Verify(); err != nil {
+		return err
+	}
+	if f.SigningKeyFingerprint == "" {
+		return errors.New("the fingerprint of the signing key is required")
+	}
+	if !strings.EqualFold(f.SigningKeyFingerprint, f.Bundle.SigningKeyFingerprint()) {
+		return fmt.Errorf("trust bundle for %q is not signed by the key with fingerprint %s", f.Bundle.Domain, f.SigningKeyFingerprint)
+	}
+	if len(f.Gateways) == 0 {
+		return errors.New("at least one local gateway is required")
+	}
+	for _, gw := range f.Gateways {
+		if !IsValidNodeID(gw) {
+			return fmt.Errorf("invalid gateway node ID: %s", gw)
+		}
+	}
+	return nil
+}
+
+// RemoteGatewayFor returns the remote gateway the given local gateway peers
+// with. False is returned if the node is not a gateway of the federation.
+func (f Federation) RemoteGatewayFor(nodeID NodeID) (FederationGateway, bool) {
+	idx := slices.Index(f.Gateways, nodeID.String())
+	if idx == -1 || len(f.Bundle.Gateways) == 0 {
+		return FederationGateway{}, false
+	}
+	return f.Bundle.Gateways[idx%len(f.Bundle.Gateways)], true
+}
+
+// TrustBundle is a signed description of a mesh exchanged between mesh
+// administrators to federate their meshes.
+type TrustBundle struct {
+	// Domain is the domain of the mesh.
+	Domain string `json:"domain"`
+	// Prefixes are the prefixes reachable through the gateways of the mesh.
+	Prefixes []string `json:"prefixes"`
+	// Gateways are the nodes that peer with federated meshes.
+	Gateways []FederationGateway `json:"gateways"`
+	// Nodes are nodes that may be referenced by federated meshes as
+	// "node@domain" in network ACLs.
+	Nodes []FederatedNode `json:"nodes,omitempty"`
+	// CA is an optional PEM encoded certificate authority for the APIs
+	// of the mesh.
+	CA string `json:"ca,omitempty"`
+	// Expires is when the bundle expires. A zero value never expires.
+	// Federations with an expired bundle are ignored until it is renewed.
+	Expires time.Time `json:"expires,omitempty"`
+	// SigningKey is the encoded public key that signed the bundle.
+	SigningKey string `json:"signingKey"`
+	// Signature is the signature over the bundle with the signature unset.
+	Signature []byte `json:"signature,omitempty"`
+}
+
+// FederationGateway is a gateway of a federated mesh.
+type FederationGateway struct {
+	// ID is the node ID of the gateway.
+	ID string `json:"id"`
+	// PublicKey is the encoded public key of the gateway.
+	PublicKey string `json:"publicKey"`
+	// Endpoints are the WireGuard endpoints of the gateway.
+	Endpoints []string `json:"endpoints"`
+	// Addresses are the addresses of the gateway in its mesh.
+	Addresses []string `json:"addresses,omitempty"`
+	// DNS are the addresses of MeshDNS servers answering for the domain of
+	// the mesh.
+	DNS []string `json:"dns,omitempty"`
+}
+
+// FederatedNode is a node of a federated mesh that may be referenced in ACLs.
+type FederatedNode struct {
+	// ID is the node ID.
+	ID string `json:"id"`
+	// Addresses are the addresses of the node in its mesh.
+	Addresses []string `json:"addresses"`
+}
+
+// Sign signs the bundle with the given key.
+func (b *TrustBundle) Sign(key crypto.PrivateKey) error {
+	encoded, err := key.PublicKey().Encode()
+	if err != nil {
+		return fmt.Errorf("encode signing key: %w", err)
+	}
+	b.SigningKey = encoded
+	payload, err := b.signingPayload()
+	if err != nil {
+		return err
+	}
+	b.Signature = ed25519.Sign(key.AsNative(), payload)
+	return nil
+}
+
+// SigningKeyFingerprint returns the hex encoded SHA-256 fingerprint of the
+// signing key of the bundle.
+func (b TrustBundle) SigningKeyFingerprint() string {
+	sum := sha256.Sum256([]byte(b.SigningKey))
+	return hex.EncodeToString(sum[:])
+}
+
+// Expired returns true if the bundle has expired at the given time.
+func (b TrustBundle) Expired(now time.Time) bool {
+	return !b.Expires.IsZero() && b.Expires.Before(now)
+}
+
+// Verify validates the bundle and verifies its signature against the
+// signing key it carries. The signature only proves the bundle was not
+// modified after signing, callers are responsible for deciding whether
+// the signing key is trusted.
+func (b TrustBundle) Verify() error {
+	if err := b.Validate(); err != nil {
+		return err
+	}
+	if b.Expired(time.Now()) {
+		return fmt.Errorf("trust bundle for %q expired at %s", b.Domain, b.Expires.Format(time.RFC3339))
+	}
+	key, err := crypto.DecodePublicKey(b.SigningKey)
+	if err != nil {
+		return fmt.Errorf("invalid signing key: %w", err)
+	}
+	payload, err := b.signingPayload()
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(key.AsNative(), payload, b.Signature) {
+		return fmt.Errorf("invalid signature on trust bundle for %q", b.Domain)
+	}
+	return nil
+}
+
+// Validate validates the contents of the bundle without checking its signature.
+func (b TrustBundle) Validate() error {
+	domain := strings.TrimSuffix(b.Domain, ".")
+	if !IsValidID(domain) || strings.Contains(domain, RemoteNodeSeparator) {
+		return fmt.Errorf("invalid domain: %q", b.Domain)
+	}
+	if len(b.Prefixes) == 0 {
+		return errors.New("at least one prefix is required")
+	}
+	for _, prefix := range b.Prefixes {
+		if _, err := netip.ParsePrefix(prefix); err != nil {
+			return fmt.Errorf("invalid prefix %q: %w", prefix, err)
+		}
+	}
+	if len(b.Gateways) == 0 {
+		return errors.New("at least one gateway is required")
+	}
+	for _, gw := range b.Gateways {
+		if !IsValidNodeID(gw.ID) {
+			return fmt.Errorf("invalid gateway node ID: %s", gw.ID)
+		}
+		if _, err := crypto.DecodePublicKey(gw.PublicKey); err != nil {
+			return fmt.Errorf("invalid public key for gateway %s: %w", gw.ID, err)
+		}
+		if len(gw.Endpoints) == 0 {
+			return fmt.Errorf("gateway %s has no endpoints", gw.ID)
+		}
+		for _, ep := range gw.Endpoints {
+			if _, err := netip.ParseAddrPort(ep); err != nil {
+				return fmt.Errorf("invalid endpoint %q for gateway %s: %w", ep, gw.ID, err)
+			}
+		}
+		for _, addr := range gw.Addresses {
+			if _, err := netip.ParsePrefix(addr); err != nil {
+				return fmt.Errorf("invalid address %q for gateway %s: %w", addr, gw.ID, err)
+			}
+		}
+		for _, dns := range gw.DNS {
+			if _, err := netip.ParseAddrPort(dns); err != nil {
+				return fmt.Errorf("invalid dns server %q for gateway %s: %w", dns, gw.ID, err)
+			}
+		}
+	}
+	for _, node := range b.Nodes {
+		if !IsValidNodeID(node.ID) {
+			return fmt.Errorf("invalid node ID: %s", node.ID)
+		}
+		for _, addr := range node.Addresses {
+			if _, err := netip.ParsePrefix(addr); err != nil {
+				return fmt.Errorf("invalid address %q for node %s: %w", addr, node.ID, err)
+			}
+		}
+	}
+	return nil
+}
+
+// PrefixList returns the parsed prefixes of the bundle. Invalid prefixes
+// are ignored.
+func (b TrustBundle) PrefixList() []netip.Prefix {
+	return ToPrefixes(b.Prefixes)
+}
+
+// DNSServers returns the MeshDNS servers of all the gateways of the bundle.
+func (b TrustBundle) DNSServers() []string {
+	var out []string
+	for _, gw := range b.Gateways {
+		out = append(out, gw.DNS...)
+	}
+	return out
+}
+
+// ResolveNode returns the prefixes of the given node in the bundle. A wildcard
+// resolves to all the prefixes of the bundle. False is returned if the node is
+// unknown.
+func (b TrustBundle) ResolveNode(id string) ([]netip.Prefix, bool) {
+	if id == "*" {
+		return b.PrefixList(), true
+	}
+	for _, node := range b.Nodes {
+		if node.ID == id {
+			return ToPrefixes(node.Addresses), true
+		}
+	}
+	for _, gw := range b.Gateways {
+		if gw.ID == id {
+			return ToPrefixes(gw.Addresses), true
+		}
+	}
+	return nil, false
+}
+
+func (b TrustBundle) signingPayload() ([]byte, error) {
+	b.Signature = nil
+	data, err := json.Marshal(b)
+	if err != nil {
+		return nil, fmt.Errorf("marshal trust bundle: %w", err)
+	}
+	return data, nil
+}
+
+// ParseRemoteNode splits a reference such as "node@otherdomain" into its node
+// ID and domain. False is returned if the reference is not a remote reference.
+func ParseRemoteNode(ref string) (node string, domain string, ok bool) {
+	node, domain, ok = strings.Cut(ref, RemoteNodeSeparator)
+	if !ok || node == "" || domain == "" {
+		return "", "", false
+	}
+	return node, strings.TrimSuffix(domain, "."), true
+}
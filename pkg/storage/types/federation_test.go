@@ -0,0 +1,121 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package types
+
+import (
+	"encoding/json"
+	"net/netip"
+	"testing"
+	"time"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/crypto"
+)
+
+func TestTrustBundleSignatures(t *testing.T) {
+	t.Parallel()
+	gwKey, err := crypto.MustGenerateKey().PublicKey().Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	newBundle := func() TrustBundle {
+		return TrustBundle{
+			Domain:   "other.example.com",
+			Prefixes: []string{"10.50.0.0/16"},
+			Gateways: []FederationGateway{{
+				ID:        "gateway",
+				PublicKey: gwKey,
+				Endpoints: []string{"203.0.113.10:51820"},
+			}},
+		}
+	}
+
+	t.Run("RoundTrip", func(t *testing.T) {
+		bundle := newBundle()
+		bundle.Expires = time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+		if err := bundle.Sign(crypto.MustGenerateKey()); err != nil {
+			t.Fatal(err)
+		}
+		data, err := json.Marshal(bundle)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var decoded TrustBundle
+		if err := json.Unmarshal(data, &decoded); err != nil {
+			t.Fatal(err)
+		}
+		if err := decoded.Verify(); err != nil {
+			t.Fatalf("expected decoded bundle to verify: %v", err)
+		}
+	})
+
+	t.Run("Tampered", func(t *testing.T) {
+		bundle := newBundle()
+		if err := bundle.Sign(crypto.MustGenerateKey()); err != nil {
+			t.Fatal(err)
+		}
+		bundle.Prefixes = append(bundle.Prefixes, "0.0.0.0/0")
+		if err := bundle.Verify(); err == nil {
+			t.Fatal("expected tampered bundle to fail verification")
+		}
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		bundle := newBundle()
+		bundle.Expires = time.Now().Add(-time.Minute)
+		if err := bundle.Sign(crypto.MustGenerateKey()); err != nil {
+			t.Fatal(err)
+		}
+		if err := bundle.Verify(); err == nil {
+			t.Fatal("expected expired bundle to fail verification")
+		}
+	})
+}
+
+func TestNetworkACLRemoteNodes(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	acl := NetworkACL{NetworkACL: &v1.NetworkACL{
+		Name:             "remote-db",
+		Action:           v1.ACLAction_ACTION_ACCEPT,
+		SourceNodes:      []string{"*"},
+		DestinationNodes: []string{"db@other.example.com"},
+	}}
+	action := NetworkAction{NetworkAction: &v1.NetworkAction{
+		SrcNode: "node-a",
+		DstNode: "gateway",
+		DstCIDR: "10.50.0.5/32",
+	}}
+	if acl.Matches(ctx, action) {
+		t.Fatal("expected unresolved remote reference not to match")
+	}
+	acl.ResolveRemoteNode("db@other.example.com", []netip.Prefix{netip.MustParsePrefix("10.50.0.5/32")})
+	if !acl.Matches(ctx, action) {
+		t.Fatal("expected resolved remote reference to match")
+	}
+	action.DstCIDR = "10.50.0.6/32"
+	if acl.Matches(ctx, action) {
+		t.Fatal("expected other remote address not to match")
+	}
+	// Referencing the remote node directly always matches.
+	action.DstNode = "db@other.example.com"
+	if !acl.Matches(ctx, action) {
+		t.Fatal("expected direct reference to match")
+	}
+}
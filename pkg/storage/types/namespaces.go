@@ -0,0 +1,119 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package types
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// Namespace is a key-value namespace. A namespace holds all keys beginning
+// with "/<name>/". Access to the keys of a namespace is granted with pub/sub
+// rules on the "/<name>/*" resource name. Quotas of zero are unlimited.
+type Namespace struct {
+	// Name is the name of the namespace.
+	Name string `json:"name"`
+	// MaxKeys is the maximum number of keys in the namespace.
+	MaxKeys int64 `json:"maxKeys,omitempty"`
+	// MaxBytes is the maximum total size of the keys and values in the namespace.
+	MaxBytes int64 `json:"maxBytes,omitempty"`
+	// MaxKeysPerNode is the maximum number of keys a single node may own
+	// in the namespace.
+	MaxKeysPerNode int64 `json:"maxKeysPerNode,omitempty"`
+	// MaxBytesPerNode is the maximum total size of the keys and values a
+	// single node may own in the namespace.
+	MaxBytesPerNode int64 `json:"maxBytesPerNode,omitempty"`
+}
+
+// NamespaceUsage is the usage of a namespace.
+type NamespaceUsage struct {
+	// Keys is the number of keys in the namespace.
+	Keys int64 `json:"keys"`
+	// Bytes is the total size of the keys and values in the namespace.
+	Bytes int64 `json:"bytes"`
+	// Nodes is the usage of the namespace by the nodes that own keys in it.
+	Nodes map[NodeID]NamespaceNodeUsage `json:"nodes,omitempty"`
+}
+
+// NamespaceNodeUsage is the usage of a namespace by a single node.
+type NamespaceNodeUsage struct {
+	// Keys is the number of keys owned by the node.
+	Keys int64 `json:"keys"`
+	// Bytes is the total size of the keys and values owned by the node.
+	Bytes int64 `json:"bytes"`
+}
+
+// NamespaceKeyUsage is the accounted size and owner of a key in a namespace.
+type NamespaceKeyUsage struct {
+	// Size is the size of the key and its value.
+	Size int64 `json:"size"`
+	// Owner is the node that last wrote the key, if known.
+	Owner NodeID `json:"owner,omitempty"`
+}
+
+// Add adds or, with a negative sign, removes a key from the usage.
+func (u *NamespaceUsage) Add(key NamespaceKeyUsage, sign int64) {
+	u.Keys += sign
+	u.Bytes += sign * key.Size
+	if key.Owner == "" {
+		return
+	}
+	if u.Nodes == nil {
+		u.Nodes = make(map[NodeID]NamespaceNodeUsage)
+	}
+	node := u.Nodes[key.Owner]
+	node.Keys += sign
+	node.Bytes += sign * key.Size
+	if node.Keys == 0 {
+		delete(u.Nodes, key.Owner)
+		return
+	}
+	u.Nodes[key.Owner] = node
+}
+
+// Prefix returns the key prefix of the namespace.
+func (n Namespace) Prefix() []byte {
+	return []byte("/" + n.Name + "/")
+}
+
+// Validate validates the namespace.
+func (n Namespace) Validate() error {
+	if !IsValidID(n.Name) {
+		return fmt.Errorf("namespace name must be a valid ID")
+	}
+	if IsReservedPrefix(n.Prefix()) {
+		return fmt.Errorf("namespace %q is reserved", n.Name)
+	}
+	if n.MaxKeys < 0 || n.MaxBytes < 0 || n.MaxKeysPerNode < 0 || n.MaxBytesPerNode < 0 {
+		return fmt.Errorf("namespace quotas cannot be negative")
+	}
+	return nil
+}
+
+// NamespaceOf returns the name of the namespace a key belongs to. Keys that
+// do not begin with a slash or have no further path segments do not belong
+// to a namespace, in which case an empty string is returned.
+func NamespaceOf(key []byte) string {
+	if !bytes.HasPrefix(key, []byte("/")) {
+		return ""
+	}
+	name, _, ok := bytes.Cut(key[1:], []byte("/"))
+	if !ok {
+		return ""
+	}
+	return string(name)
+}
@@ -141,19 +141,42 @@ func (a NetworkACLs) AllowNodesToCommunicate(ctx context.Context, nodeA, nodeB M
 // are sorted by priority. The first ACL that matches the action will be used.
 // If no ACL matches, the action is denied.
 func (a NetworkACLs) Accept(ctx context.Context, action NetworkAction) bool {
+	acl, ok := a.Evaluate(ctx, action)
+	if !ok {
+		context.LoggerFrom(ctx).Debug("No network ACL matches action, denying", "action", action)
+		return false
+	}
+	context.LoggerFrom(ctx).Debug("Network ACL matches action", "action", action, "acl", acl)
+	return acl.Action == v1.ACLAction_ACTION_ACCEPT
+}
+
+// Evaluate returns the first ACL in the list that matches the action. It assumes
+// the ACLs are sorted by priority. False is returned if no ACL matches.
+func (a NetworkACLs) Evaluate(ctx context.Context, action NetworkAction) (NetworkACL, bool) {
 	for _, acl := range a {
 		if acl.Matches(ctx, action) {
-			context.LoggerFrom(ctx).Debug("Network ACL matches action", "action", action, "acl", acl)
-			return acl.Action == v1.ACLAction_ACTION_ACCEPT
+			return acl, true
 		}
 	}
-	context.LoggerFrom(ctx).Debug("No network ACL matches action, denying", "action", action)
-	return false
+	return NetworkACL{}, false
 }
 
 // NetworkACL is a Network ACL.
 type NetworkACL struct {
 	*v1.NetworkACL `json:",inline"`
+	// remoteNodes are the resolved prefixes of references to nodes in
+	// federated meshes.
+	remoteNodes map[string][]netip.Prefix
+}
+
+// ResolveRemoteNode records the prefixes of a reference to a node in a federated
+// mesh, such as "node@otherdomain". Actions whose source or destination overlaps
+// the prefixes will match the reference.
+func (a *NetworkACL) ResolveRemoteNode(ref string, prefixes []netip.Prefix) {
+	if a.remoteNodes == nil {
+		a.remoteNodes = make(map[string][]netip.Prefix)
+	}
+	a.remoteNodes[ref] = prefixes
 }
 
 // DeepCopy returns a deep copy of the network ACL.
@@ -234,12 +257,14 @@ func (a NetworkACL) DestinationPrefixes() []netip.Prefix {
 // Matches checks if an action matches this ACL.
 func (acl NetworkACL) Matches(ctx context.Context, action NetworkAction) bool {
 	if action.GetSrcNode() != "" && len(acl.GetSourceNodes()) >= 0 {
-		if !containsOrWildcardMatch(acl.GetSourceNodes(), action.GetSrcNode()) {
+		if !containsOrWildcardMatch(acl.GetSourceNodes(), action.GetSrcNode()) &&
+			!acl.matchesRemoteNode(acl.GetSourceNodes(), action.SourcePrefix()) {
 			return false
 		}
 	}
 	if action.GetDstNode() != "" && len(acl.GetDestinationNodes()) >= 0 {
-		if !containsOrWildcardMatch(acl.GetDestinationNodes(), action.GetDstNode()) {
+		if !containsOrWildcardMatch(acl.GetDestinationNodes(), action.GetDstNode()) &&
+			!acl.matchesRemoteNode(acl.GetDestinationNodes(), action.DestinationPrefix()) {
 			return false
 		}
 	}
@@ -256,6 +281,22 @@ func (acl NetworkACL) Matches(ctx context.Context, action NetworkAction) bool {
 	return true
 }
 
+// matchesRemoteNode returns true if the prefix overlaps a resolved reference
+// to a node in a federated mesh among the given nodes.
+func (acl NetworkACL) matchesRemoteNode(nodes []string, prefix netip.Prefix) bool {
+	if !prefix.IsValid() || len(acl.remoteNodes) == 0 {
+		return false
+	}
+	for _, node := range nodes {
+		for _, remote := range acl.remoteNodes[node] {
+			if remote.Overlaps(prefix) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 func containsOrWildcardMatch(ss []string, s string) bool {
 	for _, v := range ss {
 		if v == "*" || v == s {
@@ -29,6 +29,51 @@ var (
 
 	// ConsensusPrefix is the prefix for all data stored related to consensus.
 	ConsensusPrefix StoragePrefix = []byte("/raft")
+
+	// SecretsPrefix is the prefix for data that is replicated between storage
+	// members but is not part of the registry shared with every member.
+	SecretsPrefix StoragePrefix = []byte("/secrets")
+
+	// AuditPrefix is the prefix for the audit log of mesh mutations. It is kept
+	// outside the registry so that entries do not wake registry watchers.
+	AuditPrefix StoragePrefix = []byte("/audit")
+
+	// KVVersionsPrefix is the prefix for the versions of keys written outside
+	// of the reserved prefixes.
+	KVVersionsPrefix = RegistryPrefix.ForString("kv-versions")
+
+	// KVRevisionKey is the key holding the revision of the last transaction
+	// applied to the key-value store.
+	KVRevisionKey = RegistryPrefix.ForString("kv-revision")
+
+	// KVOwnersPrefix is the prefix for the nodes that last wrote keys outside
+	// of the reserved prefixes.
+	KVOwnersPrefix = RegistryPrefix.ForString("kv-owners")
+
+	// NamespacesPrefix is the prefix for key-value namespaces.
+	NamespacesPrefix = RegistryPrefix.ForString("kv-namespaces")
+
+	// NamespaceUsagePrefix is the prefix for the usage counters of namespaces.
+	NamespaceUsagePrefix = RegistryPrefix.ForString("kv-namespace-usage")
+
+	// KVUsagePrefix is the prefix for the accounted size and owner of keys
+	// in namespaces.
+	KVUsagePrefix = RegistryPrefix.ForString("kv-usage")
+
+	// FederationsPrefix is the prefix for meshes federated with this one.
+	FederationsPrefix = RegistryPrefix.ForString("federations")
+
+	// MeshDNSKeysPrefix is the prefix for the keys used to sign mesh DNS zones.
+	MeshDNSKeysPrefix = SecretsPrefix.ForString("meshdns-keys")
+
+	// DNSRecordsPrefix is the prefix for custom records served by MeshDNS.
+	DNSRecordsPrefix = RegistryPrefix.ForString("dns-records")
+
+	// PresencePrefix is the prefix for the presence leases of mesh members.
+	PresencePrefix = RegistryPrefix.ForString("presence")
+
+	// AutopilotStateKey is the key holding the state of the raft autopilot.
+	AutopilotStateKey = RegistryPrefix.ForString("autopilot")
 )
 
 // String returns the string representation of the prefix.
@@ -60,6 +105,31 @@ func (p StoragePrefix) TrimFrom(key []byte) []byte {
 var ReservedPrefixes = []StoragePrefix{
 	RegistryPrefix,
 	ConsensusPrefix,
+	SecretsPrefix,
+	AuditPrefix,
+}
+
+// SnapshotExcludedPrefixes are the prefixes left out of storage snapshots.
+// Everything else is replicated state, including keys written outside the
+// reserved prefixes and the versions, owners and usage kept for them.
+var SnapshotExcludedPrefixes = []StoragePrefix{
+	ConsensusPrefix,
+}
+
+// IsSnapshotKey returns true if the given key is included in storage snapshots.
+func IsSnapshotKey(key []byte) bool {
+	for _, prefix := range SnapshotExcludedPrefixes {
+		if prefix.Contains(key) {
+			return false
+		}
+	}
+	return true
+}
+
+// Overlaps returns true if the given prefix contains keys under p, or is
+// itself under p.
+func (p StoragePrefix) Overlaps(prefix []byte) bool {
+	return p.Contains(prefix) || bytes.HasPrefix(p, prefix)
 }
 
 // IsReservedPrefix returns true if the given key is reserved.
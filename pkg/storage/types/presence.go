@@ -0,0 +1,53 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package types
+
+import "time"
+
+// Presence is the liveness lease of a mesh member. Members renew it
+// periodically and are considered offline once it expires.
+type Presence struct {
+	// NodeID is the ID of the member.
+	NodeID NodeID `json:"nodeID"`
+	// AgentVersion is the version of webmesh the member is running.
+	AgentVersion string `json:"agentVersion,omitempty"`
+	// Endpoints are the endpoints the member is advertising.
+	Endpoints []string `json:"endpoints,omitempty"`
+	// AppliedIndex is the last raft log index applied by the member when
+	// it provides storage. The autopilot uses it to measure log lag.
+	AppliedIndex uint64 `json:"appliedIndex,omitempty"`
+	// TTL is how long the lease lasts after it is renewed.
+	TTL time.Duration `json:"ttl"`
+	// LastSeen is when the lease was last renewed.
+	LastSeen time.Time `json:"lastSeen"`
+}
+
+// ExpiresAt returns when the lease expires.
+func (p Presence) ExpiresAt() time.Time {
+	return p.LastSeen.Add(p.TTL)
+}
+
+// Online returns true if the lease has not expired at the given time.
+func (p Presence) Online(now time.Time) bool {
+	return now.Before(p.ExpiresAt())
+}
+
+// Expired returns true if the lease expired more than the given grace
+// period before the given time.
+func (p Presence) Expired(now time.Time, grace time.Duration) bool {
+	return now.After(p.ExpiresAt().Add(grace))
+}
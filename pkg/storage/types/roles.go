@@ -18,6 +18,7 @@ package types
 
 import (
 	"fmt"
+	"strings"
 
 	v1 "github.com/webmeshproj/api/go/v1"
 	"google.golang.org/protobuf/encoding/protojson"
@@ -129,9 +130,21 @@ func EvalRule(rule *v1.Rule, action *v1.RBACAction) bool {
 		return true
 	}
 	for _, resourceName := range rule.GetResourceNames() {
-		if resourceName == action.GetResourceName() {
+		if MatchesResourceName(resourceName, action.GetResourceName()) {
 			return true
 		}
 	}
 	return false
 }
+
+// MatchesResourceName returns true if the resource name of a rule matches the
+// given resource name. A rule name of "*" matches all names and a rule name
+// ending in "*" matches all names beginning with the rest of it. This allows
+// prefix-scoped rules such as "/team-a/*" for pub/sub keys.
+func MatchesResourceName(ruleName, name string) bool {
+	if ruleName == name || ruleName == "*" {
+		return true
+	}
+	prefix, ok := strings.CutSuffix(ruleName, "*")
+	return ok && strings.HasPrefix(name, prefix)
+}
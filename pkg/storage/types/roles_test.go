@@ -212,6 +212,31 @@ func TestRolesListEval(t *testing.T) {
 				return a
 			}(),
 		},
+		{
+			name: "prefix resource name pubsub roles list",
+			roles: RolesList{
+				{
+					Role: &v1.Role{
+						Rules: []*v1.Rule{
+							{
+								Verbs:         []v1.RuleVerb{v1.RuleVerb_VERB_GET},
+								ResourceNames: []string{"/team-a/*"},
+								Resources:     []v1.RuleResource{v1.RuleResource_RESOURCE_PUBSUB},
+							},
+						},
+					},
+				},
+			},
+			actions: map[*v1.RBACAction]bool{
+				{Resource: v1.RuleResource_RESOURCE_PUBSUB, Verb: v1.RuleVerb_VERB_GET, ResourceName: "/team-a/"}:        true,
+				{Resource: v1.RuleResource_RESOURCE_PUBSUB, Verb: v1.RuleVerb_VERB_GET, ResourceName: "/team-a/key"}:     true,
+				{Resource: v1.RuleResource_RESOURCE_PUBSUB, Verb: v1.RuleVerb_VERB_GET, ResourceName: "/team-a/sub/key"}: true,
+				{Resource: v1.RuleResource_RESOURCE_PUBSUB, Verb: v1.RuleVerb_VERB_GET, ResourceName: "/team-a"}:         false,
+				{Resource: v1.RuleResource_RESOURCE_PUBSUB, Verb: v1.RuleVerb_VERB_GET, ResourceName: "/team-ab/key"}:    false,
+				{Resource: v1.RuleResource_RESOURCE_PUBSUB, Verb: v1.RuleVerb_VERB_PUT, ResourceName: "/team-a/key"}:     false,
+				{Resource: v1.RuleResource_RESOURCE_ROLES, Verb: v1.RuleVerb_VERB_GET, ResourceName: "/team-a/key"}:      false,
+			},
+		},
 	}
 
 	for _, tt := range tc {
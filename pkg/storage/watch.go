@@ -0,0 +1,152 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	// WatchRevisionMeta is the metadata key for the revision to resume a
+	// Subscribe or SubscribePeers request from. Subscribe requests that set it
+	// receive JSON encoded WatchEvents as the values of their events.
+	WatchRevisionMeta = "x-webmesh-watch-revision"
+	// RevisionMeta is the metadata key for the revision a Subscribe or
+	// SubscribePeers stream started at, sent in the header of the response.
+	RevisionMeta = "x-webmesh-revision"
+	// WatchSnapshotMeta is the metadata key for requesting the current values
+	// of a prefix before the changes of a revisioned Subscribe request.
+	WatchSnapshotMeta = "x-webmesh-watch-snapshot"
+)
+
+// WatchStorage is implemented by MeshStorage that keeps a history of
+// revisioned changes that watches can be resumed from.
+type WatchStorage interface {
+	// Revision returns the revision of the latest change known to the storage.
+	Revision(ctx context.Context) (uint64, error)
+	// Watch calls fn for every change to a key with the given prefix made after
+	// the given revision. A revision of zero watches changes from now on. If the
+	// revision is no longer in history, errors.ErrCompacted is returned. The
+	// returned channel receives errors.ErrCompacted if the watcher falls too
+	// far behind the history, and is closed when the watch ends. Keys expiring
+	// by TTL do not produce events.
+	Watch(ctx context.Context, prefix []byte, revision uint64, fn WatchFunc) (<-chan error, error)
+}
+
+// WatchFunc is the function signature for receiving watch events.
+type WatchFunc func(WatchEvent)
+
+// EventType is the type of a watch event.
+type EventType string
+
+const (
+	// EventPut is sent when a key is created or updated.
+	EventPut EventType = "PUT"
+	// EventDelete is sent when a key is removed.
+	EventDelete EventType = "DELETE"
+	// EventSynced is sent once after the current values of a watch started
+	// with a snapshot. Its revision is the revision of the snapshot.
+	EventSynced EventType = "SYNCED"
+)
+
+// WatchEvent is a revisioned change to a key.
+type WatchEvent struct {
+	// Type is the type of change.
+	Type EventType `json:"type"`
+	// Key is the key that changed.
+	Key []byte `json:"key"`
+	// Value is the new value of the key for EventPut.
+	Value []byte `json:"value,omitempty"`
+	// Revision is the revision of the change. Changes made atomically share
+	// the same revision.
+	Revision uint64 `json:"revision"`
+}
+
+// MarshalWatchEvent encodes a watch event for transport.
+func MarshalWatchEvent(ev WatchEvent) ([]byte, error) {
+	return json.Marshal(ev)
+}
+
+// UnmarshalWatchEvent decodes a watch event encoded with MarshalWatchEvent.
+func UnmarshalWatchEvent(data []byte) (WatchEvent, error) {
+	var ev WatchEvent
+	if err := json.Unmarshal(data, &ev); err != nil {
+		return ev, fmt.Errorf("decode watch event: %w", err)
+	}
+	return ev, nil
+}
+
+// ContextWithWatchRevision returns an outgoing context that requests a
+// revisioned watch resumed from the given revision.
+func ContextWithWatchRevision(ctx context.Context, revision uint64) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, WatchRevisionMeta, strconv.FormatUint(revision, 10))
+}
+
+// ContextWithWatchSnapshot returns an outgoing context that requests the
+// current values of the prefix of a revisioned watch before its changes.
+func ContextWithWatchSnapshot(ctx context.Context) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, WatchSnapshotMeta, "true")
+}
+
+// WatchSnapshotFromContext returns true if the incoming request asked for
+// a snapshot with its watch.
+func WatchSnapshotFromContext(ctx context.Context) bool {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return false
+	}
+	vals := md.Get(WatchSnapshotMeta)
+	if len(vals) == 0 {
+		return false
+	}
+	snapshot, _ := strconv.ParseBool(vals[0])
+	return snapshot
+}
+
+// WatchRevisionFromContext returns the revision sent with an incoming request.
+// If no revision was sent then false is returned.
+func WatchRevisionFromContext(ctx context.Context) (uint64, bool, error) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return 0, false, nil
+	}
+	vals := md.Get(WatchRevisionMeta)
+	if len(vals) == 0 || vals[0] == "" {
+		return 0, false, nil
+	}
+	rev, err := strconv.ParseUint(vals[0], 10, 64)
+	if err != nil {
+		return 0, true, fmt.Errorf("invalid watch revision %q: %w", vals[0], err)
+	}
+	return rev, true, nil
+}
+
+// RevisionFromHeader returns the revision sent in the header of a Subscribe or
+// SubscribePeers response, or zero if it was not sent.
+func RevisionFromHeader(md metadata.MD) uint64 {
+	vals := md.Get(RevisionMeta)
+	if len(vals) == 0 {
+		return 0
+	}
+	rev, _ := strconv.ParseUint(vals[0], 10, 64)
+	return rev
+}